@@ -3,25 +3,141 @@ use clap_complete::{
     generate,
     shells::{Bash, Zsh},
 };
-use std::fs::File;
 use std::path::PathBuf;
 
 #[path = "src/cli.rs"]
 mod cli;
 
+/// Registration at the end of the generated bash script.
+const BASH_REGISTER: &str = r#"if [[ "${BASH_VERSINFO[0]}" -eq 4 && "${BASH_VERSINFO[1]}" -ge 4 || "${BASH_VERSINFO[0]}" -gt 4 ]]; then
+    complete -F _gralph -o nosort -o bashdefault -o default gralph
+else
+    complete -F _gralph -o bashdefault -o default gralph
+fi
+"#;
+
+/// Completes live values (sessions, backends, config keys) from
+/// `gralph __complete` and leaves everything else to the generated `_gralph`.
+const BASH_DYNAMIC: &str = r#"_gralph_dynamic() {
+    local cur prev sub="" subsub="" kind="" i
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case "${COMP_WORDS[i]}" in
+            -*) ;;
+            *)
+                if [[ -z "${sub}" ]]; then
+                    sub="${COMP_WORDS[i]}"
+                elif [[ -z "${subsub}" ]]; then
+                    subsub="${COMP_WORDS[i]}"
+                fi
+                ;;
+        esac
+    done
+    case "${prev}" in
+        --backend|-b|--review-backend) kind="backends" ;;
+        --session) [[ "${sub}" == history ]] && kind="sessions" ;;
+        --name|-n) [[ "${sub}" == watch ]] && kind="sessions" ;;
+    esac
+    if [[ -z "${kind}" && ${cur} != -* ]]; then
+        case "${sub}" in
+            stop|pause|unpause|logs|resume|diff|attach)
+                [[ "${prev}" == "${sub}" ]] && kind="sessions"
+                ;;
+            config)
+                [[ ( "${prev}" == get || "${prev}" == set ) && "${subsub}" == "${prev}" ]] && kind="config-keys"
+                ;;
+        esac
+    fi
+    if [[ -n "${kind}" ]]; then
+        COMPREPLY=( $(compgen -W "$(gralph __complete "${kind}" 2>/dev/null)" -- "${cur}") )
+        return 0
+    fi
+    _gralph "$@"
+}
+
+if [[ "${BASH_VERSINFO[0]}" -eq 4 && "${BASH_VERSINFO[1]}" -ge 4 || "${BASH_VERSINFO[0]}" -gt 4 ]]; then
+    complete -F _gralph_dynamic -o nosort -o bashdefault -o default gralph
+else
+    complete -F _gralph_dynamic -o bashdefault -o default gralph
+fi
+"#;
+
+/// Registration at the end of the generated zsh script.
+const ZSH_REGISTER: &str = r#"if [ "$funcstack[1]" = "_gralph" ]; then
+    _gralph "$@"
+else
+    compdef _gralph gralph
+fi
+"#;
+
+const ZSH_DYNAMIC: &str = r#"_gralph_dynamic() {
+    local kind="" sub="" subsub="" prev="${words[CURRENT-1]}" word
+    for word in "${(@)words[2,CURRENT-1]}"; do
+        [[ "$word" == -* ]] && continue
+        if [[ -z "$sub" ]]; then
+            sub="$word"
+        elif [[ -z "$subsub" ]]; then
+            subsub="$word"
+        fi
+    done
+    case "$prev" in
+        --backend|-b|--review-backend) kind=backends ;;
+        --session) [[ "$sub" == history ]] && kind=sessions ;;
+        --name|-n) [[ "$sub" == watch ]] && kind=sessions ;;
+    esac
+    if [[ -z "$kind" && "${words[CURRENT]}" != -* ]]; then
+        case "$sub" in
+            stop|pause|unpause|logs|resume|diff|attach)
+                [[ "$prev" == "$sub" ]] && kind=sessions
+                ;;
+            config)
+                [[ ( "$prev" == get || "$prev" == set ) && "$subsub" == "$prev" ]] && kind=config-keys
+                ;;
+        esac
+    fi
+    if [[ -n "$kind" ]]; then
+        local -a values
+        values=(${(f)"$(gralph __complete "$kind" 2>/dev/null)"})
+        compadd -a values
+        return
+    fi
+    _gralph "$@"
+}
+
+compdef _gralph_dynamic gralph
+if [ "$funcstack[1]" = "_gralph" ]; then
+    _gralph_dynamic "$@"
+fi
+"#;
+
 fn main() {
     println!("cargo:rerun-if-changed=src/cli.rs");
+    println!("cargo:rerun-if-changed=build.rs");
     let manifest_dir = PathBuf::from(std::env::var("CARGO_MANIFEST_DIR").unwrap());
     let completions_dir = manifest_dir.join("completions");
     let _ = std::fs::create_dir_all(&completions_dir);
 
     let mut cmd = cli::Cli::command();
-    if let Ok(mut file) = File::create(completions_dir.join("gralph.bash")) {
-        let _ = generate(Bash, &mut cmd, "gralph", &mut file);
-    }
+    let mut script = Vec::new();
+    generate(Bash, &mut cmd, "gralph", &mut script);
+    let script = with_dynamic_values(script, BASH_REGISTER, BASH_DYNAMIC);
+    let _ = std::fs::write(completions_dir.join("gralph.bash"), script);
 
     let mut cmd = cli::Cli::command();
-    if let Ok(mut file) = File::create(completions_dir.join("gralph.zsh")) {
-        let _ = generate(Zsh, &mut cmd, "gralph", &mut file);
+    let mut script = Vec::new();
+    generate(Zsh, &mut cmd, "gralph", &mut script);
+    let script = with_dynamic_values(script, ZSH_REGISTER, ZSH_DYNAMIC);
+    let _ = std::fs::write(completions_dir.join("gralph.zsh"), script);
+}
+
+/// Swaps the generated registration for one that goes through the dynamic
+/// wrapper. Leaves the script static if clap changes its output.
+fn with_dynamic_values(script: Vec<u8>, register: &str, dynamic: &str) -> String {
+    let script = String::from_utf8_lossy(&script).into_owned();
+    if script.ends_with(register) {
+        format!("{}{}", &script[..script.len() - register.len()], dynamic)
+    } else {
+        script
     }
 }
@@ -1372,8 +1372,46 @@ _gralph() {
     esac
 }
 
+_gralph_dynamic() {
+    local cur prev sub="" subsub="" kind="" i
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case "${COMP_WORDS[i]}" in
+            -*) ;;
+            *)
+                if [[ -z "${sub}" ]]; then
+                    sub="${COMP_WORDS[i]}"
+                elif [[ -z "${subsub}" ]]; then
+                    subsub="${COMP_WORDS[i]}"
+                fi
+                ;;
+        esac
+    done
+    case "${prev}" in
+        --backend|-b|--review-backend) kind="backends" ;;
+        --session) [[ "${sub}" == history ]] && kind="sessions" ;;
+        --name|-n) [[ "${sub}" == watch ]] && kind="sessions" ;;
+    esac
+    if [[ -z "${kind}" && ${cur} != -* ]]; then
+        case "${sub}" in
+            stop|pause|unpause|logs|resume|diff|attach)
+                [[ "${prev}" == "${sub}" ]] && kind="sessions"
+                ;;
+            config)
+                [[ ( "${prev}" == get || "${prev}" == set ) && "${subsub}" == "${prev}" ]] && kind="config-keys"
+                ;;
+        esac
+    fi
+    if [[ -n "${kind}" ]]; then
+        COMPREPLY=( $(compgen -W "$(gralph __complete "${kind}" 2>/dev/null)" -- "${cur}") )
+        return 0
+    fi
+    _gralph "$@"
+}
+
 if [[ "${BASH_VERSINFO[0]}" -eq 4 && "${BASH_VERSINFO[1]}" -ge 4 || "${BASH_VERSINFO[0]}" -gt 4 ]]; then
-    complete -F _gralph -o nosort -o bashdefault -o default gralph
+    complete -F _gralph_dynamic -o nosort -o bashdefault -o default gralph
 else
-    complete -F _gralph -o bashdefault -o default gralph
+    complete -F _gralph_dynamic -o bashdefault -o default gralph
 fi
@@ -0,0 +1,87 @@
+//! Stable entry points for programs that embed gralph instead of running the
+//! CLI.
+//!
+//! Everything a loop needs is passed in: the backend, the [`Config`] (load
+//! one with [`Config::load_with_profile`] or build it with
+//! [`Config::from_yaml`]), and a [`CancelToken`] that stops it from another
+//! thread. Nothing here reads `--profile`, installs signal handlers, or
+//! writes session state; [`StateStore`] is there for callers that want to.
+//! The other public modules serve the CLI and may change between releases.
+
+use crate::core;
+use crate::prd;
+use crate::shutdown;
+use std::path::Path;
+
+pub use crate::backend::{Backend, BackendError, Usage, backend_from_name};
+pub use crate::config::{Config, ConfigError};
+pub use crate::core::{CoreError, LoopBudget, LoopOutcome, LoopStatus};
+pub use crate::prd::{PrdDocument, PrdError, PrdIssue, PrdSeverity, PrdTask};
+pub use crate::shutdown::CancelToken;
+pub use crate::state::{StateError, StateStore};
+
+/// Loop settings that `gralph start` takes as flags. Unset fields use the
+/// config, then the CLI's defaults.
+#[derive(Debug, Clone, Default)]
+pub struct LoopOptions {
+    /// Task file, comma list, or glob relative to the project (`PRD.md`).
+    pub task_file: Option<String>,
+    pub max_iterations: Option<u32>,
+    pub completion_marker: Option<String>,
+    pub model: Option<String>,
+    pub variant: Option<String>,
+    /// Names the log file, `.gralph/<session_name>.log`.
+    pub session_name: Option<String>,
+    /// Prompt template text, in place of the project's or the default.
+    pub prompt_template: Option<String>,
+    pub budget: LoopBudget,
+}
+
+/// Runs a loop in `project_dir` on the calling thread until the tasks are
+/// done, a limit is hit, or `cancel` is cancelled; cancelling stops the
+/// backend and returns [`LoopStatus::Stopped`]. `on_status` gets the
+/// iteration, status, and remaining task count as the loop moves on.
+pub fn run_loop(
+    backend: &dyn Backend,
+    project_dir: &Path,
+    config: Option<&Config>,
+    options: &LoopOptions,
+    cancel: &CancelToken,
+    mut on_status: Option<&mut dyn FnMut(u32, LoopStatus, usize)>,
+) -> Result<LoopOutcome, CoreError> {
+    let mut callback = |_: Option<&str>, iteration: u32, status: LoopStatus, remaining: usize| {
+        if let Some(on_status) = on_status.as_deref_mut() {
+            on_status(iteration, status, remaining);
+        }
+    };
+    shutdown::with_cancel(cancel, || {
+        core::run_loop_with_clock(
+            backend,
+            project_dir,
+            options.task_file.as_deref(),
+            options.max_iterations,
+            options.completion_marker.as_deref(),
+            options.model.as_deref(),
+            options.variant.as_deref(),
+            options.session_name.as_deref(),
+            options.prompt_template.as_deref(),
+            config,
+            Some(&mut callback),
+            None,
+            options.budget,
+            &core::SystemClock,
+        )
+    })
+}
+
+/// Parses a PRD into its title and task blocks.
+pub fn parse_prd(task_file: &Path) -> Result<PrdDocument, PrdError> {
+    prd::prd_parse_file(task_file)
+}
+
+/// Checks a PRD as `gralph prd check` does and returns every issue,
+/// warnings included. Context Bundle paths resolve against the repo
+/// holding the file.
+pub fn check_prd(task_file: &Path) -> Vec<PrdIssue> {
+    prd::prd_lint_file(task_file, false, None)
+}
@@ -1,26 +1,43 @@
-use crate::backend::{backend_from_name, command_in_path};
+use crate::backend::cassette::Cassette;
+use crate::backend::{BackendCapabilities, backend_from_name, command_in_path};
 use crate::cli::{
-    self, ASCII_BANNER, Cli, Command, ConfigArgs, ConfigCommand, DoctorArgs, ServerArgs,
-    VerifierArgs,
+    self, ASCII_BANNER, BackendsArgs, Cli, Command, ConfigArgs, ConfigCommand, DoctorArgs,
+    ServerArgs, StateArgs, StateCommand, VerifierArgs,
 };
-use crate::config::Config;
+use crate::config::{self, Config};
 use crate::core;
+use crate::mcp;
 use crate::notify;
+use crate::offline;
 use crate::server::{self, ServerConfig};
-use crate::state::StateStore;
+use crate::state::{self, StateDriver, StateStore};
 use crate::update;
 use crate::verifier;
 use crate::version;
+use crate::workspace;
 use std::env;
 use std::ffi::OsStr;
 use std::fmt::Display;
 use std::fs;
-use std::io::{self, Read, Seek};
+use std::io::{self, IsTerminal, Read, Seek, Write};
 use std::path::{Path, PathBuf};
 use std::process::{Command as ProcCommand, ExitCode};
 
+mod bundle;
+mod clean;
+mod completion;
+mod daemon;
+mod diff;
 mod loop_session;
+mod notify_queue;
+mod picker;
+mod progress;
+pub(crate) use loop_session::resume_session;
 mod prd_init;
+mod queue;
+mod service;
+mod tmux;
+mod watch;
 pub(crate) mod worktree;
 
 use prd_init::{cmd_init, cmd_prd};
@@ -28,10 +45,13 @@ use prd_init::{cmd_init, cmd_prd};
 #[cfg(test)]
 use prd_init::{
     ARCHITECTURE_TEMPLATE, CHANGELOG_TEMPLATE, DECISIONS_TEMPLATE, DEFAULT_PRD_TEMPLATE,
-    PROCESS_TEMPLATE, RISK_REGISTER_TEMPLATE, add_context_entry, build_context_file_list,
-    default_context_files, format_display_path, generic_markdown_template, init_template_for_path,
-    invalid_prd_path, is_markdown_path, read_prd_template_with_manifest, read_readme_context_files,
-    resolve_init_context_files, resolve_prd_output, write_allowed_context, write_atomic,
+    PROCESS_TEMPLATE, RISK_REGISTER_TEMPLATE, add_context_entry, add_prd_template,
+    append_missing_lines, build_context_file_list, critique_found_problems, critique_prompt,
+    default_context_files, estimated_prd_stage, format_display_path, generic_markdown_template,
+    init_template_for_path, invalid_prd_path, is_markdown_path, list_prd_templates,
+    read_named_prd_template, read_prd_template_with_manifest, read_readme_context_files,
+    resolve_init_context_files, resolve_prd_output, revision_prompt, write_allowed_context,
+    write_atomic,
 };
 
 pub(crate) trait FileSystem: Send + Sync {
@@ -135,6 +155,9 @@ pub struct Deps {
     process: Box<dyn ProcessRunner>,
     clock: Box<dyn core::Clock>,
     notifier: Box<dyn notify::Notifier>,
+    state_driver: StateDriver,
+    offline: bool,
+    cassette: Option<Cassette>,
 }
 
 impl Default for Deps {
@@ -151,9 +174,38 @@ impl Deps {
             process: Box::new(RealProcessRunner),
             clock: Box::new(core::SystemClock),
             notifier: Box::new(notify::RealNotifier),
+            state_driver: StateDriver::default(),
+            offline: false,
+            cassette: None,
         }
     }
 
+    /// Keeps sessions in the store `driver` names instead of `state.json`.
+    pub fn with_state_driver(mut self, driver: StateDriver) -> Self {
+        self.state_driver = driver;
+        self
+    }
+
+    /// Turns off network calls (`--offline` or `GRALPH_OFFLINE`).
+    pub fn with_offline(mut self, offline: bool) -> Self {
+        self.offline = offline;
+        self
+    }
+
+    pub(crate) fn offline(&self) -> bool {
+        self.offline
+    }
+
+    /// Records or replays backend iterations (`--record` or `--replay`).
+    pub fn with_cassette(mut self, cassette: Option<Cassette>) -> Self {
+        self.cassette = cassette;
+        self
+    }
+
+    pub(crate) fn cassette(&self) -> Option<&Cassette> {
+        self.cassette.as_ref()
+    }
+
     pub(crate) fn worktree(&self) -> &worktree::Worktree {
         &self.worktree
     }
@@ -175,16 +227,23 @@ impl Deps {
     }
 
     pub fn state_store(&self) -> StateStore {
-        StateStore::new_from_env()
+        StateStore::with_driver(self.state_driver)
     }
 }
 
+/// The `state.driver` from the global config, resolved once at startup so
+/// every state store gralph opens uses the same driver.
+pub(crate) fn configured_state_driver() -> Result<StateDriver, CliError> {
+    let config = Config::load(None).map_err(|err| CliError::Message(err.to_string()))?;
+    StateDriver::from_config(&config).map_err(|err| CliError::Message(err.to_string()))
+}
+
 pub fn run(cli: Cli, deps: &Deps) -> Result<(), CliError> {
     let Some(command) = cli.command else {
         cmd_intro()?;
         return Ok(());
     };
-    dispatch(command, deps)
+    dispatch(command, cli.json, deps)
 }
 
 pub fn exit_code_for(result: Result<(), CliError>) -> ExitCode {
@@ -197,26 +256,43 @@ pub fn exit_code_for(result: Result<(), CliError>) -> ExitCode {
     }
 }
 
-fn dispatch(command: Command, deps: &Deps) -> Result<(), CliError> {
+fn dispatch(command: Command, json: bool, deps: &Deps) -> Result<(), CliError> {
     match command {
         Command::Start(args) => loop_session::cmd_start(args, deps),
         Command::Step(args) => loop_session::cmd_step(args, deps),
+        Command::RunTask(args) => loop_session::cmd_run_task(args, deps),
         Command::RunLoop(args) => loop_session::cmd_run_loop(args, deps),
+        Command::Complete(args) => completion::cmd_complete(args, deps),
         Command::Stop(args) => loop_session::cmd_stop(args, deps),
-        Command::Status(args) => loop_session::cmd_status(args, deps),
+        Command::Pause(args) => loop_session::cmd_pause(args, deps),
+        Command::Unpause(args) => loop_session::cmd_unpause(args, deps),
+        Command::Status(args) => loop_session::cmd_status(args, json, deps),
+        Command::Watch(args) => watch::cmd_watch(args, deps),
         Command::Cleanup(args) => loop_session::cmd_cleanup(args, deps),
+        Command::Clean(args) => clean::cmd_clean(args, deps),
         Command::Doctor(args) => cmd_doctor(args, deps),
-        Command::Logs(args) => loop_session::cmd_logs(args, deps),
+        Command::Logs(args) => loop_session::cmd_logs(args, json, deps),
+        Command::Attach(args) => tmux::cmd_attach(args, deps),
+        Command::History(args) => loop_session::cmd_history(args, json, deps),
+        Command::Diff(args) => diff::cmd_diff(args, deps),
+        Command::Export(args) => bundle::cmd_export(args, deps),
+        Command::Import(args) => bundle::cmd_import(args, deps),
         Command::Resume(args) => loop_session::cmd_resume(args, deps),
         Command::Init(args) => cmd_init(args),
-        Command::Prd(args) => cmd_prd(args),
+        Command::Prd(args) => cmd_prd(args, json, deps),
         Command::Worktree(args) => deps.worktree().cmd_worktree(args),
-        Command::Backends => cmd_backends(),
-        Command::Config(args) => cmd_config(args),
+        Command::Backends(args) => cmd_backends(args, json),
+        Command::Config(args) => cmd_config(args, json),
         Command::Verifier(args) => cmd_verifier(args),
-        Command::Server(args) => cmd_server(args),
+        Command::Server(args) => cmd_server(args, deps),
+        Command::Mcp => cmd_mcp(deps),
+        Command::Daemon(args) => daemon::cmd_daemon(args, json, deps),
+        Command::Service(args) => service::cmd_service(args),
+        Command::Queue(args) => queue::cmd_queue(args, json, deps),
+        Command::Notify(args) => notify_queue::cmd_notify(args, json, deps),
+        Command::State(args) => cmd_state(args, json, deps),
         Command::Version => cmd_version(),
-        Command::Update => cmd_update(),
+        Command::Update => cmd_update(deps),
     }
 }
 
@@ -270,7 +346,10 @@ fn cmd_version() -> Result<(), CliError> {
     Ok(())
 }
 
-fn cmd_update() -> Result<(), CliError> {
+fn cmd_update(deps: &Deps) -> Result<(), CliError> {
+    if deps.offline() {
+        return Err(CliError::Message(offline::refusal("gralph update")));
+    }
     let outcome = update::install_release().map_err(|err| CliError::Message(err.to_string()))?;
     println!(
         "Installed gralph v{} to {}",
@@ -298,7 +377,7 @@ fn cmd_update() -> Result<(), CliError> {
     Ok(())
 }
 
-fn cmd_backends() -> Result<(), CliError> {
+fn cmd_backends(args: BackendsArgs, json: bool) -> Result<(), CliError> {
     let backends = vec![
         (
             "claude",
@@ -320,13 +399,73 @@ fn cmd_backends() -> Result<(), CliError> {
             backend_from_name("codex").map_err(CliError::Message)?,
             "https://platform.openai.com/docs",
         ),
+        (
+            "ollama",
+            backend_from_name("ollama").map_err(CliError::Message)?,
+            "https://ollama.com/download (server must be running)",
+        ),
+        (
+            "openai",
+            backend_from_name("openai").map_err(CliError::Message)?,
+            "set openai.base_url and openai.api_key (any OpenAI-compatible API)",
+        ),
     ];
+    // The auth probes run in the directory the command was run from.
+    let working_dir = env::current_dir().unwrap_or_else(|_| PathBuf::from("."));
+
+    if json {
+        let entries = backends
+            .iter()
+            .map(|(name, backend, hint)| {
+                let installed = backend.check_installed();
+                let capabilities = backend.capabilities();
+                let mut entry = serde_json::json!({
+                    "name": name,
+                    "installed": installed,
+                    "capabilities": {
+                        "streaming": capabilities.streaming,
+                        "json_output": capabilities.json_output,
+                        "max_context_tokens": capabilities.max_context_tokens,
+                        "variants": capabilities.variants,
+                    },
+                    "install_hint": hint,
+                });
+                if args.models {
+                    entry["models"] = serde_json::json!(if installed {
+                        backend.get_models()
+                    } else {
+                        Vec::new()
+                    });
+                }
+                if args.verbose && installed {
+                    entry["auth"] = match backend.check_auth(None, &working_dir) {
+                        Ok(()) => serde_json::json!({ "ok": true }),
+                        Err(err) => serde_json::json!({ "ok": false, "error": err }),
+                    };
+                }
+                entry
+            })
+            .collect::<Vec<_>>();
+        return print_json(&serde_json::json!({ "backends": entries }));
+    }
 
     println!("Available AI backends:\n");
     for (name, backend, hint) in backends {
         if backend.check_installed() {
             println!("  {} (installed)", name);
-            println!("      Models: {}", backend.get_models().join(", "));
+            println!(
+                "      Capabilities: {}",
+                format_capabilities(&backend.capabilities())
+            );
+            if args.models {
+                println!("      Models: {}", backend.get_models().join(", "));
+            }
+            if args.verbose {
+                match backend.check_auth(None, &working_dir) {
+                    Ok(()) => println!("      Auth: ok"),
+                    Err(err) => println!("      Auth: failed ({})", err),
+                }
+            }
         } else {
             println!("  {} (not installed)", name);
             println!("      Install: {}", hint);
@@ -336,6 +475,33 @@ fn cmd_backends() -> Result<(), CliError> {
     Ok(())
 }
 
+fn format_capabilities(capabilities: &BackendCapabilities) -> String {
+    let mut parts = Vec::new();
+    if capabilities.streaming {
+        parts.push("streaming".to_string());
+    }
+    if capabilities.json_output {
+        parts.push("json output".to_string());
+    }
+    if let Some(tokens) = capabilities.max_context_tokens {
+        parts.push(if tokens >= 1_000_000 && tokens % 1_000_000 == 0 {
+            format!("{}M context", tokens / 1_000_000)
+        } else if tokens >= 1_000 {
+            format!("{}k context", tokens / 1_000)
+        } else {
+            format!("{} context", tokens)
+        });
+    }
+    if !capabilities.variants.is_empty() {
+        parts.push(format!("variants {}", capabilities.variants.join("/")));
+    }
+    if parts.is_empty() {
+        "none reported".to_string()
+    } else {
+        parts.join(", ")
+    }
+}
+
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 enum DoctorStatus {
     Ok,
@@ -406,6 +572,10 @@ fn cmd_doctor(args: DoctorArgs, deps: &Deps) -> Result<(), CliError> {
         ("opencode", "npm install -g opencode-ai"),
         ("gemini", "npm install -g @google/gemini-cli"),
         ("codex", "npm install -g @openai/codex"),
+        (
+            "ollama",
+            "https://ollama.com/download and run `ollama serve`",
+        ),
     ];
 
     let mut required_backend = None;
@@ -414,7 +584,10 @@ fn cmd_doctor(args: DoctorArgs, deps: &Deps) -> Result<(), CliError> {
             label: "backend default".to_string(),
             status: DoctorStatus::Fail,
             detail: "defaults.backend is empty".to_string(),
-            hint: Some("Set defaults.backend to claude, opencode, gemini, or codex".to_string()),
+            hint: Some(
+                "Set defaults.backend to claude, opencode, gemini, codex, ollama, or openai"
+                    .to_string(),
+            ),
         });
     } else if backend_choices
         .iter()
@@ -424,7 +597,10 @@ fn cmd_doctor(args: DoctorArgs, deps: &Deps) -> Result<(), CliError> {
             label: "backend default".to_string(),
             status: DoctorStatus::Fail,
             detail: format!("unknown backend '{}'", default_backend),
-            hint: Some("Set defaults.backend to claude, opencode, gemini, or codex".to_string()),
+            hint: Some(
+                "Set defaults.backend to claude, opencode, gemini, codex, ollama, or openai"
+                    .to_string(),
+            ),
         });
     } else {
         required_backend = Some(default_backend.clone());
@@ -671,11 +847,12 @@ fn check_git_clean(dir: &Path) -> Result<DoctorCheck, CliError> {
     }
 }
 
-fn cmd_config(args: ConfigArgs) -> Result<(), CliError> {
+fn cmd_config(args: ConfigArgs, json: bool) -> Result<(), CliError> {
     match args.command.unwrap_or(ConfigCommand::List) {
         ConfigCommand::Get(args) => cmd_config_get(args),
         ConfigCommand::Set(args) => cmd_config_set(args),
-        ConfigCommand::List => cmd_config_list(),
+        ConfigCommand::Edit(args) => cmd_config_edit(args),
+        ConfigCommand::List => cmd_config_list(json),
     }
 }
 
@@ -696,8 +873,7 @@ fn cmd_config_get(args: cli::ConfigGetArgs) -> Result<(), CliError> {
 }
 
 fn cmd_config_set(args: cli::ConfigSetArgs) -> Result<(), CliError> {
-    let current_dir = env::current_dir().unwrap_or_else(|_| PathBuf::from("."));
-    let config_path = project_config_path(&current_dir);
+    let config_path = config_file_path(&args.file);
     let mut root = read_yaml_or_empty(&config_path)?;
     set_yaml_value(&mut root, &args.key, &args.value);
     let rendered = serde_yaml::to_string(&root)
@@ -706,15 +882,130 @@ fn cmd_config_set(args: cli::ConfigSetArgs) -> Result<(), CliError> {
         fs::create_dir_all(parent).map_err(CliError::Io)?;
     }
     fs::write(&config_path, rendered).map_err(CliError::Io)?;
-    println!("Updated config: {}", args.key);
+    println!("Updated config: {} ({})", args.key, config_path.display());
     Ok(())
 }
 
-fn cmd_config_list() -> Result<(), CliError> {
+/// Opens the chosen config file in `$VISUAL` or `$EDITOR` and checks it
+/// parses once the editor exits. An invalid file is reopened on a terminal;
+/// otherwise the previous contents are put back.
+fn cmd_config_edit(args: cli::ConfigEditArgs) -> Result<(), CliError> {
+    let editor = editor_command()?;
+    edit_config_file(&config_file_path(&args.file), &editor, &mut || {
+        Ok(io::stdin().is_terminal() && confirm("Edit again? [Y/n] ")?)
+    })
+}
+
+fn edit_config_file(
+    config_path: &Path,
+    editor: &[String],
+    edit_again: &mut dyn FnMut() -> Result<bool, CliError>,
+) -> Result<(), CliError> {
+    let original = if config_path.is_file() {
+        Some(fs::read_to_string(&config_path).map_err(CliError::Io)?)
+    } else {
+        None
+    };
+    if let Some(parent) = config_path.parent() {
+        fs::create_dir_all(parent).map_err(CliError::Io)?;
+    }
+    loop {
+        let status = ProcCommand::new(&editor[0])
+            .args(&editor[1..])
+            .arg(config_path)
+            .status()
+            .map_err(|err| {
+                CliError::Message(format!("Failed to start editor {}: {}", editor[0], err))
+            })?;
+        if !status.success() {
+            return Err(CliError::Message(format!(
+                "Editor exited with {}; config left as it was saved",
+                status
+            )));
+        }
+        let Err(message) = validate_config_file(config_path) else {
+            println!("Saved config: {}", config_path.display());
+            return Ok(());
+        };
+        eprintln!("Invalid config: {}", message);
+        if edit_again()? {
+            continue;
+        }
+        match &original {
+            Some(contents) => fs::write(config_path, contents).map_err(CliError::Io)?,
+            None => fs::remove_file(config_path).map_err(CliError::Io)?,
+        }
+        return Err(CliError::Message(format!(
+            "Config not saved, {} restored: {}",
+            config_path.display(),
+            message
+        )));
+    }
+}
+
+/// The file `config set` and `config edit` write: the project's
+/// `.gralph.yaml` in the current directory unless `--global` or `--default`.
+fn config_file_path(file: &cli::ConfigFileArgs) -> PathBuf {
+    if file.global {
+        config::global_config_path()
+    } else if file.default {
+        config::default_config_path()
+    } else {
+        project_config_path(&env::current_dir().unwrap_or_else(|_| PathBuf::from(".")))
+    }
+}
+
+/// `$VISUAL`, then `$EDITOR`, then `vi`, split like a shell command so
+/// values such as `code --wait` work.
+fn editor_command() -> Result<Vec<String>, CliError> {
+    let editor = ["VISUAL", "EDITOR"]
+        .iter()
+        .filter_map(|key| env::var(key).ok())
+        .find(|value| !value.trim().is_empty())
+        .unwrap_or_else(|| "vi".to_string());
+    let parts = shell_words::split(&editor)
+        .map_err(|err| CliError::Message(format!("Invalid editor command {}: {}", editor, err)))?;
+    if parts.is_empty() {
+        return Err(CliError::Message("Editor command is empty".to_string()));
+    }
+    Ok(parts)
+}
+
+/// A config file must parse as YAML with a mapping (or nothing) at the top.
+/// A file the editor never created is fine.
+fn validate_config_file(path: &Path) -> Result<(), String> {
+    match read_yaml_or_empty(path) {
+        Ok(serde_yaml::Value::Mapping(_) | serde_yaml::Value::Null) => Ok(()),
+        Ok(_) => Err("top level must be a mapping of sections".to_string()),
+        Err(CliError::Message(message)) => Err(message),
+        Err(CliError::Io(err)) => Err(err.to_string()),
+    }
+}
+
+fn confirm(question: &str) -> Result<bool, CliError> {
+    print!("{}", question);
+    io::stdout().flush().map_err(CliError::Io)?;
+    let mut answer = String::new();
+    io::stdin().read_line(&mut answer).map_err(CliError::Io)?;
+    Ok(!matches!(
+        answer.trim().to_ascii_lowercase().as_str(),
+        "n" | "no"
+    ))
+}
+
+fn cmd_config_list(json: bool) -> Result<(), CliError> {
     let config = Config::load(Some(
         &env::current_dir().unwrap_or_else(|_| PathBuf::from(".")),
     ))
     .map_err(|err| CliError::Message(err.to_string()))?;
+    if json {
+        let entries = config
+            .list()
+            .into_iter()
+            .map(|(key, value)| (key, serde_json::Value::String(value)))
+            .collect::<serde_json::Map<_, _>>();
+        return print_json(&serde_json::Value::Object(entries));
+    }
     for (key, value) in config.list() {
         println!("{}={}", key, value);
     }
@@ -742,7 +1033,44 @@ fn cmd_verifier(args: VerifierArgs) -> Result<(), CliError> {
     )
 }
 
-fn cmd_server(args: ServerArgs) -> Result<(), CliError> {
+fn cmd_state(args: StateArgs, json: bool, deps: &Deps) -> Result<(), CliError> {
+    match args.command {
+        StateCommand::Migrate => {
+            let migration = deps
+                .state_store()
+                .migrate()
+                .map_err(|err| CliError::Message(err.to_string()))?;
+            if json {
+                return print_json(&serde_json::json!({
+                    "version": state::STATE_VERSION,
+                    "migrated_from": migration.as_ref().map(|migration| migration.from),
+                    "backup": migration.as_ref().map(|migration| migration.backup.display().to_string()),
+                }));
+            }
+            match migration {
+                Some(migration) => println!(
+                    "Migrated state from version {} to {} (backup: {})",
+                    migration.from,
+                    migration.to,
+                    migration.backup.display()
+                ),
+                None => println!("State is up to date (version {})", state::STATE_VERSION),
+            }
+            Ok(())
+        }
+    }
+}
+
+fn cmd_mcp(deps: &Deps) -> Result<(), CliError> {
+    let store = deps.state_store();
+    store
+        .init_state()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    let stdin = io::stdin();
+    mcp::serve(&store, deps.offline(), stdin.lock(), io::stdout()).map_err(CliError::Io)
+}
+
+fn cmd_server(args: ServerArgs, deps: &Deps) -> Result<(), CliError> {
     let mut config = ServerConfig::from_env();
     if let Some(host) = args.host {
         config.host = host;
@@ -756,16 +1084,40 @@ fn cmd_server(args: ServerArgs) -> Result<(), CliError> {
     if args.open {
         config.open = true;
     }
+    if let Some(cert) = args.tls_cert {
+        config.tls.cert = Some(cert);
+    }
+    if let Some(key) = args.tls_key {
+        config.tls.key = Some(key);
+    }
+    if let Some(client_ca) = args.tls_client_ca {
+        config.tls.client_ca = Some(client_ca);
+    }
+    config.offline = deps.offline();
+    let settings = Config::load(None).map_err(|err| CliError::Message(err.to_string()))?;
+    config.tokens = server::load_api_tokens(&settings, args.tokens_file.as_deref())
+        .map_err(|err| CliError::Message(err.to_string()))?;
 
     let runtime = tokio::runtime::Runtime::new().map_err(CliError::Io)?;
     runtime
-        .block_on(server::run_server(config))
+        .block_on(server::run_server(config, deps.state_store()))
         .map_err(|err| CliError::Message(err.to_string()))
 }
 
 const DEFAULT_SESSION_NAME: &str = "gralph";
 
-fn session_name(name: &Option<String>, dir: &Path) -> Result<String, CliError> {
+/// `dir`, or the directory of workspace package `name` declared under it.
+pub(crate) fn workspace_dir(dir: &Path, name: Option<&str>) -> Result<PathBuf, CliError> {
+    let Some(name) = name else {
+        return Ok(dir.to_path_buf());
+    };
+    let package = workspace::resolve_workspace(dir, name)
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    println!("Workspace: {} ({})", name, package.display());
+    Ok(package)
+}
+
+pub(crate) fn session_name(name: &Option<String>, dir: &Path) -> Result<String, CliError> {
     if let Some(name) = name {
         let sanitized = sanitize_session_name(name);
         if sanitized.is_empty() {
@@ -880,6 +1232,13 @@ fn parse_yaml_value(value: &str) -> serde_yaml::Value {
     serde_yaml::Value::String(value.to_string())
 }
 
+pub(crate) fn print_json(value: &serde_json::Value) -> Result<(), CliError> {
+    let rendered =
+        serde_json::to_string(value).map_err(|err| CliError::Message(err.to_string()))?;
+    println!("{}", rendered);
+    Ok(())
+}
+
 pub(crate) fn normalize_csv(input: &str) -> Vec<String> {
     input
         .split(',')
@@ -901,7 +1260,7 @@ pub(crate) fn join_or_none(entries: &[String]) -> String {
 mod tests {
     use super::worktree;
     use super::*;
-    use crate::cli::{InitArgs, RunLoopArgs};
+    use crate::cli::{InitArgs, PrdTemplatesAddArgs, RunLoopArgs};
     use clap::Parser;
     use serde_json::json;
     use std::collections::BTreeMap;
@@ -939,6 +1298,31 @@ mod tests {
         assert_eq!(code, ExitCode::SUCCESS);
     }
 
+    #[test]
+    fn format_capabilities_lists_supported_features() {
+        let caps = BackendCapabilities {
+            streaming: true,
+            json_output: true,
+            max_context_tokens: Some(200_000),
+            variants: &["low", "high"],
+        };
+        assert_eq!(
+            format_capabilities(&caps),
+            "streaming, json output, 200k context, variants low/high"
+        );
+        let caps = BackendCapabilities {
+            streaming: true,
+            json_output: false,
+            max_context_tokens: Some(1_000_000),
+            variants: &[],
+        };
+        assert_eq!(format_capabilities(&caps), "streaming, 1M context");
+        assert_eq!(
+            format_capabilities(&BackendCapabilities::default()),
+            "none reported"
+        );
+    }
+
     #[test]
     fn exit_code_for_err_maps_failure() {
         let err = CliError::Message("nope".to_string());
@@ -970,15 +1354,26 @@ mod tests {
             dir,
             name: "test-session".to_string(),
             max_iterations: None,
+            max_cost: None,
+            max_tokens: None,
+            max_duration: None,
             task_file: None,
             completion_marker: None,
             backend: None,
             model: None,
             variant: None,
+            backend_args: Vec::new(),
             prompt_template: None,
+            review_backend: None,
+            review_model: None,
             webhook: None,
             no_worktree: false,
+            worktree: false,
             strict_prd: false,
+            pid_file: None,
+            resume: false,
+            profile: None,
+            allow_concurrent: false,
         }
     }
 
@@ -1113,12 +1508,14 @@ mod tests {
         let args = cli::ConfigSetArgs {
             key: "logging.level".to_string(),
             value: "info".to_string(),
+            file: cli::ConfigFileArgs::default(),
         };
         cmd_config_set(args).unwrap();
 
         let args = cli::ConfigSetArgs {
             key: "notifications.webhook".to_string(),
             value: "https://example.test".to_string(),
+            file: cli::ConfigFileArgs::default(),
         };
         cmd_config_set(args).unwrap();
 
@@ -1166,6 +1563,53 @@ mod tests {
         clear_env_overrides();
     }
 
+    #[test]
+    fn cmd_config_set_and_edit_target_the_global_file() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let global_path = temp.path().join("global.yaml");
+        set_env("GRALPH_GLOBAL_CONFIG", &global_path);
+        let global = cli::ConfigFileArgs {
+            global: true,
+            ..Default::default()
+        };
+
+        cmd_config_set(cli::ConfigSetArgs {
+            key: "defaults.backend".to_string(),
+            value: "codex".to_string(),
+            file: global,
+        })
+        .unwrap();
+        let saved = fs::read_to_string(&global_path).unwrap();
+        assert!(saved.contains("backend: codex"));
+
+        set_env("VISUAL", "sh -c 'echo \"defaults: [\" > \"$1\"' --");
+        let editor = editor_command().unwrap();
+        let mut attempts = 0;
+        let err = edit_config_file(&global_path, &editor, &mut || {
+            attempts += 1;
+            Ok(attempts < 2)
+        })
+        .unwrap_err();
+        assert_eq!(attempts, 2);
+        assert!(err.to_string().contains("Config not saved"));
+        assert_eq!(fs::read_to_string(&global_path).unwrap(), saved);
+
+        set_env(
+            "VISUAL",
+            "sh -c 'printf \"defaults:\\n  backend: gemini\\n\" > \"$1\"' --",
+        );
+        cmd_config_edit(cli::ConfigEditArgs { file: global }).unwrap();
+        assert!(
+            fs::read_to_string(&global_path)
+                .unwrap()
+                .contains("backend: gemini")
+        );
+
+        remove_env("VISUAL");
+        clear_env_overrides();
+    }
+
     #[test]
     fn invalid_prd_path_handles_extensions_and_force() {
         let output_md = PathBuf::from("PRD.generated.md");
@@ -1180,6 +1624,49 @@ mod tests {
         assert_eq!(forced, output_txt);
     }
 
+    #[test]
+    fn prd_critique_prompts_carry_issues_and_detect_clean_reviews() {
+        let issues =
+            vec!["PRD validation error: PRD.md: A-1: Missing required field: DoD".to_string()];
+        let critique = critique_prompt("# PRD\n", &issues, "README.md", "Rust");
+        assert!(critique.contains("Do not modify any files."));
+        assert!(critique.contains("Validation issues already found:\nPRD validation error"));
+        assert!(critique.contains(
+            "Context files (Context Bundle entries must be selected from these):\nREADME.md"
+        ));
+        assert!(critique.ends_with("PRD:\n# PRD\n\n"));
+
+        let revision = revision_prompt("# PRD\n", "- A-1: add a DoD\n", &[]);
+        assert!(
+            revision.contains("Validation issues:\nNone.\n\nReview:\n- A-1: add a DoD\n\nPRD:")
+        );
+
+        assert!(!critique_found_problems("No problems found.\n"));
+        assert!(!critique_found_problems("no problems found"));
+        assert!(critique_found_problems(
+            "- A-1: Context Bundle cites a missing file"
+        ));
+    }
+
+    #[test]
+    fn estimated_prd_stage_follows_headings_and_tasks() {
+        let tasks = std::cell::Cell::new(0);
+        assert_eq!(estimated_prd_stage("Reading src/main.rs", &tasks), None);
+        assert_eq!(
+            estimated_prd_stage("# PRD: Widgets", &tasks).as_deref(),
+            Some("writing the PRD")
+        );
+        assert_eq!(
+            estimated_prd_stage("### Task W-1", &tasks).as_deref(),
+            Some("writing tasks (1)")
+        );
+        assert_eq!(
+            estimated_prd_stage("### Task W-2", &tasks).as_deref(),
+            Some("writing tasks (2)")
+        );
+        assert_eq!(estimated_prd_stage("## Success Criteria", &tasks), None);
+    }
+
     #[test]
     fn cli_parse_reports_missing_required_args() {
         assert!(Cli::try_parse_from(["gralph", "logs"]).is_err());
@@ -1209,6 +1696,58 @@ mod tests {
         assert_eq!(template, DEFAULT_PRD_TEMPLATE);
     }
 
+    #[test]
+    fn prd_templates_registry_adds_lists_and_reads_by_name() {
+        let registry = tempfile::tempdir().unwrap();
+        let source = tempfile::tempdir().unwrap();
+        let file = source.path().join("cli.md");
+        fs::write(&file, "# CLI PRD\n").unwrap();
+        let args = PrdTemplatesAddArgs {
+            name: "cli".to_string(),
+            file: file.clone(),
+            force: false,
+            git_hooks: false,
+        };
+
+        let path = add_prd_template(registry.path(), &args).unwrap();
+        fs::write(registry.path().join("notes.txt"), "ignored").unwrap();
+
+        assert_eq!(path, registry.path().join("cli.md"));
+        assert_eq!(
+            list_prd_templates(registry.path()).unwrap(),
+            vec![("cli".to_string(), path)]
+        );
+        assert_eq!(
+            read_named_prd_template(registry.path(), "cli").unwrap(),
+            "# CLI PRD\n"
+        );
+        let err = add_prd_template(registry.path(), &args).unwrap_err();
+        assert!(err.to_string().contains("Template already exists"));
+        add_prd_template(
+            registry.path(),
+            &PrdTemplatesAddArgs {
+                force: true,
+                ..args
+            },
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn prd_templates_registry_rejects_unknown_and_invalid_names() {
+        let registry = tempfile::tempdir().unwrap();
+
+        assert!(
+            list_prd_templates(&registry.path().join("missing"))
+                .unwrap()
+                .is_empty()
+        );
+        let err = read_named_prd_template(registry.path(), "webapp").unwrap_err();
+        assert!(err.to_string().contains("Unknown PRD template: webapp"));
+        let err = read_named_prd_template(registry.path(), "../secret").unwrap_err();
+        assert!(err.to_string().contains("Invalid template name"));
+    }
+
     #[test]
     fn parse_bool_value_accepts_true_false_and_invalid() {
         for value in ["true", "True", "1", "yes", "Y", "on", "  ON  "] {
@@ -1291,6 +1830,27 @@ mod tests {
         assert_eq!(sanitize_session_name("!!!"), "---");
     }
 
+    #[test]
+    fn workspace_dir_scopes_to_declared_package() {
+        let temp = tempfile::tempdir().unwrap();
+        write_file(
+            &temp.path().join("pnpm-workspace.yaml"),
+            "packages:\n  - apps/*\n",
+        );
+        write_file(
+            &temp.path().join("apps/web/package.json"),
+            "{\"name\": \"@acme/web\"}",
+        );
+
+        assert_eq!(workspace_dir(temp.path(), None).unwrap(), temp.path());
+        assert_eq!(
+            workspace_dir(temp.path(), Some("web")).unwrap(),
+            temp.path().join("apps/web")
+        );
+        let err = workspace_dir(temp.path(), Some("api")).unwrap_err();
+        assert!(err.to_string().contains("Unknown workspace: api"));
+    }
+
     #[test]
     fn session_name_uses_explicit_name_and_sanitizes() {
         let temp = tempfile::tempdir().unwrap();
@@ -1345,6 +1905,7 @@ mod tests {
         let args = InitArgs {
             dir: Some(temp.path().to_path_buf()),
             force: false,
+            git_hooks: false,
         };
         cmd_init(args.clone()).unwrap();
 
@@ -1376,6 +1937,7 @@ mod tests {
         let args = InitArgs {
             dir: Some(temp.path().to_path_buf()),
             force: true,
+            git_hooks: false,
         };
         cmd_init(args).unwrap();
 
@@ -1384,6 +1946,127 @@ mod tests {
         clear_env_overrides();
     }
 
+    #[test]
+    fn init_bootstraps_project_config_template_and_state_dir() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let config_path = temp.path().join("default.yaml");
+        let project = temp.path().join("project");
+        fs::create_dir_all(&project).unwrap();
+        write_file(&project.join("Cargo.toml"), "[package]\nname = \"demo\"\n");
+        write_file(&project.join(".gitignore"), "target/");
+        write_file(
+            &config_path,
+            "defaults:\n  context_files: ARCHITECTURE.md\n  backend: openai\n",
+        );
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env("GRALPH_GLOBAL_CONFIG", temp.path().join("missing.yaml"));
+
+        let args = InitArgs {
+            dir: Some(project.clone()),
+            force: false,
+            git_hooks: false,
+        };
+        cmd_init(args.clone()).unwrap();
+
+        let config = fs::read_to_string(project.join(".gralph.yaml")).unwrap();
+        assert!(config.contains("  backend: openai\n"));
+        assert!(config.contains("  test_command: cargo test --workspace\n"));
+        assert!(project.join("PRD.template.md").is_file());
+        assert!(
+            fs::read_to_string(project.join(".gralph/.gitignore"))
+                .unwrap()
+                .contains("*.log\n")
+        );
+        assert_eq!(
+            fs::read_to_string(project.join(".gitignore")).unwrap(),
+            "target/\n.worktrees/\n"
+        );
+
+        write_file(
+            &project.join(".gralph.yaml"),
+            "defaults:\n  backend: codex\n",
+        );
+        cmd_init(args).unwrap();
+        assert_eq!(
+            fs::read_to_string(project.join(".gralph.yaml")).unwrap(),
+            "defaults:\n  backend: codex\n"
+        );
+        assert_eq!(
+            fs::read_to_string(project.join(".gitignore")).unwrap(),
+            "target/\n.worktrees/\n"
+        );
+        clear_env_overrides();
+    }
+
+    #[test]
+    fn init_git_hooks_requires_a_repository() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let config_path = temp.path().join("default.yaml");
+        write_file(&config_path, "defaults:\n  context_files: \"\"\n");
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env("GRALPH_GLOBAL_CONFIG", temp.path().join("missing.yaml"));
+        let project = temp.path().join("project");
+        fs::create_dir_all(&project).unwrap();
+
+        let args = InitArgs {
+            dir: Some(project.clone()),
+            force: false,
+            git_hooks: true,
+        };
+        let err = cmd_init(args.clone()).unwrap_err();
+        assert!(
+            err.to_string()
+                .contains("--git-hooks needs a git repository")
+        );
+
+        init_git_repo(&project);
+        cmd_init(args).unwrap();
+        let hook = fs::read_to_string(project.join(".git/hooks/pre-commit")).unwrap();
+        assert!(hook.contains("exec gralph prd check PRD.md"));
+        clear_env_overrides();
+    }
+
+    #[test]
+    fn starter_project_config_uses_the_detected_stack() {
+        let node = crate::prd::StackDetection {
+            ids: vec!["Node.js".to_string()],
+            package_managers: vec!["pnpm".to_string()],
+            ..crate::prd::StackDetection::default()
+        };
+        assert_eq!(detected_test_command(&node).as_deref(), Some("pnpm test"));
+        let config = starter_project_config("codex", &node);
+        assert!(config.contains("# Detected stack: Node.js\n"));
+        assert!(config.contains("defaults:\n  backend: codex\n"));
+        assert!(config.contains("verifier:\n  test_command: pnpm test\n  # coverage_command"));
+        assert!(Config::from_yaml(&config).is_ok());
+
+        let unknown = crate::prd::StackDetection::default();
+        assert_eq!(detected_test_command(&unknown), None);
+        let config = starter_project_config("claude", &unknown);
+        assert!(config.contains("# Detected stack: none detected\n"));
+        assert!(config.contains("  # test_command: <command that runs the tests>\n"));
+    }
+
+    #[test]
+    fn append_missing_lines_adds_only_new_entries() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join(".gitignore");
+
+        assert!(append_missing_lines(&path, &["a/", "b/"]).unwrap());
+        assert!(!append_missing_lines(&path, &["b/"]).unwrap());
+        assert_eq!(fs::read_to_string(&path).unwrap(), "a/\nb/\n");
+    }
+
+    #[test]
+    fn pre_commit_hook_quotes_the_task_file() {
+        let hook = pre_commit_hook("docs/My PRD.md");
+        assert!(hook.starts_with("#!/bin/sh\n"));
+        assert!(hook.contains("if [ -f 'docs/My PRD.md' ] && command -v gralph"));
+        assert!(hook.contains("  exec gralph prd check 'docs/My PRD.md'\n"));
+    }
+
     #[test]
     fn init_reports_missing_directory() {
         let _guard = env_guard();
@@ -1393,6 +2076,7 @@ mod tests {
         let args = InitArgs {
             dir: Some(missing.clone()),
             force: false,
+            git_hooks: false,
         };
         let err = cmd_init(args).unwrap_err();
         match err {
@@ -1676,11 +2360,14 @@ mod tests {
             .unwrap();
 
         let args = cli::LogsArgs {
-            name: "demo".to_string(),
+            name: Some("demo".to_string()),
             follow: false,
             raw: false,
+            iteration: None,
+            grep: None,
+            since: None,
         };
-        loop_session::cmd_logs(args, &Deps::real()).unwrap();
+        loop_session::cmd_logs(args, false, &Deps::real()).unwrap();
         clear_env_overrides();
     }
 
@@ -1698,11 +2385,14 @@ mod tests {
         store.set_session("demo", &[("dir", &dir_string)]).unwrap();
 
         let args = cli::LogsArgs {
-            name: "demo".to_string(),
+            name: Some("demo".to_string()),
             follow: false,
             raw: false,
+            iteration: None,
+            grep: None,
+            since: None,
         };
-        loop_session::cmd_logs(args, &Deps::real()).unwrap();
+        loop_session::cmd_logs(args, false, &Deps::real()).unwrap();
         clear_env_overrides();
     }
 
@@ -2098,6 +2788,40 @@ mod tests {
         clear_env_overrides();
     }
 
+    #[test]
+    fn cmd_history_reads_records_and_rejects_bad_since() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let state_dir = set_state_env(temp.path());
+        let record = crate::history::HistoryRecord {
+            session: "demo".to_string(),
+            dir: temp.path().to_string_lossy().into_owned(),
+            status: "complete".to_string(),
+            iterations: 2,
+            max_iterations: 30,
+            remaining_tasks: 0,
+            duration_secs: 75,
+            cost_usd: None,
+            backend: "claude".to_string(),
+            model: None,
+            started_at: "2026-01-01T00:00:00+00:00".to_string(),
+            finished_at: "2026-01-01T00:01:15+00:00".to_string(),
+            git_start: None,
+            git_end: None,
+        };
+        crate::history::append_record(&crate::history::history_path(&state_dir), &record).unwrap();
+
+        let args = |since: Option<&str>| cli::HistoryArgs {
+            session: Some("demo".to_string()),
+            since: since.map(str::to_string),
+        };
+        loop_session::cmd_history(args(None), false, &Deps::real()).unwrap();
+        loop_session::cmd_history(args(Some("7d")), true, &Deps::real()).unwrap();
+        let err = loop_session::cmd_history(args(Some("soon")), false, &Deps::real()).unwrap_err();
+        assert!(err.to_string().contains("Invalid --since duration: soon"));
+        clear_env_overrides();
+    }
+
     #[test]
     fn join_or_none_returns_none_for_empty() {
         let entries: Vec<String> = Vec::new();
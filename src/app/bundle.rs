@@ -0,0 +1,858 @@
+use super::loop_session::{resolve_log_file, resolve_raw_log_file};
+use super::picker::{self, Pick, PickerEntry};
+use super::{CliError, Deps};
+use crate::cli::{ExportArgs, ImportArgs};
+use crate::config::Config;
+use crate::core;
+use crate::redact::{self, REDACTED, Redactor};
+use crate::version;
+use serde_json::{Map, Value};
+use std::env;
+use std::fs;
+use std::path::{Component, Path, PathBuf};
+use std::process::Command;
+
+/// Version of the bundle layout. Bundles from a newer gralph are refused.
+const BUNDLE_FORMAT: u64 = 1;
+
+const MANIFEST_FILE: &str = "manifest.json";
+const SESSION_FILE: &str = "session.json";
+const CONFIG_FILE: &str = "config.json";
+const LOG_FILE: &str = "logs/session.log";
+const RAW_LOG_FILE: &str = "logs/session.raw.log";
+const PRD_DIR: &str = "prd";
+
+/// Fields of an exported record that are copied on import. The rest, such
+/// as pids, worktree paths, webhooks, and backend arguments, describe the
+/// exporting machine and could make gralph act on this one.
+const IMPORTED_FIELDS: [&str; 24] = [
+    "backend",
+    "model",
+    "variant",
+    "profile",
+    "review_backend",
+    "review_model",
+    "iteration",
+    "max_iterations",
+    "max_tokens",
+    "max_cost",
+    "max_duration",
+    "started_at",
+    "finished_at",
+    "last_activity",
+    "last_task_count",
+    "last_task_checklist",
+    "current_task",
+    "current_remaining",
+    "task_id",
+    "completion_marker",
+    "git_start",
+    "pr_url",
+    "result",
+    "last_error",
+];
+
+/// Describes a bundle: which session it holds and what files it contains.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+struct Manifest {
+    format: u64,
+    gralph_version: String,
+    exported_at: String,
+    session: String,
+    /// The session's task files, relative to `prd/`.
+    #[serde(default)]
+    task_files: Vec<String>,
+    #[serde(default)]
+    files: Vec<String>,
+}
+
+/// Writes a session's state record, logs, PRD snapshot, and effective config
+/// to a `.tar.gz` bundle, with secrets redacted.
+pub(super) fn cmd_export(args: ExportArgs, deps: &Deps) -> Result<(), CliError> {
+    let store = deps.state_store();
+    store
+        .init_state()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    let name = match args.name.clone() {
+        Some(name) => name,
+        None => {
+            let sessions = store
+                .list_sessions()
+                .map_err(|err| CliError::Message(err.to_string()))?;
+            let entries = sessions
+                .iter()
+                .filter_map(PickerEntry::from_session)
+                .collect::<Vec<_>>();
+            match picker::pick_on_terminal(&entries, "export", false)? {
+                Some(Pick::Session(name)) => name,
+                _ => return Err(CliError::Message("Session name is required.".to_string())),
+            }
+        }
+    };
+    let session = store
+        .get_session(&name)
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .ok_or_else(|| CliError::Message(format!("Session not found: {}", name)))?;
+    let profile = session
+        .get("profile")
+        .and_then(Value::as_str)
+        .filter(|profile| !profile.is_empty());
+    let config = Config::load_with_profile(session_dir(&session).as_deref(), profile)
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    let redactor = Redactor::from_config(&config);
+
+    let exported_at: chrono::DateTime<chrono::Utc> = deps.clock().now().into();
+    let output = match args.output {
+        Some(output) => output,
+        None => PathBuf::from(format!(
+            "gralph-{}-{}.tar.gz",
+            name.replace(['/', '\\'], "-"),
+            exported_at.format("%Y%m%d-%H%M%S")
+        )),
+    };
+    let staging = staging_dir(
+        &store.state_dir().join("exports"),
+        &format!(".export-{}", std::process::id()),
+    )?;
+    let result = write_bundle(
+        &staging,
+        &name,
+        &session,
+        &config,
+        &redactor,
+        &exported_at.to_rfc3339(),
+    )
+    .and_then(|manifest| pack(&staging, &output).map(|()| manifest));
+    let _ = fs::remove_dir_all(&staging);
+    let manifest = result?;
+
+    println!(
+        "Exported {} ({} files) to {}",
+        name,
+        manifest.files.len(),
+        output.display()
+    );
+    Ok(())
+}
+
+/// Unpacks a bundle made by `gralph export` under `<state dir>/imports/` and
+/// registers its session, so `gralph status` and `gralph logs` show it.
+pub(super) fn cmd_import(args: ImportArgs, deps: &Deps) -> Result<(), CliError> {
+    if !args.bundle.is_file() {
+        return Err(CliError::Message(format!(
+            "Bundle not found: {}",
+            args.bundle.display()
+        )));
+    }
+    let store = deps.state_store();
+    store
+        .init_state()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    let imports = store.state_dir().join("imports");
+    let staging = staging_dir(&imports, &format!(".import-{}", std::process::id()))?;
+    let manifest = match unpack(&args.bundle, &staging) {
+        Ok(manifest) => manifest,
+        Err(err) => {
+            let _ = fs::remove_dir_all(&staging);
+            return Err(err);
+        }
+    };
+    let name = args
+        .name
+        .clone()
+        .unwrap_or_else(|| manifest.session.clone());
+    let root = imports.join(&name);
+    let taken = store
+        .list_sessions()
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .iter()
+        .any(|session| session.get("name").and_then(Value::as_str) == Some(name.as_str()));
+    if name.trim().is_empty()
+        || name.contains(['/', '\\'])
+        || name == "."
+        || name == ".."
+        || taken
+        || root.exists()
+    {
+        let _ = fs::remove_dir_all(&staging);
+        return Err(CliError::Message(format!(
+            "Cannot import as {:?}: the name is invalid or already in use (pick another with --name)",
+            name
+        )));
+    }
+    fs::rename(&staging, &root)?;
+
+    let record = fs::read_to_string(root.join(SESSION_FILE))
+        .ok()
+        .and_then(|contents| serde_json::from_str::<Map<String, Value>>(&contents).ok())
+        .unwrap_or_default();
+    let fields = imported_fields(&record, &root, &manifest, args.dir.as_deref());
+    let fields: Vec<(&str, &str)> = fields
+        .iter()
+        .map(|(key, value)| (key.as_str(), value.as_str()))
+        .collect();
+    store
+        .set_session(&name, &fields)
+        .map_err(|err| CliError::Message(err.to_string()))?;
+
+    println!(
+        "Imported {} (exported {} by gralph {}) to {}",
+        name,
+        manifest.exported_at,
+        manifest.gralph_version,
+        root.display()
+    );
+    println!("View it with: gralph status, gralph logs {}", name);
+    Ok(())
+}
+
+fn session_dir(session: &Value) -> Option<PathBuf> {
+    session
+        .get("dir")
+        .and_then(Value::as_str)
+        .filter(|dir| !dir.is_empty())
+        .map(PathBuf::from)
+}
+
+/// Creates an empty directory `name` under `parent` that only its owner can
+/// open, replacing one a crashed run left behind. Bundles are staged there
+/// rather than in the shared temp dir, where another user could predict the
+/// path.
+fn staging_dir(parent: &Path, name: &str) -> Result<PathBuf, CliError> {
+    fs::create_dir_all(parent)?;
+    let staging = parent.join(name);
+    let _ = fs::remove_dir_all(&staging);
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::DirBuilderExt;
+        fs::DirBuilder::new().mode(0o700).create(&staging)?;
+    }
+    #[cfg(not(unix))]
+    fs::create_dir(&staging)?;
+    Ok(staging)
+}
+
+/// Writes the bundle contents for session `name` into `root` and returns its
+/// manifest. Missing logs or task files are left out rather than failing the
+/// export: a crashed session is the one most worth exporting.
+fn write_bundle(
+    root: &Path,
+    name: &str,
+    session: &Value,
+    config: &Config,
+    redactor: &Redactor,
+    exported_at: &str,
+) -> Result<Manifest, CliError> {
+    let mut files = Vec::new();
+    let mut write = |path: &str, contents: &str| -> Result<(), CliError> {
+        let target = root.join(path);
+        if let Some(parent) = target.parent() {
+            fs::create_dir_all(parent)?;
+        }
+        fs::write(&target, redactor.redact(contents).as_bytes())?;
+        files.push(path.to_string());
+        Ok(())
+    };
+
+    write(SESSION_FILE, &to_json(session)?)?;
+    let values: Map<String, Value> = config
+        .list()
+        .into_iter()
+        .map(|(key, value)| {
+            let secret = key
+                .rsplit('.')
+                .next()
+                .is_some_and(redact::is_secret_env_name);
+            let value = if secret && !value.is_empty() {
+                REDACTED.to_string()
+            } else {
+                value
+            };
+            (key, Value::String(value))
+        })
+        .collect();
+    write(CONFIG_FILE, &to_json(&Value::Object(values))?)?;
+
+    for (path, log_file) in [
+        (LOG_FILE, resolve_log_file(name, session)),
+        (RAW_LOG_FILE, resolve_raw_log_file(name, session)),
+    ] {
+        if let Some(contents) = log_file.ok().and_then(|log_file| fs::read(log_file).ok()) {
+            write(path, &String::from_utf8_lossy(&contents))?;
+        }
+    }
+
+    let mut task_files = Vec::new();
+    let task_file = session.get("task_file").and_then(Value::as_str);
+    if let (Some(dir), Some(task_file)) = (session_dir(session), task_file) {
+        for file in core::resolve_task_files(&dir, task_file).unwrap_or_default() {
+            let Ok(contents) = fs::read_to_string(dir.join(&file)) else {
+                continue;
+            };
+            let bundled = bundled_task_path(&file);
+            write(&format!("{}/{}", PRD_DIR, bundled), &contents)?;
+            task_files.push(bundled);
+        }
+    }
+
+    let manifest = Manifest {
+        format: BUNDLE_FORMAT,
+        gralph_version: version::VERSION.to_string(),
+        exported_at: exported_at.to_string(),
+        session: name.to_string(),
+        task_files,
+        files,
+    };
+    let rendered = serde_json::to_value(&manifest)
+        .map_err(|err| CliError::Message(err.to_string()))
+        .and_then(|value| to_json(&value))?;
+    fs::write(root.join(MANIFEST_FILE), rendered)?;
+    Ok(manifest)
+}
+
+fn to_json(value: &Value) -> Result<String, CliError> {
+    serde_json::to_string_pretty(value).map_err(|err| CliError::Message(err.to_string()))
+}
+
+/// Where a task file goes under `prd/`: its path in the project when it is
+/// relative and stays inside it, otherwise just its file name.
+fn bundled_task_path(file: &str) -> String {
+    let path = Path::new(file);
+    if path
+        .components()
+        .all(|component| matches!(component, Component::Normal(_) | Component::CurDir))
+    {
+        let relative: Vec<_> = path
+            .components()
+            .filter(|component| matches!(component, Component::Normal(_)))
+            .map(|component| component.as_os_str().to_string_lossy().into_owned())
+            .collect();
+        if !relative.is_empty() {
+            return relative.join("/");
+        }
+    }
+    path.file_name()
+        .map(|name| name.to_string_lossy().into_owned())
+        .unwrap_or_else(|| "PRD.md".to_string())
+}
+
+fn read_manifest(root: &Path) -> Result<Manifest, CliError> {
+    let manifest: Manifest = fs::read_to_string(root.join(MANIFEST_FILE))
+        .ok()
+        .and_then(|contents| serde_json::from_str(&contents).ok())
+        .ok_or_else(|| {
+            CliError::Message("Not a gralph export bundle: missing manifest.json".to_string())
+        })?;
+    if manifest.format > BUNDLE_FORMAT {
+        return Err(CliError::Message(format!(
+            "Bundle format {} was written by gralph {}; this gralph reads up to format {}. Run `gralph update` first.",
+            manifest.format, manifest.gralph_version, BUNDLE_FORMAT
+        )));
+    }
+    Ok(manifest)
+}
+
+/// The state fields for a session imported into `root`: the exported record,
+/// pointed at the bundled logs and at `dir` or the PRD snapshot, and marked
+/// `imported` so it is never taken for a loop running on this machine.
+fn imported_fields(
+    record: &Map<String, Value>,
+    root: &Path,
+    manifest: &Manifest,
+    dir: Option<&Path>,
+) -> Vec<(String, String)> {
+    let mut fields: Vec<(String, String)> = record
+        .iter()
+        .filter(|(key, _)| IMPORTED_FIELDS.contains(&key.as_str()))
+        .map(|(key, value)| {
+            let value = match value {
+                Value::String(value) => value.clone(),
+                Value::Null => String::new(),
+                other => other.to_string(),
+            };
+            (key.clone(), value)
+        })
+        .collect();
+    let mut set =
+        |key: &str, value: String| match fields.iter_mut().find(|(existing, _)| existing == key) {
+            Some(field) => field.1 = value,
+            None => fields.push((key.to_string(), value)),
+        };
+
+    if let Some(original) = record.get("dir").and_then(Value::as_str) {
+        set("imported_from", original.to_string());
+    }
+    set("status", "imported".to_string());
+    set("pid", "0".to_string());
+    set("tmux_session", String::new());
+    set("log_file", root.join(LOG_FILE).display().to_string());
+    set(
+        "raw_log_file",
+        root.join(RAW_LOG_FILE).display().to_string(),
+    );
+    match dir {
+        Some(dir) => {
+            set("dir", dir.display().to_string());
+            // Task files are read relative to `dir`, so only paths that stay
+            // inside it are kept.
+            let task_file = record
+                .get("task_file")
+                .and_then(Value::as_str)
+                .filter(|task_file| {
+                    task_file
+                        .split(',')
+                        .all(|file| relative_path(file.trim()).is_some())
+                });
+            if let Some(task_file) = task_file {
+                set("task_file", task_file.to_string());
+            }
+        }
+        None => {
+            set("dir", root.join(PRD_DIR).display().to_string());
+            if !manifest.task_files.is_empty() {
+                set("task_file", manifest.task_files.join(","));
+            }
+        }
+    }
+    fields
+}
+
+/// `tar` with a usable PATH, as `gralph update` runs it.
+fn tar_command() -> Command {
+    let mut cmd = Command::new("tar");
+    if env::var_os("PATH").map_or(true, |value| value.is_empty()) {
+        cmd.env("PATH", "/usr/bin:/bin");
+    }
+    cmd
+}
+
+/// Runs `tar` and returns its standard output.
+fn run_tar(mut cmd: Command, action: &str) -> Result<String, CliError> {
+    let output = cmd
+        .output()
+        .map_err(|err| CliError::Message(format!("Failed to {}: {}", action, err)))?;
+    if !output.status.success() {
+        return Err(CliError::Message(format!(
+            "Failed to {}: {}",
+            action,
+            String::from_utf8_lossy(&output.stderr).trim()
+        )));
+    }
+    Ok(String::from_utf8_lossy(&output.stdout).into_owned())
+}
+
+/// Archives the contents of `root` into the gzipped tarball `output`.
+fn pack(root: &Path, output: &Path) -> Result<(), CliError> {
+    let output = env::current_dir()?.join(output);
+    if let Some(parent) = output.parent() {
+        fs::create_dir_all(parent)?;
+    }
+    let mut cmd = tar_command();
+    cmd.arg("-czf").arg(&output).arg("-C").arg(root).arg(".");
+    run_tar(cmd, "write bundle").map(|_| ())
+}
+
+/// A bundle member: its name as `tar` lists it, its path inside the bundle,
+/// and whether it is a directory.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Member {
+    listed: String,
+    path: String,
+    dir: bool,
+}
+
+/// Extracts the bundle into `target` and returns its manifest. The members
+/// are checked before anything else is written: links, special files, paths
+/// that leave the bundle, and files the manifest does not list are refused,
+/// so a crafted bundle cannot write outside `target`.
+fn unpack(bundle: &Path, target: &Path) -> Result<Manifest, CliError> {
+    let members = list_members(bundle)?;
+    let Some(manifest_member) = members
+        .iter()
+        .find(|member| !member.dir && member.path == MANIFEST_FILE)
+    else {
+        return Err(CliError::Message(
+            "Not a gralph export bundle: missing manifest.json".to_string(),
+        ));
+    };
+    let mut cmd = tar_command();
+    cmd.arg("-xzf")
+        .arg(bundle)
+        .arg("-C")
+        .arg(target)
+        .arg(&manifest_member.listed);
+    run_tar(cmd, "read bundle")?;
+    let manifest = read_manifest(target)?;
+    check_members(&members, &manifest)?;
+
+    let mut cmd = tar_command();
+    cmd.arg("-xzf").arg(bundle).arg("-C").arg(target);
+    run_tar(cmd, "read bundle")?;
+    Ok(manifest)
+}
+
+/// Lists the members of `bundle`, refusing links, special files, and paths
+/// that are absolute or climb out with `..`.
+fn list_members(bundle: &Path) -> Result<Vec<Member>, CliError> {
+    let list = |flags: &str| {
+        let mut cmd = tar_command();
+        cmd.arg(flags).arg(bundle);
+        run_tar(cmd, "read bundle")
+    };
+    let names = list("-tzf")?;
+    // `tar -tv` starts each line with the member type, as `ls -l` does.
+    let details = list("-tvzf")?;
+    let names = names.lines().collect::<Vec<_>>();
+    let details = details.lines().collect::<Vec<_>>();
+    if names.len() != details.len() {
+        return Err(invalid_bundle("its member list could not be read"));
+    }
+
+    let mut members = Vec::new();
+    for (name, detail) in names.into_iter().zip(details) {
+        let dir = match detail.chars().next() {
+            Some('-') => false,
+            Some('d') => true,
+            _ => {
+                return Err(invalid_bundle(&format!(
+                    "{} is a link or special file",
+                    name
+                )));
+            }
+        };
+        let Some(path) = relative_path(name) else {
+            return Err(invalid_bundle(&format!("{} is outside the bundle", name)));
+        };
+        if path.is_empty() && !dir {
+            return Err(invalid_bundle(&format!("{} is outside the bundle", name)));
+        }
+        members.push(Member {
+            listed: name.to_string(),
+            path,
+            dir,
+        });
+    }
+    Ok(members)
+}
+
+/// Refuses files the manifest does not list, and directories that hold none
+/// of them.
+fn check_members(members: &[Member], manifest: &Manifest) -> Result<(), CliError> {
+    let mut files = vec![MANIFEST_FILE.to_string()];
+    files.extend(manifest.files.iter().cloned());
+    for file in &manifest.task_files {
+        if relative_path(file).is_none_or(|path| path.is_empty()) {
+            return Err(invalid_bundle(&format!(
+                "task file {} is outside the bundle",
+                file
+            )));
+        }
+    }
+    for member in members {
+        let listed = if member.dir {
+            member.path.is_empty()
+                || files
+                    .iter()
+                    .any(|file| file.starts_with(&format!("{}/", member.path)))
+        } else {
+            files.contains(&member.path)
+        };
+        if !listed {
+            return Err(invalid_bundle(&format!(
+                "{} is not listed in its manifest",
+                member.listed
+            )));
+        }
+    }
+    Ok(())
+}
+
+/// `path` with `.` components dropped and `/` separators, or `None` when it
+/// is absolute or has a `..` component.
+fn relative_path(path: &str) -> Option<String> {
+    let mut parts = Vec::new();
+    for component in Path::new(path).components() {
+        match component {
+            Component::Normal(part) => parts.push(part.to_string_lossy().into_owned()),
+            Component::CurDir => {}
+            _ => return None,
+        }
+    }
+    Some(parts.join("/"))
+}
+
+fn invalid_bundle(reason: &str) -> CliError {
+    CliError::Message(format!("Refusing to import bundle: {}", reason))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn manifest(task_files: &[&str]) -> Manifest {
+        Manifest {
+            format: BUNDLE_FORMAT,
+            gralph_version: "0.2.4".to_string(),
+            exported_at: "2026-01-01T00:00:00+00:00".to_string(),
+            session: "app".to_string(),
+            task_files: task_files.iter().map(|file| file.to_string()).collect(),
+            files: Vec::new(),
+        }
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn staging_dir_is_private_and_starts_empty() {
+        use std::os::unix::fs::PermissionsExt;
+        let temp = tempfile::tempdir().unwrap();
+        let exports = temp.path().join("exports");
+        let leftover = exports.join(".export-1");
+        fs::create_dir_all(&leftover).unwrap();
+        fs::write(leftover.join("stale"), "").unwrap();
+
+        let staging = staging_dir(&exports, ".export-1").unwrap();
+
+        assert_eq!(staging, leftover);
+        assert_eq!(fs::read_dir(&staging).unwrap().count(), 0);
+        assert_eq!(
+            fs::metadata(&staging).unwrap().permissions().mode() & 0o777,
+            0o700
+        );
+    }
+
+    #[test]
+    fn bundled_task_path_keeps_project_relative_paths() {
+        assert_eq!(bundled_task_path("PRD.md"), "PRD.md");
+        assert_eq!(bundled_task_path("./prd/api.md"), "prd/api.md");
+        assert_eq!(bundled_task_path("../shared/PRD.md"), "PRD.md");
+        assert_eq!(bundled_task_path("/home/me/tasks.md"), "tasks.md");
+    }
+
+    #[test]
+    fn imported_fields_point_at_the_bundle_and_stop_the_session() {
+        let record = json!({
+            "name": "app",
+            "dir": "/home/me/app",
+            "task_file": "PRD.md",
+            "status": "running",
+            "pid": 4242,
+            "runner_pid": 4243,
+            "iteration": 7,
+            "revision": 12,
+            "log_file": "/home/me/.config/gralph/logs/app.log",
+            "worktree": "/home/me/app/.worktrees/task-1",
+            "webhook": "https://hooks.example.com/app",
+            "backend_args": "--dangerously-skip-permissions",
+        });
+        let record = record.as_object().unwrap();
+        let root = Path::new("/state/imports/app");
+
+        let fields = imported_fields(record, root, &manifest(&["prd/api.md"]), None);
+        let get = |key: &str| {
+            fields
+                .iter()
+                .find(|(existing, _)| existing == key)
+                .map(|(_, value)| value.as_str())
+        };
+        assert_eq!(get("status"), Some("imported"));
+        assert_eq!(get("pid"), Some("0"));
+        assert_eq!(get("iteration"), Some("7"));
+        assert_eq!(get("imported_from"), Some("/home/me/app"));
+        assert_eq!(get("dir"), Some("/state/imports/app/prd"));
+        assert_eq!(get("task_file"), Some("prd/api.md"));
+        assert_eq!(get("log_file"), Some("/state/imports/app/logs/session.log"));
+        for key in [
+            "name",
+            "revision",
+            "runner_pid",
+            "worktree",
+            "webhook",
+            "backend_args",
+        ] {
+            assert_eq!(get(key), None, "{}", key);
+        }
+
+        let fields = imported_fields(record, root, &manifest(&[]), Some(Path::new("/src/app")));
+        assert!(fields.contains(&("dir".to_string(), "/src/app".to_string())));
+        assert!(fields.contains(&("task_file".to_string(), "PRD.md".to_string())));
+
+        let mut escaping = record.clone();
+        escaping.insert("task_file".to_string(), json!("PRD.md,../../etc/passwd"));
+        let fields = imported_fields(&escaping, root, &manifest(&[]), Some(Path::new("/src/app")));
+        assert!(!fields.iter().any(|(key, _)| key == "task_file"));
+    }
+
+    #[test]
+    fn relative_path_refuses_absolute_and_parent_paths() {
+        assert_eq!(
+            relative_path("./logs/session.log"),
+            Some("logs/session.log".to_string())
+        );
+        assert_eq!(relative_path("./"), Some(String::new()));
+        assert_eq!(relative_path("/etc/passwd"), None);
+        assert_eq!(relative_path("prd/../../x"), None);
+    }
+
+    /// Packs `root` like `gralph export` and tries to import it.
+    #[cfg(unix)]
+    fn unpack_dir(temp: &Path, root: &Path) -> Result<Manifest, CliError> {
+        let bundle = temp.join("bundle.tar.gz");
+        let _ = fs::remove_file(&bundle);
+        pack(root, &bundle).unwrap();
+        let target = temp.join("target");
+        let _ = fs::remove_dir_all(&target);
+        fs::create_dir_all(&target).unwrap();
+        unpack(&bundle, &target)
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn unpack_refuses_links_and_unlisted_files() {
+        let temp = tempfile::tempdir().unwrap();
+        let root = temp.path().join("root");
+        fs::create_dir_all(root.join("logs")).unwrap();
+        let mut listed = manifest(&[]);
+        listed.files = vec![LOG_FILE.to_string()];
+        fs::write(
+            root.join(MANIFEST_FILE),
+            serde_json::to_string(&listed).unwrap(),
+        )
+        .unwrap();
+        fs::write(root.join(LOG_FILE), "log\n").unwrap();
+        assert!(unpack_dir(temp.path(), &root).is_ok());
+
+        fs::write(root.join("extra.sh"), "echo hi\n").unwrap();
+        let err = unpack_dir(temp.path(), &root).unwrap_err().to_string();
+        assert!(err.contains("not listed"), "{}", err);
+        assert!(!temp.path().join("target/extra.sh").exists());
+        fs::remove_file(root.join("extra.sh")).unwrap();
+
+        std::os::unix::fs::symlink("/etc/passwd", root.join("logs/session.raw.log")).unwrap();
+        let err = unpack_dir(temp.path(), &root).unwrap_err().to_string();
+        assert!(err.contains("link"), "{}", err);
+        assert!(!temp.path().join("target/logs").exists());
+        fs::remove_file(root.join("logs/session.raw.log")).unwrap();
+
+        fs::hard_link(root.join(LOG_FILE), root.join("session.json")).unwrap();
+        listed.files.push(SESSION_FILE.to_string());
+        fs::write(
+            root.join(MANIFEST_FILE),
+            serde_json::to_string(&listed).unwrap(),
+        )
+        .unwrap();
+        let err = unpack_dir(temp.path(), &root).unwrap_err().to_string();
+        assert!(err.contains("link"), "{}", err);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn unpack_refuses_paths_outside_the_bundle() {
+        let temp = tempfile::tempdir().unwrap();
+        let root = temp.path().join("root");
+        fs::create_dir_all(&root).unwrap();
+        fs::write(
+            root.join(MANIFEST_FILE),
+            serde_json::to_string(&manifest(&[])).unwrap(),
+        )
+        .unwrap();
+        fs::write(temp.path().join("escape"), "x").unwrap();
+        let bundle = temp.path().join("bundle.tar.gz");
+        let mut cmd = tar_command();
+        cmd.arg("-czf")
+            .arg(&bundle)
+            .arg("-C")
+            .arg(&root)
+            .arg("manifest.json")
+            .arg("-P")
+            .arg(temp.path().join("escape"));
+        run_tar(cmd, "write bundle").unwrap();
+        let target = temp.path().join("target");
+        fs::create_dir_all(&target).unwrap();
+
+        let err = unpack(&bundle, &target).unwrap_err().to_string();
+        assert!(err.contains("outside the bundle"), "{}", err);
+        assert!(fs::read_dir(&target).unwrap().next().is_none());
+
+        let mut escaping = manifest(&["../PRD.md"]);
+        escaping.files.clear();
+        fs::write(
+            root.join(MANIFEST_FILE),
+            serde_json::to_string(&escaping).unwrap(),
+        )
+        .unwrap();
+        let err = unpack_dir(temp.path(), &root).unwrap_err().to_string();
+        assert!(err.contains("outside the bundle"), "{}", err);
+    }
+
+    #[test]
+    fn read_manifest_refuses_newer_formats() {
+        let temp = tempfile::tempdir().unwrap();
+        assert!(read_manifest(temp.path()).is_err());
+
+        let mut newer = manifest(&[]);
+        newer.format = BUNDLE_FORMAT + 1;
+        fs::write(
+            temp.path().join(MANIFEST_FILE),
+            serde_json::to_string(&newer).unwrap(),
+        )
+        .unwrap();
+        let err = read_manifest(temp.path()).unwrap_err().to_string();
+        assert!(err.contains("Bundle format 2"), "{}", err);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn bundle_round_trips_through_tar_with_secrets_redacted() {
+        let temp = tempfile::tempdir().unwrap();
+        let project = temp.path().join("app");
+        fs::create_dir_all(project.join("prd")).unwrap();
+        fs::write(project.join("prd/api.md"), "- [ ] API-1 add routes\n").unwrap();
+        let log_file = temp.path().join("app.log");
+        fs::write(&log_file, "iteration 1 used deploy-4242\n").unwrap();
+        fs::write(temp.path().join("app.raw.log"), "raw output\n").unwrap();
+        let session = json!({
+            "name": "app",
+            "dir": project.display().to_string(),
+            "task_file": "prd/api.md",
+            "log_file": log_file.display().to_string(),
+            "status": "failed",
+        });
+        let config = Config::from_yaml(
+            "defaults:\n  backend: claude\nnotifications:\n  telegram_token: \"123:abc\"\n",
+        )
+        .unwrap();
+        let redactor = Redactor::new(&["deploy-[0-9]+".to_string()], &[]);
+
+        let staging = temp.path().join("staging");
+        let written = write_bundle(
+            &staging,
+            "app",
+            &session,
+            &config,
+            &redactor,
+            "2026-01-01T00:00:00+00:00",
+        )
+        .unwrap();
+        assert_eq!(written.task_files, vec!["prd/api.md"]);
+        assert!(written.files.contains(&RAW_LOG_FILE.to_string()));
+        let bundle = temp.path().join("out/app.tar.gz");
+        pack(&staging, &bundle).unwrap();
+
+        let unpacked = temp.path().join("unpacked");
+        fs::create_dir_all(&unpacked).unwrap();
+        assert_eq!(unpack(&bundle, &unpacked).unwrap(), written);
+        assert_eq!(
+            fs::read_to_string(unpacked.join(LOG_FILE)).unwrap(),
+            "iteration 1 used [REDACTED]\n"
+        );
+        assert_eq!(
+            fs::read_to_string(unpacked.join("prd/prd/api.md")).unwrap(),
+            "- [ ] API-1 add routes\n"
+        );
+        let config: Value =
+            serde_json::from_str(&fs::read_to_string(unpacked.join(CONFIG_FILE)).unwrap()).unwrap();
+        assert_eq!(config["defaults.backend"], "claude");
+        assert_eq!(config["notifications.telegram_token"], REDACTED);
+    }
+}
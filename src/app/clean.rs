@@ -0,0 +1,372 @@
+use super::loop_session::parse_since_duration;
+use super::worktree::{git_output_in_dir, git_status_in_repo};
+use super::{CliError, Deps};
+use crate::cli::CleanArgs;
+use crate::gitops;
+use crate::logging;
+use crate::state::CleanupMode;
+use serde_json::Value;
+use std::collections::BTreeSet;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+/// Branch and `.worktrees/` prefixes gralph creates: task worktrees
+/// (`task-<ID>`) and auto worktrees (`prd-<session>-<timestamp>`).
+const WORKTREE_PREFIXES: [&str; 2] = ["task-", "prd-"];
+/// Session statuses that may still own a process, logs, or a worktree.
+const ACTIVE_STATUSES: [&str; 3] = ["running", "paused", "verifying"];
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum CleanItem {
+    /// A session record, removed by its state `key` since the same name may
+    /// belong to another project too.
+    Session {
+        key: String,
+        name: String,
+        status: String,
+    },
+    Log(PathBuf),
+    Worktree(PathBuf),
+    Branch(String),
+}
+
+impl CleanItem {
+    fn describe(&self) -> String {
+        match self {
+            CleanItem::Session { name, status, .. } => format!("session {} ({})", name, status),
+            CleanItem::Log(path) => format!("log {}", path.display()),
+            CleanItem::Worktree(path) => format!("worktree {}", path.display()),
+            CleanItem::Branch(branch) => format!("branch {}", branch),
+        }
+    }
+}
+
+pub(super) fn cmd_clean(args: CleanArgs, deps: &Deps) -> Result<(), CliError> {
+    let window = parse_since_duration(&args.older_than).ok_or_else(|| {
+        CliError::Message(format!(
+            "Invalid --older-than duration: {} (expected e.g. 12h, 7d)",
+            args.older_than
+        ))
+    })?;
+    let cutoff = deps.clock().now().checked_sub(window).unwrap_or(UNIX_EPOCH);
+
+    let store = deps.state_store().in_project(&args.dir);
+    store
+        .init_state()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    let _ = store.cleanup_stale(CleanupMode::Mark);
+    let sessions = store
+        .list_session_entries()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+
+    let mut items = finished_sessions(&sessions, cutoff);
+    items.extend(old_logs(
+        &args.dir.join(".gralph"),
+        &active_session_names(&sessions),
+        cutoff,
+    ));
+    let repo_root = gitops::repo_root(&args.dir);
+    if let Some(repo_root) = &repo_root {
+        let checked_out = worktree_list(repo_root)?;
+        items.extend(orphaned_worktrees(repo_root, &checked_out.paths, cutoff));
+        items.extend(merged_branches(repo_root, &checked_out.branches, cutoff)?);
+    }
+
+    if items.is_empty() {
+        println!("Nothing to clean.");
+        return Ok(());
+    }
+    if args.dry_run {
+        for item in &items {
+            println!("Would remove {}", item.describe());
+        }
+        println!("Dry run: {} item(s) would be cleaned.", items.len());
+        return Ok(());
+    }
+
+    let mut cleaned = 0;
+    for item in &items {
+        match remove_item(item, &store, repo_root.as_deref()) {
+            Ok(()) => {
+                println!("Removed {}", item.describe());
+                cleaned += 1;
+            }
+            Err(err) => eprintln!("Warning: failed to remove {}: {}", item.describe(), err),
+        }
+    }
+    if let Some(repo_root) = &repo_root {
+        if items
+            .iter()
+            .any(|item| matches!(item, CleanItem::Worktree(_)))
+        {
+            let _ = git_status_in_repo(&repo_root.to_string_lossy(), ["worktree", "prune"]);
+        }
+    }
+    println!("Cleaned {} item(s).", cleaned);
+    Ok(())
+}
+
+fn remove_item(
+    item: &CleanItem,
+    store: &crate::state::StateStore,
+    repo_root: Option<&Path>,
+) -> Result<(), CliError> {
+    match item {
+        CleanItem::Session { key, .. } => store
+            .delete_session_key(key)
+            .map_err(|err| CliError::Message(err.to_string())),
+        CleanItem::Log(path) => fs::remove_file(path).map_err(CliError::Io),
+        CleanItem::Worktree(path) => fs::remove_dir_all(path).map_err(CliError::Io),
+        CleanItem::Branch(branch) => {
+            let repo_root = repo_root.map(Path::to_string_lossy).unwrap_or_default();
+            git_status_in_repo(&repo_root, ["branch", "-d", branch.as_str()])
+        }
+    }
+}
+
+/// Sessions that are no longer running and started at or before `cutoff`.
+/// A session without a readable `started_at` counts as old.
+fn finished_sessions(sessions: &[(String, Value)], cutoff: SystemTime) -> Vec<CleanItem> {
+    sessions
+        .iter()
+        .filter_map(|(key, session)| {
+            let name = session.get("name").and_then(Value::as_str)?;
+            let status = session
+                .get("status")
+                .and_then(Value::as_str)
+                .unwrap_or("unknown");
+            if ACTIVE_STATUSES.contains(&status) {
+                return None;
+            }
+            let started = session
+                .get("started_at")
+                .and_then(Value::as_str)
+                .and_then(|value| chrono::DateTime::parse_from_rfc3339(value).ok())
+                .map(SystemTime::from);
+            if started.is_some_and(|started| started > cutoff) {
+                return None;
+            }
+            Some(CleanItem::Session {
+                key: key.clone(),
+                name: name.to_string(),
+                status: status.to_string(),
+            })
+        })
+        .collect()
+}
+
+fn active_session_names(sessions: &[(String, Value)]) -> BTreeSet<String> {
+    sessions
+        .iter()
+        .map(|(_, session)| session)
+        .filter(|session| {
+            session
+                .get("status")
+                .and_then(Value::as_str)
+                .is_some_and(|status| ACTIVE_STATUSES.contains(&status))
+        })
+        .filter_map(|session| session.get("name").and_then(Value::as_str))
+        .map(str::to_string)
+        .collect()
+}
+
+/// Session name a log file belongs to: `demo` for `demo.log`,
+/// `demo.raw.log`, and rotated copies such as `demo.log.2`.
+fn log_session_name(file_name: &str) -> &str {
+    let name = match file_name.rsplit_once('.') {
+        Some((stem, suffix)) if suffix.chars().all(|ch| ch.is_ascii_digit()) => stem,
+        _ => file_name,
+    };
+    let name = name.strip_suffix(".log").unwrap_or(name);
+    name.strip_suffix(".raw").unwrap_or(name)
+}
+
+/// Log files in `log_dir` last modified at or before `cutoff`, skipping
+/// logs of sessions that are still active.
+fn old_logs(log_dir: &Path, active: &BTreeSet<String>, cutoff: SystemTime) -> Vec<CleanItem> {
+    let Ok(entries) = fs::read_dir(log_dir) else {
+        return Vec::new();
+    };
+    let mut logs = entries
+        .filter_map(Result::ok)
+        .filter(|entry| {
+            let name = entry.file_name();
+            let Some(name) = name.to_str() else {
+                return false;
+            };
+            logging::is_log_file_name(name)
+                && !active.contains(log_session_name(name))
+                && modified_at_or_before(&entry.path(), cutoff)
+        })
+        .map(|entry| CleanItem::Log(entry.path()))
+        .collect::<Vec<_>>();
+    logs.sort_by(|a, b| a.describe().cmp(&b.describe()));
+    logs
+}
+
+fn modified_at_or_before(path: &Path, cutoff: SystemTime) -> bool {
+    fs::metadata(path)
+        .and_then(|metadata| metadata.modified())
+        .is_ok_and(|modified| modified <= cutoff)
+}
+
+#[derive(Debug, Default)]
+struct WorktreeList {
+    paths: BTreeSet<PathBuf>,
+    branches: BTreeSet<String>,
+}
+
+/// Paths and branches of the worktrees git knows about.
+fn worktree_list(repo_root: &Path) -> Result<WorktreeList, CliError> {
+    let output = git_output_in_dir(repo_root, ["worktree", "list", "--porcelain"])?;
+    let mut list = WorktreeList::default();
+    for line in output.lines() {
+        if let Some(path) = line.strip_prefix("worktree ") {
+            let path = PathBuf::from(path);
+            list.paths.insert(fs::canonicalize(&path).unwrap_or(path));
+        } else if let Some(branch) = line.strip_prefix("branch refs/heads/") {
+            list.branches.insert(branch.to_string());
+        }
+    }
+    Ok(list)
+}
+
+fn is_gralph_name(name: &str) -> bool {
+    WORKTREE_PREFIXES
+        .iter()
+        .any(|prefix| name.starts_with(prefix))
+}
+
+/// Directories under `.worktrees/` that git no longer tracks as worktrees.
+fn orphaned_worktrees(
+    repo_root: &Path,
+    registered: &BTreeSet<PathBuf>,
+    cutoff: SystemTime,
+) -> Vec<CleanItem> {
+    let Ok(entries) = fs::read_dir(repo_root.join(".worktrees")) else {
+        return Vec::new();
+    };
+    let mut orphans = entries
+        .filter_map(Result::ok)
+        .map(|entry| entry.path())
+        .filter(|path| {
+            path.is_dir()
+                && path
+                    .file_name()
+                    .and_then(|name| name.to_str())
+                    .is_some_and(is_gralph_name)
+                && !registered.contains(&fs::canonicalize(path).unwrap_or(path.clone()))
+                && modified_at_or_before(path, cutoff)
+        })
+        .map(CleanItem::Worktree)
+        .collect::<Vec<_>>();
+    orphans.sort_by(|a, b| a.describe().cmp(&b.describe()));
+    orphans
+}
+
+/// Task and auto-worktree branches merged into HEAD whose last commit is at
+/// or before `cutoff`. Branches checked out in a worktree are kept.
+fn merged_branches(
+    repo_root: &Path,
+    checked_out: &BTreeSet<String>,
+    cutoff: SystemTime,
+) -> Result<Vec<CleanItem>, CliError> {
+    if git_output_in_dir(repo_root, ["rev-parse", "--verify", "-q", "HEAD"]).is_err() {
+        return Ok(Vec::new());
+    }
+    let output = git_output_in_dir(
+        repo_root,
+        [
+            "for-each-ref",
+            "--merged",
+            "HEAD",
+            "--format=%(refname:short) %(committerdate:unix)",
+            "refs/heads",
+        ],
+    )?;
+    Ok(output
+        .lines()
+        .filter_map(|line| {
+            let (branch, timestamp) = line.trim().rsplit_once(' ')?;
+            let committed = UNIX_EPOCH + Duration::from_secs(timestamp.parse().ok()?);
+            (is_gralph_name(branch) && !checked_out.contains(branch) && committed <= cutoff)
+                .then(|| CleanItem::Branch(branch.to_string()))
+        })
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn at(value: &str) -> SystemTime {
+        SystemTime::from(chrono::DateTime::parse_from_rfc3339(value).unwrap())
+    }
+
+    #[test]
+    fn finished_sessions_skips_active_and_recent() {
+        let sessions = [
+            json!({"name": "done", "status": "complete", "started_at": "2026-01-01T00:00:00+00:00"}),
+            json!({"name": "live", "status": "running", "started_at": "2026-01-01T00:00:00+00:00"}),
+            json!({"name": "fresh", "status": "failed", "started_at": "2026-01-09T00:00:00+00:00"}),
+            json!({"name": "old", "status": "stale"}),
+        ]
+        .into_iter()
+        .map(|session| (format!("p/{}", session["name"].as_str().unwrap()), session))
+        .collect::<Vec<_>>();
+
+        let items = finished_sessions(&sessions, at("2026-01-05T00:00:00+00:00"));
+
+        assert_eq!(
+            items,
+            vec![
+                CleanItem::Session {
+                    key: "p/done".to_string(),
+                    name: "done".to_string(),
+                    status: "complete".to_string(),
+                },
+                CleanItem::Session {
+                    key: "p/old".to_string(),
+                    name: "old".to_string(),
+                    status: "stale".to_string(),
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn log_session_name_strips_log_suffixes() {
+        assert_eq!(log_session_name("demo.log"), "demo");
+        assert_eq!(log_session_name("demo.raw.log"), "demo");
+        assert_eq!(log_session_name("demo.log.3"), "demo");
+        assert_eq!(log_session_name("demo.raw.log.1"), "demo");
+        assert_eq!(log_session_name("v1.2.log"), "v1.2");
+    }
+
+    #[test]
+    fn old_logs_keeps_active_sessions_and_other_files() {
+        let temp = tempfile::tempdir().unwrap();
+        for name in ["done.log", "done.raw.log.1", "live.log", "notes.txt"] {
+            fs::write(temp.path().join(name), "x").unwrap();
+        }
+        let active = BTreeSet::from(["live".to_string()]);
+
+        let items = old_logs(
+            temp.path(),
+            &active,
+            SystemTime::now() + Duration::from_secs(60),
+        );
+        assert_eq!(
+            items,
+            vec![
+                CleanItem::Log(temp.path().join("done.log")),
+                CleanItem::Log(temp.path().join("done.raw.log.1")),
+            ]
+        );
+
+        let items = old_logs(temp.path(), &active, UNIX_EPOCH);
+        assert!(items.is_empty());
+    }
+}
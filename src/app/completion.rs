@@ -0,0 +1,70 @@
+use super::{CliError, Deps};
+use crate::backend::BACKEND_NAMES;
+use crate::cli::CompleteArgs;
+use crate::config::Config;
+use serde_json::Value;
+use std::env;
+use std::path::PathBuf;
+
+/// Prints one completion candidate per line for the shell scripts in
+/// `completions/`. Failures print nothing: a broken state file or config
+/// should leave the shell with no suggestions, not an error mid-prompt.
+pub(super) fn cmd_complete(args: CompleteArgs, deps: &Deps) -> Result<(), CliError> {
+    for value in completion_values(&args.kind, deps) {
+        println!("{}", value);
+    }
+    Ok(())
+}
+
+fn completion_values(kind: &str, deps: &Deps) -> Vec<String> {
+    match kind {
+        "sessions" => session_names(&deps.state_store().list_sessions().unwrap_or_default()),
+        "backends" => BACKEND_NAMES.iter().map(|name| name.to_string()).collect(),
+        "config-keys" => config_keys(),
+        _ => Vec::new(),
+    }
+}
+
+fn session_names(sessions: &[Value]) -> Vec<String> {
+    let mut names: Vec<String> = sessions
+        .iter()
+        .filter_map(|session| session.get("name").and_then(Value::as_str))
+        .map(str::to_string)
+        .collect();
+    names.sort();
+    names.dedup();
+    names
+}
+
+/// Flattened keys from the default, global, and project config, as shown by
+/// `gralph config list`.
+fn config_keys() -> Vec<String> {
+    let dir = env::current_dir().unwrap_or_else(|_| PathBuf::from("."));
+    Config::load(Some(&dir))
+        .map(|config| config.list().into_iter().map(|(key, _)| key).collect())
+        .unwrap_or_default()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn session_names_are_sorted_and_unique() {
+        let sessions = vec![
+            json!({"name": "web", "status": "running"}),
+            json!({"name": "api", "status": "stopped"}),
+            json!({"status": "stale"}),
+            json!({"name": "web", "status": "stale"}),
+        ];
+        assert_eq!(session_names(&sessions), vec!["api", "web"]);
+    }
+
+    #[test]
+    fn completion_values_lists_backends_and_ignores_unknown_kinds() {
+        let deps = Deps::real();
+        assert_eq!(completion_values("backends", &deps), BACKEND_NAMES.to_vec());
+        assert!(completion_values("models", &deps).is_empty());
+    }
+}
@@ -0,0 +1,713 @@
+//! `gralph daemon`: one long-running process that owns background loops.
+//!
+//! While it runs, `gralph start` and `gralph resume` hand their `run-loop`
+//! children to it over a unix socket in the state directory instead of
+//! detaching them. The daemon is their parent, so it knows when a loop exits
+//! without probing PIDs, and records a loop that died without saying so as
+//! `failed` rather than leaving it `running` until it is marked stale.
+//!
+//! Requests and replies are one JSON object per line: `{"op":"ping"}`,
+//! `{"op":"spawn","name":...,"dir":...,"args":[...]}`, `{"op":"stop","pid":...}`,
+//! `{"op":"status"}`, `{"op":"logs","name":...,"lines":...}`, and
+//! `{"op":"shutdown"}`. Every reply has `ok`, and `error` when it is false.
+//!
+//! `spawn` runs gralph as the daemon's user, so the socket is only open to
+//! that user: it is created with mode 0600 and connections from any other
+//! uid are refused.
+
+use super::{CliError, Deps, print_json};
+use crate::cli::{DaemonArgs, DaemonCommand};
+use crate::server;
+use crate::shutdown;
+use crate::state::StateStore;
+use crate::version;
+use serde_json::{Value, json};
+use std::fs;
+use std::io::{self, BufRead, BufReader, Write};
+use std::path::{Path, PathBuf};
+use std::process::{Child, Command as ProcCommand, Stdio};
+#[cfg(unix)]
+use std::sync::atomic::{AtomicBool, Ordering};
+#[cfg(unix)]
+use std::sync::{Arc, Mutex, MutexGuard};
+use std::thread;
+use std::time::{Duration, Instant};
+
+#[cfg(unix)]
+use std::os::unix::net::{UnixListener, UnixStream};
+
+/// Socket file in the state directory.
+const SOCKET_FILE: &str = "gralphd.sock";
+const POLL_INTERVAL: Duration = Duration::from_millis(200);
+const CLIENT_TIMEOUT: Duration = Duration::from_secs(10);
+/// How long `shutdown` waits for loops to stop after SIGTERM.
+const SHUTDOWN_GRACE: Duration = Duration::from_secs(30);
+
+pub(super) fn socket_path(store: &StateStore) -> PathBuf {
+    store.state_dir().join(SOCKET_FILE)
+}
+
+pub(super) fn cmd_daemon(args: DaemonArgs, json: bool, deps: &Deps) -> Result<(), CliError> {
+    let store = deps.state_store();
+    store
+        .init_state()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    match args.command.unwrap_or(DaemonCommand::Run) {
+        DaemonCommand::Run => {
+            let exe = deps.process().current_exe().map_err(CliError::Io)?;
+            run_daemon(store, exe)
+        }
+        DaemonCommand::Stop => {
+            let client = DaemonClient::connect(&store).ok_or_else(not_running)?;
+            client.request(&json!({"op": "shutdown"}))?;
+            println!("Stopped the gralph daemon.");
+            Ok(())
+        }
+        DaemonCommand::Status => {
+            let Some(client) = DaemonClient::connect(&store) else {
+                if json {
+                    return print_json(&json!({"running": false}));
+                }
+                println!("The gralph daemon is not running.");
+                return Ok(());
+            };
+            let reply = client.request(&json!({"op": "status"}))?;
+            if json {
+                let mut reply = reply;
+                reply["running"] = Value::Bool(true);
+                return print_json(&reply);
+            }
+            print_status(&reply, &client.path);
+            Ok(())
+        }
+    }
+}
+
+fn not_running() -> CliError {
+    CliError::Message("The gralph daemon is not running (start it with `gralph daemon`).".into())
+}
+
+fn print_status(reply: &Value, socket: &Path) {
+    println!(
+        "gralph daemon running (PID: {}, socket: {})",
+        reply["pid"],
+        socket.display()
+    );
+    let loops = reply["loops"].as_array().cloned().unwrap_or_default();
+    if loops.is_empty() {
+        println!("No loops.");
+        return;
+    }
+    for entry in loops {
+        let state = if entry["running"].as_bool() == Some(true) {
+            "running".to_string()
+        } else {
+            format!("exited ({})", entry["exit_code"])
+        };
+        println!(
+            "{}  PID {}  {}  {}",
+            entry["name"].as_str().unwrap_or(""),
+            entry["pid"],
+            state,
+            entry["dir"].as_str().unwrap_or("")
+        );
+    }
+}
+
+/// A loop the daemon started.
+struct Supervised {
+    name: String,
+    dir: PathBuf,
+    child: Child,
+    /// Set once it exits.
+    exit_code: Option<i32>,
+    stop_requested: bool,
+}
+
+impl Supervised {
+    fn pid(&self) -> u32 {
+        self.child.id()
+    }
+
+    fn running(&self) -> bool {
+        self.exit_code.is_none()
+    }
+}
+
+struct Supervisor {
+    store: StateStore,
+    exe: PathBuf,
+    loops: Vec<Supervised>,
+}
+
+impl Supervisor {
+    fn new(store: StateStore, exe: PathBuf) -> Self {
+        Self {
+            store,
+            exe,
+            loops: Vec::new(),
+        }
+    }
+
+    /// Answers one request; the flag is true for `shutdown`.
+    fn handle(&mut self, request: &Value) -> (Value, bool) {
+        let result = match request["op"].as_str().unwrap_or("") {
+            "ping" => Ok(json!({"pid": std::process::id(), "version": version::VERSION})),
+            "spawn" => self.spawn(request),
+            "stop" => self.stop(request),
+            "status" => Ok(self.status()),
+            "logs" => self.logs(request),
+            "shutdown" => return (json!({"ok": true}), true),
+            op => Err(format!("Unknown request: {:?}", op)),
+        };
+        let reply = match result {
+            Ok(Value::Object(mut map)) => {
+                map.insert("ok".to_string(), Value::Bool(true));
+                Value::Object(map)
+            }
+            Ok(_) => json!({"ok": true}),
+            Err(error) => json!({"ok": false, "error": error}),
+        };
+        (reply, false)
+    }
+
+    fn spawn(&mut self, request: &Value) -> Result<Value, String> {
+        let name = request["name"]
+            .as_str()
+            .filter(|name| !name.is_empty())
+            .ok_or("spawn requires a name")?;
+        let dir = request["dir"].as_str().ok_or("spawn requires a dir")?;
+        let args: Vec<&str> = request["args"]
+            .as_array()
+            .ok_or("spawn requires args")?
+            .iter()
+            .map(|arg| arg.as_str().ok_or("args must be strings"))
+            .collect::<Result<_, _>>()?;
+        let mut cmd = ProcCommand::new(&self.exe);
+        cmd.args(&args)
+            .stdin(Stdio::null())
+            .stdout(Stdio::null())
+            .stderr(Stdio::null());
+        // A Ctrl-C on the daemon's terminal is the daemon's to handle.
+        super::loop_session::detach_from_terminal(&mut cmd);
+        let child = cmd
+            .spawn()
+            .map_err(|err| format!("Failed to start loop: {}", err))?;
+        let pid = child.id();
+        self.loops.retain(Supervised::running);
+        self.loops.push(Supervised {
+            name: name.to_string(),
+            dir: PathBuf::from(dir),
+            child,
+            exit_code: None,
+            stop_requested: false,
+        });
+        Ok(json!({"pid": pid}))
+    }
+
+    fn stop(&mut self, request: &Value) -> Result<Value, String> {
+        let pid = request["pid"].as_u64().ok_or("stop requires a pid")?;
+        let entry = self
+            .loops
+            .iter_mut()
+            .find(|entry| entry.running() && u64::from(entry.pid()) == pid)
+            .ok_or_else(|| format!("No loop with PID {}", pid))?;
+        entry.stop_requested = true;
+        terminate(entry.pid());
+        Ok(json!({}))
+    }
+
+    fn status(&self) -> Value {
+        let loops: Vec<Value> = self
+            .loops
+            .iter()
+            .map(|entry| {
+                json!({
+                    "name": entry.name,
+                    "dir": entry.dir.to_string_lossy(),
+                    "pid": entry.pid(),
+                    "running": entry.running(),
+                    "exit_code": entry.exit_code,
+                })
+            })
+            .collect();
+        json!({"pid": std::process::id(), "loops": loops})
+    }
+
+    fn logs(&self, request: &Value) -> Result<Value, String> {
+        let name = request["name"].as_str().ok_or("logs requires a name")?;
+        let lines = request["lines"]
+            .as_u64()
+            .map_or(server::DEFAULT_LOG_LINES, |lines| lines as usize);
+        let raw = request["raw"].as_bool().unwrap_or(false);
+        let (_, text, _) = server::read_session_log(&self.store, name, raw, lines)
+            .map_err(|(_, message)| message)?;
+        Ok(json!({"text": text}))
+    }
+
+    /// Collects loops that have exited. A loop records its own final status;
+    /// one whose session still says running was killed or crashed, so it is
+    /// recorded as stopped or failed here.
+    fn reap(&mut self) {
+        for entry in self.loops.iter_mut().filter(|entry| entry.running()) {
+            let Ok(Some(status)) = entry.child.try_wait() else {
+                continue;
+            };
+            entry.exit_code = Some(status.code().unwrap_or(-1));
+            let store = self.store.clone().in_project(&entry.dir);
+            let status = if entry.stop_requested {
+                "stopped"
+            } else {
+                "failed"
+            };
+            let pid = u64::from(entry.pid());
+            // Checked and written in one transaction, so a final status the loop
+            // records while exiting is never overwritten.
+            let _ = store.update_session(&entry.name, |mut fields| {
+                let live = matches!(
+                    fields.get("status").and_then(Value::as_str),
+                    Some("running" | "paused")
+                );
+                if live && fields.get("pid").and_then(Value::as_u64) == Some(pid) {
+                    fields.insert("status".to_string(), Value::from(status));
+                    fields.insert("pid".to_string(), Value::from(0));
+                }
+                fields
+            });
+        }
+    }
+
+    /// Sends SIGTERM to every running loop and waits up to `grace` for them.
+    fn shutdown(&mut self, grace: Duration) {
+        for entry in self.loops.iter_mut().filter(|entry| entry.running()) {
+            entry.stop_requested = true;
+            terminate(entry.pid());
+        }
+        let deadline = Instant::now() + grace;
+        while self.loops.iter().any(Supervised::running) && Instant::now() < deadline {
+            thread::sleep(POLL_INTERVAL);
+            self.reap();
+        }
+    }
+}
+
+fn terminate(pid: u32) {
+    #[cfg(unix)]
+    unsafe {
+        libc::kill(pid as i32, libc::SIGTERM);
+    }
+    #[cfg(not(unix))]
+    let _ = pid;
+}
+
+#[cfg(unix)]
+fn run_daemon(store: StateStore, exe: PathBuf) -> Result<(), CliError> {
+    let path = socket_path(&store);
+    if path.exists() {
+        if DaemonClient::connect(&store).is_some() {
+            return Err(CliError::Message(format!(
+                "The gralph daemon is already running ({})",
+                path.display()
+            )));
+        }
+        // Left behind by a daemon that did not shut down cleanly.
+        fs::remove_file(&path).map_err(CliError::Io)?;
+    }
+    let listener = bind_socket(&path)?;
+    shutdown::install();
+    println!(
+        "gralph daemon listening on {} (PID: {})",
+        path.display(),
+        std::process::id()
+    );
+    let supervisor = Arc::new(Mutex::new(Supervisor::new(store, exe)));
+    let result = serve(&listener, &supervisor);
+    lock(&supervisor).shutdown(SHUTDOWN_GRACE);
+    let _ = fs::remove_file(&path);
+    result.map_err(CliError::Io)
+}
+
+#[cfg(not(unix))]
+fn run_daemon(_store: StateStore, _exe: PathBuf) -> Result<(), CliError> {
+    Err(CliError::Message(
+        "gralph daemon needs unix domain sockets, which this platform lacks".to_string(),
+    ))
+}
+
+/// Binds the daemon socket with mode 0600, so only its owner can connect.
+#[cfg(unix)]
+fn bind_socket(path: &Path) -> Result<UnixListener, CliError> {
+    use std::os::unix::fs::PermissionsExt;
+
+    let listener = UnixListener::bind(path).map_err(|err| {
+        CliError::Message(format!("Failed to listen on {}: {}", path.display(), err))
+    })?;
+    fs::set_permissions(path, fs::Permissions::from_mode(0o600)).map_err(CliError::Io)?;
+    Ok(listener)
+}
+
+#[cfg(unix)]
+fn lock(supervisor: &Mutex<Supervisor>) -> MutexGuard<'_, Supervisor> {
+    supervisor
+        .lock()
+        .unwrap_or_else(|poisoned| poisoned.into_inner())
+}
+
+/// Accepts requests until a `shutdown` request or SIGINT/SIGTERM, reaping
+/// exited loops in between. Each connection is handled on its own thread, so
+/// a slow client does not hold up the others.
+#[cfg(unix)]
+fn serve(listener: &UnixListener, supervisor: &Arc<Mutex<Supervisor>>) -> io::Result<()> {
+    listener.set_nonblocking(true)?;
+    let stopping = Arc::new(AtomicBool::new(false));
+    loop {
+        if stopping.load(Ordering::SeqCst) || shutdown::requested().is_some() {
+            return Ok(());
+        }
+        match listener.accept() {
+            Ok((stream, _)) => {
+                let supervisor = Arc::clone(supervisor);
+                let stopping = Arc::clone(&stopping);
+                thread::spawn(move || match handle_connection(stream, &supervisor) {
+                    Ok(true) => stopping.store(true, Ordering::SeqCst),
+                    Ok(false) => {}
+                    Err(err) => eprintln!("gralph daemon: request failed: {}", err),
+                });
+            }
+            Err(err) if err.kind() == io::ErrorKind::WouldBlock => {
+                lock(supervisor).reap();
+                thread::sleep(POLL_INTERVAL);
+            }
+            Err(err) => return Err(err),
+        }
+    }
+}
+
+#[cfg(unix)]
+fn handle_connection(stream: UnixStream, supervisor: &Mutex<Supervisor>) -> io::Result<bool> {
+    stream.set_nonblocking(false)?;
+    let mut writer = stream.try_clone()?;
+    let uid = peer_uid(&stream)?;
+    if uid != unsafe { libc::geteuid() } {
+        writeln!(
+            writer,
+            "{}",
+            json!({"ok": false, "error": "Permission denied"})
+        )?;
+        return Err(io::Error::new(
+            io::ErrorKind::PermissionDenied,
+            format!("refused a connection from uid {}", uid),
+        ));
+    }
+    stream.set_read_timeout(Some(CLIENT_TIMEOUT))?;
+    let mut line = String::new();
+    BufReader::new(stream).read_line(&mut line)?;
+    let (reply, shutdown) = match serde_json::from_str::<Value>(&line) {
+        Ok(request) => lock(supervisor).handle(&request),
+        Err(err) => (
+            json!({"ok": false, "error": format!("Invalid request: {}", err)}),
+            false,
+        ),
+    };
+    writeln!(writer, "{}", reply)?;
+    Ok(shutdown)
+}
+
+/// The uid of the process on the other end of `stream`.
+#[cfg(any(target_os = "linux", target_os = "android"))]
+fn peer_uid(stream: &UnixStream) -> io::Result<libc::uid_t> {
+    use std::os::unix::io::AsRawFd;
+
+    let mut cred = libc::ucred {
+        pid: 0,
+        uid: 0,
+        gid: 0,
+    };
+    let mut len = std::mem::size_of::<libc::ucred>() as libc::socklen_t;
+    let result = unsafe {
+        libc::getsockopt(
+            stream.as_raw_fd(),
+            libc::SOL_SOCKET,
+            libc::SO_PEERCRED,
+            &mut cred as *mut libc::ucred as *mut libc::c_void,
+            &mut len,
+        )
+    };
+    if result != 0 {
+        return Err(io::Error::last_os_error());
+    }
+    Ok(cred.uid)
+}
+
+#[cfg(any(
+    target_os = "macos",
+    target_os = "ios",
+    target_os = "freebsd",
+    target_os = "openbsd",
+    target_os = "netbsd",
+    target_os = "dragonfly"
+))]
+fn peer_uid(stream: &UnixStream) -> io::Result<libc::uid_t> {
+    use std::os::unix::io::AsRawFd;
+
+    let mut uid = 0;
+    let mut gid = 0;
+    if unsafe { libc::getpeereid(stream.as_raw_fd(), &mut uid, &mut gid) } != 0 {
+        return Err(io::Error::last_os_error());
+    }
+    Ok(uid)
+}
+
+#[cfg(all(
+    unix,
+    not(any(
+        target_os = "linux",
+        target_os = "android",
+        target_os = "macos",
+        target_os = "ios",
+        target_os = "freebsd",
+        target_os = "openbsd",
+        target_os = "netbsd",
+        target_os = "dragonfly"
+    ))
+))]
+fn peer_uid(_stream: &UnixStream) -> io::Result<libc::uid_t> {
+    Err(io::Error::new(
+        io::ErrorKind::Unsupported,
+        "cannot check the peer of a unix socket on this platform",
+    ))
+}
+
+/// Connection to a running daemon.
+pub(super) struct DaemonClient {
+    path: PathBuf,
+}
+
+impl DaemonClient {
+    /// The daemon for `store`'s state directory, if one answers.
+    pub(super) fn connect(store: &StateStore) -> Option<Self> {
+        let client = Self {
+            path: socket_path(store),
+        };
+        if !client.path.exists() {
+            return None;
+        }
+        client.request(&json!({"op": "ping"})).ok()?;
+        Some(client)
+    }
+
+    /// Starts `gralph <args>` under the daemon and returns its PID.
+    pub(super) fn spawn(&self, name: &str, dir: &Path, args: &[String]) -> Result<u32, CliError> {
+        let reply = self.request(&json!({
+            "op": "spawn",
+            "name": name,
+            "dir": dir.to_string_lossy(),
+            "args": args,
+        }))?;
+        reply["pid"]
+            .as_u64()
+            .map(|pid| pid as u32)
+            .ok_or_else(|| CliError::Message("gralph daemon returned no PID".to_string()))
+    }
+
+    pub(super) fn stop(&self, pid: i64) -> Result<(), CliError> {
+        self.request(&json!({"op": "stop", "pid": pid})).map(|_| ())
+    }
+
+    #[cfg(unix)]
+    pub(super) fn request(&self, request: &Value) -> Result<Value, CliError> {
+        let stream = UnixStream::connect(&self.path).map_err(CliError::Io)?;
+        stream
+            .set_read_timeout(Some(CLIENT_TIMEOUT))
+            .map_err(CliError::Io)?;
+        let mut writer = stream.try_clone().map_err(CliError::Io)?;
+        writeln!(writer, "{}", request).map_err(CliError::Io)?;
+        let mut line = String::new();
+        BufReader::new(stream)
+            .read_line(&mut line)
+            .map_err(CliError::Io)?;
+        let reply: Value = serde_json::from_str(&line).map_err(|err| {
+            CliError::Message(format!("Invalid reply from the gralph daemon: {}", err))
+        })?;
+        if reply["ok"].as_bool() != Some(true) {
+            return Err(CliError::Message(
+                reply["error"]
+                    .as_str()
+                    .unwrap_or("gralph daemon request failed")
+                    .to_string(),
+            ));
+        }
+        Ok(reply)
+    }
+
+    #[cfg(not(unix))]
+    pub(super) fn request(&self, _request: &Value) -> Result<Value, CliError> {
+        Err(CliError::Message(
+            "gralph daemon needs unix domain sockets, which this platform lacks".to_string(),
+        ))
+    }
+}
+
+#[cfg(all(test, unix))]
+mod tests {
+    use super::*;
+
+    fn store_for_test(dir: &Path) -> StateStore {
+        let state_dir = dir.join("state");
+        StateStore::with_paths(
+            state_dir.clone(),
+            state_dir.join("state.json"),
+            state_dir.join("state.lock"),
+            Duration::from_secs(1),
+        )
+    }
+
+    fn wait_until_exited(supervisor: &mut Supervisor) {
+        let deadline = Instant::now() + Duration::from_secs(10);
+        while supervisor.loops.iter().any(Supervised::running) && Instant::now() < deadline {
+            thread::sleep(Duration::from_millis(20));
+            supervisor.reap();
+        }
+    }
+
+    fn spawn(supervisor: &mut Supervisor, name: &str, dir: &Path, script: &str) -> u64 {
+        let (reply, _) = supervisor.handle(&json!({
+            "op": "spawn",
+            "name": name,
+            "dir": dir.to_string_lossy(),
+            "args": ["-c", script],
+        }));
+        assert_eq!(reply["ok"], true, "{}", reply);
+        reply["pid"].as_u64().unwrap()
+    }
+
+    #[test]
+    fn reap_marks_loops_that_exit_while_running() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path());
+        store.init_state().unwrap();
+        let mut supervisor = Supervisor::new(store.clone(), PathBuf::from("/bin/sh"));
+        let dir = temp.path().to_string_lossy().to_string();
+
+        let crashed = spawn(&mut supervisor, "crashed", temp.path(), "exit 3");
+        let finished = spawn(&mut supervisor, "finished", temp.path(), "exit 0");
+        for (name, pid, status) in [
+            ("crashed", crashed, "running"),
+            ("finished", finished, "complete"),
+        ] {
+            store
+                .set_session(
+                    name,
+                    &[
+                        ("dir", dir.as_str()),
+                        ("pid", &pid.to_string()),
+                        ("status", status),
+                    ],
+                )
+                .unwrap();
+        }
+        wait_until_exited(&mut supervisor);
+
+        let session = |name: &str| store.get_session(name).unwrap().unwrap();
+        assert_eq!(session("crashed")["status"], "failed");
+        assert_eq!(session("crashed")["pid"], 0);
+        assert_eq!(session("finished")["status"], "complete");
+        let status = supervisor.status();
+        assert_eq!(status["loops"][0]["exit_code"], 3);
+        assert_eq!(status["loops"][0]["running"], false);
+    }
+
+    #[test]
+    fn stop_terminates_the_loop_and_records_it_stopped() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path());
+        store.init_state().unwrap();
+        let mut supervisor = Supervisor::new(store.clone(), PathBuf::from("/bin/sh"));
+        let pid = spawn(&mut supervisor, "slow", temp.path(), "sleep 30");
+        store
+            .set_session(
+                "slow",
+                &[
+                    ("dir", temp.path().to_str().unwrap()),
+                    ("pid", &pid.to_string()),
+                    ("status", "running"),
+                ],
+            )
+            .unwrap();
+
+        let (reply, _) = supervisor.handle(&json!({"op": "stop", "pid": pid}));
+        assert_eq!(reply["ok"], true);
+        wait_until_exited(&mut supervisor);
+
+        assert_eq!(
+            store.get_session("slow").unwrap().unwrap()["status"],
+            "stopped"
+        );
+        let (reply, _) = supervisor.handle(&json!({"op": "stop", "pid": pid}));
+        assert_eq!(reply["ok"], false);
+        assert_eq!(reply["error"], format!("No loop with PID {}", pid));
+    }
+
+    #[test]
+    fn client_talks_to_the_daemon_over_its_socket() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path());
+        store.init_state().unwrap();
+        let listener = bind_socket(&socket_path(&store)).unwrap();
+        let daemon_store = store.clone();
+        let daemon = thread::spawn(move || {
+            let supervisor = Arc::new(Mutex::new(Supervisor::new(
+                daemon_store,
+                PathBuf::from("/bin/sh"),
+            )));
+            serve(&listener, &supervisor)
+        });
+
+        let client = DaemonClient::connect(&store).expect("daemon should answer");
+        let pid = client.spawn("job", temp.path(), &["-c".into(), "exit 0".into()]);
+        assert!(pid.unwrap() > 0);
+        let status = client.request(&json!({"op": "status"})).unwrap();
+        assert_eq!(status["loops"][0]["name"], "job");
+        let err = client.request(&json!({"op": "bogus"})).unwrap_err();
+        assert_eq!(err.to_string(), "Unknown request: \"bogus\"");
+        client.request(&json!({"op": "shutdown"})).unwrap();
+
+        daemon.join().unwrap().unwrap();
+    }
+
+    #[test]
+    fn socket_is_private_and_a_silent_client_does_not_block_others() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path());
+        store.init_state().unwrap();
+        let path = socket_path(&store);
+        let listener = bind_socket(&path).unwrap();
+        assert_eq!(
+            fs::metadata(&path).unwrap().permissions().mode() & 0o777,
+            0o600
+        );
+        let daemon_store = store.clone();
+        let daemon = thread::spawn(move || {
+            let supervisor = Arc::new(Mutex::new(Supervisor::new(
+                daemon_store,
+                PathBuf::from("/bin/sh"),
+            )));
+            serve(&listener, &supervisor)
+        });
+
+        // Connects and never sends its request.
+        let silent = UnixStream::connect(&path).unwrap();
+        assert_eq!(peer_uid(&silent).unwrap(), unsafe { libc::geteuid() });
+        thread::sleep(Duration::from_millis(100));
+        let started = Instant::now();
+        let client = DaemonClient::connect(&store).expect("daemon should answer");
+        client.request(&json!({"op": "status"})).unwrap();
+        assert!(started.elapsed() < CLIENT_TIMEOUT / 2);
+        client.request(&json!({"op": "shutdown"})).unwrap();
+
+        daemon.join().unwrap().unwrap();
+        drop(silent);
+    }
+}
@@ -0,0 +1,143 @@
+use super::picker::{self, Pick, PickerEntry};
+use super::{CliError, Deps};
+use crate::cli::DiffArgs;
+use crate::gitops;
+use crate::history::{self, HistoryRecord};
+use serde_json::Value;
+use std::path::{Path, PathBuf};
+
+/// Prints what a session changed: the diff from the commit it started at to
+/// `HEAD`, or only the commits that name `--task`.
+pub(super) fn cmd_diff(args: DiffArgs, deps: &Deps) -> Result<(), CliError> {
+    let store = deps.state_store();
+    store
+        .init_state()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    let name = match args.name.clone() {
+        Some(name) => name,
+        None => {
+            let sessions = store
+                .list_sessions()
+                .map_err(|err| CliError::Message(err.to_string()))?;
+            let entries = sessions
+                .iter()
+                .filter_map(PickerEntry::from_session)
+                .collect::<Vec<_>>();
+            match picker::pick_on_terminal(&entries, "show changes for", false)? {
+                Some(Pick::Session(name)) => name,
+                _ => return Err(CliError::Message("Session name is required.".to_string())),
+            }
+        }
+    };
+    let session = store
+        .get_session(&name)
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .ok_or_else(|| CliError::Message(format!("Session not found: {}", name)))?;
+    let dir = session
+        .get("dir")
+        .and_then(Value::as_str)
+        .filter(|dir| !dir.is_empty())
+        .map(PathBuf::from)
+        .ok_or_else(|| CliError::Message(format!("Session has no directory: {}", name)))?;
+    let records =
+        history::read_records(&history::history_path(store.state_dir())).unwrap_or_default();
+    let base = start_commit(&name, &session, &records).ok_or_else(|| {
+        CliError::Message(format!("No start commit recorded for session: {}", name))
+    })?;
+
+    let output = session_diff(&dir, &base, args.task.as_deref(), args.stat)?;
+    if !output.trim().is_empty() {
+        print!("{}", output);
+    } else if let Some(task) = &args.task {
+        println!("No commits for {} since {}.", task, short_hash(&base));
+    } else {
+        println!("No changes since {}.", short_hash(&base));
+    }
+    Ok(())
+}
+
+/// The commit the session started from: recorded in state by the loop, or
+/// taken from the session's latest history record for older sessions.
+fn start_commit(name: &str, session: &Value, records: &[HistoryRecord]) -> Option<String> {
+    session
+        .get("git_start")
+        .and_then(Value::as_str)
+        .filter(|commit| !commit.is_empty())
+        .map(str::to_string)
+        .or_else(|| {
+            records
+                .iter()
+                .rev()
+                .filter(|record| record.session == name)
+                .find_map(|record| record.git_start.clone())
+        })
+}
+
+fn session_diff(
+    dir: &Path,
+    base: &str,
+    task: Option<&str>,
+    stat_only: bool,
+) -> Result<String, CliError> {
+    let git_error = |err: gitops::GitError| CliError::Message(err.to_string());
+    match task {
+        Some(task) => {
+            let commits = gitops::task_commits(dir, base, task).map_err(git_error)?;
+            if commits.is_empty() {
+                return Ok(String::new());
+            }
+            gitops::show_commits(dir, &commits, stat_only).map_err(git_error)
+        }
+        None => gitops::diff_range(dir, base, stat_only).map_err(git_error),
+    }
+}
+
+fn short_hash(commit: &str) -> String {
+    commit.chars().take(7).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn record(session: &str, git_start: Option<&str>) -> HistoryRecord {
+        HistoryRecord {
+            session: session.to_string(),
+            dir: "/tmp/app".to_string(),
+            status: "complete".to_string(),
+            iterations: 3,
+            max_iterations: 30,
+            remaining_tasks: 0,
+            duration_secs: 60,
+            cost_usd: None,
+            backend: "claude".to_string(),
+            model: None,
+            started_at: "2026-01-01T00:00:00Z".to_string(),
+            finished_at: "2026-01-01T00:01:00Z".to_string(),
+            git_start: git_start.map(str::to_string),
+            git_end: None,
+        }
+    }
+
+    #[test]
+    fn start_commit_prefers_state_then_latest_history() {
+        let records = vec![
+            record("app", Some("aaaa")),
+            record("app", Some("bbbb")),
+            record("web", Some("cccc")),
+            record("app", None),
+        ];
+        let session = json!({"name": "app", "git_start": "ffff"});
+        assert_eq!(
+            start_commit("app", &session, &records).as_deref(),
+            Some("ffff")
+        );
+        let session = json!({"name": "app", "git_start": ""});
+        assert_eq!(
+            start_commit("app", &session, &records).as_deref(),
+            Some("bbbb")
+        );
+        assert_eq!(start_commit("api", &json!({}), &records), None);
+    }
+}
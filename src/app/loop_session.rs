@@ -1,49 +1,118 @@
-use super::{CliError, Deps, FileSystem, ProcessRunner};
-use crate::backend::backend_from_name;
+use super::daemon;
+use super::notify_queue::{self, PendingNotification};
+use super::picker::{self, Pick, PickerEntry};
+use super::worktree::TaskWorktreeBackend;
+use super::{CliError, Deps, FileSystem, ProcessRunner, print_json};
+use crate::backend::cassette::Cassette;
+use crate::backend::fallback::{self, FallbackBackend, FallbackEntry};
+use crate::backend::{
+    Backend, Usage, backend_from_config, ensure_network_allowed, ensure_variant_supported,
+    with_heartbeat,
+};
+use crate::checkpoint;
 use crate::cli::{
-    CleanupArgs, LogsArgs, ResumeArgs, RunLoopArgs, StartArgs, StatusArgs, StepArgs, StopArgs,
+    CleanupArgs, HistoryArgs, LogsArgs, PauseArgs, ResumeArgs, RunLoopArgs, RunTaskArgs, StartArgs,
+    StatusArgs, StepArgs, StopArgs,
 };
-use crate::config::Config;
+use crate::config::{self, Config};
 use crate::core::{self, LoopStatus};
+use crate::gitops;
+use crate::history;
+use crate::logging::{self, Level, LogSettings, Logger};
 use crate::notify;
 use crate::prd;
+use crate::redact::Redactor;
+use crate::remote;
+use crate::shutdown;
 use crate::state::{CleanupMode, StateStore};
+use crate::task::is_unchecked_line;
 use crate::update;
 use crate::verifier;
+use regex::Regex;
 use serde_json::{Map, Value};
+use std::cell::Cell;
 use std::env;
 use std::fs;
 use std::io::{self, Read, Seek, SeekFrom, Write};
 use std::path::{Path, PathBuf};
 use std::process::{Command as ProcCommand, Stdio};
-use std::time::Duration;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+/// How often a running iteration refreshes the session's `last_activity`.
+const HEARTBEAT_INTERVAL: Duration = Duration::from_secs(30);
 
-pub(super) fn cmd_start(args: StartArgs, deps: &Deps) -> Result<(), CliError> {
+pub(super) fn cmd_start(mut args: StartArgs, deps: &Deps) -> Result<(), CliError> {
     if !args.dir.is_dir() {
         return Err(CliError::Message(format!(
             "Directory does not exist: {}",
             args.dir.display()
         )));
     }
+    args.dir = super::workspace_dir(&args.dir, args.workspace.as_deref())?;
+    // Sessions are keyed by project path, so store it the same way from
+    // wherever the command was run.
+    args.dir = args.dir.canonicalize().unwrap_or(args.dir);
+    args.profile = args.profile.or_else(config::active_profile);
     if args.dry_run {
         return cmd_start_dry_run(args, deps);
     }
     let no_tmux = args.no_tmux;
     let session_name = super::session_name(&args.name, &args.dir)?;
-    let config = Config::load(Some(&args.dir)).map_err(|err| CliError::Message(err.to_string()))?;
+    let config = Config::load_with_profile(Some(&args.dir), args.profile.as_deref())
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .with_offline(deps.offline())
+        .with_cassette(deps.cassette().cloned());
     let mut run_args = run_loop_args_from_start(args, session_name)?;
+    // Check what the spawned loop would reject before it leaves the terminal.
+    let backend_chain = resolve_backend_chain(&run_args, &config);
+    let backend_name = &backend_chain[0];
+    let backend = backend_from_config(backend_name, &config, &run_args.backend_args)
+        .map_err(CliError::Message)?;
+    ensure_network_allowed(backend.as_ref(), config.offline()).map_err(CliError::Message)?;
+    ensure_variant_supported(backend.as_ref(), run_args.variant.as_deref())
+        .map_err(CliError::Message)?;
+    resolve_budget(&run_args, &config)?;
+    if should_preflight(&config) {
+        let model = resolve_model(&run_args, &config, backend_name);
+        if let Err(err) = preflight_backend(backend.as_ref(), model.as_deref(), &run_args.dir) {
+            // The loop can start on a fallback, so only fail when none works.
+            if !preflight_fallbacks(&backend_chain[1..], &config, &run_args.dir) {
+                return Err(err);
+            }
+            eprintln!("Warning: {}", err);
+        }
+        if let Some(name) = run_args.review_backend.as_deref() {
+            let review_backend =
+                backend_from_config(name, &config, &[]).map_err(CliError::Message)?;
+            preflight_backend(
+                review_backend.as_ref(),
+                run_args.review_model.as_deref(),
+                &run_args.dir,
+            )?;
+        }
+    }
     deps.worktree()
         .maybe_create_auto_worktree(&mut run_args, &config)?;
     if no_tmux {
+        shutdown::install();
         return run_loop_with_state(run_args, deps);
     }
 
-    let child = spawn_run_loop(&run_args, deps.process())?;
-
-    let store = deps.state_store();
+    let store = deps.state_store().in_project(&run_args.dir);
     store
         .init_state()
         .map_err(|err| CliError::Message(err.to_string()))?;
+    if !run_args.allow_concurrent {
+        ensure_dir_available(&store, &run_args.dir, deps.process())?;
+    }
+    let (pid, supervised) = launch_run_loop(
+        &store,
+        &run_args,
+        deps.process(),
+        deps.offline(),
+        deps.cassette(),
+    )?;
+
     let now = format_rfc3339(deps.clock());
     let task_file = run_args
         .task_file
@@ -54,7 +123,7 @@ pub(super) fn cmd_start(args: StartArgs, deps: &Deps) -> Result<(), CliError> {
         .clone()
         .unwrap_or_else(|| "COMPLETE".to_string());
     let max_iterations = run_args.max_iterations.unwrap_or(30);
-    let remaining = core::count_remaining_tasks(&run_args.dir.join(&task_file));
+    let remaining = core::count_remaining_for(&run_args.dir, &task_file);
     let log_file = run_args
         .dir
         .join(".gralph")
@@ -67,8 +136,9 @@ pub(super) fn cmd_start(args: StartArgs, deps: &Deps) -> Result<(), CliError> {
             &[
                 ("dir", &run_args.dir.to_string_lossy()),
                 ("task_file", &task_file),
-                ("pid", &child.id().to_string()),
+                ("pid", &pid.to_string()),
                 ("tmux_session", ""),
+                ("daemon", if supervised { "true" } else { "false" }),
                 ("started_at", &now),
                 ("iteration", "1"),
                 ("max_iterations", &max_iterations.to_string()),
@@ -80,12 +150,45 @@ pub(super) fn cmd_start(args: StartArgs, deps: &Deps) -> Result<(), CliError> {
                 ("backend", run_args.backend.as_deref().unwrap_or("claude")),
                 ("model", run_args.model.as_deref().unwrap_or("")),
                 ("variant", run_args.variant.as_deref().unwrap_or("")),
+                ("backend_args", &shell_words::join(&run_args.backend_args)),
+                ("max_cost", &optional_field(run_args.max_cost)),
+                ("max_tokens", &optional_field(run_args.max_tokens)),
+                (
+                    "max_duration",
+                    run_args.max_duration.as_deref().unwrap_or(""),
+                ),
+                (
+                    "review_backend",
+                    run_args.review_backend.as_deref().unwrap_or(""),
+                ),
+                (
+                    "review_model",
+                    run_args.review_model.as_deref().unwrap_or(""),
+                ),
                 ("webhook", run_args.webhook.as_deref().unwrap_or("")),
+                ("worktree", if run_args.worktree { "true" } else { "false" }),
+                ("profile", run_args.profile.as_deref().unwrap_or("")),
+                ("offline", if deps.offline() { "true" } else { "false" }),
+                (
+                    "pid_file",
+                    &run_args
+                        .pid_file
+                        .as_ref()
+                        .map(|path| path.to_string_lossy().into_owned())
+                        .unwrap_or_default(),
+                ),
             ],
         )
         .map_err(|err| CliError::Message(err.to_string()))?;
 
-    println!("Gralph loop started in background (PID: {}).", child.id());
+    if supervised {
+        println!("Gralph loop started by the gralph daemon (PID: {}).", pid);
+    } else {
+        println!("Gralph loop started in background (PID: {}).", pid);
+    }
+    if let Some(pid_file) = run_args.pid_file.as_ref() {
+        println!("PID file: {}", pid_file.display());
+    }
     println!("Logs: {}", log_file.display());
     println!(
         "Tail logs: gralph logs {} --follow (or tail -f {}).",
@@ -98,15 +201,27 @@ pub(super) fn cmd_start(args: StartArgs, deps: &Deps) -> Result<(), CliError> {
 
 fn cmd_start_dry_run(args: StartArgs, deps: &Deps) -> Result<(), CliError> {
     let session_name = super::session_name(&args.name, &args.dir)?;
-    let config = Config::load(Some(&args.dir)).map_err(|err| CliError::Message(err.to_string()))?;
+    let config = Config::load_with_profile(Some(&args.dir), args.profile.as_deref())
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .with_cassette(deps.cassette().cloned());
     let run_args = run_loop_args_from_start(args, session_name)?;
-    let task_file = resolve_task_file(&run_args, &config);
+    let task_spec = resolve_task_file(&run_args, &config);
+    let task_files = resolve_task_files(&run_args.dir, &task_spec)?;
+    let task_file = core::active_task_file(&run_args.dir, &task_spec);
     let max_iterations = resolve_max_iterations(&run_args, &config);
     let completion_marker = resolve_completion_marker(&run_args, &config);
+    let backend_name = resolve_backend_name(&run_args, &config);
+    let model = resolve_model(&run_args, &config, &backend_name);
+    let backend = backend_from_config(&backend_name, &config, &run_args.backend_args)
+        .map_err(CliError::Message)?;
+    ensure_variant_supported(backend.as_ref(), run_args.variant.as_deref())
+        .map_err(CliError::Message)?;
 
     if should_validate_prd(run_args.strict_prd) {
-        prd::prd_validate_file(&run_args.dir.join(&task_file), false, Some(&run_args.dir))
-            .map_err(|err| CliError::Message(err.to_string()))?;
+        for file in &task_files {
+            prd::prd_validate_file(&run_args.dir.join(file), false, Some(&run_args.dir))
+                .map_err(|err| CliError::Message(err.to_string()))?;
+        }
     }
 
     let prompt_template = match &run_args.prompt_template {
@@ -122,9 +237,18 @@ fn cmd_start_dry_run(args: StartArgs, deps: &Deps) -> Result<(), CliError> {
         &completion_marker,
         prompt_template.as_deref(),
         Some(&config),
+        Some(&backend_name),
+        None,
     )
     .map_err(|err| CliError::Message(err.to_string()))?;
+    for warning in &rendered.context_warnings {
+        eprintln!("Warning: {}", warning);
+    }
 
+    if task_files.len() > 1 {
+        println!("Task files (in order): {}", task_files.join(", "));
+        println!();
+    }
     println!("Next task block:");
     if let Some(block) = rendered.task_block {
         println!("{}", block);
@@ -134,29 +258,105 @@ fn cmd_start_dry_run(args: StartArgs, deps: &Deps) -> Result<(), CliError> {
     println!();
     println!("Resolved prompt:");
     println!("{}", rendered.prompt);
+    println!();
+    println!("Backend command:");
+    println!(
+        "{}",
+        backend.describe_invocation(model.as_deref(), run_args.variant.as_deref())
+    );
+    let backend_chain = resolve_backend_chain(&run_args, &config);
+    if backend_chain.len() > 1 {
+        println!();
+        println!("Fallback backends: {}", backend_chain[1..].join(", "));
+    }
     Ok(())
 }
 
-pub(super) fn cmd_step(args: StepArgs, deps: &Deps) -> Result<(), CliError> {
+pub(super) fn cmd_step(mut args: StepArgs, deps: &Deps) -> Result<(), CliError> {
     if !args.dir.is_dir() {
         return Err(CliError::Message(format!(
             "Directory does not exist: {}",
             args.dir.display()
         )));
     }
+    args.dir = args.dir.canonicalize().unwrap_or(args.dir);
     let session_name = super::session_name(&args.name, &args.dir)?;
-    let config = Config::load(Some(&args.dir)).map_err(|err| CliError::Message(err.to_string()))?;
+    let config = Config::load(Some(&args.dir))
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .with_offline(deps.offline())
+        .with_cassette(deps.cassette().cloned());
     let mut run_args = run_loop_args_from_step(args, session_name)?;
     deps.worktree()
         .maybe_create_auto_worktree(&mut run_args, &config)?;
     run_single_iteration(run_args, &config, deps)
 }
 
+pub(super) fn cmd_run_task(args: RunTaskArgs, deps: &Deps) -> Result<(), CliError> {
+    let dir = args
+        .dir
+        .clone()
+        .unwrap_or_else(|| env::current_dir().unwrap_or_else(|_| PathBuf::from(".")));
+    if !dir.is_dir() {
+        return Err(CliError::Message(format!(
+            "Directory does not exist: {}",
+            dir.display()
+        )));
+    }
+    let dir = dir.canonicalize().unwrap_or(dir);
+    let task_id = args.id.trim().to_string();
+    if task_id.is_empty() {
+        return Err(CliError::Message("Task ID is required".to_string()));
+    }
+    let session_name = match &args.name {
+        Some(_) => super::session_name(&args.name, &dir)?,
+        None => format!(
+            "{}-{}",
+            super::session_name(&None, &dir)?,
+            super::sanitize_session_name(&task_id.to_lowercase())
+        ),
+    };
+    let config = Config::load(Some(&dir))
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .with_offline(deps.offline())
+        .with_cassette(deps.cassette().cloned());
+    let run_args = run_loop_args_from_run_task(args, dir, session_name);
+    run_task_with_state(run_args, &task_id, &config, deps)
+}
+
 pub(super) fn cmd_run_loop(mut args: RunLoopArgs, deps: &Deps) -> Result<(), CliError> {
-    let config = Config::load(Some(&args.dir)).map_err(|err| CliError::Message(err.to_string()))?;
+    args.dir = args.dir.canonicalize().unwrap_or(args.dir);
+    args.profile = args.profile.or_else(config::active_profile);
+    let config = Config::load_with_profile(Some(&args.dir), args.profile.as_deref())
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    shutdown::install();
+    let pid_file = args.pid_file.clone();
+    if let Some(path) = pid_file.as_ref() {
+        write_pid_file(path, deps.process().pid())?;
+    }
     deps.worktree()
         .maybe_create_auto_worktree(&mut args, &config)?;
-    run_loop_with_state(args, deps)
+    let result = run_loop_with_state(args, deps);
+    if let Some(path) = pid_file.as_ref() {
+        remove_pid_file(path, deps.process().pid());
+    }
+    result
+}
+
+fn write_pid_file(path: &Path, pid: u32) -> Result<(), CliError> {
+    if let Some(parent) = path.parent() {
+        fs::create_dir_all(parent).map_err(CliError::Io)?;
+    }
+    fs::write(path, format!("{}\n", pid)).map_err(CliError::Io)
+}
+
+/// Remove the PID file unless a newer loop has already taken it over.
+fn remove_pid_file(path: &Path, pid: u32) {
+    let owned = fs::read_to_string(path)
+        .map(|contents| contents.trim() == pid.to_string())
+        .unwrap_or(false);
+    if owned {
+        let _ = fs::remove_file(path);
+    }
 }
 
 pub(super) fn cmd_stop(args: StopArgs, deps: &Deps) -> Result<(), CliError> {
@@ -178,9 +378,28 @@ pub(super) fn cmd_stop(args: StopArgs, deps: &Deps) -> Result<(), CliError> {
         return Ok(());
     }
 
-    let name = args
-        .name
-        .ok_or_else(|| CliError::Message("Session name is required.".to_string()))?;
+    let name = match args.name {
+        Some(name) => name,
+        None => {
+            let sessions = store
+                .list_sessions()
+                .map_err(|err| CliError::Message(err.to_string()))?;
+            let running = sessions
+                .iter()
+                .filter(|session| {
+                    matches!(
+                        session.get("status").and_then(|v| v.as_str()),
+                        Some("running" | "paused")
+                    )
+                })
+                .filter_map(PickerEntry::from_session)
+                .collect::<Vec<_>>();
+            match picker::pick_on_terminal(&running, "stop", false)? {
+                Some(Pick::Session(name)) => name,
+                _ => return Err(CliError::Message("Session name is required.".to_string())),
+            }
+        }
+    };
     let session = store
         .get_session(&name)
         .map_err(|err| CliError::Message(err.to_string()))?
@@ -191,41 +410,102 @@ pub(super) fn cmd_stop(args: StopArgs, deps: &Deps) -> Result<(), CliError> {
     Ok(())
 }
 
-pub(super) fn cmd_status(args: StatusArgs, deps: &Deps) -> Result<(), CliError> {
+pub(super) fn cmd_pause(args: PauseArgs, deps: &Deps) -> Result<(), CliError> {
+    let (session, pause_file) = session_pause_file(&args.name, deps)?;
+    let status = session
+        .get("status")
+        .and_then(|v| v.as_str())
+        .unwrap_or("unknown");
+    if !matches!(status, "running" | "paused") {
+        return Err(CliError::Message(format!(
+            "Session is not running: {} ({})",
+            args.name, status
+        )));
+    }
+    if let Some(parent) = pause_file.parent() {
+        fs::create_dir_all(parent).map_err(CliError::Io)?;
+    }
+    fs::write(&pause_file, format_rfc3339(deps.clock())).map_err(CliError::Io)?;
+    println!(
+        "Pause requested: {} (loop pauses after the current iteration)",
+        args.name
+    );
+    Ok(())
+}
+
+pub(super) fn cmd_unpause(args: PauseArgs, deps: &Deps) -> Result<(), CliError> {
+    let (_, pause_file) = session_pause_file(&args.name, deps)?;
+    if !pause_file.exists() {
+        println!("Session is not paused: {}", args.name);
+        return Ok(());
+    }
+    fs::remove_file(&pause_file).map_err(CliError::Io)?;
+    println!("Unpaused session: {}", args.name);
+    Ok(())
+}
+
+fn session_pause_file(name: &str, deps: &Deps) -> Result<(Value, PathBuf), CliError> {
+    let store = deps.state_store();
+    store
+        .init_state()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    let session = store
+        .get_session(name)
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .ok_or_else(|| CliError::Message(format!("Session not found: {}", name)))?;
+    let dir = session
+        .get("dir")
+        .and_then(|v| v.as_str())
+        .filter(|dir| !dir.trim().is_empty())
+        .ok_or_else(|| CliError::Message(format!("Missing dir for session {}", name)))?;
+    let pause_file = core::pause_file_path(Path::new(dir), Some(name));
+    Ok((session, pause_file))
+}
+
+pub(super) fn cmd_status(args: StatusArgs, json: bool, deps: &Deps) -> Result<(), CliError> {
     let store = deps.state_store();
     store
         .init_state()
         .map_err(|err| CliError::Message(err.to_string()))?;
     let _ = store.cleanup_stale(CleanupMode::Mark);
+    if args.tmux {
+        return super::tmux::cmd_status_tmux(&store, json, deps);
+    }
 
-    let sessions = store
+    let mut sessions = store
         .list_sessions()
         .map_err(|err| CliError::Message(err.to_string()))?;
-    if sessions.is_empty() {
-        if args.json {
-            let output = serde_json::json!({"sessions": []});
-            let rendered =
-                serde_json::to_string(&output).map_err(|err| CliError::Message(err.to_string()))?;
-            println!("{}", rendered);
+    let project = if args.global { None } else { status_project() };
+    let mut hidden = 0;
+    if let Some(root) = &project {
+        let total = sessions.len();
+        sessions.retain(|session| session_in_project(session, root));
+        hidden = total - sessions.len();
+    }
+    let mut enriched = sessions
+        .into_iter()
+        .map(|session| enrich_status_session(session, deps.process()))
+        .collect::<Vec<_>>();
+    if !args.local && project.is_none() {
+        enriched.extend(remote_status_sessions(deps.offline()));
+    }
+    if enriched.is_empty() {
+        if json {
+            print_json(&serde_json::json!({"sessions": []}))?;
+        } else if let Some(root) = &project {
+            println!("No sessions found for {}.", root.display());
+            print_hidden_sessions(hidden);
         } else {
             println!("No sessions found.");
         }
         return Ok(());
     }
 
-    let enriched = sessions
-        .into_iter()
-        .map(|session| enrich_status_session(session, deps.process()))
-        .collect::<Vec<_>>();
-
-    if args.json {
-        let output = serde_json::json!({"sessions": enriched});
-        let rendered =
-            serde_json::to_string(&output).map_err(|err| CliError::Message(err.to_string()))?;
-        println!("{}", rendered);
-        return Ok(());
+    if json {
+        return print_json(&serde_json::json!({"sessions": enriched}));
     }
 
+    let with_host = enriched.iter().any(|session| session.get("host").is_some());
     let mut rows = Vec::new();
     for session in &enriched {
         let name = session
@@ -250,23 +530,92 @@ pub(super) fn cmd_status(args: StatusArgs, deps: &Deps) -> Result<(), CliError>
             .and_then(|v| v.as_u64())
             .unwrap_or(0);
 
-        rows.push(vec![
+        let mut row = vec![
             name.to_string(),
             dir.to_string(),
             format!("{}/{}", iteration, max_iterations),
             status.to_string(),
             format!("{}", remaining),
-        ]);
+        ];
+        if with_host {
+            let host = session
+                .get("host")
+                .and_then(|v| v.as_str())
+                .unwrap_or("local");
+            row.insert(0, host.to_string());
+        }
+        rows.push(row);
     }
 
-    print_table(&["NAME", "DIR", "ITERATION", "STATUS", "REMAINING"], &rows);
+    if with_host {
+        print_table(
+            &["HOST", "NAME", "DIR", "ITERATION", "STATUS", "REMAINING"],
+            &rows,
+        );
+    } else {
+        print_table(&["NAME", "DIR", "ITERATION", "STATUS", "REMAINING"], &rows);
+    }
     if args.verbose {
         print_status_verbose(&enriched);
     }
+    print_hidden_sessions(hidden);
     Ok(())
 }
 
-fn enrich_status_session(session: Value, process: &dyn ProcessRunner) -> Value {
+/// The project `gralph status` is limited to without `--global`: the git
+/// repo around the current directory, or the directory itself when it has a
+/// `.gralph/` folder. Elsewhere every session is shown.
+fn status_project() -> Option<PathBuf> {
+    let cwd = env::current_dir().ok()?;
+    let root = gitops::repo_root(&cwd).or_else(|| cwd.join(".gralph").is_dir().then_some(cwd))?;
+    Some(root.canonicalize().unwrap_or(root))
+}
+
+/// Whether the session runs in `root` or below it, which includes its
+/// worktrees and workspace packages.
+fn session_in_project(session: &Value, root: &Path) -> bool {
+    session
+        .get("dir")
+        .and_then(Value::as_str)
+        .filter(|dir| !dir.is_empty())
+        .map(PathBuf::from)
+        .is_some_and(|dir| dir.canonicalize().unwrap_or(dir).starts_with(root))
+}
+
+fn print_hidden_sessions(hidden: usize) {
+    if hidden > 0 {
+        println!(
+            "{} session(s) in other projects; `gralph status --global` shows all.",
+            hidden
+        );
+    }
+}
+
+/// Sessions from the servers listed under `remotes`. A server that cannot
+/// be reached is reported and skipped so the rest still show.
+fn remote_status_sessions(offline: bool) -> Vec<Value> {
+    let servers = match Config::load(None)
+        .map_err(|err| err.to_string())
+        .and_then(|config| remote::remote_servers(&config).map_err(|err| err.to_string()))
+    {
+        Ok(servers) => servers,
+        Err(err) => {
+            eprintln!("Warning: {}", err);
+            return Vec::new();
+        }
+    };
+    servers
+        .iter()
+        .flat_map(|server| {
+            remote::fetch_sessions(server, offline).unwrap_or_else(|err| {
+                eprintln!("Warning: {}", err);
+                Vec::new()
+            })
+        })
+        .collect()
+}
+
+pub(super) fn enrich_status_session(session: Value, process: &dyn ProcessRunner) -> Value {
     let mut map = match session.as_object() {
         Some(map) => map.clone(),
         None => Map::new(),
@@ -284,7 +633,7 @@ fn enrich_status_session(session: Value, process: &dyn ProcessRunner) -> Value {
         .to_string();
     let pid = map.get("pid").and_then(|v| v.as_i64()).unwrap_or(0);
     let mut is_alive = false;
-    if status == "running" && pid > 0 {
+    if matches!(status.as_str(), "running" | "paused") && pid > 0 {
         if process.is_alive(pid) {
             is_alive = true;
         } else {
@@ -297,7 +646,7 @@ fn enrich_status_session(session: Value, process: &dyn ProcessRunner) -> Value {
             .and_then(|v| v.as_u64())
             .unwrap_or(0) as i64
     } else {
-        core::count_remaining_tasks(&PathBuf::from(dir).join(task_file)) as i64
+        core::count_remaining_for(Path::new(dir), task_file) as i64
     };
 
     let log_file = resolve_status_log_file(&map, name_raw, dir);
@@ -305,8 +654,15 @@ fn enrich_status_session(session: Value, process: &dyn ProcessRunner) -> Value {
     let last_task_id = if dir.is_empty() {
         None
     } else {
-        prd::prd_next_task_id(&PathBuf::from(dir).join(task_file))
+        let dir = Path::new(dir);
+        prd::prd_next_task_id(&dir.join(core::active_task_file(dir, task_file)))
     };
+    let last_task_checklist = last_task_id.as_deref().and_then(|id| {
+        let dir = Path::new(dir);
+        let block =
+            core::find_task_block(&dir.join(core::active_task_file(dir, task_file)), id).ok()??;
+        prd::prd_task_checklist_progress(&block)
+    });
     let last_log = log_file
         .as_ref()
         .and_then(|path| core::last_log_line(path.as_path()));
@@ -338,6 +694,12 @@ fn enrich_status_session(session: Value, process: &dyn ProcessRunner) -> Value {
         "last_task_id".to_string(),
         last_task_id.map(Value::String).unwrap_or(Value::Null),
     );
+    map.insert(
+        "last_task_checklist".to_string(),
+        last_task_checklist
+            .map(|progress| serde_json::json!(progress))
+            .unwrap_or(Value::Null),
+    );
     map.insert(
         "last_log_line".to_string(),
         last_log.map(Value::String).unwrap_or(Value::Null),
@@ -395,6 +757,24 @@ fn print_status_verbose(sessions: &[Value]) {
             .get("last_error")
             .and_then(|v| v.as_str())
             .unwrap_or("");
+        let current_task = session
+            .get("current_task")
+            .and_then(|v| v.as_str())
+            .unwrap_or("");
+        let last_activity = session
+            .get("last_activity")
+            .and_then(|v| v.as_str())
+            .unwrap_or("");
+        let checklist = session
+            .get("last_task_checklist")
+            .and_then(|progress| {
+                Some(format!(
+                    "{}/{} done",
+                    progress.get("done")?.as_u64()?,
+                    progress.get("total")?.as_u64()?
+                ))
+            })
+            .unwrap_or_else(|| "none".to_string());
 
         println!();
         println!("{}:", name);
@@ -422,6 +802,23 @@ fn print_status_verbose(sessions: &[Value]) {
                 last_error
             }
         );
+        println!(
+            "  current_task: {}",
+            if current_task.is_empty() {
+                "none"
+            } else {
+                current_task
+            }
+        );
+        println!("  last_task_checklist: {}", checklist);
+        println!(
+            "  last_activity: {}",
+            if last_activity.is_empty() {
+                "none"
+            } else {
+                last_activity
+            }
+        );
     }
 }
 
@@ -455,19 +852,95 @@ pub(super) fn cmd_cleanup(args: CleanupArgs, deps: &Deps) -> Result<(), CliError
     Ok(())
 }
 
-pub(super) fn cmd_logs(args: LogsArgs, deps: &Deps) -> Result<(), CliError> {
+pub(super) fn cmd_history(args: HistoryArgs, json: bool, deps: &Deps) -> Result<(), CliError> {
+    let since = match args.since.as_deref() {
+        Some(value) => {
+            let window = parse_since_duration(value).ok_or_else(|| {
+                CliError::Message(format!(
+                    "Invalid --since duration: {} (expected e.g. 90s, 30m, 2h, 7d)",
+                    value
+                ))
+            })?;
+            Some(deps.clock().now().checked_sub(window).unwrap_or(UNIX_EPOCH))
+        }
+        None => None,
+    };
+    let path = history::history_path(deps.state_store().state_dir());
+    let records = history::read_records(&path).map_err(|err| CliError::Message(err.to_string()))?;
+    let records = history::filter_records(records, args.session.as_deref(), since);
+
+    if json {
+        return print_json(&serde_json::json!({"history": records}));
+    }
+    if records.is_empty() {
+        println!("No session history found.");
+        return Ok(());
+    }
+    let rows = records
+        .iter()
+        .map(|record| {
+            let duration = core::format_duration(record.duration_secs);
+            vec![
+                record.finished_at.clone(),
+                record.session.clone(),
+                record.status.clone(),
+                format!("{}/{}", record.iterations, record.max_iterations),
+                duration
+                    .split_once(" (")
+                    .map(|(short, _)| short.to_string())
+                    .unwrap_or(duration.clone()),
+                record
+                    .cost_usd
+                    .map(|cost| format!("${:.2}", cost))
+                    .unwrap_or_else(|| "-".to_string()),
+                record.git_range().unwrap_or_else(|| "-".to_string()),
+            ]
+        })
+        .collect::<Vec<_>>();
+    print_table(
+        &[
+            "FINISHED",
+            "SESSION",
+            "STATUS",
+            "ITERATIONS",
+            "DURATION",
+            "COST",
+            "GIT",
+        ],
+        &rows,
+    );
+    Ok(())
+}
+
+pub(super) fn cmd_logs(args: LogsArgs, json: bool, deps: &Deps) -> Result<(), CliError> {
     let store = deps.state_store();
     store
         .init_state()
         .map_err(|err| CliError::Message(err.to_string()))?;
+    let name = match args.name.clone() {
+        Some(name) => name,
+        None => {
+            let sessions = store
+                .list_sessions()
+                .map_err(|err| CliError::Message(err.to_string()))?;
+            let entries = sessions
+                .iter()
+                .filter_map(PickerEntry::from_session)
+                .collect::<Vec<_>>();
+            match picker::pick_on_terminal(&entries, "view logs for", false)? {
+                Some(Pick::Session(name)) => name,
+                _ => return Err(CliError::Message("Session name is required.".to_string())),
+            }
+        }
+    };
     let session = store
-        .get_session(&args.name)
+        .get_session(&name)
         .map_err(|err| CliError::Message(err.to_string()))?
-        .ok_or_else(|| CliError::Message(format!("Session not found: {}", args.name)))?;
+        .ok_or_else(|| CliError::Message(format!("Session not found: {}", name)))?;
     let log_file = if args.raw {
-        resolve_raw_log_file(&args.name, &session)?
+        resolve_raw_log_file(&name, &session)?
     } else {
-        resolve_log_file(&args.name, &session)?
+        resolve_log_file(&name, &session)?
     };
     if !log_file.is_file() {
         return Err(CliError::Message(format!(
@@ -477,8 +950,31 @@ pub(super) fn cmd_logs(args: LogsArgs, deps: &Deps) -> Result<(), CliError> {
         )));
     }
 
+    let filter = LogFilter::from_args(&args, deps.clock().now())?;
     if args.follow {
-        follow_log(&log_file, deps.fs(), deps.clock())?;
+        follow_log(&log_file, json, deps.fs(), deps.clock())?;
+    } else if let Some(filter) = filter {
+        let contents = deps.fs().read_to_string(&log_file).map_err(CliError::Io)?;
+        let lines = filter.apply(&contents);
+        if json {
+            print_json(&serde_json::json!({
+                "name": name,
+                "log_file": log_file.to_string_lossy(),
+                "lines": lines,
+            }))?;
+        } else {
+            for line in lines {
+                println!("{}", line);
+            }
+        }
+    } else if json {
+        let contents = deps.fs().read_to_string(&log_file).map_err(CliError::Io)?;
+        let lines = tail_lines(&contents, 200);
+        print_json(&serde_json::json!({
+            "name": name,
+            "log_file": log_file.to_string_lossy(),
+            "lines": lines,
+        }))?;
     } else {
         print_tail(&log_file, 200, deps.fs())?;
     }
@@ -493,86 +989,56 @@ pub(super) fn cmd_resume(args: ResumeArgs, deps: &Deps) -> Result<(), CliError>
     let sessions = store
         .list_sessions()
         .map_err(|err| CliError::Message(err.to_string()))?;
-    let target = args.name;
+    let target = match args.name {
+        Some(name) => Some(name),
+        None => {
+            let resumable = sessions
+                .iter()
+                .filter(|session| is_resumable(session, deps.process()))
+                .filter_map(PickerEntry::from_session)
+                .collect::<Vec<_>>();
+            if resumable.is_empty() {
+                None
+            } else {
+                match picker::pick_on_terminal(&resumable, "resume", true)? {
+                    Some(Pick::Session(name)) => Some(name),
+                    Some(Pick::All) | None => None,
+                }
+            }
+        }
+    };
 
+    // A named target resolves like any other lookup, so a name used by
+    // several projects resumes only the one for the current directory.
+    let sessions = match target.as_deref() {
+        Some(target) => store
+            .get_session(target)
+            .map_err(|err| CliError::Message(err.to_string()))?
+            .into_iter()
+            .collect(),
+        None => sessions,
+    };
     let mut resumed = 0;
     for session in sessions {
         let name = session.get("name").and_then(|v| v.as_str()).unwrap_or("");
         if name.is_empty() {
             continue;
         }
-        if let Some(target) = target.as_deref() {
-            if name != target {
-                continue;
-            }
-        }
-
-        let status = session
-            .get("status")
-            .and_then(|v| v.as_str())
-            .unwrap_or("unknown");
-        let pid = session.get("pid").and_then(|v| v.as_i64()).unwrap_or(0);
-        let pid_alive = status == "running" && pid > 0 && deps.process().is_alive(pid);
-        let should_resume = should_resume_session(status, pid, pid_alive);
-        if !should_resume {
-            continue;
+        match resume_session(
+            name,
+            &session,
+            &store,
+            deps.process(),
+            deps.offline(),
+            deps.cassette(),
+        ) {
+            Ok(Some(_)) => resumed += 1,
+            Ok(None) => {}
+            // Resuming everything goes on past a session whose directory is
+            // taken; a named target reports it.
+            Err(err) if target.is_none() => eprintln!("Skipped {}: {}", name, err),
+            Err(err) => return Err(err),
         }
-
-        let dir = session
-            .get("dir")
-            .and_then(|v| v.as_str())
-            .ok_or_else(|| CliError::Message(format!("Missing dir for session {}", name)))?;
-        let task_file = session
-            .get("task_file")
-            .and_then(|v| v.as_str())
-            .map(|s| s.to_string());
-        let max_iterations = session
-            .get("max_iterations")
-            .and_then(|v| v.as_u64())
-            .map(|v| v as u32);
-        let completion_marker = session
-            .get("completion_marker")
-            .and_then(|v| v.as_str())
-            .map(|s| s.to_string());
-        let backend = session
-            .get("backend")
-            .and_then(|v| v.as_str())
-            .map(|s| s.to_string());
-        let model = session
-            .get("model")
-            .and_then(|v| v.as_str())
-            .map(|s| s.to_string());
-        let variant = session
-            .get("variant")
-            .and_then(|v| v.as_str())
-            .map(|s| s.to_string());
-        let webhook = session
-            .get("webhook")
-            .and_then(|v| v.as_str())
-            .map(|s| s.to_string());
-
-        let run_args = RunLoopArgs {
-            dir: PathBuf::from(dir),
-            name: name.to_string(),
-            max_iterations,
-            task_file,
-            completion_marker,
-            backend,
-            model,
-            variant,
-            prompt_template: None,
-            webhook,
-            no_worktree: true,
-            strict_prd: false,
-        };
-        let child = spawn_run_loop(&run_args, deps.process())?;
-        store
-            .set_session(
-                name,
-                &[("pid", &child.id().to_string()), ("status", "running")],
-            )
-            .map_err(|err| CliError::Message(err.to_string()))?;
-        resumed += 1;
     }
 
     if resumed == 0 {
@@ -600,6 +1066,9 @@ fn maybe_check_for_update() {
 }
 
 fn should_check_for_update(config: &Config) -> bool {
+    if config.offline() {
+        return false;
+    }
     if let Ok(value) = env::var("GRALPH_NO_UPDATE_CHECK") {
         if value.trim().is_empty() {
             return false;
@@ -616,7 +1085,54 @@ fn should_check_for_update(config: &Config) -> bool {
         .unwrap_or(true)
 }
 
-fn format_rfc3339(clock: &dyn core::Clock) -> String {
+fn should_preflight(config: &Config) -> bool {
+    config
+        .get("defaults.preflight")
+        .as_deref()
+        .and_then(super::parse_bool_value)
+        .unwrap_or(true)
+}
+
+/// Checks that `backend` is installed and can authenticate before a loop
+/// starts, so a missing login fails in the terminal instead of in the first
+/// iteration of a background run. The auth probe runs in the project `dir`.
+fn preflight_backend(
+    backend: &dyn Backend,
+    model: Option<&str>,
+    dir: &Path,
+) -> Result<(), CliError> {
+    if !backend.check_installed() {
+        return Err(CliError::Message(format!(
+            "Backend is not installed: {}",
+            backend.name()
+        )));
+    }
+    backend.check_auth(model, dir).map_err(|err| {
+        CliError::Message(format!(
+            "Backend {} failed its auth check: {} (set defaults.preflight: false to skip the check)",
+            backend.name(),
+            err
+        ))
+    })
+}
+
+/// Whether any of the fallback backends passes [`preflight_backend`].
+fn preflight_fallbacks(names: &[String], config: &Config, dir: &Path) -> bool {
+    names.iter().any(|name| {
+        backend_from_config(name, config, &[])
+            .map_err(CliError::Message)
+            .and_then(|backend| {
+                preflight_backend(
+                    backend.as_ref(),
+                    resolve_fallback_model(config, name).as_deref(),
+                    dir,
+                )
+            })
+            .is_ok()
+    })
+}
+
+pub(super) fn format_rfc3339(clock: &dyn core::Clock) -> String {
     let datetime: chrono::DateTime<chrono::Local> = clock.now().into();
     datetime.to_rfc3339()
 }
@@ -629,13 +1145,60 @@ fn resolve_task_file(args: &RunLoopArgs, config: &Config) -> String {
 }
 
 fn resolve_max_iterations(args: &RunLoopArgs, config: &Config) -> u32 {
-    args.max_iterations
-        .or_else(|| {
-            config
-                .get("defaults.max_iterations")
-                .and_then(|value| value.parse().ok())
-        })
-        .unwrap_or(30)
+    if let Some(max_iterations) = args.max_iterations {
+        return max_iterations;
+    }
+    match config.get("defaults.max_iterations") {
+        Some(value) if value.trim().eq_ignore_ascii_case("auto") => {
+            estimated_max_iterations(args, config).unwrap_or(30)
+        }
+        value => value.and_then(|value| value.parse().ok()).unwrap_or(30),
+    }
+}
+
+/// `defaults.max_iterations: auto`: the suggestion from the `- **Estimate**`
+/// fields across the task files, or None when no task has one.
+fn estimated_max_iterations(args: &RunLoopArgs, config: &Config) -> Option<u32> {
+    let mut estimate = prd::EstimateSummary::default();
+    for file in resolve_task_files(&args.dir, &resolve_task_file(args, config)).ok()? {
+        let contents = fs::read_to_string(args.dir.join(file)).ok()?;
+        estimate.add(prd::prd_estimate_contents(&contents));
+    }
+    estimate.suggested_max_iterations()
+}
+
+/// Budget caps from `--max-cost`/`--max-tokens`, falling back to config,
+/// plus the `--max-duration` time limit. Zero on the command line lifts a
+/// configured cap.
+fn resolve_budget(args: &RunLoopArgs, config: &Config) -> Result<core::LoopBudget, CliError> {
+    let mut budget = core::LoopBudget::from_config(Some(config))
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    if let Some(max) = args.max_cost {
+        if !max.is_finite() || max < 0.0 {
+            return Err(CliError::Message(format!(
+                "--max-cost must be a non-negative number: {}",
+                max
+            )));
+        }
+        budget.max_cost_usd = (max > 0.0).then_some(max);
+    }
+    if let Some(max) = args.max_tokens {
+        budget.max_tokens = (max > 0).then_some(max);
+    }
+    if let Some(value) = args.max_duration.as_deref() {
+        let max = parse_since_duration(value).ok_or_else(|| {
+            CliError::Message(format!(
+                "Invalid --max-duration: {} (expected e.g. 90s, 30m, 4h, 1d)",
+                value
+            ))
+        })?;
+        budget.max_duration = (!max.is_zero()).then_some(max);
+    }
+    Ok(budget)
+}
+
+fn optional_field<T: ToString>(value: Option<T>) -> String {
+    value.map(|value| value.to_string()).unwrap_or_default()
 }
 
 fn resolve_completion_marker(args: &RunLoopArgs, config: &Config) -> String {
@@ -646,32 +1209,328 @@ fn resolve_completion_marker(args: &RunLoopArgs, config: &Config) -> String {
 }
 
 fn resolve_backend_name(args: &RunLoopArgs, config: &Config) -> String {
-    args.backend
-        .clone()
-        .or_else(|| config.get("defaults.backend"))
-        .unwrap_or_else(|| "claude".to_string())
+    resolve_backend_chain(args, config).remove(0)
 }
 
-fn resolve_model(args: &RunLoopArgs, config: &Config, backend_name: &str) -> Option<String> {
-    let mut model = args.model.clone().or_else(|| config.get("defaults.model"));
-    if model.as_deref().unwrap_or("").is_empty() && backend_name == "opencode" {
-        model = config.get("opencode.default_model");
+/// The primary backend followed by its fallbacks. `--backend a,b` (or a comma
+/// list in `defaults.backend`) names the whole chain; a single backend is
+/// followed by `defaults.backend_fallbacks`.
+fn resolve_backend_chain(args: &RunLoopArgs, config: &Config) -> Vec<String> {
+    let split = |value: &str| -> Vec<String> {
+        value
+            .split(',')
+            .map(str::trim)
+            .filter(|name| !name.is_empty())
+            .map(str::to_string)
+            .collect()
+    };
+    let mut chain = args
+        .backend
+        .clone()
+        .or_else(|| config.get("defaults.backend"))
+        .map(|value| split(&value))
+        .unwrap_or_default();
+    if chain.is_empty() {
+        chain.push("claude".to_string());
     }
-    model
+    if chain.len() == 1 {
+        for value in config
+            .get_list("defaults.backend_fallbacks")
+            .unwrap_or_default()
+        {
+            chain.extend(split(&value));
+        }
+    }
+    let mut seen = Vec::new();
+    chain.retain(|name| {
+        let first = !seen.contains(name);
+        seen.push(name.clone());
+        first
+    });
+    chain
 }
 
-fn should_validate_prd(strict_prd: bool) -> bool {
-    strict_prd
+/// Consecutive failed iterations before the loop moves to the next backend
+/// in the chain.
+fn resolve_fallback_after(config: &Config) -> u32 {
+    config
+        .get("defaults.backend_fallback_after")
+        .and_then(|value| value.parse().ok())
+        .filter(|value| *value > 0)
+        .unwrap_or(fallback::DEFAULT_MAX_FAILURES)
 }
 
-fn should_resume_session(status: &str, pid: i64, pid_alive: bool) -> bool {
-    if matches!(status, "stale" | "stopped" | "failed") {
-        return true;
-    }
-    if status == "running" {
-        return pid <= 0 || !pid_alive;
-    }
-    false
+/// The model a fallback backend runs with: its own default, since `--model`
+/// and `defaults.model` are meant for the primary.
+fn resolve_fallback_model(config: &Config, backend_name: &str) -> Option<String> {
+    config
+        .get(&format!("{}.default_model", backend_name))
+        .filter(|model| !model.is_empty())
+}
+
+/// Wraps `primary` in a [`FallbackBackend`] when the chain has fallbacks.
+/// Fallbacks that cannot be built or are refused in offline mode are left
+/// out with a warning rather than failing the run.
+fn with_fallbacks(
+    primary: Box<dyn Backend>,
+    chain: &[String],
+    config: &Config,
+    logger: &Logger,
+) -> Box<dyn Backend> {
+    let mut entries = vec![FallbackEntry {
+        backend: primary,
+        model: None,
+    }];
+    for name in &chain[1..] {
+        let backend = backend_from_config(name, config, &[]).and_then(|backend| {
+            ensure_network_allowed(backend.as_ref(), config.offline())?;
+            Ok(backend)
+        });
+        match backend {
+            Ok(backend) => entries.push(FallbackEntry {
+                backend,
+                model: resolve_fallback_model(config, name),
+            }),
+            Err(err) => {
+                let _ = logger.warn(&format!("skipping fallback backend {}: {}", name, err));
+            }
+        }
+    }
+    if entries.len() == 1 {
+        return entries.remove(0).backend;
+    }
+    let switch_logger = logger.clone();
+    Box::new(
+        FallbackBackend::new(entries, resolve_fallback_after(config)).on_switch(move |message| {
+            let _ = switch_logger.warn(message);
+        }),
+    )
+}
+
+fn resolve_model(args: &RunLoopArgs, config: &Config, backend_name: &str) -> Option<String> {
+    let mut model = args.model.clone().or_else(|| config.get("defaults.model"));
+    if model.as_deref().unwrap_or("").is_empty()
+        && matches!(backend_name, "opencode" | "ollama" | "openai")
+    {
+        model = config.get(&format!("{}.default_model", backend_name));
+    }
+    model
+}
+
+fn should_validate_prd(strict_prd: bool) -> bool {
+    strict_prd
+}
+
+/// Relaunch a session's loop in the background from its stored record.
+///
+/// Returns the new PID, or `None` when the session is not resumable (its
+/// loop is still alive, or it finished).
+fn is_resumable(session: &Value, process: &dyn ProcessRunner) -> bool {
+    let status = session
+        .get("status")
+        .and_then(|v| v.as_str())
+        .unwrap_or("unknown");
+    let pid = session.get("pid").and_then(|v| v.as_i64()).unwrap_or(0);
+    let pid_alive = status == "running" && pid > 0 && process.is_alive(pid);
+    should_resume_session(status, pid, pid_alive)
+}
+
+pub(crate) fn resume_session(
+    name: &str,
+    session: &Value,
+    store: &StateStore,
+    process: &dyn ProcessRunner,
+    offline: bool,
+    cassette: Option<&Cassette>,
+) -> Result<Option<u32>, CliError> {
+    if !is_resumable(session, process) {
+        return Ok(None);
+    }
+
+    let dir = session
+        .get("dir")
+        .and_then(|v| v.as_str())
+        .ok_or_else(|| CliError::Message(format!("Missing dir for session {}", name)))?;
+    ensure_dir_available(store, Path::new(dir), process)?;
+    let task_file = session
+        .get("task_file")
+        .and_then(|v| v.as_str())
+        .map(|s| s.to_string());
+    let max_iterations = session
+        .get("max_iterations")
+        .and_then(|v| v.as_u64())
+        .map(|v| v as u32);
+    let completion_marker = session
+        .get("completion_marker")
+        .and_then(|v| v.as_str())
+        .map(|s| s.to_string());
+    let backend = session
+        .get("backend")
+        .and_then(|v| v.as_str())
+        .map(|s| s.to_string());
+    let model = session
+        .get("model")
+        .and_then(|v| v.as_str())
+        .map(|s| s.to_string());
+    let variant = session
+        .get("variant")
+        .and_then(|v| v.as_str())
+        .map(|s| s.to_string());
+    let backend_args = session
+        .get("backend_args")
+        .and_then(|v| v.as_str())
+        .and_then(|s| shell_words::split(s).ok())
+        .unwrap_or_default();
+    let max_cost = session.get("max_cost").and_then(|v| {
+        v.as_f64()
+            .or_else(|| v.as_str().and_then(|s| s.parse().ok()))
+    });
+    let max_tokens = session.get("max_tokens").and_then(|v| v.as_u64());
+    let max_duration = session
+        .get("max_duration")
+        .and_then(|v| v.as_str())
+        .filter(|s| !s.is_empty())
+        .map(|s| s.to_string());
+    let webhook = session
+        .get("webhook")
+        .and_then(|v| v.as_str())
+        .map(|s| s.to_string());
+    let worktree = session
+        .get("worktree")
+        .and_then(|v| v.as_bool())
+        .unwrap_or(false);
+    let pid_file = session
+        .get("pid_file")
+        .and_then(|v| v.as_str())
+        .filter(|s| !s.is_empty())
+        .map(PathBuf::from);
+    let review_backend = session
+        .get("review_backend")
+        .and_then(|v| v.as_str())
+        .filter(|s| !s.is_empty())
+        .map(|s| s.to_string());
+    let review_model = session
+        .get("review_model")
+        .and_then(|v| v.as_str())
+        .filter(|s| !s.is_empty())
+        .map(|s| s.to_string());
+    let profile = session
+        .get("profile")
+        .and_then(|v| v.as_str())
+        .filter(|s| !s.is_empty())
+        .map(|s| s.to_string());
+    // A loop started offline stays offline when it is resumed.
+    let offline = offline
+        || session
+            .get("offline")
+            .and_then(|v| v.as_bool())
+            .unwrap_or(false);
+
+    let run_args = RunLoopArgs {
+        dir: PathBuf::from(dir),
+        name: name.to_string(),
+        max_iterations,
+        max_cost,
+        max_tokens,
+        max_duration,
+        task_file,
+        completion_marker,
+        backend,
+        model,
+        variant,
+        backend_args,
+        prompt_template: None,
+        review_backend,
+        review_model,
+        webhook,
+        no_worktree: true,
+        worktree,
+        strict_prd: false,
+        pid_file,
+        resume: true,
+        profile,
+        allow_concurrent: false,
+    };
+    let (pid, supervised) = launch_run_loop(store, &run_args, process, offline, cassette)?;
+    session_store(store, session)
+        .set_session(
+            name,
+            &[
+                ("pid", &pid.to_string()),
+                ("status", "running"),
+                ("daemon", if supervised { "true" } else { "false" }),
+            ],
+        )
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    Ok(Some(pid))
+}
+
+/// Refuses to start a loop in `dir` while another live loop works there: the
+/// two would race on the same task file and git tree. The calling process's
+/// own session (a `run-loop` child started by `gralph start`) is not a
+/// conflict.
+fn ensure_dir_available(
+    store: &StateStore,
+    dir: &Path,
+    process: &dyn ProcessRunner,
+) -> Result<(), CliError> {
+    let dir = dir.canonicalize().unwrap_or_else(|_| dir.to_path_buf());
+    let own_pid = i64::from(process.pid());
+    let sessions = store
+        .list_sessions()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    for session in &sessions {
+        let status = session
+            .get("status")
+            .and_then(|v| v.as_str())
+            .unwrap_or("unknown");
+        let pid = session.get("pid").and_then(|v| v.as_i64()).unwrap_or(0);
+        if !matches!(status, "running" | "paused")
+            || pid <= 0
+            || pid == own_pid
+            || !process.is_alive(pid)
+        {
+            continue;
+        }
+        let Some(other_dir) = session
+            .get("dir")
+            .and_then(|v| v.as_str())
+            .filter(|dir| !dir.is_empty())
+            .map(PathBuf::from)
+        else {
+            continue;
+        };
+        if other_dir.canonicalize().unwrap_or(other_dir) != dir {
+            continue;
+        }
+        let name = session.get("name").and_then(|v| v.as_str()).unwrap_or("");
+        let task_file = session
+            .get("task_file")
+            .and_then(|v| v.as_str())
+            .unwrap_or("PRD.md");
+        return Err(CliError::Message(format!(
+            "Session '{}' is already running in {} on {} (PID: {}). Stop it with `gralph stop {}`, or pass --allow-concurrent to run both.",
+            name,
+            dir.display(),
+            task_file,
+            pid,
+            name
+        )));
+    }
+    Ok(())
+}
+
+fn should_resume_session(status: &str, pid: i64, pid_alive: bool) -> bool {
+    if matches!(
+        status,
+        "stale" | "stopped" | "failed" | "stalled" | "budget_exceeded" | "deadline_exceeded"
+    ) {
+        return true;
+    }
+    if matches!(status, "running" | "paused") {
+        return pid <= 0 || !pid_alive;
+    }
+    false
 }
 
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
@@ -727,24 +1586,51 @@ fn notification_decision(
         LoopStatus::MaxIterations => Some(NotificationDecision::Failed {
             reason: "max_iterations",
         }),
-        LoopStatus::Running => None,
+        LoopStatus::Stalled => Some(NotificationDecision::Failed { reason: "stalled" }),
+        LoopStatus::BudgetExceeded => Some(NotificationDecision::Failed {
+            reason: "budget_exceeded",
+        }),
+        LoopStatus::DeadlineExceeded => Some(NotificationDecision::Failed {
+            reason: "deadline_exceeded",
+        }),
+        LoopStatus::Running | LoopStatus::Paused | LoopStatus::Stopped => None,
     }
 }
 
 fn run_loop_with_state(args: RunLoopArgs, deps: &Deps) -> Result<(), CliError> {
-    let config = Config::load(Some(&args.dir)).map_err(|err| CliError::Message(err.to_string()))?;
+    let config = Config::load_with_profile(Some(&args.dir), args.profile.as_deref())
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .with_offline(deps.offline())
+        .with_cassette(deps.cassette().cloned());
     if should_check_for_update(&config) {
         maybe_check_for_update();
     }
     let task_file = resolve_task_file(&args, &config);
+    let task_files = resolve_task_files(&args.dir, &task_file)?;
+    if task_files.len() > 1 && args.worktree {
+        return Err(CliError::Message(
+            "--worktree works with a single task file".to_string(),
+        ));
+    }
     let max_iterations = resolve_max_iterations(&args, &config);
     let completion_marker = resolve_completion_marker(&args, &config);
-    let backend_name = resolve_backend_name(&args, &config);
+    let backend_chain = resolve_backend_chain(&args, &config);
+    let backend_name = backend_chain[0].clone();
     let model = resolve_model(&args, &config, &backend_name);
+    let budget = resolve_budget(&args, &config)?;
+    let log_file = args.dir.join(".gralph").join(format!("{}.log", args.name));
+    let raw_log_file = core::raw_log_path(&log_file);
+    let logger = Logger::new(Some(&log_file), LogSettings::from_config(&config))
+        .redacting(Redactor::from_config(&config))
+        .to_stderr()
+        .with("session", args.name.as_str())
+        .with("backend", backend_name.as_str());
 
     if should_validate_prd(args.strict_prd) {
-        prd::prd_validate_file(&args.dir.join(&task_file), false, Some(&args.dir))
-            .map_err(|err| CliError::Message(err.to_string()))?;
+        for file in &task_files {
+            prd::prd_validate_file(&args.dir.join(file), false, Some(&args.dir))
+                .map_err(|err| CliError::Message(err.to_string()))?;
+        }
     }
 
     let prompt_template = match &args.prompt_template {
@@ -752,22 +1638,73 @@ fn run_loop_with_state(args: RunLoopArgs, deps: &Deps) -> Result<(), CliError> {
         None => None,
     };
 
-    let backend = backend_from_name(&backend_name).map_err(CliError::Message)?;
+    let backend = backend_from_config(&backend_name, &config, &args.backend_args)
+        .map_err(CliError::Message)?;
+    ensure_network_allowed(backend.as_ref(), config.offline()).map_err(CliError::Message)?;
+    ensure_variant_supported(backend.as_ref(), args.variant.as_deref())
+        .map_err(CliError::Message)?;
+    let backend = with_fallbacks(backend, &backend_chain, &config, &logger);
     if !backend.check_installed() {
         return Err(CliError::Message(format!(
             "Backend is not installed: {}",
-            backend_name
+            backend_chain.join(", ")
         )));
     }
+    let backend: Box<dyn Backend> = if args.worktree {
+        Box::new(TaskWorktreeBackend::new(
+            backend,
+            &args.dir,
+            &task_files[0],
+        )?)
+    } else {
+        backend
+    };
+    let review_backend = match args.review_backend.as_deref() {
+        Some(name) => {
+            let review_backend =
+                backend_from_config(name, &config, &[]).map_err(CliError::Message)?;
+            ensure_network_allowed(review_backend.as_ref(), config.offline())
+                .map_err(CliError::Message)?;
+            if !review_backend.check_installed() {
+                return Err(CliError::Message(format!(
+                    "Review backend is not installed: {}",
+                    name
+                )));
+            }
+            Some(review_backend)
+        }
+        None => None,
+    };
+    let reviewer = review_backend
+        .as_deref()
+        .map(|backend| core::CompletionReviewer {
+            backend,
+            model: args.review_model.as_deref(),
+        });
 
-    let store = deps.state_store();
+    let store = deps.state_store().in_project(&args.dir);
     store
         .init_state()
         .map_err(|err| CliError::Message(err.to_string()))?;
+    if !args.allow_concurrent {
+        ensure_dir_available(&store, &args.dir, deps.process())?;
+    }
     let now = format_rfc3339(deps.clock());
-    let remaining = core::count_remaining_tasks(&args.dir.join(&task_file));
-    let log_file = args.dir.join(".gralph").join(format!("{}.log", args.name));
-    let raw_log_file = core::raw_log_path(&log_file);
+    let remaining = core::count_remaining_for(&args.dir, &task_file);
+    // A resumed loop keeps the commit the session first started from, so
+    // `gralph diff` still covers every iteration.
+    let git_start = args
+        .resume
+        .then(|| store.get_session(&args.name).ok().flatten())
+        .flatten()
+        .and_then(|session| {
+            session
+                .get("git_start")
+                .and_then(Value::as_str)
+                .filter(|commit| !commit.is_empty())
+                .map(str::to_string)
+        })
+        .or_else(|| gitops::head_commit(&args.dir));
 
     store
         .set_session(
@@ -785,42 +1722,197 @@ fn run_loop_with_state(args: RunLoopArgs, deps: &Deps) -> Result<(), CliError> {
                 ("completion_marker", &completion_marker),
                 ("log_file", &log_file.to_string_lossy()),
                 ("raw_log_file", &raw_log_file.to_string_lossy()),
-                ("backend", &backend_name),
+                ("backend", &backend_chain.join(",")),
                 ("model", model.as_deref().unwrap_or("")),
                 ("variant", args.variant.as_deref().unwrap_or("")),
+                ("backend_args", &shell_words::join(&args.backend_args)),
+                ("max_cost", &optional_field(args.max_cost)),
+                ("max_tokens", &optional_field(args.max_tokens)),
+                ("max_duration", args.max_duration.as_deref().unwrap_or("")),
+                (
+                    "review_backend",
+                    args.review_backend.as_deref().unwrap_or(""),
+                ),
+                ("review_model", args.review_model.as_deref().unwrap_or("")),
                 ("webhook", args.webhook.as_deref().unwrap_or("")),
+                ("worktree", if args.worktree { "true" } else { "false" }),
+                ("git_start", git_start.as_deref().unwrap_or("")),
+                ("profile", args.profile.as_deref().unwrap_or("")),
+                ("offline", if deps.offline() { "true" } else { "false" }),
+                (
+                    "pid_file",
+                    &args
+                        .pid_file
+                        .as_ref()
+                        .map(|path| path.to_string_lossy().into_owned())
+                        .unwrap_or_default(),
+                ),
             ],
         )
         .map_err(|err| CliError::Message(err.to_string()))?;
 
+    if !args.resume {
+        // A fresh run starts at iteration 1; only `gralph resume` picks up
+        // where the last loop of this session stopped.
+        let _ = checkpoint::clear(&args.dir.join(".gralph"), &args.name);
+    }
+
+    let loop_start = deps.clock().now();
+    let last_progress = Cell::new((1u32, remaining));
+    let progress_webhook = resolve_progress_webhook(&config, &args);
+    let progress_template = match progress_webhook {
+        Some(_) => resolve_payload_template(&config, &args.dir)?,
+        None => None,
+    };
+    let task_paths: Vec<PathBuf> = task_files.iter().map(|file| args.dir.join(file)).collect();
+    let current_file = Cell::new(0);
+    let attempted: Cell<Option<(u32, Option<String>)>> = Cell::new(None);
     let mut callback =
         |name: Option<&str>, iteration: u32, status: LoopStatus, remaining: usize| {
+            last_progress.set((iteration, remaining));
             let session = name.unwrap_or(&args.name);
+            let mut current_task = String::new();
+            if status == LoopStatus::Running {
+                // The loop reports Running once before and once after each
+                // iteration; the first records the task, the second posts.
+                match attempted.take() {
+                    Some((seen, task_id)) if seen == iteration => {
+                        if let Some(webhook) = &progress_webhook {
+                            if let Err(err) = deps.notifier().notify_progress(
+                                session,
+                                webhook,
+                                Some(&args.dir.to_string_lossy()),
+                                iteration,
+                                Some(max_iterations),
+                                Some(remaining as u32),
+                                task_id.as_deref(),
+                                progress_template.as_deref(),
+                                resolve_notification_timeout(&config),
+                            ) {
+                                let _ =
+                                    logger.warn(&format!("progress notification failed: {}", err));
+                            }
+                        }
+                    }
+                    _ => {
+                        let task_id = prd::prd_next_task_id(&task_paths[current_file.get()]);
+                        current_task = task_id.clone().unwrap_or_default();
+                        attempted.set(Some((iteration, task_id)));
+                    }
+                }
+            }
             let _ = store.set_session(
                 session,
                 &[
                     ("iteration", &iteration.to_string()),
                     ("status", status.as_str()),
                     ("last_task_count", &remaining.to_string()),
+                    ("current_task", &current_task),
+                    ("last_activity", &format_rfc3339(deps.clock())),
                 ],
             );
         };
 
-    let outcome = core::run_loop_with_clock(
-        &*backend,
-        &args.dir,
-        Some(&task_file),
-        Some(max_iterations),
-        Some(&completion_marker),
-        model.as_deref(),
-        args.variant.as_deref(),
-        Some(&args.name),
-        prompt_template.as_deref(),
-        Some(&config),
-        Some(&mut callback),
-        deps.clock(),
-    )
-    .map_err(|err| CliError::Message(err.to_string()))?;
+    // Beat while a backend runs, however long it stays quiet, so status can
+    // tell a slow iteration from a hung loop.
+    let heartbeat_store = store.clone();
+    let heartbeat_name = args.name.clone();
+    let outcome = with_heartbeat(
+        HEARTBEAT_INTERVAL,
+        move || {
+            // An update never recreates a session removed by cleanup.
+            let _ = heartbeat_store.update_session(&heartbeat_name, |mut fields| {
+                fields.insert(
+                    "last_activity".to_string(),
+                    Value::from(format_rfc3339(&core::SystemClock)),
+                );
+                fields
+            });
+        },
+        || {
+            run_task_files(
+                &args.dir,
+                &task_files,
+                max_iterations,
+                budget,
+                deps.clock(),
+                &current_file,
+                &mut callback,
+                &mut |file, max_iterations, budget, callback| {
+                    core::run_loop_with_clock(
+                        &*backend,
+                        &args.dir,
+                        Some(file),
+                        Some(max_iterations),
+                        Some(&completion_marker),
+                        model.as_deref(),
+                        args.variant.as_deref(),
+                        Some(&args.name),
+                        prompt_template.as_deref(),
+                        Some(&config),
+                        Some(callback),
+                        reviewer.as_ref(),
+                        budget,
+                        deps.clock(),
+                    )
+                },
+            )
+        },
+    );
+    let record_history = |status: &str, outcome: &core::LoopOutcome| {
+        let record = history::HistoryRecord {
+            session: args.name.clone(),
+            dir: args.dir.to_string_lossy().into_owned(),
+            status: status.to_string(),
+            iterations: outcome.iterations,
+            max_iterations,
+            remaining_tasks: outcome.remaining_tasks,
+            duration_secs: outcome.duration_secs,
+            cost_usd: outcome.usage.cost_usd,
+            backend: backend_name.clone(),
+            model: model.clone().filter(|model| !model.is_empty()),
+            started_at: now.clone(),
+            finished_at: format_rfc3339(deps.clock()),
+            git_start: git_start.clone(),
+            git_end: gitops::head_commit(&args.dir),
+        };
+        let path = history::history_path(store.state_dir());
+        if let Err(err) = history::append_record(&path, &record) {
+            let _ = logger.warn(&format!("failed to record session history: {}", err));
+        }
+    };
+    let outcome = match outcome {
+        Ok(outcome) => outcome,
+        Err(err) => {
+            let (iterations, remaining_tasks) = last_progress.get();
+            let failed = core::LoopOutcome {
+                status: LoopStatus::Failed,
+                iterations,
+                remaining_tasks,
+                duration_secs: deps
+                    .clock()
+                    .now()
+                    .duration_since(loop_start)
+                    .unwrap_or_default()
+                    .as_secs(),
+                usage: Usage::default(),
+            };
+            record_history(LoopStatus::Failed.as_str(), &failed);
+            if let Err(notify_err) = notify_if_configured(
+                &config,
+                &args,
+                &failed,
+                max_iterations,
+                deps.notifier(),
+                &store,
+                deps.clock(),
+                &logger,
+            ) {
+                let _ = logger.warn(&notify_err.to_string());
+            }
+            return Err(CliError::Message(err.to_string()));
+        }
+    };
 
     let auto_run_verifier = verifier::resolve_verifier_auto_run(&config, &args.dir);
     let status_plan = outcome_status_plan(outcome.status, auto_run_verifier);
@@ -833,6 +1925,9 @@ fn run_loop_with_state(args: RunLoopArgs, deps: &Deps) -> Result<(), CliError> {
             ],
         )
         .map_err(|err| CliError::Message(err.to_string()))?;
+    if outcome.status == LoopStatus::Stopped {
+        let _ = store.set_session(&args.name, &[("pid", "0")]);
+    }
 
     if let OutcomeStatusPlan::Verify {
         verifying_status,
@@ -849,6 +1944,19 @@ fn run_loop_with_state(args: RunLoopArgs, deps: &Deps) -> Result<(), CliError> {
             .map_err(|err| CliError::Message(err.to_string()))?;
         if let Err(err) = verifier::run_verifier_pipeline(&args.dir, &config, None, None, None) {
             let _ = store.set_session(&args.name, &[("status", verify_failed_status)]);
+            record_history(verify_failed_status, &outcome);
+            if let Err(notify_err) = notify_if_configured(
+                &config,
+                &args,
+                &outcome,
+                max_iterations,
+                deps.notifier(),
+                &store,
+                deps.clock(),
+                &logger,
+            ) {
+                let _ = logger.warn(&notify_err.to_string());
+            }
             return Err(err);
         }
         store
@@ -857,22 +1965,320 @@ fn run_loop_with_state(args: RunLoopArgs, deps: &Deps) -> Result<(), CliError> {
                 &[("status", verified_status), ("last_task_count", "0")],
             )
             .map_err(|err| CliError::Message(err.to_string()))?;
+        record_history(verified_status, &outcome);
+    } else {
+        record_history(status_plan.initial_status(), &outcome);
     }
 
-    notify_if_configured(&config, &args, &outcome, max_iterations, deps.notifier())?;
+    // The verifier opens its own PR; only unverified runs go through the API.
+    if matches!(status_plan, OutcomeStatusPlan::Final { .. }) {
+        open_pull_request_if_configured(
+            &config,
+            &args,
+            &outcome,
+            max_iterations,
+            &backend_name,
+            &store,
+            &logger,
+        );
+    }
+
+    notify_if_configured(
+        &config,
+        &args,
+        &outcome,
+        max_iterations,
+        deps.notifier(),
+        &store,
+        deps.clock(),
+        &logger,
+    )?;
     Ok(())
 }
 
+/// Per-iteration progress, as reported by [`core::run_loop_with_clock`].
+type LoopCallback<'a> = dyn FnMut(Option<&str>, u32, LoopStatus, usize) + 'a;
+
+/// Resolves a `--task-file` value into the files to run, in order.
+fn resolve_task_files(dir: &Path, task_file: &str) -> Result<Vec<String>, CliError> {
+    core::resolve_task_files(dir, task_file).map_err(|err| CliError::Message(err.to_string()))
+}
+
+/// Runs the loop over each task file in turn, moving to the next file once
+/// one completes. Files already done are skipped, unless every file is done,
+/// in which case the last one runs so the loop can confirm completion.
+/// Iterations, usage, and time are shared across files: `run_file` gets what
+/// is left of each, and `callback` sees iterations counted from the start of
+/// the run and remaining tasks summed over the files not yet finished.
+fn run_task_files(
+    dir: &Path,
+    task_files: &[String],
+    max_iterations: u32,
+    budget: core::LoopBudget,
+    clock: &dyn core::Clock,
+    current_file: &Cell<usize>,
+    callback: &mut LoopCallback,
+    run_file: &mut dyn FnMut(
+        &str,
+        u32,
+        core::LoopBudget,
+        &mut LoopCallback,
+    ) -> Result<core::LoopOutcome, core::CoreError>,
+) -> Result<core::LoopOutcome, core::CoreError> {
+    let start = clock.now();
+    let last = task_files.len().saturating_sub(1);
+    let remaining_after = |index: usize| -> usize {
+        task_files[index + 1..]
+            .iter()
+            .map(|file| core::count_remaining_tasks(&dir.join(file)))
+            .sum()
+    };
+    let mut iterations = 0;
+    let mut usage = Usage::default();
+    for (index, file) in task_files.iter().enumerate() {
+        if index < last && core::count_remaining_tasks(&dir.join(file)) == 0 {
+            continue;
+        }
+        current_file.set(index);
+        let offset = iterations;
+        let mut file_callback =
+            |name: Option<&str>, iteration: u32, status: LoopStatus, remaining: usize| {
+                let status = match status {
+                    LoopStatus::Complete if index < last => LoopStatus::Running,
+                    status => status,
+                };
+                callback(
+                    name,
+                    offset + iteration,
+                    status,
+                    remaining + remaining_after(index),
+                );
+            };
+        let elapsed = clock.now().duration_since(start).unwrap_or_default();
+        let outcome = run_file(
+            file,
+            max_iterations - iterations,
+            budget.remaining(&usage, elapsed),
+            &mut file_callback,
+        )?;
+        iterations += outcome.iterations;
+        usage.add(&outcome.usage);
+        let elapsed = clock.now().duration_since(start).unwrap_or_default();
+        let status = match outcome.status {
+            LoopStatus::Complete if index < last => {
+                if budget.exceeded_by(&usage).is_some() {
+                    LoopStatus::BudgetExceeded
+                } else if budget.max_duration.is_some_and(|max| elapsed >= max) {
+                    LoopStatus::DeadlineExceeded
+                } else if remaining_after(index) == 0 {
+                    LoopStatus::Complete
+                } else if iterations >= max_iterations {
+                    LoopStatus::MaxIterations
+                } else {
+                    continue;
+                }
+            }
+            status => status,
+        };
+        return Ok(core::LoopOutcome {
+            status,
+            iterations,
+            remaining_tasks: outcome.remaining_tasks + remaining_after(index),
+            duration_secs: elapsed.as_secs(),
+            usage,
+        });
+    }
+    Err(core::CoreError::InvalidInput(
+        "task_file is required".to_string(),
+    ))
+}
+
+fn open_pull_request_if_configured(
+    config: &Config,
+    args: &RunLoopArgs,
+    outcome: &core::LoopOutcome,
+    max_iterations: u32,
+    backend_name: &str,
+    store: &StateStore,
+    logger: &Logger,
+) {
+    let settings = gitops::PullRequestSettings::from_config(config);
+    if !settings.enabled
+        || !matches!(
+            outcome.status,
+            LoopStatus::Complete | LoopStatus::MaxIterations
+        )
+    {
+        return;
+    }
+    let title = format!("chore(gralph): {} run", args.name);
+    let task_spec = resolve_task_file(args, config);
+    let body = format_run_report(
+        &args.name,
+        outcome,
+        max_iterations,
+        backend_name,
+        &session_completed_tasks(&args.dir, &args.name),
+        &session_task_estimates(&args.dir, &args.name, &task_spec),
+        &open_task_checklists(&args.dir, &task_spec),
+    );
+    match gitops::publish_branch(&args.dir, &settings, &title, &body) {
+        Ok(url) => {
+            let _ = logger.log(
+                Level::Info,
+                &format!("Pull request opened: {}", url),
+                &[("pr_url", url.as_str().into())],
+            );
+            let _ = store.set_session(&args.name, &[("pr_url", &url)]);
+        }
+        Err(err) => {
+            let _ = logger.warn(&err.to_string());
+        }
+    }
+}
+
+/// Markdown summary of a finished run, used as the pull request body.
+fn format_run_report(
+    session: &str,
+    outcome: &core::LoopOutcome,
+    max_iterations: u32,
+    backend_name: &str,
+    completed_tasks: &[String],
+    estimates: &[TaskEstimate],
+    checklists: &[(String, prd::ChecklistProgress)],
+) -> String {
+    let mut report = format!(
+        "## gralph run report\n\n| | |\n|---|---|\n| Session | `{}` |\n| Status | {} |\n| Iterations | {}/{} |\n| Remaining tasks | {} |\n| Duration | {} |\n| Backend | {} |\n",
+        session,
+        outcome.status.as_str(),
+        outcome.iterations,
+        max_iterations,
+        outcome.remaining_tasks,
+        core::format_duration(outcome.duration_secs),
+        backend_name
+    );
+    report.push_str("\n### Completed tasks\n\n");
+    if completed_tasks.is_empty() {
+        report.push_str("None\n");
+    } else {
+        for id in completed_tasks {
+            report.push_str(&format!("- {}\n", id));
+        }
+    }
+    if !checklists.is_empty() {
+        report.push_str("\n### Open checklists\n\n");
+        for (id, progress) in checklists {
+            report.push_str(&format!("- {}: {}\n", id, progress));
+        }
+    }
+    if !estimates.is_empty() {
+        report
+            .push_str("\n### Estimate vs. actual\n\n| Task | Estimate | Actual |\n|---|---|---|\n");
+        for task in estimates {
+            report.push_str(&format!(
+                "| {} | {} | {} |\n",
+                task.id, task.estimate, task.actual
+            ));
+        }
+        report.push_str(&format!(
+            "| Total | {} | {} |\n",
+            estimates
+                .iter()
+                .map(|task| u64::from(task.estimate))
+                .sum::<u64>(),
+            estimates.iter().map(|task| task.actual).sum::<u64>()
+        ));
+    }
+    report
+}
+
+/// A finished task's `- **Estimate**` next to the iterations it took.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct TaskEstimate {
+    id: String,
+    estimate: u32,
+    actual: u64,
+}
+
+/// Task IDs that `.gralph/tasks.json` records as finished by `session`.
+fn session_completed_tasks(dir: &Path, session: &str) -> Vec<String> {
+    session_done_records(dir, session)
+        .iter()
+        .filter_map(|task| task.get("id").and_then(Value::as_str))
+        .map(str::to_string)
+        .collect()
+}
+
+/// Estimates of the tasks `session` finished, with the attempts
+/// `.gralph/tasks.json` counted for each, in task file order.
+fn session_task_estimates(dir: &Path, session: &str, task_spec: &str) -> Vec<TaskEstimate> {
+    let records = session_done_records(dir, session);
+    let files = core::resolve_task_files(dir, task_spec).unwrap_or_default();
+    files
+        .iter()
+        .filter_map(|file| fs::read_to_string(dir.join(file)).ok())
+        .flat_map(|contents| prd::prd_task_estimates(&contents))
+        .filter_map(|(id, estimate)| {
+            let record = records
+                .iter()
+                .find(|task| task.get("id").and_then(Value::as_str) == Some(id.as_str()))?;
+            Some(TaskEstimate {
+                actual: record.get("attempts").and_then(Value::as_u64).unwrap_or(0),
+                id,
+                estimate,
+            })
+        })
+        .collect()
+}
+
+/// Tasks whose tracked checklist items are not all checked, in task file
+/// order.
+fn open_task_checklists(dir: &Path, task_spec: &str) -> Vec<(String, prd::ChecklistProgress)> {
+    core::resolve_task_files(dir, task_spec)
+        .unwrap_or_default()
+        .iter()
+        .filter_map(|file| fs::read_to_string(dir.join(file)).ok())
+        .flat_map(|contents| prd::prd_checklist_progress(&contents))
+        .filter(|(_, progress)| !progress.is_complete())
+        .collect()
+}
+
+/// Records in `.gralph/tasks.json` of the tasks `session` finished.
+fn session_done_records(dir: &Path, session: &str) -> Vec<Value> {
+    let contents = fs::read_to_string(core::task_records_path(dir)).unwrap_or_default();
+    let Ok(records) = serde_json::from_str::<Value>(&contents) else {
+        return Vec::new();
+    };
+    records
+        .get("tasks")
+        .and_then(Value::as_object)
+        .map(|tasks| {
+            tasks
+                .values()
+                .filter(|task| {
+                    task.get("session").and_then(Value::as_str) == Some(session)
+                        && task.get("status").and_then(Value::as_str) == Some("done")
+                })
+                .cloned()
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
 fn run_single_iteration(args: RunLoopArgs, config: &Config, deps: &Deps) -> Result<(), CliError> {
-    let task_file = resolve_task_file(&args, config);
+    let task_spec = resolve_task_file(&args, config);
+    let task_files = resolve_task_files(&args.dir, &task_spec)?;
+    let task_file = core::active_task_file(&args.dir, &task_spec);
     let max_iterations = resolve_max_iterations(&args, config);
     let completion_marker = resolve_completion_marker(&args, config);
     let backend_name = resolve_backend_name(&args, config);
     let model = resolve_model(&args, config, &backend_name);
 
     if should_validate_prd(args.strict_prd) {
-        prd::prd_validate_file(&args.dir.join(&task_file), false, Some(&args.dir))
-            .map_err(|err| CliError::Message(err.to_string()))?;
+        for file in &task_files {
+            prd::prd_validate_file(&args.dir.join(file), false, Some(&args.dir))
+                .map_err(|err| CliError::Message(err.to_string()))?;
+        }
     }
 
     let prompt_template = match &args.prompt_template {
@@ -880,7 +2286,11 @@ fn run_single_iteration(args: RunLoopArgs, config: &Config, deps: &Deps) -> Resu
         None => None,
     };
 
-    let backend = backend_from_name(&backend_name).map_err(CliError::Message)?;
+    let backend = backend_from_config(&backend_name, config, &args.backend_args)
+        .map_err(CliError::Message)?;
+    ensure_network_allowed(backend.as_ref(), config.offline()).map_err(CliError::Message)?;
+    ensure_variant_supported(backend.as_ref(), args.variant.as_deref())
+        .map_err(CliError::Message)?;
     if !backend.check_installed() {
         return Err(CliError::Message(format!(
             "Backend is not installed: {}",
@@ -907,7 +2317,7 @@ fn run_single_iteration(args: RunLoopArgs, config: &Config, deps: &Deps) -> Resu
     )
     .map_err(|err| CliError::Message(err.to_string()))?;
 
-    let remaining = core::count_remaining_tasks(&args.dir.join(&task_file));
+    let remaining = core::count_remaining_for(&args.dir, &task_spec);
     println!("Step completed. Remaining tasks: {}", remaining);
 
     let complete = core::check_completion(
@@ -923,13 +2333,191 @@ fn run_single_iteration(args: RunLoopArgs, config: &Config, deps: &Deps) -> Resu
     Ok(())
 }
 
+fn run_task_with_state(
+    args: RunLoopArgs,
+    task_id: &str,
+    config: &Config,
+    deps: &Deps,
+) -> Result<(), CliError> {
+    let task_spec = resolve_task_file(&args, config);
+    let completion_marker = resolve_completion_marker(&args, config);
+    let backend_name = resolve_backend_name(&args, config);
+    let model = resolve_model(&args, config, &backend_name);
+    let mut found = None;
+    for file in resolve_task_files(&args.dir, &task_spec)? {
+        if let Some(block) = core::find_task_block(&args.dir.join(&file), task_id)
+            .map_err(|err| CliError::Message(err.to_string()))?
+        {
+            found = Some((file, block));
+            break;
+        }
+    }
+    let Some((task_file, block)) = found else {
+        return Err(CliError::Message(format!(
+            "Task not found in {}: {}",
+            args.dir.join(&task_spec).display(),
+            task_id
+        )));
+    };
+    let task_path = args.dir.join(&task_file);
+    if !block.lines().any(is_unchecked_line) {
+        return Err(CliError::Message(format!(
+            "Task is already complete: {}",
+            task_id
+        )));
+    }
+
+    let prompt_template = match &args.prompt_template {
+        Some(path) => Some(deps.fs().read_to_string(path).map_err(CliError::Io)?),
+        None => None,
+    };
+
+    let backend = backend_from_config(&backend_name, config, &args.backend_args)
+        .map_err(CliError::Message)?;
+    ensure_network_allowed(backend.as_ref(), config.offline()).map_err(CliError::Message)?;
+    ensure_variant_supported(backend.as_ref(), args.variant.as_deref())
+        .map_err(CliError::Message)?;
+    if !backend.check_installed() {
+        return Err(CliError::Message(format!(
+            "Backend is not installed: {}",
+            backend_name
+        )));
+    }
+
+    let store = deps.state_store().in_project(&args.dir);
+    store
+        .init_state()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    let log_file = args.dir.join(".gralph").join(format!("{}.log", args.name));
+    let raw_log_file = core::raw_log_path(&log_file);
+    store
+        .set_session(
+            &args.name,
+            &[
+                ("dir", &args.dir.to_string_lossy()),
+                ("task_file", &task_spec),
+                ("task_id", task_id),
+                ("pid", &deps.process().pid().to_string()),
+                ("tmux_session", ""),
+                ("started_at", &format_rfc3339(deps.clock())),
+                ("iteration", "1"),
+                ("max_iterations", "1"),
+                ("status", "running"),
+                (
+                    "last_task_count",
+                    &core::count_remaining_for(&args.dir, &task_spec).to_string(),
+                ),
+                ("completion_marker", &completion_marker),
+                ("log_file", &log_file.to_string_lossy()),
+                ("raw_log_file", &raw_log_file.to_string_lossy()),
+                ("backend", &backend_name),
+                ("model", model.as_deref().unwrap_or("")),
+                ("variant", args.variant.as_deref().unwrap_or("")),
+                ("backend_args", &shell_words::join(&args.backend_args)),
+                ("webhook", ""),
+            ],
+        )
+        .map_err(|err| CliError::Message(err.to_string()))?;
+
+    let before = core::task_states(&task_path);
+    let started = deps.clock().now();
+    let result = core::run_task_with_clock(
+        &*backend,
+        &args.dir,
+        &task_file,
+        task_id,
+        &completion_marker,
+        model.as_deref(),
+        args.variant.as_deref(),
+        Some(&log_file),
+        prompt_template.as_deref(),
+        Some(config),
+        deps.clock(),
+    );
+    let finished_at = deps.clock().now();
+    let remaining = core::count_remaining_for(&args.dir, &task_spec);
+    let status = if result.is_ok() {
+        LoopStatus::Complete
+    } else {
+        LoopStatus::Failed
+    };
+    store
+        .set_session(
+            &args.name,
+            &[
+                ("status", status.as_str()),
+                ("last_task_count", &remaining.to_string()),
+            ],
+        )
+        .map_err(|err| CliError::Message(err.to_string()))?;
+
+    let record = core::TaskIterationRecord {
+        session: &args.name,
+        backend: &backend_name,
+        iteration: 1,
+        duration_secs: finished_at
+            .duration_since(started)
+            .unwrap_or_default()
+            .as_secs(),
+        attempted: Some(task_id),
+        failed: result.is_err(),
+        finished_at,
+    };
+    if let Err(err) =
+        core::record_task_progress(&args.dir, &before, &core::task_states(&task_path), &record)
+    {
+        eprintln!("Warning: failed to record task progress: {}", err);
+    }
+
+    result.map_err(|err| CliError::Message(err.to_string()))?;
+    println!("Task {} completed. Remaining tasks: {}", task_id, remaining);
+    Ok(())
+}
+
 fn notify_if_configured(
     config: &Config,
     args: &RunLoopArgs,
     outcome: &core::LoopOutcome,
     max_iterations: u32,
     notifier: &dyn notify::Notifier,
+    store: &StateStore,
+    clock: &dyn core::Clock,
+    logger: &Logger,
 ) -> Result<(), CliError> {
+    let on_complete = config
+        .get("notifications.on_complete")
+        .map(|v| v == "true")
+        .unwrap_or(true);
+    let Some(decision) = notification_decision(outcome.status, on_complete) else {
+        return Ok(());
+    };
+    // Failure reasons and delivery errors can carry backend output or the
+    // webhook's own token. Warnings go through the session logger, which
+    // redacts them the same way.
+    let redactor = Redactor::from_config(config);
+    // The desktop notification is local, so it is sent offline too. It is
+    // best effort: a missing notifier never fails the run.
+    if config
+        .get("notifications.desktop")
+        .is_some_and(|v| v == "true")
+    {
+        let (title, message) = match decision {
+            NotificationDecision::Complete => notify::format_desktop_complete(
+                &args.name,
+                Some(outcome.iterations),
+                Some(outcome.duration_secs),
+            ),
+            NotificationDecision::Failed { reason } => notify::format_desktop_failed(
+                &args.name,
+                reason,
+                Some(outcome.remaining_tasks as u32),
+            ),
+        };
+        if let Err(err) = notifier.notify_desktop(&title, &redactor.redact(&message)) {
+            let _ = logger.warn(&format!("desktop notification failed: {}", err));
+        }
+    }
+
     let webhook = args
         .webhook
         .clone()
@@ -937,59 +2525,168 @@ fn notify_if_configured(
     let Some(webhook) = webhook else {
         return Ok(());
     };
+    if config.offline() {
+        println!("Offline: skipping webhook notification.");
+        return Ok(());
+    }
+    let webhook = with_notification_provider(config, webhook);
+    let template = resolve_payload_template(config, &args.dir)?;
+
+    let project = args.dir.to_string_lossy().into_owned();
+    let notification = match decision {
+        NotificationDecision::Complete => PendingNotification {
+            event: "complete".to_string(),
+            iterations: Some(outcome.iterations),
+            ..PendingNotification::default()
+        },
+        NotificationDecision::Failed { reason } => PendingNotification {
+            event: "failed".to_string(),
+            reason: Some(redactor.redact(reason).into_owned()),
+            iterations: Some(outcome.iterations),
+            max_iterations: Some(max_iterations),
+            remaining_tasks: Some(outcome.remaining_tasks as u32),
+            ..PendingNotification::default()
+        },
+    };
+    let notification = PendingNotification {
+        session: args.name.clone(),
+        webhook,
+        project: Some(project),
+        duration_secs: Some(outcome.duration_secs),
+        template,
+        timeout_secs: resolve_notification_timeout(config),
+        ..notification
+    };
 
-    let on_complete = config
-        .get("notifications.on_complete")
-        .map(|v| v == "true")
-        .unwrap_or(true);
-    match notification_decision(outcome.status, on_complete) {
-        Some(NotificationDecision::Complete) => {
-            notifier
-                .notify_complete(
-                    &args.name,
-                    &webhook,
-                    Some(&args.dir.to_string_lossy()),
-                    Some(outcome.iterations),
-                    Some(outcome.duration_secs),
-                    None,
-                )
-                .map_err(|err| CliError::Message(err.to_string()))?;
+    match notification.send(notifier) {
+        Ok(()) => {
+            // The webhook is reachable, so send what earlier runs left behind.
+            match notify_queue::retry_notifications(store, notifier, clock, false) {
+                Ok(summary) => {
+                    for (id, session, error) in summary.failed {
+                        let _ = logger.warn(&format!(
+                            "notification #{} for {} failed again: {}",
+                            id, session, error
+                        ));
+                    }
+                }
+                Err(err) => {
+                    let _ = logger.warn(&err.to_string());
+                }
+            }
+            Ok(())
         }
-        Some(NotificationDecision::Failed { reason }) => {
-            notifier
-                .notify_failed(
-                    &args.name,
-                    &webhook,
-                    Some(reason),
-                    Some(&args.dir.to_string_lossy()),
-                    Some(outcome.iterations),
-                    Some(max_iterations),
-                    Some(outcome.remaining_tasks as u32),
-                    Some(outcome.duration_secs),
-                    None,
-                )
-                .map_err(|err| CliError::Message(err.to_string()))?;
+        Err(err) if err.is_transient() => {
+            let message = redactor.redact(&err.to_string()).into_owned();
+            let id = notify_queue::queue_notification(store, notification, &err, &redactor, clock)
+                .map_err(|queue_err| {
+                    CliError::Message(format!(
+                        "{} (could not queue a retry: {})",
+                        message, queue_err
+                    ))
+                })?;
+            let _ = logger.warn(&format!(
+                "{}; queued as notification #{} for a retry (`gralph notify flush` sends it now)",
+                message, id
+            ));
+            Ok(())
         }
-        None => {}
+        Err(err) => Err(CliError::Message(
+            redactor.redact(&err.to_string()).into_owned(),
+        )),
+    }
+}
+
+fn resolve_progress_webhook(config: &Config, args: &RunLoopArgs) -> Option<String> {
+    let enabled = config
+        .get("notifications.progress")
+        .map(|v| v == "true")
+        .unwrap_or(false);
+    if !enabled || config.offline() {
+        return None;
     }
+    args.webhook
+        .clone()
+        .or_else(|| config.get("notifications.webhook"))
+        .filter(|webhook| !webhook.trim().is_empty())
+        .map(|webhook| with_notification_provider(config, webhook))
+}
 
-    Ok(())
+/// Prefixes the webhook with `notifications.provider` (`slack+https://...`)
+/// so that provider is used whatever the URL looks like. `auto`, the
+/// default, leaves the choice to the URL.
+pub(super) fn with_notification_provider(config: &Config, webhook: String) -> String {
+    let provider = config.get("notifications.provider").unwrap_or_default();
+    let provider = provider.trim();
+    if provider.is_empty() || provider == "auto" {
+        return webhook;
+    }
+    if notify::WebhookType::parse(provider).is_none() {
+        eprintln!(
+            "Warning: unknown notifications.provider {:?} (expected auto, {}); detecting from the URL",
+            provider,
+            notify::WebhookType::NAMES.join(", ")
+        );
+        return webhook;
+    }
+    format!("{}+{}", provider, webhook.trim())
+}
+
+/// Reads `notifications.template_file`, relative to the project, for a
+/// custom webhook payload.
+fn resolve_payload_template(config: &Config, dir: &Path) -> Result<Option<String>, CliError> {
+    let Some(file) = config
+        .get("notifications.template_file")
+        .filter(|file| !file.trim().is_empty())
+    else {
+        return Ok(None);
+    };
+    let path = dir.join(file.trim());
+    fs::read_to_string(&path).map(Some).map_err(|err| {
+        CliError::Message(format!(
+            "failed to read notifications.template_file {}: {}",
+            path.display(),
+            err
+        ))
+    })
+}
+
+fn resolve_notification_timeout(config: &Config) -> Option<u64> {
+    config
+        .get("notifications.timeout")
+        .and_then(|value| value.trim().parse::<u64>().ok())
+        .filter(|value| *value > 0)
 }
 
 fn run_loop_args_from_start(args: StartArgs, name: String) -> Result<RunLoopArgs, CliError> {
+    let pid_file = args
+        .daemon
+        .then(|| core::pid_file_path(&args.dir, Some(&name)));
     Ok(RunLoopArgs {
         dir: args.dir,
         name,
         max_iterations: args.max_iterations,
+        max_cost: args.max_cost,
+        max_tokens: args.max_tokens,
+        max_duration: args.max_duration,
         task_file: args.task_file,
         completion_marker: args.completion_marker,
         backend: args.backend,
         model: args.model,
         variant: args.variant,
+        backend_args: args.backend_args,
         prompt_template: args.prompt_template,
+        review_backend: args.review_backend,
+        review_model: args.review_model,
         webhook: args.webhook,
-        no_worktree: args.no_worktree,
+        // Per-task worktrees replace the per-run auto worktree.
+        no_worktree: args.no_worktree || args.worktree,
+        worktree: args.worktree,
         strict_prd: args.strict_prd,
+        pid_file,
+        resume: false,
+        profile: args.profile,
+        allow_concurrent: args.allow_concurrent,
     })
 }
 
@@ -998,66 +2695,203 @@ fn run_loop_args_from_step(args: StepArgs, name: String) -> Result<RunLoopArgs,
         dir: args.dir,
         name,
         max_iterations: args.max_iterations,
+        max_cost: None,
+        max_tokens: None,
+        max_duration: None,
         task_file: args.task_file,
         completion_marker: args.completion_marker,
         backend: args.backend,
         model: args.model,
         variant: args.variant,
+        backend_args: args.backend_args,
         prompt_template: args.prompt_template,
+        review_backend: None,
+        review_model: None,
         webhook: None,
         no_worktree: args.no_worktree,
+        worktree: false,
         strict_prd: args.strict_prd,
+        pid_file: None,
+        resume: false,
+        profile: config::active_profile(),
+        allow_concurrent: false,
     })
 }
 
+fn run_loop_args_from_run_task(args: RunTaskArgs, dir: PathBuf, name: String) -> RunLoopArgs {
+    RunLoopArgs {
+        dir,
+        name,
+        max_iterations: Some(1),
+        max_cost: None,
+        max_tokens: None,
+        max_duration: None,
+        task_file: args.task_file,
+        completion_marker: args.completion_marker,
+        backend: args.backend,
+        model: args.model,
+        variant: args.variant,
+        backend_args: args.backend_args,
+        prompt_template: args.prompt_template,
+        review_backend: None,
+        review_model: None,
+        webhook: None,
+        no_worktree: true,
+        worktree: false,
+        strict_prd: false,
+        pid_file: None,
+        resume: false,
+        profile: config::active_profile(),
+        allow_concurrent: false,
+    }
+}
+
+/// Starts `gralph run-loop` for `args`: under the gralph daemon when one is
+/// running, or as a detached child otherwise. Returns the loop's PID and
+/// whether the daemon supervises it.
+fn launch_run_loop(
+    store: &StateStore,
+    args: &RunLoopArgs,
+    process: &dyn ProcessRunner,
+    offline: bool,
+    cassette: Option<&Cassette>,
+) -> Result<(u32, bool), CliError> {
+    let argv = run_loop_command_args(args, offline, cassette);
+    if let Some(client) = daemon::DaemonClient::connect(store) {
+        let pid = client.spawn(&args.name, &args.dir, &argv)?;
+        return Ok((pid, true));
+    }
+    Ok((spawn_run_loop(args, &argv, process)?.id(), false))
+}
+
 fn spawn_run_loop(
     args: &RunLoopArgs,
+    argv: &[String],
     process: &dyn ProcessRunner,
 ) -> Result<std::process::Child, CliError> {
     let exe = process.current_exe().map_err(CliError::Io)?;
     let mut cmd = ProcCommand::new(exe);
-    cmd.arg("run-loop")
-        .arg(args.dir.to_string_lossy().as_ref())
-        .arg("--name")
-        .arg(&args.name);
+    cmd.args(argv);
+    if args.pid_file.is_some() {
+        detach_from_terminal(&mut cmd);
+    }
 
+    cmd.stdin(Stdio::null())
+        .stdout(Stdio::null())
+        .stderr(Stdio::null());
+    process
+        .spawn(&mut cmd)
+        .map_err(|err| CliError::Message(format!("Failed to start loop: {}", err)))
+}
+
+/// The `gralph run-loop` arguments that run `args`, offline when `offline`
+/// is set and through `cassette` when there is one.
+fn run_loop_command_args(
+    args: &RunLoopArgs,
+    offline: bool,
+    cassette: Option<&Cassette>,
+) -> Vec<String> {
+    let mut argv = vec![
+        "run-loop".to_string(),
+        args.dir.to_string_lossy().into_owned(),
+        "--name".to_string(),
+        args.name.clone(),
+    ];
     if let Some(max) = args.max_iterations {
-        cmd.arg("--max-iterations").arg(max.to_string());
+        argv.extend(["--max-iterations".to_string(), max.to_string()]);
+    }
+    if let Some(max) = args.max_cost {
+        argv.extend(["--max-cost".to_string(), max.to_string()]);
+    }
+    if let Some(max) = args.max_tokens {
+        argv.extend(["--max-tokens".to_string(), max.to_string()]);
+    }
+    if let Some(max) = args.max_duration.as_deref() {
+        argv.extend(["--max-duration".to_string(), max.to_string()]);
     }
     if let Some(task_file) = args.task_file.as_deref() {
-        cmd.arg("--task-file").arg(task_file);
+        argv.extend(["--task-file".to_string(), task_file.to_string()]);
     }
     if let Some(marker) = args.completion_marker.as_deref() {
-        cmd.arg("--completion-marker").arg(marker);
+        argv.extend(["--completion-marker".to_string(), marker.to_string()]);
     }
     if let Some(backend) = args.backend.as_deref() {
-        cmd.arg("--backend").arg(backend);
+        argv.extend(["--backend".to_string(), backend.to_string()]);
     }
     if let Some(model) = args.model.as_deref() {
-        cmd.arg("--model").arg(model);
+        argv.extend(["--model".to_string(), model.to_string()]);
     }
     if let Some(variant) = args.variant.as_deref() {
-        cmd.arg("--variant").arg(variant);
+        argv.extend(["--variant".to_string(), variant.to_string()]);
+    }
+    for backend_arg in &args.backend_args {
+        argv.extend(["--backend-arg".to_string(), backend_arg.to_string()]);
     }
     if let Some(template) = args.prompt_template.as_ref() {
-        cmd.arg("--prompt-template").arg(template);
+        argv.extend([
+            "--prompt-template".to_string(),
+            template.to_string_lossy().into_owned(),
+        ]);
+    }
+    if let Some(review_backend) = args.review_backend.as_deref() {
+        argv.extend(["--review-backend".to_string(), review_backend.to_string()]);
+    }
+    if let Some(review_model) = args.review_model.as_deref() {
+        argv.extend(["--review-model".to_string(), review_model.to_string()]);
     }
     if let Some(webhook) = args.webhook.as_deref() {
-        cmd.arg("--webhook").arg(webhook);
+        argv.extend(["--webhook".to_string(), webhook.to_string()]);
     }
     if args.no_worktree {
-        cmd.arg("--no-worktree");
+        argv.push("--no-worktree".to_string());
+    }
+    if args.worktree {
+        argv.push("--worktree".to_string());
     }
     if args.strict_prd {
-        cmd.arg("--strict-prd");
+        argv.push("--strict-prd".to_string());
+    }
+    if args.resume {
+        argv.push("--resume".to_string());
+    }
+    if let Some(profile) = args.profile.as_deref() {
+        argv.extend(["--profile".to_string(), profile.to_string()]);
     }
+    if args.allow_concurrent {
+        argv.push("--allow-concurrent".to_string());
+    }
+    if offline {
+        argv.push("--offline".to_string());
+    }
+    if let Some(cassette) = cassette {
+        argv.extend(cassette.args());
+    }
+    if let Some(pid_file) = args.pid_file.as_ref() {
+        argv.extend([
+            "--pid-file".to_string(),
+            pid_file.to_string_lossy().into_owned(),
+        ]);
+    }
+    argv
+}
 
-    cmd.stdin(Stdio::null())
-        .stdout(Stdio::null())
-        .stderr(Stdio::null());
-    process
-        .spawn(&mut cmd)
-        .map_err(|err| CliError::Message(format!("Failed to start loop: {}", err)))
+/// Run the child in its own session so closing the terminal that started it
+/// does not send it SIGHUP.
+pub(super) fn detach_from_terminal(cmd: &mut ProcCommand) {
+    #[cfg(unix)]
+    {
+        use std::os::unix::process::CommandExt;
+        unsafe {
+            cmd.pre_exec(|| {
+                if libc::setsid() == -1 {
+                    return Err(io::Error::last_os_error());
+                }
+                Ok(())
+            });
+        }
+    }
+    #[cfg(not(unix))]
+    let _ = cmd;
 }
 
 fn stop_session(
@@ -1072,8 +2906,22 @@ fn stop_session(
         }
     }
     let pid = session.get("pid").and_then(|v| v.as_i64()).unwrap_or(0);
-    process.kill_pid(pid);
-    store
+    let stopped_by_daemon = session.get("daemon").and_then(Value::as_bool) == Some(true)
+        && daemon::DaemonClient::connect(store).is_some_and(|client| client.stop(pid).is_ok());
+    if !stopped_by_daemon {
+        process.kill_pid(pid);
+    }
+    if let Some(dir) = session.get("dir").and_then(|v| v.as_str()) {
+        if !dir.trim().is_empty() {
+            let _ = fs::remove_file(core::pause_file_path(Path::new(dir), Some(name)));
+        }
+    }
+    if let Some(pid_file) = session.get("pid_file").and_then(|v| v.as_str()) {
+        if !pid_file.trim().is_empty() {
+            let _ = fs::remove_file(pid_file);
+        }
+    }
+    session_store(store, session)
         .set_session(
             name,
             &[("status", "stopped"), ("pid", "0"), ("tmux_session", "")],
@@ -1082,6 +2930,19 @@ fn stop_session(
     Ok(())
 }
 
+/// `store` narrowed to the project `session` belongs to, so an update by
+/// name reaches this session even when another project uses the same name.
+pub(super) fn session_store(store: &StateStore, session: &Value) -> StateStore {
+    match session
+        .get("dir")
+        .and_then(Value::as_str)
+        .filter(|dir| !dir.trim().is_empty())
+    {
+        Some(dir) => store.clone().in_project(Path::new(dir)),
+        None => store.clone(),
+    }
+}
+
 pub(super) fn resolve_log_file(
     name: &str,
     session: &serde_json::Value,
@@ -1113,32 +2974,174 @@ pub(super) fn resolve_raw_log_file(
     Ok(core::raw_log_path(&log_file))
 }
 
-fn follow_log(path: &Path, fs: &dyn FileSystem, clock: &dyn core::Clock) -> Result<(), CliError> {
+/// Streams appended log output. In JSON mode each complete line is emitted
+/// as a `{"line": ...}` object (NDJSON).
+pub(super) fn follow_log(
+    path: &Path,
+    json: bool,
+    fs: &dyn FileSystem,
+    clock: &dyn core::Clock,
+) -> Result<(), CliError> {
     let mut file = fs.open_read(path).map_err(CliError::Io)?;
     let mut pos = file.seek(SeekFrom::End(0)).map_err(CliError::Io)?;
+    let mut pending = String::new();
     loop {
         let mut buffer = String::new();
         file.seek(SeekFrom::Start(pos)).map_err(CliError::Io)?;
         let bytes = file.read_to_string(&mut buffer).map_err(CliError::Io)?;
         if bytes > 0 {
-            print!("{}", buffer);
+            if json {
+                pending.push_str(&buffer);
+                while let Some(index) = pending.find('\n') {
+                    let line = pending[..index].trim_end_matches('\r').to_string();
+                    pending.drain(..=index);
+                    print_json(&serde_json::json!({ "line": line }))?;
+                }
+            } else {
+                print!("{}", buffer);
+            }
             io::stdout().flush().map_err(CliError::Io)?;
             pos += bytes as u64;
         }
-        clock.sleep(Duration::from_millis(500));
+        clock.sleep(Duration::from_millis(500));
+    }
+}
+
+/// Line filters for `gralph logs`. Iterations come from the
+/// `=== Iteration N/M ===` markers (or the `iteration` field of JSON records)
+/// and times from `Started at:` lines (or the `time` field); each line takes
+/// the most recent of either.
+struct LogFilter {
+    iteration: Option<u32>,
+    pattern: Option<Regex>,
+    since: Option<SystemTime>,
+}
+
+impl LogFilter {
+    fn from_args(args: &LogsArgs, now: SystemTime) -> Result<Option<Self>, CliError> {
+        if args.iteration.is_none() && args.grep.is_none() && args.since.is_none() {
+            return Ok(None);
+        }
+        let pattern = args
+            .grep
+            .as_deref()
+            .map(Regex::new)
+            .transpose()
+            .map_err(|err| CliError::Message(format!("Invalid --grep pattern: {}", err)))?;
+        let since = match args.since.as_deref() {
+            Some(value) => {
+                let window = parse_since_duration(value).ok_or_else(|| {
+                    CliError::Message(format!(
+                        "Invalid --since duration: {} (expected e.g. 90s, 30m, 2h, 1d)",
+                        value
+                    ))
+                })?;
+                Some(now.checked_sub(window).unwrap_or(UNIX_EPOCH))
+            }
+            None => None,
+        };
+        Ok(Some(Self {
+            iteration: args.iteration,
+            pattern,
+            since,
+        }))
+    }
+
+    fn apply<'a>(&self, contents: &'a str) -> Vec<&'a str> {
+        let mut current_iteration = None;
+        let mut current_time = None;
+        let mut lines = Vec::new();
+        for line in contents.lines() {
+            let record = line
+                .trim_start()
+                .starts_with('{')
+                .then(|| serde_json::from_str::<Value>(line).ok())
+                .flatten();
+            let text = logging::display_line(line);
+            if let Some(iteration) = parse_iteration_marker(&text) {
+                current_iteration = Some(iteration);
+            }
+            if let Some(time) = text
+                .strip_prefix("Started at: ")
+                .and_then(core::parse_log_timestamp)
+            {
+                current_time = Some(time);
+            }
+            let record_iteration = record
+                .as_ref()
+                .and_then(|value| value.get("iteration"))
+                .and_then(Value::as_u64)
+                .map(|value| value as u32);
+            let record_time = record
+                .as_ref()
+                .and_then(|value| value.get("time"))
+                .and_then(Value::as_str)
+                .and_then(|value| chrono::DateTime::parse_from_rfc3339(value).ok())
+                .map(SystemTime::from);
+            if let Some(time) = record_time {
+                current_time = Some(time);
+            }
+
+            if let Some(wanted) = self.iteration {
+                if record_iteration.or(current_iteration) != Some(wanted) {
+                    continue;
+                }
+            }
+            if let Some(since) = self.since {
+                if !current_time.is_some_and(|time| time >= since) {
+                    continue;
+                }
+            }
+            if let Some(pattern) = &self.pattern {
+                if !pattern.is_match(line) {
+                    continue;
+                }
+            }
+            lines.push(line);
+        }
+        lines
     }
 }
 
+fn parse_iteration_marker(line: &str) -> Option<u32> {
+    let rest = line.trim().strip_prefix("=== Iteration ")?;
+    let (number, _) = rest.split_once('/')?;
+    number.trim().parse().ok()
+}
+
+/// Parse `--since` values such as `45s`, `30m`, `2h`, or `1d`; a bare
+/// number is seconds.
+pub(super) fn parse_since_duration(value: &str) -> Option<Duration> {
+    let value = value.trim();
+    let split = value
+        .find(|ch: char| !ch.is_ascii_digit())
+        .unwrap_or(value.len());
+    let (number, unit) = value.split_at(split);
+    let number: u64 = number.parse().ok()?;
+    let seconds = match unit.trim() {
+        "" | "s" => 1,
+        "m" => 60,
+        "h" => 3600,
+        "d" => 86400,
+        _ => return None,
+    };
+    Some(Duration::from_secs(number.checked_mul(seconds)?))
+}
+
 fn print_tail(path: &Path, lines: usize, fs: &dyn FileSystem) -> Result<(), CliError> {
     let contents = fs.read_to_string(path).map_err(CliError::Io)?;
-    let total: Vec<&str> = contents.lines().collect();
-    let start = total.len().saturating_sub(lines);
-    for line in &total[start..] {
+    for line in tail_lines(&contents, lines) {
         println!("{}", line);
     }
     Ok(())
 }
 
+pub(super) fn tail_lines(contents: &str, lines: usize) -> Vec<&str> {
+    let total: Vec<&str> = contents.lines().collect();
+    let start = total.len().saturating_sub(lines);
+    total[start..].to_vec()
+}
+
 fn print_cleanup_result(action: &str, empty_message: &str, sessions: &[String]) {
     if sessions.is_empty() {
         println!("{}", empty_message);
@@ -1156,7 +3159,7 @@ fn print_cleanup_result(action: &str, empty_message: &str, sessions: &[String])
     }
 }
 
-fn print_table(headers: &[&str], rows: &[Vec<String>]) {
+pub(super) fn print_table(headers: &[&str], rows: &[Vec<String>]) {
     let mut widths = headers.iter().map(|h| h.len()).collect::<Vec<_>>();
     for row in rows {
         for (index, col) in row.iter().enumerate() {
@@ -1188,6 +3191,7 @@ fn print_table(headers: &[&str], rows: &[Vec<String>]) {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::backend::cassette::CassetteMode;
     use std::env;
     use std::fs;
     use std::io;
@@ -1297,15 +3301,26 @@ mod tests {
             dir: PathBuf::from("."),
             name: "session".to_string(),
             max_iterations: None,
+            max_cost: None,
+            max_tokens: None,
+            max_duration: None,
             task_file: None,
             completion_marker: None,
             backend: None,
             model: None,
             variant: None,
+            backend_args: Vec::new(),
             prompt_template: None,
+            review_backend: None,
+            review_model: None,
             webhook: None,
             no_worktree: false,
+            worktree: false,
             strict_prd: false,
+            pid_file: None,
+            resume: false,
+            profile: None,
+            allow_concurrent: false,
         }
     }
 
@@ -1335,6 +3350,59 @@ mod tests {
         }
     }
 
+    #[test]
+    fn ensure_dir_available_rejects_a_live_loop_in_the_same_dir() {
+        let temp = tempfile::tempdir().unwrap();
+        let state_dir = temp.path().join("state");
+        let store = StateStore::with_paths(
+            state_dir.clone(),
+            state_dir.join("state.json"),
+            state_dir.join("state.lock"),
+            Duration::from_secs(1),
+        );
+        let project = temp.path().join("app");
+        let other = temp.path().join("web");
+        fs::create_dir_all(&project).unwrap();
+        fs::create_dir_all(&other).unwrap();
+        store
+            .set_session(
+                "app",
+                &[
+                    ("dir", &project.to_string_lossy()),
+                    ("pid", "4242"),
+                    ("status", "running"),
+                ],
+            )
+            .unwrap();
+
+        let alive = TestProcessRunner { alive: true };
+        let err = ensure_dir_available(&store, &project, &alive).unwrap_err();
+        assert!(err.to_string().contains("Session 'app' is already running"));
+        assert!(err.to_string().contains("--allow-concurrent"));
+        assert!(ensure_dir_available(&store, &other, &alive).is_ok());
+
+        let dead = TestProcessRunner { alive: false };
+        assert!(ensure_dir_available(&store, &project, &dead).is_ok());
+
+        store.set_session("app", &[("status", "complete")]).unwrap();
+        assert!(ensure_dir_available(&store, &project, &alive).is_ok());
+    }
+
+    #[test]
+    fn pid_file_is_removed_only_by_its_owner() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = core::pid_file_path(temp.path(), Some("demo"));
+
+        write_pid_file(&path, 4242).unwrap();
+        assert_eq!(fs::read_to_string(&path).unwrap(), "4242\n");
+
+        remove_pid_file(&path, 1111);
+        assert!(path.exists());
+
+        remove_pid_file(&path, 4242);
+        assert!(!path.exists());
+    }
+
     #[test]
     fn resolve_task_file_prefers_cli_config_then_default() {
         let _guard = env_guard();
@@ -1351,6 +3419,353 @@ mod tests {
         assert_eq!(resolve_task_file(&args, &config), "PRD.md");
     }
 
+    #[test]
+    fn resolve_notification_timeout_ignores_invalid_values() {
+        let _guard = env_guard();
+        let config = load_config("notifications:\n  timeout: 10\n");
+        assert_eq!(resolve_notification_timeout(&config), Some(10));
+
+        for raw in ["0", "soon", "-5"] {
+            let config = load_config(&format!("notifications:\n  timeout: {}\n", raw));
+            assert_eq!(resolve_notification_timeout(&config), None);
+        }
+
+        let config = load_config("notifications:\n  on_complete: true\n");
+        assert_eq!(resolve_notification_timeout(&config), None);
+    }
+
+    #[derive(Default)]
+    struct RecordingNotifier {
+        calls: std::sync::Mutex<Vec<String>>,
+        /// Fails webhook sends with this HTTP status when set.
+        webhook_status: Option<u16>,
+    }
+
+    impl RecordingNotifier {
+        fn webhook_result(&self) -> Result<(), notify::NotifyError> {
+            match self.webhook_status {
+                Some(code) => Err(notify::NotifyError::HttpStatus(code)),
+                None => Ok(()),
+            }
+        }
+    }
+
+    fn test_store(dir: &Path) -> StateStore {
+        StateStore::with_paths(
+            dir.to_path_buf(),
+            dir.join("state.json"),
+            dir.join("state.lock"),
+            Duration::from_secs(1),
+        )
+    }
+
+    impl notify::Notifier for RecordingNotifier {
+        fn notify_complete(
+            &self,
+            session_name: &str,
+            _webhook_url: &str,
+            _project_dir: Option<&str>,
+            _iterations: Option<u32>,
+            _duration_secs: Option<u64>,
+            _payload_template: Option<&str>,
+            _timeout_secs: Option<u64>,
+        ) -> Result<(), notify::NotifyError> {
+            self.calls
+                .lock()
+                .unwrap()
+                .push(format!("webhook complete {}", session_name));
+            self.webhook_result()
+        }
+
+        fn notify_failed(
+            &self,
+            session_name: &str,
+            _webhook_url: &str,
+            failure_reason: Option<&str>,
+            _project_dir: Option<&str>,
+            _iterations: Option<u32>,
+            _max_iterations: Option<u32>,
+            _remaining_tasks: Option<u32>,
+            _duration_secs: Option<u64>,
+            _payload_template: Option<&str>,
+            _timeout_secs: Option<u64>,
+        ) -> Result<(), notify::NotifyError> {
+            self.calls.lock().unwrap().push(format!(
+                "webhook failed {} {}",
+                session_name,
+                failure_reason.unwrap_or("")
+            ));
+            self.webhook_result()
+        }
+
+        fn notify_progress(
+            &self,
+            _session_name: &str,
+            _webhook_url: &str,
+            _project_dir: Option<&str>,
+            _iteration: u32,
+            _max_iterations: Option<u32>,
+            _remaining_tasks: Option<u32>,
+            _task_id: Option<&str>,
+            _payload_template: Option<&str>,
+            _timeout_secs: Option<u64>,
+        ) -> Result<(), notify::NotifyError> {
+            Ok(())
+        }
+
+        fn notify_desktop(&self, title: &str, _message: &str) -> Result<(), notify::NotifyError> {
+            self.calls
+                .lock()
+                .unwrap()
+                .push(format!("desktop {}", title));
+            Err(notify::NotifyError::Command(
+                "notify-send not found".to_string(),
+            ))
+        }
+    }
+
+    #[test]
+    fn notify_if_configured_sends_desktop_notifications_alongside_webhooks() {
+        let _guard = env_guard();
+        let mut args = base_args();
+        args.name = "demo".to_string();
+        let outcome = |status| core::LoopOutcome {
+            status,
+            iterations: 3,
+            remaining_tasks: 2,
+            duration_secs: 60,
+            usage: Usage::default(),
+        };
+
+        let temp = tempfile::tempdir().unwrap();
+        let store = test_store(temp.path());
+        let log_path = temp.path().join("demo.log");
+        let logger = Logger::new(Some(&log_path), LogSettings::default());
+        let notifier = RecordingNotifier::default();
+        let config = load_config("notifications:\n  desktop: true\n");
+        notify_if_configured(
+            &config,
+            &args,
+            &outcome(LoopStatus::Complete),
+            5,
+            &notifier,
+            &store,
+            &core::SystemClock,
+            &logger,
+        )
+        .unwrap();
+        assert_eq!(
+            *notifier.calls.lock().unwrap(),
+            vec!["desktop gralph: demo complete"]
+        );
+        assert!(
+            fs::read_to_string(&log_path)
+                .unwrap()
+                .contains("desktop notification failed: ")
+        );
+
+        let notifier = RecordingNotifier::default();
+        let config =
+            load_config("notifications:\n  desktop: true\n  webhook: https://example.com/hook\n");
+        notify_if_configured(
+            &config,
+            &args,
+            &outcome(LoopStatus::MaxIterations),
+            5,
+            &notifier,
+            &store,
+            &core::SystemClock,
+            &logger,
+        )
+        .unwrap();
+        assert_eq!(
+            *notifier.calls.lock().unwrap(),
+            vec![
+                "desktop gralph: demo failed",
+                "webhook failed demo max_iterations"
+            ]
+        );
+
+        let notifier = RecordingNotifier::default();
+        let config = load_config("notifications:\n  webhook: https://example.com/hook\n");
+        notify_if_configured(
+            &config,
+            &args,
+            &outcome(LoopStatus::Complete),
+            5,
+            &notifier,
+            &store,
+            &core::SystemClock,
+            &logger,
+        )
+        .unwrap();
+        assert_eq!(
+            *notifier.calls.lock().unwrap(),
+            vec!["webhook complete demo"]
+        );
+    }
+
+    #[test]
+    fn notify_if_configured_queues_webhooks_that_fail_to_send() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let store = test_store(temp.path());
+        let logger = Logger::new(Some(&temp.path().join("demo.log")), LogSettings::default());
+        let mut args = base_args();
+        args.name = "demo".to_string();
+        let outcome = core::LoopOutcome {
+            status: LoopStatus::Complete,
+            iterations: 3,
+            remaining_tasks: 0,
+            duration_secs: 60,
+            usage: Usage::default(),
+        };
+        let config = load_config("notifications:\n  webhook: https://example.com/hook\n");
+        let notifier = RecordingNotifier {
+            webhook_status: Some(503),
+            ..RecordingNotifier::default()
+        };
+
+        notify_if_configured(
+            &config,
+            &args,
+            &outcome,
+            5,
+            &notifier,
+            &store,
+            &core::SystemClock,
+            &logger,
+        )
+        .unwrap();
+
+        let pending = store.list_notifications().unwrap();
+        assert_eq!(pending.len(), 1);
+        assert_eq!(pending[0]["session"], "demo");
+        assert_eq!(pending[0]["event"], "complete");
+        assert_eq!(pending[0]["webhook"], "https://example.com/hook");
+        assert_eq!(pending[0]["attempts"], 1);
+
+        // A rejected request is not retried.
+        let notifier = RecordingNotifier {
+            webhook_status: Some(404),
+            ..RecordingNotifier::default()
+        };
+        assert!(
+            notify_if_configured(
+                &config,
+                &args,
+                &outcome,
+                5,
+                &notifier,
+                &store,
+                &core::SystemClock,
+                &logger
+            )
+            .is_err()
+        );
+        assert_eq!(store.list_notifications().unwrap().len(), 1);
+    }
+
+    #[test]
+    fn resolve_progress_webhook_requires_flag_and_webhook() {
+        let _guard = env_guard();
+        let mut args = base_args();
+        let config = load_config("notifications:\n  webhook: https://example.com/hook\n");
+        assert_eq!(resolve_progress_webhook(&config, &args), None);
+
+        let config =
+            load_config("notifications:\n  progress: true\n  webhook: https://example.com/hook\n");
+        assert_eq!(
+            resolve_progress_webhook(&config, &args).as_deref(),
+            Some("https://example.com/hook")
+        );
+
+        args.webhook = Some("https://example.com/cli".to_string());
+        assert_eq!(
+            resolve_progress_webhook(&config, &args).as_deref(),
+            Some("https://example.com/cli")
+        );
+
+        assert_eq!(
+            resolve_progress_webhook(&config.with_offline(true), &args),
+            None
+        );
+
+        let config = load_config("notifications:\n  progress: true\n");
+        args.webhook = None;
+        assert_eq!(resolve_progress_webhook(&config, &args), None);
+    }
+
+    #[test]
+    fn run_loop_command_args_pass_offline_mode_and_cassette_to_the_loop() {
+        let args = base_args();
+        let argv = run_loop_command_args(&args, false, None);
+        assert!(!argv.contains(&"--offline".to_string()));
+        assert!(!argv.contains(&"--replay".to_string()));
+
+        let cassette = Cassette {
+            mode: CassetteMode::Replay,
+            dir: PathBuf::from("/tmp/cassette"),
+        };
+        let argv = run_loop_command_args(&args, true, Some(&cassette));
+        assert!(argv.contains(&"--offline".to_string()));
+        assert!(
+            argv.windows(2)
+                .any(|pair| pair == ["--replay", "/tmp/cassette"])
+        );
+    }
+
+    #[test]
+    fn resolve_payload_template_reads_the_file_from_the_project() {
+        let temp = tempfile::tempdir().unwrap();
+        write_file(
+            &temp.path().join("hooks/incident.json"),
+            "{\"name\": \"{session}\"}",
+        );
+
+        let config = load_config("notifications:\n  template_file: hooks/incident.json\n");
+        assert_eq!(
+            resolve_payload_template(&config, temp.path())
+                .unwrap()
+                .as_deref(),
+            Some("{\"name\": \"{session}\"}")
+        );
+        assert_eq!(
+            resolve_payload_template(
+                &load_config("notifications:\n  progress: true\n"),
+                temp.path()
+            )
+            .unwrap(),
+            None
+        );
+        let config = load_config("notifications:\n  template_file: missing.json\n");
+        assert!(
+            resolve_payload_template(&config, temp.path())
+                .unwrap_err()
+                .to_string()
+                .contains("failed to read notifications.template_file")
+        );
+    }
+
+    #[test]
+    fn with_notification_provider_prefixes_explicit_providers() {
+        let url = "https://relay.example.com/hook".to_string();
+        let provider = |yaml: &str| {
+            with_notification_provider(
+                &load_config(&format!("notifications:\n{}", yaml)),
+                url.clone(),
+            )
+        };
+
+        assert_eq!(
+            provider("  provider: slack\n"),
+            "slack+https://relay.example.com/hook"
+        );
+        assert_eq!(provider("  provider: auto\n"), url);
+        assert_eq!(provider("  provider: pager\n"), url);
+        assert_eq!(provider("  desktop: true\n"), url);
+    }
+
     #[test]
     fn enrich_status_session_includes_log_and_task_fields() {
         let temp = tempfile::tempdir().unwrap();
@@ -1442,6 +3857,41 @@ mod tests {
         assert_eq!(resolve_backend_name(&args, &config), "claude");
     }
 
+    #[test]
+    fn resolve_backend_chain_reads_cli_lists_and_config_fallbacks() {
+        let _guard = env_guard();
+        let config = load_config(
+            "defaults:\n  backend: claude\n  backend_fallbacks: [opencode, claude, gemini]\n  backend_fallback_after: 2\n",
+        );
+        let mut args = base_args();
+
+        assert_eq!(
+            resolve_backend_chain(&args, &config),
+            vec!["claude", "opencode", "gemini"]
+        );
+        assert_eq!(resolve_fallback_after(&config), 2);
+
+        args.backend = Some("codex, ollama".to_string());
+        assert_eq!(
+            resolve_backend_chain(&args, &config),
+            vec!["codex", "ollama"]
+        );
+        assert_eq!(resolve_backend_name(&args, &config), "codex");
+
+        args.backend = Some("codex".to_string());
+        assert_eq!(
+            resolve_backend_chain(&args, &config),
+            vec!["codex", "opencode", "claude", "gemini"]
+        );
+
+        let config = load_config("defaults:\n  backend_fallback_after: 0\n");
+        assert_eq!(resolve_backend_chain(&args, &config), vec!["codex"]);
+        assert_eq!(
+            resolve_fallback_after(&config),
+            fallback::DEFAULT_MAX_FAILURES
+        );
+    }
+
     #[test]
     fn resolve_model_prefers_cli_or_config_and_opencode_default() {
         let _guard = env_guard();
@@ -1471,6 +3921,107 @@ mod tests {
         );
     }
 
+    #[test]
+    fn resolve_budget_prefers_cli_caps_over_config() {
+        let _guard = env_guard();
+        let config = load_config("defaults:\n  max_cost_usd: 20\n  max_tokens: 500000\n");
+        let mut args = base_args();
+        assert_eq!(
+            resolve_budget(&args, &config).unwrap(),
+            core::LoopBudget {
+                max_cost_usd: Some(20.0),
+                max_tokens: Some(500_000),
+                max_duration: None,
+            }
+        );
+
+        args.max_cost = Some(5.5);
+        args.max_tokens = Some(0);
+        args.max_duration = Some("4h".to_string());
+        assert_eq!(
+            resolve_budget(&args, &config).unwrap(),
+            core::LoopBudget {
+                max_cost_usd: Some(5.5),
+                max_tokens: None,
+                max_duration: Some(Duration::from_secs(4 * 3600)),
+            }
+        );
+
+        args.max_duration = Some("soon".to_string());
+        assert!(resolve_budget(&args, &config).is_err());
+        args.max_duration = None;
+        args.max_cost = Some(-1.0);
+        assert!(resolve_budget(&args, &config).is_err());
+        let config = load_config("defaults:\n  max_cost_usd: lots\n");
+        assert!(resolve_budget(&base_args(), &config).is_err());
+    }
+
+    #[test]
+    fn run_task_files_continues_into_the_next_file_after_completion() {
+        let temp = tempfile::tempdir().unwrap();
+        let dir = temp.path();
+        fs::write(dir.join("PRD-a.md"), "- [ ] A1\n- [ ] A2\n").unwrap();
+        fs::write(dir.join("PRD-b.md"), "- [ ] B1\n").unwrap();
+        fs::write(dir.join("PRD-c.md"), "- [x] C1\n").unwrap();
+        let files = vec![
+            "PRD-c.md".to_string(),
+            "PRD-a.md".to_string(),
+            "PRD-b.md".to_string(),
+        ];
+
+        let current = Cell::new(0);
+        let mut reported = Vec::new();
+        let mut runs = Vec::new();
+        let outcome = run_task_files(
+            dir,
+            &files,
+            10,
+            core::LoopBudget::default(),
+            &core::SystemClock,
+            &current,
+            &mut |_, iteration, status, remaining| reported.push((iteration, status, remaining)),
+            &mut |file, max_iterations, _, callback| {
+                runs.push((file.to_string(), max_iterations));
+                let path = dir.join(file);
+                let contents = fs::read_to_string(&path).unwrap();
+                callback(
+                    None,
+                    1,
+                    LoopStatus::Running,
+                    core::count_remaining_tasks(&path),
+                );
+                fs::write(&path, contents.replace("- [ ]", "- [x]")).unwrap();
+                callback(None, 2, LoopStatus::Complete, 0);
+                Ok(core::LoopOutcome {
+                    status: LoopStatus::Complete,
+                    iterations: 2,
+                    remaining_tasks: 0,
+                    duration_secs: 0,
+                    usage: Usage::default(),
+                })
+            },
+        )
+        .unwrap();
+
+        assert_eq!(
+            runs,
+            vec![("PRD-a.md".to_string(), 10), ("PRD-b.md".to_string(), 8)]
+        );
+        assert_eq!(current.get(), 2);
+        assert_eq!(
+            reported,
+            vec![
+                (1, LoopStatus::Running, 3),
+                (2, LoopStatus::Running, 1),
+                (3, LoopStatus::Running, 1),
+                (4, LoopStatus::Complete, 0),
+            ]
+        );
+        assert_eq!(outcome.status, LoopStatus::Complete);
+        assert_eq!(outcome.iterations, 4);
+        assert_eq!(outcome.remaining_tasks, 0);
+    }
+
     #[test]
     fn should_validate_prd_matches_flag() {
         assert!(should_validate_prd(true));
@@ -1479,7 +4030,14 @@ mod tests {
 
     #[test]
     fn should_resume_session_handles_status_and_pid() {
-        for status in ["stale", "stopped", "failed"] {
+        for status in [
+            "stale",
+            "stopped",
+            "failed",
+            "stalled",
+            "budget_exceeded",
+            "deadline_exceeded",
+        ] {
             assert!(should_resume_session(status, 123, true));
             assert!(should_resume_session(status, 0, false));
         }
@@ -1487,6 +4045,8 @@ mod tests {
         assert!(should_resume_session("running", 0, false));
         assert!(should_resume_session("running", 123, false));
         assert!(!should_resume_session("running", 123, true));
+        assert!(should_resume_session("paused", 123, false));
+        assert!(!should_resume_session("paused", 123, true));
         assert!(!should_resume_session("complete", 123, false));
         assert!(!should_resume_session("unknown", 0, false));
     }
@@ -1527,6 +4087,137 @@ mod tests {
         assert_eq!(plan, OutcomeStatusPlan::Final { status: "complete" });
     }
 
+    #[test]
+    fn format_run_report_lists_session_tasks() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::create_dir_all(temp.path().join(".gralph")).unwrap();
+        fs::write(
+            core::task_records_path(temp.path()),
+            r#"{"tasks":{"A-1":{"id":"A-1","status":"done","session":"demo"},"A-2":{"id":"A-2","status":"failed","session":"demo"},"B-1":{"id":"B-1","status":"done","session":"other"}}}"#,
+        )
+        .unwrap();
+        let completed = session_completed_tasks(temp.path(), "demo");
+        assert_eq!(completed, vec!["A-1"]);
+
+        let outcome = core::LoopOutcome {
+            status: LoopStatus::MaxIterations,
+            iterations: 30,
+            remaining_tasks: 2,
+            duration_secs: 65,
+            usage: Usage::default(),
+        };
+        let report = format_run_report("demo", &outcome, 30, "claude", &completed, &[], &[]);
+        assert!(report.contains("| Status | max_iterations |"));
+        assert!(report.contains("| Iterations | 30/30 |"));
+        assert!(report.contains("| Duration | 1m 5s (65s) |"));
+        assert!(report.ends_with("### Completed tasks\n\n- A-1\n"));
+    }
+
+    #[test]
+    fn format_run_report_compares_estimates_with_attempts() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::create_dir_all(temp.path().join(".gralph")).unwrap();
+        fs::write(
+            core::task_records_path(temp.path()),
+            r#"{"tasks":{"A-1":{"id":"A-1","status":"done","session":"demo","attempts":3},"A-2":{"id":"A-2","status":"done","session":"demo","attempts":1},"A-3":{"id":"A-3","status":"failed","session":"demo","attempts":2}}}"#,
+        )
+        .unwrap();
+        fs::write(
+            temp.path().join("PRD.md"),
+            "### Task A-1\n- **ID** A-1\n- **Estimate** M\n- [x] A-1 Task\n---\n### Task A-2\n- **ID** A-2\n- [x] A-2 Task\n---\n### Task A-3\n- **ID** A-3\n- **Estimate** S\n- [ ] A-3 Task\n",
+        )
+        .unwrap();
+
+        let estimates = session_task_estimates(temp.path(), "demo", "PRD.md");
+        assert_eq!(
+            estimates,
+            vec![TaskEstimate {
+                id: "A-1".to_string(),
+                estimate: 2,
+                actual: 3,
+            }]
+        );
+
+        let outcome = core::LoopOutcome {
+            status: LoopStatus::Complete,
+            iterations: 4,
+            remaining_tasks: 1,
+            duration_secs: 10,
+            usage: Usage::default(),
+        };
+        let report = format_run_report(
+            "demo",
+            &outcome,
+            30,
+            "claude",
+            &["A-1".to_string()],
+            &estimates,
+            &[],
+        );
+        assert!(report.ends_with(
+            "### Estimate vs. actual\n\n| Task | Estimate | Actual |\n|---|---|---|\n| A-1 | 2 | 3 |\n| Total | 2 | 3 |\n"
+        ));
+    }
+
+    #[test]
+    fn format_run_report_lists_open_checklists() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(
+            temp.path().join("PRD.md"),
+            "### Task A-1\n- **ID** A-1\n- **Checklist**\n  * [x] One\n  * [x] Two\n- [x] A-1 Task\n---\n### Task A-2\n- **ID** A-2\n- **Checklist**\n  * [x] One\n  * [ ] Two\n  * [ ] Three\n- [ ] A-2 Task\n---\n### Task A-3\n- **ID** A-3\n- **Checklist**\n  * Untracked\n- [ ] A-3 Task\n",
+        )
+        .unwrap();
+
+        let checklists = open_task_checklists(temp.path(), "PRD.md");
+        assert_eq!(
+            checklists,
+            vec![(
+                "A-2".to_string(),
+                prd::ChecklistProgress { done: 1, total: 3 }
+            )]
+        );
+
+        let outcome = core::LoopOutcome {
+            status: LoopStatus::MaxIterations,
+            iterations: 3,
+            remaining_tasks: 2,
+            duration_secs: 10,
+            usage: Usage::default(),
+        };
+        let report = format_run_report(
+            "demo",
+            &outcome,
+            3,
+            "claude",
+            &["A-1".to_string()],
+            &[],
+            &checklists,
+        );
+        assert!(report.ends_with("### Open checklists\n\n- A-2: 1/3 checklist items\n"));
+    }
+
+    #[test]
+    fn resolve_max_iterations_derives_auto_from_estimates() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(
+            temp.path().join("PRD.md"),
+            "### Task A-1\n- **ID** A-1\n- **Estimate** L\n- [ ] A-1 Task\n---\n### Task A-2\n- **ID** A-2\n- [ ] A-2 Task\n",
+        )
+        .unwrap();
+        let args = RunLoopArgs {
+            dir: temp.path().to_path_buf(),
+            ..base_args()
+        };
+
+        let auto = Config::from_yaml("defaults:\n  max_iterations: auto\n").unwrap();
+        assert_eq!(resolve_max_iterations(&args, &auto), 8);
+        let fixed = Config::from_yaml("defaults:\n  max_iterations: 12\n").unwrap();
+        assert_eq!(resolve_max_iterations(&args, &fixed), 12);
+
+        fs::write(temp.path().join("PRD.md"), "- [ ] Task\n").unwrap();
+        assert_eq!(resolve_max_iterations(&args, &auto), 30);
+    }
+
     #[test]
     fn notification_decision_maps_statuses() {
         assert_eq!(
@@ -1545,5 +4236,114 @@ mod tests {
             })
         );
         assert_eq!(notification_decision(LoopStatus::Running, true), None);
+        assert_eq!(notification_decision(LoopStatus::Paused, true), None);
+        assert_eq!(notification_decision(LoopStatus::Stopped, true), None);
+        assert_eq!(
+            notification_decision(LoopStatus::Stalled, false),
+            Some(NotificationDecision::Failed { reason: "stalled" })
+        );
+        assert_eq!(
+            notification_decision(LoopStatus::BudgetExceeded, false),
+            Some(NotificationDecision::Failed {
+                reason: "budget_exceeded"
+            })
+        );
+        assert_eq!(
+            notification_decision(LoopStatus::DeadlineExceeded, false),
+            Some(NotificationDecision::Failed {
+                reason: "deadline_exceeded"
+            })
+        );
+    }
+
+    fn logs_args(iteration: Option<u32>, grep: Option<&str>, since: Option<&str>) -> LogsArgs {
+        LogsArgs {
+            name: Some("demo".to_string()),
+            follow: false,
+            raw: false,
+            iteration,
+            grep: grep.map(str::to_string),
+            since: since.map(str::to_string),
+        }
+    }
+
+    #[test]
+    fn log_filter_selects_iteration_and_pattern() {
+        let contents = "Starting gralph loop in /tmp\n\n=== Iteration 1/3 (Remaining: 2) ===\nok one\n\n=== Iteration 2/3 (Remaining: 2) ===\nError: Iteration failed: boom\nRaw output saved to: x\n";
+        let now = SystemTime::now();
+
+        let filter = LogFilter::from_args(&logs_args(Some(2), None, None), now)
+            .unwrap()
+            .unwrap();
+        assert_eq!(
+            filter.apply(contents),
+            vec![
+                "=== Iteration 2/3 (Remaining: 2) ===",
+                "Error: Iteration failed: boom",
+                "Raw output saved to: x",
+            ]
+        );
+
+        let filter = LogFilter::from_args(&logs_args(None, Some("^(ok|Error)"), None), now)
+            .unwrap()
+            .unwrap();
+        assert_eq!(
+            filter.apply(contents),
+            vec!["ok one", "Error: Iteration failed: boom"]
+        );
+
+        assert!(
+            LogFilter::from_args(&logs_args(None, None, None), now)
+                .unwrap()
+                .is_none()
+        );
+    }
+
+    #[test]
+    fn log_filter_since_uses_started_at_and_json_times() {
+        let now = SystemTime::now();
+        let old = core::format_timestamp(now - Duration::from_secs(3 * 3600));
+        let recent = core::format_timestamp(now - Duration::from_secs(600));
+        let json_time: chrono::DateTime<chrono::Utc> = (now - Duration::from_secs(60)).into();
+        let contents = format!(
+            "=== Iteration 1/2 (Remaining: 1) ===\nStarted at: {}\nold line\n=== Iteration 2/2 (Remaining: 1) ===\nStarted at: {}\nnew line\n{{\"level\":\"info\",\"msg\":\"json line\",\"time\":\"{}\"}}\n",
+            old,
+            recent,
+            json_time.to_rfc3339()
+        );
+
+        let filter = LogFilter::from_args(&logs_args(None, None, Some("1h")), now)
+            .unwrap()
+            .unwrap();
+        let lines = filter.apply(&contents);
+
+        assert_eq!(lines.len(), 3);
+        assert_eq!(lines[0], format!("Started at: {}", recent));
+        assert_eq!(lines[1], "new line");
+        assert!(lines[2].contains("json line"));
+    }
+
+    #[test]
+    fn log_filter_rejects_bad_arguments() {
+        let now = SystemTime::now();
+        let err = LogFilter::from_args(&logs_args(None, Some("("), None), now)
+            .err()
+            .unwrap();
+        assert!(err.to_string().contains("Invalid --grep pattern"));
+
+        let err = LogFilter::from_args(&logs_args(None, None, Some("2w")), now)
+            .err()
+            .unwrap();
+        assert!(err.to_string().contains("Invalid --since duration"));
+    }
+
+    #[test]
+    fn parse_since_duration_handles_units() {
+        assert_eq!(parse_since_duration("90"), Some(Duration::from_secs(90)));
+        assert_eq!(parse_since_duration("30m"), Some(Duration::from_secs(1800)));
+        assert_eq!(parse_since_duration("2h"), Some(Duration::from_secs(7200)));
+        assert_eq!(parse_since_duration("1d"), Some(Duration::from_secs(86400)));
+        assert_eq!(parse_since_duration("h"), None);
+        assert_eq!(parse_since_duration("5y"), None);
     }
 }
@@ -0,0 +1,237 @@
+use super::CliError;
+use serde_json::Value;
+use std::io::{self, BufRead, IsTerminal, Write};
+
+/// A session offered by the picker.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(super) struct PickerEntry {
+    pub(super) name: String,
+    pub(super) status: String,
+    pub(super) dir: String,
+}
+
+impl PickerEntry {
+    pub(super) fn from_session(session: &Value) -> Option<Self> {
+        let field = |key: &str| {
+            session
+                .get(key)
+                .and_then(Value::as_str)
+                .unwrap_or("")
+                .to_string()
+        };
+        let name = field("name");
+        if name.is_empty() {
+            return None;
+        }
+        Some(Self {
+            name,
+            status: session
+                .get("status")
+                .and_then(Value::as_str)
+                .unwrap_or("unknown")
+                .to_string(),
+            dir: field("dir"),
+        })
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(super) enum Pick {
+    Session(String),
+    /// `all` was entered where the command accepts it (`resume`).
+    All,
+}
+
+/// Asks for a session on the terminal when `stop`, `logs`, or `resume` got
+/// no name. Returns None when stdin is not a terminal, so scripts keep the
+/// non-interactive behavior.
+pub(super) fn pick_on_terminal(
+    entries: &[PickerEntry],
+    action: &str,
+    allow_all: bool,
+) -> Result<Option<Pick>, CliError> {
+    if !io::stdin().is_terminal() {
+        return Ok(None);
+    }
+    if entries.is_empty() {
+        return Err(CliError::Message(format!("No sessions to {}.", action)));
+    }
+    let stdin = io::stdin();
+    pick_session(
+        entries,
+        action,
+        allow_all,
+        &mut stdin.lock(),
+        &mut io::stdout(),
+    )
+    .map(Some)
+}
+
+/// Lists `entries` and reads choices until one is picked. A number picks
+/// from the list shown, other text narrows the list by fuzzy match, an empty
+/// line picks the only remaining match, and `q` or end of input cancels.
+pub(super) fn pick_session<R: BufRead, W: Write>(
+    entries: &[PickerEntry],
+    action: &str,
+    allow_all: bool,
+    input: &mut R,
+    output: &mut W,
+) -> Result<Pick, CliError> {
+    let mut shown: Vec<&PickerEntry> = entries.iter().collect();
+    loop {
+        print_entries(output, &shown).map_err(CliError::Io)?;
+        let hint = if allow_all {
+            "number, text to filter, all, or q"
+        } else {
+            "number, text to filter, or q"
+        };
+        write!(output, "Session to {} ({}): ", action, hint).map_err(CliError::Io)?;
+        output.flush().map_err(CliError::Io)?;
+
+        let mut line = String::new();
+        if input.read_line(&mut line).map_err(CliError::Io)? == 0 {
+            return Err(CliError::Message("No session selected.".to_string()));
+        }
+        let choice = line.trim();
+        match choice {
+            "q" | "quit" => return Err(CliError::Message("No session selected.".to_string())),
+            "all" if allow_all => return Ok(Pick::All),
+            "" if shown.len() == 1 => return Ok(Pick::Session(shown[0].name.clone())),
+            "" => continue,
+            _ => {}
+        }
+        if let Ok(number) = choice.parse::<usize>() {
+            match number.checked_sub(1).and_then(|index| shown.get(index)) {
+                Some(entry) => return Ok(Pick::Session(entry.name.clone())),
+                None => {
+                    writeln!(output, "No session numbered {}.", number).map_err(CliError::Io)?;
+                    continue;
+                }
+            }
+        }
+        let matches: Vec<&PickerEntry> = entries
+            .iter()
+            .filter(|entry| fuzzy_matches(choice, &entry.name) || fuzzy_matches(choice, &entry.dir))
+            .collect();
+        if matches.is_empty() {
+            writeln!(output, "No sessions match '{}'.", choice).map_err(CliError::Io)?;
+        } else {
+            shown = matches;
+        }
+    }
+}
+
+fn print_entries<W: Write>(output: &mut W, entries: &[&PickerEntry]) -> io::Result<()> {
+    let name_width = entries
+        .iter()
+        .map(|entry| entry.name.len())
+        .max()
+        .unwrap_or(0);
+    let status_width = entries
+        .iter()
+        .map(|entry| entry.status.len())
+        .max()
+        .unwrap_or(0);
+    for (index, entry) in entries.iter().enumerate() {
+        writeln!(
+            output,
+            "{:>3}) {:<name_width$}  {:<status_width$}  {}",
+            index + 1,
+            entry.name,
+            entry.status,
+            entry.dir
+        )?;
+    }
+    Ok(())
+}
+
+/// Case-insensitive subsequence match: `gw` matches `gralph-web`.
+fn fuzzy_matches(query: &str, text: &str) -> bool {
+    let mut chars = text.chars().flat_map(char::to_lowercase);
+    query
+        .chars()
+        .flat_map(char::to_lowercase)
+        .all(|wanted| chars.any(|ch| ch == wanted))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io::Cursor;
+
+    fn entries() -> Vec<PickerEntry> {
+        [
+            ("api", "running", "/work/api"),
+            ("web-app", "stale", "/work/web"),
+            ("worker", "stopped", "/work/jobs"),
+        ]
+        .into_iter()
+        .map(|(name, status, dir)| PickerEntry {
+            name: name.to_string(),
+            status: status.to_string(),
+            dir: dir.to_string(),
+        })
+        .collect()
+    }
+
+    fn pick(input: &str, allow_all: bool) -> (Result<Pick, CliError>, String) {
+        let mut output = Vec::new();
+        let result = pick_session(
+            &entries(),
+            "stop",
+            allow_all,
+            &mut Cursor::new(input.as_bytes()),
+            &mut output,
+        );
+        (result, String::from_utf8(output).unwrap())
+    }
+
+    #[test]
+    fn fuzzy_matches_subsequences_case_insensitively() {
+        assert!(fuzzy_matches("wa", "web-app"));
+        assert!(fuzzy_matches("WAP", "web-app"));
+        assert!(fuzzy_matches("", "api"));
+        assert!(!fuzzy_matches("aw", "web"));
+    }
+
+    #[test]
+    fn pick_session_by_number_and_filter() {
+        let (result, output) = pick("2\n", false);
+        assert_eq!(result.unwrap(), Pick::Session("web-app".to_string()));
+        assert!(output.contains("  1) api      running  /work/api"));
+        assert!(output.contains("Session to stop (number, text to filter, or q): "));
+
+        let (result, output) = pick("wkr\n1\n", false);
+        assert_eq!(result.unwrap(), Pick::Session("worker".to_string()));
+        assert!(output.contains("  1) worker  stopped  /work/jobs"));
+
+        let (result, _) = pick("jobs\n\n", false);
+        assert_eq!(result.unwrap(), Pick::Session("worker".to_string()));
+    }
+
+    #[test]
+    fn pick_session_reports_bad_input_and_cancels() {
+        let (result, output) = pick("9\nzzz\nq\n", false);
+        assert!(
+            result
+                .unwrap_err()
+                .to_string()
+                .contains("No session selected")
+        );
+        assert!(output.contains("No session numbered 9."));
+        assert!(output.contains("No sessions match 'zzz'."));
+
+        let (result, _) = pick("", false);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn pick_session_accepts_all_only_when_allowed() {
+        let (result, _) = pick("all\n", true);
+        assert_eq!(result.unwrap(), Pick::All);
+
+        let (result, output) = pick("all\nq\n", false);
+        assert!(result.is_err());
+        assert!(output.contains("No sessions match 'all'."));
+    }
+}
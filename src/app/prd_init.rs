@@ -1,18 +1,37 @@
-use super::{CliError, join_or_none, normalize_csv};
-use crate::backend::backend_from_name;
-use crate::cli::{InitArgs, PrdArgs, PrdCheckArgs, PrdCommand, PrdCreateArgs};
-use crate::config::Config;
+use super::progress::Progress;
+use super::{CliError, Deps, join_or_none, normalize_csv, print_json};
+use crate::backend::{
+    BACKEND_NAMES, Backend, backend_from_config, command_in_path, ensure_network_allowed,
+    ensure_variant_supported,
+};
+use crate::cli::{
+    InitArgs, PrdAddTaskArgs, PrdArgs, PrdCheckArgs, PrdCommand, PrdCreateArgs, PrdFixArgs,
+    PrdGraphArgs, PrdMergeArgs, PrdParseArgs, PrdProgressArgs, PrdRenumberArgs, PrdSplitArgs,
+};
+use crate::config::{self, Config};
+use crate::gitops;
 use crate::prd;
+use crate::sources;
+use std::cell::Cell;
 use std::collections::BTreeMap;
 use std::env;
 use std::fs;
-use std::io::{self, Write};
+use std::io::{self, BufRead, IsTerminal, Write};
 use std::path::{Path, PathBuf};
 
-pub(super) fn cmd_prd(args: PrdArgs) -> Result<(), CliError> {
+pub(super) fn cmd_prd(args: PrdArgs, json: bool, deps: &Deps) -> Result<(), CliError> {
     match args.command {
-        PrdCommand::Check(args) => cmd_prd_check(args),
-        PrdCommand::Create(args) => cmd_prd_create(args),
+        PrdCommand::AddTask(args) => cmd_prd_add_task(args),
+        PrdCommand::Check(args) => cmd_prd_check(args, json),
+        PrdCommand::Create(args) => cmd_prd_create(args, deps),
+        PrdCommand::Fix(args) => cmd_prd_fix(args, json),
+        PrdCommand::Graph(args) => cmd_prd_graph(args),
+        PrdCommand::Merge(args) => cmd_prd_merge(args, json),
+        PrdCommand::Parse(args) => cmd_prd_parse(args, json),
+        PrdCommand::Progress(args) => cmd_prd_progress(args, json),
+        PrdCommand::Renumber(args) => cmd_prd_renumber(args, json),
+        PrdCommand::Split(args) => cmd_prd_split(args, json),
+        PrdCommand::Templates(args) => cmd_prd_templates(args),
     }
 }
 
@@ -29,18 +48,13 @@ pub(super) fn cmd_init(args: InitArgs) -> Result<(), CliError> {
 
     let config =
         Config::load(Some(&target_dir)).map_err(|err| CliError::Message(err.to_string()))?;
+    let mut summary = InitSummary::default();
+
     let config_list = config.get("defaults.context_files");
     let entries = resolve_init_context_files(&target_dir, config_list.as_deref());
     if entries.is_empty() {
         println!("No context files configured.");
-        return Ok(());
     }
-
-    let mut created = Vec::new();
-    let mut overwritten = Vec::new();
-    let mut skipped = Vec::new();
-    let mut skipped_non_md = Vec::new();
-
     for entry in entries {
         let path = if Path::new(&entry).is_absolute() {
             PathBuf::from(&entry)
@@ -49,55 +63,696 @@ pub(super) fn cmd_init(args: InitArgs) -> Result<(), CliError> {
         };
         if !is_markdown_path(&path) {
             println!("Skipping non-markdown entry: {}", entry);
-            skipped_non_md.push(entry);
-            continue;
-        }
-        let display = format_display_path(&path, &target_dir);
-        let existed = path.exists();
-        if existed && !args.force {
-            skipped.push(display);
+            summary.skipped_non_md.push(entry);
             continue;
         }
-
-        if let Some(parent) = path.parent() {
-            fs::create_dir_all(parent).map_err(CliError::Io)?;
-        }
         let contents = init_template_for_path(&path);
-        write_atomic(&path, &contents, args.force).map_err(CliError::Io)?;
+        init_file(&mut summary, &path, &target_dir, &contents, args.force)?;
+    }
 
-        if existed {
-            overwritten.push(display);
-        } else {
-            created.push(display);
-        }
+    let configured_backend = config.get_or("defaults.backend", "claude");
+    let backend = detect_init_backend(&configured_backend);
+    let stack = prd::prd_detect_stack(&target_dir);
+    init_file(
+        &mut summary,
+        &target_dir.join(".gralph.yaml"),
+        &target_dir,
+        &starter_project_config(&backend, &stack),
+        args.force,
+    )?;
+    // The bundled template, not one the project already has.
+    let manifest_dir = Path::new(env!("CARGO_MANIFEST_DIR"));
+    init_file(
+        &mut summary,
+        &target_dir.join("PRD.template.md"),
+        &target_dir,
+        &read_prd_template_with_manifest(manifest_dir, manifest_dir)?,
+        args.force,
+    )?;
+
+    let state_dir = target_dir.join(".gralph");
+    fs::create_dir_all(&state_dir).map_err(CliError::Io)?;
+    init_file(
+        &mut summary,
+        &state_dir.join(".gitignore"),
+        &target_dir,
+        GRALPH_DIR_GITIGNORE,
+        args.force,
+    )?;
+    let gitignore = target_dir.join(".gitignore");
+    if append_missing_lines(&gitignore, &[".worktrees/"]).map_err(CliError::Io)? {
+        summary
+            .updated
+            .push(format_display_path(&gitignore, &target_dir));
+    }
+
+    if args.git_hooks {
+        let task_file = config.get_or("defaults.task_file", "PRD.md");
+        install_pre_commit_hook(&mut summary, &target_dir, &task_file, args.force)?;
     }
 
     println!("Init summary:");
-    println!("Created ({}): {}", created.len(), join_or_none(&created));
+    println!(
+        "Created ({}): {}",
+        summary.created.len(),
+        join_or_none(&summary.created)
+    );
     println!(
         "Overwritten ({}): {}",
-        overwritten.len(),
-        join_or_none(&overwritten)
+        summary.overwritten.len(),
+        join_or_none(&summary.overwritten)
     );
-    println!("Skipped ({}): {}", skipped.len(), join_or_none(&skipped));
-    if !skipped_non_md.is_empty() {
+    println!(
+        "Updated ({}): {}",
+        summary.updated.len(),
+        join_or_none(&summary.updated)
+    );
+    println!(
+        "Skipped ({}): {}",
+        summary.skipped.len(),
+        join_or_none(&summary.skipped)
+    );
+    if !summary.skipped_non_md.is_empty() {
         println!(
             "Non-markdown skipped ({}): {}",
-            skipped_non_md.len(),
-            join_or_none(&skipped_non_md)
+            summary.skipped_non_md.len(),
+            join_or_none(&summary.skipped_non_md)
         );
     }
+    println!();
+    println!("Next steps:");
+    println!("  1. Review .gralph.yaml (backend: {})", backend);
+    println!("  2. Fill in the context files, such as ARCHITECTURE.md");
+    println!("  3. gralph prd create --goal \"...\" --output PRD.md");
+    println!("  4. gralph prd check PRD.md");
+    println!("  5. gralph start .");
+    Ok(())
+}
+
+/// What `gralph init` did with each file, by display path.
+#[derive(Default)]
+struct InitSummary {
+    created: Vec<String>,
+    overwritten: Vec<String>,
+    updated: Vec<String>,
+    skipped: Vec<String>,
+    skipped_non_md: Vec<String>,
+}
+
+/// Run state under `.gralph/` that should not be committed. Other files there,
+/// such as `prompt-template.txt`, are meant to be shared.
+const GRALPH_DIR_GITIGNORE: &str =
+    "# Run state written by gralph\n*.log\n*.pid\n*.pause\ncheckpoints/\n";
+
+/// Writes `contents` to `path` unless it exists and `force` is off. Returns
+/// whether the file was written.
+fn init_file(
+    summary: &mut InitSummary,
+    path: &Path,
+    target_dir: &Path,
+    contents: &str,
+    force: bool,
+) -> Result<bool, CliError> {
+    let display = format_display_path(path, target_dir);
+    let existed = path.exists();
+    if existed && !force {
+        summary.skipped.push(display);
+        return Ok(false);
+    }
+
+    if let Some(parent) = path.parent() {
+        fs::create_dir_all(parent).map_err(CliError::Io)?;
+    }
+    write_atomic(path, contents, force).map_err(CliError::Io)?;
+
+    if existed {
+        summary.overwritten.push(display);
+    } else {
+        summary.created.push(display);
+    }
+    Ok(true)
+}
+
+/// Appends the lines of `entries` that `path` does not contain yet, creating
+/// the file if needed. Returns whether anything was added.
+pub(super) fn append_missing_lines(path: &Path, entries: &[&str]) -> Result<bool, io::Error> {
+    let existing = match fs::read_to_string(path) {
+        Ok(contents) => contents,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => String::new(),
+        Err(err) => return Err(err),
+    };
+    let missing: Vec<&str> = entries
+        .iter()
+        .copied()
+        .filter(|entry| !existing.lines().any(|line| line.trim() == *entry))
+        .collect();
+    if missing.is_empty() {
+        return Ok(false);
+    }
+
+    let mut contents = existing;
+    if !contents.is_empty() && !contents.ends_with('\n') {
+        contents.push('\n');
+    }
+    for entry in missing {
+        contents.push_str(entry);
+        contents.push('\n');
+    }
+    fs::write(path, contents)?;
+    Ok(true)
+}
+
+/// The configured backend when its CLI is installed (or it is the
+/// `openai` HTTP backend), else the first installed backend in
+/// `gralph backends` order, else the configured one.
+fn detect_init_backend(configured: &str) -> String {
+    if configured == "openai" || command_in_path(configured) {
+        return configured.to_string();
+    }
+    BACKEND_NAMES
+        .iter()
+        .find(|name| command_in_path(name))
+        .map_or_else(|| configured.to_string(), |name| name.to_string())
+}
+
+/// A test command for the first detected stack that has a conventional one.
+pub(super) fn detected_test_command(stack: &prd::StackDetection) -> Option<String> {
+    stack.ids.iter().find_map(|id| match id.as_str() {
+        "Rust" => Some("cargo test --workspace".to_string()),
+        "Go" => Some("go test ./...".to_string()),
+        "Node.js" => Some(format!(
+            "{} test",
+            stack.package_managers.first().map_or("npm", String::as_str)
+        )),
+        "Deno" => Some("deno test".to_string()),
+        "Python" => Some("pytest".to_string()),
+        "Elixir" => Some("mix test".to_string()),
+        ".NET" => Some("dotnet test".to_string()),
+        "Flutter" => Some("flutter test".to_string()),
+        "Dart" => Some("dart test".to_string()),
+        "Zig" => Some("zig build test".to_string()),
+        _ => None,
+    })
+}
+
+/// The `.gralph.yaml` written by `gralph init`: the detected backend and test
+/// command, with the most commonly changed keys spelled out.
+pub(super) fn starter_project_config(backend: &str, stack: &prd::StackDetection) -> String {
+    let stack_line = if stack.ids.is_empty() {
+        "none detected".to_string()
+    } else {
+        stack.ids.join(", ")
+    };
+    let test_command = match detected_test_command(stack) {
+        Some(command) => format!("  test_command: {}\n", command),
+        None => "  # test_command: <command that runs the tests>\n".to_string(),
+    };
+    format!(
+        "# gralph project config, merged over the global and default config.\n\
+         # Detected stack: {stack_line}\n\
+         defaults:\n  \
+         backend: {backend}\n  \
+         task_file: PRD.md\n  \
+         max_iterations: 30\n\
+         \n\
+         verifier:\n\
+         {test_command}  \
+         # coverage_command: <command that prints a coverage percentage>\n\
+         \n\
+         git:\n  \
+         auto_commit: false\n",
+    )
+}
+
+/// Installs a `pre-commit` hook that runs `gralph prd check` on the task
+/// file when it exists and gralph is on the PATH.
+fn install_pre_commit_hook(
+    summary: &mut InitSummary,
+    target_dir: &Path,
+    task_file: &str,
+    force: bool,
+) -> Result<(), CliError> {
+    let hooks_dir = gitops::hooks_dir(target_dir).ok_or_else(|| {
+        CliError::Message(format!(
+            "--git-hooks needs a git repository: {}",
+            target_dir.display()
+        ))
+    })?;
+    // Hooks run from the repository root.
+    let task_path = gitops::repo_root(target_dir)
+        .and_then(|root| {
+            let root = root.canonicalize().ok()?;
+            let dir = target_dir.canonicalize().ok()?;
+            dir.strip_prefix(&root).ok().map(|dir| dir.join(task_file))
+        })
+        .unwrap_or_else(|| PathBuf::from(task_file));
+    let hook = hooks_dir.join("pre-commit");
+    if init_file(
+        summary,
+        &hook,
+        target_dir,
+        &pre_commit_hook(&task_path.to_string_lossy()),
+        force,
+    )? {
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::set_permissions(&hook, fs::Permissions::from_mode(0o755)).map_err(CliError::Io)?;
+        }
+    }
     Ok(())
 }
 
-fn cmd_prd_check(args: PrdCheckArgs) -> Result<(), CliError> {
-    prd::prd_validate_file(&args.file, args.allow_missing_context, None)
+pub(super) fn pre_commit_hook(task_file: &str) -> String {
+    let quoted = shell_words::quote(task_file);
+    format!(
+        "#!/bin/sh\n\
+         # Installed by `gralph init --git-hooks`: validate the PRD before each commit.\n\
+         if [ -f {quoted} ] && command -v gralph >/dev/null 2>&1; then\n  \
+         exec gralph prd check {quoted}\n\
+         fi\n",
+    )
+}
+
+fn cmd_prd_add_task(args: PrdAddTaskArgs) -> Result<(), CliError> {
+    let interactive = io::stdin().is_terminal();
+    let id = required_task_field(args.id, "ID", "--id", interactive)?;
+    let summary = required_task_field(args.summary, "Summary", "--summary", interactive)?;
+    let dod = required_task_field(args.dod, "DoD", "--dod", interactive)?;
+    let context = match args.context {
+        Some(value) => value,
+        None if interactive => prompt_line("Context Bundle (comma-separated)")?,
+        None => {
+            return Err(CliError::Message(
+                "Context is required. Use --context.".to_string(),
+            ));
+        }
+    };
+
+    let task = prd::NewTask {
+        id,
+        summary,
+        dod,
+        checklist: args
+            .checklist
+            .iter()
+            .map(|item| item.trim().to_string())
+            .filter(|item| !item.is_empty())
+            .collect(),
+        dependencies: normalize_csv(args.deps.as_deref().unwrap_or("")),
+        context: normalize_csv(&context),
+        issue: args
+            .issue
+            .map(|issue| issue.trim().to_string())
+            .filter(|issue| !issue.is_empty()),
+    };
+    prd::prd_add_task_file(&args.file, &task, args.allow_missing_context)
         .map_err(|err| CliError::Message(err.to_string()))?;
-    println!("PRD validation passed: {}", args.file.display());
+    println!("Added task {} to {}", task.id, args.file.display());
     Ok(())
 }
 
-fn cmd_prd_create(args: PrdCreateArgs) -> Result<(), CliError> {
+fn required_task_field(
+    value: Option<String>,
+    label: &str,
+    flag: &str,
+    interactive: bool,
+) -> Result<String, CliError> {
+    let value = match value {
+        Some(value) => value,
+        None if interactive => prompt_line(label)?,
+        None => String::new(),
+    };
+    let value = value.trim().to_string();
+    if value.is_empty() {
+        return Err(CliError::Message(format!(
+            "{} is required. Use {}.",
+            label, flag
+        )));
+    }
+    Ok(value)
+}
+
+fn prompt_line(label: &str) -> Result<String, CliError> {
+    print!("{}: ", label);
+    io::stdout().flush().map_err(CliError::Io)?;
+    let mut line = String::new();
+    io::stdin()
+        .lock()
+        .read_line(&mut line)
+        .map_err(CliError::Io)?;
+    Ok(line.trim().to_string())
+}
+
+fn cmd_prd_check(args: PrdCheckArgs, json: bool) -> Result<(), CliError> {
+    let issues = prd::prd_lint_file(&args.file, args.allow_missing_context, None);
+    let (errors, warnings): (Vec<_>, Vec<_>) = issues.iter().partition(|issue| issue.is_error());
+    if json {
+        print_json(&serde_json::json!({
+            "file": args.file.to_string_lossy(),
+            "valid": errors.is_empty(),
+            "errors": errors.iter().map(|issue| &issue.message).collect::<Vec<_>>(),
+            "issues": issues.iter().map(prd_issue_json).collect::<Vec<_>>(),
+        }))?;
+    } else {
+        for warning in &warnings {
+            eprintln!("{}", warning.message);
+        }
+    }
+    if !errors.is_empty() {
+        let messages = errors
+            .iter()
+            .map(|issue| issue.message.as_str())
+            .collect::<Vec<_>>();
+        return Err(CliError::Message(messages.join("\n")));
+    }
+    if !json {
+        if warnings.is_empty() {
+            println!("PRD validation passed: {}", args.file.display());
+        } else {
+            println!(
+                "PRD validation passed with {} warning(s): {}",
+                warnings.len(),
+                args.file.display()
+            );
+        }
+    }
+    Ok(())
+}
+
+fn prd_issue_json(issue: &prd::PrdIssue) -> serde_json::Value {
+    serde_json::json!({
+        "code": issue.code,
+        "severity": issue.severity.as_str(),
+        "line": issue.line,
+        "task": issue.task,
+        "message": issue.message,
+    })
+}
+
+fn cmd_prd_fix(args: PrdFixArgs, json: bool) -> Result<(), CliError> {
+    if !args.file.is_file() {
+        return Err(CliError::Message(format!(
+            "Task file does not exist: {}",
+            args.file.display()
+        )));
+    }
+    let fixes = prd::prd_fix_file(&args.file, args.dry_run)
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    // Whatever the fixer cannot touch still needs a human.
+    let remaining = if args.dry_run {
+        Vec::new()
+    } else {
+        prd::prd_lint_file(&args.file, false, None)
+    };
+
+    if json {
+        return print_json(&serde_json::json!({
+            "file": args.file.to_string_lossy(),
+            "dry_run": args.dry_run,
+            "fixes": fixes
+                .iter()
+                .map(|fix| serde_json::json!({"line": fix.line, "description": fix.description}))
+                .collect::<Vec<_>>(),
+            "issues": remaining.iter().map(prd_issue_json).collect::<Vec<_>>(),
+        }));
+    }
+
+    if fixes.is_empty() {
+        println!("Nothing to fix: {}", args.file.display());
+    } else {
+        let verb = if args.dry_run {
+            "Would apply"
+        } else {
+            "Applied"
+        };
+        println!(
+            "{} {} fix(es) to {}:",
+            verb,
+            fixes.len(),
+            args.file.display()
+        );
+        for fix in &fixes {
+            println!("  line {}: {}", fix.line, fix.description);
+        }
+    }
+    if !remaining.is_empty() {
+        println!("Remaining issues:");
+        for issue in &remaining {
+            println!("  {}", issue.message);
+        }
+    }
+    Ok(())
+}
+
+fn cmd_prd_graph(args: PrdGraphArgs) -> Result<(), CliError> {
+    let contents = fs::read_to_string(&args.file).map_err(|err| {
+        CliError::Message(format!(
+            "Failed to read PRD {}: {}",
+            args.file.display(),
+            err
+        ))
+    })?;
+    let graph = prd::TaskGraph::from_contents(&contents);
+    if graph.nodes.is_empty() {
+        println!("No task blocks found: {}", args.file.display());
+        return Ok(());
+    }
+    print!("{}", graph.render());
+    if let Some(cycle) = graph.find_cycle() {
+        return Err(CliError::Message(format!(
+            "Dependency cycle detected: {}",
+            cycle.join(" -> ")
+        )));
+    }
+    Ok(())
+}
+
+fn cmd_prd_parse(args: PrdParseArgs, json: bool) -> Result<(), CliError> {
+    let document = prd::prd_parse_file(&args.file).map_err(|err| {
+        CliError::Message(format!(
+            "Failed to read PRD {}: {}",
+            args.file.display(),
+            err
+        ))
+    })?;
+    if json || args.format == "json" {
+        let value =
+            serde_json::to_value(&document).map_err(|err| CliError::Message(err.to_string()))?;
+        return print_json(&value);
+    }
+    let rendered =
+        serde_yaml::to_string(&document).map_err(|err| CliError::Message(err.to_string()))?;
+    print!("{}", rendered);
+    Ok(())
+}
+
+fn cmd_prd_progress(args: PrdProgressArgs, json: bool) -> Result<(), CliError> {
+    let contents = fs::read_to_string(&args.file).map_err(|err| {
+        CliError::Message(format!(
+            "Failed to read PRD {}: {}",
+            args.file.display(),
+            err
+        ))
+    })?;
+    let progress = prd::prd_progress(&contents);
+    if json {
+        let value =
+            serde_json::to_value(&progress).map_err(|err| CliError::Message(err.to_string()))?;
+        return print_json(&value);
+    }
+    if progress.tasks.is_empty() {
+        println!("No task blocks found: {}", args.file.display());
+        return Ok(());
+    }
+    if args.mermaid {
+        print!("{}", progress.render_mermaid());
+    } else {
+        print!("{}", progress.render());
+    }
+    Ok(())
+}
+
+fn cmd_prd_merge(args: PrdMergeArgs, json: bool) -> Result<(), CliError> {
+    for file in [&args.old, &args.new] {
+        if !file.is_file() {
+            return Err(CliError::Message(format!(
+                "Task file does not exist: {}",
+                file.display()
+            )));
+        }
+    }
+    let output = args.output.clone().unwrap_or_else(|| args.old.clone());
+    let report = prd::prd_merge_files(&args.old, &args.new, &output, args.dry_run)
+        .map_err(|err| CliError::Message(err.to_string()))?;
+
+    if json {
+        return print_json(&serde_json::json!({
+            "old": args.old.to_string_lossy(),
+            "new": args.new.to_string_lossy(),
+            "output": output.to_string_lossy(),
+            "dry_run": args.dry_run,
+            "kept": report.kept,
+            "added": report.added,
+            "updated": report.updated,
+            "conflicts": report.conflicts,
+        }));
+    }
+
+    let verb = if args.dry_run {
+        "Would merge"
+    } else {
+        "Merged"
+    };
+    println!(
+        "{} {} into {}: {} kept, {} added, {} updated",
+        verb,
+        args.new.display(),
+        output.display(),
+        report.kept.len(),
+        report.added.len(),
+        report.updated.len()
+    );
+    if !report.conflicts.is_empty() {
+        println!("Conflicts:");
+        for conflict in &report.conflicts {
+            match &conflict.task {
+                Some(task) => println!("  {}: {}", task, conflict.message),
+                None => println!("  {}", conflict.message),
+            }
+        }
+    }
+    Ok(())
+}
+
+fn cmd_prd_renumber(args: PrdRenumberArgs, json: bool) -> Result<(), CliError> {
+    if !args.file.is_file() {
+        return Err(CliError::Message(format!(
+            "Task file does not exist: {}",
+            args.file.display()
+        )));
+    }
+    let options = prd::RenumberOptions {
+        prefix: args.prefix,
+        from: args.from,
+        start: args.start,
+    };
+    let renamed = prd::prd_renumber_file(&args.file, &options, args.dry_run)
+        .map_err(|err| CliError::Message(err.to_string()))?;
+
+    if json {
+        return print_json(&serde_json::json!({
+            "file": args.file.to_string_lossy(),
+            "dry_run": args.dry_run,
+            "renamed": renamed,
+        }));
+    }
+
+    if renamed.is_empty() {
+        println!("Task IDs already in order: {}", args.file.display());
+        return Ok(());
+    }
+    let verb = if args.dry_run {
+        "Would renumber"
+    } else {
+        "Renumbered"
+    };
+    println!(
+        "{} {} task(s) in {}:",
+        verb,
+        renamed.len(),
+        args.file.display()
+    );
+    for rename in &renamed {
+        println!("  {} -> {}", rename.old, rename.new);
+    }
+    Ok(())
+}
+
+fn cmd_prd_split(args: PrdSplitArgs, json: bool) -> Result<(), CliError> {
+    let contents = fs::read_to_string(&args.file).map_err(|err| {
+        CliError::Message(format!(
+            "Failed to read PRD {}: {}",
+            args.file.display(),
+            err
+        ))
+    })?;
+    let stem = args
+        .file
+        .file_stem()
+        .map(|stem| stem.to_string_lossy().to_string())
+        .unwrap_or_else(|| "PRD".to_string());
+    let split = prd::prd_split_contents(&contents, &stem);
+    if split.shards.is_empty() {
+        return Err(CliError::Message(format!(
+            "No task blocks found: {}",
+            args.file.display()
+        )));
+    }
+
+    let output_dir = args.output_dir.clone().unwrap_or_else(|| {
+        args.file
+            .parent()
+            .map(Path::to_path_buf)
+            .unwrap_or_else(|| PathBuf::from("."))
+    });
+    fs::create_dir_all(&output_dir).map_err(CliError::Io)?;
+    let mut outputs: Vec<(PathBuf, &str)> = split
+        .shards
+        .iter()
+        .map(|shard| (output_dir.join(&shard.file_name), shard.contents.as_str()))
+        .collect();
+    outputs.push((
+        output_dir.join(&split.index_file_name),
+        split.index.as_str(),
+    ));
+    if !args.force {
+        if let Some((path, _)) = outputs.iter().find(|(path, _)| path.exists()) {
+            return Err(CliError::Message(format!(
+                "Output file exists: {} (use --force to overwrite)",
+                path.display()
+            )));
+        }
+    }
+    for (path, contents) in &outputs {
+        fs::write(path, contents).map_err(CliError::Io)?;
+    }
+
+    if json {
+        let shards = split
+            .shards
+            .iter()
+            .map(|shard| {
+                serde_json::json!({
+                    "prefix": shard.prefix,
+                    "file": output_dir.join(&shard.file_name).to_string_lossy(),
+                    "tasks": shard.tasks,
+                    "remaining": shard.remaining,
+                })
+            })
+            .collect::<Vec<_>>();
+        return print_json(&serde_json::json!({
+            "index": output_dir.join(&split.index_file_name).to_string_lossy(),
+            "shards": shards,
+        }));
+    }
+    for shard in &split.shards {
+        println!(
+            "Wrote {} ({} tasks, {} remaining)",
+            output_dir.join(&shard.file_name).display(),
+            shard.tasks,
+            shard.remaining
+        );
+    }
+    println!(
+        "Wrote index: {}",
+        output_dir.join(&split.index_file_name).display()
+    );
+    Ok(())
+}
+
+fn cmd_prd_create(args: PrdCreateArgs, deps: &Deps) -> Result<(), CliError> {
+    let profile = args.profile.clone().or_else(config::active_profile);
     let target_dir = args
         .dir
         .unwrap_or_else(|| env::current_dir().unwrap_or_else(|_| PathBuf::from(".")));
@@ -107,47 +762,88 @@ fn cmd_prd_create(args: PrdCreateArgs) -> Result<(), CliError> {
             target_dir.display()
         )));
     }
+    let target_dir = super::workspace_dir(&target_dir, args.workspace.as_deref())?;
 
-    let goal = args
-        .goal
-        .clone()
-        .ok_or_else(|| CliError::Message("Goal is required. Use --goal.".to_string()))?;
+    let todos = if args.from_todos {
+        let todos = prd::prd_scan_todos(&target_dir);
+        if todos.is_empty() && args.goal.is_none() {
+            return Err(CliError::Message(format!(
+                "No TODO or FIXME comments found in {}",
+                target_dir.display()
+            )));
+        }
+        println!("Found {} TODO/FIXME comment(s)", todos.len());
+        todos
+    } else {
+        Vec::new()
+    };
+
+    let goal = match args.goal.clone() {
+        Some(goal) => goal,
+        None if args.from_todos => {
+            "Resolve the TODO and FIXME comments found in the codebase.".to_string()
+        }
+        None => {
+            return Err(CliError::Message(
+                "Goal is required. Use --goal.".to_string(),
+            ));
+        }
+    };
 
     let constraints = args
         .constraints
         .clone()
         .unwrap_or_else(|| "None.".to_string());
 
-    let output_path = resolve_prd_output(&target_dir, args.output.clone(), args.force)?;
+    let output_path =
+        resolve_prd_output(&target_dir, args.output.clone(), args.force || args.dry_run)?;
 
-    let config =
-        Config::load(Some(&target_dir)).map_err(|err| CliError::Message(err.to_string()))?;
+    let config = Config::load_with_profile(Some(&target_dir), profile.as_deref())
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .with_offline(deps.offline())
+        .with_cassette(deps.cassette().cloned());
     let backend_name = args
         .backend
         .clone()
         .or_else(|| config.get("defaults.backend"))
         .unwrap_or_else(|| "claude".to_string());
     let mut model = args.model.clone().or_else(|| config.get("defaults.model"));
-    if model.as_deref().unwrap_or("").is_empty() && backend_name == "opencode" {
-        model = config.get("opencode.default_model");
+    if model.as_deref().unwrap_or("").is_empty()
+        && matches!(backend_name.as_str(), "opencode" | "ollama" | "openai")
+    {
+        model = config.get(&format!("{}.default_model", backend_name));
     }
 
-    let backend = backend_from_name(&backend_name).map_err(CliError::Message)?;
-    if !backend.check_installed() {
+    let backend = backend_from_config(&backend_name, &config, &[]).map_err(CliError::Message)?;
+    ensure_network_allowed(backend.as_ref(), config.offline()).map_err(CliError::Message)?;
+    ensure_variant_supported(backend.as_ref(), args.variant.as_deref())
+        .map_err(CliError::Message)?;
+    if !args.dry_run && !backend.check_installed() {
         return Err(CliError::Message(format!(
             "Backend is not installed: {}",
             backend_name
         )));
     }
 
-    let stack = prd::prd_detect_stack(&target_dir);
+    let stack_depth = match args.stack_depth {
+        Some(depth) => depth,
+        None => resolve_stack_depth(&config)?,
+    };
+    let stack = prd::prd_detect_stack_with_depth(&target_dir, stack_depth);
     let stack_summary = prd::prd_format_stack_summary(&stack, 2);
 
-    let context_files = build_context_file_list(
+    let mut context_files = build_context_file_list(
         &target_dir,
         args.context.as_deref(),
         config.get("defaults.context_files").as_deref(),
     );
+    let seeded = &todos[..todos.len().min(prd::TODO_SCAN_LIMIT)];
+    for todo in seeded {
+        if !context_files.contains(&todo.path) {
+            context_files.push(todo.path.clone());
+        }
+    }
+    let todos_section = todos_prompt_section(seeded, todos.len());
     let context_section = if context_files.is_empty() {
         "None.".to_string()
     } else {
@@ -156,7 +852,7 @@ fn cmd_prd_create(args: PrdCreateArgs) -> Result<(), CliError> {
 
     let sources_section = match args.sources.as_deref() {
         Some(value) if !value.trim().is_empty() => normalize_csv(value).join("\n"),
-        _ => "None.".to_string(),
+        _ => discover_prd_sources(&config, &goal).unwrap_or_else(|| "None.".to_string()),
     };
 
     let warnings_section = if sources_section == "None." {
@@ -166,9 +862,12 @@ fn cmd_prd_create(args: PrdCreateArgs) -> Result<(), CliError> {
         "None.".to_string()
     };
 
-    let template_text = read_prd_template(&target_dir)?;
+    let template_text = match args.template.as_deref() {
+        Some(name) => read_named_prd_template(&prd_templates_dir(), name)?,
+        None => read_prd_template(&target_dir)?,
+    };
     let prompt = format!(
-        "You are generating a gralph PRD in markdown. The output must be spec-compliant and grounded in the repository.\n\nProject directory: {dir}\n\nGoal:\n{goal}\n\nConstraints:\n{constraints}\n\nDetected stack summary (from repository files):\n{stack_summary}\n\nSources (authoritative URLs or references):\n{sources}\n\nWarnings (only include in the PRD if Sources is empty):\n{warnings}\n\nContext files (read these first if present):\n{context}\n\nRequirements:\n- Output only the PRD markdown with no commentary or code fences.\n- Use ASCII only.\n- Do not include an \"Open Questions\" section.\n- Do not use any checkboxes outside task blocks.\n- Context Bundle entries must be real files in the repo and must be selected from the Context files list above.\n- If a task creates new files, do not list the new files in Context Bundle; cite the closest existing files instead.\n- Use atomic, granular tasks grounded in the repo and context files.\n- Each task block must use a '### Task <ID>' header and include **ID**, **Context Bundle**, **DoD**, **Checklist**, **Dependencies**.\n- Each task block must contain exactly one unchecked task line like '- [ ] <ID> <summary>'.\n- If Sources is empty, include a 'Warnings' section with the warning text above and no checkboxes.\n- Do not invent stack, frameworks, or files not supported by the context files and stack summary.\n\nTemplate:\n{template}\n",
+        "You are generating a gralph PRD in markdown. The output must be spec-compliant and grounded in the repository.\n\nProject directory: {dir}\n\nGoal:\n{goal}\n\nConstraints:\n{constraints}\n\nDetected stack summary (from repository files):\n{stack_summary}\n\nSources (authoritative URLs or references):\n{sources}\n\nWarnings (only include in the PRD if Sources is empty):\n{warnings}\n\nContext files (read these first if present):\n{context}\n{todos}\nRequirements:\n{rules}\n\nTemplate:\n{template}\n",
         dir = target_dir.display(),
         goal = goal,
         constraints = constraints,
@@ -176,28 +875,87 @@ fn cmd_prd_create(args: PrdCreateArgs) -> Result<(), CliError> {
         sources = sources_section,
         warnings = warnings_section,
         context = context_section,
+        todos = todos_section,
+        rules = PRD_RULES,
         template = template_text
     );
 
-    let tmp_dir = env::temp_dir();
-    let output_file = tmp_dir.join(format!("gralph-prd-{}.tmp", std::process::id()));
-    backend
-        .run_iteration(
-            &prompt,
+    if args.dry_run {
+        println!("Output file: {}", output_path.display());
+        println!();
+        println!("Resolved prompt:");
+        println!("{}", prompt);
+        println!();
+        println!("Backend command:");
+        println!(
+            "{}",
+            backend.describe_invocation(model.as_deref(), args.variant.as_deref())
+        );
+        if args.critique {
+            println!();
+            println!("Critique: the draft is critiqued and revised in two more backend passes");
+        }
+        return Ok(());
+    }
+
+    let passes = if args.critique { 3 } else { 1 };
+    let run_pass = |stage: &str, step: usize, label: &str, prompt: &str| {
+        run_prd_pass(
+            backend.as_ref(),
+            stage,
+            &format!("[{}/{}] {}", step, passes, label),
+            args.quiet,
+            prompt,
             model.as_deref(),
             args.variant.as_deref(),
-            &output_file,
             &target_dir,
         )
-        .map_err(|err| CliError::Message(err.to_string()))?;
-    let result = backend
-        .parse_text(&output_file)
-        .map_err(|err| CliError::Message(err.to_string()))?;
+    };
+    let mut result = run_pass("draft", 1, "Drafting the PRD", &prompt)?;
     if result.trim().is_empty() {
         return Err(CliError::Message(
             "PRD generation returned empty output.".to_string(),
         ));
     }
+    if args.critique {
+        let issues = prd::prd_lint_contents(
+            &result,
+            &output_path,
+            args.allow_missing_context,
+            Some(&target_dir),
+        )
+        .into_iter()
+        .map(|issue| issue.message)
+        .collect::<Vec<_>>();
+        let critique = run_pass(
+            "critique",
+            2,
+            &format!(
+                "Critiquing the draft ({} validation issue(s))",
+                issues.len()
+            ),
+            &critique_prompt(&result, &issues, &context_section, &stack_summary),
+        )?;
+        if critique.trim().is_empty() {
+            eprintln!("Warning: critique returned empty output; keeping the draft");
+        } else if !critique_found_problems(&critique) && issues.is_empty() {
+            println!("Critique found no problems");
+        } else {
+            let revised = run_pass(
+                "revision",
+                3,
+                "Revising the draft",
+                &revision_prompt(&result, &critique, &issues),
+            )?;
+            if revised.trim().is_empty() {
+                eprintln!("Warning: revision returned empty output; keeping the draft");
+            } else {
+                result = revised;
+            }
+        }
+    }
+
+    let tmp_dir = env::temp_dir();
 
     let temp_prd = tmp_dir.join(format!("gralph-prd-{}.md", std::process::id()));
     fs::write(&temp_prd, result).map_err(CliError::Io)?;
@@ -227,6 +985,179 @@ fn cmd_prd_create(args: PrdCreateArgs) -> Result<(), CliError> {
     Ok(())
 }
 
+/// Runs one `prd create` backend pass and returns its text.
+fn run_prd_pass(
+    backend: &dyn Backend,
+    stage: &str,
+    label: &str,
+    quiet: bool,
+    prompt: &str,
+    model: Option<&str>,
+    variant: Option<&str>,
+    target_dir: &Path,
+) -> Result<String, CliError> {
+    let output_file =
+        env::temp_dir().join(format!("gralph-prd-{}-{}.tmp", std::process::id(), stage));
+    let run = || backend.run_iteration(prompt, model, variant, &output_file, target_dir);
+    // The PRD is read from the output file, so the backend's streamed text
+    // goes to stderr (or nowhere) instead of stdout.
+    let outcome = if quiet {
+        crate::backend::without_stdout_echo(run)
+    } else {
+        let ticker = Progress::start(label);
+        let progress = ticker.progress().clone();
+        progress.set_detail("reading the repository");
+        let tasks = Cell::new(0);
+        crate::backend::without_stdout_echo(|| {
+            crate::backend::with_live_output(
+                move |line| {
+                    progress.line(line);
+                    if let Some(detail) = estimated_prd_stage(line, &tasks) {
+                        progress.set_detail(&detail);
+                    }
+                },
+                run,
+            )
+        })
+    };
+    outcome.map_err(|err| CliError::Message(format!("PRD {} failed: {}", stage, err)))?;
+    let text = backend
+        .parse_text(&output_file)
+        .map_err(|err| CliError::Message(err.to_string()));
+    let _ = fs::remove_file(&output_file);
+    text
+}
+
+/// Guesses what the backend is doing from a line of its streamed output:
+/// headings mean it has started writing the PRD, and each task header bumps
+/// the count in `tasks`.
+pub(super) fn estimated_prd_stage(line: &str, tasks: &Cell<usize>) -> Option<String> {
+    let line = line.trim_start();
+    if line.starts_with("### Task") {
+        tasks.set(tasks.get() + 1);
+        return Some(format!("writing tasks ({})", tasks.get()));
+    }
+    if tasks.get() == 0 && (line.starts_with("# ") || line.starts_with("## ")) {
+        return Some("writing the PRD".to_string());
+    }
+    None
+}
+
+/// Asks the backend to review a draft PRD against [`PRD_RULES`], the
+/// validation issues already found, and the repository.
+pub(super) fn critique_prompt(
+    draft: &str,
+    issues: &[String],
+    context: &str,
+    stack_summary: &str,
+) -> String {
+    let issues = if issues.is_empty() {
+        "None.".to_string()
+    } else {
+        issues.join("\n")
+    };
+    format!(
+        "You are reviewing a generated gralph PRD. Do not modify any files.\n\nDetected stack summary (from repository files):\n{stack_summary}\n\nContext files (Context Bundle entries must be selected from these):\n{context}\n\nRules the PRD must follow:\n{rules}\n\nValidation issues already found:\n{issues}\n\nCheck every task against the rules and against the repository: read the files it cites and confirm they exist and support the task, and flag any stack, framework, or file that the repository does not back up. List each problem as a bullet with the task ID and a concrete fix. If there are no problems, reply with exactly: {clean}\n\nPRD:\n{draft}\n",
+        rules = PRD_RULES,
+        clean = CRITIQUE_CLEAN,
+    )
+}
+
+/// Whether a critique lists anything to fix.
+pub(super) fn critique_found_problems(critique: &str) -> bool {
+    !critique
+        .trim()
+        .trim_end_matches('.')
+        .eq_ignore_ascii_case(CRITIQUE_CLEAN.trim_end_matches('.'))
+}
+
+/// Asks the backend to apply a critique to the draft and return the whole
+/// revised PRD.
+pub(super) fn revision_prompt(draft: &str, critique: &str, issues: &[String]) -> String {
+    let issues = if issues.is_empty() {
+        "None.".to_string()
+    } else {
+        issues.join("\n")
+    };
+    format!(
+        "You are revising a generated gralph PRD. Apply every fix from the review and resolve every validation issue below; keep everything else as it is.\n\nRules the PRD must follow:\n{rules}\n\nValidation issues:\n{issues}\n\nReview:\n{critique}\n\nPRD:\n{draft}\n\nOutput only the complete revised PRD markdown with no commentary or code fences.\n",
+        rules = PRD_RULES,
+        critique = critique.trim(),
+    )
+}
+
+/// The prompt section listing TODO and FIXME comments as seed tasks, or an
+/// empty string without any.
+fn todos_prompt_section(seeded: &[prd::TodoComment], found: usize) -> String {
+    if seeded.is_empty() {
+        return String::new();
+    }
+    let mut section = String::from(
+        "\nTODO and FIXME comments in the codebase (seed tasks: turn each into a task or group related ones into one, and list its file in the Context Bundle):\n",
+    );
+    for todo in seeded {
+        section.push_str(&format!("- {}\n", todo));
+    }
+    if found > seeded.len() {
+        section.push_str(&format!("- ... {} more not listed\n", found - seeded.len()));
+    }
+    section
+}
+
+/// Sources from the `sources.provider` search, one `title - url` per line.
+/// Failures are reported and treated as no sources.
+fn discover_prd_sources(config: &Config, goal: &str) -> Option<String> {
+    let settings = match sources::SourcesSettings::from_config(config) {
+        Ok(settings) => settings?,
+        Err(err) => {
+            eprintln!("Warning: {}", err);
+            return None;
+        }
+    };
+    if config.offline() && settings.requires_network() {
+        println!(
+            "Offline: skipping source search via {}; pass --sources or use the static provider.",
+            settings.provider
+        );
+        return None;
+    }
+    match sources::discover_sources(&settings, goal) {
+        Ok(found) if !found.is_empty() => {
+            println!("Found {} sources via {}", found.len(), settings.provider);
+            Some(
+                found
+                    .iter()
+                    .map(|source| format!("{} - {}", source.title, source.url))
+                    .collect::<Vec<_>>()
+                    .join("\n"),
+            )
+        }
+        Ok(_) => None,
+        Err(err) => {
+            eprintln!("Warning: {}", err);
+            None
+        }
+    }
+}
+
+/// `defaults.stack_depth`: how many directory levels below the project root
+/// `prd create` scans for workspace packages. Zero scans the root only.
+fn resolve_stack_depth(config: &Config) -> Result<usize, CliError> {
+    let Some(value) = config.get("defaults.stack_depth") else {
+        return Ok(DEFAULT_STACK_DEPTH);
+    };
+    let value = value.trim();
+    if value.is_empty() {
+        return Ok(DEFAULT_STACK_DEPTH);
+    }
+    value.parse().map_err(|_| {
+        CliError::Message(format!(
+            "defaults.stack_depth must be a non-negative integer: {}",
+            value
+        ))
+    })
+}
+
 pub(super) fn resolve_prd_output(
     dir: &Path,
     output: Option<PathBuf>,
@@ -277,6 +1208,103 @@ pub(super) fn read_prd_template_with_manifest(
     Ok(DEFAULT_PRD_TEMPLATE.to_string())
 }
 
+fn cmd_prd_templates(args: PrdTemplatesArgs) -> Result<(), CliError> {
+    let registry = prd_templates_dir();
+    match args.command {
+        PrdTemplatesCommand::List => {
+            let templates = list_prd_templates(&registry)?;
+            if templates.is_empty() {
+                println!("No PRD templates in {}", registry.display());
+                return Ok(());
+            }
+            for (name, path) in templates {
+                println!("{:<20} {}", name, path.display());
+            }
+            Ok(())
+        }
+        PrdTemplatesCommand::Add(args) => {
+            let path = add_prd_template(&registry, &args)?;
+            println!("Added template {} ({})", args.name, path.display());
+            Ok(())
+        }
+    }
+}
+
+fn prd_templates_dir() -> PathBuf {
+    config::config_dir().join("templates")
+}
+
+pub(super) fn read_named_prd_template(registry: &Path, name: &str) -> Result<String, CliError> {
+    validate_template_name(name)?;
+    let path = registry.join(format!("{}.md", name));
+    if !path.is_file() {
+        return Err(CliError::Message(format!(
+            "Unknown PRD template: {} (see gralph prd templates list)",
+            name
+        )));
+    }
+    fs::read_to_string(&path).map_err(CliError::Io)
+}
+
+pub(super) fn list_prd_templates(registry: &Path) -> Result<Vec<(String, PathBuf)>, CliError> {
+    let entries = match fs::read_dir(registry) {
+        Ok(entries) => entries,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(err) => return Err(CliError::Io(err)),
+    };
+    let mut templates = Vec::new();
+    for entry in entries {
+        let path = entry.map_err(CliError::Io)?.path();
+        if !path.is_file() || path.extension().and_then(|ext| ext.to_str()) != Some("md") {
+            continue;
+        }
+        if let Some(name) = path.file_stem().and_then(|stem| stem.to_str()) {
+            templates.push((name.to_string(), path.clone()));
+        }
+    }
+    templates.sort();
+    Ok(templates)
+}
+
+pub(super) fn add_prd_template(
+    registry: &Path,
+    args: &PrdTemplatesAddArgs,
+) -> Result<PathBuf, CliError> {
+    validate_template_name(&args.name)?;
+    let contents = fs::read_to_string(&args.file).map_err(|err| {
+        CliError::Message(format!(
+            "Failed to read template {}: {}",
+            args.file.display(),
+            err
+        ))
+    })?;
+    let path = registry.join(format!("{}.md", args.name));
+    if path.exists() && !args.force {
+        return Err(CliError::Message(format!(
+            "Template already exists: {} (use --force to overwrite)",
+            args.name
+        )));
+    }
+    fs::create_dir_all(registry).map_err(CliError::Io)?;
+    write_atomic(&path, &contents, args.force).map_err(CliError::Io)?;
+    Ok(path)
+}
+
+fn validate_template_name(name: &str) -> Result<(), CliError> {
+    let valid = !name.is_empty()
+        && name
+            .chars()
+            .all(|ch| ch.is_ascii_alphanumeric() || ch == '-' || ch == '_');
+    if valid {
+        Ok(())
+    } else {
+        Err(CliError::Message(format!(
+            "Invalid template name: {} (use letters, digits, '-' and '_')",
+            name
+        )))
+    }
+}
+
 pub(super) fn resolve_init_context_files(
     target_dir: &Path,
     config_list: Option<&str>,
@@ -523,6 +1551,15 @@ pub(super) fn write_allowed_context(entries: &[String]) -> Result<Option<PathBuf
     Ok(Some(path))
 }
 
+const DEFAULT_STACK_DEPTH: usize = 2;
+
+/// Rules a generated PRD must follow, shared by the generation, critique,
+/// and revision prompts.
+const PRD_RULES: &str = "- Output only the PRD markdown with no commentary or code fences.\n- Use ASCII only.\n- Do not include an \"Open Questions\" section.\n- Do not use any checkboxes outside task blocks.\n- Context Bundle entries must be real files in the repo and must be selected from the Context files list above.\n- If a task creates new files, do not list the new files in Context Bundle; cite the closest existing files instead.\n- Use atomic, granular tasks grounded in the repo and context files.\n- Each task block must use a '### Task <ID>' header and include **ID**, **Context Bundle**, **DoD**, **Checklist**, **Dependencies**.\n- Each task block must contain exactly one unchecked task line like '- [ ] <ID> <summary>'.\n- If Sources is empty, include a 'Warnings' section with the warning text above and no checkboxes.\n- Do not invent stack, frameworks, or files not supported by the context files and stack summary.";
+
+/// What a critique that finds nothing to fix replies with.
+const CRITIQUE_CLEAN: &str = "No problems found.";
+
 pub(super) const DEFAULT_PRD_TEMPLATE: &str = "## Overview\n\nBriefly describe the project, goals, and intended users.\n\n## Problem Statement\n\n- What problem does this solve?\n- What pain points exist today?\n\n## Solution\n\nHigh-level solution summary.\n\n---\n\n## Functional Requirements\n\n### FR-1: Core Feature\n\nDescribe the primary user-facing behavior.\n\n### FR-2: Secondary Feature\n\nDescribe supporting behavior.\n\n---\n\n## Non-Functional Requirements\n\n### NFR-1: Performance\n\n- Example: Response times under 200ms for key operations.\n\n### NFR-2: Reliability\n\n- Example: Crash recovery or retries where appropriate.\n\n---\n\n## Implementation Tasks\n\nEach task must use a `### Task <ID>` block header and include the required fields.\nEach task block must contain exactly one unchecked task line.\n\n### Task EX-1\n\n- **ID** EX-1\n- **Context Bundle** `path/to/file`, `path/to/other`\n- **DoD** Define the done criteria for this task.\n- **Checklist**\n  * First verification item.\n  * Second verification item.\n- **Dependencies** None\n- [ ] EX-1 Short task summary\n\n---\n\n## Success Criteria\n\n- Define measurable outcomes that indicate completion.\n\n---\n\n## Sources\n\n- List authoritative URLs used as source of truth.\n\n---\n\n## Warnings\n\n- Only include this section if no reliable sources were found.\n- State what is missing and what must be verified.\n";
 
 pub(super) const ARCHITECTURE_TEMPLATE: &str = "# Architecture\n\n## Overview\n\nDescribe the system at a high level.\n\n## Modules\n\nList key modules and what they own.\n\n## Runtime Flow\n\nDescribe the primary runtime path.\n\n## Storage\n\nRecord where state or data is stored.\n";
@@ -0,0 +1,174 @@
+use std::io::{self, IsTerminal, Write};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{Arc, Mutex};
+use std::thread::{self, JoinHandle};
+use std::time::{Duration, Instant};
+
+const SPINNER_FRAMES: [char; 4] = ['|', '/', '-', '\\'];
+const SPINNER_INTERVAL: Duration = Duration::from_millis(120);
+
+/// A progress line on stderr for a long backend call: a spinner, the stage,
+/// an optional detail, and the elapsed time. Text passed to [`Progress::line`]
+/// is printed above it. When stderr is not a terminal there is no spinner;
+/// stage changes and text are printed as plain lines.
+#[derive(Clone)]
+pub(super) struct Progress {
+    state: Arc<Mutex<ProgressState>>,
+}
+
+struct ProgressState {
+    stage: String,
+    detail: Option<String>,
+    started: Instant,
+    frame: usize,
+    drawn: bool,
+    terminal: bool,
+}
+
+/// Keeps the spinner turning until dropped.
+pub(super) struct ProgressTicker {
+    progress: Progress,
+    stop: Arc<AtomicBool>,
+    handle: Option<JoinHandle<()>>,
+}
+
+impl Progress {
+    /// Starts showing `stage`; the spinner runs until the ticker is dropped.
+    pub(super) fn start(stage: &str) -> ProgressTicker {
+        let terminal = io::stderr().is_terminal();
+        let progress = Progress {
+            state: Arc::new(Mutex::new(ProgressState {
+                stage: stage.to_string(),
+                detail: None,
+                started: Instant::now(),
+                frame: 0,
+                drawn: false,
+                terminal,
+            })),
+        };
+        if !terminal {
+            eprintln!("{}...", stage);
+        }
+        let stop = Arc::new(AtomicBool::new(false));
+        let handle = terminal.then(|| {
+            let progress = progress.clone();
+            let stop = Arc::clone(&stop);
+            thread::spawn(move || {
+                while !stop.load(Ordering::Relaxed) {
+                    progress.with_state(|state, stderr| {
+                        state.frame = (state.frame + 1) % SPINNER_FRAMES.len();
+                        state.draw(stderr);
+                    });
+                    thread::sleep(SPINNER_INTERVAL);
+                }
+            })
+        });
+        ProgressTicker {
+            progress,
+            stop,
+            handle,
+        }
+    }
+
+    /// Changes the detail shown after the stage, such as what the backend
+    /// seems to be doing.
+    pub(super) fn set_detail(&self, detail: &str) {
+        self.with_state(|state, stderr| {
+            if state.detail.as_deref() == Some(detail) {
+                return;
+            }
+            state.detail = Some(detail.to_string());
+            if state.terminal {
+                state.draw(stderr);
+            }
+        });
+    }
+
+    /// Prints a line of backend output above the progress line.
+    pub(super) fn line(&self, text: &str) {
+        self.with_state(|state, stderr| {
+            state.clear(stderr);
+            let _ = writeln!(stderr, "{}", text);
+            if state.terminal {
+                state.draw(stderr);
+            }
+        });
+    }
+
+    fn with_state(&self, f: impl FnOnce(&mut ProgressState, &mut io::StderrLock<'_>)) {
+        let mut state = match self.state.lock() {
+            Ok(state) => state,
+            Err(poisoned) => poisoned.into_inner(),
+        };
+        let mut stderr = io::stderr().lock();
+        f(&mut state, &mut stderr);
+        let _ = stderr.flush();
+    }
+}
+
+impl ProgressTicker {
+    pub(super) fn progress(&self) -> &Progress {
+        &self.progress
+    }
+}
+
+impl Drop for ProgressTicker {
+    fn drop(&mut self) {
+        self.stop.store(true, Ordering::Relaxed);
+        if let Some(handle) = self.handle.take() {
+            let _ = handle.join();
+        }
+        self.progress.with_state(|state, stderr| {
+            state.clear(stderr);
+            let _ = writeln!(
+                stderr,
+                "{} ({})",
+                state.stage,
+                format_elapsed(state.started.elapsed())
+            );
+        });
+    }
+}
+
+impl ProgressState {
+    fn draw(&mut self, stderr: &mut impl Write) {
+        let detail = self
+            .detail
+            .as_deref()
+            .map(|detail| format!(": {}", detail))
+            .unwrap_or_default();
+        let _ = write!(
+            stderr,
+            "\r\x1b[2K{} {}{} {}",
+            SPINNER_FRAMES[self.frame],
+            self.stage,
+            detail,
+            format_elapsed(self.started.elapsed())
+        );
+        self.drawn = true;
+    }
+
+    fn clear(&mut self, stderr: &mut impl Write) {
+        if self.drawn {
+            let _ = write!(stderr, "\r\x1b[2K");
+            self.drawn = false;
+        }
+    }
+}
+
+/// Elapsed time as `m:ss`.
+fn format_elapsed(elapsed: Duration) -> String {
+    let secs = elapsed.as_secs();
+    format!("{}:{:02}", secs / 60, secs % 60)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn format_elapsed_shows_minutes_and_seconds() {
+        assert_eq!(format_elapsed(Duration::from_secs(5)), "0:05");
+        assert_eq!(format_elapsed(Duration::from_secs(125)), "2:05");
+    }
+}
@@ -0,0 +1,467 @@
+use super::loop_session::{format_rfc3339, print_table};
+use super::{CliError, Deps, ProcessRunner, print_json, session_name};
+use crate::cli::{
+    Cli, Command, QueueAddArgs, QueueArgs, QueueCommand, QueueRemoveArgs, QueueRunArgs,
+};
+use crate::shutdown;
+use crate::state::{StateError, StateStore};
+use clap::Parser;
+use serde_json::{Map, Value};
+use std::fs::{self, OpenOptions};
+use std::path::{Path, PathBuf};
+use std::process::{Child, Command as ProcCommand, Stdio};
+use std::time::Duration;
+
+/// How often `queue run` checks on the loops it started.
+const POLL_INTERVAL: Duration = Duration::from_secs(2);
+
+/// A queue entry whose `gralph start` is running under this runner.
+struct RunningEntry {
+    id: u64,
+    name: String,
+    dir: PathBuf,
+    child: Child,
+}
+
+pub(super) fn cmd_queue(args: QueueArgs, json: bool, deps: &Deps) -> Result<(), CliError> {
+    match args.command {
+        QueueCommand::Add(args) => cmd_queue_add(args, deps),
+        QueueCommand::List => cmd_queue_list(json, deps),
+        QueueCommand::Remove(args) => cmd_queue_remove(args, deps),
+        QueueCommand::Run(args) => cmd_queue_run(args, deps),
+    }
+}
+
+fn cmd_queue_add(args: QueueAddArgs, deps: &Deps) -> Result<(), CliError> {
+    let dir = args.dir.canonicalize().map_err(|err| {
+        CliError::Message(format!(
+            "Directory does not exist: {} ({})",
+            args.dir.display(),
+            err
+        ))
+    })?;
+    let name = session_name(&args.name, &dir)?;
+    validate_start_args(&start_args(&dir, &name, &args.args))?;
+
+    let store = deps.state_store();
+    let queue = store.list_queue().map_err(state_error)?;
+    if queue.iter().any(|entry| {
+        field(entry, "name") == name && matches!(field(entry, "status"), "queued" | "running")
+    }) {
+        return Err(CliError::Message(format!(
+            "Session {} is already in the queue",
+            name
+        )));
+    }
+
+    let mut fields = Map::new();
+    fields.insert("name".to_string(), Value::String(name.clone()));
+    fields.insert(
+        "dir".to_string(),
+        Value::String(dir.to_string_lossy().into_owned()),
+    );
+    fields.insert("args".to_string(), Value::from(args.args));
+    fields.insert(
+        "added_at".to_string(),
+        Value::String(format_rfc3339(deps.clock())),
+    );
+    let id = store.enqueue(fields).map_err(state_error)?;
+    println!("Queued {} as #{} ({})", name, id, dir.display());
+    Ok(())
+}
+
+fn cmd_queue_list(json: bool, deps: &Deps) -> Result<(), CliError> {
+    let queue = deps.state_store().list_queue().map_err(state_error)?;
+    if json {
+        return print_json(&serde_json::json!({ "queue": queue }));
+    }
+    if queue.is_empty() {
+        println!("Queue is empty.");
+        return Ok(());
+    }
+    let rows = queue
+        .iter()
+        .map(|entry| {
+            vec![
+                entry
+                    .get("id")
+                    .and_then(Value::as_u64)
+                    .unwrap_or(0)
+                    .to_string(),
+                field(entry, "name").to_string(),
+                field(entry, "status").to_string(),
+                field(entry, "result").to_string(),
+                field(entry, "dir").to_string(),
+            ]
+        })
+        .collect::<Vec<_>>();
+    print_table(&["ID", "NAME", "STATUS", "RESULT", "DIR"], &rows);
+    Ok(())
+}
+
+fn cmd_queue_remove(args: QueueRemoveArgs, deps: &Deps) -> Result<(), CliError> {
+    let store = deps.state_store();
+    let queue = store.list_queue().map_err(state_error)?;
+    let Some(entry) = queue
+        .iter()
+        .find(|entry| entry.get("id").and_then(Value::as_u64) == Some(args.id))
+    else {
+        return Err(CliError::Message(format!(
+            "Queue entry #{} not found",
+            args.id
+        )));
+    };
+    if field(entry, "status") == "running" {
+        return Err(CliError::Message(format!(
+            "Queue entry #{} is running; stop session {} first",
+            args.id,
+            field(entry, "name")
+        )));
+    }
+    store.remove_queue_entry(args.id).map_err(state_error)?;
+    println!("Removed #{} ({})", args.id, field(entry, "name"));
+    Ok(())
+}
+
+/// Starts queued entries as `gralph start --no-tmux` children, at most
+/// `--concurrency` at a time, until nothing is queued or running. A stop
+/// signal is passed on to the children and their entries go back to
+/// `queued`.
+fn cmd_queue_run(args: QueueRunArgs, deps: &Deps) -> Result<(), CliError> {
+    let store = deps.state_store();
+    let process = deps.process();
+    let requeued = requeue_orphans(&store, process)?;
+    if requeued > 0 {
+        println!(
+            "Requeued {} entries left running by a stopped runner",
+            requeued
+        );
+    }
+    let exe = process.current_exe().map_err(CliError::Io)?;
+    let log_dir = store.state_dir().join("queue");
+    fs::create_dir_all(&log_dir).map_err(CliError::Io)?;
+    shutdown::install();
+
+    let runner_pid = process.pid().to_string();
+    let mut running: Vec<RunningEntry> = Vec::new();
+    let mut forwarded_stop = false;
+    let (mut done, mut failed) = (0, 0);
+    loop {
+        let mut index = 0;
+        while index < running.len() {
+            let exited = match running[index].child.try_wait() {
+                Ok(Some(status)) => Some(status.success()),
+                Ok(None) => None,
+                Err(_) => Some(false),
+            };
+            let Some(success) = exited else {
+                index += 1;
+                continue;
+            };
+            let entry = running.swap_remove(index);
+            let status = if shutdown::requested().is_some() {
+                "queued"
+            } else if success {
+                done += 1;
+                "done"
+            } else {
+                failed += 1;
+                "failed"
+            };
+            let result = session_status(&store, &entry.name, &entry.dir);
+            store
+                .update_queue_entry(
+                    entry.id,
+                    &[
+                        ("status", status),
+                        ("result", &result),
+                        ("finished_at", &format_rfc3339(deps.clock())),
+                    ],
+                )
+                .map_err(state_error)?;
+            println!("#{} {}: {} ({})", entry.id, entry.name, status, result);
+        }
+
+        if shutdown::requested().is_none() {
+            while running.len() < args.concurrency as usize {
+                let Some(entry) = store
+                    .claim_queue_entry(&[
+                        ("runner_pid", &runner_pid),
+                        ("started_at", &format_rfc3339(deps.clock())),
+                    ])
+                    .map_err(state_error)?
+                else {
+                    break;
+                };
+                match start_entry(&exe, &entry, &log_dir, process) {
+                    Ok(started) => {
+                        store
+                            .update_queue_entry(
+                                started.id,
+                                &[("pid", &started.child.id().to_string())],
+                            )
+                            .map_err(state_error)?;
+                        running.push(started);
+                    }
+                    Err(err) => {
+                        let id = entry.get("id").and_then(Value::as_u64).unwrap_or(0);
+                        failed += 1;
+                        store
+                            .update_queue_entry(id, &[("status", "failed"), ("result", &err)])
+                            .map_err(state_error)?;
+                        eprintln!("#{} {}: {}", id, field(&entry, "name"), err);
+                    }
+                }
+            }
+        } else if !forwarded_stop {
+            for entry in &running {
+                process.kill_pid(entry.child.id() as i64);
+            }
+            forwarded_stop = true;
+        }
+
+        if running.is_empty() {
+            break;
+        }
+        deps.clock().sleep(POLL_INTERVAL);
+    }
+
+    if done + failed == 0 && shutdown::requested().is_none() {
+        println!("No queued entries.");
+    } else {
+        println!("Queue finished: {} done, {} failed", done, failed);
+    }
+    Ok(())
+}
+
+/// Puts `running` entries whose runner is gone back in the queue.
+fn requeue_orphans(store: &StateStore, process: &dyn ProcessRunner) -> Result<usize, CliError> {
+    let queue = store.list_queue().map_err(state_error)?;
+    let mut requeued = 0;
+    for entry in &queue {
+        if field(entry, "status") != "running" {
+            continue;
+        }
+        let runner = entry.get("runner_pid").and_then(Value::as_i64).unwrap_or(0);
+        if process.is_alive(runner) {
+            continue;
+        }
+        if let Some(id) = entry.get("id").and_then(Value::as_u64) {
+            store
+                .update_queue_entry(id, &[("status", "queued")])
+                .map_err(state_error)?;
+            requeued += 1;
+        }
+    }
+    Ok(requeued)
+}
+
+fn start_entry(
+    exe: &Path,
+    entry: &Value,
+    log_dir: &Path,
+    process: &dyn ProcessRunner,
+) -> Result<RunningEntry, String> {
+    let id = entry.get("id").and_then(Value::as_u64).unwrap_or(0);
+    let name = field(entry, "name").to_string();
+    let dir = PathBuf::from(field(entry, "dir"));
+    let extra = entry
+        .get("args")
+        .and_then(Value::as_array)
+        .map(|args| {
+            args.iter()
+                .filter_map(Value::as_str)
+                .map(str::to_string)
+                .collect::<Vec<_>>()
+        })
+        .unwrap_or_default();
+    let log_file = log_dir.join(format!("{}.log", id));
+    let log = OpenOptions::new()
+        .create(true)
+        .append(true)
+        .open(&log_file)
+        .map_err(|err| format!("failed to open {}: {}", log_file.display(), err))?;
+    let log_err = log
+        .try_clone()
+        .map_err(|err| format!("failed to open {}: {}", log_file.display(), err))?;
+
+    let mut cmd = ProcCommand::new(exe);
+    cmd.args(start_args(&dir, &name, &extra))
+        .current_dir(&dir)
+        .stdin(Stdio::null())
+        .stdout(log)
+        .stderr(log_err);
+    let child = process
+        .spawn(&mut cmd)
+        .map_err(|err| format!("failed to start gralph: {}", err))?;
+    println!(
+        "#{} {}: started (pid {}, output in {})",
+        id,
+        name,
+        child.id(),
+        log_file.display()
+    );
+    Ok(RunningEntry {
+        id,
+        name,
+        dir,
+        child,
+    })
+}
+
+/// Arguments for the `gralph start` that runs a queue entry in the
+/// foreground, where the runner can wait on it.
+fn start_args(dir: &Path, name: &str, extra: &[String]) -> Vec<String> {
+    let mut args = vec![
+        "start".to_string(),
+        dir.to_string_lossy().into_owned(),
+        "--name".to_string(),
+        name.to_string(),
+        "--no-tmux".to_string(),
+    ];
+    args.extend(extra.iter().cloned());
+    args
+}
+
+/// Parses the entry's `gralph start` command line now, so a typo fails at
+/// `queue add` rather than overnight.
+fn validate_start_args(args: &[String]) -> Result<(), CliError> {
+    let cli = Cli::try_parse_from(std::iter::once("gralph").chain(args.iter().map(String::as_str)))
+        .map_err(|err| CliError::Message(err.to_string().trim_end().to_string()))?;
+    match cli.command {
+        Some(Command::Start(start)) if start.daemon || start.dry_run => Err(CliError::Message(
+            "--daemon and --dry-run cannot be queued".to_string(),
+        )),
+        Some(Command::Start(_)) => Ok(()),
+        _ => Err(CliError::Message(
+            "queue entries must be `gralph start` arguments".to_string(),
+        )),
+    }
+}
+
+/// Status of the entry's session, looked up in the entry's project first;
+/// `--workspace` or an auto worktree can move the session to another
+/// directory.
+fn session_status(store: &StateStore, name: &str, dir: &Path) -> String {
+    store
+        .clone()
+        .in_project(dir)
+        .get_session(name)
+        .ok()
+        .flatten()
+        .or_else(|| store.get_session(name).ok().flatten())
+        .and_then(|session| {
+            session
+                .get("status")
+                .and_then(Value::as_str)
+                .map(str::to_string)
+        })
+        .unwrap_or_else(|| "unknown".to_string())
+}
+
+fn field<'a>(entry: &'a Value, key: &str) -> &'a str {
+    entry.get(key).and_then(Value::as_str).unwrap_or("")
+}
+
+fn state_error(err: StateError) -> CliError {
+    CliError::Message(err.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io;
+
+    struct DeadRunner;
+
+    impl ProcessRunner for DeadRunner {
+        fn current_exe(&self) -> io::Result<PathBuf> {
+            Ok(PathBuf::from("/bin/true"))
+        }
+
+        fn spawn(&self, _cmd: &mut ProcCommand) -> io::Result<Child> {
+            Err(io::Error::other("not used"))
+        }
+
+        fn kill_tmux_session(&self, _session: &str) {}
+
+        fn kill_pid(&self, _pid: i64) {}
+
+        fn pid(&self) -> u32 {
+            0
+        }
+
+        fn is_alive(&self, pid: i64) -> bool {
+            pid == 7
+        }
+    }
+
+    fn entry(name: &str) -> Map<String, Value> {
+        let mut fields = Map::new();
+        fields.insert("name".to_string(), Value::String(name.to_string()));
+        fields
+    }
+
+    #[test]
+    fn start_args_run_the_entry_in_the_foreground() {
+        let args = start_args(
+            Path::new("/srv/api"),
+            "api",
+            &["--backend".to_string(), "codex".to_string()],
+        );
+        assert_eq!(
+            args,
+            vec![
+                "start",
+                "/srv/api",
+                "--name",
+                "api",
+                "--no-tmux",
+                "--backend",
+                "codex"
+            ]
+        );
+        assert!(validate_start_args(&args).is_ok());
+    }
+
+    #[test]
+    fn validate_start_args_rejects_bad_flags_and_daemon() {
+        let dir = Path::new("/srv/api");
+        let args = start_args(
+            dir,
+            "api",
+            &["--max-iterations".to_string(), "lots".to_string()],
+        );
+        assert!(validate_start_args(&args).is_err());
+
+        let args = start_args(dir, "api", &["--daemon".to_string()]);
+        let err = validate_start_args(&args).unwrap_err();
+        assert!(err.to_string().contains("cannot be queued"));
+    }
+
+    #[test]
+    fn requeue_orphans_only_touches_entries_of_dead_runners() {
+        let temp = tempfile::tempdir().unwrap();
+        let state_dir = temp.path().join("state");
+        let store = StateStore::with_paths(
+            state_dir.clone(),
+            state_dir.join("state.json"),
+            state_dir.join("state.lock"),
+            Duration::from_secs(1),
+        );
+        store.enqueue(entry("alive")).unwrap();
+        store.enqueue(entry("orphan")).unwrap();
+        store.enqueue(entry("waiting")).unwrap();
+        store.claim_queue_entry(&[("runner_pid", "7")]).unwrap();
+        store.claim_queue_entry(&[("runner_pid", "8")]).unwrap();
+
+        assert_eq!(requeue_orphans(&store, &DeadRunner).unwrap(), 1);
+        let statuses = store
+            .list_queue()
+            .unwrap()
+            .iter()
+            .map(|entry| field(entry, "status").to_string())
+            .collect::<Vec<_>>();
+        assert_eq!(statuses, vec!["running", "queued", "queued"]);
+    }
+}
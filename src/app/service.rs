@@ -0,0 +1,370 @@
+use super::CliError;
+use crate::cli::{ServiceArgs, ServiceCommand, ServiceInstallArgs};
+use std::env;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Manager {
+    Systemd,
+    Launchd,
+}
+
+impl Manager {
+    fn resolve(value: Option<&str>) -> Self {
+        match value {
+            Some("launchd") => Manager::Launchd,
+            Some(_) => Manager::Systemd,
+            None if cfg!(target_os = "macos") => Manager::Launchd,
+            None => Manager::Systemd,
+        }
+    }
+}
+
+/// Everything a service file needs, independent of the manager.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct ServiceSpec {
+    /// `gralph-<name>` for systemd units, `dev.gralph.<name>` for launchd.
+    id: String,
+    description: String,
+    program: Vec<String>,
+    working_dir: Option<PathBuf>,
+    path_env: Option<String>,
+    /// Restart after a crash. Loops exit on their own and are not restarted.
+    restart: bool,
+    log_file: Option<PathBuf>,
+}
+
+pub(super) fn cmd_service(args: ServiceArgs) -> Result<(), CliError> {
+    match args.command {
+        ServiceCommand::Install(args) => cmd_service_install(args),
+    }
+}
+
+fn cmd_service_install(args: ServiceInstallArgs) -> Result<(), CliError> {
+    let manager = Manager::resolve(args.manager.as_deref());
+    let exe = env::current_exe().map_err(CliError::Io)?;
+    let spec = service_spec(&args, &exe, env::var("PATH").ok())?;
+    let contents = match manager {
+        Manager::Systemd => render_systemd(&spec),
+        Manager::Launchd => render_launchd(&spec),
+    };
+
+    if args.print {
+        print!("{}", contents);
+        return Ok(());
+    }
+
+    let path = match args.output {
+        Some(path) => path,
+        None => default_service_path(manager, &spec.id)?,
+    };
+    if let Some(parent) = path.parent() {
+        fs::create_dir_all(parent).map_err(CliError::Io)?;
+    }
+    fs::write(&path, contents).map_err(CliError::Io)?;
+    println!("Wrote {}", path.display());
+    match manager {
+        Manager::Systemd => {
+            println!("Enable it with:");
+            println!("  systemctl --user daemon-reload");
+            println!(
+                "  systemctl --user enable --now {}",
+                path.file_name()
+                    .map(|name| name.to_string_lossy().into_owned())
+                    .unwrap_or_default()
+            );
+        }
+        Manager::Launchd => {
+            println!("Load it with:");
+            println!("  launchctl load -w {}", path.display());
+        }
+    }
+    Ok(())
+}
+
+fn service_spec(
+    args: &ServiceInstallArgs,
+    exe: &Path,
+    path_env: Option<String>,
+) -> Result<ServiceSpec, CliError> {
+    let mut program = vec![exe.to_string_lossy().into_owned()];
+    let spec = if args.target == "server" || args.target == "daemon" {
+        let id = args.target.clone();
+        program.push(id.clone());
+        program.extend(args.args.iter().cloned());
+        ServiceSpec {
+            description: if id == "server" {
+                "gralph status server".to_string()
+            } else {
+                "gralph loop daemon".to_string()
+            },
+            program,
+            working_dir: None,
+            path_env,
+            restart: true,
+            log_file: dirs::home_dir().map(|home| {
+                home.join("Library")
+                    .join("Logs")
+                    .join(format!("gralph-{}.log", id))
+            }),
+            id,
+        }
+    } else {
+        let dir = args.dir.canonicalize().map_err(|err| {
+            CliError::Message(format!(
+                "Directory does not exist: {} ({})",
+                args.dir.display(),
+                err
+            ))
+        })?;
+        let name = super::session_name(&args.name, &dir)?;
+        // The service manager supervises the process, so run in the foreground.
+        program.extend([
+            "start".to_string(),
+            dir.to_string_lossy().into_owned(),
+            "--name".to_string(),
+            name.clone(),
+            "--no-tmux".to_string(),
+        ]);
+        program.extend(args.args.iter().cloned());
+        ServiceSpec {
+            id: name.clone(),
+            description: format!("gralph loop ({})", name),
+            program,
+            log_file: Some(dir.join(".gralph").join(format!("{}.service.log", name))),
+            working_dir: Some(dir),
+            path_env,
+            restart: false,
+        }
+    };
+    Ok(spec)
+}
+
+fn default_service_path(manager: Manager, id: &str) -> Result<PathBuf, CliError> {
+    match manager {
+        Manager::Systemd => dirs::config_dir()
+            .map(|dir| {
+                dir.join("systemd")
+                    .join("user")
+                    .join(format!("gralph-{}.service", id))
+            })
+            .ok_or_else(|| CliError::Message("Could not resolve config directory".to_string())),
+        Manager::Launchd => dirs::home_dir()
+            .map(|home| {
+                home.join("Library")
+                    .join("LaunchAgents")
+                    .join(format!("dev.gralph.{}.plist", id))
+            })
+            .ok_or_else(|| CliError::Message("Could not resolve home directory".to_string())),
+    }
+}
+
+fn render_systemd(spec: &ServiceSpec) -> String {
+    let mut out = String::new();
+    out.push_str("[Unit]\n");
+    out.push_str(&format!("Description={}\n", spec.description));
+    out.push_str("After=network-online.target\n\n");
+    out.push_str("[Service]\n");
+    out.push_str("Type=simple\n");
+    if let Some(dir) = &spec.working_dir {
+        out.push_str(&format!(
+            "WorkingDirectory={}\n",
+            systemd_quote(&dir.to_string_lossy())
+        ));
+    }
+    if let Some(path) = &spec.path_env {
+        out.push_str(&format!(
+            "Environment={}\n",
+            systemd_quote(&format!("PATH={}", path))
+        ));
+    }
+    let command = spec
+        .program
+        .iter()
+        .map(|arg| systemd_quote(arg))
+        .collect::<Vec<_>>()
+        .join(" ");
+    out.push_str(&format!("ExecStart={}\n", command));
+    if spec.restart {
+        out.push_str("Restart=on-failure\n");
+        out.push_str("RestartSec=5\n");
+    }
+    out.push_str("\n[Install]\n");
+    out.push_str("WantedBy=default.target\n");
+    out
+}
+
+/// Quote an argument for a unit file. `%` and `$` are escaped so systemd
+/// passes them through literally.
+fn systemd_quote(value: &str) -> String {
+    let escaped = value.replace('%', "%%").replace('$', "$$");
+    let plain = !escaped.is_empty()
+        && escaped
+            .chars()
+            .all(|ch| ch.is_ascii_alphanumeric() || "-_./:=,+@%$".contains(ch));
+    if plain {
+        return escaped;
+    }
+    format!("\"{}\"", escaped.replace('\\', "\\\\").replace('"', "\\\""))
+}
+
+fn render_launchd(spec: &ServiceSpec) -> String {
+    let mut out = String::new();
+    out.push_str("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n");
+    out.push_str("<!DOCTYPE plist PUBLIC \"-//Apple//DTD PLIST 1.0//EN\" \"http://www.apple.com/DTDs/PropertyList-1.0.dtd\">\n");
+    out.push_str("<plist version=\"1.0\">\n<dict>\n");
+    out.push_str(&plist_entry(
+        "Label",
+        &format!("<string>dev.gralph.{}</string>", xml_escape(&spec.id)),
+    ));
+    let mut program = String::from("<array>\n");
+    for arg in &spec.program {
+        program.push_str(&format!("    <string>{}</string>\n", xml_escape(arg)));
+    }
+    program.push_str("  </array>");
+    out.push_str(&plist_entry("ProgramArguments", &program));
+    if let Some(dir) = &spec.working_dir {
+        out.push_str(&plist_entry(
+            "WorkingDirectory",
+            &format!("<string>{}</string>", xml_escape(&dir.to_string_lossy())),
+        ));
+    }
+    if let Some(path) = &spec.path_env {
+        out.push_str(&plist_entry(
+            "EnvironmentVariables",
+            &format!(
+                "<dict>\n    <key>PATH</key>\n    <string>{}</string>\n  </dict>",
+                xml_escape(path)
+            ),
+        ));
+    }
+    out.push_str(&plist_entry("RunAtLoad", "<true/>"));
+    if spec.restart {
+        out.push_str(&plist_entry(
+            "KeepAlive",
+            "<dict>\n    <key>SuccessfulExit</key>\n    <false/>\n  </dict>",
+        ));
+    }
+    if let Some(log_file) = &spec.log_file {
+        let log = format!(
+            "<string>{}</string>",
+            xml_escape(&log_file.to_string_lossy())
+        );
+        out.push_str(&plist_entry("StandardOutPath", &log));
+        out.push_str(&plist_entry("StandardErrorPath", &log));
+    }
+    out.push_str("</dict>\n</plist>\n");
+    out
+}
+
+fn plist_entry(key: &str, value: &str) -> String {
+    format!("  <key>{}</key>\n  {}\n", key, value)
+}
+
+fn xml_escape(value: &str) -> String {
+    value
+        .replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+        .replace('\'', "&apos;")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn install_args(target: &str, dir: &Path, args: &[&str]) -> ServiceInstallArgs {
+        ServiceInstallArgs {
+            target: target.to_string(),
+            dir: dir.to_path_buf(),
+            name: Some("demo".to_string()),
+            manager: None,
+            output: None,
+            print: true,
+            args: args.iter().map(|arg| arg.to_string()).collect(),
+        }
+    }
+
+    #[test]
+    fn loop_service_runs_start_in_foreground_without_restart() {
+        let temp = tempfile::tempdir().unwrap();
+        let dir = temp.path().canonicalize().unwrap();
+        let args = install_args("loop", &dir, &["--backend", "codex"]);
+
+        let spec = service_spec(&args, Path::new("/usr/local/bin/gralph"), None).unwrap();
+        let unit = render_systemd(&spec);
+
+        assert_eq!(spec.id, "demo");
+        assert!(unit.contains(&format!(
+            "ExecStart=/usr/local/bin/gralph start {} --name demo --no-tmux --backend codex\n",
+            dir.display()
+        )));
+        assert!(unit.contains(&format!("WorkingDirectory={}\n", dir.display())));
+        assert!(!unit.contains("Restart="));
+        assert!(unit.ends_with("[Install]\nWantedBy=default.target\n"));
+    }
+
+    #[test]
+    fn server_service_restarts_on_failure_and_keeps_path() {
+        let temp = tempfile::tempdir().unwrap();
+        let args = install_args("server", temp.path(), &["--port", "9000"]);
+
+        let spec = service_spec(
+            &args,
+            Path::new("/opt/gralph"),
+            Some("/usr/bin:/bin".to_string()),
+        )
+        .unwrap();
+
+        let unit = render_systemd(&spec);
+        assert!(unit.contains("ExecStart=/opt/gralph server --port 9000\n"));
+        assert!(unit.contains("Environment=PATH=/usr/bin:/bin\n"));
+        assert!(unit.contains("Restart=on-failure\n"));
+
+        let plist = render_launchd(&spec);
+        assert!(plist.contains("<string>dev.gralph.server</string>"));
+        assert!(plist.contains("<key>SuccessfulExit</key>\n    <false/>"));
+        assert!(plist.contains("    <string>--port</string>\n    <string>9000</string>\n"));
+    }
+
+    #[test]
+    fn daemon_service_runs_the_daemon_and_restarts_on_failure() {
+        let temp = tempfile::tempdir().unwrap();
+        let args = install_args("daemon", temp.path(), &[]);
+
+        let spec = service_spec(&args, Path::new("/opt/gralph"), None).unwrap();
+
+        assert_eq!(spec.id, "daemon");
+        let unit = render_systemd(&spec);
+        assert!(unit.contains("Description=gralph loop daemon\n"));
+        assert!(unit.contains("ExecStart=/opt/gralph daemon\n"));
+        assert!(unit.contains("Restart=on-failure\n"));
+    }
+
+    #[test]
+    fn systemd_quote_escapes_specifiers_and_spaces() {
+        assert_eq!(systemd_quote("/usr/bin/gralph"), "/usr/bin/gralph");
+        assert_eq!(systemd_quote("50%"), "50%%");
+        assert_eq!(systemd_quote("$HOME"), "$$HOME");
+        assert_eq!(systemd_quote("my app"), "\"my app\"");
+        assert_eq!(systemd_quote("say \"hi\""), "\"say \\\"hi\\\"\"");
+        assert_eq!(systemd_quote(""), "\"\"");
+    }
+
+    #[test]
+    fn xml_escape_handles_markup() {
+        assert_eq!(xml_escape("a<b>&\"c'"), "a&lt;b&gt;&amp;&quot;c&apos;");
+    }
+
+    #[test]
+    fn loop_service_rejects_missing_directory() {
+        let temp = tempfile::tempdir().unwrap();
+        let args = install_args("loop", &temp.path().join("missing"), &[]);
+
+        let err = service_spec(&args, Path::new("/opt/gralph"), None).unwrap_err();
+
+        assert!(err.to_string().contains("Directory does not exist"));
+    }
+}
@@ -0,0 +1,506 @@
+use super::loop_session::{follow_log, print_table, resolve_log_file, session_store};
+use super::picker::{self, Pick, PickerEntry};
+use super::{CliError, Deps, ProcessRunner, print_json};
+use crate::cli::AttachArgs;
+use crate::state::StateStore;
+use serde_json::Value;
+use std::collections::BTreeSet;
+use std::env;
+use std::process::{Command as ProcCommand, Stdio};
+
+/// Prefix of the tmux session a loop runs in: `gralph-<name>`.
+const TMUX_PREFIX: &str = "gralph-";
+
+/// A session on the local tmux server.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct TmuxSession {
+    name: String,
+    windows: usize,
+    panes: usize,
+    /// Panes whose command has exited (kept open by `remain-on-exit`).
+    dead_panes: usize,
+}
+
+/// How a state record and the tmux server line up.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum TmuxHealth {
+    Ok,
+    /// Some panes exited; the loop may have crashed inside tmux.
+    DeadPanes,
+    /// The record names a tmux session that no longer exists.
+    Missing,
+    /// The loop runs as a plain background process.
+    NoTmux,
+    /// A `gralph-*` tmux session without a state record.
+    Orphan,
+}
+
+impl TmuxHealth {
+    fn as_str(self) -> &'static str {
+        match self {
+            TmuxHealth::Ok => "ok",
+            TmuxHealth::DeadPanes => "dead panes",
+            TmuxHealth::Missing => "missing",
+            TmuxHealth::NoTmux => "no tmux",
+            TmuxHealth::Orphan => "orphan",
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct TmuxCheck {
+    /// State record name; empty for orphans.
+    name: String,
+    /// Index of the state record in the session list.
+    record: Option<usize>,
+    tmux: Option<TmuxSession>,
+    status: String,
+    health: TmuxHealth,
+    /// New `tmux_session` value for the record, when it disagrees with tmux.
+    update: Option<String>,
+}
+
+/// Attaches to the tmux session a loop runs in. Loops started as background
+/// processes have none, so their log is followed instead.
+pub(super) fn cmd_attach(args: AttachArgs, deps: &Deps) -> Result<(), CliError> {
+    let store = deps.state_store();
+    store
+        .init_state()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    let name = match args.name.clone() {
+        Some(name) => name,
+        None => {
+            let sessions = store
+                .list_sessions()
+                .map_err(|err| CliError::Message(err.to_string()))?;
+            let entries = sessions
+                .iter()
+                .filter(|session| is_live(session, deps.process()))
+                .filter_map(PickerEntry::from_session)
+                .collect::<Vec<_>>();
+            match picker::pick_on_terminal(&entries, "attach to", false)? {
+                Some(Pick::Session(name)) => name,
+                _ => return Err(CliError::Message("Session name is required.".to_string())),
+            }
+        }
+    };
+    let session = store
+        .get_session(&name)
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .ok_or_else(|| CliError::Message(format!("Session not found: {}", name)))?;
+    if !is_live(&session, deps.process()) {
+        let status = session
+            .get("status")
+            .and_then(Value::as_str)
+            .unwrap_or("unknown");
+        return Err(CliError::Message(format!(
+            "Session {} is not running (status: {}). Use `gralph logs {}` to read its log.",
+            name, status, name
+        )));
+    }
+
+    let tmux_sessions = list_tmux_sessions().unwrap_or_default();
+    match attach_target(&name, &session, &tmux_sessions) {
+        Some(target) => attach_tmux(&target),
+        None => {
+            let log_file = resolve_log_file(&name, &session)?;
+            println!(
+                "Session {} runs without tmux; following {} (Ctrl-C to stop).",
+                name,
+                log_file.display()
+            );
+            follow_log(&log_file, false, deps.fs(), deps.clock())
+        }
+    }
+}
+
+/// `gralph status --tmux`: lists tmux sessions next to the state records
+/// they belong to, and fixes records whose `tmux_session` is out of date.
+pub(super) fn cmd_status_tmux(store: &StateStore, json: bool, deps: &Deps) -> Result<(), CliError> {
+    let Some(tmux_sessions) = list_tmux_sessions() else {
+        return Err(CliError::Message(
+            "tmux is not installed; loops run as background processes (see `gralph status`)."
+                .to_string(),
+        ));
+    };
+    let sessions = store
+        .list_sessions()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    let checks = reconcile(&sessions, &tmux_sessions, deps.process());
+
+    for check in &checks {
+        let (Some(update), Some(record)) = (&check.update, check.record) else {
+            continue;
+        };
+        let session = &sessions[record];
+        session_store(store, session)
+            .set_session(&check.name, &[("tmux_session", update.as_str())])
+            .map_err(|err| CliError::Message(err.to_string()))?;
+    }
+
+    if json {
+        let sessions = checks
+            .iter()
+            .map(|check| {
+                serde_json::json!({
+                    "name": check.name,
+                    "tmux_session": check.tmux.as_ref().map(|tmux| tmux.name.clone()),
+                    "windows": check.tmux.as_ref().map_or(0, |tmux| tmux.windows),
+                    "panes": check.tmux.as_ref().map_or(0, |tmux| tmux.panes),
+                    "dead_panes": check.tmux.as_ref().map_or(0, |tmux| tmux.dead_panes),
+                    "status": check.status,
+                    "health": check.health.as_str(),
+                    "reconciled": check.update.is_some(),
+                })
+            })
+            .collect::<Vec<_>>();
+        return print_json(&serde_json::json!({ "sessions": sessions }));
+    }
+    if checks.is_empty() {
+        println!("No running sessions or gralph tmux sessions.");
+        return Ok(());
+    }
+
+    let rows = checks
+        .iter()
+        .map(|check| {
+            let (tmux, panes) = match &check.tmux {
+                Some(tmux) if tmux.dead_panes > 0 => (
+                    tmux.name.clone(),
+                    format!("{} ({} dead)", tmux.panes, tmux.dead_panes),
+                ),
+                Some(tmux) => (tmux.name.clone(), tmux.panes.to_string()),
+                None => ("-".to_string(), "-".to_string()),
+            };
+            let name = if check.name.is_empty() {
+                "-".to_string()
+            } else {
+                check.name.clone()
+            };
+            vec![
+                name,
+                tmux,
+                panes,
+                check.status.clone(),
+                check.health.as_str().to_string(),
+            ]
+        })
+        .collect::<Vec<_>>();
+    print_table(&["NAME", "TMUX", "PANES", "STATUS", "HEALTH"], &rows);
+
+    for check in &checks {
+        match check.update.as_deref() {
+            Some("") => println!("Cleared the missing tmux session from {}.", check.name),
+            Some(tmux) => println!("Recorded tmux session {} for {}.", tmux, check.name),
+            None => {}
+        }
+    }
+    if checks
+        .iter()
+        .any(|check| check.health == TmuxHealth::Orphan)
+    {
+        println!(
+            "Orphaned tmux sessions have no gralph state; inspect one with `tmux attach -t <session>` or end it with `tmux kill-session -t <session>`."
+        );
+    }
+    Ok(())
+}
+
+/// Pairs state records with tmux sessions. A record matches the tmux
+/// session it names, or `gralph-<name>` when it names none. Finished records
+/// without a tmux session are left out.
+fn reconcile(
+    sessions: &[Value],
+    tmux_sessions: &[TmuxSession],
+    process: &dyn ProcessRunner,
+) -> Vec<TmuxCheck> {
+    let mut matched = BTreeSet::new();
+    let mut checks = Vec::new();
+    for (record, session) in sessions.iter().enumerate() {
+        let Some(name) = session
+            .get("name")
+            .and_then(Value::as_str)
+            .filter(|name| !name.is_empty())
+        else {
+            continue;
+        };
+        let recorded = recorded_tmux(session);
+        let target = recorded
+            .clone()
+            .unwrap_or_else(|| format!("{}{}", TMUX_PREFIX, name));
+        let tmux = tmux_sessions.iter().find(|tmux| tmux.name == target);
+        let live = is_live(session, process);
+        if !live && tmux.is_none() && recorded.is_none() {
+            continue;
+        }
+        let (health, update) = match tmux {
+            Some(tmux) => {
+                let health = if tmux.dead_panes > 0 {
+                    TmuxHealth::DeadPanes
+                } else {
+                    TmuxHealth::Ok
+                };
+                (health, recorded.is_none().then(|| tmux.name.clone()))
+            }
+            None if recorded.is_some() => (TmuxHealth::Missing, Some(String::new())),
+            None => (TmuxHealth::NoTmux, None),
+        };
+        if let Some(tmux) = tmux {
+            matched.insert(tmux.name.clone());
+        }
+        let mut status = session
+            .get("status")
+            .and_then(Value::as_str)
+            .unwrap_or("unknown")
+            .to_string();
+        if matches!(status.as_str(), "running" | "paused") && !live {
+            status = "stale".to_string();
+        }
+        checks.push(TmuxCheck {
+            name: name.to_string(),
+            record: Some(record),
+            tmux: tmux.cloned(),
+            status,
+            health,
+            update,
+        });
+    }
+    for tmux in tmux_sessions {
+        if tmux.name.starts_with(TMUX_PREFIX) && !matched.contains(&tmux.name) {
+            checks.push(TmuxCheck {
+                name: String::new(),
+                record: None,
+                tmux: Some(tmux.clone()),
+                status: "-".to_string(),
+                health: TmuxHealth::Orphan,
+                update: None,
+            });
+        }
+    }
+    checks
+}
+
+fn is_live(session: &Value, process: &dyn ProcessRunner) -> bool {
+    let status = session
+        .get("status")
+        .and_then(Value::as_str)
+        .unwrap_or("unknown");
+    let pid = session.get("pid").and_then(Value::as_i64).unwrap_or(0);
+    matches!(status, "running" | "paused") && process.is_alive(pid)
+}
+
+fn recorded_tmux(session: &Value) -> Option<String> {
+    session
+        .get("tmux_session")
+        .and_then(Value::as_str)
+        .map(str::trim)
+        .filter(|tmux| !tmux.is_empty())
+        .map(str::to_string)
+}
+
+/// The tmux session to attach to for `name`, if it exists.
+fn attach_target(name: &str, session: &Value, tmux_sessions: &[TmuxSession]) -> Option<String> {
+    let target = recorded_tmux(session).unwrap_or_else(|| format!("{}{}", TMUX_PREFIX, name));
+    tmux_sessions
+        .iter()
+        .any(|tmux| tmux.name == target)
+        .then_some(target)
+}
+
+fn attach_tmux(target: &str) -> Result<(), CliError> {
+    // Inside tmux, attaching would nest sessions; switch the client instead.
+    let verb = if env::var_os("TMUX").is_some() {
+        "switch-client"
+    } else {
+        "attach-session"
+    };
+    let mut cmd = ProcCommand::new("tmux");
+    cmd.arg(verb).arg("-t").arg(format!("={}", target));
+    #[cfg(unix)]
+    {
+        use std::os::unix::process::CommandExt;
+        let err = cmd.exec();
+        return Err(CliError::Message(format!("Failed to run tmux: {}", err)));
+    }
+    #[cfg(not(unix))]
+    {
+        let status = cmd
+            .status()
+            .map_err(|err| CliError::Message(format!("Failed to run tmux: {}", err)))?;
+        if status.success() {
+            Ok(())
+        } else {
+            Err(CliError::Message(format!(
+                "tmux could not attach to {}",
+                target
+            )))
+        }
+    }
+}
+
+/// Sessions on the local tmux server. `None` when tmux is not installed;
+/// empty when no server is running.
+fn list_tmux_sessions() -> Option<Vec<TmuxSession>> {
+    let output = ProcCommand::new("tmux")
+        .args([
+            "list-panes",
+            "-a",
+            "-F",
+            "#{session_name}\t#{window_index}\t#{pane_dead}",
+        ])
+        .stdin(Stdio::null())
+        .stderr(Stdio::null())
+        .output()
+        .ok()?;
+    if !output.status.success() {
+        return Some(Vec::new());
+    }
+    Some(parse_tmux_panes(&String::from_utf8_lossy(&output.stdout)))
+}
+
+/// Groups `list-panes -a` lines (`session<TAB>window<TAB>dead`) by session.
+fn parse_tmux_panes(output: &str) -> Vec<TmuxSession> {
+    let mut sessions: Vec<TmuxSession> = Vec::new();
+    let mut windows = BTreeSet::new();
+    for line in output.lines() {
+        let mut fields = line.split('\t');
+        let (Some(name), Some(window), Some(dead)) = (fields.next(), fields.next(), fields.next())
+        else {
+            continue;
+        };
+        let index = match sessions.iter().position(|session| session.name == name) {
+            Some(index) => index,
+            None => {
+                sessions.push(TmuxSession {
+                    name: name.to_string(),
+                    windows: 0,
+                    panes: 0,
+                    dead_panes: 0,
+                });
+                sessions.len() - 1
+            }
+        };
+        let session = &mut sessions[index];
+        if windows.insert((name.to_string(), window.to_string())) {
+            session.windows += 1;
+        }
+        session.panes += 1;
+        if dead.trim() == "1" {
+            session.dead_panes += 1;
+        }
+    }
+    sessions
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+    use std::io;
+    use std::path::PathBuf;
+    use std::process::{Child, Command};
+
+    struct AliveRunner;
+
+    impl ProcessRunner for AliveRunner {
+        fn current_exe(&self) -> io::Result<PathBuf> {
+            Ok(PathBuf::from("/bin/true"))
+        }
+
+        fn spawn(&self, _cmd: &mut Command) -> io::Result<Child> {
+            Err(io::Error::new(io::ErrorKind::Other, "not used"))
+        }
+
+        fn kill_tmux_session(&self, _session: &str) {}
+
+        fn kill_pid(&self, _pid: i64) {}
+
+        fn pid(&self) -> u32 {
+            0
+        }
+
+        fn is_alive(&self, pid: i64) -> bool {
+            pid == 100
+        }
+    }
+
+    fn tmux(name: &str, panes: usize, dead_panes: usize) -> TmuxSession {
+        TmuxSession {
+            name: name.to_string(),
+            windows: 1,
+            panes,
+            dead_panes,
+        }
+    }
+
+    #[test]
+    fn parse_tmux_panes_counts_windows_and_dead_panes() {
+        let output = "gralph-api\t0\t0\ngralph-api\t0\t1\ngralph-api\t1\t0\nwork\t0\t0\nbad line\n";
+        assert_eq!(
+            parse_tmux_panes(output),
+            vec![
+                TmuxSession {
+                    name: "gralph-api".to_string(),
+                    windows: 2,
+                    panes: 3,
+                    dead_panes: 1,
+                },
+                tmux("work", 1, 0),
+            ]
+        );
+    }
+
+    #[test]
+    fn reconcile_matches_records_and_reports_mismatches() {
+        let sessions = vec![
+            json!({"name": "api", "status": "running", "pid": 100, "tmux_session": ""}),
+            json!({"name": "web", "status": "running", "pid": 100, "tmux_session": "gralph-web"}),
+            json!({"name": "cli", "status": "running", "pid": 100}),
+            json!({"name": "old", "status": "complete", "pid": 0}),
+            json!({"name": "gone", "status": "running", "pid": 7, "tmux_session": "gralph-gone"}),
+        ];
+        let tmux_sessions = vec![
+            tmux("gralph-api", 1, 0),
+            tmux("gralph-web", 2, 1),
+            tmux("gralph-lost", 1, 0),
+            tmux("work", 1, 0),
+        ];
+        let checks = reconcile(&sessions, &tmux_sessions, &AliveRunner);
+        let summary = checks
+            .iter()
+            .map(|check| {
+                (
+                    check.name.as_str(),
+                    check.health,
+                    check.status.as_str(),
+                    check.update.as_deref(),
+                )
+            })
+            .collect::<Vec<_>>();
+        assert_eq!(
+            summary,
+            vec![
+                ("api", TmuxHealth::Ok, "running", Some("gralph-api")),
+                ("web", TmuxHealth::DeadPanes, "running", None),
+                ("cli", TmuxHealth::NoTmux, "running", None),
+                ("gone", TmuxHealth::Missing, "stale", Some("")),
+                ("", TmuxHealth::Orphan, "-", None),
+            ]
+        );
+        assert_eq!(checks[4].tmux.as_ref().unwrap().name, "gralph-lost");
+    }
+
+    #[test]
+    fn attach_target_prefers_the_recorded_session() {
+        let tmux_sessions = vec![tmux("gralph-api", 1, 0), tmux("custom", 1, 0)];
+        assert_eq!(
+            attach_target("api", &json!({"tmux_session": ""}), &tmux_sessions).as_deref(),
+            Some("gralph-api")
+        );
+        assert_eq!(
+            attach_target("api", &json!({"tmux_session": "custom"}), &tmux_sessions).as_deref(),
+            Some("custom")
+        );
+        assert_eq!(attach_target("web", &json!({}), &tmux_sessions), None);
+    }
+}
@@ -0,0 +1,502 @@
+//! `gralph watch`: a dashboard of every session with a tail of one
+//! session's log.
+//!
+//! On a terminal the view is interactive: `j`/`k` or the arrow keys move the
+//! selection, the log pane follows the selected session, and `q` quits.
+//! With `--once` or piped output, plain frames are printed instead.
+
+use super::loop_session::{enrich_status_session, resolve_log_file, tail_lines};
+use super::{CliError, Deps, FileSystem};
+use crate::cli::WatchArgs;
+use crate::logging;
+use crate::state::{CleanupMode, StateStore};
+use serde_json::Value;
+#[cfg(unix)]
+use std::io::IsTerminal;
+use std::io::{self, Write};
+use std::time::Duration;
+
+const CLEAR_SCREEN: &str = "\x1b[2J\x1b[H";
+const PROGRESS_WIDTH: usize = 20;
+const PLAIN_HINT: &str = "Ctrl-C to exit";
+const INTERACTIVE_HINT: &str = "j/k or arrows to select, q to quit";
+/// Rows a frame uses besides the session rows and the log lines: the title
+/// and a blank line, the table header, and a blank line and the log title.
+const FRAME_ROWS: usize = 5;
+
+/// A key the interactive view acts on.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Key {
+    Up,
+    Down,
+    Quit,
+}
+
+pub(super) fn cmd_watch(args: WatchArgs, deps: &Deps) -> Result<(), CliError> {
+    let store = deps.state_store();
+    store
+        .init_state()
+        .map_err(|err| CliError::Message(err.to_string()))?;
+    let interval = Duration::from_secs(args.interval.max(1));
+
+    #[cfg(unix)]
+    if !args.once && io::stdin().is_terminal() && io::stdout().is_terminal() {
+        return watch_interactive(&store, &args, interval, deps);
+    }
+
+    loop {
+        let sessions = load_sessions(&store, deps)?;
+        let frame = render_frame(
+            &sessions,
+            args.name.as_deref(),
+            args.lines,
+            args.interval.max(1),
+            PLAIN_HINT,
+            deps.fs(),
+        );
+
+        let mut stdout = io::stdout();
+        if !args.once {
+            write!(stdout, "{}", CLEAR_SCREEN).map_err(CliError::Io)?;
+        }
+        write!(stdout, "{}", frame).map_err(CliError::Io)?;
+        stdout.flush().map_err(CliError::Io)?;
+
+        if args.once {
+            return Ok(());
+        }
+        deps.clock().sleep(interval);
+    }
+}
+
+/// Redraws on every refresh and every key press until `q`. The selection is
+/// kept by name, so it survives sessions being added or reordered.
+#[cfg(unix)]
+fn watch_interactive(
+    store: &StateStore,
+    args: &WatchArgs,
+    interval: Duration,
+    deps: &Deps,
+) -> Result<(), CliError> {
+    let terminal = terminal::RawTerminal::enter().map_err(CliError::Io)?;
+    let mut selected = args.name.clone();
+    loop {
+        let sessions = load_sessions(store, deps)?;
+        let index = select_session(&sessions, selected.as_deref())
+            .or_else(|| select_session(&sessions, None));
+        selected = index.and_then(|index| session_name(&sessions, index));
+        let lines = pane_lines(args.lines, terminal::rows(), sessions.len());
+        let frame = render_frame(
+            &sessions,
+            selected.as_deref(),
+            lines,
+            args.interval.max(1),
+            INTERACTIVE_HINT,
+            deps.fs(),
+        );
+
+        let mut stdout = io::stdout();
+        write!(stdout, "{}{}", CLEAR_SCREEN, frame).map_err(CliError::Io)?;
+        stdout.flush().map_err(CliError::Io)?;
+
+        let input = terminal.read_input(interval).map_err(CliError::Io)?;
+        let mut index = index;
+        for key in parse_keys(&input) {
+            if key == Key::Quit {
+                return Ok(());
+            }
+            index = move_selection(sessions.len(), index, key);
+        }
+        selected = index.and_then(|index| session_name(&sessions, index));
+    }
+}
+
+fn load_sessions(store: &StateStore, deps: &Deps) -> Result<Vec<Value>, CliError> {
+    let _ = store.cleanup_stale(CleanupMode::Mark);
+    Ok(store
+        .list_sessions()
+        .map_err(|err| CliError::Message(err.to_string()))?
+        .into_iter()
+        .map(|session| enrich_status_session(session, deps.process()))
+        .collect())
+}
+
+fn session_name(sessions: &[Value], index: usize) -> Option<String> {
+    sessions
+        .get(index)
+        .and_then(|session| str_field(session, "name"))
+        .map(str::to_string)
+}
+
+/// Log lines that fit under the table: at most `lines`, and at least one.
+fn pane_lines(lines: usize, rows: Option<usize>, sessions: usize) -> usize {
+    match rows {
+        Some(rows) => lines.min(rows.saturating_sub(sessions + FRAME_ROWS)).max(1),
+        None => lines,
+    }
+}
+
+/// Keys in one read from the terminal. Arrow keys arrive as escape
+/// sequences; a lone escape quits, like `q` and Ctrl-C.
+fn parse_keys(input: &[u8]) -> Vec<Key> {
+    let mut keys = Vec::new();
+    let mut rest = input;
+    while !rest.is_empty() {
+        let (key, len) = match rest {
+            [0x1b, b'[', b'A', ..] => (Some(Key::Up), 3),
+            [0x1b, b'[', b'B', ..] => (Some(Key::Down), 3),
+            [0x1b, b'[', _, ..] => (None, 3),
+            [0x1b] | [b'q', ..] | [0x03, ..] => (Some(Key::Quit), 1),
+            [b'k', ..] => (Some(Key::Up), 1),
+            [b'j', ..] => (Some(Key::Down), 1),
+            _ => (None, 1),
+        };
+        keys.extend(key);
+        rest = &rest[len.min(rest.len())..];
+    }
+    keys
+}
+
+fn move_selection(len: usize, current: Option<usize>, key: Key) -> Option<usize> {
+    if len == 0 {
+        return None;
+    }
+    let current = current.unwrap_or(0).min(len - 1);
+    Some(match key {
+        Key::Up => current.saturating_sub(1),
+        Key::Down => (current + 1).min(len - 1),
+        Key::Quit => current,
+    })
+}
+
+fn render_frame(
+    sessions: &[Value],
+    name: Option<&str>,
+    lines: usize,
+    interval: u64,
+    hint: &str,
+    fs: &dyn FileSystem,
+) -> String {
+    let mut out = String::new();
+    out.push_str(&format!(
+        "gralph watch - {} session(s) - refresh {}s ({})\n\n",
+        sessions.len(),
+        interval,
+        hint
+    ));
+    if sessions.is_empty() {
+        out.push_str("No sessions found.\n");
+        return out;
+    }
+
+    let selected = select_session(sessions, name);
+    let rows = sessions
+        .iter()
+        .enumerate()
+        .map(|(index, session)| session_row(session, selected == Some(index)))
+        .collect::<Vec<_>>();
+    out.push_str(&format_table(
+        &["", "NAME", "ITERATION", "PROGRESS", "STATUS", "REMAINING"],
+        &rows,
+    ));
+
+    let Some(index) = selected else {
+        if let Some(name) = name {
+            out.push_str(&format!("\nSession not found: {}\n", name));
+        }
+        return out;
+    };
+    let session = &sessions[index];
+    let name = str_field(session, "name").unwrap_or("unknown");
+    out.push('\n');
+    match resolve_log_file(name, session) {
+        Ok(path) => {
+            out.push_str(&format!("LOG {} ({})\n", name, path.display()));
+            match fs.read_to_string(&path) {
+                Ok(contents) => {
+                    for line in tail_lines(&contents, lines) {
+                        out.push_str(&logging::display_line(line));
+                        out.push('\n');
+                    }
+                }
+                Err(_) => out.push_str("(log file not available yet)\n"),
+            }
+        }
+        Err(err) => out.push_str(&format!("LOG {} ({})\n", name, err)),
+    }
+    out
+}
+
+fn select_session(sessions: &[Value], name: Option<&str>) -> Option<usize> {
+    if let Some(name) = name {
+        return sessions
+            .iter()
+            .position(|session| str_field(session, "name") == Some(name));
+    }
+    sessions
+        .iter()
+        .position(|session| str_field(session, "status") == Some("running"))
+        .or_else(|| (!sessions.is_empty()).then_some(0))
+}
+
+fn session_row(session: &Value, selected: bool) -> Vec<String> {
+    let iteration = u64_field(session, "iteration");
+    let max_iterations = u64_field(session, "max_iterations");
+    vec![
+        if selected { ">" } else { "" }.to_string(),
+        str_field(session, "name").unwrap_or("unknown").to_string(),
+        format!("{}/{}", iteration, max_iterations),
+        progress_bar(iteration, max_iterations, PROGRESS_WIDTH),
+        str_field(session, "status")
+            .unwrap_or("unknown")
+            .to_string(),
+        u64_field(session, "current_remaining").to_string(),
+    ]
+}
+
+fn progress_bar(current: u64, total: u64, width: usize) -> String {
+    let filled = if total == 0 {
+        0
+    } else {
+        ((current.min(total) as usize) * width) / total as usize
+    };
+    format!("[{}{}]", "#".repeat(filled), "-".repeat(width - filled))
+}
+
+fn format_table(headers: &[&str], rows: &[Vec<String>]) -> String {
+    let mut widths = headers.iter().map(|h| h.len()).collect::<Vec<_>>();
+    for row in rows {
+        for (index, col) in row.iter().enumerate() {
+            widths[index] = widths[index].max(col.len());
+        }
+    }
+    let mut out = String::new();
+    let mut push_row = |cols: Vec<&str>| {
+        let line = cols
+            .iter()
+            .enumerate()
+            .map(|(index, col)| format!("{:width$}", col, width = widths[index]))
+            .collect::<Vec<_>>()
+            .join("  ");
+        out.push_str(line.trim_end());
+        out.push('\n');
+    };
+    push_row(headers.to_vec());
+    for row in rows {
+        push_row(row.iter().map(String::as_str).collect());
+    }
+    out
+}
+
+fn str_field<'a>(session: &'a Value, key: &str) -> Option<&'a str> {
+    session.get(key).and_then(|v| v.as_str())
+}
+
+fn u64_field(session: &Value, key: &str) -> u64 {
+    session.get(key).and_then(|v| v.as_u64()).unwrap_or(0)
+}
+
+/// The terminal in the mode the interactive view needs: keys arrive one at
+/// a time without echo, on the alternate screen with the cursor hidden.
+/// Dropping it puts the terminal back.
+#[cfg(unix)]
+mod terminal {
+    use std::io::{self, Write};
+    use std::time::Duration;
+
+    pub(super) struct RawTerminal {
+        saved: libc::termios,
+    }
+
+    impl RawTerminal {
+        pub(super) fn enter() -> io::Result<Self> {
+            let mut saved = unsafe { std::mem::zeroed::<libc::termios>() };
+            if unsafe { libc::tcgetattr(libc::STDIN_FILENO, &mut saved) } != 0 {
+                return Err(io::Error::last_os_error());
+            }
+            let mut raw = saved;
+            // Ctrl-C arrives as a key, so the terminal is restored on quit.
+            raw.c_lflag &= !(libc::ICANON | libc::ECHO | libc::ISIG);
+            raw.c_cc[libc::VMIN] = 0;
+            raw.c_cc[libc::VTIME] = 0;
+            if unsafe { libc::tcsetattr(libc::STDIN_FILENO, libc::TCSANOW, &raw) } != 0 {
+                return Err(io::Error::last_os_error());
+            }
+            let mut stdout = io::stdout();
+            write!(stdout, "\x1b[?1049h\x1b[?25l")?;
+            stdout.flush()?;
+            Ok(Self { saved })
+        }
+
+        /// Waits up to `timeout` for input and returns what was typed.
+        pub(super) fn read_input(&self, timeout: Duration) -> io::Result<Vec<u8>> {
+            let mut poll = libc::pollfd {
+                fd: libc::STDIN_FILENO,
+                events: libc::POLLIN,
+                revents: 0,
+            };
+            let timeout = timeout.as_millis().min(libc::c_int::MAX as u128) as libc::c_int;
+            match unsafe { libc::poll(&mut poll, 1, timeout) } {
+                0 => return Ok(Vec::new()),
+                ready if ready < 0 => {
+                    let err = io::Error::last_os_error();
+                    if err.kind() == io::ErrorKind::Interrupted {
+                        return Ok(Vec::new());
+                    }
+                    return Err(err);
+                }
+                _ => {}
+            }
+            let mut buf = [0u8; 64];
+            let read =
+                unsafe { libc::read(libc::STDIN_FILENO, buf.as_mut_ptr().cast(), buf.len()) };
+            if read < 0 {
+                return Err(io::Error::last_os_error());
+            }
+            Ok(buf[..read as usize].to_vec())
+        }
+    }
+
+    impl Drop for RawTerminal {
+        fn drop(&mut self) {
+            let mut stdout = io::stdout();
+            let _ = write!(stdout, "\x1b[?25h\x1b[?1049l");
+            let _ = stdout.flush();
+            unsafe { libc::tcsetattr(libc::STDIN_FILENO, libc::TCSANOW, &self.saved) };
+        }
+    }
+
+    /// Height of the terminal on stdout, if it reports one.
+    pub(super) fn rows() -> Option<usize> {
+        let mut size = unsafe { std::mem::zeroed::<libc::winsize>() };
+        let ok = unsafe { libc::ioctl(libc::STDOUT_FILENO, libc::TIOCGWINSZ, &mut size) } == 0;
+        (ok && size.ws_row > 0).then_some(size.ws_row as usize)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::app::RealFileSystem;
+    use std::fs;
+
+    fn frame(sessions: &[Value], name: Option<&str>) -> String {
+        render_frame(sessions, name, 2, 2, PLAIN_HINT, &RealFileSystem)
+    }
+
+    #[test]
+    fn progress_bar_scales_and_clamps() {
+        assert_eq!(progress_bar(0, 0, 4), "[----]");
+        assert_eq!(progress_bar(2, 4, 4), "[##--]");
+        assert_eq!(progress_bar(9, 4, 4), "[####]");
+    }
+
+    #[test]
+    fn select_session_prefers_name_then_running_then_first() {
+        let sessions = vec![
+            serde_json::json!({"name": "alpha", "status": "stopped"}),
+            serde_json::json!({"name": "beta", "status": "running"}),
+        ];
+        assert_eq!(select_session(&sessions, Some("alpha")), Some(0));
+        assert_eq!(select_session(&sessions, Some("gamma")), None);
+        assert_eq!(select_session(&sessions, None), Some(1));
+        assert_eq!(select_session(&sessions[..1], None), Some(0));
+        assert_eq!(select_session(&[], None), None);
+    }
+
+    #[test]
+    fn render_frame_includes_table_and_log_tail() {
+        let temp = tempfile::tempdir().unwrap();
+        let log_path = temp.path().join("alpha.log");
+        fs::write(&log_path, "one\ntwo\nthree\n").unwrap();
+        let sessions = vec![serde_json::json!({
+            "name": "alpha",
+            "status": "running",
+            "iteration": 3,
+            "max_iterations": 6,
+            "current_remaining": 2,
+            "log_file": log_path.to_string_lossy(),
+        })];
+
+        let frame = frame(&sessions, None);
+
+        assert!(frame.contains("1 session(s)"));
+        assert!(frame.contains("> "));
+        assert!(frame.contains("3/6"));
+        assert!(frame.contains("[##########----------]"));
+        assert!(frame.contains("LOG alpha"));
+        assert!(frame.contains("two\nthree\n"));
+        assert!(!frame.contains("one\n"));
+    }
+
+    #[test]
+    fn render_frame_reports_empty_and_unknown_sessions() {
+        let frame = frame(&[], None);
+        assert!(frame.contains("No sessions found."));
+
+        let sessions = vec![serde_json::json!({"name": "alpha", "status": "running"})];
+        let frame = frame(&sessions, Some("beta"));
+        assert!(frame.contains("Session not found: beta"));
+    }
+
+    #[test]
+    fn parse_keys_reads_letters_arrows_and_quit() {
+        assert_eq!(
+            parse_keys(b"jk\x1b[B\x1b[A"),
+            vec![Key::Down, Key::Up, Key::Down, Key::Up]
+        );
+        assert_eq!(parse_keys(b"x\x1b[Cq"), vec![Key::Quit]);
+        assert_eq!(parse_keys(b"\x03"), vec![Key::Quit]);
+        assert_eq!(parse_keys(b"\x1b"), vec![Key::Quit]);
+        assert!(parse_keys(b"").is_empty());
+    }
+
+    #[test]
+    fn move_selection_stays_within_the_list() {
+        assert_eq!(move_selection(3, Some(0), Key::Up), Some(0));
+        assert_eq!(move_selection(3, Some(0), Key::Down), Some(1));
+        assert_eq!(move_selection(3, Some(2), Key::Down), Some(2));
+        assert_eq!(move_selection(3, None, Key::Down), Some(1));
+        assert_eq!(move_selection(2, Some(5), Key::Up), Some(0));
+        assert_eq!(move_selection(0, Some(0), Key::Down), None);
+    }
+
+    #[test]
+    fn pane_lines_fit_the_terminal() {
+        assert_eq!(pane_lines(15, None, 3), 15);
+        assert_eq!(pane_lines(15, Some(40), 3), 15);
+        assert_eq!(pane_lines(15, Some(20), 3), 12);
+        assert_eq!(pane_lines(15, Some(4), 3), 1);
+    }
+
+    #[test]
+    fn render_frame_tails_the_selected_session() {
+        let temp = tempfile::tempdir().unwrap();
+        let sessions = ["alpha", "beta"]
+            .iter()
+            .map(|name| {
+                let log_path = temp.path().join(format!("{}.log", name));
+                fs::write(&log_path, format!("{} log\n", name)).unwrap();
+                serde_json::json!({
+                    "name": name,
+                    "status": "running",
+                    "log_file": log_path.to_string_lossy(),
+                })
+            })
+            .collect::<Vec<_>>();
+
+        let frame = render_frame(
+            &sessions,
+            Some("beta"),
+            5,
+            2,
+            INTERACTIVE_HINT,
+            &RealFileSystem,
+        );
+
+        assert!(frame.contains(INTERACTIVE_HINT));
+        assert!(frame.contains("LOG beta"));
+        assert!(frame.contains("beta log\n"));
+        assert!(!frame.contains("alpha log"));
+    }
+}
@@ -1,6 +1,10 @@
 use super::{CliError, parse_bool_value, sanitize_session_name};
+use crate::backend::{Backend, BackendCapabilities, BackendError, Usage};
 use crate::cli::{self, RunLoopArgs, WorktreeCommand, WorktreeCreateArgs, WorktreeFinishArgs};
 use crate::config::Config;
+use crate::core;
+use crate::gitops;
+use crate::prd;
 use std::ffi::OsStr;
 use std::fs;
 use std::path::{Path, PathBuf};
@@ -65,21 +69,23 @@ fn cmd_worktree_finish(args: WorktreeFinishArgs) -> Result<(), CliError> {
     }
     ensure_git_clean(&repo_root)?;
 
-    let branch = format!("task-{}", args.id);
-    let worktrees_dir = PathBuf::from(&repo_root).join(".worktrees");
+    let worktree_path = finish_task_worktree(&repo_root, &args.id)?;
+    println!(
+        "Finished worktree {} and merged task-{}",
+        worktree_path.display(),
+        args.id
+    );
+    Ok(())
+}
+
+/// Merges `task-<ID>` into the branch checked out at `repo_root` and removes
+/// its worktree. Returns the removed worktree path.
+fn finish_task_worktree(repo_root: &str, id: &str) -> Result<PathBuf, CliError> {
+    let branch = format!("task-{}", id);
+    let worktrees_dir = PathBuf::from(repo_root).join(".worktrees");
     let worktree_path = worktrees_dir.join(&branch);
 
-    if git_status_in_repo(
-        &repo_root,
-        [
-            "show-ref",
-            "--verify",
-            "--quiet",
-            &format!("refs/heads/{}", branch),
-        ],
-    )
-    .is_err()
-    {
+    if !git_branch_exists(repo_root, &branch) {
         return Err(CliError::Message(format!(
             "Branch does not exist: {}",
             branch
@@ -92,9 +98,10 @@ fn cmd_worktree_finish(args: WorktreeFinishArgs) -> Result<(), CliError> {
         )));
     }
 
-    let current_branch = git_output(["rev-parse", "--abbrev-ref", "HEAD"])?
-        .trim()
-        .to_string();
+    let current_branch =
+        git_output_in_dir(Path::new(repo_root), ["rev-parse", "--abbrev-ref", "HEAD"])?
+            .trim()
+            .to_string();
     if current_branch == branch {
         return Err(CliError::Message(format!(
             "Cannot finish while on branch {}",
@@ -102,10 +109,10 @@ fn cmd_worktree_finish(args: WorktreeFinishArgs) -> Result<(), CliError> {
         )));
     }
 
-    git_status_in_repo(&repo_root, ["merge", "--no-ff", &branch])
+    git_status_in_repo(repo_root, ["merge", "--no-ff", &branch])
         .map_err(|err| CliError::Message(format!("Failed to merge branch: {}", err)))?;
     git_status_in_repo(
-        &repo_root,
+        repo_root,
         [
             "worktree",
             "remove",
@@ -114,12 +121,7 @@ fn cmd_worktree_finish(args: WorktreeFinishArgs) -> Result<(), CliError> {
     )
     .map_err(|err| CliError::Message(format!("Failed to remove worktree: {}", err)))?;
 
-    println!(
-        "Finished worktree {} and merged {}",
-        worktree_path.display(),
-        branch
-    );
-    Ok(())
+    Ok(worktree_path)
 }
 
 pub(super) fn validate_task_id(id: &str) -> Result<(), CliError> {
@@ -173,7 +175,7 @@ pub(crate) fn git_output_in_dir(
     }
 }
 
-fn git_status_in_repo(
+pub(super) fn git_status_in_repo(
     repo_root: &str,
     args: impl IntoIterator<Item = impl AsRef<OsStr>>,
 ) -> Result<(), CliError> {
@@ -414,6 +416,184 @@ pub(super) fn maybe_create_auto_worktree_with_timestamp(
     Ok(())
 }
 
+/// Runs each loop iteration in `.worktrees/task-<ID>` for the task the loop
+/// picked, then merges the branch back through the finish logic once the task
+/// is checked off there. Unfinished worktrees are kept for the next iteration.
+pub(crate) struct TaskWorktreeBackend {
+    inner: Box<dyn Backend>,
+    repo_root: String,
+    relative_dir: PathBuf,
+    task_file: String,
+    task_path: PathBuf,
+}
+
+impl TaskWorktreeBackend {
+    pub(crate) fn new(
+        inner: Box<dyn Backend>,
+        project_dir: &Path,
+        task_file: &str,
+    ) -> Result<Self, CliError> {
+        let repo_root = git_output_in_dir(project_dir, ["rev-parse", "--show-toplevel"])
+            .map_err(|err| {
+                CliError::Message(format!("--worktree requires a git repository: {}", err))
+            })?
+            .trim()
+            .to_string();
+        if !git_has_commits(&repo_root) {
+            return Err(CliError::Message(
+                "Repository has no commits; cannot use --worktree.".to_string(),
+            ));
+        }
+        let dirty =
+            gitops::dirty_paths(project_dir).map_err(|err| CliError::Message(err.to_string()))?;
+        if !dirty.is_empty() {
+            return Err(CliError::Message(format!(
+                "Git working tree is dirty ({}). Commit or stash changes before using --worktree.",
+                dirty.join(", ")
+            )));
+        }
+
+        let project_dir = project_dir
+            .canonicalize()
+            .unwrap_or_else(|_| project_dir.to_path_buf());
+        let root = PathBuf::from(&repo_root);
+        let root = root.canonicalize().unwrap_or(root);
+        let relative_dir = project_dir
+            .strip_prefix(&root)
+            .unwrap_or_else(|_| Path::new(""))
+            .to_path_buf();
+        Ok(Self {
+            inner,
+            repo_root,
+            relative_dir,
+            task_file: task_file.to_string(),
+            task_path: project_dir.join(task_file),
+        })
+    }
+
+    fn run_in_task_worktree(
+        &self,
+        backend: &dyn Backend,
+        task_id: &str,
+        prompt: &str,
+        model: Option<&str>,
+        variant: Option<&str>,
+        output_file: &Path,
+    ) -> Result<(), CliError> {
+        validate_task_id(task_id)?;
+        let branch = format!("task-{}", task_id);
+        let worktrees_dir = PathBuf::from(&self.repo_root).join(".worktrees");
+        let worktree_path = worktrees_dir.join(&branch);
+        if !worktree_path.is_dir() {
+            fs::create_dir_all(&worktrees_dir).map_err(CliError::Io)?;
+            create_worktree_at(&self.repo_root, &branch, &worktree_path)?;
+            println!(
+                "Task worktree created: {} (branch {})",
+                worktree_path.display(),
+                branch
+            );
+        }
+
+        let dir = worktree_path.join(&self.relative_dir);
+        backend
+            .run_iteration(prompt, model, variant, output_file, &dir)
+            .map_err(|err| CliError::Message(err.to_string()))?;
+
+        gitops::commit_all(&dir, &format!("chore(gralph): {}", task_id))
+            .map_err(|err| CliError::Message(err.to_string()))?;
+        let done = core::task_states(&dir.join(&self.task_file))
+            .get(task_id)
+            .copied()
+            .unwrap_or(false);
+        if done {
+            let removed = finish_task_worktree(&self.repo_root, task_id)?;
+            println!("Merged {} and removed {}", branch, removed.display());
+        }
+        Ok(())
+    }
+}
+
+impl Backend for TaskWorktreeBackend {
+    fn name(&self) -> &str {
+        self.inner.name()
+    }
+
+    fn capabilities(&self) -> BackendCapabilities {
+        self.inner.capabilities()
+    }
+
+    fn requires_network(&self) -> bool {
+        self.inner.requires_network()
+    }
+
+    fn check_variant(&self, variant: &str) -> Result<(), String> {
+        self.inner.check_variant(variant)
+    }
+
+    fn describe_invocation(&self, model: Option<&str>, variant: Option<&str>) -> String {
+        self.inner.describe_invocation(model, variant)
+    }
+
+    fn check_installed(&self) -> bool {
+        self.inner.check_installed()
+    }
+
+    fn check_auth(&self, model: Option<&str>, working_dir: &Path) -> Result<(), String> {
+        self.inner.check_auth(model, working_dir)
+    }
+
+    fn run_iteration(
+        &self,
+        prompt: &str,
+        model: Option<&str>,
+        variant: Option<&str>,
+        output_file: &Path,
+        working_dir: &Path,
+    ) -> Result<(), BackendError> {
+        self.run_iteration_on(
+            self.inner.as_ref(),
+            prompt,
+            model,
+            variant,
+            output_file,
+            working_dir,
+        )
+    }
+
+    fn run_iteration_on(
+        &self,
+        backend: &dyn Backend,
+        prompt: &str,
+        model: Option<&str>,
+        variant: Option<&str>,
+        output_file: &Path,
+        working_dir: &Path,
+    ) -> Result<(), BackendError> {
+        match prd::prd_next_task_id(&self.task_path) {
+            Some(task_id) => self
+                .run_in_task_worktree(backend, &task_id, prompt, model, variant, output_file)
+                .map_err(|err| BackendError::Command(format!("task {}: {}", task_id, err))),
+            None => backend.run_iteration(prompt, model, variant, output_file, working_dir),
+        }
+    }
+
+    fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+        self.inner.parse_text(response_file)
+    }
+
+    fn parse_usage(&self, response_file: &Path) -> Option<Usage> {
+        self.inner.parse_usage(response_file)
+    }
+
+    // Not resumable: each task runs in its own worktree, and the CLIs keep
+    // conversations per directory.
+    fn resume_session(&self, _id: Option<&str>) {}
+
+    fn get_models(&self) -> Vec<String> {
+        self.inner.get_models()
+    }
+}
+
 fn print_auto_worktree_hint() {
     println!(
         "Hint: use --no-worktree or set defaults.auto_worktree: false to disable auto worktrees."
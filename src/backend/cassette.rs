@@ -0,0 +1,576 @@
+//! Record and replay backend iterations, enabled with `--record <dir>` or
+//! `GRALPH_RECORD_DIR`, and `--replay <dir>` or `GRALPH_REPLAY_DIR`.
+//!
+//! Recording runs the real backend and saves each iteration to the cassette
+//! directory as `NNNN.json`: the prompt, the raw output, and the text files
+//! the backend created, changed, or deleted in the working directory.
+//! Replaying skips the backend and plays the matching iteration back, so a
+//! loop can run end to end in CI without model access. Iterations are
+//! matched by prompt, with the working directory written as
+//! [`WORKING_DIR_PLACEHOLDER`] so cassettes survive a move to another
+//! checkout. Like offline mode, the cassette is resolved once at startup,
+//! carried on the loaded config, and passed to the loops `start` spawns as
+//! `--record` or `--replay`.
+
+use super::{Backend, BackendCapabilities, BackendError, Usage};
+use serde::{Deserialize, Serialize};
+use std::cell::RefCell;
+use std::collections::BTreeMap;
+use std::env;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+pub const RECORD_ENV: &str = "GRALPH_RECORD_DIR";
+pub const REPLAY_ENV: &str = "GRALPH_REPLAY_DIR";
+
+/// Stands in for the working directory in recorded prompts.
+pub const WORKING_DIR_PLACEHOLDER: &str = "{working_dir}";
+
+/// Directories never captured: git metadata and gralph's own state.
+const SKIPPED_DIRS: [&str; 3] = [".git", ".gralph", ".worktrees"];
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum CassetteMode {
+    Record,
+    Replay,
+}
+
+/// One recorded iteration.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct CassetteEntry {
+    pub backend: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub model: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub variant: Option<String>,
+    pub prompt: String,
+    pub output: String,
+    /// Text files written by the iteration, relative to the working directory.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub files: BTreeMap<String, String>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub deleted: Vec<String>,
+    /// The backend error, replayed as a failed iteration.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+/// The cassette a run records to or replays from.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Cassette {
+    pub mode: CassetteMode,
+    pub dir: PathBuf,
+}
+
+impl Cassette {
+    /// The cassette named by `--record` or `--replay`, or else by
+    /// `GRALPH_RECORD_DIR` or `GRALPH_REPLAY_DIR`. Relative directories are
+    /// resolved against the current directory, so spawned loops find them.
+    pub fn from_flags(
+        record: Option<&Path>,
+        replay: Option<&Path>,
+    ) -> Result<Option<Self>, String> {
+        let record = record
+            .map(Path::to_path_buf)
+            .or_else(|| env_dir(RECORD_ENV));
+        let replay = replay
+            .map(Path::to_path_buf)
+            .or_else(|| env_dir(REPLAY_ENV));
+        select_mode(record, replay)
+    }
+
+    /// Wraps `backend` so its iterations are recorded or replayed.
+    pub fn wrap(&self, backend: Box<dyn Backend>) -> Result<Box<dyn Backend>, String> {
+        match self.mode {
+            CassetteMode::Record => {
+                Ok(Box::new(CassetteBackend::record(backend, self.dir.clone())))
+            }
+            CassetteMode::Replay => Ok(Box::new(CassetteBackend::replay(
+                backend,
+                self.dir.clone(),
+            )?)),
+        }
+    }
+
+    /// The global flag that hands this cassette to a spawned gralph.
+    pub fn args(&self) -> [String; 2] {
+        let flag = match self.mode {
+            CassetteMode::Record => "--record",
+            CassetteMode::Replay => "--replay",
+        };
+        [flag.to_string(), self.dir.to_string_lossy().into_owned()]
+    }
+}
+
+fn select_mode(
+    record: Option<PathBuf>,
+    replay: Option<PathBuf>,
+) -> Result<Option<Cassette>, String> {
+    let (mode, dir) = match (record, replay) {
+        (Some(_), Some(_)) => {
+            return Err(format!(
+                "{} and {} cannot both be set (--record and --replay)",
+                RECORD_ENV, REPLAY_ENV
+            ));
+        }
+        (Some(dir), None) => (CassetteMode::Record, dir),
+        (None, Some(dir)) => (CassetteMode::Replay, dir),
+        (None, None) => return Ok(None),
+    };
+    Ok(Some(Cassette {
+        mode,
+        dir: absolute(&dir),
+    }))
+}
+
+fn env_dir(key: &str) -> Option<PathBuf> {
+    env::var_os(key)
+        .filter(|value| !value.is_empty())
+        .map(PathBuf::from)
+}
+
+fn absolute(dir: &Path) -> PathBuf {
+    if dir.is_absolute() {
+        dir.to_path_buf()
+    } else {
+        env::current_dir()
+            .map(|cwd| cwd.join(dir))
+            .unwrap_or_else(|_| dir.to_path_buf())
+    }
+}
+
+pub struct CassetteBackend {
+    inner: Box<dyn Backend>,
+    mode: CassetteMode,
+    dir: PathBuf,
+    /// Replay only: recorded entries, and whether each has been played.
+    entries: Vec<CassetteEntry>,
+    played: RefCell<Vec<bool>>,
+}
+
+impl CassetteBackend {
+    pub fn record(inner: Box<dyn Backend>, dir: PathBuf) -> Self {
+        Self {
+            inner,
+            mode: CassetteMode::Record,
+            dir,
+            entries: Vec::new(),
+            played: RefCell::new(Vec::new()),
+        }
+    }
+
+    /// Loads every entry in `dir`, in file name order.
+    pub fn replay(inner: Box<dyn Backend>, dir: PathBuf) -> Result<Self, String> {
+        let entries = read_entries(&dir)?;
+        Ok(Self {
+            inner,
+            mode: CassetteMode::Replay,
+            dir,
+            played: RefCell::new(vec![false; entries.len()]),
+            entries,
+        })
+    }
+
+    fn record_iteration(
+        &self,
+        prompt: &str,
+        model: Option<&str>,
+        variant: Option<&str>,
+        output_file: &Path,
+        working_dir: &Path,
+    ) -> Result<(), BackendError> {
+        let before = snapshot(working_dir);
+        let result = self
+            .inner
+            .run_iteration(prompt, model, variant, output_file, working_dir);
+        let after = snapshot(working_dir);
+
+        let mut entry = CassetteEntry {
+            backend: self.inner.name().to_string(),
+            model: model.map(str::to_string),
+            variant: variant.map(str::to_string),
+            prompt: normalize_prompt(prompt, working_dir),
+            output: fs::read_to_string(output_file).unwrap_or_default(),
+            error: result.as_ref().err().map(|err| match err {
+                BackendError::Command(message) => message.clone(),
+                other => other.to_string(),
+            }),
+            ..CassetteEntry::default()
+        };
+        for (path, contents) in &after {
+            if before.get(path) != Some(contents) {
+                if let Ok(text) = String::from_utf8(contents.clone()) {
+                    entry.files.insert(path.clone(), text);
+                }
+            }
+        }
+        entry.deleted = before
+            .keys()
+            .filter(|path| !after.contains_key(*path))
+            .cloned()
+            .collect();
+        write_entry(&self.dir, &entry)?;
+        result
+    }
+
+    fn replay_iteration(
+        &self,
+        prompt: &str,
+        output_file: &Path,
+        working_dir: &Path,
+    ) -> Result<(), BackendError> {
+        let prompt = normalize_prompt(prompt, working_dir);
+        let mut played = self.played.borrow_mut();
+        let index = (0..self.entries.len())
+            .find(|index| !played[*index] && self.entries[*index].prompt == prompt)
+            .ok_or_else(|| {
+                BackendError::InvalidInput(format!(
+                    "no recorded iteration in {} matches the prompt (re-record with --record)",
+                    self.dir.display()
+                ))
+            })?;
+        played[index] = true;
+        let entry = &self.entries[index];
+
+        for (path, contents) in &entry.files {
+            let target = working_dir.join(path);
+            if let Some(parent) = target.parent() {
+                fs::create_dir_all(parent).map_err(|source| BackendError::Io {
+                    path: parent.to_path_buf(),
+                    source,
+                })?;
+            }
+            fs::write(&target, contents).map_err(|source| BackendError::Io {
+                path: target.clone(),
+                source,
+            })?;
+        }
+        for path in &entry.deleted {
+            let _ = fs::remove_file(working_dir.join(path));
+        }
+        fs::write(output_file, &entry.output).map_err(|source| BackendError::Io {
+            path: output_file.to_path_buf(),
+            source,
+        })?;
+        match &entry.error {
+            Some(message) => Err(BackendError::Command(message.clone())),
+            None => Ok(()),
+        }
+    }
+}
+
+impl Backend for CassetteBackend {
+    fn name(&self) -> &str {
+        self.inner.name()
+    }
+
+    fn capabilities(&self) -> BackendCapabilities {
+        self.inner.capabilities()
+    }
+
+    fn requires_network(&self) -> bool {
+        self.mode == CassetteMode::Record && self.inner.requires_network()
+    }
+
+    fn check_variant(&self, variant: &str) -> Result<(), String> {
+        self.inner.check_variant(variant)
+    }
+
+    fn describe_invocation(&self, model: Option<&str>, variant: Option<&str>) -> String {
+        match self.mode {
+            CassetteMode::Record => format!(
+                "{} (recording to {})",
+                self.inner.describe_invocation(model, variant),
+                self.dir.display()
+            ),
+            CassetteMode::Replay => format!("replay from {}", self.dir.display()),
+        }
+    }
+
+    fn check_installed(&self) -> bool {
+        self.mode == CassetteMode::Replay || self.inner.check_installed()
+    }
+
+    fn check_auth(&self, model: Option<&str>, working_dir: &Path) -> Result<(), String> {
+        match self.mode {
+            CassetteMode::Record => self.inner.check_auth(model, working_dir),
+            CassetteMode::Replay => Ok(()),
+        }
+    }
+
+    fn run_iteration(
+        &self,
+        prompt: &str,
+        model: Option<&str>,
+        variant: Option<&str>,
+        output_file: &Path,
+        working_dir: &Path,
+    ) -> Result<(), BackendError> {
+        match self.mode {
+            CassetteMode::Record => {
+                self.record_iteration(prompt, model, variant, output_file, working_dir)
+            }
+            CassetteMode::Replay => self.replay_iteration(prompt, output_file, working_dir),
+        }
+    }
+
+    fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+        self.inner.parse_text(response_file)
+    }
+
+    fn parse_usage(&self, response_file: &Path) -> Option<Usage> {
+        self.inner.parse_usage(response_file)
+    }
+
+    fn parse_session_id(&self, response_file: &Path) -> Option<String> {
+        self.inner.parse_session_id(response_file)
+    }
+
+    fn resume_session(&self, id: Option<&str>) {
+        self.inner.resume_session(id)
+    }
+
+    fn get_models(&self) -> Vec<String> {
+        self.inner.get_models()
+    }
+}
+
+fn normalize_prompt(prompt: &str, working_dir: &Path) -> String {
+    let dir = working_dir.display().to_string();
+    if dir.is_empty() {
+        prompt.to_string()
+    } else {
+        prompt.replace(&dir, WORKING_DIR_PLACEHOLDER)
+    }
+}
+
+/// File contents under `dir` keyed by `/`-separated relative path.
+fn snapshot(dir: &Path) -> BTreeMap<String, Vec<u8>> {
+    let mut files = BTreeMap::new();
+    collect_files(dir, "", &mut files);
+    files
+}
+
+fn collect_files(dir: &Path, prefix: &str, files: &mut BTreeMap<String, Vec<u8>>) {
+    let Ok(entries) = fs::read_dir(dir) else {
+        return;
+    };
+    for entry in entries.flatten() {
+        let name = entry.file_name().to_string_lossy().into_owned();
+        let Ok(file_type) = entry.file_type() else {
+            continue;
+        };
+        let relative = if prefix.is_empty() {
+            name.clone()
+        } else {
+            format!("{}/{}", prefix, name)
+        };
+        if file_type.is_dir() {
+            if !SKIPPED_DIRS.contains(&name.as_str()) {
+                collect_files(&entry.path(), &relative, files);
+            }
+        } else if file_type.is_file() {
+            if let Ok(contents) = fs::read(entry.path()) {
+                files.insert(relative, contents);
+            }
+        }
+    }
+}
+
+fn read_entries(dir: &Path) -> Result<Vec<CassetteEntry>, String> {
+    let entries = fs::read_dir(dir)
+        .map_err(|err| format!("Cannot read cassette {}: {}", dir.display(), err))?;
+    let mut paths: Vec<PathBuf> = entries
+        .flatten()
+        .map(|entry| entry.path())
+        .filter(|path| path.extension().and_then(|ext| ext.to_str()) == Some("json"))
+        .collect();
+    paths.sort();
+    paths
+        .iter()
+        .map(|path| {
+            let contents = fs::read_to_string(path)
+                .map_err(|err| format!("Cannot read {}: {}", path.display(), err))?;
+            serde_json::from_str(&contents)
+                .map_err(|err| format!("Invalid cassette entry {}: {}", path.display(), err))
+        })
+        .collect()
+}
+
+/// Saves `entry` as the next `NNNN.json` in `dir`, after any entries left by
+/// earlier processes.
+fn write_entry(dir: &Path, entry: &CassetteEntry) -> Result<(), BackendError> {
+    let io_error = |source| BackendError::Io {
+        path: dir.to_path_buf(),
+        source,
+    };
+    fs::create_dir_all(dir).map_err(io_error)?;
+    let next = fs::read_dir(dir)
+        .map_err(io_error)?
+        .flatten()
+        .filter_map(|entry| {
+            let path = entry.path();
+            if path.extension().and_then(|ext| ext.to_str()) != Some("json") {
+                return None;
+            }
+            path.file_stem()?.to_str()?.parse::<u32>().ok()
+        })
+        .max()
+        .map_or(1, |last| last + 1);
+    let path = dir.join(format!("{:04}.json", next));
+    let contents =
+        serde_json::to_string_pretty(entry).map_err(|source| BackendError::Json { source })?;
+    fs::write(&path, contents).map_err(|source| BackendError::Io { path, source })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Writes a canned output and checks off the task in `PRD.md`.
+    struct FakeBackend;
+
+    impl Backend for FakeBackend {
+        fn name(&self) -> &str {
+            "fake"
+        }
+
+        fn check_installed(&self) -> bool {
+            false
+        }
+
+        fn run_iteration(
+            &self,
+            prompt: &str,
+            _model: Option<&str>,
+            _variant: Option<&str>,
+            output_file: &Path,
+            working_dir: &Path,
+        ) -> Result<(), BackendError> {
+            if prompt.contains("fail") {
+                return Err(BackendError::Command("fake exited with 1".to_string()));
+            }
+            fs::write(working_dir.join("PRD.md"), "- [x] T-1 Done\n").unwrap();
+            fs::remove_file(working_dir.join("scratch.txt")).unwrap();
+            fs::write(output_file, format!("answered: {}", prompt)).unwrap();
+            Ok(())
+        }
+
+        fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+            Ok(fs::read_to_string(response_file).unwrap().to_uppercase())
+        }
+
+        fn get_models(&self) -> Vec<String> {
+            Vec::new()
+        }
+    }
+
+    fn write_workspace(dir: &Path) {
+        fs::write(dir.join("PRD.md"), "- [ ] T-1 Do it\n").unwrap();
+        fs::write(dir.join("scratch.txt"), "tmp\n").unwrap();
+        fs::create_dir_all(dir.join(".git")).unwrap();
+        fs::write(dir.join(".git").join("HEAD"), "ref: refs/heads/main\n").unwrap();
+    }
+
+    #[test]
+    fn record_then_replay_reproduces_output_and_file_changes() {
+        let temp = tempfile::tempdir().unwrap();
+        let cassette = temp.path().join("cassette");
+        let recorded_dir = temp.path().join("recorded");
+        fs::create_dir_all(&recorded_dir).unwrap();
+        write_workspace(&recorded_dir);
+
+        let recorder = CassetteBackend::record(Box::new(FakeBackend), cassette.clone());
+        let prompt = format!("Work in {}", recorded_dir.display());
+        let output = temp.path().join("record.out");
+        recorder
+            .run_iteration(&prompt, Some("m"), None, &output, &recorded_dir)
+            .unwrap();
+
+        let entry: CassetteEntry =
+            serde_json::from_str(&fs::read_to_string(cassette.join("0001.json")).unwrap()).unwrap();
+        assert_eq!(entry.prompt, "Work in {working_dir}");
+        assert_eq!(entry.model.as_deref(), Some("m"));
+        assert_eq!(
+            entry.files.get("PRD.md").map(String::as_str),
+            Some("- [x] T-1 Done\n")
+        );
+        assert_eq!(entry.files.len(), 1);
+        assert_eq!(entry.deleted, vec!["scratch.txt"]);
+
+        let replay_dir = temp.path().join("replayed");
+        fs::create_dir_all(&replay_dir).unwrap();
+        write_workspace(&replay_dir);
+        let player = CassetteBackend::replay(Box::new(FakeBackend), cassette).unwrap();
+        assert!(player.check_installed());
+        assert!(!player.requires_network());
+        let output = temp.path().join("replay.out");
+        let prompt = format!("Work in {}", replay_dir.display());
+        player
+            .run_iteration(&prompt, None, None, &output, &replay_dir)
+            .unwrap();
+
+        assert_eq!(
+            fs::read_to_string(replay_dir.join("PRD.md")).unwrap(),
+            "- [x] T-1 Done\n"
+        );
+        assert!(!replay_dir.join("scratch.txt").exists());
+        assert_eq!(
+            player.parse_text(&output).unwrap(),
+            format!("answered: Work in {}", recorded_dir.display()).to_uppercase()
+        );
+
+        let err = player
+            .run_iteration(&prompt, None, None, &output, &replay_dir)
+            .unwrap_err();
+        assert!(err.to_string().contains("no recorded iteration"));
+    }
+
+    #[test]
+    fn replay_returns_recorded_errors_and_entries_append() {
+        let temp = tempfile::tempdir().unwrap();
+        let cassette = temp.path().join("cassette");
+        let work = temp.path().join("work");
+        fs::create_dir_all(&work).unwrap();
+        write_workspace(&work);
+        let output = temp.path().join("out");
+
+        let recorder = CassetteBackend::record(Box::new(FakeBackend), cassette.clone());
+        recorder
+            .run_iteration("please fail", None, None, &output, &work)
+            .unwrap_err();
+        let recorder = CassetteBackend::record(Box::new(FakeBackend), cassette.clone());
+        recorder
+            .run_iteration("succeed", None, None, &output, &work)
+            .unwrap();
+        assert!(cassette.join("0001.json").is_file());
+        assert!(cassette.join("0002.json").is_file());
+
+        let player = CassetteBackend::replay(Box::new(FakeBackend), cassette).unwrap();
+        let err = player
+            .run_iteration("please fail", None, None, &output, &work)
+            .unwrap_err();
+        assert_eq!(err.to_string(), "backend command error: fake exited with 1");
+    }
+
+    #[test]
+    fn select_mode_rejects_record_and_replay_together() {
+        let record = Some(PathBuf::from("/tmp/record"));
+        let replay = Some(PathBuf::from("/tmp/replay"));
+        let err = select_mode(record.clone(), replay.clone()).unwrap_err();
+        assert!(err.contains("cannot both be set"));
+        let cassette = select_mode(None, replay).unwrap().unwrap();
+        assert_eq!(cassette.mode, CassetteMode::Replay);
+        assert_eq!(cassette.args(), ["--replay", "/tmp/replay"]);
+        let cassette = select_mode(record, None).unwrap().unwrap();
+        assert_eq!(cassette.mode, CassetteMode::Record);
+        assert_eq!(cassette.dir, PathBuf::from("/tmp/record"));
+        assert_eq!(select_mode(None, None).unwrap(), None);
+
+        let cassette = select_mode(Some(PathBuf::from("rec")), None)
+            .unwrap()
+            .unwrap();
+        assert!(cassette.dir.is_absolute());
+        assert!(cassette.dir.ends_with("rec"));
+    }
+}
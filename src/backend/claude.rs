@@ -1,31 +1,93 @@
-use super::{Backend, BackendError, spawn_with_retry, stream_command_output};
+use super::{
+    AUTH_PROBE_PROMPT, AUTH_PROBE_TIMEOUT, Backend, BackendCapabilities, BackendError,
+    PROMPT_PLACEHOLDER, Usage, checked_variant, echo_output, format_command, probe_command,
+    requested_variant, sandbox::Sandbox, spawn_with_retry, stream_command_output,
+};
 use serde_json::Value;
+use std::cell::RefCell;
 use std::fs::{self, File};
 use std::io::{self, BufWriter, Write};
-use std::path::{Path, PathBuf};
+use std::path::Path;
 use std::process::{Command, Stdio};
 
+/// Claude Code reads its extended thinking budget from this variable.
+const THINKING_TOKENS_ENV: &str = "MAX_THINKING_TOKENS";
+const VARIANTS: &[&str] = &["low", "medium", "high"];
+
 #[derive(Debug, Clone)]
 pub struct ClaudeBackend {
     command: String,
+    extra_args: Vec<String>,
+    sandbox: Sandbox,
+    /// Conversation the next iteration continues with `--resume`.
+    resume: RefCell<Option<String>>,
 }
 
 impl ClaudeBackend {
     pub fn new() -> Self {
         Self {
             command: "claude".to_string(),
+            extra_args: Vec::new(),
+            sandbox: Sandbox::None,
+            resume: RefCell::new(None),
         }
     }
 
     pub fn with_command(command: impl Into<String>) -> Self {
         Self {
             command: command.into(),
+            extra_args: Vec::new(),
+            sandbox: Sandbox::None,
+            resume: RefCell::new(None),
         }
     }
 
+    /// Arguments added to every iteration after gralph's own flags.
+    pub fn with_extra_args(mut self, extra_args: Vec<String>) -> Self {
+        self.extra_args = extra_args;
+        self
+    }
+
+    /// Runs every command the backend spawns inside `sandbox`.
+    pub fn with_sandbox(mut self, sandbox: Sandbox) -> Self {
+        self.sandbox = sandbox;
+        self
+    }
+
     pub fn command(&self) -> &str {
         &self.command
     }
+
+    fn iteration_command(
+        &self,
+        prompt: &str,
+        model: Option<&str>,
+        variant: Option<&str>,
+        resume: Option<&str>,
+    ) -> Command {
+        let mut cmd = Command::new(&self.command);
+        cmd.arg("--dangerously-skip-permissions")
+            .arg("--verbose")
+            .arg("--print")
+            .arg("--output-format")
+            .arg("stream-json")
+            .arg("-p")
+            .arg(prompt)
+            .env("IS_SANDBOX", "1");
+        if let Some(resume) = resume {
+            cmd.arg("--resume").arg(resume);
+        }
+        if let Some(model) = model {
+            if !model.trim().is_empty() {
+                cmd.arg("--model").arg(model);
+            }
+        }
+        if let Some(tokens) = variant.and_then(thinking_tokens) {
+            cmd.env(THINKING_TOKENS_ENV, tokens.to_string());
+        }
+        cmd.args(&self.extra_args);
+        cmd
+    }
 }
 
 impl Default for ClaudeBackend {
@@ -35,28 +97,73 @@ impl Default for ClaudeBackend {
 }
 
 impl Backend for ClaudeBackend {
-    fn check_installed(&self) -> bool {
-        let mut cmd = Command::new(&self.command);
-        cmd.arg("--version")
-            .stdout(Stdio::null())
-            .stderr(Stdio::null());
-        match spawn_with_retry(&mut cmd, "claude") {
-            Ok(mut child) => child.wait().map(|status| status.success()).unwrap_or(false),
-            Err(_) => false,
+    fn name(&self) -> &str {
+        "claude"
+    }
+
+    fn capabilities(&self) -> BackendCapabilities {
+        BackendCapabilities {
+            streaming: true,
+            json_output: true,
+            max_context_tokens: Some(200_000),
+            variants: VARIANTS,
+        }
+    }
+
+    /// Also accepts a thinking budget in tokens, such as `16000`.
+    fn check_variant(&self, variant: &str) -> Result<(), String> {
+        match thinking_tokens(variant) {
+            Some(_) => Ok(()),
+            None => Err(format!(
+                "unsupported variant for claude: {} (expected one of: {}, or a token budget)",
+                variant,
+                VARIANTS.join(", ")
+            )),
         }
     }
 
+    fn describe_invocation(&self, model: Option<&str>, variant: Option<&str>) -> String {
+        format_command(&self.iteration_command(
+            PROMPT_PLACEHOLDER,
+            model,
+            requested_variant(variant),
+            None,
+        ))
+    }
+
+    fn check_installed(&self) -> bool {
+        self.sandbox.check_installed(|| {
+            let mut cmd = Command::new(&self.command);
+            cmd.arg("--version")
+                .stdout(Stdio::null())
+                .stderr(Stdio::null());
+            match spawn_with_retry(&mut cmd, "claude") {
+                Ok(mut child) => child.wait().map(|status| status.success()).unwrap_or(false),
+                Err(_) => false,
+            }
+        })
+    }
+
+    fn check_auth(&self, model: Option<&str>, working_dir: &Path) -> Result<(), String> {
+        let mut cmd = self.sandbox.wrap(
+            self.iteration_command(AUTH_PROBE_PROMPT, model, None, None),
+            working_dir,
+        );
+        probe_command(&mut cmd, "claude", AUTH_PROBE_TIMEOUT)
+    }
+
     fn run_iteration(
         &self,
         prompt: &str,
         model: Option<&str>,
-        _variant: Option<&str>,
+        variant: Option<&str>,
         output_file: &Path,
         working_dir: &Path,
     ) -> Result<(), BackendError> {
         if prompt.trim().is_empty() {
             return Err(BackendError::InvalidInput("prompt is required".to_string()));
         }
+        let variant = checked_variant(self, variant)?;
 
         let file = File::create(output_file).map_err(|source| BackendError::Io {
             path: output_file.to_path_buf(),
@@ -64,24 +171,12 @@ impl Backend for ClaudeBackend {
         })?;
         let mut output = BufWriter::new(file);
 
-        let mut cmd = Command::new(&self.command);
-        cmd.current_dir(working_dir);
-        cmd.arg("--dangerously-skip-permissions")
-            .arg("--verbose")
-            .arg("--print")
-            .arg("--output-format")
-            .arg("stream-json")
-            .arg("-p")
-            .arg(prompt)
-            .env("IS_SANDBOX", "1")
-            .stdout(Stdio::piped())
-            .stderr(Stdio::piped());
-
-        if let Some(model) = model {
-            if !model.trim().is_empty() {
-                cmd.arg("--model").arg(model);
-            }
-        }
+        let resume = self.resume.borrow().clone();
+        let mut cmd = self.sandbox.wrap(
+            self.iteration_command(prompt, model, variant, resume.as_deref()),
+            working_dir,
+        );
+        cmd.stdout(Stdio::piped()).stderr(Stdio::piped());
 
         let child = spawn_with_retry(&mut cmd, "claude")?;
 
@@ -102,16 +197,7 @@ impl Backend for ClaudeBackend {
                 for text in extract_assistant_texts(&value) {
                     let mut rendered = text.replace('\n', "\r\n");
                     rendered.push_str("\r\n\n");
-                    stdout_lock
-                        .write_all(rendered.as_bytes())
-                        .map_err(|source| BackendError::Io {
-                            path: PathBuf::from("stdout"),
-                            source,
-                        })?;
-                    stdout_lock.flush().map_err(|source| BackendError::Io {
-                        path: PathBuf::from("stdout"),
-                        source,
-                    })?;
+                    echo_output(&mut stdout_lock, &rendered)?;
                 }
             }
             Ok(())
@@ -143,11 +229,50 @@ impl Backend for ClaudeBackend {
         }
     }
 
+    fn parse_usage(&self, response_file: &Path) -> Option<Usage> {
+        let contents = fs::read_to_string(response_file).ok()?;
+        contents
+            .lines()
+            .filter_map(|line| serde_json::from_str::<Value>(line.trim()).ok())
+            .filter_map(|value| extract_result_usage(&value))
+            .last()
+    }
+
+    /// The `session_id` Claude Code puts on its stream events.
+    fn parse_session_id(&self, response_file: &Path) -> Option<String> {
+        let contents = fs::read_to_string(response_file).ok()?;
+        contents
+            .lines()
+            .filter_map(|line| serde_json::from_str::<Value>(line.trim()).ok())
+            .filter_map(|value| {
+                value
+                    .get("session_id")
+                    .and_then(Value::as_str)
+                    .filter(|id| !id.is_empty())
+                    .map(str::to_string)
+            })
+            .last()
+    }
+
+    fn resume_session(&self, id: Option<&str>) {
+        *self.resume.borrow_mut() = id.map(str::to_string);
+    }
+
     fn get_models(&self) -> Vec<String> {
         vec!["claude-opus-4-5".to_string()]
     }
 }
 
+/// Thinking budget for a variant: a named level or a number of tokens.
+fn thinking_tokens(variant: &str) -> Option<u32> {
+    match variant {
+        "low" => Some(4_000),
+        "medium" => Some(10_000),
+        "high" => Some(31_999),
+        other => other.parse().ok().filter(|tokens| *tokens > 0),
+    }
+}
+
 fn extract_assistant_texts(value: &Value) -> Vec<String> {
     if value.get("type").and_then(|v| v.as_str()) != Some("assistant") {
         return Vec::new();
@@ -177,6 +302,35 @@ fn extract_result_text(value: &Value) -> Option<String> {
         .map(|text| text.to_string())
 }
 
+/// Tokens and cost from the `result` event. Cache reads and writes count as
+/// input tokens.
+fn extract_result_usage(value: &Value) -> Option<Usage> {
+    if value.get("type").and_then(|v| v.as_str()) != Some("result") {
+        return None;
+    }
+    let usage = value.get("usage");
+    let tokens = |key: &str| {
+        usage
+            .and_then(|usage| usage.get(key))
+            .and_then(Value::as_u64)
+            .unwrap_or(0)
+    };
+    let cost_usd = value
+        .get("total_cost_usd")
+        .or_else(|| value.get("cost_usd"))
+        .and_then(Value::as_f64);
+    if usage.is_none() && cost_usd.is_none() {
+        return None;
+    }
+    Some(Usage {
+        input_tokens: tokens("input_tokens")
+            + tokens("cache_creation_input_tokens")
+            + tokens("cache_read_input_tokens"),
+        output_tokens: tokens("output_tokens"),
+        cost_usd,
+    })
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -368,6 +522,22 @@ mod tests {
         assert!(extract_assistant_texts(&null_content).is_empty());
     }
 
+    #[test]
+    fn parse_usage_reads_tokens_and_cost_from_result_event() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("stream.json");
+        let contents = "{\"type\":\"assistant\",\"message\":{\"content\":[]}}\n{\"type\":\"result\",\"result\":\"done\",\"total_cost_usd\":0.42,\"usage\":{\"input_tokens\":100,\"cache_read_input_tokens\":900,\"output_tokens\":50}}\n";
+        fs::write(&path, contents).unwrap();
+
+        let usage = ClaudeBackend::new().parse_usage(&path).unwrap();
+        assert_eq!(usage.input_tokens, 1000);
+        assert_eq!(usage.output_tokens, 50);
+        assert_eq!(usage.cost_usd, Some(0.42));
+
+        fs::write(&path, "{\"type\":\"result\",\"result\":\"done\"}\n").unwrap();
+        assert_eq!(ClaudeBackend::new().parse_usage(&path), None);
+    }
+
     #[test]
     fn extract_result_text_requires_result_type() {
         let result = json!({"type": "result", "result": "done"});
@@ -590,6 +760,20 @@ mod tests {
         assert!(!backend.check_installed());
     }
 
+    #[cfg(unix)]
+    #[test]
+    fn check_auth_probes_in_the_project_dir() {
+        let temp = tempfile::tempdir().unwrap();
+        let project = temp.path().join("project");
+        fs::create_dir(&project).unwrap();
+        let script_path = temp.path().join("claude-probe");
+        write_executable(&script_path, "#!/bin/sh\npwd > probed-here\necho OK\n");
+
+        let backend = ClaudeBackend::with_command(script_path.to_string_lossy().to_string());
+        assert!(backend.check_auth(None, &project).is_ok());
+        assert!(project.join("probed-here").exists());
+    }
+
     #[cfg(unix)]
     #[test]
     fn run_iteration_returns_io_when_output_dir_is_read_only() {
@@ -633,6 +817,34 @@ mod tests {
         assert!(output.contains("\"type\":\"result\""));
     }
 
+    #[cfg(unix)]
+    #[test]
+    fn run_iteration_sets_thinking_budget_from_variant() {
+        let temp = tempfile::tempdir().unwrap();
+        let script_path = temp.path().join("claude-mock");
+        let output_path = temp.path().join("output.json");
+        let script = "#!/bin/sh\nprintf '{\"type\":\"result\",\"result\":\"%s\"}\\n' \"$MAX_THINKING_TOKENS\"\n";
+        write_executable(&script_path, script);
+        let backend = ClaudeBackend::with_command(script_path.to_string_lossy().to_string());
+
+        for (variant, expected) in [("high", "31999"), ("16000", "16000")] {
+            backend
+                .run_iteration("prompt", None, Some(variant), &output_path, temp.path())
+                .expect("run_iteration should succeed");
+            let output = fs::read_to_string(&output_path).unwrap();
+            let value: Value = serde_json::from_str(output.trim()).unwrap();
+            assert_eq!(value["result"], expected);
+        }
+
+        let err = backend
+            .run_iteration("prompt", None, Some("ultra"), &output_path, temp.path())
+            .unwrap_err();
+        assert!(
+            err.to_string()
+                .contains("unsupported variant for claude: ultra")
+        );
+    }
+
     #[cfg(unix)]
     #[test]
     fn run_iteration_includes_model_flag_when_set() {
@@ -662,6 +874,46 @@ printf '"}\n'
         assert!(result.contains("--model|model-x|"));
     }
 
+    #[cfg(unix)]
+    #[test]
+    fn run_iteration_resumes_the_previous_session() {
+        let temp = tempfile::tempdir().unwrap();
+        let script_path = temp.path().join("claude-mock");
+        let output_path = temp.path().join("output.json");
+        let script = r#"#!/bin/sh
+printf '{"type":"system","session_id":"old"}\n{"type":"result","session_id":"abc-123","result":"'
+for arg in "$@"; do
+  printf '%s|' "$arg"
+done
+printf '"}\n'
+"#;
+        write_executable(&script_path, script);
+
+        let backend = ClaudeBackend::with_command(script_path.to_string_lossy().to_string());
+        backend
+            .run_iteration("prompt", None, None, &output_path, temp.path())
+            .expect("run_iteration should succeed");
+        assert!(
+            !backend
+                .parse_text(&output_path)
+                .unwrap()
+                .contains("--resume")
+        );
+
+        let id = backend.parse_session_id(&output_path).unwrap();
+        assert_eq!(id, "abc-123");
+        backend.resume_session(Some(&id));
+        backend
+            .run_iteration("prompt", None, None, &output_path, temp.path())
+            .expect("run_iteration should succeed");
+        assert!(
+            backend
+                .parse_text(&output_path)
+                .unwrap()
+                .contains("--resume|abc-123|")
+        );
+    }
+
     #[cfg(unix)]
     #[test]
     fn run_iteration_orders_args_with_model() {
@@ -1,30 +1,84 @@
-use super::{Backend, BackendError, command_in_path, spawn_with_retry, stream_command_output};
+use super::{
+    AUTH_PROBE_PROMPT, AUTH_PROBE_TIMEOUT, Backend, BackendCapabilities, BackendError,
+    PROMPT_PLACEHOLDER, Usage, checked_variant, command_in_path, echo_output, format_command,
+    probe_command, requested_variant, sandbox::Sandbox, spawn_with_retry, stream_command_output,
+};
+use serde_json::Value;
+use std::cell::RefCell;
 use std::fs::{self, File};
 use std::io::{self, BufWriter, Write};
-use std::path::{Path, PathBuf};
+use std::path::Path;
 use std::process::{Command, Stdio};
 
 #[derive(Debug, Clone)]
 pub struct CodexBackend {
     command: String,
+    extra_args: Vec<String>,
+    sandbox: Sandbox,
+    /// Session the next iteration continues with `resume <id>`.
+    resume: RefCell<Option<String>>,
 }
 
 impl CodexBackend {
     pub fn new() -> Self {
         Self {
             command: "codex".to_string(),
+            extra_args: Vec::new(),
+            sandbox: Sandbox::None,
+            resume: RefCell::new(None),
         }
     }
 
     pub fn with_command(command: impl Into<String>) -> Self {
         Self {
             command: command.into(),
+            extra_args: Vec::new(),
+            sandbox: Sandbox::None,
+            resume: RefCell::new(None),
         }
     }
 
+    /// Arguments added to every iteration after gralph's own flags.
+    pub fn with_extra_args(mut self, extra_args: Vec<String>) -> Self {
+        self.extra_args = extra_args;
+        self
+    }
+
+    /// Runs every command the backend spawns inside `sandbox`.
+    pub fn with_sandbox(mut self, sandbox: Sandbox) -> Self {
+        self.sandbox = sandbox;
+        self
+    }
+
     pub fn command(&self) -> &str {
         &self.command
     }
+
+    fn iteration_command(
+        &self,
+        prompt: &str,
+        model: Option<&str>,
+        effort: Option<&str>,
+        resume: Option<&str>,
+    ) -> Command {
+        let mut cmd = Command::new(&self.command);
+        cmd.arg("--quiet").arg("--auto-approve").arg("--json");
+        if let Some(model) = model {
+            if !model.trim().is_empty() {
+                cmd.arg("--model").arg(model);
+            }
+        }
+        if let Some(effort) = effort {
+            cmd.arg("-c")
+                .arg(format!("model_reasoning_effort={}", effort));
+        }
+        cmd.args(&self.extra_args);
+        if let Some(resume) = resume {
+            cmd.arg("resume").arg(resume);
+        }
+        cmd.arg(prompt);
+        cmd
+    }
 }
 
 impl Default for CodexBackend {
@@ -34,21 +88,53 @@ impl Default for CodexBackend {
 }
 
 impl Backend for CodexBackend {
+    fn name(&self) -> &str {
+        "codex"
+    }
+
+    fn capabilities(&self) -> BackendCapabilities {
+        BackendCapabilities {
+            streaming: true,
+            json_output: true,
+            max_context_tokens: None,
+            variants: &["minimal", "low", "medium", "high"],
+        }
+    }
+
+    fn describe_invocation(&self, model: Option<&str>, variant: Option<&str>) -> String {
+        format_command(&self.iteration_command(
+            PROMPT_PLACEHOLDER,
+            model,
+            requested_variant(variant),
+            None,
+        ))
+    }
+
     fn check_installed(&self) -> bool {
-        command_in_path(&self.command)
+        self.sandbox
+            .check_installed(|| command_in_path(&self.command))
+    }
+
+    fn check_auth(&self, model: Option<&str>, working_dir: &Path) -> Result<(), String> {
+        let mut cmd = self.sandbox.wrap(
+            self.iteration_command(AUTH_PROBE_PROMPT, model, None, None),
+            working_dir,
+        );
+        probe_command(&mut cmd, "codex", AUTH_PROBE_TIMEOUT)
     }
 
     fn run_iteration(
         &self,
         prompt: &str,
         model: Option<&str>,
-        _variant: Option<&str>,
+        variant: Option<&str>,
         output_file: &Path,
         working_dir: &Path,
     ) -> Result<(), BackendError> {
         if prompt.trim().is_empty() {
             return Err(BackendError::InvalidInput("prompt is required".to_string()));
         }
+        let variant = checked_variant(self, variant)?;
 
         let file = File::create(output_file).map_err(|source| BackendError::Io {
             path: output_file.to_path_buf(),
@@ -56,17 +142,12 @@ impl Backend for CodexBackend {
         })?;
         let mut output = BufWriter::new(file);
 
-        let mut cmd = Command::new(&self.command);
-        cmd.current_dir(working_dir);
-        cmd.arg("--quiet").arg("--auto-approve");
-        if let Some(model) = model {
-            if !model.trim().is_empty() {
-                cmd.arg("--model").arg(model);
-            }
-        }
-        cmd.arg(prompt)
-            .stdout(Stdio::piped())
-            .stderr(Stdio::piped());
+        let resume = self.resume.borrow().clone();
+        let mut cmd = self.sandbox.wrap(
+            self.iteration_command(prompt, model, variant, resume.as_deref()),
+            working_dir,
+        );
+        cmd.stdout(Stdio::piped()).stderr(Stdio::piped());
 
         let child = spawn_with_retry(&mut cmd, "codex")?;
 
@@ -80,25 +161,83 @@ impl Backend for CodexBackend {
                     path: output_file.to_path_buf(),
                     source,
                 })?;
-            stdout_lock
-                .write_all(line.as_bytes())
-                .map_err(|source| BackendError::Io {
-                    path: PathBuf::from("stdout"),
-                    source,
-                })?;
-            stdout_lock.flush().map_err(|source| BackendError::Io {
-                path: PathBuf::from("stdout"),
-                source,
-            })?;
-            Ok(())
+            // JSON events are echoed as their assistant text; anything else
+            // (warnings, older CLIs without --json) passes through unchanged.
+            let rendered = match serde_json::from_str::<Value>(line.trim()) {
+                Ok(value) => match extract_agent_message(&value) {
+                    Some(text) => format!("{}\n", text),
+                    None => return Ok(()),
+                },
+                Err(_) => line.to_string(),
+            };
+            echo_output(&mut stdout_lock, &rendered)
         })
     }
 
     fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
-        fs::read_to_string(response_file).map_err(|source| BackendError::Io {
+        let contents = fs::read_to_string(response_file).map_err(|source| BackendError::Io {
             path: response_file.to_path_buf(),
             source,
-        })
+        })?;
+        let mut result = None;
+        for line in contents.lines() {
+            let trimmed = line.trim();
+            if !trimmed.starts_with('{') {
+                continue;
+            }
+            let Ok(value) = serde_json::from_str::<Value>(trimmed) else {
+                continue;
+            };
+            if let Some(text) = extract_agent_message(&value) {
+                result = Some(text);
+            }
+        }
+        Ok(result.unwrap_or(contents))
+    }
+
+    /// Sums the `usage` of each `turn.completed` event. Codex reports no cost.
+    fn parse_usage(&self, response_file: &Path) -> Option<Usage> {
+        let contents = fs::read_to_string(response_file).ok()?;
+        let mut total: Option<Usage> = None;
+        for line in contents.lines() {
+            let Ok(value) = serde_json::from_str::<Value>(line.trim()) else {
+                continue;
+            };
+            if value.get("type").and_then(|v| v.as_str()) != Some("turn.completed") {
+                continue;
+            }
+            let Some(usage) = value.get("usage") else {
+                continue;
+            };
+            let tokens = |key: &str| usage.get(key).and_then(Value::as_u64).unwrap_or(0);
+            total.get_or_insert_with(Usage::default).add(&Usage {
+                input_tokens: tokens("input_tokens"),
+                output_tokens: tokens("output_tokens"),
+                cost_usd: None,
+            });
+        }
+        total
+    }
+
+    /// The `thread_id` of the `thread.started` event, or a `session_id`
+    /// from older Codex releases.
+    fn parse_session_id(&self, response_file: &Path) -> Option<String> {
+        let contents = fs::read_to_string(response_file).ok()?;
+        contents
+            .lines()
+            .filter_map(|line| serde_json::from_str::<Value>(line.trim()).ok())
+            .find_map(|value| {
+                value
+                    .get("thread_id")
+                    .or_else(|| value.get("session_id"))
+                    .and_then(Value::as_str)
+                    .filter(|id| !id.is_empty())
+                    .map(str::to_string)
+            })
+    }
+
+    fn resume_session(&self, id: Option<&str>) {
+        *self.resume.borrow_mut() = id.map(str::to_string);
     }
 
     fn get_models(&self) -> Vec<String> {
@@ -106,6 +245,47 @@ impl Backend for CodexBackend {
     }
 }
 
+/// Returns the assistant text carried by a codex `--json` event, accepting
+/// the response-item (`message`/`output_text`), `item.completed`, and
+/// `msg.agent_message` shapes emitted by different CLI versions.
+fn extract_agent_message(value: &Value) -> Option<String> {
+    if value.get("type").and_then(|v| v.as_str()) == Some("message")
+        && value.get("role").and_then(|v| v.as_str()) == Some("assistant")
+    {
+        let content = value.get("content")?.as_array()?;
+        let text = content
+            .iter()
+            .filter(|item| {
+                matches!(
+                    item.get("type").and_then(|v| v.as_str()),
+                    Some("output_text" | "text")
+                )
+            })
+            .filter_map(|item| item.get("text").and_then(|v| v.as_str()))
+            .collect::<Vec<_>>()
+            .join("");
+        return (!text.is_empty()).then_some(text);
+    }
+    if value.get("type").and_then(|v| v.as_str()) == Some("item.completed") {
+        let item = value.get("item")?;
+        if item.get("type").and_then(|v| v.as_str()) == Some("agent_message") {
+            return item
+                .get("text")
+                .and_then(|v| v.as_str())
+                .map(str::to_string);
+        }
+        return None;
+    }
+    let msg = value.get("msg")?;
+    if msg.get("type").and_then(|v| v.as_str()) == Some("agent_message") {
+        return msg
+            .get("message")
+            .and_then(|v| v.as_str())
+            .map(str::to_string);
+    }
+    None
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -167,6 +347,43 @@ mod tests {
         assert!(result.is_empty());
     }
 
+    #[test]
+    fn parse_usage_sums_completed_turns() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("codex.jsonl");
+        fs::write(
+            &path,
+            "{\"type\":\"turn.completed\",\"usage\":{\"input_tokens\":120,\"cached_input_tokens\":80,\"output_tokens\":30}}\nnot json\n{\"type\":\"turn.completed\",\"usage\":{\"input_tokens\":10,\"output_tokens\":5}}\n",
+        )
+        .unwrap();
+
+        let usage = CodexBackend::new().parse_usage(&path).unwrap();
+        assert_eq!(usage.total_tokens(), 165);
+        assert_eq!(usage.cost_usd, None);
+
+        fs::write(&path, "hello codex\n").unwrap();
+        assert_eq!(CodexBackend::new().parse_usage(&path), None);
+    }
+
+    #[test]
+    fn parse_session_id_reads_the_thread_started_event() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("codex.jsonl");
+        fs::write(
+            &path,
+            "not json\n{\"type\":\"thread.started\",\"thread_id\":\"thread-1\"}\n{\"type\":\"turn.completed\"}\n",
+        )
+        .unwrap();
+
+        assert_eq!(
+            CodexBackend::new().parse_session_id(&path),
+            Some("thread-1".to_string())
+        );
+
+        fs::write(&path, "hello codex\n").unwrap();
+        assert_eq!(CodexBackend::new().parse_session_id(&path), None);
+    }
+
     #[test]
     fn parse_text_returns_raw_contents() {
         let temp = tempfile::tempdir().unwrap();
@@ -178,6 +395,53 @@ mod tests {
         assert_eq!(result, "hello codex\n");
     }
 
+    #[test]
+    fn parse_text_extracts_last_agent_message_from_json_events() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("codex.jsonl");
+        fs::write(
+            &path,
+            concat!(
+                "warning: experimental\n",
+                "{\"type\":\"message\",\"role\":\"assistant\",\"content\":[{\"type\":\"output_text\",\"text\":\"Working\"}]}\n",
+                "{\"type\":\"function_call\",\"name\":\"shell\"}\n",
+                "{\"type\":\"message\",\"role\":\"assistant\",\"content\":[{\"type\":\"output_text\",\"text\":\"Done <promise>COMPLETE</promise>\"}]}\n",
+            ),
+        )
+        .unwrap();
+
+        let backend = CodexBackend::new();
+        assert_eq!(
+            backend.parse_text(&path).unwrap(),
+            "Done <promise>COMPLETE</promise>"
+        );
+    }
+
+    #[test]
+    fn extract_agent_message_handles_event_shapes() {
+        let item = serde_json::json!({
+            "type": "item.completed",
+            "item": {"type": "agent_message", "text": "from item"}
+        });
+        assert_eq!(extract_agent_message(&item).as_deref(), Some("from item"));
+
+        let msg =
+            serde_json::json!({"id": "0", "msg": {"type": "agent_message", "message": "from msg"}});
+        assert_eq!(extract_agent_message(&msg).as_deref(), Some("from msg"));
+
+        let reasoning = serde_json::json!({
+            "type": "item.completed",
+            "item": {"type": "reasoning", "text": "thinking"}
+        });
+        assert_eq!(extract_agent_message(&reasoning), None);
+        let user = serde_json::json!({
+            "type": "message",
+            "role": "user",
+            "content": [{"type": "input_text", "text": "prompt"}]
+        });
+        assert_eq!(extract_agent_message(&user), None);
+    }
+
     #[test]
     fn parse_text_returns_io_error_for_invalid_utf8() {
         let temp = tempfile::tempdir().unwrap();
@@ -358,7 +622,14 @@ mod tests {
         let args: Vec<&str> = output.lines().collect();
         assert_eq!(
             args,
-            vec!["--quiet", "--auto-approve", "--model", "model-x", "prompt"]
+            vec![
+                "--quiet",
+                "--auto-approve",
+                "--json",
+                "--model",
+                "model-x",
+                "prompt"
+            ]
         );
     }
 
@@ -392,6 +663,7 @@ mod tests {
             vec![
                 "--quiet",
                 "--auto-approve",
+                "--json",
                 "--model",
                 "model-z",
                 "prompt with spaces",
@@ -429,6 +701,75 @@ mod tests {
         assert_eq!(args.last().copied(), Some("final-prompt"));
     }
 
+    #[cfg(unix)]
+    #[test]
+    fn run_iteration_resumes_the_session_before_the_prompt() {
+        let temp = tempfile::tempdir().unwrap();
+        let script_path = temp.path().join("codex-mock");
+        let output_path = temp.path().join("output.txt");
+        let script = "#!/bin/sh\nprintf '%s\\n' \"$@\"\n";
+        fs::write(&script_path, script).unwrap();
+        let mut perms = fs::metadata(&script_path).unwrap().permissions();
+        perms.set_mode(0o755);
+        fs::set_permissions(&script_path, perms).unwrap();
+
+        let backend = CodexBackend::with_command(script_path.to_string_lossy().to_string());
+        backend.resume_session(Some("thread-1"));
+        backend
+            .run_iteration("prompt", None, None, &output_path, temp.path())
+            .expect("run_iteration should succeed");
+
+        let output = fs::read_to_string(&output_path).unwrap();
+        let args: Vec<&str> = output.lines().collect();
+        assert_eq!(&args[args.len() - 3..], ["resume", "thread-1", "prompt"]);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn run_iteration_maps_variant_to_reasoning_effort() {
+        let temp = tempfile::tempdir().unwrap();
+        let script_path = temp.path().join("codex-mock");
+        let output_path = temp.path().join("output.txt");
+        let script = "#!/bin/sh\nprintf '%s\\n' \"$@\"\n";
+        fs::write(&script_path, script).unwrap();
+        let mut perms = fs::metadata(&script_path).unwrap().permissions();
+        perms.set_mode(0o755);
+        fs::set_permissions(&script_path, perms).unwrap();
+
+        let backend = CodexBackend::with_command(script_path.to_string_lossy().to_string());
+        backend
+            .run_iteration(
+                "final-prompt",
+                None,
+                Some(" high "),
+                &output_path,
+                temp.path(),
+            )
+            .expect("run_iteration should succeed");
+
+        let output = fs::read_to_string(&output_path).unwrap();
+        let args: Vec<&str> = output.lines().collect();
+        assert_eq!(
+            args,
+            vec![
+                "--quiet",
+                "--auto-approve",
+                "--json",
+                "-c",
+                "model_reasoning_effort=high",
+                "final-prompt"
+            ]
+        );
+
+        let err = backend
+            .run_iteration("final-prompt", None, Some("max"), &output_path, temp.path())
+            .unwrap_err();
+        assert!(
+            err.to_string()
+                .contains("expected one of: minimal, low, medium, high")
+        );
+    }
+
     #[cfg(unix)]
     #[test]
     fn run_iteration_includes_quiet_auto_approve_without_model() {
@@ -448,7 +789,7 @@ mod tests {
 
         let output = fs::read_to_string(&output_path).unwrap();
         let args: Vec<&str> = output.lines().collect();
-        assert_eq!(args, vec!["--quiet", "--auto-approve", "prompt"]);
+        assert_eq!(args, vec!["--quiet", "--auto-approve", "--json", "prompt"]);
     }
 
     #[cfg(unix)]
@@ -472,7 +813,7 @@ mod tests {
         let args: Vec<&str> = output.lines().collect();
         assert_eq!(
             args,
-            vec!["--quiet", "--auto-approve", "prompt with spaces"]
+            vec!["--quiet", "--auto-approve", "--json", "prompt with spaces"]
         );
         assert!(!args.iter().any(|arg| *arg == "--model"));
     }
@@ -495,7 +836,7 @@ mod tests {
             .expect("run_iteration should succeed");
 
         let output = fs::read_to_string(&output_path).unwrap();
-        assert!(output.contains("args:--quiet|--auto-approve|prompt|"));
+        assert!(output.contains("args:--quiet|--auto-approve|--json|prompt|"));
         assert!(!output.contains("--model"));
     }
 
@@ -518,7 +859,7 @@ mod tests {
 
         let output = fs::read_to_string(&output_path).unwrap();
         let args: Vec<&str> = output.lines().collect();
-        assert_eq!(args, vec!["--quiet", "--auto-approve", "prompt"]);
+        assert_eq!(args, vec!["--quiet", "--auto-approve", "--json", "prompt"]);
     }
 
     #[cfg(unix)]
@@ -540,6 +881,6 @@ mod tests {
 
         let output = fs::read_to_string(&output_path).unwrap();
         let args: Vec<&str> = output.lines().collect();
-        assert_eq!(args, vec!["--quiet", "--auto-approve", "prompt"]);
+        assert_eq!(args, vec!["--quiet", "--auto-approve", "--json", "prompt"]);
     }
 }
@@ -0,0 +1,462 @@
+//! Fall back to the next backend in a chain, enabled with
+//! `--backend claude,opencode` or `defaults.backend_fallbacks`.
+//!
+//! Iterations go to the current backend. A failed backend command (failed
+//! auth, a server error) is retried after a short backoff, and once it has
+//! failed `max_failures` times in a row, or is not installed, the iteration
+//! moves to the next backend in the chain, which then stays current for the
+//! rest of the run. Rate limits are left to the loop's own backoff; a
+//! shutdown request and errors a retry cannot fix, such as bad input or a
+//! local file error, are returned as they are.
+
+use super::{Backend, BackendCapabilities, BackendError, Usage};
+use crate::core;
+use crate::shutdown;
+use std::cell::Cell;
+use std::fs;
+use std::path::Path;
+use std::thread;
+use std::time::Duration;
+
+/// `defaults.backend_fallback_after` when unset.
+pub const DEFAULT_MAX_FAILURES: u32 = 3;
+
+/// Wait before the first retry of a failed backend; it doubles on each
+/// further retry, up to [`MAX_RETRY_BACKOFF`].
+const RETRY_BACKOFF: Duration = Duration::from_secs(2);
+const MAX_RETRY_BACKOFF: Duration = Duration::from_secs(30);
+
+/// One backend in the chain, with the model it runs when it is not the
+/// primary.
+pub struct FallbackEntry {
+    pub backend: Box<dyn Backend>,
+    pub model: Option<String>,
+}
+
+pub struct FallbackBackend {
+    entries: Vec<FallbackEntry>,
+    max_failures: u32,
+    current: Cell<usize>,
+    failures: Cell<u32>,
+    on_switch: Box<dyn Fn(&str)>,
+    sleep: Box<dyn Fn(Duration)>,
+}
+
+impl FallbackBackend {
+    /// `entries[0]` is the primary and runs with the model the loop passes
+    /// in; later entries run with their own model.
+    pub fn new(entries: Vec<FallbackEntry>, max_failures: u32) -> Self {
+        Self {
+            entries,
+            max_failures: max_failures.max(1),
+            current: Cell::new(0),
+            failures: Cell::new(0),
+            on_switch: Box::new(|_| {}),
+            sleep: Box::new(thread::sleep),
+        }
+    }
+
+    /// Replaces how the backoff between retries waits; tests use it to
+    /// record the waits instead of sleeping.
+    pub fn with_sleep(mut self, sleep: impl Fn(Duration) + 'static) -> Self {
+        self.sleep = Box::new(sleep);
+        self
+    }
+
+    /// Called with a one-line message each time the chain moves on.
+    pub fn on_switch(mut self, callback: impl Fn(&str) + 'static) -> Self {
+        self.on_switch = Box::new(callback);
+        self
+    }
+
+    fn current(&self) -> &dyn Backend {
+        self.entries[self.current.get()].backend.as_ref()
+    }
+
+    /// Moves to the next backend, or returns false at the end of the chain.
+    fn advance(&self, reason: &str) -> bool {
+        let from = self.current.get();
+        if from + 1 >= self.entries.len() {
+            return false;
+        }
+        self.current.set(from + 1);
+        self.failures.set(0);
+        (self.on_switch)(&format!(
+            "Switching backend: {} -> {} ({})",
+            self.entries[from].backend.name(),
+            self.entries[from + 1].backend.name(),
+            reason
+        ));
+        true
+    }
+}
+
+/// Wait before retry `attempt` (1-based) of the same backend.
+fn retry_backoff(attempt: u32) -> Duration {
+    let factor = 1u32 << attempt.saturating_sub(1).min(16);
+    RETRY_BACKOFF.saturating_mul(factor).min(MAX_RETRY_BACKOFF)
+}
+
+/// Whether a retry may fix `err`: only a backend command that failed.
+/// Bad input and local file errors fail the same way every time.
+fn is_retryable(err: &BackendError) -> bool {
+    matches!(err, BackendError::Command(_))
+}
+
+impl Backend for FallbackBackend {
+    fn name(&self) -> &str {
+        self.current().name()
+    }
+
+    fn capabilities(&self) -> BackendCapabilities {
+        self.current().capabilities()
+    }
+
+    fn requires_network(&self) -> bool {
+        self.entries
+            .iter()
+            .any(|entry| entry.backend.requires_network())
+    }
+
+    fn check_variant(&self, variant: &str) -> Result<(), String> {
+        self.entries[0].backend.check_variant(variant)
+    }
+
+    fn describe_invocation(&self, model: Option<&str>, variant: Option<&str>) -> String {
+        self.entries[0].backend.describe_invocation(model, variant)
+    }
+
+    /// True while any backend left in the chain is installed.
+    fn check_installed(&self) -> bool {
+        self.entries[self.current.get()..]
+            .iter()
+            .any(|entry| entry.backend.check_installed())
+    }
+
+    fn check_auth(&self, model: Option<&str>, working_dir: &Path) -> Result<(), String> {
+        self.current().check_auth(model, working_dir)
+    }
+
+    fn run_iteration(
+        &self,
+        prompt: &str,
+        model: Option<&str>,
+        variant: Option<&str>,
+        output_file: &Path,
+        working_dir: &Path,
+    ) -> Result<(), BackendError> {
+        loop {
+            let index = self.current.get();
+            let entry = &self.entries[index];
+            if !entry.backend.check_installed() {
+                if self.advance("not installed") {
+                    continue;
+                }
+                return Err(BackendError::Command(format!(
+                    "backend is not installed: {}",
+                    entry.backend.name()
+                )));
+            }
+            // Fallbacks run with their own model, and only with the variant
+            // when they can map it.
+            let (model, variant) = if index == 0 {
+                (model, variant)
+            } else {
+                (
+                    entry.model.as_deref(),
+                    variant.filter(|variant| entry.backend.check_variant(variant).is_ok()),
+                )
+            };
+            let result =
+                entry
+                    .backend
+                    .run_iteration(prompt, model, variant, output_file, working_dir);
+            let Err(err) = result else {
+                self.failures.set(0);
+                return Ok(());
+            };
+            let output = fs::read_to_string(output_file).unwrap_or_default();
+            if shutdown::requested().is_some()
+                || !is_retryable(&err)
+                || core::rate_limit_reason(&err.to_string(), &output).is_some()
+            {
+                return Err(err);
+            }
+            let failures = self.failures.get() + 1;
+            self.failures.set(failures);
+            if failures < self.max_failures {
+                (self.sleep)(retry_backoff(failures));
+                if shutdown::requested().is_some() {
+                    return Err(err);
+                }
+                continue;
+            }
+            let reason = format!("{} consecutive failures, last: {}", failures, err);
+            if !self.advance(&reason) {
+                return Err(err);
+            }
+        }
+    }
+
+    fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+        self.current().parse_text(response_file)
+    }
+
+    fn parse_usage(&self, response_file: &Path) -> Option<Usage> {
+        self.current().parse_usage(response_file)
+    }
+
+    fn parse_session_id(&self, response_file: &Path) -> Option<String> {
+        self.current().parse_session_id(response_file)
+    }
+
+    fn resume_session(&self, id: Option<&str>) {
+        self.current().resume_session(id)
+    }
+
+    fn get_models(&self) -> Vec<String> {
+        self.current().get_models()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::cell::RefCell;
+    use std::rc::Rc;
+
+    /// Fails its first `failures` iterations, then writes its name and model.
+    struct FlakyBackend {
+        name: &'static str,
+        installed: bool,
+        failures: Cell<u32>,
+        error: &'static str,
+    }
+
+    impl FlakyBackend {
+        fn boxed(name: &'static str, failures: u32) -> Box<dyn Backend> {
+            Box::new(Self {
+                name,
+                installed: true,
+                failures: Cell::new(failures),
+                error: "exited with 1: 500 Internal Server Error",
+            })
+        }
+    }
+
+    impl Backend for FlakyBackend {
+        fn name(&self) -> &str {
+            self.name
+        }
+
+        fn check_installed(&self) -> bool {
+            self.installed
+        }
+
+        fn run_iteration(
+            &self,
+            _prompt: &str,
+            model: Option<&str>,
+            _variant: Option<&str>,
+            output_file: &Path,
+            _working_dir: &Path,
+        ) -> Result<(), BackendError> {
+            if self.failures.get() > 0 {
+                self.failures.set(self.failures.get() - 1);
+                fs::write(output_file, "").unwrap();
+                return Err(BackendError::Command(format!(
+                    "{} {}",
+                    self.name, self.error
+                )));
+            }
+            fs::write(
+                output_file,
+                format!("{}:{}", self.name, model.unwrap_or("-")),
+            )
+            .unwrap();
+            Ok(())
+        }
+
+        fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+            Ok(fs::read_to_string(response_file).unwrap())
+        }
+
+        fn get_models(&self) -> Vec<String> {
+            Vec::new()
+        }
+    }
+
+    fn entry(backend: Box<dyn Backend>, model: Option<&str>) -> FallbackEntry {
+        FallbackEntry {
+            backend,
+            model: model.map(str::to_string),
+        }
+    }
+
+    /// Records the backoff waits instead of sleeping.
+    fn no_sleep(backend: FallbackBackend) -> (FallbackBackend, Rc<RefCell<Vec<Duration>>>) {
+        let waits = Rc::new(RefCell::new(Vec::new()));
+        let seen = Rc::clone(&waits);
+        let backend = backend.with_sleep(move |wait| seen.borrow_mut().push(wait));
+        (backend, waits)
+    }
+
+    fn run(backend: &FallbackBackend, dir: &Path) -> Result<String, BackendError> {
+        let output = dir.join("out.txt");
+        backend.run_iteration("prompt", Some("primary-model"), None, &output, dir)?;
+        backend.parse_text(&output)
+    }
+
+    #[test]
+    fn switches_after_consecutive_failures_and_stays_switched() {
+        let temp = tempfile::tempdir().unwrap();
+        let switches = Rc::new(RefCell::new(Vec::new()));
+        let seen = Rc::clone(&switches);
+        let (backend, waits) = no_sleep(
+            FallbackBackend::new(
+                vec![
+                    entry(FlakyBackend::boxed("claude", 5), None),
+                    entry(FlakyBackend::boxed("opencode", 0), Some("opencode/model")),
+                ],
+                2,
+            )
+            .on_switch(move |message| seen.borrow_mut().push(message.to_string())),
+        );
+
+        assert_eq!(
+            run(&backend, temp.path()).unwrap(),
+            "opencode:opencode/model"
+        );
+        assert_eq!(backend.name(), "opencode");
+        assert_eq!(*waits.borrow(), vec![RETRY_BACKOFF]);
+        assert_eq!(switches.borrow().len(), 1);
+        assert!(switches.borrow()[0].starts_with(
+            "Switching backend: claude -> opencode (2 consecutive failures, last: backend command error: claude exited with 1"
+        ));
+        assert_eq!(
+            run(&backend, temp.path()).unwrap(),
+            "opencode:opencode/model"
+        );
+        assert_eq!(switches.borrow().len(), 1);
+    }
+
+    #[test]
+    fn retries_the_primary_below_the_threshold_with_a_growing_backoff() {
+        let temp = tempfile::tempdir().unwrap();
+        let (backend, waits) = no_sleep(FallbackBackend::new(
+            vec![
+                entry(FlakyBackend::boxed("claude", 2), None),
+                entry(FlakyBackend::boxed("opencode", 0), None),
+            ],
+            3,
+        ));
+
+        assert_eq!(run(&backend, temp.path()).unwrap(), "claude:primary-model");
+        assert_eq!(backend.name(), "claude");
+        assert_eq!(
+            *waits.borrow(),
+            vec![Duration::from_secs(2), Duration::from_secs(4)]
+        );
+    }
+
+    #[test]
+    fn retry_backoff_doubles_up_to_the_cap() {
+        assert_eq!(retry_backoff(1), RETRY_BACKOFF);
+        assert_eq!(retry_backoff(3), Duration::from_secs(8));
+        assert_eq!(retry_backoff(40), MAX_RETRY_BACKOFF);
+    }
+
+    /// Fails every iteration with an error a retry cannot fix.
+    struct BadInputBackend;
+
+    impl Backend for BadInputBackend {
+        fn name(&self) -> &str {
+            "claude"
+        }
+
+        fn check_installed(&self) -> bool {
+            true
+        }
+
+        fn run_iteration(
+            &self,
+            _prompt: &str,
+            _model: Option<&str>,
+            _variant: Option<&str>,
+            _output_file: &Path,
+            _working_dir: &Path,
+        ) -> Result<(), BackendError> {
+            Err(BackendError::InvalidInput("prompt is required".to_string()))
+        }
+
+        fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+            Ok(fs::read_to_string(response_file).unwrap())
+        }
+
+        fn get_models(&self) -> Vec<String> {
+            Vec::new()
+        }
+    }
+
+    #[test]
+    fn returns_errors_a_retry_cannot_fix_without_counting_them() {
+        let temp = tempfile::tempdir().unwrap();
+        let (backend, waits) = no_sleep(FallbackBackend::new(
+            vec![
+                entry(Box::new(BadInputBackend), None),
+                entry(FlakyBackend::boxed("codex", 0), None),
+            ],
+            1,
+        ));
+
+        let err = run(&backend, temp.path()).unwrap_err();
+        assert!(matches!(err, BackendError::InvalidInput(_)));
+        assert_eq!(backend.name(), "claude");
+        assert_eq!(backend.failures.get(), 0);
+        assert!(waits.borrow().is_empty());
+    }
+
+    #[test]
+    fn skips_uninstalled_backends_and_fails_at_the_end_of_the_chain() {
+        let temp = tempfile::tempdir().unwrap();
+        let missing = Box::new(FlakyBackend {
+            name: "gemini",
+            installed: false,
+            failures: Cell::new(0),
+            error: "",
+        });
+        let (backend, _) = no_sleep(FallbackBackend::new(
+            vec![
+                entry(missing, None),
+                entry(FlakyBackend::boxed("codex", 9), None),
+            ],
+            2,
+        ));
+
+        assert!(backend.check_installed());
+        let err = run(&backend, temp.path()).unwrap_err();
+        assert_eq!(backend.name(), "codex");
+        assert!(err.to_string().contains("codex exited with 1"));
+    }
+
+    #[test]
+    fn leaves_rate_limits_to_the_loop() {
+        let temp = tempfile::tempdir().unwrap();
+        let limited = Box::new(FlakyBackend {
+            name: "claude",
+            installed: true,
+            failures: Cell::new(1),
+            error: "exited with 1: 429 Too Many Requests",
+        });
+        let backend = FallbackBackend::new(
+            vec![
+                entry(limited, None),
+                entry(FlakyBackend::boxed("codex", 0), None),
+            ],
+            1,
+        );
+
+        assert!(run(&backend, temp.path()).is_err());
+        assert_eq!(backend.name(), "claude");
+    }
+}
@@ -1,30 +1,65 @@
-use super::{Backend, BackendError, command_in_path, spawn_with_retry, stream_command_output};
+use super::{
+    AUTH_PROBE_PROMPT, AUTH_PROBE_TIMEOUT, Backend, BackendCapabilities, BackendError,
+    PROMPT_PLACEHOLDER, checked_variant, command_in_path, echo_output, format_command,
+    probe_command, sandbox::Sandbox, spawn_with_retry, stream_command_output,
+};
 use std::fs::{self, File};
 use std::io::{self, BufWriter, Write};
-use std::path::{Path, PathBuf};
+use std::path::Path;
 use std::process::{Command, Stdio};
 
 #[derive(Debug, Clone)]
 pub struct GeminiBackend {
     command: String,
+    extra_args: Vec<String>,
+    sandbox: Sandbox,
 }
 
 impl GeminiBackend {
     pub fn new() -> Self {
         Self {
             command: "gemini".to_string(),
+            extra_args: Vec::new(),
+            sandbox: Sandbox::None,
         }
     }
 
     pub fn with_command(command: impl Into<String>) -> Self {
         Self {
             command: command.into(),
+            extra_args: Vec::new(),
+            sandbox: Sandbox::None,
         }
     }
 
+    /// Arguments added to every iteration after gralph's own flags.
+    pub fn with_extra_args(mut self, extra_args: Vec<String>) -> Self {
+        self.extra_args = extra_args;
+        self
+    }
+
+    /// Runs every command the backend spawns inside `sandbox`.
+    pub fn with_sandbox(mut self, sandbox: Sandbox) -> Self {
+        self.sandbox = sandbox;
+        self
+    }
+
     pub fn command(&self) -> &str {
         &self.command
     }
+
+    fn iteration_command(&self, prompt: &str, model: Option<&str>) -> Command {
+        let mut cmd = Command::new(&self.command);
+        cmd.arg("--headless");
+        if let Some(model) = model {
+            if !model.trim().is_empty() {
+                cmd.arg("--model").arg(model);
+            }
+        }
+        cmd.args(&self.extra_args);
+        cmd.arg(prompt);
+        cmd
+    }
 }
 
 impl Default for GeminiBackend {
@@ -34,21 +69,49 @@ impl Default for GeminiBackend {
 }
 
 impl Backend for GeminiBackend {
+    fn name(&self) -> &str {
+        "gemini"
+    }
+
+    fn capabilities(&self) -> BackendCapabilities {
+        BackendCapabilities {
+            streaming: true,
+            json_output: false,
+            max_context_tokens: Some(1_000_000),
+            variants: &[],
+        }
+    }
+
+    fn describe_invocation(&self, model: Option<&str>, _variant: Option<&str>) -> String {
+        format_command(&self.iteration_command(PROMPT_PLACEHOLDER, model))
+    }
+
     fn check_installed(&self) -> bool {
-        command_in_path(&self.command)
+        self.sandbox
+            .check_installed(|| command_in_path(&self.command))
+    }
+
+    fn check_auth(&self, model: Option<&str>, working_dir: &Path) -> Result<(), String> {
+        let mut cmd = self.sandbox.wrap(
+            self.iteration_command(AUTH_PROBE_PROMPT, model),
+            working_dir,
+        );
+        probe_command(&mut cmd, "gemini", AUTH_PROBE_TIMEOUT)
     }
 
     fn run_iteration(
         &self,
         prompt: &str,
         model: Option<&str>,
-        _variant: Option<&str>,
+        variant: Option<&str>,
         output_file: &Path,
         working_dir: &Path,
     ) -> Result<(), BackendError> {
         if prompt.trim().is_empty() {
             return Err(BackendError::InvalidInput("prompt is required".to_string()));
         }
+        // Gemini CLI has no thinking flag; this only rejects a set variant.
+        checked_variant(self, variant)?;
 
         let file = File::create(output_file).map_err(|source| BackendError::Io {
             path: output_file.to_path_buf(),
@@ -56,17 +119,10 @@ impl Backend for GeminiBackend {
         })?;
         let mut output = BufWriter::new(file);
 
-        let mut cmd = Command::new(&self.command);
-        cmd.current_dir(working_dir);
-        cmd.arg("--headless");
-        if let Some(model) = model {
-            if !model.trim().is_empty() {
-                cmd.arg("--model").arg(model);
-            }
-        }
-        cmd.arg(prompt)
-            .stdout(Stdio::piped())
-            .stderr(Stdio::piped());
+        let mut cmd = self
+            .sandbox
+            .wrap(self.iteration_command(prompt, model), working_dir);
+        cmd.stdout(Stdio::piped()).stderr(Stdio::piped());
 
         let child = spawn_with_retry(&mut cmd, "gemini")?;
 
@@ -80,17 +136,7 @@ impl Backend for GeminiBackend {
                     path: output_file.to_path_buf(),
                     source,
                 })?;
-            stdout_lock
-                .write_all(line.as_bytes())
-                .map_err(|source| BackendError::Io {
-                    path: PathBuf::from("stdout"),
-                    source,
-                })?;
-            stdout_lock.flush().map_err(|source| BackendError::Io {
-                path: PathBuf::from("stdout"),
-                source,
-            })?;
-            Ok(())
+            echo_output(&mut stdout_lock, &line)
         })
     }
 
@@ -381,6 +427,30 @@ mod tests {
         assert!(output.contains("args:--headless|--model|model-x|prompt|"));
     }
 
+    #[cfg(unix)]
+    #[test]
+    fn run_iteration_in_env_sandbox_drops_unlisted_variables() {
+        let temp = tempfile::tempdir().unwrap();
+        let script_path = temp.path().join("gemini-mock");
+        let output_path = temp.path().join("output.txt");
+        let script =
+            "#!/bin/sh\nprintf 'secret:%s dir:%s\\n' \"$GRALPH_SANDBOX_SECRET\" \"$PWD\"\n";
+        write_executable(&script_path, script);
+
+        let _guard = crate::test_support::env_lock();
+        unsafe { env::set_var("GRALPH_SANDBOX_SECRET", "leaked") };
+        let backend = GeminiBackend::with_command(script_path.to_string_lossy().to_string())
+            .with_sandbox(Sandbox::Env {
+                allow: vec!["PATH".to_string()],
+            });
+        let result = backend.run_iteration("prompt", None, None, &output_path, temp.path());
+        unsafe { env::remove_var("GRALPH_SANDBOX_SECRET") };
+        result.expect("run_iteration should succeed");
+
+        let output = fs::read_to_string(&output_path).unwrap();
+        assert!(output.starts_with("secret: dir:"), "{}", output);
+    }
+
     #[cfg(unix)]
     #[test]
     fn run_iteration_keeps_prompt_last_and_headless_first() {
@@ -409,6 +479,29 @@ mod tests {
         );
     }
 
+    #[cfg(unix)]
+    #[test]
+    fn run_iteration_puts_extra_args_before_prompt() {
+        let temp = tempfile::tempdir().unwrap();
+        let script_path = temp.path().join("gemini-mock");
+        let output_path = temp.path().join("output.txt");
+        let script = "#!/bin/sh\nprintf '%s\\n' \"$@\"\n";
+        write_executable(&script_path, script);
+
+        let backend = GeminiBackend::with_command(script_path.to_string_lossy().to_string())
+            .with_extra_args(vec!["--sandbox".to_string(), "--yolo".to_string()]);
+        backend
+            .run_iteration("final-prompt", None, None, &output_path, temp.path())
+            .expect("run_iteration should succeed");
+
+        let output = fs::read_to_string(&output_path).unwrap();
+        let args: Vec<&str> = output.lines().collect();
+        assert_eq!(
+            args,
+            vec!["--headless", "--sandbox", "--yolo", "final-prompt"]
+        );
+    }
+
     #[cfg(unix)]
     #[test]
     fn run_iteration_omits_model_flag_when_none() {
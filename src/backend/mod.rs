@@ -1,25 +1,138 @@
+use crate::config::Config;
+use crate::offline;
+use crate::shutdown;
+use std::cell::{Cell, RefCell};
 use std::env;
 use std::error::Error;
 use std::fmt;
-use std::io::{BufRead, BufReader, Read};
+use std::fs;
+use std::io::{BufRead, BufReader, Read, Write};
 use std::path::{Path, PathBuf};
-use std::process::{Child, Command};
-use std::sync::mpsc;
+use std::process::{Child, Command, Stdio};
+use std::rc::Rc;
+use std::sync::mpsc::{self, RecvTimeoutError};
 use std::thread;
-use std::time::Duration;
+use std::time::{Duration, Instant, SystemTime, UNIX_EPOCH};
 
+pub mod cassette;
 pub mod claude;
 pub mod codex;
+pub mod fallback;
 pub mod gemini;
+pub mod ollama;
+pub mod openai;
 pub mod opencode;
+pub mod sandbox;
 
 use self::claude::ClaudeBackend;
 use self::codex::CodexBackend;
 use self::gemini::GeminiBackend;
+use self::ollama::OllamaBackend;
+use self::openai::OpenAiBackend;
 use self::opencode::OpenCodeBackend;
+use self::sandbox::Sandbox;
+
+const MODELS_CACHE_TTL: Duration = Duration::from_secs(60 * 60);
+const CANCEL_POLL_INTERVAL: Duration = Duration::from_millis(100);
+const TERMINATE_GRACE: Duration = Duration::from_secs(5);
+
+/// Stands in for the prompt in [`Backend::describe_invocation`].
+pub const PROMPT_PLACEHOLDER: &str = "<prompt>";
+
+/// Prompt CLI backends answer in [`Backend::check_auth`].
+pub(crate) const AUTH_PROBE_PROMPT: &str = "Reply with the single word OK.";
+/// How long a CLI backend gets to answer [`AUTH_PROBE_PROMPT`].
+pub(crate) const AUTH_PROBE_TIMEOUT: Duration = Duration::from_secs(120);
+
+/// What a backend can do, as reported by `gralph backends`.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct BackendCapabilities {
+    /// Output is shown while the iteration runs rather than at the end.
+    pub streaming: bool,
+    /// The raw output file holds structured JSON events.
+    pub json_output: bool,
+    /// Context window in tokens, when it does not depend on the model.
+    pub max_context_tokens: Option<u32>,
+    /// Named values accepted by `--variant`, mapped to the backend's
+    /// reasoning or thinking setting.
+    pub variants: &'static [&'static str],
+}
+
+/// Tokens and spend reported by a backend, for one iteration or summed
+/// over a loop.
+#[derive(Debug, Clone, Copy, Default, PartialEq)]
+pub struct Usage {
+    pub input_tokens: u64,
+    pub output_tokens: u64,
+    /// None when the backend reports tokens but not what they cost.
+    pub cost_usd: Option<f64>,
+}
+
+impl Usage {
+    pub fn total_tokens(&self) -> u64 {
+        self.input_tokens + self.output_tokens
+    }
+
+    pub fn add(&mut self, other: &Usage) {
+        self.input_tokens += other.input_tokens;
+        self.output_tokens += other.output_tokens;
+        self.cost_usd = match (self.cost_usd, other.cost_usd) {
+            (Some(total), Some(cost)) => Some(total + cost),
+            (total, cost) => total.or(cost),
+        };
+    }
+}
 
 pub trait Backend {
+    /// Registry name, as accepted by [`backend_from_name`].
+    fn name(&self) -> &str;
+    /// Defaults to no known capabilities.
+    fn capabilities(&self) -> BackendCapabilities {
+        BackendCapabilities::default()
+    }
+    /// Whether iterations reach a remote service. CLI backends call their
+    /// vendor's API, so this defaults to true.
+    fn requires_network(&self) -> bool {
+        true
+    }
+    /// Rejects a `--variant` value the backend cannot map. The default
+    /// accepts the names listed in [`BackendCapabilities::variants`].
+    fn check_variant(&self, variant: &str) -> Result<(), String> {
+        let variants = self.capabilities().variants;
+        if variants.contains(&variant) {
+            Ok(())
+        } else if variants.is_empty() {
+            Err(format!(
+                "backend {} does not support --variant",
+                self.name()
+            ))
+        } else {
+            Err(format!(
+                "unsupported variant for {}: {} (expected one of: {})",
+                self.name(),
+                variant,
+                variants.join(", ")
+            ))
+        }
+    }
+    /// How an iteration would call the backend, for `--dry-run`: the command
+    /// line for CLI backends, the request for API backends. The prompt is
+    /// shown as [`PROMPT_PLACEHOLDER`].
+    fn describe_invocation(&self, _model: Option<&str>, _variant: Option<&str>) -> String {
+        format!("{} (no invocation details)", self.name())
+    }
     fn check_installed(&self) -> bool;
+    /// Confirms the backend can authenticate with `model`, so a missing
+    /// login or API key fails before the first iteration rather than minutes
+    /// into the run. A probe command runs in `working_dir`, the project
+    /// directory, like [`Backend::run_iteration`]. Defaults to Ok for backends
+    /// with nothing to check.
+    fn check_auth(&self, _model: Option<&str>, _working_dir: &Path) -> Result<(), String> {
+        Ok(())
+    }
+    /// Runs the backend command with `working_dir` as its current directory.
+    /// The process's own directory is never changed, so loops for different
+    /// projects can run side by side in one process.
     fn run_iteration(
         &self,
         prompt: &str,
@@ -28,20 +141,159 @@ pub trait Backend {
         output_file: &Path,
         working_dir: &Path,
     ) -> Result<(), BackendError>;
+    /// Runs one iteration on `backend` in place of this one, for a task
+    /// with its own `- **Backend**` field. Wrappers that change where an
+    /// iteration runs do the same for `backend`; the default runs it as is.
+    fn run_iteration_on(
+        &self,
+        backend: &dyn Backend,
+        prompt: &str,
+        model: Option<&str>,
+        variant: Option<&str>,
+        output_file: &Path,
+        working_dir: &Path,
+    ) -> Result<(), BackendError> {
+        backend.run_iteration(prompt, model, variant, output_file, working_dir)
+    }
     fn parse_text(&self, response_file: &Path) -> Result<String, BackendError>;
+    /// Usage reported in the raw output of an iteration. Defaults to None
+    /// for backends that report nothing.
+    fn parse_usage(&self, _response_file: &Path) -> Option<Usage> {
+        None
+    }
+    /// The conversation or session ID in the raw output of an iteration, for
+    /// [`Backend::resume_session`]. Defaults to None for backends that cannot
+    /// resume a conversation.
+    fn parse_session_id(&self, _response_file: &Path) -> Option<String> {
+        None
+    }
+    /// Continue conversation `id` on the following iterations instead of
+    /// starting a new one each time; None goes back to fresh conversations.
+    /// Ignored by backends that cannot resume.
+    fn resume_session(&self, _id: Option<&str>) {}
     fn get_models(&self) -> Vec<String>;
 }
 
+/// Names accepted by [`backend_from_name`], in `gralph backends` order.
+pub const BACKEND_NAMES: [&str; 6] = ["claude", "opencode", "gemini", "codex", "ollama", "openai"];
+
 pub fn backend_from_name(name: &str) -> Result<Box<dyn Backend>, String> {
     match name {
         "claude" => Ok(Box::new(ClaudeBackend::new())),
         "opencode" => Ok(Box::new(OpenCodeBackend::new())),
         "gemini" => Ok(Box::new(GeminiBackend::new())),
         "codex" => Ok(Box::new(CodexBackend::new())),
+        "ollama" => Ok(Box::new(OllamaBackend::new())),
+        "openai" => Ok(Box::new(OpenAiBackend::new())),
         other => Err(format!("Unknown backend: {}", other)),
     }
 }
 
+/// Fails fast when `offline` is set and `backend` needs the network.
+pub fn ensure_network_allowed<B: Backend + ?Sized>(
+    backend: &B,
+    offline: bool,
+) -> Result<(), String> {
+    if offline && backend.requires_network() {
+        return Err(offline::refusal(&format!("backend {}", backend.name())));
+    }
+    Ok(())
+}
+
+/// `cmd` as a shell line, with its environment overrides in front.
+fn format_command(cmd: &Command) -> String {
+    let quote = |value: &std::ffi::OsStr| shell_words::quote(&value.to_string_lossy()).into_owned();
+    let mut parts: Vec<String> = cmd
+        .get_envs()
+        .filter_map(|(key, value)| {
+            value.map(|value| format!("{}={}", key.to_string_lossy(), quote(value)))
+        })
+        .collect();
+    parts.push(quote(cmd.get_program()));
+    parts.extend(cmd.get_args().map(quote));
+    parts.join(" ")
+}
+
+/// Fails fast when `variant` is set and `backend` cannot use it.
+pub fn ensure_variant_supported<B: Backend + ?Sized>(
+    backend: &B,
+    variant: Option<&str>,
+) -> Result<(), String> {
+    match requested_variant(variant) {
+        Some(variant) => backend.check_variant(variant),
+        None => Ok(()),
+    }
+}
+
+/// The variant an iteration should apply: trimmed, and None when blank
+/// (sessions store an empty variant when none was given).
+pub(crate) fn requested_variant(variant: Option<&str>) -> Option<&str> {
+    variant.map(str::trim).filter(|variant| !variant.is_empty())
+}
+
+/// [`requested_variant`], checked against `backend` for use in
+/// `run_iteration`.
+fn checked_variant<'a, B: Backend + ?Sized>(
+    backend: &B,
+    variant: Option<&'a str>,
+) -> Result<Option<&'a str>, BackendError> {
+    let variant = requested_variant(variant);
+    if let Some(variant) = variant {
+        backend
+            .check_variant(variant)
+            .map_err(BackendError::InvalidInput)?;
+    }
+    Ok(variant)
+}
+
+/// Like [`backend_from_name`], but lets API backends read their settings
+/// from the loaded config. CLI backends get `backends.<name>.extra_args`
+/// followed by `extra_args` (from `--backend-arg`) on every invocation.
+/// With a cassette on the config, the backend records or replays through it.
+pub fn backend_from_config(
+    name: &str,
+    config: &Config,
+    extra_args: &[String],
+) -> Result<Box<dyn Backend>, String> {
+    let mut args = config
+        .get_list(&format!("backends.{}.extra_args", name))
+        .unwrap_or_default();
+    args.extend(extra_args.iter().cloned());
+    let sandbox = Sandbox::from_config(config)?;
+    let backend = match name {
+        "claude" => Ok(Box::new(
+            ClaudeBackend::new()
+                .with_extra_args(args)
+                .with_sandbox(sandbox),
+        )),
+        "opencode" => Ok(Box::new(
+            OpenCodeBackend::new()
+                .with_extra_args(args)
+                .with_sandbox(sandbox),
+        )),
+        "gemini" => Ok(Box::new(
+            GeminiBackend::new()
+                .with_extra_args(args)
+                .with_sandbox(sandbox),
+        )),
+        "codex" => Ok(Box::new(
+            CodexBackend::new()
+                .with_extra_args(args)
+                .with_sandbox(sandbox),
+        )),
+        "ollama" | "openai" if !args.is_empty() => Err(format!(
+            "Backend {} talks to an HTTP API and takes no extra CLI args (--backend-arg or backends.{}.extra_args)",
+            name, name
+        )),
+        "openai" => Ok(Box::new(OpenAiBackend::from_config(config))),
+        other => backend_from_name(other),
+    }?;
+    match config.cassette() {
+        Some(cassette) => cassette.wrap(backend),
+        None => Ok(backend),
+    }
+}
+
 #[derive(Debug)]
 pub enum BackendError {
     Io {
@@ -80,6 +332,77 @@ impl Error for BackendError {
     }
 }
 
+/// Returns the models reported by `fetch`, cached on disk for an hour under
+/// `key`. A failed or empty fetch falls back to the last cached list.
+pub(crate) fn cached_models<F>(key: &str, fetch: F) -> Vec<String>
+where
+    F: FnOnce() -> Result<Vec<String>, BackendError>,
+{
+    let path = models_cache_path(key);
+    let cached = path.as_deref().and_then(read_models_cache);
+    if let Some((fetched_at, models)) = &cached {
+        let fresh = SystemTime::now()
+            .duration_since(*fetched_at)
+            .map(|age| age < MODELS_CACHE_TTL)
+            .unwrap_or(false);
+        if fresh && !models.is_empty() {
+            return models.clone();
+        }
+    }
+    match fetch() {
+        Ok(models) if !models.is_empty() => {
+            if let Some(path) = path.as_deref() {
+                write_models_cache(path, &models);
+            }
+            models
+        }
+        _ => cached.map(|(_, models)| models).unwrap_or_default(),
+    }
+}
+
+fn models_cache_path(key: &str) -> Option<PathBuf> {
+    let dir = match env::var_os("GRALPH_MODELS_CACHE_DIR") {
+        Some(dir) if !dir.is_empty() => PathBuf::from(dir),
+        _ => dirs::cache_dir()?.join("gralph").join("models"),
+    };
+    let file_name: String = key
+        .chars()
+        .map(|ch| {
+            if ch.is_ascii_alphanumeric() || matches!(ch, '-' | '_' | '.') {
+                ch
+            } else {
+                '_'
+            }
+        })
+        .collect();
+    Some(dir.join(format!("{}.json", file_name)))
+}
+
+fn read_models_cache(path: &Path) -> Option<(SystemTime, Vec<String>)> {
+    let contents = fs::read_to_string(path).ok()?;
+    let value: serde_json::Value = serde_json::from_str(&contents).ok()?;
+    let fetched_at = UNIX_EPOCH + Duration::from_secs(value.get("fetched_at")?.as_u64()?);
+    let models = value
+        .get("models")?
+        .as_array()?
+        .iter()
+        .filter_map(|model| model.as_str().map(str::to_string))
+        .collect();
+    Some((fetched_at, models))
+}
+
+fn write_models_cache(path: &Path, models: &[String]) {
+    let fetched_at = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default()
+        .as_secs();
+    let payload = serde_json::json!({ "fetched_at": fetched_at, "models": models });
+    if let Some(parent) = path.parent() {
+        let _ = fs::create_dir_all(parent);
+    }
+    let _ = fs::write(path, payload.to_string());
+}
+
 pub(crate) fn command_in_path(command: &str) -> bool {
     let command_path = Path::new(command);
     if command_path.is_absolute() {
@@ -96,6 +419,120 @@ pub(crate) fn command_in_path(command: &str) -> bool {
     })
 }
 
+thread_local! {
+    static LIVE_OUTPUT: RefCell<Option<Rc<dyn Fn(&str)>>> = const { RefCell::new(None) };
+    static HEARTBEAT: RefCell<Option<Heartbeat>> = const { RefCell::new(None) };
+    static ECHO_STDOUT: Cell<bool> = const { Cell::new(true) };
+}
+
+struct Heartbeat {
+    interval: Duration,
+    last: Option<Instant>,
+    beat: Rc<dyn Fn()>,
+}
+
+/// Runs `f` with `beat` called about every `interval` while a backend
+/// command is running, whether or not it prints anything, so a loop that
+/// is waiting on a slow backend can be told apart from one that is hung.
+pub fn with_heartbeat<T>(
+    interval: Duration,
+    beat: impl Fn() + 'static,
+    f: impl FnOnce() -> T,
+) -> T {
+    struct Restore(Option<Heartbeat>);
+
+    impl Drop for Restore {
+        fn drop(&mut self) {
+            let previous = self.0.take();
+            HEARTBEAT.with(|heartbeat| *heartbeat.borrow_mut() = previous);
+        }
+    }
+
+    let heartbeat = Heartbeat {
+        interval,
+        last: None,
+        beat: Rc::new(beat),
+    };
+    let _restore = Restore(HEARTBEAT.with(|current| current.replace(Some(heartbeat))));
+    f()
+}
+
+/// Calls the [`with_heartbeat`] callback when it is due.
+fn heartbeat() {
+    let beat = HEARTBEAT.with(|heartbeat| {
+        let mut heartbeat = heartbeat.borrow_mut();
+        let heartbeat = heartbeat.as_mut()?;
+        if heartbeat
+            .last
+            .is_some_and(|last| last.elapsed() < heartbeat.interval)
+        {
+            return None;
+        }
+        heartbeat.last = Some(Instant::now());
+        Some(Rc::clone(&heartbeat.beat))
+    });
+    if let Some(beat) = beat {
+        beat();
+    }
+}
+
+/// Runs `f` with `sink` getting each line the backend prints while it runs,
+/// so the loop can copy live output into the session log.
+pub fn with_live_output<T>(sink: impl Fn(&str) + 'static, f: impl FnOnce() -> T) -> T {
+    struct Restore(Option<Rc<dyn Fn(&str)>>);
+
+    impl Drop for Restore {
+        fn drop(&mut self) {
+            let previous = self.0.take();
+            LIVE_OUTPUT.with(|live| *live.borrow_mut() = previous);
+        }
+    }
+
+    let sink: Rc<dyn Fn(&str)> = Rc::new(sink);
+    let _restore = Restore(LIVE_OUTPUT.with(|live| live.replace(Some(sink))));
+    f()
+}
+
+/// Runs `f` with backend output going only to the [`with_live_output`]
+/// sink, for callers that display it themselves.
+pub fn without_stdout_echo<T>(f: impl FnOnce() -> T) -> T {
+    struct Restore(bool);
+
+    impl Drop for Restore {
+        fn drop(&mut self) {
+            ECHO_STDOUT.with(|echo| echo.set(self.0));
+        }
+    }
+
+    let _restore = Restore(ECHO_STDOUT.with(|echo| echo.replace(false)));
+    f()
+}
+
+/// Prints backend output as it arrives, unless inside
+/// [`without_stdout_echo`], and passes each non-blank line to the
+/// [`with_live_output`] sink, if any.
+pub(crate) fn echo_output(stdout: &mut impl Write, text: &str) -> Result<(), BackendError> {
+    if ECHO_STDOUT.with(Cell::get) {
+        stdout
+            .write_all(text.as_bytes())
+            .and_then(|_| stdout.flush())
+            .map_err(|source| BackendError::Io {
+                path: PathBuf::from("stdout"),
+                source,
+            })?;
+    }
+    let sink = LIVE_OUTPUT.with(|live| live.borrow().clone());
+    if let Some(sink) = sink {
+        for line in text.lines() {
+            let line = line.trim_end_matches('\r');
+            if !line.trim().is_empty() {
+                sink(line);
+            }
+        }
+    }
+    Ok(())
+}
+
 pub(crate) fn stream_command_output<F>(
     mut child: Child,
     backend_label: &str,
@@ -117,14 +554,39 @@ where
     let stdout_handle = spawn_reader(stdout, tx.clone());
     let stderr_handle = spawn_reader(stderr, tx);
 
-    for line in rx {
-        on_line(line)?;
+    let mut interrupted: Option<Instant> = None;
+    loop {
+        heartbeat();
+        match rx.recv_timeout(CANCEL_POLL_INTERVAL) {
+            Ok(line) => on_line(line)?,
+            Err(RecvTimeoutError::Disconnected) => break,
+            Err(RecvTimeoutError::Timeout) => match interrupted {
+                // Grandchildren can keep the pipes open after the backend exits.
+                Some(at) if at.elapsed() >= TERMINATE_GRACE => break,
+                Some(_) => {}
+                None if shutdown::requested().is_some() => {
+                    terminate_child(&mut child);
+                    interrupted = Some(Instant::now());
+                }
+                None => {}
+            },
+        }
     }
 
     let status = child.wait().map_err(|err| {
         BackendError::Command(format!("failed to wait for {}: {}", backend_label, err))
     })?;
 
+    if interrupted.is_some() {
+        return Err(BackendError::Command(format!(
+            "{} interrupted by {}",
+            backend_label,
+            shutdown::requested()
+                .map(shutdown::signal_name)
+                .unwrap_or_else(|| "shutdown".to_string())
+        )));
+    }
+
     let _ = stdout_handle.join();
     let _ = stderr_handle.join();
 
@@ -138,6 +600,22 @@ where
     Ok(())
 }
 
+/// Ask the backend to exit with SIGTERM, then kill it after a grace period.
+fn terminate_child(child: &mut Child) {
+    #[cfg(unix)]
+    {
+        let _ = unsafe { libc::kill(child.id() as i32, libc::SIGTERM) };
+        let deadline = Instant::now() + TERMINATE_GRACE;
+        while Instant::now() < deadline {
+            if !matches!(child.try_wait(), Ok(None)) {
+                return;
+            }
+            thread::sleep(CANCEL_POLL_INTERVAL);
+        }
+    }
+    let _ = child.kill();
+}
+
 pub(crate) fn spawn_with_retry(
     cmd: &mut Command,
     backend_label: &str,
@@ -173,6 +651,65 @@ fn is_executable_busy(err: &std::io::Error) -> bool {
     }
 }
 
+/// Runs a short health check such as [`AUTH_PROBE_PROMPT`]. Fails with the
+/// last line of output when the command exits non-zero, and kills it once
+/// `timeout` has passed.
+pub(crate) fn probe_command(
+    cmd: &mut Command,
+    backend_label: &str,
+    timeout: Duration,
+) -> Result<(), String> {
+    cmd.stdin(Stdio::null())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped());
+    let mut child = spawn_with_retry(cmd, backend_label).map_err(|err| err.to_string())?;
+    let (tx, rx) = mpsc::channel();
+    if let Some(stdout) = child.stdout.take() {
+        spawn_reader(stdout, tx.clone());
+    }
+    if let Some(stderr) = child.stderr.take() {
+        spawn_reader(stderr, tx.clone());
+    }
+    drop(tx);
+
+    let deadline = Instant::now() + timeout;
+    let mut last_line = String::new();
+    loop {
+        let remaining = deadline.saturating_duration_since(Instant::now());
+        match rx.recv_timeout(remaining) {
+            Ok(line) => {
+                if !line.trim().is_empty() {
+                    last_line = line.trim().to_string();
+                }
+            }
+            Err(RecvTimeoutError::Disconnected) => break,
+            Err(RecvTimeoutError::Timeout) => {
+                let _ = child.kill();
+                let _ = child.wait();
+                return Err(format!(
+                    "{} did not answer within {}s",
+                    backend_label,
+                    timeout.as_secs()
+                ));
+            }
+        }
+    }
+
+    let status = child
+        .wait()
+        .map_err(|err| format!("failed to wait for {}: {}", backend_label, err))?;
+    if status.success() {
+        Ok(())
+    } else if last_line.is_empty() {
+        Err(format!("{} exited with {}", backend_label, status))
+    } else {
+        Err(format!(
+            "{} exited with {}: {}",
+            backend_label, status, last_line
+        ))
+    }
+}
+
 fn spawn_reader<R: Read + Send + 'static>(
     reader: R,
     sender: mpsc::Sender<String>,
@@ -259,9 +796,7 @@ mod tests {
 
     #[test]
     fn backend_selection_returns_expected_type() {
-        let cases = ["claude", "opencode", "gemini", "codex"];
-
-        for name in cases {
+        for name in BACKEND_NAMES {
             assert!(backend_from_name(name).is_ok(), "{} should resolve", name);
         }
         let err = match backend_from_name("unknown") {
@@ -271,6 +806,60 @@ mod tests {
         assert_eq!(err, "Unknown backend: unknown");
     }
 
+    #[test]
+    fn describe_invocation_shows_command_line_with_placeholder() {
+        let codex = CodexBackend::with_command("codex")
+            .with_extra_args(vec!["--profile".to_string(), "my team".to_string()]);
+        assert_eq!(
+            codex.describe_invocation(Some("gpt-5"), Some("high")),
+            "codex --quiet --auto-approve --json --model gpt-5 -c 'model_reasoning_effort=high' --profile 'my team' '<prompt>'"
+        );
+
+        let claude = ClaudeBackend::with_command("claude").describe_invocation(None, Some("low"));
+        assert!(claude.starts_with("IS_SANDBOX=1 MAX_THINKING_TOKENS=4000 claude "));
+        assert!(claude.ends_with("-p '<prompt>'"));
+
+        let ollama = OllamaBackend::with_host("http://127.0.0.1:11434");
+        assert_eq!(
+            ollama.describe_invocation(Some("qwen3"), Some("off")),
+            "POST http://127.0.0.1:11434/api/generate {\"model\":\"qwen3\",\"prompt\":\"<prompt>\",\"stream\":true,\"think\":false}"
+        );
+    }
+
+    #[test]
+    fn backend_from_config_rejects_extra_args_for_http_backends() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("default.yaml");
+        fs::write(
+            &path,
+            "backends:\n  ollama:\n    extra_args: [\"--verbose\"]\n",
+        )
+        .unwrap();
+        let config = {
+            let _guard = crate::test_support::env_lock();
+            unsafe {
+                env::set_var("GRALPH_DEFAULT_CONFIG", &path);
+                env::set_var("GRALPH_GLOBAL_CONFIG", temp.path().join("missing.yaml"));
+            }
+            let config = Config::load(None).unwrap();
+            unsafe {
+                env::remove_var("GRALPH_DEFAULT_CONFIG");
+                env::remove_var("GRALPH_GLOBAL_CONFIG");
+            }
+            config
+        };
+
+        let err = match backend_from_config("ollama", &config, &[]) {
+            Ok(_) => panic!("expected extra args error"),
+            Err(err) => err,
+        };
+        assert!(err.contains("backends.ollama.extra_args"));
+        let flag = vec!["--flag".to_string()];
+        assert!(backend_from_config("openai", &config, &flag).is_err());
+        assert!(backend_from_config("openai", &config, &[]).is_ok());
+        assert!(backend_from_config("codex", &config, &flag).is_ok());
+    }
+
     #[test]
     fn backend_from_name_reports_invalid_names() {
         let cases = ["", "Claude", "claude ", " opencode"];
@@ -284,18 +873,22 @@ mod tests {
         }
     }
 
+    #[test]
+    fn ensure_network_allowed_refuses_remote_backends_offline() {
+        let claude = backend_from_name("claude").unwrap();
+        let local = ollama::OllamaBackend::with_host("http://127.0.0.1:11434");
+
+        assert!(ensure_network_allowed(claude.as_ref(), false).is_ok());
+
+        let err = ensure_network_allowed(claude.as_ref(), true).unwrap_err();
+        assert!(err.starts_with("backend claude needs network access"));
+        assert!(ensure_network_allowed(&local, true).is_ok());
+    }
+
     #[test]
     fn backend_models_are_non_empty_and_stable() {
         let cases = [
             ("claude", vec!["claude-opus-4-5".to_string()]),
-            (
-                "opencode",
-                vec![
-                    "opencode/example-code-model".to_string(),
-                    "anthropic/claude-opus-4-5".to_string(),
-                    "google/gemini-1.5-pro".to_string(),
-                ],
-            ),
             ("gemini", vec!["gemini-1.5-pro".to_string()]),
             ("codex", vec!["example-codex-model".to_string()]),
         ];
@@ -308,6 +901,48 @@ mod tests {
         }
     }
 
+    #[test]
+    fn backend_capabilities_reflect_output_modes() {
+        let claude = backend_from_name("claude").unwrap().capabilities();
+        assert!(claude.streaming && claude.json_output);
+        assert_eq!(claude.max_context_tokens, Some(200_000));
+
+        let openai = backend_from_name("openai").unwrap().capabilities();
+        assert!(!openai.streaming);
+        assert!(openai.json_output);
+    }
+
+    #[test]
+    fn cached_models_reuses_fresh_cache_and_falls_back_when_fetch_fails() {
+        let _lock = crate::test_support::env_lock();
+        let temp = tempfile::tempdir().unwrap();
+        unsafe {
+            env::set_var("GRALPH_MODELS_CACHE_DIR", temp.path());
+        }
+
+        let models = cached_models("demo/host:1", || Ok(vec!["m1".to_string()]));
+        assert_eq!(models, vec!["m1"]);
+        assert!(temp.path().join("demo_host_1.json").is_file());
+
+        let models = cached_models("demo/host:1", || panic!("fresh cache should be used"));
+        assert_eq!(models, vec!["m1"]);
+
+        let path = temp.path().join("demo_host_1.json");
+        fs::write(&path, "{\"fetched_at\":0,\"models\":[\"stale\"]}").unwrap();
+        let models = cached_models("demo/host:1", || {
+            Err(BackendError::Command("offline".to_string()))
+        });
+        assert_eq!(models, vec!["stale"]);
+
+        let models = cached_models("demo/host:1", || Ok(vec!["fresh".to_string()]));
+        assert_eq!(models, vec!["fresh"]);
+
+        assert!(cached_models("missing", || Ok(Vec::new())).is_empty());
+        unsafe {
+            env::remove_var("GRALPH_MODELS_CACHE_DIR");
+        }
+    }
+
     #[test]
     fn backend_error_display_and_source_for_io() {
         let temp_dir = tempfile::tempdir().unwrap();
@@ -611,6 +1246,111 @@ mod tests {
         assert_eq!(lines, vec!["stderr-line\n"]);
     }
 
+    #[cfg(unix)]
+    #[test]
+    fn stream_command_output_terminates_backend_on_shutdown() {
+        let child = Command::new("/bin/sh")
+            .arg("-c")
+            .arg("trap 'printf \"flushed\\n\"; exit 143' TERM; printf 'started\\n'; while :; do sleep 0.05; done")
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .spawn()
+            .unwrap();
+
+        crate::shutdown::request_for_test(libc::SIGINT);
+        let mut lines = Vec::new();
+        let result = stream_command_output(child, "stub", |line| {
+            lines.push(line);
+            Ok(())
+        });
+        crate::shutdown::clear_for_test();
+
+        let err = result.unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "backend command error: stub interrupted by SIGINT"
+        );
+        assert!(lines.iter().any(|line| line.contains("flushed")));
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn stream_command_output_beats_while_the_backend_is_quiet() {
+        let child = Command::new("/bin/sh")
+            .arg("-c")
+            .arg("sleep 0.5")
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .spawn()
+            .unwrap();
+
+        let beats = Rc::new(std::cell::Cell::new(0));
+        let counted = Rc::clone(&beats);
+        let result = with_heartbeat(
+            Duration::from_millis(150),
+            move || counted.set(counted.get() + 1),
+            || stream_command_output(child, "stub", |_| Ok(())),
+        );
+
+        assert!(result.is_ok());
+        let count = beats.get();
+        assert!((2..=5).contains(&count), "{} beats", count);
+        heartbeat();
+        assert_eq!(beats.get(), count);
+    }
+
+    #[test]
+    fn echo_output_passes_lines_to_the_live_output_sink() {
+        let lines = Rc::new(RefCell::new(Vec::new()));
+        let seen = Rc::clone(&lines);
+        let mut stdout = Vec::new();
+
+        with_live_output(
+            move |line| seen.borrow_mut().push(line.to_string()),
+            || echo_output(&mut stdout, "first\r\n\n  \nsecond").unwrap(),
+        );
+        echo_output(&mut stdout, "unseen\n").unwrap();
+
+        assert_eq!(*lines.borrow(), vec!["first", "second"]);
+        assert_eq!(stdout, b"first\r\n\n  \nsecondunseen\n");
+    }
+
+    #[test]
+    fn without_stdout_echo_keeps_the_live_output_sink() {
+        let lines = Rc::new(RefCell::new(Vec::new()));
+        let seen = Rc::clone(&lines);
+        let mut stdout = Vec::new();
+
+        with_live_output(
+            move |line| seen.borrow_mut().push(line.to_string()),
+            || without_stdout_echo(|| echo_output(&mut stdout, "first\n").unwrap()),
+        );
+        echo_output(&mut stdout, "shown\n").unwrap();
+
+        assert_eq!(*lines.borrow(), vec!["first"]);
+        assert_eq!(stdout, b"shown\n");
+    }
+
+    #[test]
+    fn probe_command_reports_exit_output_and_timeout() {
+        let mut ok = Command::new("sh");
+        ok.arg("-c").arg("echo OK");
+        assert!(probe_command(&mut ok, "fake", Duration::from_secs(5)).is_ok());
+
+        let mut failed = Command::new("sh");
+        failed
+            .arg("-c")
+            .arg("echo 'Invalid API key' >&2; echo; exit 1");
+        let err = probe_command(&mut failed, "fake", Duration::from_secs(5)).unwrap_err();
+        assert!(err.starts_with("fake exited with"), "{}", err);
+        assert!(err.ends_with(": Invalid API key"), "{}", err);
+
+        let mut slow = Command::new("sh");
+        slow.arg("-c").arg("exec sleep 5");
+        let err = probe_command(&mut slow, "fake", Duration::from_millis(200)).unwrap_err();
+        assert_eq!(err, "fake did not answer within 0s");
+    }
+
     #[test]
     fn spawn_reader_exits_when_receiver_closed() {
         let reader = Cursor::new(b"first-line\nsecond-line\n".to_vec());
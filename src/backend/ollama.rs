@@ -0,0 +1,439 @@
+use super::{
+    Backend, BackendCapabilities, BackendError, PROMPT_PLACEHOLDER, Usage, cached_models,
+    checked_variant, requested_variant,
+};
+use crate::offline;
+use reqwest::blocking::Client;
+use serde_json::Value;
+use std::env;
+use std::fs::{self, File};
+use std::io::{self, BufRead, BufReader, BufWriter, Write};
+use std::path::{Path, PathBuf};
+use std::time::Duration;
+
+const DEFAULT_HOST: &str = "http://127.0.0.1:11434";
+const DEFAULT_MODEL: &str = "llama3.1";
+const PROBE_TIMEOUT: Duration = Duration::from_secs(2);
+
+#[derive(Debug, Clone)]
+pub struct OllamaBackend {
+    host: String,
+}
+
+impl OllamaBackend {
+    pub fn new() -> Self {
+        let host = env::var("OLLAMA_HOST").unwrap_or_default();
+        Self::with_host(host)
+    }
+
+    pub fn with_host(host: impl Into<String>) -> Self {
+        Self {
+            host: normalize_host(&host.into()),
+        }
+    }
+
+    pub fn host(&self) -> &str {
+        &self.host
+    }
+
+    /// Lists models installed on the Ollama server via `/api/tags`.
+    pub fn list_models(&self) -> Result<Vec<String>, BackendError> {
+        let client = build_client(Some(PROBE_TIMEOUT))?;
+        let response = client
+            .get(format!("{}/api/tags", self.host))
+            .send()
+            .map_err(|err| request_error("list models", err))?;
+        if !response.status().is_success() {
+            return Err(BackendError::Command(format!(
+                "ollama list models returned {}",
+                response.status()
+            )));
+        }
+        let body = response
+            .text()
+            .map_err(|err| request_error("list models", err))?;
+        let body: Value =
+            serde_json::from_str(&body).map_err(|source| BackendError::Json { source })?;
+        Ok(parse_tags(&body))
+    }
+}
+
+impl Default for OllamaBackend {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Backend for OllamaBackend {
+    fn name(&self) -> &str {
+        "ollama"
+    }
+
+    fn capabilities(&self) -> BackendCapabilities {
+        BackendCapabilities {
+            streaming: true,
+            json_output: true,
+            max_context_tokens: None,
+            variants: &["on", "off", "low", "medium", "high"],
+        }
+    }
+
+    fn requires_network(&self) -> bool {
+        !offline::is_local_url(&self.host)
+    }
+
+    fn describe_invocation(&self, model: Option<&str>, variant: Option<&str>) -> String {
+        let payload = generate_payload(
+            PROMPT_PLACEHOLDER,
+            resolve_model(model),
+            requested_variant(variant),
+        );
+        format!("POST {}/api/generate {}", self.host, payload)
+    }
+
+    fn check_installed(&self) -> bool {
+        self.list_models().is_ok()
+    }
+
+    fn run_iteration(
+        &self,
+        prompt: &str,
+        model: Option<&str>,
+        variant: Option<&str>,
+        output_file: &Path,
+        _working_dir: &Path,
+    ) -> Result<(), BackendError> {
+        if prompt.trim().is_empty() {
+            return Err(BackendError::InvalidInput("prompt is required".to_string()));
+        }
+        let variant = checked_variant(self, variant)?;
+        let model = resolve_model(model);
+
+        let file = File::create(output_file).map_err(|source| BackendError::Io {
+            path: output_file.to_path_buf(),
+            source,
+        })?;
+        let mut output = BufWriter::new(file);
+
+        let payload = generate_payload(prompt, model, variant);
+        let client = build_client(None)?;
+        let response = client
+            .post(format!("{}/api/generate", self.host))
+            .header("Content-Type", "application/json")
+            .body(payload.to_string())
+            .send()
+            .map_err(|err| request_error("generate", err))?;
+        if !response.status().is_success() {
+            let status = response.status();
+            let body = response.text().unwrap_or_default();
+            return Err(BackendError::Command(format!(
+                "ollama generate returned {}: {}",
+                status,
+                body.trim()
+            )));
+        }
+
+        let stdout_stream = io::stdout();
+        let mut stdout_lock = stdout_stream.lock();
+        for line in BufReader::new(response).lines() {
+            let line = line.map_err(|source| BackendError::Io {
+                path: PathBuf::from("ollama response"),
+                source,
+            })?;
+            if line.trim().is_empty() {
+                continue;
+            }
+            writeln!(output, "{}", line).map_err(|source| BackendError::Io {
+                path: output_file.to_path_buf(),
+                source,
+            })?;
+            let chunk: Value =
+                serde_json::from_str(&line).map_err(|source| BackendError::Json { source })?;
+            if let Some(error) = chunk.get("error").and_then(|v| v.as_str()) {
+                return Err(BackendError::Command(format!("ollama error: {}", error)));
+            }
+            if let Some(text) = chunk.get("response").and_then(|v| v.as_str()) {
+                stdout_lock
+                    .write_all(text.as_bytes())
+                    .and_then(|_| stdout_lock.flush())
+                    .map_err(|source| BackendError::Io {
+                        path: PathBuf::from("stdout"),
+                        source,
+                    })?;
+            }
+        }
+        output.flush().map_err(|source| BackendError::Io {
+            path: output_file.to_path_buf(),
+            source,
+        })
+    }
+
+    fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+        let contents = fs::read_to_string(response_file).map_err(|source| BackendError::Io {
+            path: response_file.to_path_buf(),
+            source,
+        })?;
+        Ok(collect_response_text(&contents))
+    }
+
+    /// Prompt and generated token counts from the final (`done`) chunk.
+    fn parse_usage(&self, response_file: &Path) -> Option<Usage> {
+        let contents = fs::read_to_string(response_file).ok()?;
+        contents
+            .lines()
+            .filter_map(|line| serde_json::from_str::<Value>(line.trim()).ok())
+            .filter(|chunk| chunk.get("done").and_then(Value::as_bool) == Some(true))
+            .map(|chunk| Usage {
+                input_tokens: chunk
+                    .get("prompt_eval_count")
+                    .and_then(Value::as_u64)
+                    .unwrap_or(0),
+                output_tokens: chunk.get("eval_count").and_then(Value::as_u64).unwrap_or(0),
+                cost_usd: None,
+            })
+            .last()
+    }
+
+    fn get_models(&self) -> Vec<String> {
+        let models = cached_models(&format!("ollama-{}", self.host), || self.list_models());
+        if models.is_empty() {
+            vec![DEFAULT_MODEL.to_string()]
+        } else {
+            models
+        }
+    }
+}
+
+fn normalize_host(raw: &str) -> String {
+    let trimmed = raw.trim().trim_end_matches('/');
+    if trimmed.is_empty() {
+        return DEFAULT_HOST.to_string();
+    }
+    if trimmed.starts_with("http://") || trimmed.starts_with("https://") {
+        trimmed.to_string()
+    } else {
+        format!("http://{}", trimmed)
+    }
+}
+
+fn resolve_model(model: Option<&str>) -> &str {
+    model
+        .map(str::trim)
+        .filter(|model| !model.is_empty())
+        .unwrap_or(DEFAULT_MODEL)
+}
+
+fn generate_payload(prompt: &str, model: &str, variant: Option<&str>) -> Value {
+    let mut payload = serde_json::json!({
+        "model": model,
+        "prompt": prompt,
+        "stream": true,
+    });
+    if let Some(variant) = variant {
+        payload["think"] = think_value(variant);
+    }
+    payload
+}
+
+/// Ollama's `think` option: on/off for thinking models, or a level for
+/// models that take one (gpt-oss).
+fn think_value(variant: &str) -> Value {
+    match variant {
+        "on" => Value::Bool(true),
+        "off" => Value::Bool(false),
+        level => Value::String(level.to_string()),
+    }
+}
+
+fn build_client(timeout: Option<Duration>) -> Result<Client, BackendError> {
+    Client::builder()
+        .timeout(timeout)
+        .build()
+        .map_err(|err| request_error("client setup", err))
+}
+
+fn request_error(action: &str, err: reqwest::Error) -> BackendError {
+    BackendError::Command(format!("ollama {} failed: {}", action, err))
+}
+
+fn parse_tags(body: &Value) -> Vec<String> {
+    body.get("models")
+        .and_then(|v| v.as_array())
+        .map(|models| {
+            models
+                .iter()
+                .filter_map(|model| model.get("name").and_then(|v| v.as_str()))
+                .map(str::to_string)
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+/// Concatenates `response` fields from streamed NDJSON chunks. Lines that are
+/// not JSON are kept verbatim so partial or plain-text output still parses.
+fn collect_response_text(contents: &str) -> String {
+    let mut text = String::new();
+    for line in contents.lines() {
+        if line.trim().is_empty() {
+            continue;
+        }
+        match serde_json::from_str::<Value>(line) {
+            Ok(chunk) => {
+                if let Some(part) = chunk.get("response").and_then(|v| v.as_str()) {
+                    text.push_str(part);
+                }
+            }
+            Err(_) => {
+                text.push_str(line);
+                text.push('\n');
+            }
+        }
+    }
+    text
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::serve_http_once;
+
+    #[test]
+    fn normalize_host_adds_scheme_and_default() {
+        assert_eq!(normalize_host(""), DEFAULT_HOST);
+        assert_eq!(normalize_host("localhost:11434/"), "http://localhost:11434");
+        assert_eq!(
+            normalize_host("https://ollama.local"),
+            "https://ollama.local"
+        );
+    }
+
+    #[test]
+    fn requires_network_only_for_remote_hosts() {
+        assert!(!OllamaBackend::with_host("").requires_network());
+        assert!(!OllamaBackend::with_host("localhost:11434").requires_network());
+        assert!(OllamaBackend::with_host("https://ollama.example.com").requires_network());
+    }
+
+    #[test]
+    fn parse_tags_extracts_model_names() {
+        let body =
+            serde_json::json!({"models": [{"name": "llama3.1:8b"}, {"name": "qwen2.5-coder"}, {}]});
+        assert_eq!(parse_tags(&body), vec!["llama3.1:8b", "qwen2.5-coder"]);
+        assert!(parse_tags(&serde_json::json!({})).is_empty());
+    }
+
+    #[test]
+    fn parse_text_joins_streamed_chunks() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("ollama.jsonl");
+        fs::write(
+            &path,
+            "{\"response\":\"Hello \",\"done\":false}\n{\"response\":\"world\",\"done\":false}\n{\"response\":\"\",\"done\":true}\n",
+        )
+        .unwrap();
+
+        let result = OllamaBackend::with_host("").parse_text(&path).unwrap();
+        assert_eq!(result, "Hello world");
+    }
+
+    #[test]
+    fn parse_usage_reads_final_chunk_counts() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("ollama.jsonl");
+        fs::write(
+            &path,
+            "{\"response\":\"Hi\",\"done\":false}\n{\"response\":\"\",\"done\":true,\"prompt_eval_count\":26,\"eval_count\":290}\n",
+        )
+        .unwrap();
+
+        let usage = OllamaBackend::with_host("").parse_usage(&path).unwrap();
+        assert_eq!((usage.input_tokens, usage.output_tokens), (26, 290));
+        assert_eq!(usage.cost_usd, None);
+    }
+
+    #[test]
+    fn list_models_reads_tags_endpoint() {
+        let (base, handle) = serve_http_once(
+            "HTTP/1.1 200 OK",
+            "{\"models\":[{\"name\":\"llama3.1\"}]}".to_string(),
+        );
+        let backend = OllamaBackend::with_host(base);
+
+        assert_eq!(backend.list_models().unwrap(), vec!["llama3.1"]);
+        let request = handle.join().unwrap();
+        assert!(request.starts_with("GET /api/tags"));
+    }
+
+    #[test]
+    fn run_iteration_streams_generate_response_to_output_file() {
+        let (base, handle) = serve_http_once(
+            "HTTP/1.1 200 OK",
+            "{\"response\":\"done \",\"done\":false}\n{\"response\":\"<promise>COMPLETE</promise>\",\"done\":true}\n".to_string(),
+        );
+        let temp = tempfile::tempdir().unwrap();
+        let output = temp.path().join("out.jsonl");
+        let backend = OllamaBackend::with_host(base);
+
+        backend
+            .run_iteration("Do it", Some("qwen2.5-coder"), None, &output, temp.path())
+            .unwrap();
+
+        let request = handle.join().unwrap();
+        assert!(request.starts_with("POST /api/generate"));
+        assert!(request.contains("\"model\":\"qwen2.5-coder\""));
+        assert_eq!(
+            backend.parse_text(&output).unwrap(),
+            "done <promise>COMPLETE</promise>"
+        );
+    }
+
+    #[test]
+    fn run_iteration_sends_think_for_variant() {
+        let (base, handle) = serve_http_once(
+            "HTTP/1.1 200 OK",
+            "{\"response\":\"ok\",\"done\":true}\n".to_string(),
+        );
+        let temp = tempfile::tempdir().unwrap();
+        let output = temp.path().join("out.jsonl");
+        let backend = OllamaBackend::with_host(base);
+
+        backend
+            .run_iteration("Do it", None, Some("off"), &output, temp.path())
+            .unwrap();
+
+        let request = handle.join().unwrap();
+        assert!(request.contains("\"think\":false"));
+        assert_eq!(think_value("high"), Value::String("high".to_string()));
+        assert!(
+            backend
+                .run_iteration("Do it", None, Some("max"), &output, temp.path())
+                .is_err()
+        );
+    }
+
+    #[test]
+    fn run_iteration_reports_http_errors() {
+        let (base, handle) = serve_http_once(
+            "HTTP/1.1 404 Not Found",
+            "{\"error\":\"model not found\"}".to_string(),
+        );
+        let temp = tempfile::tempdir().unwrap();
+        let backend = OllamaBackend::with_host(base);
+
+        let err = backend
+            .run_iteration("Do it", None, None, &temp.path().join("out"), temp.path())
+            .unwrap_err();
+        let _ = handle.join();
+        assert!(err.to_string().contains("model not found"));
+    }
+
+    #[test]
+    fn run_iteration_rejects_empty_prompt() {
+        let temp = tempfile::tempdir().unwrap();
+        let backend = OllamaBackend::with_host("");
+        let err = backend
+            .run_iteration(" ", None, None, &temp.path().join("out"), temp.path())
+            .unwrap_err();
+        assert!(matches!(err, BackendError::InvalidInput(_)));
+    }
+}
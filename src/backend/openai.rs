@@ -0,0 +1,486 @@
+use super::{
+    Backend, BackendCapabilities, BackendError, PROMPT_PLACEHOLDER, Usage, cached_models,
+    checked_variant, requested_variant,
+};
+use crate::config::Config;
+use crate::offline;
+use reqwest::blocking::{Client, RequestBuilder};
+use serde_json::Value;
+use std::env;
+use std::fs;
+use std::io::{self, Write};
+use std::path::{Path, PathBuf};
+use std::time::Duration;
+
+const DEFAULT_BASE_URL: &str = "https://api.openai.com/v1";
+const PROBE_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// Backend for any OpenAI-compatible `/chat/completions` endpoint
+/// (OpenAI, OpenRouter, Groq, LM Studio, vLLM, ...).
+#[derive(Debug, Clone)]
+pub struct OpenAiBackend {
+    base_url: String,
+    api_key: Option<String>,
+    default_model: Option<String>,
+}
+
+impl OpenAiBackend {
+    /// Builds the backend from `GRALPH_OPENAI_*` variables, falling back to
+    /// the conventional `OPENAI_BASE_URL` and `OPENAI_API_KEY`.
+    pub fn new() -> Self {
+        let lookup = |keys: &[&str]| {
+            keys.iter()
+                .find_map(|key| env::var(key).ok().filter(|v| !v.trim().is_empty()))
+        };
+        Self::with_settings(
+            lookup(&["GRALPH_OPENAI_BASE_URL", "OPENAI_BASE_URL"]).as_deref(),
+            lookup(&["GRALPH_OPENAI_API_KEY", "OPENAI_API_KEY"]).as_deref(),
+            lookup(&["GRALPH_OPENAI_DEFAULT_MODEL"]).as_deref(),
+        )
+    }
+
+    /// Reads `openai.base_url`, `openai.api_key`, and `openai.default_model`,
+    /// using the environment for anything the config leaves empty.
+    pub fn from_config(config: &Config) -> Self {
+        let fallback = Self::new();
+        let value = |key: &str| config.get(key).filter(|v| !v.trim().is_empty());
+        Self::with_settings(
+            value("openai.base_url")
+                .as_deref()
+                .or(Some(fallback.base_url.as_str())),
+            value("openai.api_key")
+                .as_deref()
+                .or(fallback.api_key.as_deref()),
+            value("openai.default_model")
+                .as_deref()
+                .or(fallback.default_model.as_deref()),
+        )
+    }
+
+    pub fn with_settings(
+        base_url: Option<&str>,
+        api_key: Option<&str>,
+        default_model: Option<&str>,
+    ) -> Self {
+        let base_url = base_url
+            .map(|url| url.trim().trim_end_matches('/'))
+            .filter(|url| !url.is_empty())
+            .unwrap_or(DEFAULT_BASE_URL)
+            .to_string();
+        let non_empty = |value: Option<&str>| {
+            value
+                .map(str::trim)
+                .filter(|v| !v.is_empty())
+                .map(str::to_string)
+        };
+        Self {
+            base_url,
+            api_key: non_empty(api_key),
+            default_model: non_empty(default_model),
+        }
+    }
+
+    pub fn base_url(&self) -> &str {
+        &self.base_url
+    }
+
+    pub fn default_model(&self) -> Option<&str> {
+        self.default_model.as_deref()
+    }
+
+    /// Lists model IDs from the endpoint's `/models` route.
+    pub fn list_models(&self) -> Result<Vec<String>, BackendError> {
+        let client = build_client(Some(PROBE_TIMEOUT))?;
+        let response = self
+            .authorize(client.get(format!("{}/models", self.base_url)))
+            .send()
+            .map_err(|err| request_error("list models", err))?;
+        let status = response.status();
+        let body = response
+            .text()
+            .map_err(|err| request_error("list models", err))?;
+        if !status.is_success() {
+            return Err(BackendError::Command(format!(
+                "openai list models returned {}: {}",
+                status,
+                body.trim()
+            )));
+        }
+        let body: Value =
+            serde_json::from_str(&body).map_err(|source| BackendError::Json { source })?;
+        Ok(parse_model_ids(&body))
+    }
+
+    /// `model` when given, otherwise `openai.default_model`.
+    fn resolve_model<'a>(&'a self, model: Option<&'a str>) -> Option<&'a str> {
+        model
+            .map(str::trim)
+            .filter(|model| !model.is_empty())
+            .or(self.default_model.as_deref())
+    }
+
+    fn authorize(&self, request: RequestBuilder) -> RequestBuilder {
+        match &self.api_key {
+            Some(key) => request.bearer_auth(key),
+            None => request,
+        }
+    }
+}
+
+impl Default for OpenAiBackend {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Backend for OpenAiBackend {
+    fn name(&self) -> &str {
+        "openai"
+    }
+
+    fn capabilities(&self) -> BackendCapabilities {
+        BackendCapabilities {
+            streaming: false,
+            json_output: true,
+            max_context_tokens: None,
+            variants: &["minimal", "low", "medium", "high"],
+        }
+    }
+
+    fn requires_network(&self) -> bool {
+        !offline::is_local_url(&self.base_url)
+    }
+
+    fn describe_invocation(&self, model: Option<&str>, variant: Option<&str>) -> String {
+        let model = self.resolve_model(model).unwrap_or("<model required>");
+        let payload = chat_payload(PROMPT_PLACEHOLDER, model, requested_variant(variant));
+        format!("POST {}/chat/completions {}", self.base_url, payload)
+    }
+
+    fn check_installed(&self) -> bool {
+        self.list_models().is_ok()
+    }
+
+    /// A remote endpoint needs a key, and must accept it for `/models`.
+    fn check_auth(&self, _model: Option<&str>, _working_dir: &Path) -> Result<(), String> {
+        if self.api_key.is_none() && self.requires_network() {
+            return Err(format!(
+                "no API key for {} (set openai.api_key or OPENAI_API_KEY)",
+                self.base_url
+            ));
+        }
+        self.list_models()
+            .map(|_| ())
+            .map_err(|err| err.to_string())
+    }
+
+    fn run_iteration(
+        &self,
+        prompt: &str,
+        model: Option<&str>,
+        variant: Option<&str>,
+        output_file: &Path,
+        _working_dir: &Path,
+    ) -> Result<(), BackendError> {
+        if prompt.trim().is_empty() {
+            return Err(BackendError::InvalidInput("prompt is required".to_string()));
+        }
+        let effort = checked_variant(self, variant)?;
+        let model = self.resolve_model(model).ok_or_else(|| {
+            BackendError::InvalidInput(
+                "model is required (pass --model or set openai.default_model)".to_string(),
+            )
+        })?;
+
+        let payload = chat_payload(prompt, model, effort);
+        let client = build_client(None)?;
+        let response = self
+            .authorize(client.post(format!("{}/chat/completions", self.base_url)))
+            .header("Content-Type", "application/json")
+            .body(payload.to_string())
+            .send()
+            .map_err(|err| request_error("chat completion", err))?;
+        let status = response.status();
+        let body = response
+            .text()
+            .map_err(|err| request_error("chat completion", err))?;
+        fs::write(output_file, &body).map_err(|source| BackendError::Io {
+            path: output_file.to_path_buf(),
+            source,
+        })?;
+        if !status.is_success() {
+            return Err(BackendError::Command(format!(
+                "openai chat completion returned {}: {}",
+                status,
+                body.trim()
+            )));
+        }
+
+        let text = extract_message_text(&body)?;
+        let mut stdout = io::stdout().lock();
+        writeln!(stdout, "{}", text)
+            .and_then(|_| stdout.flush())
+            .map_err(|source| BackendError::Io {
+                path: PathBuf::from("stdout"),
+                source,
+            })
+    }
+
+    fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+        let contents = fs::read_to_string(response_file).map_err(|source| BackendError::Io {
+            path: response_file.to_path_buf(),
+            source,
+        })?;
+        extract_message_text(&contents)
+    }
+
+    /// The response's `usage` block. Chat completions report no cost.
+    fn parse_usage(&self, response_file: &Path) -> Option<Usage> {
+        let contents = fs::read_to_string(response_file).ok()?;
+        let value: Value = serde_json::from_str(&contents).ok()?;
+        let usage = value.get("usage")?;
+        let tokens = |key: &str| usage.get(key).and_then(Value::as_u64).unwrap_or(0);
+        Some(Usage {
+            input_tokens: tokens("prompt_tokens"),
+            output_tokens: tokens("completion_tokens"),
+            cost_usd: None,
+        })
+    }
+
+    fn get_models(&self) -> Vec<String> {
+        let models = cached_models(&format!("openai-{}", self.base_url), || self.list_models());
+        if models.is_empty() {
+            self.default_model.iter().cloned().collect()
+        } else {
+            models
+        }
+    }
+}
+
+fn chat_payload(prompt: &str, model: &str, effort: Option<&str>) -> Value {
+    let mut payload = serde_json::json!({
+        "model": model,
+        "messages": [{"role": "user", "content": prompt}],
+    });
+    if let Some(effort) = effort {
+        payload["reasoning_effort"] = Value::String(effort.to_string());
+    }
+    payload
+}
+
+fn build_client(timeout: Option<Duration>) -> Result<Client, BackendError> {
+    Client::builder()
+        .timeout(timeout)
+        .build()
+        .map_err(|err| request_error("client setup", err))
+}
+
+fn request_error(action: &str, err: reqwest::Error) -> BackendError {
+    BackendError::Command(format!("openai {} failed: {}", action, err))
+}
+
+fn parse_model_ids(body: &Value) -> Vec<String> {
+    body.get("data")
+        .and_then(|v| v.as_array())
+        .map(|models| {
+            models
+                .iter()
+                .filter_map(|model| model.get("id").and_then(|v| v.as_str()))
+                .map(str::to_string)
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+fn extract_message_text(body: &str) -> Result<String, BackendError> {
+    if body.trim().is_empty() {
+        return Ok(String::new());
+    }
+    let value: Value =
+        serde_json::from_str(body).map_err(|source| BackendError::Json { source })?;
+    Ok(value
+        .pointer("/choices/0/message/content")
+        .and_then(|v| v.as_str())
+        .unwrap_or_default()
+        .to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::serve_http_once;
+
+    const COMPLETION: &str = "{\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":\"All done\\n<promise>COMPLETE</promise>\"}}]}";
+
+    #[test]
+    fn with_settings_trims_and_defaults() {
+        let backend = OpenAiBackend::with_settings(Some(" "), Some(""), None);
+        assert_eq!(backend.base_url(), DEFAULT_BASE_URL);
+        assert!(backend.api_key.is_none());
+        assert!(backend.default_model().is_none());
+
+        let backend = OpenAiBackend::with_settings(
+            Some("http://localhost:1234/v1/"),
+            Some("sk-test"),
+            Some("local-model"),
+        );
+        assert_eq!(backend.base_url(), "http://localhost:1234/v1");
+        assert_eq!(backend.api_key.as_deref(), Some("sk-test"));
+        assert_eq!(backend.default_model(), Some("local-model"));
+    }
+
+    #[test]
+    fn parse_text_extracts_first_choice_content() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("openai.json");
+        fs::write(&path, COMPLETION).unwrap();
+
+        let backend = OpenAiBackend::with_settings(None, None, None);
+        assert_eq!(
+            backend.parse_text(&path).unwrap(),
+            "All done\n<promise>COMPLETE</promise>"
+        );
+    }
+
+    #[test]
+    fn parse_usage_reads_usage_block() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("openai.json");
+        let backend = OpenAiBackend::with_settings(None, None, None);
+        fs::write(&path, COMPLETION).unwrap();
+        assert_eq!(backend.parse_usage(&path), None);
+
+        fs::write(
+            &path,
+            "{\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":7,\"total_tokens\":19}}",
+        )
+        .unwrap();
+        let usage = backend.parse_usage(&path).unwrap();
+        assert_eq!(usage.total_tokens(), 19);
+    }
+
+    #[test]
+    fn parse_text_reports_invalid_json() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("openai.json");
+        fs::write(&path, "not json").unwrap();
+
+        let backend = OpenAiBackend::with_settings(None, None, None);
+        assert!(matches!(
+            backend.parse_text(&path),
+            Err(BackendError::Json { .. })
+        ));
+    }
+
+    #[test]
+    fn list_models_sends_bearer_token() {
+        let (base, handle) = serve_http_once(
+            "HTTP/1.1 200 OK",
+            "{\"data\":[{\"id\":\"gpt-4o-mini\"},{\"id\":\"llama-3.1-70b\"}]}".to_string(),
+        );
+        let backend = OpenAiBackend::with_settings(Some(&base), Some("sk-test"), None);
+
+        assert_eq!(
+            backend.list_models().unwrap(),
+            vec!["gpt-4o-mini", "llama-3.1-70b"]
+        );
+        let request = handle.join().unwrap();
+        assert!(request.starts_with("GET /models"));
+        assert!(
+            request
+                .to_ascii_lowercase()
+                .contains("authorization: bearer sk-test")
+        );
+    }
+
+    #[test]
+    fn run_iteration_posts_chat_completion() {
+        let (base, handle) = serve_http_once("HTTP/1.1 200 OK", COMPLETION.to_string());
+        let temp = tempfile::tempdir().unwrap();
+        let output = temp.path().join("out.json");
+        let backend = OpenAiBackend::with_settings(Some(&base), None, Some("local-model"));
+
+        backend
+            .run_iteration("Do it", None, None, &output, temp.path())
+            .unwrap();
+
+        let request = handle.join().unwrap();
+        assert!(request.starts_with("POST /chat/completions"));
+        assert!(request.contains("\"model\":\"local-model\""));
+        assert!(request.contains("\"content\":\"Do it\""));
+        assert!(!request.contains("reasoning_effort"));
+        assert_eq!(
+            backend.parse_text(&output).unwrap(),
+            "All done\n<promise>COMPLETE</promise>"
+        );
+    }
+
+    #[test]
+    fn run_iteration_sends_reasoning_effort_for_variant() {
+        let (base, handle) = serve_http_once("HTTP/1.1 200 OK", COMPLETION.to_string());
+        let temp = tempfile::tempdir().unwrap();
+        let output = temp.path().join("out.json");
+        let backend = OpenAiBackend::with_settings(Some(&base), None, Some("local-model"));
+
+        backend
+            .run_iteration("Do it", None, Some("high"), &output, temp.path())
+            .unwrap();
+
+        let request = handle.join().unwrap();
+        assert!(request.contains("\"reasoning_effort\":\"high\""));
+    }
+
+    #[test]
+    fn requires_network_unless_base_url_is_loopback() {
+        assert!(OpenAiBackend::with_settings(None, None, None).requires_network());
+        let local = OpenAiBackend::with_settings(Some("http://127.0.0.1:1234/v1"), None, None);
+        assert!(!local.requires_network());
+    }
+
+    #[test]
+    fn check_auth_requires_a_key_for_remote_endpoints() {
+        let remote = OpenAiBackend::with_settings(Some("https://api.example.com/v1"), None, None);
+        assert!(
+            remote
+                .check_auth(None, Path::new("."))
+                .unwrap_err()
+                .contains("no API key")
+        );
+
+        let (base, handle) = serve_http_once(
+            "HTTP/1.1 401 Unauthorized",
+            "{\"error\":\"bad key\"}".to_string(),
+        );
+        let local = OpenAiBackend::with_settings(Some(&base), None, None);
+        let err = local.check_auth(None, Path::new(".")).unwrap_err();
+        assert!(err.contains("401"), "{}", err);
+        assert!(handle.join().unwrap().starts_with("GET /models"));
+    }
+
+    #[test]
+    fn run_iteration_requires_model() {
+        let temp = tempfile::tempdir().unwrap();
+        let backend = OpenAiBackend::with_settings(None, None, None);
+        let err = backend
+            .run_iteration("Do it", None, None, &temp.path().join("out"), temp.path())
+            .unwrap_err();
+        assert!(err.to_string().contains("openai.default_model"));
+    }
+
+    #[test]
+    fn run_iteration_reports_http_errors() {
+        let (base, handle) = serve_http_once(
+            "HTTP/1.1 401 Unauthorized",
+            "{\"error\":{\"message\":\"bad key\"}}".to_string(),
+        );
+        let temp = tempfile::tempdir().unwrap();
+        let backend = OpenAiBackend::with_settings(Some(&base), None, Some("m"));
+
+        let err = backend
+            .run_iteration("Do it", None, None, &temp.path().join("out"), temp.path())
+            .unwrap_err();
+        let _ = handle.join();
+        assert!(err.to_string().contains("401"));
+        assert!(err.to_string().contains("bad key"));
+    }
+}
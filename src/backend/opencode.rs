@@ -1,30 +1,105 @@
-use super::{Backend, BackendError, command_in_path, spawn_with_retry, stream_command_output};
+use super::{
+    AUTH_PROBE_PROMPT, AUTH_PROBE_TIMEOUT, Backend, BackendCapabilities, BackendError,
+    PROMPT_PLACEHOLDER, cached_models, command_in_path, echo_output, format_command, probe_command,
+    sandbox::Sandbox, spawn_with_retry, stream_command_output,
+};
 use std::fs::{self, File};
 use std::io::{self, BufWriter, Write};
-use std::path::{Path, PathBuf};
+use std::path::Path;
 use std::process::{Command, Stdio};
 
 #[derive(Debug, Clone)]
 pub struct OpenCodeBackend {
     command: String,
+    extra_args: Vec<String>,
+    sandbox: Sandbox,
 }
 
 impl OpenCodeBackend {
     pub fn new() -> Self {
         Self {
             command: "opencode".to_string(),
+            extra_args: Vec::new(),
+            sandbox: Sandbox::None,
         }
     }
 
     pub fn with_command(command: impl Into<String>) -> Self {
         Self {
             command: command.into(),
+            extra_args: Vec::new(),
+            sandbox: Sandbox::None,
         }
     }
 
+    /// Arguments added to every iteration after gralph's own flags.
+    pub fn with_extra_args(mut self, extra_args: Vec<String>) -> Self {
+        self.extra_args = extra_args;
+        self
+    }
+
+    /// Runs every command the backend spawns inside `sandbox`.
+    pub fn with_sandbox(mut self, sandbox: Sandbox) -> Self {
+        self.sandbox = sandbox;
+        self
+    }
+
     pub fn command(&self) -> &str {
         &self.command
     }
+
+    fn iteration_command(
+        &self,
+        prompt: &str,
+        model: Option<&str>,
+        variant: Option<&str>,
+    ) -> Command {
+        let mut cmd = Command::new(&self.command);
+        cmd.env(OPENCODE_LSP_ENV, "true");
+        cmd.arg("run");
+        if let Some(model) = model {
+            if !model.trim().is_empty() {
+                cmd.arg("--model").arg(model);
+            }
+        }
+        if let Some(variant) = variant {
+            if !variant.trim().is_empty() {
+                cmd.arg("--variant").arg(variant);
+            }
+        }
+        cmd.args(&self.extra_args);
+        cmd.arg(prompt);
+        cmd
+    }
+
+    /// Lists `provider/model` IDs via `opencode models`.
+    pub fn list_models(&self) -> Result<Vec<String>, BackendError> {
+        let output = Command::new(&self.command)
+            .arg("models")
+            .stdin(Stdio::null())
+            .output()
+            .map_err(|err| {
+                BackendError::Command(format!("failed to run opencode models: {}", err))
+            })?;
+        if !output.status.success() {
+            return Err(BackendError::Command(format!(
+                "opencode models exited with {}",
+                output.status
+            )));
+        }
+        Ok(parse_model_lines(&String::from_utf8_lossy(&output.stdout)))
+    }
+}
+
+fn parse_model_lines(stdout: &str) -> Vec<String> {
+    stdout
+        .lines()
+        .map(str::trim)
+        .filter(|line| {
+            !line.is_empty() && !line.contains(char::is_whitespace) && line.contains('/')
+        })
+        .map(str::to_string)
+        .collect()
 }
 
 const OPENCODE_LSP_ENV: &str = "OPENCODE_EXPERIMENTAL_LSP_TOOL";
@@ -36,8 +111,39 @@ impl Default for OpenCodeBackend {
 }
 
 impl Backend for OpenCodeBackend {
+    fn name(&self) -> &str {
+        "opencode"
+    }
+
+    fn capabilities(&self) -> BackendCapabilities {
+        BackendCapabilities {
+            streaming: true,
+            json_output: false,
+            max_context_tokens: None,
+            variants: &[],
+        }
+    }
+
+    /// Variants are defined per model and passed through as `--variant`.
+    fn check_variant(&self, _variant: &str) -> Result<(), String> {
+        Ok(())
+    }
+
+    fn describe_invocation(&self, model: Option<&str>, variant: Option<&str>) -> String {
+        format_command(&self.iteration_command(PROMPT_PLACEHOLDER, model, variant))
+    }
+
     fn check_installed(&self) -> bool {
-        command_in_path(&self.command)
+        self.sandbox
+            .check_installed(|| command_in_path(&self.command))
+    }
+
+    fn check_auth(&self, model: Option<&str>, working_dir: &Path) -> Result<(), String> {
+        let mut cmd = self.sandbox.wrap(
+            self.iteration_command(AUTH_PROBE_PROMPT, model, None),
+            working_dir,
+        );
+        probe_command(&mut cmd, "opencode", AUTH_PROBE_TIMEOUT)
     }
 
     fn run_iteration(
@@ -58,23 +164,10 @@ impl Backend for OpenCodeBackend {
         })?;
         let mut output = BufWriter::new(file);
 
-        let mut cmd = Command::new(&self.command);
-        cmd.current_dir(working_dir);
-        cmd.env(OPENCODE_LSP_ENV, "true");
-        cmd.arg("run");
-        if let Some(model) = model {
-            if !model.trim().is_empty() {
-                cmd.arg("--model").arg(model);
-            }
-        }
-        if let Some(variant) = variant {
-            if !variant.trim().is_empty() {
-                cmd.arg("--variant").arg(variant);
-            }
-        }
-        cmd.arg(prompt)
-            .stdout(Stdio::piped())
-            .stderr(Stdio::piped());
+        let mut cmd = self
+            .sandbox
+            .wrap(self.iteration_command(prompt, model, variant), working_dir);
+        cmd.stdout(Stdio::piped()).stderr(Stdio::piped());
 
         let child = spawn_with_retry(&mut cmd, "opencode")?;
 
@@ -88,17 +181,7 @@ impl Backend for OpenCodeBackend {
                     path: output_file.to_path_buf(),
                     source,
                 })?;
-            stdout_lock
-                .write_all(line.as_bytes())
-                .map_err(|source| BackendError::Io {
-                    path: PathBuf::from("stdout"),
-                    source,
-                })?;
-            stdout_lock.flush().map_err(|source| BackendError::Io {
-                path: PathBuf::from("stdout"),
-                source,
-            })?;
-            Ok(())
+            echo_output(&mut stdout_lock, &line)
         })
     }
 
@@ -110,6 +193,12 @@ impl Backend for OpenCodeBackend {
     }
 
     fn get_models(&self) -> Vec<String> {
+        if self.check_installed() {
+            let models = cached_models("opencode", || self.list_models());
+            if !models.is_empty() {
+                return models;
+            }
+        }
         vec![
             "opencode/example-code-model".to_string(),
             "anthropic/claude-opus-4-5".to_string(),
@@ -167,6 +256,33 @@ mod tests {
         assert_eq!(backend.command(), "custom-opencode");
     }
 
+    #[test]
+    fn parse_model_lines_keeps_provider_model_ids() {
+        let stdout = "anthropic/claude-opus-4-5\n\nopenai/gpt-4o\nWarning: cache refreshed\n";
+        assert_eq!(
+            parse_model_lines(stdout),
+            vec!["anthropic/claude-opus-4-5", "openai/gpt-4o"]
+        );
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn list_models_runs_models_subcommand() {
+        let temp = tempfile::tempdir().unwrap();
+        let script_path = temp.path().join("opencode-mock");
+        let script = "#!/bin/sh\n[ \"$1\" = models ] || exit 3\nprintf 'opencode/big-pickle\\ngoogle/gemini-2.5-pro\\n'\n";
+        fs::write(&script_path, script).unwrap();
+        let mut perms = fs::metadata(&script_path).unwrap().permissions();
+        perms.set_mode(0o755);
+        fs::set_permissions(&script_path, perms).unwrap();
+
+        let backend = OpenCodeBackend::with_command(script_path.to_string_lossy().to_string());
+        assert_eq!(
+            backend.list_models().unwrap(),
+            vec!["opencode/big-pickle", "google/gemini-2.5-pro"]
+        );
+    }
+
     #[test]
     fn parse_text_returns_raw_contents() {
         let temp = tempfile::tempdir().unwrap();
@@ -0,0 +1,310 @@
+//! Restricted environments for backend CLIs, set with `security.sandbox`.
+//!
+//! Backends run unattended with permission prompts turned off, so on a
+//! shared machine they can read and change anything the user can. A
+//! [`Sandbox`] rewrites each backend command before it is spawned: `env`
+//! drops every environment variable not in `security.env_allow`, `firejail`
+//! also runs the command under firejail, and `docker` runs it in a container
+//! with only the working directory mounted. HTTP backends spawn nothing and
+//! are not affected.
+
+use super::command_in_path;
+use crate::config::Config;
+use std::ffi::OsString;
+use std::path::Path;
+use std::process::Command;
+
+pub const SANDBOX_MODES: [&str; 4] = ["none", "env", "firejail", "docker"];
+
+/// Variables every sandbox keeps, on top of `security.env_allow`.
+const BASE_ENV: &[&str] = &["PATH", "HOME", "USER", "LANG", "LC_ALL", "TERM", "TMPDIR"];
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub enum Sandbox {
+    /// Run backend commands as they are.
+    #[default]
+    None,
+    /// Keep only allow-listed environment variables.
+    Env { allow: Vec<String> },
+    /// Run under firejail with an allow-listed environment.
+    Firejail {
+        allow: Vec<String>,
+        args: Vec<String>,
+    },
+    /// Run in `docker run` with the working directory mounted at the same
+    /// path and an allow-listed environment.
+    Docker {
+        image: String,
+        allow: Vec<String>,
+        args: Vec<String>,
+    },
+}
+
+impl Sandbox {
+    /// The sandbox for the `security` config section.
+    pub fn from_config(config: &Config) -> Result<Self, String> {
+        let mode = config.get_or("security.sandbox", "none");
+        let mut allow: Vec<String> = BASE_ENV.iter().map(|name| name.to_string()).collect();
+        for name in config.get_list("security.env_allow").unwrap_or_default() {
+            let name = name.trim().to_string();
+            if !name.is_empty() && !allow.contains(&name) {
+                allow.push(name);
+            }
+        }
+        let args = config.get_list("security.sandbox_args").unwrap_or_default();
+        match mode.trim() {
+            "" | "none" => Ok(Sandbox::None),
+            "env" => Ok(Sandbox::Env { allow }),
+            "firejail" => Ok(Sandbox::Firejail { allow, args }),
+            "docker" => {
+                let image = config.get_or("security.docker_image", "");
+                if image.trim().is_empty() {
+                    return Err(
+                        "security.sandbox is docker but security.docker_image is not set"
+                            .to_string(),
+                    );
+                }
+                Ok(Sandbox::Docker {
+                    image: image.trim().to_string(),
+                    allow,
+                    args,
+                })
+            }
+            other => Err(format!(
+                "Unknown security.sandbox: {} (expected {})",
+                other,
+                SANDBOX_MODES.join(", ")
+            )),
+        }
+    }
+
+    /// Whether the backend can run: `host_check` for the backend CLI itself,
+    /// plus firejail, or only docker when the CLI lives in the image.
+    pub fn check_installed(&self, host_check: impl FnOnce() -> bool) -> bool {
+        match self {
+            Sandbox::None | Sandbox::Env { .. } => host_check(),
+            Sandbox::Firejail { .. } => command_in_path("firejail") && host_check(),
+            Sandbox::Docker { .. } => command_in_path("docker"),
+        }
+    }
+
+    /// `cmd` rewritten to run in the sandbox from `working_dir`. Variables
+    /// the backend sets on `cmd` itself are always passed through.
+    pub fn wrap(&self, mut cmd: Command, working_dir: &Path) -> Command {
+        let overrides: Vec<(OsString, OsString)> = cmd
+            .get_envs()
+            .filter_map(|(key, value)| Some((key.to_os_string(), value?.to_os_string())))
+            .collect();
+        match self {
+            Sandbox::None => {
+                cmd.current_dir(working_dir);
+                cmd
+            }
+            Sandbox::Env { allow } => {
+                let mut wrapped = Command::new(cmd.get_program());
+                wrapped.args(cmd.get_args());
+                restrict_env(&mut wrapped, allow, &overrides);
+                wrapped.current_dir(working_dir);
+                wrapped
+            }
+            Sandbox::Firejail { allow, args } => {
+                let mut wrapped = Command::new("firejail");
+                wrapped
+                    .arg("--quiet")
+                    .args(args)
+                    .arg("--")
+                    .arg(cmd.get_program())
+                    .args(cmd.get_args());
+                restrict_env(&mut wrapped, allow, &overrides);
+                wrapped.current_dir(working_dir);
+                wrapped
+            }
+            Sandbox::Docker { image, allow, args } => {
+                let mut wrapped = Command::new("docker");
+                let mut mount = working_dir.as_os_str().to_os_string();
+                mount.push(":");
+                mount.push(working_dir.as_os_str());
+                wrapped
+                    .arg("run")
+                    .arg("--rm")
+                    .arg("-i")
+                    .arg("-v")
+                    .arg(mount)
+                    .arg("-w")
+                    .arg(working_dir);
+                #[cfg(unix)]
+                {
+                    // Files the backend writes stay owned by the user.
+                    let (uid, gid) = unsafe { (libc::getuid(), libc::getgid()) };
+                    wrapped.arg("--user").arg(format!("{}:{}", uid, gid));
+                }
+                // Values go through docker's own environment (`-e NAME`), so
+                // secrets never appear on its command line.
+                for name in allow {
+                    if name != "PATH" && name != "HOME" && std::env::var_os(name).is_some() {
+                        wrapped.arg("-e").arg(name);
+                    }
+                }
+                for (key, value) in &overrides {
+                    wrapped.arg("-e").arg(key).env(key, value);
+                }
+                wrapped
+                    .args(args)
+                    .arg(image)
+                    .arg(cmd.get_program())
+                    .args(cmd.get_args());
+                wrapped.current_dir(working_dir);
+                wrapped
+            }
+        }
+    }
+}
+
+/// Clears the environment of `cmd` except for the allowed variables and the
+/// backend's own overrides.
+fn restrict_env(cmd: &mut Command, allow: &[String], overrides: &[(OsString, OsString)]) {
+    cmd.env_clear();
+    for name in allow {
+        if let Some(value) = std::env::var_os(name) {
+            cmd.env(name, value);
+        }
+    }
+    for (key, value) in overrides {
+        cmd.env(key, value);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn args(cmd: &Command) -> Vec<String> {
+        cmd.get_args()
+            .map(|arg| arg.to_string_lossy().into_owned())
+            .collect()
+    }
+
+    fn env_names(cmd: &Command) -> Vec<String> {
+        cmd.get_envs()
+            .filter(|(_, value)| value.is_some())
+            .map(|(key, _)| key.to_string_lossy().into_owned())
+            .collect()
+    }
+
+    fn backend_command() -> Command {
+        let mut cmd = Command::new("claude");
+        cmd.arg("-p").arg("do it").env("IS_SANDBOX", "1");
+        cmd
+    }
+
+    #[test]
+    fn from_config_reads_the_security_section() {
+        let config = Config::from_yaml("security:\n  sandbox: none\n").unwrap();
+        assert_eq!(Sandbox::from_config(&config), Ok(Sandbox::None));
+
+        let config = Config::from_yaml(
+            "security:\n  sandbox: firejail\n  env_allow: [ANTHROPIC_API_KEY, PATH]\n  sandbox_args: [--net=none]\n",
+        )
+        .unwrap();
+        match Sandbox::from_config(&config).unwrap() {
+            Sandbox::Firejail { allow, args } => {
+                assert!(allow.ends_with(&["TMPDIR".to_string(), "ANTHROPIC_API_KEY".to_string()]));
+                assert_eq!(allow.iter().filter(|name| *name == "PATH").count(), 1);
+                assert_eq!(args, vec!["--net=none"]);
+            }
+            other => panic!("unexpected sandbox {:?}", other),
+        }
+
+        let config = Config::from_yaml("security:\n  sandbox: docker\n").unwrap();
+        assert!(
+            Sandbox::from_config(&config)
+                .unwrap_err()
+                .contains("security.docker_image")
+        );
+        let config = Config::from_yaml("security:\n  sandbox: chroot\n").unwrap();
+        assert_eq!(
+            Sandbox::from_config(&config).unwrap_err(),
+            "Unknown security.sandbox: chroot (expected none, env, firejail, docker)"
+        );
+    }
+
+    #[test]
+    fn none_only_sets_the_working_dir() {
+        let cmd = Sandbox::None.wrap(backend_command(), Path::new("/work"));
+        assert_eq!(cmd.get_program(), "claude");
+        assert_eq!(args(&cmd), vec!["-p", "do it"]);
+        assert_eq!(cmd.get_current_dir(), Some(Path::new("/work")));
+    }
+
+    #[test]
+    fn env_keeps_allowed_variables_and_backend_overrides() {
+        let sandbox = Sandbox::Env {
+            allow: vec!["PATH".to_string(), "GRALPH_SANDBOX_TEST_UNSET".to_string()],
+        };
+        let cmd = sandbox.wrap(backend_command(), Path::new("/work"));
+
+        assert_eq!(cmd.get_program(), "claude");
+        assert_eq!(args(&cmd), vec!["-p", "do it"]);
+        let mut names = env_names(&cmd);
+        names.sort();
+        assert_eq!(names, vec!["IS_SANDBOX", "PATH"]);
+    }
+
+    #[test]
+    fn firejail_runs_the_command_after_its_own_args() {
+        let sandbox = Sandbox::Firejail {
+            allow: Vec::new(),
+            args: vec!["--net=none".to_string()],
+        };
+        let cmd = sandbox.wrap(backend_command(), Path::new("/work"));
+
+        assert_eq!(cmd.get_program(), "firejail");
+        assert_eq!(
+            args(&cmd),
+            vec!["--quiet", "--net=none", "--", "claude", "-p", "do it"]
+        );
+        assert_eq!(env_names(&cmd), vec!["IS_SANDBOX"]);
+    }
+
+    #[test]
+    fn docker_mounts_the_working_dir_and_passes_env_by_name() {
+        let sandbox = Sandbox::Docker {
+            image: "gralph/claude:latest".to_string(),
+            allow: vec!["PATH".to_string()],
+            args: vec!["--network=host".to_string()],
+        };
+        let cmd = sandbox.wrap(backend_command(), Path::new("/work"));
+
+        assert_eq!(cmd.get_program(), "docker");
+        let args = args(&cmd);
+        assert_eq!(args[..6], ["run", "--rm", "-i", "-v", "/work:/work", "-w"]);
+        assert!(!args.contains(&"PATH".to_string()));
+        assert!(
+            args.windows(2)
+                .any(|pair| pair == ["-e".to_string(), "IS_SANDBOX".to_string()])
+        );
+        assert!(args.ends_with(&[
+            "--network=host".to_string(),
+            "gralph/claude:latest".to_string(),
+            "claude".to_string(),
+            "-p".to_string(),
+            "do it".to_string(),
+        ]));
+        assert!(!args.iter().any(|arg| arg.contains("IS_SANDBOX=")));
+    }
+
+    #[test]
+    fn check_installed_looks_for_the_sandbox_program() {
+        assert!(Sandbox::None.check_installed(|| true));
+        assert!(!Sandbox::Env { allow: Vec::new() }.check_installed(|| false));
+        let docker = Sandbox::Docker {
+            image: "img".to_string(),
+            allow: Vec::new(),
+            args: Vec::new(),
+        };
+        assert_eq!(
+            docker.check_installed(|| panic!("the CLI lives in the image")),
+            command_in_path("docker")
+        );
+    }
+}
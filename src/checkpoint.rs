@@ -0,0 +1,255 @@
+//! Per-iteration checkpoints for crash recovery.
+//!
+//! Before the backend runs, the loop writes `.gralph/checkpoints/<session>.json`
+//! with the iteration number, a hash of the prompt, and `HEAD`, and marks it
+//! finished once the iteration's changes have been handled. A resumed loop
+//! starts from the checkpoint instead of iteration 1; when the last iteration
+//! never finished, its uncommitted changes are rolled back first. The
+//! checkpoint also carries the time and usage spent so far, so budget caps
+//! and `--max-duration` count the whole run, not just the part after a
+//! restart.
+
+use crate::backend::Usage;
+use serde::{Deserialize, Serialize};
+use std::collections::hash_map::DefaultHasher;
+use std::error::Error;
+use std::fmt;
+use std::fs;
+use std::hash::{Hash, Hasher};
+use std::io;
+use std::path::{Path, PathBuf};
+use std::time::Duration;
+
+/// Directory under `.gralph/` that holds one checkpoint per session.
+pub const CHECKPOINT_DIR: &str = "checkpoints";
+
+#[derive(Debug)]
+pub enum CheckpointError {
+    Io {
+        path: PathBuf,
+        source: io::Error,
+    },
+    Json {
+        path: PathBuf,
+        source: serde_json::Error,
+    },
+}
+
+impl fmt::Display for CheckpointError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            CheckpointError::Io { path, source } => {
+                write!(f, "checkpoint io error at {}: {}", path.display(), source)
+            }
+            CheckpointError::Json { path, source } => {
+                write!(f, "checkpoint json error at {}: {}", path.display(), source)
+            }
+        }
+    }
+}
+
+impl Error for CheckpointError {
+    fn source(&self) -> Option<&(dyn Error + 'static)> {
+        match self {
+            CheckpointError::Io { source, .. } => Some(source),
+            CheckpointError::Json { source, .. } => Some(source),
+        }
+    }
+}
+
+/// The iteration a loop was on when it last wrote its checkpoint.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Checkpoint {
+    pub session: String,
+    /// Task file the loop was working through, relative to the project.
+    pub task_file: String,
+    pub iteration: u32,
+    /// Hash of the rendered prompt, from [`prompt_hash`].
+    pub prompt_hash: String,
+    /// `HEAD` before the iteration, if the project is a git repo.
+    #[serde(default)]
+    pub git_commit: Option<String>,
+    /// Whether the tree had no uncommitted changes before the iteration.
+    /// Only then can the iteration's leftovers be discarded safely.
+    #[serde(default)]
+    pub clean: bool,
+    /// Set once the iteration ended normally.
+    #[serde(default)]
+    pub finished: bool,
+    /// Unix seconds when the iteration started.
+    pub started_at: u64,
+    /// Seconds the loop had run, including earlier runs it resumed from:
+    /// before the iteration, or through it once `finished`.
+    #[serde(default)]
+    pub elapsed_secs: u64,
+    /// Usage of the iterations run so far, counted the same way.
+    #[serde(default)]
+    pub input_tokens: u64,
+    #[serde(default)]
+    pub output_tokens: u64,
+    #[serde(default)]
+    pub cost_usd: Option<f64>,
+}
+
+impl Checkpoint {
+    /// The iteration a resumed loop runs first: the same one when it was cut
+    /// short, otherwise the one after.
+    pub fn next_iteration(&self) -> u32 {
+        if self.finished {
+            self.iteration + 1
+        } else {
+            self.iteration
+        }
+    }
+
+    /// Usage a resumed loop starts from.
+    pub fn spent(&self) -> Usage {
+        Usage {
+            input_tokens: self.input_tokens,
+            output_tokens: self.output_tokens,
+            cost_usd: self.cost_usd,
+        }
+    }
+
+    /// Records the usage and running time spent so far.
+    pub fn record_spent(&mut self, usage: &Usage, elapsed: Duration) {
+        self.input_tokens = usage.input_tokens;
+        self.output_tokens = usage.output_tokens;
+        self.cost_usd = usage.cost_usd;
+        self.elapsed_secs = elapsed.as_secs();
+    }
+}
+
+pub fn checkpoint_path(gralph_dir: &Path, session: &str) -> PathBuf {
+    gralph_dir
+        .join(CHECKPOINT_DIR)
+        .join(format!("{}.json", session))
+}
+
+/// Reads a session's checkpoint. A missing file is `Ok(None)`.
+pub fn load(gralph_dir: &Path, session: &str) -> Result<Option<Checkpoint>, CheckpointError> {
+    let path = checkpoint_path(gralph_dir, session);
+    let contents = match fs::read_to_string(&path) {
+        Ok(contents) => contents,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => return Ok(None),
+        Err(source) => return Err(CheckpointError::Io { path, source }),
+    };
+    serde_json::from_str(&contents)
+        .map(Some)
+        .map_err(|source| CheckpointError::Json { path, source })
+}
+
+/// Writes the checkpoint through a temporary file, so a crash mid-write
+/// leaves the previous checkpoint in place.
+pub fn save(gralph_dir: &Path, checkpoint: &Checkpoint) -> Result<(), CheckpointError> {
+    let path = checkpoint_path(gralph_dir, &checkpoint.session);
+    let io_error = |path: &Path| {
+        let path = path.to_path_buf();
+        move |source| CheckpointError::Io { path, source }
+    };
+    if let Some(parent) = path.parent() {
+        fs::create_dir_all(parent).map_err(io_error(parent))?;
+    }
+    let json =
+        serde_json::to_string_pretty(checkpoint).map_err(|source| CheckpointError::Json {
+            path: path.clone(),
+            source,
+        })?;
+    let tmp = path.with_extension("json.tmp");
+    fs::write(&tmp, json).map_err(io_error(&tmp))?;
+    fs::rename(&tmp, &path).map_err(io_error(&path))
+}
+
+/// Removes a session's checkpoint, if there is one.
+pub fn clear(gralph_dir: &Path, session: &str) -> Result<(), CheckpointError> {
+    let path = checkpoint_path(gralph_dir, session);
+    match fs::remove_file(&path) {
+        Ok(()) => Ok(()),
+        Err(err) if err.kind() == io::ErrorKind::NotFound => Ok(()),
+        Err(source) => Err(CheckpointError::Io { path, source }),
+    }
+}
+
+/// Short hex digest of a prompt, to tell whether a restarted iteration sees
+/// the same input as the attempt that was cut short.
+pub fn prompt_hash(prompt: &str) -> String {
+    let mut hasher = DefaultHasher::new();
+    prompt.hash(&mut hasher);
+    format!("{:016x}", hasher.finish())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn checkpoint(iteration: u32, finished: bool) -> Checkpoint {
+        Checkpoint {
+            session: "demo".to_string(),
+            task_file: "PRD.md".to_string(),
+            iteration,
+            prompt_hash: prompt_hash("prompt"),
+            git_commit: Some("abc123".to_string()),
+            clean: true,
+            finished,
+            started_at: 1_700_000_000,
+            elapsed_secs: 0,
+            input_tokens: 0,
+            output_tokens: 0,
+            cost_usd: None,
+        }
+    }
+
+    #[test]
+    fn save_load_and_clear_round_trip() {
+        let temp = tempfile::tempdir().unwrap();
+        let dir = temp.path();
+        assert_eq!(load(dir, "demo").unwrap(), None);
+
+        save(dir, &checkpoint(3, false)).unwrap();
+        assert!(dir.join("checkpoints/demo.json").is_file());
+        assert_eq!(load(dir, "demo").unwrap(), Some(checkpoint(3, false)));
+
+        save(dir, &checkpoint(3, true)).unwrap();
+        assert!(load(dir, "demo").unwrap().unwrap().finished);
+
+        clear(dir, "demo").unwrap();
+        clear(dir, "demo").unwrap();
+        assert_eq!(load(dir, "demo").unwrap(), None);
+    }
+
+    #[test]
+    fn next_iteration_repeats_unfinished_iterations() {
+        assert_eq!(checkpoint(4, false).next_iteration(), 4);
+        assert_eq!(checkpoint(4, true).next_iteration(), 5);
+        assert_eq!(prompt_hash("a"), prompt_hash("a"));
+        assert_ne!(prompt_hash("a"), prompt_hash("b"));
+    }
+
+    #[test]
+    fn spent_round_trips_usage_and_older_checkpoints_default_to_none() {
+        let mut saved = checkpoint(2, true);
+        let usage = Usage {
+            input_tokens: 1200,
+            output_tokens: 300,
+            cost_usd: Some(0.75),
+        };
+        saved.record_spent(&usage, Duration::from_secs(5400));
+        assert_eq!(saved.spent(), usage);
+        assert_eq!(saved.elapsed_secs, 5400);
+
+        let legacy: Checkpoint = serde_json::from_str(
+            r#"{"session":"demo","task_file":"PRD.md","iteration":1,"prompt_hash":"","started_at":0}"#,
+        )
+        .unwrap();
+        assert_eq!(legacy.spent(), Usage::default());
+        assert_eq!(legacy.elapsed_secs, 0);
+    }
+
+    #[test]
+    fn load_rejects_corrupt_checkpoint() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::create_dir_all(temp.path().join(CHECKPOINT_DIR)).unwrap();
+        fs::write(checkpoint_path(temp.path(), "demo"), "{").unwrap();
+        assert!(load(temp.path(), "demo").is_err());
+    }
+}
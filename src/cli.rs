@@ -9,80 +9,207 @@ pub const ASCII_BANNER: &str = r#"  ___  ____    __    __    ____  _   _
 
 const ROOT_AFTER_HELP: &str = r#"START OPTIONS:
   --name, -n          Session name (default: directory name)
+  --workspace         Run in a workspace package instead of the repo root
+  --profile           Apply the config profile profiles.<name> (also GRALPH_PROFILE)
   --max-iterations    Max iterations before giving up (default: 30)
-  --task-file, -f     Task file path (default: PRD.md)
+  --task-file, -f     Task file, comma list, or glob like PRD-*.md (default: PRD.md)
   --completion-marker Completion promise text (default: COMPLETE)
-  --backend, -b       AI backend (default: claude). See `gralph backends`
+  --backend, -b       AI backend, or a fallback chain like claude,opencode (default: claude)
   --model, -m         Model override (format depends on backend)
-  --variant           Model variant override (backend-specific)
+  --variant           Reasoning or thinking level (backend-specific, see `gralph backends`)
+  --backend-arg       Extra argument for the backend CLI (repeatable)
+  --max-cost          Stop once reported spend reaches this many USD (default: config or none)
+  --max-tokens        Stop once reported tokens reach this total (default: config or none)
+  --max-duration      Stop after this much wall-clock time, e.g. 90m or 4h (default: none)
   --prompt-template   Path to custom prompt template file
+  --review-backend    Backend that must confirm completion against each task's DoD
+  --review-model      Model override for the review backend
   --webhook           Notification webhook URL
   --no-worktree       Disable automatic worktree creation
+  --worktree          Run each task in .worktrees/task-<ID>, merge back when done
   --no-tmux           Run in foreground (blocks; logs in .gralph/<session>.log)
+  --daemon, --detach  Detach from the terminal and write .gralph/<session>.pid
   --strict-prd        Validate PRD before starting the loop
-  --dry-run           Print the next task block and resolved prompt
+  --allow-concurrent  Start even if another loop is running in the same directory
+  --dry-run           Print the next task block, resolved prompt, and backend command
 
 STEP OPTIONS:
   --name, -n          Session name (default: directory name)
   --max-iterations    Max iterations before giving up (default: 30)
-  --task-file, -f     Task file path (default: PRD.md)
+  --task-file, -f     Task file, comma list, or glob like PRD-*.md (default: PRD.md)
   --completion-marker Completion promise text (default: COMPLETE)
   --backend, -b       AI backend (default: claude). See `gralph backends`
   --model, -m         Model override (format depends on backend)
-  --variant           Model variant override (backend-specific)
+  --variant           Reasoning or thinking level (backend-specific, see `gralph backends`)
+  --backend-arg       Extra argument for the backend CLI (repeatable)
   --prompt-template   Path to custom prompt template file
   --no-worktree       Disable automatic worktree creation
   --strict-prd        Validate PRD before running the step
 
+RUN-TASK OPTIONS:
+  --dir               Project directory (default: current)
+  --name, -n          Session name (default: <directory>-<task id>)
+  --task-file, -f     Task file, comma list, or glob like PRD-*.md (default: PRD.md)
+  --backend, -b       AI backend (default: claude)
+  --model, -m         Model override (format depends on backend)
+  --variant           Reasoning or thinking level (backend-specific, see `gralph backends`)
+  --backend-arg       Extra argument for the backend CLI (repeatable)
+  --prompt-template   Path to custom prompt template file
+
 PRD OPTIONS:
   --dir               Project directory (default: current)
   --output, -o        Output PRD file path (default: PRD.generated.md)
   --goal              Short description of what to build
+  --template          Named PRD template from ~/.config/gralph/templates
+  --stack-depth       Directory levels scanned for stack detection (default: 2)
+  --workspace         Scope `prd create` to a workspace package
+  --profile           Apply the config profile profiles.<name> to `prd create`
   --constraints       Constraints or non-functional requirements
   --context           Extra context files (comma-separated)
   --sources           External URLs or references (comma-separated)
+  --from-todos        Seed `prd create` tasks from TODO and FIXME comments
+  --critique          Critique and revise the `prd create` draft in two more backend passes
+  --quiet             Hide backend output and progress during `prd create`
   --backend, -b        Backend for PRD generation (default: config/default)
   --model, -m          Model override for PRD generation
-  --variant           Model variant override (backend-specific)
+  --variant           Reasoning or thinking level (backend-specific, see `gralph backends`)
   --allow-missing-context Allow missing Context Bundle paths
   --multiline         Enable multiline prompts (interactive)
   --no-interactive    Disable interactive prompts
   --interactive       Force interactive prompts
   --force             Overwrite existing output file
+  --output-dir        Directory for `prd split` files (default: PRD directory)
+  --dry-run           Report `prd fix`, `prd renumber`, or `prd merge` changes without writing, or print the `prd create` prompt and backend command
+  --format            Output format for `prd parse`: json or yaml (default: json)
+  --id, --summary, --dod  Task fields for `prd add-task` (prompted when missing)
+  --checklist         Checklist item for `prd add-task` (repeatable)
+  --deps              Dependencies for `prd add-task` (comma-separated)
+  --issue             Linked tracker issue for `prd add-task`
 
 INIT OPTIONS:
   --dir               Target directory (default: current)
   --force             Overwrite existing files
+  --git-hooks         Install a pre-commit hook that runs `gralph prd check`
+
+SERVICE INSTALL OPTIONS:
+  --name, -n            Session name for a loop service (default: directory name)
+  --manager             systemd or launchd (default: launchd on macOS, systemd elsewhere)
+  --output              Write the file here instead of the per-user service directory
+  --print               Print the file instead of writing it
+  -- <ARGS>             Extra arguments for `gralph server` or `gralph start`
+
+QUEUE OPTIONS:
+  --name, -n            Session name for `queue add` (default: directory name)
+  -- <ARGS>             Extra arguments for `gralph start` (`queue add`)
+  --concurrency         Entries `queue run` runs at a time (default: 1)
+
+CONFIG OPTIONS:
+  --project             `config set`/`edit` write .gralph.yaml here (default)
+  --global              `config set`/`edit` write ~/.config/gralph/config.yaml
+  --default             `config set`/`edit` write the default config (admins)
 
 SERVER OPTIONS:
   --host, -H            Host/IP to bind to (default: 127.0.0.1)
   --port, -p            Port number (default: 8080)
   --token, -t           Authentication token (required for non-localhost)
-  --open                Disable token requirement (use with caution)
+  --open                Allow requests from any origin (requires a token)
+  --tls-cert            Serve HTTPS with this PEM certificate chain
+  --tls-key             PEM private key for --tls-cert
+  --tls-client-ca       Require client certificates signed by this PEM CA (mTLS)
+  --tokens-file         YAML file of named tokens with read or control scope
 
 DOCTOR OPTIONS:
   --dir                 Project directory to check (default: current)
 
+BACKENDS OPTIONS:
+  --models              List models from each installed backend (cached for 1h)
+  --verbose             Check that each installed backend can authenticate
+
+LOGS OPTIONS:
+  --follow              Follow log output
+  --raw                 Show raw backend output
+  --iteration           Only lines from iteration N
+  --grep                Only lines matching a regular expression
+  --since               Only lines logged within a duration (e.g. 30m, 2h, 1d)
+
+STATUS OPTIONS:
+  --verbose             Show log paths and last error line
+  --local               Only sessions on this machine (skip configured remotes)
+  --global              Sessions from every project (default: the current project)
+  --tmux                Check tmux sessions against state and fix mismatches
+
+HISTORY OPTIONS:
+  --session             Only runs of this session
+  --since               Only runs finished within a duration (e.g. 12h, 7d)
+
+DIFF OPTIONS:
+  --stat                Only the files changed and line counts
+  --task                Only commits whose message names this task ID
+
+WATCH OPTIONS:
+  --name, -n            Session whose log is tailed (default: first running)
+  --interval            Refresh interval in seconds (default: 2)
+  --lines               Log lines to show (default: 15)
+  --once                Render a single frame and exit
+
 CLEANUP OPTIONS:
   --remove              Delete stale sessions from state
   --purge               Delete all sessions from state (explicit opt-in)
 
+CLEAN OPTIONS:
+  --dir                 Project whose logs and worktrees are cleaned (default: current)
+  --older-than          Only items older than this (default: 7d; 0 for all)
+  --dry-run             List what would be removed
+
+GLOBAL OPTIONS:
+  --json                Emit JSON for status, backends, config list, prd check/fix, logs, history, queue list
+  --offline             No network calls (also GRALPH_OFFLINE=1); remote backends fail fast
+  --record <dir>        Record backend iterations to a cassette (also GRALPH_RECORD_DIR)
+  --replay <dir>        Replay iterations from a cassette, no backend needed (also GRALPH_REPLAY_DIR)
+
 EXAMPLES:
   gralph start .
   gralph start ~/project --name myapp --max-iterations 50
   gralph start . --dry-run
+  gralph start . --worktree
+  gralph start . --workspace web
+  gralph start . --profile cheap
+  gralph start . --backend codex --review-backend claude
   gralph step .
+  gralph run-task COR-3 --dir .
   gralph status
+  gralph status --json
+  gralph history --since 7d
   gralph logs myapp --follow
+  gralph logs myapp --iteration 7 --grep 'error|panic'
+  gralph diff myapp --task COR-3
+  gralph attach myapp
+  gralph watch --name myapp
+  gralph pause myapp
+  gralph unpause myapp
   gralph stop myapp
   gralph doctor --dir .
+  gralph backends --models
   gralph cleanup
+  gralph prd add-task --id GO-42 --summary "Add retries" --dod "Retries are covered by tests" --deps GO-40 --context README.md
   gralph prd create --dir . --output PRD.new.md --goal "Add a billing dashboard"
+  gralph prd create --template cli --goal "Add a config subcommand"
+  gralph prd templates add cli docs/PRD.cli.md
+  gralph prd fix PRD.md --dry-run
+  gralph prd graph PRD.md
+  gralph prd parse PRD.md --format json
+  gralph prd split PRD.md
   gralph init --dir .
   gralph worktree create C-1
   gralph worktree finish C-1
   gralph verifier --dir .
   gralph server --host 0.0.0.0 --port 8080
+  gralph server --host 0.0.0.0 --tls-cert cert.pem --tls-key key.pem
+  gralph start . --daemon
+  gralph service install loop ~/projects/myapp -- --backend codex
+  gralph service install server -- --port 9000
+  gralph queue add ~/projects/api -- --backend codex --max-iterations 40
+  gralph queue run --concurrency 2
 "#;
 
 #[derive(Parser, Debug)]
@@ -95,6 +222,35 @@ EXAMPLES:
     after_help = ROOT_AFTER_HELP
 )]
 pub struct Cli {
+    #[arg(
+        long,
+        global = true,
+        action = clap::ArgAction::SetTrue,
+        help = "Emit machine-readable JSON (status, backends, config list, prd check/fix, logs, history, queue list)"
+    )]
+    pub json: bool,
+    #[arg(
+        long,
+        global = true,
+        action = clap::ArgAction::SetTrue,
+        help = "Disable network calls: skip source search, webhooks, and update checks; refuse remote backends"
+    )]
+    pub offline: bool,
+    #[arg(
+        long,
+        global = true,
+        value_name = "DIR",
+        conflicts_with = "replay",
+        help = "Record backend iterations (prompt, output, file changes) to a cassette directory"
+    )]
+    pub record: Option<PathBuf>,
+    #[arg(
+        long,
+        global = true,
+        value_name = "DIR",
+        help = "Replay backend iterations from a cassette directory instead of calling the backend"
+    )]
+    pub replay: Option<PathBuf>,
     #[command(subcommand)]
     pub command: Option<Command>,
 }
@@ -105,72 +261,184 @@ pub enum Command {
     Start(StartArgs),
     #[command(about = "Run exactly one iteration")]
     Step(StepArgs),
+    #[command(about = "Run a single task block once")]
+    RunTask(RunTaskArgs),
     #[command(about = "Stop a running loop")]
     Stop(StopArgs),
-    #[command(about = "Show status of all loops")]
+    #[command(about = "Pause a running loop after the current iteration")]
+    Pause(PauseArgs),
+    #[command(about = "Continue a paused loop")]
+    Unpause(PauseArgs),
+    #[command(about = "Show status of all loops", visible_alias = "sessions")]
     Status(StatusArgs),
+    #[command(about = "Live dashboard of all loops")]
+    Watch(WatchArgs),
     #[command(about = "Clean up stale sessions")]
     Cleanup(CleanupArgs),
+    #[command(about = "Remove finished sessions, old logs, and leftover worktrees")]
+    Clean(CleanArgs),
     #[command(about = "Run local diagnostics")]
     Doctor(DoctorArgs),
     #[command(about = "View logs for a loop")]
     Logs(LogsArgs),
+    #[command(about = "Attach to a loop's tmux session, or follow its log")]
+    Attach(AttachArgs),
+    #[command(about = "Show finished sessions from the history log")]
+    History(HistoryArgs),
+    #[command(about = "Show changes made by a session")]
+    Diff(DiffArgs),
+    #[command(about = "Bundle a session's state, logs, PRD, and config into a tarball")]
+    Export(ExportArgs),
+    #[command(about = "Register a session from a bundle made by gralph export")]
+    Import(ImportArgs),
     #[command(about = "Resume crashed/stopped loops")]
     Resume(ResumeArgs),
-    #[command(about = "Initialize shared context files")]
+    #[command(about = "Set up a project: config, context files, PRD template")]
     Init(InitArgs),
     #[command(about = "Generate or validate PRDs")]
     Prd(PrdArgs),
     #[command(about = "Manage task worktrees")]
     Worktree(WorktreeArgs),
     #[command(about = "List available AI backends")]
-    Backends,
+    Backends(BackendsArgs),
     #[command(about = "Manage configuration")]
     Config(ConfigArgs),
     #[command(about = "Run verifier quality gates")]
     Verifier(VerifierArgs),
     #[command(about = "Start status API server")]
     Server(ServerArgs),
+    #[command(about = "Serve gralph tools to MCP clients over stdio")]
+    Mcp,
+    #[command(about = "Run the daemon that supervises background loops")]
+    Daemon(DaemonArgs),
+    #[command(about = "Generate systemd or launchd service files")]
+    Service(ServiceArgs),
+    #[command(about = "Queue projects and run them a few at a time")]
+    Queue(QueueArgs),
+    #[command(about = "Retry notifications that failed to send")]
+    Notify(NotifyArgs),
+    #[command(about = "Manage the session state")]
+    State(StateArgs),
     #[command(about = "Show version")]
     Version,
     #[command(about = "Install the latest release")]
     Update,
     #[command(hide = true)]
     RunLoop(RunLoopArgs),
+    #[command(name = "__complete", hide = true)]
+    Complete(CompleteArgs),
 }
 
 #[derive(Args, Debug, Clone)]
 pub struct StartArgs {
     #[arg(value_name = "DIR", help = "Project directory to run the loop in")]
     pub dir: PathBuf,
+    #[arg(
+        long,
+        value_name = "NAME",
+        help = "Apply the config profile profiles.<NAME> over the loaded config"
+    )]
+    pub profile: Option<String>,
+    #[arg(
+        long,
+        help = "Run the loop in this workspace package instead of the repo root"
+    )]
+    pub workspace: Option<String>,
     #[arg(short, long, help = "Session name (default: directory name)")]
     pub name: Option<String>,
     #[arg(long, help = "Max iterations before giving up (default: 30)")]
     pub max_iterations: Option<u32>,
-    #[arg(short = 'f', long, help = "Task file path (default: PRD.md)")]
+    #[arg(
+        long,
+        value_name = "USD",
+        help = "Stop as budget_exceeded once reported spend reaches this many dollars"
+    )]
+    pub max_cost: Option<f64>,
+    #[arg(
+        long,
+        value_name = "TOKENS",
+        help = "Stop as budget_exceeded once reported tokens reach this total"
+    )]
+    pub max_tokens: Option<u64>,
+    #[arg(
+        long,
+        value_name = "DURATION",
+        help = "Stop as deadline_exceeded after this long, e.g. 90m or 4h"
+    )]
+    pub max_duration: Option<String>,
+    #[arg(
+        short = 'f',
+        long,
+        help = "Task file path, comma-separated list, or glob such as PRD-*.md (default: PRD.md)"
+    )]
     pub task_file: Option<String>,
     #[arg(long, help = "Completion promise text (default: COMPLETE)")]
     pub completion_marker: Option<String>,
-    #[arg(short = 'b', long, help = "AI backend (default: claude)")]
+    #[arg(
+        short = 'b',
+        long,
+        help = "AI backend, or a comma-separated fallback chain (default: claude)"
+    )]
     pub backend: Option<String>,
     #[arg(short = 'm', long, help = "Model override (format depends on backend)")]
     pub model: Option<String>,
-    #[arg(long, help = "Model variant override (backend-specific)")]
+    #[arg(
+        long,
+        help = "Reasoning or thinking level (backend-specific, see `gralph backends`)"
+    )]
     pub variant: Option<String>,
+    #[arg(
+        long = "backend-arg",
+        value_name = "ARG",
+        allow_hyphen_values = true,
+        help = "Extra argument for the backend CLI (repeatable)"
+    )]
+    pub backend_args: Vec<String>,
     #[arg(long, help = "Path to custom prompt template file")]
     pub prompt_template: Option<PathBuf>,
+    #[arg(
+        long,
+        help = "Backend that must confirm a completion claim against each task's DoD and Checklist"
+    )]
+    pub review_backend: Option<String>,
+    #[arg(
+        long,
+        requires = "review_backend",
+        help = "Model override for the review backend"
+    )]
+    pub review_model: Option<String>,
     #[arg(long, help = "Notification webhook URL")]
     pub webhook: Option<String>,
     #[arg(long, action = clap::ArgAction::SetTrue, help = "Disable automatic worktree creation")]
     pub no_worktree: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Run each task in .worktrees/task-<ID> and merge it back when done"
+    )]
+    pub worktree: bool,
     #[arg(
         long,
         action = clap::ArgAction::SetTrue,
         help = "Run in foreground (blocks; logs in .gralph/<session>.log)"
     )]
     pub no_tmux: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        visible_alias = "detach",
+        conflicts_with = "no_tmux",
+        help = "Detach the loop from the terminal and write .gralph/<session>.pid"
+    )]
+    pub daemon: bool,
     #[arg(long, action = clap::ArgAction::SetTrue, help = "Validate PRD before starting the loop")]
     pub strict_prd: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Start even if another loop is running in the same directory"
+    )]
+    pub allow_concurrent: bool,
     #[arg(long, action = clap::ArgAction::SetTrue, help = "Print the next task block and resolved prompt")]
     pub dry_run: bool,
 }
@@ -183,7 +451,11 @@ pub struct StepArgs {
     pub name: Option<String>,
     #[arg(long, help = "Max iterations before giving up (default: 30)")]
     pub max_iterations: Option<u32>,
-    #[arg(short = 'f', long, help = "Task file path (default: PRD.md)")]
+    #[arg(
+        short = 'f',
+        long,
+        help = "Task file path, comma-separated list, or glob such as PRD-*.md (default: PRD.md)"
+    )]
     pub task_file: Option<String>,
     #[arg(long, help = "Completion promise text (default: COMPLETE)")]
     pub completion_marker: Option<String>,
@@ -191,8 +463,18 @@ pub struct StepArgs {
     pub backend: Option<String>,
     #[arg(short = 'm', long, help = "Model override (format depends on backend)")]
     pub model: Option<String>,
-    #[arg(long, help = "Model variant override (backend-specific)")]
+    #[arg(
+        long,
+        help = "Reasoning or thinking level (backend-specific, see `gralph backends`)"
+    )]
     pub variant: Option<String>,
+    #[arg(
+        long = "backend-arg",
+        value_name = "ARG",
+        allow_hyphen_values = true,
+        help = "Extra argument for the backend CLI (repeatable)"
+    )]
+    pub backend_args: Vec<String>,
     #[arg(long, help = "Path to custom prompt template file")]
     pub prompt_template: Option<PathBuf>,
     #[arg(long, action = clap::ArgAction::SetTrue, help = "Disable automatic worktree creation")]
@@ -201,6 +483,42 @@ pub struct StepArgs {
     pub strict_prd: bool,
 }
 
+#[derive(Args, Debug)]
+pub struct RunTaskArgs {
+    #[arg(value_name = "ID", help = "Task ID to run (e.g. COR-1)")]
+    pub id: String,
+    #[arg(long, help = "Project directory (default: current)")]
+    pub dir: Option<PathBuf>,
+    #[arg(short, long, help = "Session name (default: <directory>-<task id>)")]
+    pub name: Option<String>,
+    #[arg(
+        short = 'f',
+        long,
+        help = "Task file path, comma-separated list, or glob such as PRD-*.md (default: PRD.md)"
+    )]
+    pub task_file: Option<String>,
+    #[arg(long, help = "Completion promise text (default: COMPLETE)")]
+    pub completion_marker: Option<String>,
+    #[arg(short = 'b', long, help = "AI backend (default: claude)")]
+    pub backend: Option<String>,
+    #[arg(short = 'm', long, help = "Model override (format depends on backend)")]
+    pub model: Option<String>,
+    #[arg(
+        long,
+        help = "Reasoning or thinking level (backend-specific, see `gralph backends`)"
+    )]
+    pub variant: Option<String>,
+    #[arg(
+        long = "backend-arg",
+        value_name = "ARG",
+        allow_hyphen_values = true,
+        help = "Extra argument for the backend CLI (repeatable)"
+    )]
+    pub backend_args: Vec<String>,
+    #[arg(long, help = "Path to custom prompt template file")]
+    pub prompt_template: Option<PathBuf>,
+}
+
 #[derive(Args, Debug, Clone)]
 pub struct RunLoopArgs {
     #[arg(value_name = "DIR")]
@@ -210,6 +528,12 @@ pub struct RunLoopArgs {
     #[arg(long)]
     pub max_iterations: Option<u32>,
     #[arg(long)]
+    pub max_cost: Option<f64>,
+    #[arg(long)]
+    pub max_tokens: Option<u64>,
+    #[arg(long)]
+    pub max_duration: Option<String>,
+    #[arg(long)]
     pub task_file: Option<String>,
     #[arg(long)]
     pub completion_marker: Option<String>,
@@ -219,40 +543,218 @@ pub struct RunLoopArgs {
     pub model: Option<String>,
     #[arg(long)]
     pub variant: Option<String>,
+    #[arg(long = "backend-arg", allow_hyphen_values = true)]
+    pub backend_args: Vec<String>,
     #[arg(long)]
     pub prompt_template: Option<PathBuf>,
     #[arg(long)]
+    pub review_backend: Option<String>,
+    #[arg(long)]
+    pub review_model: Option<String>,
+    #[arg(long)]
     pub webhook: Option<String>,
     #[arg(long, action = clap::ArgAction::SetTrue, help = "Disable automatic worktree creation")]
     pub no_worktree: bool,
     #[arg(long, action = clap::ArgAction::SetTrue)]
+    pub worktree: bool,
+    #[arg(long, action = clap::ArgAction::SetTrue)]
     pub strict_prd: bool,
+    #[arg(long)]
+    pub pid_file: Option<PathBuf>,
+    #[arg(long, action = clap::ArgAction::SetTrue)]
+    pub resume: bool,
+    #[arg(long)]
+    pub profile: Option<String>,
+    #[arg(long, action = clap::ArgAction::SetTrue)]
+    pub allow_concurrent: bool,
+}
+
+#[derive(Args, Debug)]
+pub struct CompleteArgs {
+    #[arg(
+        value_name = "KIND",
+        value_parser = ["sessions", "backends", "config-keys"],
+        help = "Values to list for shell completion"
+    )]
+    pub kind: String,
 }
 
 #[derive(Args, Debug)]
 pub struct StopArgs {
-    #[arg(value_name = "NAME", help = "Session name")]
+    #[arg(
+        value_name = "NAME",
+        help = "Session name (prompted with a picker on a terminal when omitted)"
+    )]
     pub name: Option<String>,
     #[arg(short, long, action = clap::ArgAction::SetTrue, help = "Stop all loops")]
     pub all: bool,
 }
 
+#[derive(Args, Debug)]
+pub struct PauseArgs {
+    #[arg(value_name = "NAME", help = "Session name")]
+    pub name: String,
+}
+
 #[derive(Args, Debug)]
 pub struct StatusArgs {
-    #[arg(long, action = clap::ArgAction::SetTrue, conflicts_with = "verbose", help = "Print JSON output")]
-    pub json: bool,
-    #[arg(long, action = clap::ArgAction::SetTrue, conflicts_with = "json", help = "Show log paths and last error line")]
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "Show log paths and last error line")]
     pub verbose: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Only show sessions on this machine (skip configured remotes)"
+    )]
+    pub local: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Show sessions from every project, not just the current one"
+    )]
+    pub global: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Check tmux sessions against state records and fix mismatches"
+    )]
+    pub tmux: bool,
+}
+
+#[derive(Args, Debug)]
+pub struct AttachArgs {
+    #[arg(
+        value_name = "NAME",
+        help = "Session name (prompted with a picker on a terminal when omitted)"
+    )]
+    pub name: Option<String>,
+}
+
+#[derive(Args, Debug)]
+pub struct WatchArgs {
+    #[arg(
+        short,
+        long,
+        help = "Session selected at start (default: first running)"
+    )]
+    pub name: Option<String>,
+    #[arg(long, default_value_t = 2, help = "Refresh interval in seconds")]
+    pub interval: u64,
+    #[arg(long, default_value_t = 15, help = "Log lines to show")]
+    pub lines: usize,
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "Render a single frame and exit")]
+    pub once: bool,
 }
 
 #[derive(Args, Debug)]
 pub struct LogsArgs {
-    #[arg(value_name = "NAME", help = "Session name")]
-    pub name: String,
+    #[arg(
+        value_name = "NAME",
+        help = "Session name (prompted with a picker on a terminal when omitted)"
+    )]
+    pub name: Option<String>,
     #[arg(long, action = clap::ArgAction::SetTrue, help = "Follow log output")]
     pub follow: bool,
     #[arg(long, action = clap::ArgAction::SetTrue, help = "Show raw backend output")]
     pub raw: bool,
+    #[arg(
+        long,
+        value_name = "N",
+        conflicts_with_all = ["follow", "raw"],
+        help = "Only show lines from iteration N"
+    )]
+    pub iteration: Option<u32>,
+    #[arg(
+        long,
+        value_name = "REGEX",
+        conflicts_with = "follow",
+        help = "Only show lines matching a regular expression"
+    )]
+    pub grep: Option<String>,
+    #[arg(
+        long,
+        value_name = "DURATION",
+        conflicts_with_all = ["follow", "raw"],
+        help = "Only show lines logged within a duration (e.g. 30m, 2h, 1d)"
+    )]
+    pub since: Option<String>,
+}
+
+#[derive(Args, Debug)]
+pub struct HistoryArgs {
+    #[arg(long, value_name = "NAME", help = "Only show runs of this session")]
+    pub session: Option<String>,
+    #[arg(
+        long,
+        value_name = "DURATION",
+        help = "Only show runs finished within a duration (e.g. 12h, 7d)"
+    )]
+    pub since: Option<String>,
+}
+
+#[derive(Args, Debug)]
+pub struct DiffArgs {
+    #[arg(
+        value_name = "NAME",
+        help = "Session name (prompted with a picker on a terminal when omitted)"
+    )]
+    pub name: Option<String>,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Only show the files changed and line counts"
+    )]
+    pub stat: bool,
+    #[arg(
+        long,
+        value_name = "ID",
+        help = "Only show commits whose message names this task ID"
+    )]
+    pub task: Option<String>,
+}
+
+#[derive(Args, Debug)]
+pub struct ExportArgs {
+    #[arg(
+        value_name = "NAME",
+        help = "Session name (prompted with a picker on a terminal when omitted)"
+    )]
+    pub name: Option<String>,
+    #[arg(
+        short,
+        long,
+        value_name = "FILE",
+        help = "Bundle path (default: gralph-<name>-<timestamp>.tar.gz)"
+    )]
+    pub output: Option<PathBuf>,
+}
+
+#[derive(Args, Debug)]
+pub struct ImportArgs {
+    #[arg(value_name = "FILE", help = "Bundle made by gralph export")]
+    pub bundle: PathBuf,
+    #[arg(
+        long,
+        value_name = "NAME",
+        help = "Register the session under this name"
+    )]
+    pub name: Option<String>,
+    #[arg(
+        long,
+        value_name = "DIR",
+        help = "Project directory for the session (default: the bundled PRD snapshot)"
+    )]
+    pub dir: Option<PathBuf>,
+}
+
+#[derive(Args, Debug)]
+pub struct BackendsArgs {
+    #[arg(long, help = "List models reported by each installed backend")]
+    pub models: bool,
+    #[arg(
+        long,
+        help = "Check that each installed backend can authenticate (sends a one-line prompt)"
+    )]
+    pub verbose: bool,
 }
 
 #[derive(Args, Debug)]
@@ -269,9 +771,31 @@ pub struct CleanupArgs {
     pub purge: bool,
 }
 
+#[derive(Args, Debug)]
+pub struct CleanArgs {
+    #[arg(
+        long,
+        default_value = ".",
+        help = "Project whose sessions, logs, and worktrees are cleaned"
+    )]
+    pub dir: PathBuf,
+    #[arg(
+        long,
+        value_name = "DURATION",
+        default_value = "7d",
+        help = "Only clean items older than this (e.g. 12h, 7d, 0 for all)"
+    )]
+    pub older_than: String,
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "List what would be removed")]
+    pub dry_run: bool,
+}
+
 #[derive(Args, Debug)]
 pub struct ResumeArgs {
-    #[arg(value_name = "NAME", help = "Session name")]
+    #[arg(
+        value_name = "NAME",
+        help = "Session name (picker on a terminal; resumes all otherwise)"
+    )]
     pub name: Option<String>,
 }
 
@@ -281,6 +805,12 @@ pub struct InitArgs {
     pub dir: Option<PathBuf>,
     #[arg(long, action = clap::ArgAction::SetTrue, help = "Overwrite existing files")]
     pub force: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Install a pre-commit hook that runs `gralph prd check`"
+    )]
+    pub git_hooks: bool,
 }
 
 #[derive(Args, Debug)]
@@ -291,24 +821,185 @@ pub struct PrdArgs {
 
 #[derive(Subcommand, Debug)]
 pub enum PrdCommand {
+    #[command(about = "Append a task block to a PRD")]
+    AddTask(PrdAddTaskArgs),
     #[command(about = "Validate PRD task blocks")]
     Check(PrdCheckArgs),
     #[command(about = "Generate a spec-compliant PRD")]
     Create(PrdCreateArgs),
+    #[command(about = "Apply safe fixes to a hand-written PRD")]
+    Fix(PrdFixArgs),
+    #[command(about = "Print the task dependency graph and detect cycles")]
+    Graph(PrdGraphArgs),
+    #[command(about = "Merge a regenerated PRD into an existing one, keeping progress")]
+    Merge(PrdMergeArgs),
+    #[command(about = "Print the parsed PRD as structured data")]
+    Parse(PrdParseArgs),
+    #[command(about = "Show progress by section and dependency chain")]
+    Progress(PrdProgressArgs),
+    #[command(about = "Renumber task IDs and update references to them")]
+    Renumber(PrdRenumberArgs),
+    #[command(about = "Split a PRD into per-prefix files with an index")]
+    Split(PrdSplitArgs),
+    #[command(about = "Manage named PRD templates")]
+    Templates(PrdTemplatesArgs),
 }
 
 #[derive(Args, Debug)]
-pub struct PrdCheckArgs {
-    #[arg(value_name = "FILE", help = "PRD file to validate")]
-    pub file: PathBuf,
-    #[arg(long, action = clap::ArgAction::SetTrue, help = "Allow missing Context Bundle paths")]
-    pub allow_missing_context: bool,
+pub struct PrdTemplatesArgs {
+    #[command(subcommand)]
+    pub command: PrdTemplatesCommand,
 }
 
-#[derive(Args, Debug, Clone)]
-pub struct PrdCreateArgs {
-    #[arg(long, help = "Project directory (default: current)")]
-    pub dir: Option<PathBuf>,
+#[derive(Subcommand, Debug)]
+pub enum PrdTemplatesCommand {
+    #[command(about = "List named PRD templates")]
+    List,
+    #[command(about = "Add a markdown file as a named PRD template")]
+    Add(PrdTemplatesAddArgs),
+}
+
+#[derive(Args, Debug)]
+pub struct PrdTemplatesAddArgs {
+    #[arg(
+        value_name = "NAME",
+        help = "Template name (letters, digits, '-' and '_')"
+    )]
+    pub name: String,
+    #[arg(value_name = "FILE", help = "Markdown file to copy into the registry")]
+    pub file: PathBuf,
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "Overwrite an existing template")]
+    pub force: bool,
+}
+
+#[derive(Args, Debug)]
+pub struct PrdAddTaskArgs {
+    #[arg(
+        value_name = "FILE",
+        default_value = "PRD.md",
+        help = "PRD file to append to"
+    )]
+    pub file: PathBuf,
+    #[arg(long, help = "Task ID (prompted when missing on a terminal)")]
+    pub id: Option<String>,
+    #[arg(long, help = "One-line task summary")]
+    pub summary: Option<String>,
+    #[arg(long, help = "Definition of done")]
+    pub dod: Option<String>,
+    #[arg(long, help = "Checklist item (repeatable)")]
+    pub checklist: Vec<String>,
+    #[arg(long, help = "Task dependencies (comma-separated)")]
+    pub deps: Option<String>,
+    #[arg(long, help = "Context Bundle paths (comma-separated)")]
+    pub context: Option<String>,
+    #[arg(long, help = "Linked tracker issue")]
+    pub issue: Option<String>,
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "Allow missing Context Bundle paths")]
+    pub allow_missing_context: bool,
+}
+
+#[derive(Args, Debug)]
+pub struct PrdCheckArgs {
+    #[arg(value_name = "FILE", help = "PRD file to validate")]
+    pub file: PathBuf,
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "Allow missing Context Bundle paths")]
+    pub allow_missing_context: bool,
+}
+
+#[derive(Args, Debug)]
+pub struct PrdFixArgs {
+    #[arg(value_name = "FILE", help = "PRD file to fix in place")]
+    pub file: PathBuf,
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "Report fixes without writing them")]
+    pub dry_run: bool,
+}
+
+#[derive(Args, Debug)]
+pub struct PrdGraphArgs {
+    #[arg(value_name = "FILE", help = "PRD file to inspect")]
+    pub file: PathBuf,
+}
+
+#[derive(Args, Debug)]
+pub struct PrdParseArgs {
+    #[arg(value_name = "FILE", help = "PRD file to parse")]
+    pub file: PathBuf,
+    #[arg(
+        long,
+        default_value = "json",
+        value_parser = ["json", "yaml"],
+        help = "Output format"
+    )]
+    pub format: String,
+}
+
+#[derive(Args, Debug)]
+pub struct PrdProgressArgs {
+    #[arg(
+        value_name = "FILE",
+        default_value = "PRD.md",
+        help = "PRD file to inspect"
+    )]
+    pub file: PathBuf,
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "Print a Mermaid flowchart instead")]
+    pub mermaid: bool,
+}
+
+#[derive(Args, Debug)]
+pub struct PrdMergeArgs {
+    #[arg(value_name = "OLD", help = "PRD the loop has been working on")]
+    pub old: PathBuf,
+    #[arg(value_name = "NEW", help = "Regenerated PRD to adopt tasks from")]
+    pub new: PathBuf,
+    #[arg(long, help = "Write the merged PRD here instead of over OLD")]
+    pub output: Option<PathBuf>,
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "Report the merge without writing it")]
+    pub dry_run: bool,
+}
+
+#[derive(Args, Debug)]
+pub struct PrdRenumberArgs {
+    #[arg(
+        value_name = "FILE",
+        default_value = "PRD.md",
+        help = "PRD file to renumber in place"
+    )]
+    pub file: PathBuf,
+    #[arg(long, help = "Give renumbered tasks this prefix (e.g. CORE)")]
+    pub prefix: Option<String>,
+    #[arg(long, help = "Only renumber tasks with this prefix (e.g. GO)")]
+    pub from: Option<String>,
+    #[arg(
+        long,
+        default_value_t = 1,
+        value_parser = clap::value_parser!(u32),
+        help = "Number of the first task under each prefix"
+    )]
+    pub start: u32,
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "Report renames without writing them")]
+    pub dry_run: bool,
+}
+
+#[derive(Args, Debug)]
+pub struct PrdSplitArgs {
+    #[arg(value_name = "FILE", help = "PRD file to split")]
+    pub file: PathBuf,
+    #[arg(long, help = "Directory for split files (default: PRD directory)")]
+    pub output_dir: Option<PathBuf>,
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "Overwrite existing split files")]
+    pub force: bool,
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct PrdCreateArgs {
+    #[arg(long, help = "Project directory (default: current)")]
+    pub dir: Option<PathBuf>,
+    #[arg(
+        long,
+        value_name = "NAME",
+        help = "Apply the config profile profiles.<NAME> over the loaded config"
+    )]
+    pub profile: Option<String>,
     #[arg(
         short = 'o',
         long,
@@ -317,12 +1008,39 @@ pub struct PrdCreateArgs {
     pub output: Option<PathBuf>,
     #[arg(long, help = "Short description of what to build")]
     pub goal: Option<String>,
+    #[arg(long, help = "Named PRD template from ~/.config/gralph/templates")]
+    pub template: Option<String>,
+    #[arg(long, help = "Scope the PRD to this workspace package")]
+    pub workspace: Option<String>,
+    #[arg(
+        long,
+        help = "Directory levels scanned for stack detection (default: config or 2)"
+    )]
+    pub stack_depth: Option<usize>,
     #[arg(long, help = "Constraints or non-functional requirements")]
     pub constraints: Option<String>,
     #[arg(long, help = "Extra context files (comma-separated)")]
     pub context: Option<String>,
     #[arg(long, help = "External URLs or references (comma-separated)")]
     pub sources: Option<String>,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Seed tasks from the TODO and FIXME comments in the project"
+    )]
+    pub from_todos: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Critique the draft against the PRD rules and the repo, then revise it"
+    )]
+    pub critique: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Do not show backend output and progress while generating"
+    )]
+    pub quiet: bool,
     #[arg(
         short = 'b',
         long,
@@ -331,7 +1049,10 @@ pub struct PrdCreateArgs {
     pub backend: Option<String>,
     #[arg(short = 'm', long, help = "Model override for PRD generation")]
     pub model: Option<String>,
-    #[arg(long, help = "Model variant override (backend-specific)")]
+    #[arg(
+        long,
+        help = "Reasoning or thinking level (backend-specific, see `gralph backends`)"
+    )]
     pub variant: Option<String>,
     #[arg(long, action = clap::ArgAction::SetTrue, help = "Allow missing Context Bundle paths")]
     pub allow_missing_context: bool,
@@ -343,6 +1064,12 @@ pub struct PrdCreateArgs {
     pub interactive: bool,
     #[arg(long, action = clap::ArgAction::SetTrue, help = "Overwrite existing output file")]
     pub force: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Print the prompt and backend command without running the backend"
+    )]
+    pub dry_run: bool,
 }
 
 #[derive(Args, Debug)]
@@ -386,87 +1113,684 @@ pub struct VerifierArgs {
     pub coverage_min: Option<f64>,
 }
 
-#[derive(Args, Debug)]
-pub struct ConfigArgs {
-    #[command(subcommand)]
-    pub command: Option<ConfigCommand>,
-}
+#[derive(Args, Debug)]
+pub struct ConfigArgs {
+    #[command(subcommand)]
+    pub command: Option<ConfigCommand>,
+}
+
+#[derive(Subcommand, Debug)]
+pub enum ConfigCommand {
+    #[command(about = "Get config value")]
+    Get(ConfigGetArgs),
+    #[command(about = "Set config value")]
+    Set(ConfigSetArgs),
+    #[command(about = "Open a config file in $EDITOR and validate it on save")]
+    Edit(ConfigEditArgs),
+    #[command(about = "List config values")]
+    List,
+}
+
+#[derive(Args, Debug)]
+pub struct ConfigGetArgs {
+    #[arg(value_name = "KEY", help = "Config key")]
+    pub key: String,
+}
+
+#[derive(Args, Debug)]
+pub struct ConfigSetArgs {
+    #[arg(value_name = "KEY", help = "Config key")]
+    pub key: String,
+    #[arg(value_name = "VALUE", help = "Config value")]
+    pub value: String,
+    #[command(flatten)]
+    pub file: ConfigFileArgs,
+}
+
+#[derive(Args, Debug)]
+pub struct ConfigEditArgs {
+    #[command(flatten)]
+    pub file: ConfigFileArgs,
+}
+
+/// Which config file `config set` and `config edit` write.
+#[derive(Args, Debug, Clone, Copy, Default)]
+pub struct ConfigFileArgs {
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        conflicts_with_all = ["global", "default"],
+        help = "Write the project's .gralph.yaml in the current directory (default)"
+    )]
+    pub project: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        conflicts_with = "default",
+        help = "Write the global config (~/.config/gralph/config.yaml)"
+    )]
+    pub global: bool,
+    #[arg(
+        long,
+        action = clap::ArgAction::SetTrue,
+        help = "Write the default config that every user and project inherits"
+    )]
+    pub default: bool,
+}
+
+#[derive(Args, Debug)]
+pub struct DaemonArgs {
+    #[command(subcommand)]
+    pub command: Option<DaemonCommand>,
+}
+
+#[derive(Subcommand, Debug, Clone, Copy, PartialEq, Eq)]
+pub enum DaemonCommand {
+    #[command(about = "Run the daemon in the foreground (default)")]
+    Run,
+    #[command(about = "Stop the daemon and the loops it supervises")]
+    Stop,
+    #[command(about = "Show whether the daemon runs and which loops it supervises")]
+    Status,
+}
+
+#[derive(Args, Debug)]
+pub struct ServerArgs {
+    #[arg(short = 'H', long, help = "Host/IP to bind to (default: 127.0.0.1)")]
+    pub host: Option<String>,
+    #[arg(short = 'p', long, help = "Port number (default: 8080)")]
+    pub port: Option<u16>,
+    #[arg(
+        short = 't',
+        long,
+        help = "Authentication token (required for non-localhost)"
+    )]
+    pub token: Option<String>,
+    #[arg(long, action = clap::ArgAction::SetTrue, help = "Allow requests from any origin (requires a token)")]
+    pub open: bool,
+    #[arg(
+        long,
+        value_name = "PEM",
+        requires = "tls_key",
+        help = "Serve HTTPS with this certificate chain"
+    )]
+    pub tls_cert: Option<PathBuf>,
+    #[arg(
+        long,
+        value_name = "PEM",
+        requires = "tls_cert",
+        help = "Private key for --tls-cert"
+    )]
+    pub tls_key: Option<PathBuf>,
+    #[arg(
+        long,
+        value_name = "PEM",
+        requires = "tls_cert",
+        help = "Require client certificates signed by this CA (mTLS)"
+    )]
+    pub tls_client_ca: Option<PathBuf>,
+    #[arg(
+        long,
+        value_name = "PATH",
+        help = "YAML file of named tokens with read or control scope"
+    )]
+    pub tokens_file: Option<PathBuf>,
+}
+
+#[derive(Args, Debug)]
+pub struct ServiceArgs {
+    #[command(subcommand)]
+    pub command: ServiceCommand,
+}
+
+#[derive(Subcommand, Debug)]
+pub enum ServiceCommand {
+    #[command(about = "Write a service file for the server, the daemon, or a loop")]
+    Install(ServiceInstallArgs),
+}
+
+#[derive(Args, Debug)]
+pub struct ServiceInstallArgs {
+    #[arg(value_name = "TARGET", value_parser = ["server", "daemon", "loop"], help = "What the service runs")]
+    pub target: String,
+    #[arg(
+        value_name = "DIR",
+        default_value = ".",
+        help = "Project directory for a loop service"
+    )]
+    pub dir: PathBuf,
+    #[arg(
+        short,
+        long,
+        help = "Session name for a loop service (default: directory name)"
+    )]
+    pub name: Option<String>,
+    #[arg(
+        long,
+        value_parser = ["systemd", "launchd"],
+        help = "Service manager (default: launchd on macOS, systemd elsewhere)"
+    )]
+    pub manager: Option<String>,
+    #[arg(
+        long,
+        help = "Write the file here instead of the per-user service directory"
+    )]
+    pub output: Option<PathBuf>,
+    #[arg(long, action = clap::ArgAction::SetTrue, conflicts_with = "output", help = "Print the file instead of writing it")]
+    pub print: bool,
+    #[arg(
+        last = true,
+        value_name = "ARGS",
+        help = "Extra arguments passed to `gralph server` or `gralph start`"
+    )]
+    pub args: Vec<String>,
+}
+
+#[derive(Args, Debug)]
+pub struct QueueArgs {
+    #[command(subcommand)]
+    pub command: QueueCommand,
+}
+
+#[derive(Subcommand, Debug)]
+pub enum QueueCommand {
+    #[command(about = "Add a project to the queue")]
+    Add(QueueAddArgs),
+    #[command(about = "List queue entries and their status")]
+    List,
+    #[command(about = "Remove an entry that is not running")]
+    Remove(QueueRemoveArgs),
+    #[command(about = "Run queued entries until the queue is empty")]
+    Run(QueueRunArgs),
+}
+
+#[derive(Args, Debug)]
+pub struct QueueAddArgs {
+    #[arg(value_name = "DIR", help = "Project directory to run the loop in")]
+    pub dir: PathBuf,
+    #[arg(short, long, help = "Session name (default: directory name)")]
+    pub name: Option<String>,
+    #[arg(
+        last = true,
+        value_name = "ARGS",
+        help = "Extra arguments passed to `gralph start`"
+    )]
+    pub args: Vec<String>,
+}
+
+#[derive(Args, Debug)]
+pub struct QueueRemoveArgs {
+    #[arg(value_name = "ID", help = "Queue entry id from `gralph queue list`")]
+    pub id: u64,
+}
+
+#[derive(Args, Debug)]
+pub struct QueueRunArgs {
+    #[arg(
+        long,
+        default_value_t = 1,
+        value_parser = clap::value_parser!(u32).range(1..),
+        help = "How many entries run at a time"
+    )]
+    pub concurrency: u32,
+}
+
+#[derive(Args, Debug)]
+pub struct NotifyArgs {
+    #[command(subcommand)]
+    pub command: NotifyCommand,
+}
+
+#[derive(Subcommand, Debug)]
+pub enum NotifyCommand {
+    #[command(about = "List notifications waiting for a retry")]
+    List,
+    #[command(about = "Retry every pending notification now")]
+    Flush,
+}
+
+#[derive(Args, Debug)]
+pub struct StateArgs {
+    #[command(subcommand)]
+    pub command: StateCommand,
+}
+
+#[derive(Subcommand, Debug)]
+pub enum StateCommand {
+    #[command(about = "Upgrade the state to the current schema version")]
+    Migrate,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use clap::error::ErrorKind;
+
+    #[test]
+    fn parse_update_command() {
+        let cli = Cli::parse_from(["gralph", "update"]);
+        match cli.command {
+            Some(Command::Update) => {}
+            other => panic!("Expected update command, got: {other:?}"),
+        }
+    }
+
+    #[test]
+    fn parse_status_command() {
+        let cli = Cli::parse_from(["gralph", "status"]);
+        match cli.command {
+            Some(Command::Status(args)) => {
+                assert!(!cli.json);
+                assert!(!args.verbose);
+            }
+            other => panic!("Expected status command, got: {other:?}"),
+        }
+    }
+
+    #[test]
+    fn parse_status_json_flag() {
+        let cli = Cli::parse_from(["gralph", "status", "--json"]);
+        match cli.command {
+            Some(Command::Status(args)) => {
+                assert!(cli.json);
+                assert!(!args.verbose);
+            }
+            other => panic!("Expected status command, got: {other:?}"),
+        }
+    }
+
+    #[test]
+    fn parse_run_task_command() {
+        let cli = Cli::parse_from(["gralph", "run-task", "COR-3", "--dir", ".", "-b", "codex"]);
+        let Some(Command::RunTask(args)) = cli.command else {
+            panic!("expected run-task command");
+        };
+        assert_eq!(args.id, "COR-3");
+        assert_eq!(args.dir, Some(PathBuf::from(".")));
+        assert_eq!(args.backend.as_deref(), Some("codex"));
+    }
+
+    #[test]
+    fn parse_backends_models_flag() {
+        let cli = Cli::parse_from(["gralph", "backends", "--models"]);
+        assert!(matches!(
+            cli.command,
+            Some(Command::Backends(BackendsArgs {
+                models: true,
+                verbose: false
+            }))
+        ));
+    }
+
+    #[test]
+    fn parse_status_local_flag() {
+        let cli = Cli::parse_from(["gralph", "status", "--local"]);
+        let Some(Command::Status(args)) = cli.command else {
+            panic!("expected status command");
+        };
+        assert!(args.local);
+        assert!(!args.verbose);
+    }
+
+    #[test]
+    fn parse_sessions_alias_and_attach() {
+        let cli = Cli::parse_from(["gralph", "sessions", "--tmux"]);
+        let Some(Command::Status(args)) = cli.command else {
+            panic!("expected status command");
+        };
+        assert!(args.tmux);
+
+        let cli = Cli::parse_from(["gralph", "attach", "myapp"]);
+        let Some(Command::Attach(args)) = cli.command else {
+            panic!("expected attach command");
+        };
+        assert_eq!(args.name.as_deref(), Some("myapp"));
+    }
+
+    #[test]
+    fn parse_clean_defaults_and_flags() {
+        let cli = Cli::parse_from(["gralph", "clean"]);
+        let Some(Command::Clean(args)) = cli.command else {
+            panic!("expected clean command");
+        };
+        assert_eq!(args.dir, PathBuf::from("."));
+        assert_eq!(args.older_than, "7d");
+        assert!(!args.dry_run);
+
+        let cli = Cli::parse_from(["gralph", "clean", "--older-than", "2d", "--dry-run"]);
+        let Some(Command::Clean(args)) = cli.command else {
+            panic!("expected clean command");
+        };
+        assert_eq!(args.older_than, "2d");
+        assert!(args.dry_run);
+    }
+
+    #[test]
+    fn parse_start_profile() {
+        let cli = Cli::parse_from(["gralph", "start", ".", "--profile", "cheap"]);
+        let Some(Command::Start(args)) = cli.command else {
+            panic!("expected start command");
+        };
+        assert_eq!(args.profile.as_deref(), Some("cheap"));
+    }
+
+    #[test]
+    fn parse_diff_options() {
+        let cli = Cli::parse_from(["gralph", "diff", "app", "--stat", "--task", "COR-3"]);
+        let Some(Command::Diff(args)) = cli.command else {
+            panic!("expected diff command");
+        };
+        assert_eq!(args.name.as_deref(), Some("app"));
+        assert!(args.stat);
+        assert_eq!(args.task.as_deref(), Some("COR-3"));
+    }
+
+    #[test]
+    fn parse_export_and_import() {
+        let cli = Cli::parse_from(["gralph", "export", "app", "-o", "/tmp/app.tar.gz"]);
+        let Some(Command::Export(args)) = cli.command else {
+            panic!("expected export command");
+        };
+        assert_eq!(args.name.as_deref(), Some("app"));
+        assert_eq!(args.output, Some(PathBuf::from("/tmp/app.tar.gz")));
+
+        let cli = Cli::parse_from([
+            "gralph",
+            "import",
+            "app.tar.gz",
+            "--name",
+            "app-report",
+            "--dir",
+            "/src/app",
+        ]);
+        let Some(Command::Import(args)) = cli.command else {
+            panic!("expected import command");
+        };
+        assert_eq!(args.bundle, PathBuf::from("app.tar.gz"));
+        assert_eq!(args.name.as_deref(), Some("app-report"));
+        assert_eq!(args.dir, Some(PathBuf::from("/src/app")));
+        assert!(Cli::try_parse_from(["gralph", "import"]).is_err());
+    }
+
+    #[test]
+    fn parse_history_filters() {
+        let cli = Cli::parse_from(["gralph", "history", "--session", "app", "--since", "7d"]);
+        let Some(Command::History(args)) = cli.command else {
+            panic!("expected history command");
+        };
+        assert_eq!(args.session.as_deref(), Some("app"));
+        assert_eq!(args.since.as_deref(), Some("7d"));
+    }
+
+    #[test]
+    fn parse_global_offline_flag() {
+        let cli = Cli::parse_from(["gralph", "start", ".", "--offline"]);
+        assert!(cli.offline);
+        let cli = Cli::parse_from(["gralph", "--offline", "prd", "create"]);
+        assert!(cli.offline);
+        assert!(!Cli::parse_from(["gralph", "status"]).offline);
+    }
+
+    #[test]
+    fn parse_global_cassette_flags() {
+        let cli = Cli::parse_from(["gralph", "start", ".", "--replay", "cassettes/run"]);
+        assert_eq!(cli.replay, Some(PathBuf::from("cassettes/run")));
+        assert!(cli.record.is_none());
+        let cli = Cli::parse_from(["gralph", "--record", "rec", "step", "."]);
+        assert_eq!(cli.record, Some(PathBuf::from("rec")));
+        assert!(
+            Cli::try_parse_from(["gralph", "--record", "a", "--replay", "b", "status"]).is_err()
+        );
+    }
+
+    #[test]
+    fn parse_global_json_flag_before_and_after_subcommand() {
+        for argv in [
+            ["gralph", "--json", "backends"],
+            ["gralph", "backends", "--json"],
+        ] {
+            let cli = Cli::parse_from(argv);
+            assert!(cli.json);
+            assert!(matches!(
+                cli.command,
+                Some(Command::Backends(BackendsArgs {
+                    models: false,
+                    verbose: false
+                }))
+            ));
+        }
+        let cli = Cli::parse_from(["gralph", "config", "list", "--json"]);
+        assert!(cli.json);
+    }
+
+    #[test]
+    fn parse_watch_defaults_and_flags() {
+        let cli = Cli::parse_from(["gralph", "watch"]);
+        match cli.command {
+            Some(Command::Watch(args)) => {
+                assert!(args.name.is_none());
+                assert_eq!(args.interval, 2);
+                assert_eq!(args.lines, 15);
+                assert!(!args.once);
+            }
+            other => panic!("Expected watch command, got: {other:?}"),
+        }
+
+        let cli = Cli::parse_from([
+            "gralph",
+            "watch",
+            "--name",
+            "demo",
+            "--interval",
+            "5",
+            "--once",
+        ]);
+        match cli.command {
+            Some(Command::Watch(args)) => {
+                assert_eq!(args.name.as_deref(), Some("demo"));
+                assert_eq!(args.interval, 5);
+                assert!(args.once);
+            }
+            other => panic!("Expected watch command, got: {other:?}"),
+        }
+    }
+
+    #[test]
+    fn parse_prd_graph_command() {
+        let cli = Cli::parse_from(["gralph", "prd", "graph", "PRD.md"]);
+        match cli.command {
+            Some(Command::Prd(PrdArgs {
+                command: PrdCommand::Graph(args),
+            })) => {
+                assert_eq!(args.file, PathBuf::from("PRD.md"));
+            }
+            other => panic!("Expected prd graph command, got: {other:?}"),
+        }
+    }
 
-#[derive(Subcommand, Debug)]
-pub enum ConfigCommand {
-    #[command(about = "Get config value")]
-    Get(ConfigGetArgs),
-    #[command(about = "Set config value")]
-    Set(ConfigSetArgs),
-    #[command(about = "List config values")]
-    List,
-}
+    #[test]
+    fn parse_prd_progress_command() {
+        let cli = Cli::parse_from(["gralph", "prd", "progress", "--mermaid"]);
+        match cli.command {
+            Some(Command::Prd(PrdArgs {
+                command: PrdCommand::Progress(args),
+            })) => {
+                assert_eq!(args.file, PathBuf::from("PRD.md"));
+                assert!(args.mermaid);
+            }
+            other => panic!("Expected prd progress command, got: {other:?}"),
+        }
+    }
 
-#[derive(Args, Debug)]
-pub struct ConfigGetArgs {
-    #[arg(value_name = "KEY", help = "Config key")]
-    pub key: String,
-}
+    #[test]
+    fn parse_prd_merge_command() {
+        let cli = Cli::parse_from([
+            "gralph",
+            "prd",
+            "merge",
+            "PRD.md",
+            "PRD.generated.md",
+            "--output",
+            "PRD.merged.md",
+        ]);
+        match cli.command {
+            Some(Command::Prd(PrdArgs {
+                command: PrdCommand::Merge(args),
+            })) => {
+                assert_eq!(args.old, PathBuf::from("PRD.md"));
+                assert_eq!(args.new, PathBuf::from("PRD.generated.md"));
+                assert_eq!(args.output, Some(PathBuf::from("PRD.merged.md")));
+                assert!(!args.dry_run);
+            }
+            other => panic!("Expected prd merge command, got: {other:?}"),
+        }
+    }
 
-#[derive(Args, Debug)]
-pub struct ConfigSetArgs {
-    #[arg(value_name = "KEY", help = "Config key")]
-    pub key: String,
-    #[arg(value_name = "VALUE", help = "Config value")]
-    pub value: String,
-}
+    #[test]
+    fn parse_prd_renumber_command() {
+        let cli = Cli::parse_from([
+            "gralph",
+            "prd",
+            "renumber",
+            "--from",
+            "GO",
+            "--prefix",
+            "CORE",
+            "--dry-run",
+        ]);
+        match cli.command {
+            Some(Command::Prd(PrdArgs {
+                command: PrdCommand::Renumber(args),
+            })) => {
+                assert_eq!(args.file, PathBuf::from("PRD.md"));
+                assert_eq!(args.from.as_deref(), Some("GO"));
+                assert_eq!(args.prefix.as_deref(), Some("CORE"));
+                assert_eq!(args.start, 1);
+                assert!(args.dry_run);
+            }
+            other => panic!("Expected prd renumber command, got: {other:?}"),
+        }
+    }
 
-#[derive(Args, Debug)]
-pub struct ServerArgs {
-    #[arg(short = 'H', long, help = "Host/IP to bind to (default: 127.0.0.1)")]
-    pub host: Option<String>,
-    #[arg(short = 'p', long, help = "Port number (default: 8080)")]
-    pub port: Option<u16>,
-    #[arg(
-        short = 't',
-        long,
-        help = "Authentication token (required for non-localhost)"
-    )]
-    pub token: Option<String>,
-    #[arg(long, action = clap::ArgAction::SetTrue, help = "Disable token requirement (use with caution)")]
-    pub open: bool,
-}
+    #[test]
+    fn parse_prd_add_task_command() {
+        let cli = Cli::parse_from([
+            "gralph",
+            "prd",
+            "add-task",
+            "--id",
+            "GO-42",
+            "--summary",
+            "Add retries",
+            "--deps",
+            "GO-40",
+            "--context",
+            "README.md,src/core.rs",
+            "--checklist",
+            "Write tests",
+            "--checklist",
+            "Update docs",
+        ]);
+        match cli.command {
+            Some(Command::Prd(PrdArgs {
+                command: PrdCommand::AddTask(args),
+            })) => {
+                assert_eq!(args.file, PathBuf::from("PRD.md"));
+                assert_eq!(args.id.as_deref(), Some("GO-42"));
+                assert_eq!(args.summary.as_deref(), Some("Add retries"));
+                assert_eq!(args.dod, None);
+                assert_eq!(args.deps.as_deref(), Some("GO-40"));
+                assert_eq!(args.context.as_deref(), Some("README.md,src/core.rs"));
+                assert_eq!(args.checklist, vec!["Write tests", "Update docs"]);
+                assert!(!args.allow_missing_context);
+            }
+            other => panic!("Expected prd add-task command, got: {other:?}"),
+        }
+    }
 
-#[cfg(test)]
-mod tests {
-    use super::*;
-    use clap::error::ErrorKind;
+    #[test]
+    fn parse_prd_templates_add_command() {
+        let cli = Cli::parse_from(["gralph", "prd", "templates", "add", "cli", "PRD.cli.md"]);
+        match cli.command {
+            Some(Command::Prd(PrdArgs {
+                command:
+                    PrdCommand::Templates(PrdTemplatesArgs {
+                        command: PrdTemplatesCommand::Add(args),
+                    }),
+            })) => {
+                assert_eq!(args.name, "cli");
+                assert_eq!(args.file, PathBuf::from("PRD.cli.md"));
+                assert!(!args.force);
+            }
+            other => panic!("Expected prd templates add command, got: {other:?}"),
+        }
+    }
 
     #[test]
-    fn parse_update_command() {
-        let cli = Cli::parse_from(["gralph", "update"]);
+    fn parse_prd_fix_command() {
+        let cli = Cli::parse_from(["gralph", "prd", "fix", "PRD.md", "--dry-run"]);
         match cli.command {
-            Some(Command::Update) => {}
-            other => panic!("Expected update command, got: {other:?}"),
+            Some(Command::Prd(PrdArgs {
+                command: PrdCommand::Fix(args),
+            })) => {
+                assert_eq!(args.file, PathBuf::from("PRD.md"));
+                assert!(args.dry_run);
+            }
+            other => panic!("Expected prd fix command, got: {other:?}"),
         }
     }
 
     #[test]
-    fn parse_status_command() {
-        let cli = Cli::parse_from(["gralph", "status"]);
+    fn parse_prd_parse_command() {
+        let cli = Cli::parse_from(["gralph", "prd", "parse", "PRD.md"]);
         match cli.command {
-            Some(Command::Status(args)) => {
-                assert!(!args.json);
-                assert!(!args.verbose);
+            Some(Command::Prd(PrdArgs {
+                command: PrdCommand::Parse(args),
+            })) => {
+                assert_eq!(args.file, PathBuf::from("PRD.md"));
+                assert_eq!(args.format, "json");
             }
-            other => panic!("Expected status command, got: {other:?}"),
+            other => panic!("Expected prd parse command, got: {other:?}"),
         }
+        assert!(
+            Cli::try_parse_from(["gralph", "prd", "parse", "PRD.md", "--format", "xml"]).is_err()
+        );
     }
 
     #[test]
-    fn parse_status_json_flag() {
-        let cli = Cli::parse_from(["gralph", "status", "--json"]);
+    fn parse_prd_split_command() {
+        let cli = Cli::parse_from([
+            "gralph",
+            "prd",
+            "split",
+            "PRD.md",
+            "--output-dir",
+            "specs",
+            "--force",
+        ]);
         match cli.command {
-            Some(Command::Status(args)) => {
-                assert!(args.json);
-                assert!(!args.verbose);
+            Some(Command::Prd(PrdArgs {
+                command: PrdCommand::Split(args),
+            })) => {
+                assert_eq!(args.file, PathBuf::from("PRD.md"));
+                assert_eq!(args.output_dir, Some(PathBuf::from("specs")));
+                assert!(args.force);
             }
-            other => panic!("Expected status command, got: {other:?}"),
+            other => panic!("Expected prd split command, got: {other:?}"),
+        }
+    }
+
+    #[test]
+    fn parse_pause_and_unpause_commands() {
+        let cli = Cli::parse_from(["gralph", "pause", "demo"]);
+        match cli.command {
+            Some(Command::Pause(args)) => assert_eq!(args.name, "demo"),
+            other => panic!("Expected pause command, got: {other:?}"),
+        }
+        let cli = Cli::parse_from(["gralph", "unpause", "demo"]);
+        match cli.command {
+            Some(Command::Unpause(args)) => assert_eq!(args.name, "demo"),
+            other => panic!("Expected unpause command, got: {other:?}"),
         }
     }
 
@@ -549,6 +1873,7 @@ mod tests {
         match cli.command {
             Some(Command::Start(args)) => {
                 assert_eq!(args.dir, PathBuf::from("."));
+                assert!(args.workspace.is_none());
                 assert!(args.name.is_none());
                 assert!(args.max_iterations.is_none());
                 assert!(args.task_file.is_none());
@@ -627,6 +1952,44 @@ mod tests {
         }
     }
 
+    #[test]
+    fn parse_start_worktree_flag() {
+        let cli = Cli::parse_from(["gralph", "start", ".", "--worktree"]);
+        match cli.command {
+            Some(Command::Start(args)) => {
+                assert!(args.worktree);
+                assert!(!args.no_worktree);
+            }
+            other => panic!("Expected start command, got: {other:?}"),
+        }
+    }
+
+    #[test]
+    fn parse_logs_filters() {
+        let cli = Cli::parse_from([
+            "gralph",
+            "logs",
+            "demo",
+            "--iteration",
+            "3",
+            "--grep",
+            "fail",
+            "--since",
+            "2h",
+        ]);
+        match cli.command {
+            Some(Command::Logs(args)) => {
+                assert_eq!(args.iteration, Some(3));
+                assert_eq!(args.grep.as_deref(), Some("fail"));
+                assert_eq!(args.since.as_deref(), Some("2h"));
+            }
+            other => panic!("Expected logs command, got: {other:?}"),
+        }
+
+        let result = Cli::try_parse_from(["gralph", "logs", "demo", "--follow", "--grep", "x"]);
+        assert!(result.is_err());
+    }
+
     #[test]
     fn parse_step_defaults() {
         let cli = Cli::parse_from(["gralph", "step", "."]);
@@ -716,6 +2079,7 @@ mod tests {
             "--webhook",
             "https://example.com/hook",
             "--no-worktree",
+            "--worktree",
             "--strict-prd",
         ]);
         match cli.command {
@@ -731,6 +2095,7 @@ mod tests {
                 assert_eq!(args.prompt_template, Some(PathBuf::from("prompt.md")));
                 assert_eq!(args.webhook.as_deref(), Some("https://example.com/hook"));
                 assert!(args.no_worktree);
+                assert!(args.worktree);
                 assert!(args.strict_prd);
             }
             other => panic!("Expected run-loop command, got: {other:?}"),
@@ -755,6 +2120,9 @@ mod tests {
             "ARCHITECTURE.md,PROCESS.md",
             "--sources",
             "https://example.com",
+            "--from-todos",
+            "--critique",
+            "--quiet",
             "--backend",
             "claude",
             "--model",
@@ -775,6 +2143,9 @@ mod tests {
                     assert_eq!(args.constraints.as_deref(), Some("Fast"));
                     assert_eq!(args.context.as_deref(), Some("ARCHITECTURE.md,PROCESS.md"));
                     assert_eq!(args.sources.as_deref(), Some("https://example.com"));
+                    assert!(args.from_todos);
+                    assert!(args.critique);
+                    assert!(args.quiet);
                     assert_eq!(args.backend.as_deref(), Some("claude"));
                     assert_eq!(args.model.as_deref(), Some("sonnet"));
                     assert_eq!(args.variant.as_deref(), Some("mini"));
@@ -783,6 +2154,7 @@ mod tests {
                     assert!(args.no_interactive);
                     assert!(!args.interactive);
                     assert!(args.force);
+                    assert!(!args.dry_run);
                 }
                 other => panic!("Expected prd create command, got: {other:?}"),
             },
@@ -844,6 +2216,189 @@ mod tests {
         }
     }
 
+    #[test]
+    fn parse_start_daemon_flag() {
+        let cli = Cli::parse_from(["gralph", "start", ".", "--daemon"]);
+        match cli.command {
+            Some(Command::Start(args)) => assert!(args.daemon),
+            other => panic!("Expected start command, got: {other:?}"),
+        }
+        let cli = Cli::parse_from(["gralph", "start", ".", "--detach"]);
+        assert!(matches!(cli.command, Some(Command::Start(args)) if args.daemon));
+
+        let err =
+            Cli::try_parse_from(["gralph", "start", ".", "--daemon", "--no-tmux"]).unwrap_err();
+        assert_eq!(err.kind(), ErrorKind::ArgumentConflict);
+    }
+
+    #[test]
+    fn parse_start_review_backend() {
+        let cli = Cli::parse_from([
+            "gralph",
+            "start",
+            ".",
+            "--review-backend",
+            "claude",
+            "--review-model",
+            "claude-opus-4-5",
+        ]);
+        match cli.command {
+            Some(Command::Start(args)) => {
+                assert_eq!(args.review_backend.as_deref(), Some("claude"));
+                assert_eq!(args.review_model.as_deref(), Some("claude-opus-4-5"));
+            }
+            other => panic!("Expected start command, got: {other:?}"),
+        }
+
+        let err = Cli::try_parse_from(["gralph", "start", ".", "--review-model", "x"]).unwrap_err();
+        assert_eq!(err.kind(), ErrorKind::MissingRequiredArgument);
+    }
+
+    #[test]
+    fn parse_start_backend_args() {
+        let cli = Cli::parse_from([
+            "gralph",
+            "start",
+            ".",
+            "--backend-arg",
+            "--allowedTools",
+            "--backend-arg=Bash,Edit",
+            "--no-tmux",
+        ]);
+        match cli.command {
+            Some(Command::Start(args)) => {
+                assert_eq!(args.backend_args, vec!["--allowedTools", "Bash,Edit"]);
+                assert!(args.no_tmux);
+            }
+            other => panic!("Expected start command, got: {other:?}"),
+        }
+    }
+
+    #[test]
+    fn parse_start_budget_caps() {
+        let cli = Cli::parse_from([
+            "gralph",
+            "start",
+            ".",
+            "--max-cost",
+            "12.5",
+            "--max-tokens",
+            "2000000",
+            "--max-duration",
+            "4h",
+        ]);
+        match cli.command {
+            Some(Command::Start(args)) => {
+                assert_eq!(args.max_cost, Some(12.5));
+                assert_eq!(args.max_tokens, Some(2_000_000));
+                assert_eq!(args.max_duration.as_deref(), Some("4h"));
+            }
+            other => panic!("Expected start command, got: {other:?}"),
+        }
+        assert!(Cli::try_parse_from(["gralph", "start", ".", "--max-tokens", "-1"]).is_err());
+    }
+
+    #[test]
+    fn parse_service_install() {
+        let cli = Cli::parse_from([
+            "gralph",
+            "service",
+            "install",
+            "loop",
+            "/srv/app",
+            "--name",
+            "app",
+            "--manager",
+            "launchd",
+            "--print",
+            "--",
+            "--backend",
+            "codex",
+        ]);
+        match cli.command {
+            Some(Command::Service(args)) => match args.command {
+                ServiceCommand::Install(args) => {
+                    assert_eq!(args.target, "loop");
+                    assert_eq!(args.dir, PathBuf::from("/srv/app"));
+                    assert_eq!(args.name.as_deref(), Some("app"));
+                    assert_eq!(args.manager.as_deref(), Some("launchd"));
+                    assert!(args.print);
+                    assert_eq!(args.args, vec!["--backend", "codex"]);
+                }
+            },
+            other => panic!("Expected service command, got: {other:?}"),
+        }
+
+        let err = Cli::try_parse_from(["gralph", "service", "install", "cron"]).unwrap_err();
+        assert_eq!(err.kind(), ErrorKind::InvalidValue);
+    }
+
+    #[test]
+    fn parse_queue_commands() {
+        let cli = Cli::parse_from([
+            "gralph",
+            "queue",
+            "add",
+            "/srv/api",
+            "--name",
+            "api",
+            "--",
+            "--backend",
+            "codex",
+        ]);
+        match cli.command {
+            Some(Command::Queue(QueueArgs {
+                command: QueueCommand::Add(args),
+            })) => {
+                assert_eq!(args.dir, PathBuf::from("/srv/api"));
+                assert_eq!(args.name.as_deref(), Some("api"));
+                assert_eq!(args.args, vec!["--backend", "codex"]);
+            }
+            other => panic!("Expected queue add, got: {other:?}"),
+        }
+
+        let cli = Cli::parse_from(["gralph", "queue", "run", "--concurrency", "2"]);
+        match cli.command {
+            Some(Command::Queue(QueueArgs {
+                command: QueueCommand::Run(args),
+            })) => assert_eq!(args.concurrency, 2),
+            other => panic!("Expected queue run, got: {other:?}"),
+        }
+
+        assert!(Cli::try_parse_from(["gralph", "queue", "run", "--concurrency", "0"]).is_err());
+    }
+
+    #[test]
+    fn parse_notify_commands() {
+        let cli = Cli::parse_from(["gralph", "notify", "flush"]);
+        assert!(matches!(
+            cli.command,
+            Some(Command::Notify(NotifyArgs {
+                command: NotifyCommand::Flush
+            }))
+        ));
+        let cli = Cli::parse_from(["gralph", "notify", "list"]);
+        assert!(matches!(
+            cli.command,
+            Some(Command::Notify(NotifyArgs {
+                command: NotifyCommand::List
+            }))
+        ));
+        assert!(Cli::try_parse_from(["gralph", "notify"]).is_err());
+    }
+
+    #[test]
+    fn parse_state_migrate() {
+        let cli = Cli::parse_from(["gralph", "state", "migrate"]);
+        assert!(matches!(
+            cli.command,
+            Some(Command::State(StateArgs {
+                command: StateCommand::Migrate
+            }))
+        ));
+        assert!(Cli::try_parse_from(["gralph", "state"]).is_err());
+    }
+
     #[test]
     fn parse_server_flags() {
         let cli = Cli::parse_from([
@@ -861,6 +2416,47 @@ mod tests {
         }
     }
 
+    #[test]
+    fn parse_server_tls_flags() {
+        let cli = Cli::parse_from([
+            "gralph",
+            "server",
+            "--tls-cert",
+            "cert.pem",
+            "--tls-key",
+            "key.pem",
+            "--tls-client-ca",
+            "ca.pem",
+            "--tokens-file",
+            "tokens.yaml",
+        ]);
+        match cli.command {
+            Some(Command::Server(args)) => {
+                assert_eq!(args.tls_cert, Some(PathBuf::from("cert.pem")));
+                assert_eq!(args.tls_key, Some(PathBuf::from("key.pem")));
+                assert_eq!(args.tls_client_ca, Some(PathBuf::from("ca.pem")));
+                assert_eq!(args.tokens_file, Some(PathBuf::from("tokens.yaml")));
+            }
+            other => panic!("Expected server command, got: {other:?}"),
+        }
+    }
+
+    #[test]
+    fn server_tls_cert_requires_key() {
+        let err = Cli::try_parse_from(["gralph", "server", "--tls-cert", "cert.pem"]).unwrap_err();
+        assert_eq!(err.kind(), ErrorKind::MissingRequiredArgument);
+    }
+
+    #[test]
+    fn parse_hidden_complete_command() {
+        let cli = Cli::parse_from(["gralph", "__complete", "config-keys"]);
+        match cli.command {
+            Some(Command::Complete(args)) => assert_eq!(args.kind, "config-keys"),
+            other => panic!("Expected complete command, got: {other:?}"),
+        }
+        assert!(Cli::try_parse_from(["gralph", "__complete", "models"]).is_err());
+    }
+
     #[test]
     fn parse_config_commands() {
         let get_cli = Cli::parse_from(["gralph", "config", "get", "core.backend"]);
@@ -880,12 +2476,25 @@ mod tests {
                 Some(ConfigCommand::Set(args)) => {
                     assert_eq!(args.key, "core.backend");
                     assert_eq!(args.value, "codex");
+                    assert!(!args.file.global && !args.file.default);
                 }
                 other => panic!("Expected config set command, got: {other:?}"),
             },
             other => panic!("Expected config command, got: {other:?}"),
         }
 
+        let edit_cli = Cli::parse_from(["gralph", "config", "edit", "--global"]);
+        match edit_cli.command {
+            Some(Command::Config(args)) => match args.command {
+                Some(ConfigCommand::Edit(args)) => assert!(args.file.global),
+                other => panic!("Expected config edit command, got: {other:?}"),
+            },
+            other => panic!("Expected config command, got: {other:?}"),
+        }
+        assert!(
+            Cli::try_parse_from(["gralph", "config", "edit", "--project", "--global"]).is_err()
+        );
+
         let list_cli = Cli::parse_from(["gralph", "config", "list"]);
         match list_cli.command {
             Some(Command::Config(args)) => match args.command {
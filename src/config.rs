@@ -1,3 +1,4 @@
+use crate::backend::cassette::Cassette;
 use serde_yaml::{Mapping, Value};
 use std::collections::BTreeMap;
 use std::env;
@@ -6,6 +7,10 @@ use std::fmt;
 use std::fs;
 use std::path::{Path, PathBuf};
 
+/// Names the profile under `profiles.<name>` that is merged over the loaded
+/// config. Set by `--profile`, so loops spawned by `start` inherit it.
+pub const PROFILE_ENV: &str = "GRALPH_PROFILE";
+
 #[derive(Debug)]
 pub enum ConfigError {
     Io {
@@ -16,6 +21,10 @@ pub enum ConfigError {
         path: PathBuf,
         source: serde_yaml::Error,
     },
+    UnknownProfile {
+        name: String,
+        available: Vec<String>,
+    },
 }
 
 impl fmt::Display for ConfigError {
@@ -32,6 +41,14 @@ impl fmt::Display for ConfigError {
                     source
                 )
             }
+            ConfigError::UnknownProfile { name, available } => {
+                write!(f, "unknown config profile: {}", name)?;
+                if available.is_empty() {
+                    write!(f, " (no profiles are defined)")
+                } else {
+                    write!(f, " (available: {})", available.join(", "))
+                }
+            }
         }
     }
 }
@@ -41,6 +58,7 @@ impl Error for ConfigError {
         match self {
             ConfigError::Io { source, .. } => Some(source),
             ConfigError::Parse { source, .. } => Some(source),
+            ConfigError::UnknownProfile { .. } => None,
         }
     }
 }
@@ -49,10 +67,24 @@ impl Error for ConfigError {
 pub struct Config {
     merged: Value,
     user_overrides: Value,
+    offline: bool,
+    cassette: Option<Cassette>,
 }
 
 impl Config {
+    /// Loads the config with the profile from `GRALPH_PROFILE`, if any.
+    /// Commands that take `--profile` use [`Config::load_with_profile`].
     pub fn load(project_dir: Option<&Path>) -> Result<Self, ConfigError> {
+        Self::load_with_profile(project_dir, active_profile().as_deref())
+    }
+
+    /// Merges the default, global, and project config, then `profile` on
+    /// top. Nothing is cached, so loops for different projects or profiles
+    /// can load their own config side by side.
+    pub fn load_with_profile(
+        project_dir: Option<&Path>,
+        profile: Option<&str>,
+    ) -> Result<Self, ConfigError> {
         let mut merged = Value::Mapping(Mapping::new());
         let mut user_overrides = Value::Mapping(Mapping::new());
         let default_path = default_config_path();
@@ -64,12 +96,79 @@ impl Config {
                 user_overrides = merge_values(user_overrides, value);
             }
         }
+        // The selected profile goes on top of every file, wherever it was
+        // defined; env overrides still win when a key is read.
+        if let Some(name) = profile.map(str::trim).filter(|name| !name.is_empty()) {
+            let name = name.to_string();
+            let profiles = lookup_value(&merged, "profiles");
+            let profile = match profiles {
+                Some(Value::Mapping(map)) => lookup_mapping_value(map, &name).cloned(),
+                _ => None,
+            };
+            let Some(profile) = profile else {
+                let available = match profiles {
+                    Some(Value::Mapping(map)) => map
+                        .keys()
+                        .filter_map(Value::as_str)
+                        .map(str::to_string)
+                        .collect(),
+                    _ => Vec::new(),
+                };
+                return Err(ConfigError::UnknownProfile { name, available });
+            };
+            merged = merge_values(merged, profile.clone());
+            user_overrides = merge_values(user_overrides, profile);
+        }
         Ok(Self {
             merged,
             user_overrides,
+            offline: false,
+            cassette: None,
+        })
+    }
+
+    /// A config from one YAML document, without reading any config file.
+    /// Env overrides still apply when a key is read.
+    pub fn from_yaml(yaml: &str) -> Result<Self, ConfigError> {
+        let merged: Value = serde_yaml::from_str(yaml).map_err(|source| ConfigError::Parse {
+            path: PathBuf::from("<yaml>"),
+            source,
+        })?;
+        let merged = match merged {
+            Value::Null => Value::Mapping(Mapping::new()),
+            value => value,
+        };
+        Ok(Self {
+            user_overrides: merged.clone(),
+            merged,
+            offline: false,
+            cassette: None,
         })
     }
 
+    /// Marks the run this config belongs to as offline (`--offline` or
+    /// `GRALPH_OFFLINE`), so code that only sees the config can tell.
+    pub fn with_offline(mut self, offline: bool) -> Self {
+        self.offline = offline;
+        self
+    }
+
+    /// Whether network calls are off for this run.
+    pub fn offline(&self) -> bool {
+        self.offline
+    }
+
+    /// Records or replays the backends built from this config through
+    /// `cassette` (`--record` or `--replay`).
+    pub fn with_cassette(mut self, cassette: Option<Cassette>) -> Self {
+        self.cassette = cassette;
+        self
+    }
+
+    pub fn cassette(&self) -> Option<&Cassette> {
+        self.cassette.as_ref()
+    }
+
     pub fn get(&self, key: &str) -> Option<String> {
         let normalized = normalize_key(key)?;
         if let Some(value) = resolve_env_override(key, &normalized) {
@@ -78,6 +177,21 @@ impl Config {
         lookup_value(&self.merged, &normalized).and_then(value_to_string)
     }
 
+    /// A list value with one item per YAML sequence entry, so items may
+    /// contain commas. A string (including an env override) is split like a
+    /// shell command line.
+    pub fn get_list(&self, key: &str) -> Option<Vec<String>> {
+        let normalized = normalize_key(key)?;
+        if let Some(value) = resolve_env_override(key, &normalized) {
+            return Some(split_list_string(&value));
+        }
+        match lookup_value(&self.merged, &normalized)? {
+            Value::Sequence(values) => Some(values.iter().filter_map(value_to_string).collect()),
+            Value::String(text) => Some(split_list_string(text)),
+            other => value_to_string(other).map(|value| vec![value]),
+        }
+    }
+
     pub fn get_user(&self, key: &str) -> Option<String> {
         let normalized = normalize_key(key)?;
         if let Some(value) = resolve_env_override(key, &normalized) {
@@ -109,6 +223,14 @@ impl Config {
     }
 }
 
+/// The profile named by `--profile` or `GRALPH_PROFILE`, if any.
+pub fn active_profile() -> Option<String> {
+    env::var(PROFILE_ENV)
+        .ok()
+        .map(|name| name.trim().to_string())
+        .filter(|name| !name.is_empty())
+}
+
 fn config_paths(project_dir: Option<&Path>) -> Vec<PathBuf> {
     let mut paths = Vec::new();
 
@@ -136,7 +258,9 @@ fn config_paths(project_dir: Option<&Path>) -> Vec<PathBuf> {
     paths
 }
 
-fn default_config_path() -> PathBuf {
+/// The config every user and project inherits: `GRALPH_DEFAULT_CONFIG`, the
+/// installed `config/default.yaml`, or the one in the source tree.
+pub fn default_config_path() -> PathBuf {
     if let Ok(path) = env::var("GRALPH_DEFAULT_CONFIG") {
         return PathBuf::from(path);
     }
@@ -157,14 +281,17 @@ fn default_config_path() -> PathBuf {
     PathBuf::from("config/default.yaml")
 }
 
-fn global_config_path() -> PathBuf {
+/// The per-user config: `GRALPH_GLOBAL_CONFIG` or `config.yaml` in
+/// [`config_dir`].
+pub fn global_config_path() -> PathBuf {
     if let Ok(path) = env::var("GRALPH_GLOBAL_CONFIG") {
         return PathBuf::from(path);
     }
     config_dir().join("config.yaml")
 }
 
-fn config_dir() -> PathBuf {
+/// The per-user gralph directory: `GRALPH_CONFIG_DIR` or `~/.config/gralph`.
+pub fn config_dir() -> PathBuf {
     if let Ok(path) = env::var("GRALPH_CONFIG_DIR") {
         return PathBuf::from(path);
     }
@@ -237,6 +364,10 @@ fn lookup_mapping_value<'a>(map: &'a Mapping, part: &str) -> Option<&'a Value> {
     matched
 }
 
+fn split_list_string(value: &str) -> Vec<String> {
+    shell_words::split(value).unwrap_or_else(|_| vec![value.to_string()])
+}
+
 fn value_to_string(value: &Value) -> Option<String> {
     match value {
         Value::String(text) => Some(text.clone()),
@@ -483,6 +614,7 @@ mod tests {
             "GRALPH_BACKEND",
             "GRALPH_MODEL",
             "GRALPH_TEST_FLAGS",
+            PROFILE_ENV,
         ] {
             remove_env(key);
         }
@@ -527,6 +659,111 @@ mod tests {
         remove_env("GRALPH_DEFAULT_CONFIG");
     }
 
+    #[test]
+    fn load_merges_selected_profile_over_project_config() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let default_path = temp.path().join("default.yaml");
+        let global_path = temp.path().join("global.yaml");
+        let project_dir = temp.path().join("project");
+
+        write_file(
+            &default_path,
+            "defaults:\n  backend: claude\n  max_iterations: 30\n",
+        );
+        write_file(
+            &global_path,
+            "profiles:\n  cheap:\n    defaults:\n      backend: gemini\n      max_iterations: 10\n",
+        );
+        write_file(
+            &project_dir.join(".gralph.yaml"),
+            "defaults:\n  backend: codex\n  max_iterations: 50\nprofiles:\n  cheap:\n    defaults:\n      model: flash\n",
+        );
+        set_env("GRALPH_DEFAULT_CONFIG", &default_path);
+        set_env("GRALPH_GLOBAL_CONFIG", &global_path);
+
+        let config = Config::load(Some(&project_dir)).unwrap();
+        assert_eq!(config.get("defaults.backend").as_deref(), Some("codex"));
+        let config = Config::load_with_profile(Some(&project_dir), Some("cheap")).unwrap();
+        assert_eq!(config.get("defaults.backend").as_deref(), Some("gemini"));
+        assert_eq!(active_profile(), None);
+
+        set_env(PROFILE_ENV, "cheap");
+        let config = Config::load(Some(&project_dir)).unwrap();
+        assert_eq!(config.get("defaults.backend").as_deref(), Some("gemini"));
+        assert_eq!(config.get("defaults.max_iterations").as_deref(), Some("10"));
+        assert_eq!(config.get("defaults.model").as_deref(), Some("flash"));
+        assert_eq!(
+            config.get_user("defaults.backend").as_deref(),
+            Some("gemini")
+        );
+
+        match Config::load_with_profile(Some(&project_dir), Some("quality")).unwrap_err() {
+            ConfigError::UnknownProfile { name, available } => {
+                assert_eq!(name, "quality");
+                assert_eq!(available, vec!["cheap".to_string()]);
+            }
+            other => panic!("expected unknown profile error, got {other:?}"),
+        }
+
+        clear_env_overrides();
+    }
+
+    #[test]
+    fn from_yaml_reads_no_config_files() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let default_path = temp.path().join("default.yaml");
+        write_file(&default_path, "defaults:\n  backend: claude\n");
+        set_env("GRALPH_DEFAULT_CONFIG", &default_path);
+
+        let config = Config::from_yaml("defaults:\n  max_iterations: 5\n").unwrap();
+        assert_eq!(config.get("defaults.max_iterations").as_deref(), Some("5"));
+        assert_eq!(config.get("defaults.backend"), None);
+        assert_eq!(Config::from_yaml("").unwrap().list(), Vec::new());
+        assert!(matches!(
+            Config::from_yaml("defaults: [").unwrap_err(),
+            ConfigError::Parse { .. }
+        ));
+
+        clear_env_overrides();
+    }
+
+    #[test]
+    fn get_list_keeps_sequence_items_and_splits_strings() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let default_path = temp.path().join("default.yaml");
+
+        write_file(
+            &default_path,
+            "backends:\n  claude:\n    extra_args: [\"--allowedTools\", \"Bash,Edit\"]\n  codex:\n    extra_args: \"--sandbox 'workspace write'\"\n",
+        );
+        set_env("GRALPH_DEFAULT_CONFIG", &default_path);
+        set_env("GRALPH_GLOBAL_CONFIG", temp.path().join("missing.yaml"));
+        let config = Config::load(None).unwrap();
+
+        assert_eq!(
+            config.get_list("backends.claude.extra_args"),
+            Some(vec!["--allowedTools".to_string(), "Bash,Edit".to_string()])
+        );
+        assert_eq!(
+            config.get_list("backends.codex.extra_args"),
+            Some(vec!["--sandbox".to_string(), "workspace write".to_string()])
+        );
+        assert_eq!(config.get_list("backends.gemini.extra_args"), None);
+
+        set_env("GRALPH_BACKENDS_GEMINI_EXTRA_ARGS", "--yolo --debug");
+        assert_eq!(
+            config.get_list("backends.gemini.extra_args"),
+            Some(vec!["--yolo".to_string(), "--debug".to_string()])
+        );
+
+        remove_env("GRALPH_BACKENDS_GEMINI_EXTRA_ARGS");
+        remove_env("GRALPH_GLOBAL_CONFIG");
+        remove_env("GRALPH_DEFAULT_CONFIG");
+    }
+
     #[test]
     fn normalize_key_trims_and_standardizes_segments() {
         assert_eq!(
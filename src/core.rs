@@ -1,14 +1,28 @@
-use crate::backend::{Backend, BackendError};
+use crate::backend::{Backend, BackendError, Usage};
+use crate::checkpoint::{self, Checkpoint};
 use crate::config::Config;
-use crate::task::{is_task_header, is_unchecked_line, task_blocks_from_contents};
+use crate::gitops::{self, GitError, GitSettings, IterationCommit};
+use crate::guard::{GuardSettings, ProtectedSnapshot};
+use crate::hooks::{self, HookContext, HookError, HookEvent};
+use crate::logging::{self, Level, LogError, LogSettings, Logger};
+use crate::prd;
+use crate::redact::Redactor;
+use crate::shutdown;
+use crate::task::{
+    is_task_block_end, is_task_header, is_unchecked_line, task_blocks_from_contents,
+};
+use crate::task_result;
+use crate::telemetry::{self, SpanKind};
+use crate::tracker::{self, TrackerSettings};
+use std::collections::{BTreeMap, HashSet};
 use std::error::Error;
 use std::fmt;
 use std::fs::{self, OpenOptions};
-use std::io::{self, Write};
+use std::io;
 use std::path::{Path, PathBuf};
 use std::time::{Duration, SystemTime, UNIX_EPOCH};
 
-pub const DEFAULT_PROMPT_TEMPLATE: &str = "Read {task_file} carefully. Find any task marked '- [ ]' (unchecked).\n\nIf unchecked tasks exist:\n- Complete ONE task fully\n- Mark it '- [x]' in {task_file}\n- Commit changes with a concise, lower-case conventional commit message (e.g. 'feat: add worktree collision checks')\n- Exit normally (do NOT output completion promise)\n\nIf ZERO '- [ ]' remain (all complete):\n- Verify by searching the file\n- Output ONLY: <promise>{completion_marker}</promise>\n\nCRITICAL: Never mention the promise unless outputting it as the completion signal.\n\n{context_files_section}Task Block:\n{task_block}\n\nIteration: {iteration}/{max_iterations}";
+pub const DEFAULT_PROMPT_TEMPLATE: &str = "Read {task_file} carefully. Find any task marked '- [ ]' (unchecked).\n\nIf unchecked tasks exist:\n- Complete ONE task fully\n- Check off each of its Checklist items as '* [x]' once it is done\n- Mark it '- [x]' in {task_file} only when all its Checklist items are done\n- Commit changes with a concise, lower-case conventional commit message (e.g. 'feat: add worktree collision checks')\n- End your reply with <gralph-result>{\"task\":\"<task ID>\",\"status\":\"done\",\"notes\":\"<one line>\"}</gralph-result>, with status done, partial, or blocked\n- Exit normally (do NOT output completion promise)\n\nIf ZERO '- [ ]' remain (all complete):\n- Verify by searching the file\n- Output ONLY: <promise>{completion_marker}</promise>\n\nCRITICAL: Never mention the promise unless outputting it as the completion signal.\n\n{context_files_section}{previous_failure}Task Block:\n{task_block}\n\nIteration: {iteration}/{max_iterations}";
 
 pub trait Clock: Send + Sync {
     fn now(&self) -> SystemTime;
@@ -28,11 +42,30 @@ impl Clock for SystemClock {
     }
 }
 
+const PAUSE_POLL_INTERVAL: Duration = Duration::from_secs(1);
+/// Lines of backend output kept for stall logs and failure feedback.
+const OUTPUT_TAIL_LINES: usize = 20;
+/// Diffs longer than this are cut before being sent to a completion reviewer.
+const REVIEW_DIFF_MAX_CHARS: usize = 100_000;
+/// Pause between iterations when the backend sets no `iteration_delay`.
+const ITERATION_GAP: Duration = Duration::from_secs(2);
+/// Wait after the first rate-limit error; doubled for each one after it.
+const DEFAULT_RATE_LIMIT_BACKOFF: u64 = 60;
+const MAX_RATE_LIMIT_BACKOFF: Duration = Duration::from_secs(30 * 60);
+const DEFAULT_RATE_LIMIT_RETRIES: u64 = 5;
+
 #[derive(Debug)]
 pub enum CoreError {
-    Io { path: PathBuf, source: io::Error },
+    Io {
+        path: PathBuf,
+        source: io::Error,
+    },
     Backend(BackendError),
+    Git(GitError),
+    Hook(HookError),
     InvalidInput(String),
+    /// The backend failed with a rate-limit error; holds the line that said so.
+    RateLimited(String),
 }
 
 impl fmt::Display for CoreError {
@@ -42,7 +75,10 @@ impl fmt::Display for CoreError {
                 write!(f, "core io error at {}: {}", path.display(), source)
             }
             CoreError::Backend(error) => write!(f, "backend error: {}", error),
+            CoreError::Git(error) => write!(f, "git error: {}", error),
+            CoreError::Hook(error) => write!(f, "hook error: {}", error),
             CoreError::InvalidInput(message) => write!(f, "invalid input: {}", message),
+            CoreError::RateLimited(reason) => write!(f, "rate limited: {}", reason),
         }
     }
 }
@@ -52,7 +88,9 @@ impl Error for CoreError {
         match self {
             CoreError::Io { source, .. } => Some(source),
             CoreError::Backend(error) => Some(error),
-            CoreError::InvalidInput(_) => None,
+            CoreError::Git(error) => Some(error),
+            CoreError::Hook(error) => Some(error),
+            CoreError::InvalidInput(_) | CoreError::RateLimited(_) => None,
         }
     }
 }
@@ -63,12 +101,38 @@ impl From<BackendError> for CoreError {
     }
 }
 
+impl From<GitError> for CoreError {
+    fn from(error: GitError) -> Self {
+        CoreError::Git(error)
+    }
+}
+
+impl From<LogError> for CoreError {
+    fn from(error: LogError) -> Self {
+        CoreError::Io {
+            path: error.path,
+            source: error.source,
+        }
+    }
+}
+
+impl From<HookError> for CoreError {
+    fn from(error: HookError) -> Self {
+        CoreError::Hook(error)
+    }
+}
+
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 pub enum LoopStatus {
     Running,
     Failed,
     Complete,
     MaxIterations,
+    Paused,
+    Stopped,
+    Stalled,
+    BudgetExceeded,
+    DeadlineExceeded,
 }
 
 impl LoopStatus {
@@ -78,6 +142,11 @@ impl LoopStatus {
             LoopStatus::Failed => "failed",
             LoopStatus::Complete => "complete",
             LoopStatus::MaxIterations => "max_iterations",
+            LoopStatus::Paused => "paused",
+            LoopStatus::Stopped => "stopped",
+            LoopStatus::Stalled => "stalled",
+            LoopStatus::BudgetExceeded => "budget_exceeded",
+            LoopStatus::DeadlineExceeded => "deadline_exceeded",
         }
     }
 }
@@ -86,6 +155,11 @@ impl LoopStatus {
 pub struct IterationResult {
     pub result: String,
     pub raw_output_file: Option<PathBuf>,
+    /// What the backend reported spending, if anything.
+    pub usage: Option<Usage>,
+    /// Conversation the loop's backend can resume, from
+    /// [`Backend::parse_session_id`]. None for task backend overrides.
+    pub session_id: Option<String>,
 }
 
 #[derive(Debug, Clone)]
@@ -94,12 +168,136 @@ pub struct LoopOutcome {
     pub iterations: u32,
     pub remaining_tasks: usize,
     pub duration_secs: u64,
+    /// Usage summed over the iterations that reported it.
+    pub usage: Usage,
+}
+
+/// Spend caps for one loop run, from `--max-cost`/`--max-tokens` or
+/// `defaults.max_cost_usd`/`defaults.max_tokens`. None means no cap.
+#[derive(Debug, Clone, Copy, Default, PartialEq)]
+pub struct LoopBudget {
+    pub max_cost_usd: Option<f64>,
+    pub max_tokens: Option<u64>,
+    /// Wall-clock limit from `--max-duration`. Checked between iterations,
+    /// so the iteration running when it passes is allowed to finish.
+    pub max_duration: Option<Duration>,
+}
+
+impl LoopBudget {
+    /// Reads the caps from config. An empty value or zero disables a cap.
+    pub fn from_config(config: Option<&Config>) -> Result<Self, CoreError> {
+        let value = |key: &str| {
+            config
+                .and_then(|cfg| cfg.get(key))
+                .map(|value| value.trim().to_string())
+                .filter(|value| !value.is_empty())
+        };
+        let max_cost_usd = match value("defaults.max_cost_usd") {
+            Some(raw) => {
+                let cost = raw
+                    .trim_start_matches('$')
+                    .parse::<f64>()
+                    .ok()
+                    .filter(|cost| cost.is_finite() && *cost >= 0.0)
+                    .ok_or_else(|| {
+                        CoreError::InvalidInput(format!(
+                            "defaults.max_cost_usd must be a non-negative number: {}",
+                            raw
+                        ))
+                    })?;
+                Some(cost)
+            }
+            None => None,
+        };
+        let max_tokens = match value("defaults.max_tokens") {
+            Some(raw) => Some(raw.parse::<u64>().map_err(|_| {
+                CoreError::InvalidInput(format!(
+                    "defaults.max_tokens must be a non-negative integer: {}",
+                    raw
+                ))
+            })?),
+            None => None,
+        };
+        Ok(Self {
+            max_cost_usd: max_cost_usd.filter(|cost| *cost > 0.0),
+            max_tokens: max_tokens.filter(|tokens| *tokens > 0),
+            max_duration: None,
+        })
+    }
+
+    /// What is left of the caps after `spent` and `elapsed`, for a loop that
+    /// continues a run already under way.
+    pub fn remaining(&self, spent: &Usage, elapsed: Duration) -> Self {
+        Self {
+            max_cost_usd: self
+                .max_cost_usd
+                .map(|max| (max - spent.cost_usd.unwrap_or(0.0)).max(0.0)),
+            max_tokens: self
+                .max_tokens
+                .map(|max| max.saturating_sub(spent.total_tokens())),
+            max_duration: self.max_duration.map(|max| max.saturating_sub(elapsed)),
+        }
+    }
+
+    /// Describes the first cap `usage` has reached, if any.
+    pub fn exceeded_by(&self, usage: &Usage) -> Option<String> {
+        if let (Some(max), Some(cost)) = (self.max_cost_usd, usage.cost_usd) {
+            if cost >= max {
+                return Some(format!("spent ${:.2} of the ${:.2} cap", cost, max));
+            }
+        }
+        if let Some(max) = self.max_tokens {
+            if usage.total_tokens() >= max {
+                return Some(format!(
+                    "used {} tokens of the {} token cap",
+                    usage.total_tokens(),
+                    max
+                ));
+            }
+        }
+        None
+    }
 }
 
 #[derive(Debug, Clone)]
 pub struct PromptRender {
     pub prompt: String,
     pub task_block: Option<String>,
+    /// Context files cut short or left out to fit the backend's
+    /// `max_context_tokens` budget.
+    pub context_warnings: Vec<String>,
+}
+
+/// Rough bytes per token used to size context files without a tokenizer.
+const BYTES_PER_TOKEN: usize = 4;
+
+/// Context files after sizing them against a token budget.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct ContextBudget {
+    /// Entries to list in the prompt, in their original order.
+    pub entries: Vec<String>,
+    pub warnings: Vec<String>,
+}
+
+/// Placeholders filled by [`render_prompt_template`]; custom variables cannot
+/// shadow them.
+const BUILTIN_PROMPT_VARS: [&str; 8] = [
+    "task_file",
+    "completion_marker",
+    "iteration",
+    "max_iterations",
+    "task_block",
+    "context_files",
+    "context_files_section",
+    "previous_failure",
+];
+
+/// Settings from a prompt template's YAML front-matter block.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct PromptFrontMatter {
+    pub preamble: Option<String>,
+    pub vars: BTreeMap<String, String>,
+    pub backends: BTreeMap<String, PromptFrontMatter>,
 }
 
 pub fn render_iteration_prompt(
@@ -110,6 +308,8 @@ pub fn render_iteration_prompt(
     completion_marker: &str,
     prompt_template: Option<&str>,
     config: Option<&Config>,
+    backend_name: Option<&str>,
+    previous_failure: Option<&str>,
 ) -> Result<PromptRender, CoreError> {
     if project_dir.as_os_str().is_empty() {
         return Err(CoreError::InvalidInput(
@@ -142,7 +342,7 @@ pub fn render_iteration_prompt(
         )));
     }
 
-    let resolved_template = resolve_prompt_template(project_dir, prompt_template)?;
+    let template = resolve_iteration_template(project_dir, prompt_template, config, backend_name)?;
     let mut task_block = get_next_unchecked_task_block(&full_task_path)?;
     if task_block.is_none() {
         let remaining = count_remaining_tasks(&full_task_path);
@@ -151,13 +351,24 @@ pub fn render_iteration_prompt(
         }
     }
 
-    let context_files = config
+    let mut context_files = config
         .and_then(|cfg| cfg.get("defaults.context_files"))
         .unwrap_or_default();
-    let normalized_context_files = normalize_context_files(&context_files);
+    let max_context_tokens = resolve_max_context_tokens(config, backend_name)?;
+    if max_context_tokens.is_some() {
+        // The task's Context Bundle counts against the same budget.
+        if let Some(block) = task_block.as_deref() {
+            for entry in prd::prd_task_context_entries(block) {
+                context_files.push(',');
+                context_files.push_str(&entry);
+            }
+        }
+    }
+    let budget = fit_context_files(project_dir, &context_files, max_context_tokens);
+    let normalized_context_files = budget.entries.join("\n");
 
     let prompt = render_prompt_template(
-        &resolved_template,
+        &template,
         task_file,
         completion_marker,
         iteration,
@@ -168,9 +379,95 @@ pub fn render_iteration_prompt(
         } else {
             Some(normalized_context_files.as_str())
         },
+        previous_failure,
+    );
+
+    Ok(PromptRender {
+        prompt,
+        task_block,
+        context_warnings: budget.warnings,
+    })
+}
+
+/// Renders the prompt for one named task block. The block's Context Bundle
+/// entries are listed after the configured `defaults.context_files`.
+pub fn render_task_prompt(
+    project_dir: &Path,
+    task_file: &str,
+    task_id: &str,
+    completion_marker: &str,
+    prompt_template: Option<&str>,
+    config: Option<&Config>,
+    backend_name: Option<&str>,
+) -> Result<PromptRender, CoreError> {
+    if task_id.trim().is_empty() {
+        return Err(CoreError::InvalidInput("task id is required".to_string()));
+    }
+    if !project_dir.is_dir() {
+        return Err(CoreError::InvalidInput(format!(
+            "project directory does not exist: {}",
+            project_dir.display()
+        )));
+    }
+    let full_task_path = project_dir.join(task_file);
+    if !full_task_path.is_file() {
+        return Err(CoreError::InvalidInput(format!(
+            "task file does not exist: {}",
+            full_task_path.display()
+        )));
+    }
+
+    let block = find_task_block(&full_task_path, task_id)?.ok_or_else(|| {
+        CoreError::InvalidInput(format!(
+            "task not found in {}: {}",
+            full_task_path.display(),
+            task_id
+        ))
+    })?;
+    if !block.lines().any(is_unchecked_line) {
+        return Err(CoreError::InvalidInput(format!(
+            "task is already complete: {}",
+            task_id
+        )));
+    }
+
+    let template = resolve_iteration_template(project_dir, prompt_template, config, backend_name)?;
+    let mut context_files = config
+        .and_then(|cfg| cfg.get("defaults.context_files"))
+        .unwrap_or_default();
+    for entry in prd::prd_task_context_entries(&block) {
+        context_files.push(',');
+        context_files.push_str(&entry);
+    }
+    let max_context_tokens = resolve_max_context_tokens(config, backend_name)?;
+    let budget = fit_context_files(project_dir, &context_files, max_context_tokens);
+    let normalized_context_files = budget.entries.join("\n");
+
+    let prompt = render_prompt_template(
+        &template,
+        task_file,
+        completion_marker,
+        1,
+        1,
+        Some(&block),
+        if normalized_context_files.is_empty() {
+            None
+        } else {
+            Some(normalized_context_files.as_str())
+        },
+        None,
+    );
+    let prompt = format!(
+        "Work only on task {} below. Do not start any other task.\n\n{}",
+        task_id.trim(),
+        prompt
     );
 
-    Ok(PromptRender { prompt, task_block })
+    Ok(PromptRender {
+        prompt,
+        task_block: Some(block),
+        context_warnings: budget.warnings,
+    })
 }
 
 pub fn run_iteration<B: Backend + ?Sized>(
@@ -215,6 +512,75 @@ pub fn run_iteration_with_clock<B: Backend + ?Sized>(
     prompt_template: Option<&str>,
     config: Option<&Config>,
     clock: &dyn Clock,
+) -> Result<IterationResult, CoreError> {
+    telemetry::with_tracing(config, || {
+        let mut span = iteration_span(
+            iteration,
+            max_iterations,
+            prd::prd_next_task_id(&project_dir.join(task_file)).as_deref(),
+        );
+        let result = run_iteration_with_logger(
+            backend,
+            project_dir,
+            task_file,
+            iteration,
+            max_iterations,
+            completion_marker,
+            model,
+            variant,
+            &config_logger(log_file, config),
+            prompt_template,
+            config,
+            None,
+            None,
+            clock,
+        );
+        record_span_result(&mut span, &result);
+        result
+    })
+}
+
+/// The trace span for one iteration; it ends when dropped.
+fn iteration_span(iteration: u32, max_iterations: u32, task: Option<&str>) -> telemetry::Span {
+    let mut span = telemetry::start_span("gralph.iteration", SpanKind::Internal)
+        .with_attribute("gralph.iteration", iteration)
+        .with_attribute("gralph.max_iterations", max_iterations);
+    if let Some(task) = task {
+        span.set_attribute("gralph.task", task);
+    }
+    span
+}
+
+/// Records how an iteration ended on its span as `gralph.exit_status`.
+fn record_span_result<T>(span: &mut telemetry::Span, result: &Result<T, CoreError>) {
+    match result {
+        Ok(_) => span.set_attribute("gralph.exit_status", "ok"),
+        Err(err) => {
+            let status = match err {
+                CoreError::RateLimited(_) => "rate_limited",
+                _ => "failed",
+            };
+            span.set_attribute("gralph.exit_status", status);
+            span.set_error(err.to_string());
+        }
+    }
+}
+
+fn run_iteration_with_logger<B: Backend + ?Sized>(
+    backend: &B,
+    project_dir: &Path,
+    task_file: &str,
+    iteration: u32,
+    max_iterations: u32,
+    completion_marker: &str,
+    model: Option<&str>,
+    variant: Option<&str>,
+    logger: &Logger,
+    prompt_template: Option<&str>,
+    config: Option<&Config>,
+    previous_failure: Option<&str>,
+    on_prompt: Option<&mut dyn FnMut(&str)>,
+    clock: &dyn Clock,
 ) -> Result<IterationResult, CoreError> {
     if project_dir.as_os_str().is_empty() {
         return Err(CoreError::InvalidInput(
@@ -253,10 +619,21 @@ pub fn run_iteration_with_clock<B: Backend + ?Sized>(
         ));
     }
 
-    let tmpfile = create_temp_file_with_clock("gralph-iteration", clock)?;
-
-    let raw_output_file = log_file.map(|path| raw_log_path(path));
-
+    let task_override = TaskOverride::resolve(
+        get_next_unchecked_task_block(&full_task_path)?.as_deref(),
+        backend,
+        model,
+        variant,
+        config,
+    )?;
+    let (task_backend, model, variant) = match task_override.as_ref() {
+        Some(task_override) => (
+            task_override.backend.as_deref(),
+            task_override.model.as_deref(),
+            task_override.variant.as_deref(),
+        ),
+        None => (None, model, variant),
+    };
     let prompt = render_iteration_prompt(
         project_dir,
         task_file,
@@ -265,53 +642,204 @@ pub fn run_iteration_with_clock<B: Backend + ?Sized>(
         completion_marker,
         prompt_template,
         config,
-    )?
-    .prompt;
+        Some(task_backend.map_or(backend.name(), |task_backend| task_backend.name())),
+        previous_failure,
+    )?;
+    for warning in &prompt.context_warnings {
+        logger.warn(warning)?;
+    }
+    if let Some(on_prompt) = on_prompt {
+        on_prompt(&prompt.prompt);
+    }
+
+    if let Some(task_override) = task_override.as_ref() {
+        logger.info(&task_override.describe(backend.name()))?;
+    }
+
+    execute_prompt(
+        backend,
+        task_backend,
+        &prompt.prompt,
+        model,
+        variant,
+        project_dir,
+        logger,
+        clock,
+    )
+}
+
+/// Runs exactly one task block through the backend, outside of any loop.
+///
+/// The task's checkbox is ticked when the backend succeeds and left (or put
+/// back) unchecked when it fails, whatever the backend did to the file.
+pub fn run_task_with_clock<B: Backend + ?Sized>(
+    backend: &B,
+    project_dir: &Path,
+    task_file: &str,
+    task_id: &str,
+    completion_marker: &str,
+    model: Option<&str>,
+    variant: Option<&str>,
+    log_file: Option<&Path>,
+    prompt_template: Option<&str>,
+    config: Option<&Config>,
+    clock: &dyn Clock,
+) -> Result<IterationResult, CoreError> {
+    let prompt = render_task_prompt(
+        project_dir,
+        task_file,
+        task_id,
+        completion_marker,
+        prompt_template,
+        config,
+        Some(backend.name()),
+    )?;
+
+    if !backend.check_installed() {
+        return Err(CoreError::InvalidInput(
+            "backend is not installed".to_string(),
+        ));
+    }
+
+    let full_task_path = project_dir.join(task_file);
+    let logger = config_logger(log_file, config).with("task", task_id);
+    for warning in &prompt.context_warnings {
+        logger.warn(warning)?;
+    }
+    let task_override = TaskOverride::resolve(
+        prompt.task_block.as_deref(),
+        backend,
+        model,
+        variant,
+        config,
+    )?;
+    if let Some(task_override) = task_override.as_ref() {
+        logger.info(&task_override.describe(backend.name()))?;
+    }
+    let (task_backend, model, variant) = match task_override.as_ref() {
+        Some(task_override) => (
+            task_override.backend.as_deref(),
+            task_override.model.as_deref(),
+            task_override.variant.as_deref(),
+        ),
+        None => (None, model, variant),
+    };
+    match execute_prompt(
+        backend,
+        task_backend,
+        &prompt.prompt,
+        model,
+        variant,
+        project_dir,
+        &logger,
+        clock,
+    ) {
+        Ok(result) => {
+            set_task_checked(&full_task_path, task_id, true)?;
+            Ok(result)
+        }
+        Err(err) => {
+            let _ = set_task_checked(&full_task_path, task_id, false);
+            Err(err)
+        }
+    }
+}
+
+/// Runs `prompt` through `backend`, or through `task_backend` (by way of
+/// [`Backend::run_iteration_on`]) when the task has its own backend.
+fn execute_prompt<B: Backend + ?Sized>(
+    backend: &B,
+    task_backend: Option<&dyn Backend>,
+    prompt: &str,
+    model: Option<&str>,
+    variant: Option<&str>,
+    project_dir: &Path,
+    logger: &Logger,
+    clock: &dyn Clock,
+) -> Result<IterationResult, CoreError> {
+    let tmpfile = create_temp_file_with_clock("gralph-iteration", clock)?;
+
+    let raw_output_file = logger.path().map(raw_log_path);
 
-    let backend_result = backend.run_iteration(&prompt, model, variant, &tmpfile, project_dir);
+    // Background loops have no terminal, so what the backend prints is
+    // copied into the session log as it arrives for `gralph logs --follow`.
+    let live_logger = logger.clone();
+    let mut backend_span = telemetry::start_span("gralph.backend", SpanKind::Client)
+        .with_attribute(
+            "gralph.backend",
+            task_backend.map_or(backend.name(), |task_backend| task_backend.name()),
+        );
+    if let Some(model) = model {
+        backend_span.set_attribute("gralph.model", model);
+    }
+    if let Some(variant) = variant {
+        backend_span.set_attribute("gralph.variant", variant);
+    }
+    let backend_result = crate::backend::with_live_output(
+        move |line| {
+            let _ = live_logger.output(line);
+        },
+        || match task_backend {
+            Some(task_backend) => backend.run_iteration_on(
+                task_backend,
+                prompt,
+                model,
+                variant,
+                &tmpfile,
+                project_dir,
+            ),
+            None => backend.run_iteration(prompt, model, variant, &tmpfile, project_dir),
+        },
+    );
+    match &backend_result {
+        Ok(()) => backend_span.set_attribute("gralph.exit_status", "ok"),
+        Err(error) => {
+            backend_span.set_attribute("gralph.exit_status", "failed");
+            backend_span.set_error(error.to_string());
+        }
+    }
+    backend_span.end();
 
     if let Some(raw_path) = raw_output_file.as_ref() {
-        if let Err(err) = copy_if_exists(&tmpfile, raw_path) {
-            log_message(
-                log_file,
-                &format!("Warning: failed to copy raw output: {}", err),
-            )?;
+        if let Err(err) = logger.settings().rotate_if_needed(raw_path) {
+            logger.warn(&format!("failed to rotate raw output: {}", err))?;
+        }
+        if let Err(err) = copy_if_exists(&tmpfile, raw_path, logger.redactor()) {
+            logger.warn(&format!("failed to copy raw output: {}", err))?;
         }
     }
 
-    if backend_result.is_err() {
+    if let Err(error) = backend_result {
         if fs::metadata(&tmpfile).map(|meta| meta.len()).unwrap_or(0) == 0 {
             if let Some(raw_path) = raw_output_file.as_ref() {
-                log_message(
-                    log_file,
-                    &format!("Raw output saved to: {}", raw_path.display()),
-                )?;
+                logger.info(&format!("Raw output saved to: {}", raw_path.display()))?;
             }
         }
-        return Err(backend_result.err().unwrap().into());
+        let output = fs::read_to_string(&tmpfile).unwrap_or_default();
+        if let Some(reason) = rate_limit_reason(&error.to_string(), &output) {
+            return Err(CoreError::RateLimited(reason));
+        }
+        return Err(error.into());
     }
 
     if fs::metadata(&tmpfile).map(|meta| meta.len()).unwrap_or(0) == 0 {
-        log_message(log_file, "Error: backend produced no JSON output.")?;
+        logger.error("backend produced no JSON output.")?;
         if let Some(raw_path) = raw_output_file.as_ref() {
-            log_message(
-                log_file,
-                &format!("Raw output saved to: {}", raw_path.display()),
-            )?;
+            logger.info(&format!("Raw output saved to: {}", raw_path.display()))?;
         }
         return Err(CoreError::InvalidInput(
             "backend produced no output".to_string(),
         ));
     }
 
-    let result = backend.parse_text(&tmpfile)?;
+    let result = match task_backend {
+        Some(task_backend) => task_backend.parse_text(&tmpfile)?,
+        None => backend.parse_text(&tmpfile)?,
+    };
     if result.trim().is_empty() {
-        log_message(log_file, "Error: backend returned no parsed result.")?;
+        logger.error("backend returned no parsed result.")?;
         if let Some(raw_path) = raw_output_file.as_ref() {
-            log_message(
-                log_file,
-                &format!("Raw output saved to: {}", raw_path.display()),
-            )?;
+            logger.info(&format!("Raw output saved to: {}", raw_path.display()))?;
         }
         return Err(CoreError::InvalidInput(
             "backend returned no parsed result".to_string(),
@@ -321,9 +849,99 @@ pub fn run_iteration_with_clock<B: Backend + ?Sized>(
     Ok(IterationResult {
         result,
         raw_output_file,
+        usage: match task_backend {
+            Some(task_backend) => task_backend.parse_usage(&tmpfile),
+            None => backend.parse_usage(&tmpfile),
+        },
+        session_id: match task_backend {
+            Some(_) => None,
+            None => backend.parse_session_id(&tmpfile),
+        },
     })
 }
 
+/// The backend and model a task block asks for in its `- **Backend**` and
+/// `- **Model**` fields, resolved against the loop's.
+struct TaskOverride {
+    /// Set when the task names a backend other than the loop's.
+    backend: Option<Box<dyn Backend>>,
+    model: Option<String>,
+    variant: Option<String>,
+}
+
+impl TaskOverride {
+    /// None when the block has neither field. A task that switches backend
+    /// without naming a model gets that backend's `<name>.default_model`,
+    /// and the loop's variant only when the backend can map it.
+    fn resolve<B: Backend + ?Sized>(
+        block: Option<&str>,
+        backend: &B,
+        model: Option<&str>,
+        variant: Option<&str>,
+        config: Option<&Config>,
+    ) -> Result<Option<Self>, CoreError> {
+        let Some(block) = block else {
+            return Ok(None);
+        };
+        let task_backend = prd::prd_task_backend(block);
+        let task_model = prd::prd_task_model(block);
+        if task_backend.is_none() && task_model.is_none() {
+            return Ok(None);
+        }
+        let task_backend = match task_backend.filter(|name| name != backend.name()) {
+            Some(name) => {
+                let built = match config {
+                    Some(config) => crate::backend::backend_from_config(&name, config, &[]),
+                    None => crate::backend::backend_from_name(&name),
+                }
+                .and_then(|built| {
+                    let offline = config.is_some_and(Config::offline);
+                    crate::backend::ensure_network_allowed(built.as_ref(), offline)?;
+                    Ok(built)
+                })
+                .map_err(CoreError::InvalidInput)?;
+                if !built.check_installed() {
+                    return Err(CoreError::InvalidInput(format!(
+                        "task backend is not installed: {}",
+                        name
+                    )));
+                }
+                Some(built)
+            }
+            None => None,
+        };
+        let (model, variant) = match task_backend.as_deref() {
+            Some(task_backend) => (
+                task_model.or_else(|| {
+                    config
+                        .and_then(|config| {
+                            config.get(&format!("{}.default_model", task_backend.name()))
+                        })
+                        .filter(|model| !model.is_empty())
+                }),
+                variant.filter(|variant| task_backend.check_variant(variant).is_ok()),
+            ),
+            None => (task_model.or(model.map(str::to_string)), variant),
+        };
+        Ok(Some(Self {
+            backend: task_backend,
+            model,
+            variant: variant.map(str::to_string),
+        }))
+    }
+
+    fn describe(&self, loop_backend: &str) -> String {
+        format!(
+            "Task runs on backend {} (model {})",
+            self.backend
+                .as_deref()
+                .map(|backend| backend.name())
+                .unwrap_or(loop_backend),
+            self.model.as_deref().unwrap_or("default")
+        )
+    }
+}
+
 pub fn count_remaining_tasks(task_file: &Path) -> usize {
     if task_file.as_os_str().is_empty() || !task_file.is_file() {
         return 0;
@@ -348,6 +966,121 @@ pub fn count_remaining_tasks(task_file: &Path) -> usize {
     }
 }
 
+/// Expands a `--task-file` value into the files to work through, in order.
+/// Several files can be listed separated by commas, and `*` in a file name
+/// matches within its directory. Files whose tasks depend on IDs defined in
+/// another listed file come after it; otherwise the listed order holds, with
+/// glob matches sorted by name. A single plain path is returned unchanged.
+pub fn resolve_task_files(project_dir: &Path, task_file: &str) -> Result<Vec<String>, CoreError> {
+    let entries: Vec<&str> = task_file
+        .split(',')
+        .map(str::trim)
+        .filter(|entry| !entry.is_empty())
+        .collect();
+    if entries.len() <= 1 && !task_file.contains('*') {
+        return Ok(vec![task_file.trim().to_string()]);
+    }
+
+    let mut files: Vec<String> = Vec::new();
+    for entry in entries {
+        let matches = if entry.contains('*') {
+            glob_task_files(project_dir, entry)?
+        } else {
+            vec![entry.to_string()]
+        };
+        for file in matches {
+            if !files.contains(&file) {
+                files.push(file);
+            }
+        }
+    }
+    let contents: Vec<String> = files
+        .iter()
+        .map(|file| fs::read_to_string(project_dir.join(file)).unwrap_or_default())
+        .collect();
+    Ok(prd::prd_order_task_files(&contents)
+        .into_iter()
+        .map(|index| files[index].clone())
+        .collect())
+}
+
+fn glob_task_files(project_dir: &Path, pattern: &str) -> Result<Vec<String>, CoreError> {
+    let (dir, name) = match pattern.rsplit_once('/') {
+        Some((dir, name)) => (Some(dir), name),
+        None => (None, pattern),
+    };
+    if dir.is_some_and(|dir| dir.contains('*')) {
+        return Err(CoreError::InvalidInput(format!(
+            "task file pattern may only use * in the file name: {}",
+            pattern
+        )));
+    }
+    let search_dir = dir.map_or_else(|| project_dir.to_path_buf(), |dir| project_dir.join(dir));
+    let mut names: Vec<String> = fs::read_dir(&search_dir)
+        .map_err(|source| CoreError::Io {
+            path: search_dir.clone(),
+            source,
+        })?
+        .filter_map(|entry| entry.ok())
+        .filter(|entry| entry.path().is_file())
+        .filter_map(|entry| entry.file_name().to_str().map(str::to_string))
+        .filter(|file_name| wildcard_match(name, file_name))
+        .collect();
+    if names.is_empty() {
+        return Err(CoreError::InvalidInput(format!(
+            "no task files match {}",
+            pattern
+        )));
+    }
+    names.sort();
+    Ok(names
+        .into_iter()
+        .map(|file_name| match dir {
+            Some(dir) => format!("{}/{}", dir, file_name),
+            None => file_name,
+        })
+        .collect())
+}
+
+fn wildcard_match(pattern: &str, value: &str) -> bool {
+    let parts: Vec<&str> = pattern.split('*').collect();
+    let (first, last) = (parts[0], parts[parts.len() - 1]);
+    if !value.starts_with(first) || value.len() < first.len() + last.len() {
+        return false;
+    }
+    let mut rest = &value[first.len()..];
+    for part in &parts[1..parts.len() - 1] {
+        match rest.find(part) {
+            Some(index) => rest = &rest[index + part.len()..],
+            None => return false,
+        }
+    }
+    rest.ends_with(last)
+}
+
+/// Unchecked tasks summed over every file in a `--task-file` value. A value
+/// that no longer resolves is counted as a single path.
+pub fn count_remaining_for(project_dir: &Path, task_file: &str) -> usize {
+    resolve_task_files(project_dir, task_file)
+        .unwrap_or_else(|_| vec![task_file.to_string()])
+        .iter()
+        .map(|file| count_remaining_tasks(&project_dir.join(file)))
+        .sum()
+}
+
+/// The file in a `--task-file` value being worked on: the first, in order,
+/// with unchecked tasks, or the last once all are done.
+pub fn active_task_file(project_dir: &Path, task_file: &str) -> String {
+    let files =
+        resolve_task_files(project_dir, task_file).unwrap_or_else(|_| vec![task_file.to_string()]);
+    files
+        .iter()
+        .find(|file| count_remaining_tasks(&project_dir.join(file)) > 0)
+        .or(files.last())
+        .cloned()
+        .unwrap_or_else(|| task_file.to_string())
+}
+
 pub fn check_completion(
     task_file: &Path,
     result: &str,
@@ -409,6 +1142,8 @@ pub fn run_loop<B: Backend + ?Sized>(
         prompt_template,
         config,
         state_callback,
+        None,
+        LoopBudget::from_config(config)?,
         &SystemClock,
     )
 }
@@ -424,12 +1159,72 @@ pub fn run_loop_with_clock<B: Backend + ?Sized>(
     session_name: Option<&str>,
     prompt_template: Option<&str>,
     config: Option<&Config>,
-    mut state_callback: Option<&mut dyn FnMut(Option<&str>, u32, LoopStatus, usize)>,
+    state_callback: Option<&mut dyn FnMut(Option<&str>, u32, LoopStatus, usize)>,
+    reviewer: Option<&CompletionReviewer>,
+    budget: LoopBudget,
     clock: &dyn Clock,
 ) -> Result<LoopOutcome, CoreError> {
-    if project_dir.as_os_str().is_empty() {
-        return Err(CoreError::InvalidInput(
-            "project_dir is required".to_string(),
+    telemetry::with_tracing(config, || {
+        let mut span = telemetry::start_span("gralph.loop", SpanKind::Internal)
+            .with_attribute("gralph.session", session_name.unwrap_or("gralph"))
+            .with_attribute("gralph.backend", backend.name());
+        if let Some(model) = model {
+            span.set_attribute("gralph.model", model);
+        }
+        let result = run_traced_loop(
+            backend,
+            project_dir,
+            task_file,
+            max_iterations,
+            completion_marker,
+            model,
+            variant,
+            session_name,
+            prompt_template,
+            config,
+            state_callback,
+            reviewer,
+            budget,
+            clock,
+        );
+        match &result {
+            Ok(outcome) => {
+                span.set_attribute("gralph.exit_status", outcome.status.as_str());
+                span.set_attribute("gralph.iterations", outcome.iterations);
+                span.set_attribute("gralph.remaining_tasks", outcome.remaining_tasks);
+                if outcome.status == LoopStatus::Failed {
+                    span.set_error("loop failed");
+                }
+            }
+            Err(err) => {
+                span.set_attribute("gralph.exit_status", "error");
+                span.set_error(err.to_string());
+            }
+        }
+        result
+    })
+}
+
+/// The loop itself; [`run_loop_with_clock`] wraps it in the loop's span.
+fn run_traced_loop<B: Backend + ?Sized>(
+    backend: &B,
+    project_dir: &Path,
+    task_file: Option<&str>,
+    max_iterations: Option<u32>,
+    completion_marker: Option<&str>,
+    model: Option<&str>,
+    variant: Option<&str>,
+    session_name: Option<&str>,
+    prompt_template: Option<&str>,
+    config: Option<&Config>,
+    mut state_callback: Option<&mut dyn FnMut(Option<&str>, u32, LoopStatus, usize)>,
+    reviewer: Option<&CompletionReviewer>,
+    budget: LoopBudget,
+    clock: &dyn Clock,
+) -> Result<LoopOutcome, CoreError> {
+    if project_dir.as_os_str().is_empty() {
+        return Err(CoreError::InvalidInput(
+            "project_dir is required".to_string(),
         ));
     }
 
@@ -462,6 +1257,15 @@ pub fn run_loop_with_clock<B: Backend + ?Sized>(
         )));
     }
 
+    let stall_iterations = resolve_stall_iterations(config)?;
+    let continuous_session = resolve_continuous_session(config);
+    let pacing = Pacing::from_config(config, backend.name())?;
+    let tracker = config
+        .map(TrackerSettings::from_config)
+        .transpose()
+        .map_err(|err| CoreError::InvalidInput(err.to_string()))?
+        .flatten();
+
     let gralph_dir = project_dir.join(".gralph");
     fs::create_dir_all(&gralph_dir).map_err(|source| CoreError::Io {
         path: gralph_dir.clone(),
@@ -472,51 +1276,215 @@ pub fn run_loop_with_clock<B: Backend + ?Sized>(
 
     let log_name = session_name.unwrap_or("gralph");
     let log_file = gralph_dir.join(format!("{}.log", log_name));
+    let logger = config_logger(Some(&log_file), config)
+        .with("session", log_name)
+        .with("backend", backend.name());
 
-    let loop_start = clock.now();
+    let mut loop_start = clock.now();
     let mut iteration = 1;
 
-    log_message(
-        Some(&log_file),
-        &format!("Starting gralph loop in {}", project_dir.display()),
-    )?;
-    log_message(Some(&log_file), &format!("Task file: {}", task_file))?;
-    log_message(
-        Some(&log_file),
-        &format!("Max iterations: {}", max_iterations),
-    )?;
-    log_message(
-        Some(&log_file),
-        &format!("Completion marker: {}", completion_marker),
-    )?;
+    logger.info(&format!(
+        "Starting gralph loop in {}",
+        project_dir.display()
+    ))?;
+    logger.info(&format!("Task file: {}", task_file))?;
+    logger.info(&format!("Max iterations: {}", max_iterations))?;
+    if stall_iterations > 0 {
+        logger.info(&format!("Stall limit: {} iterations", stall_iterations))?;
+    }
+    if continuous_session {
+        logger.info("Continuous session: resuming the backend conversation each iteration")?;
+    }
+    if let Some(max) = budget.max_cost_usd {
+        logger.info(&format!("Cost cap: ${:.2}", max))?;
+    }
+    if let Some(max) = budget.max_tokens {
+        logger.info(&format!("Token cap: {}", max))?;
+    }
+    if let Some(max) = budget.max_duration {
+        logger.info(&format!("Time limit: {}", format_duration(max.as_secs())))?;
+    }
+    if pacing.iteration_delay > ITERATION_GAP {
+        logger.info(&format!(
+            "Iteration delay: {}s",
+            pacing.iteration_delay.as_secs()
+        ))?;
+    }
+    if let Some(tracker) = &tracker {
+        logger.info(&format!("Issue tracker: {}", tracker.provider.as_str()))?;
+    }
+    logger.info(&format!("Completion marker: {}", completion_marker))?;
+    let estimate =
+        prd::prd_estimate_contents(&fs::read_to_string(&full_task_path).unwrap_or_default());
+    if let Some(suggested) = estimate.suggested_max_iterations() {
+        logger.info(&format!(
+            "Task estimates: {} iterations for {} remaining tasks",
+            estimate.total(),
+            estimate.estimated_tasks + estimate.unestimated_tasks
+        ))?;
+        if estimate.total() > max_iterations {
+            logger.warn(&format!(
+                "task estimates add up to {} iterations, more than max iterations ({}); consider --max-iterations {}",
+                estimate.total(),
+                max_iterations,
+                suggested
+            ))?;
+        }
+    }
     if let Some(model) = model {
-        log_message(Some(&log_file), &format!("Model: {}", model))?;
+        logger.info(&format!("Model: {}", model))?;
     }
     if let Some(variant) = variant {
-        log_message(Some(&log_file), &format!("Variant: {}", variant))?;
+        logger.info(&format!("Variant: {}", variant))?;
     }
-    log_message(
-        Some(&log_file),
-        &format!("Started at: {}", format_timestamp(loop_start)),
-    )?;
+    logger.info(&format!("Started at: {}", format_timestamp(loop_start)))?;
+
+    let mut resume_from = match session_name.map(|name| checkpoint::load(&gralph_dir, name)) {
+        Some(Ok(Some(resumed))) if resumed.task_file == task_file => Some(resumed),
+        Some(Err(err)) => {
+            logger.warn(&format!("ignoring checkpoint: {}", err))?;
+            None
+        }
+        _ => None,
+    };
+    if let Some(resumed) = &resume_from {
+        iteration = resumed.next_iteration();
+        logger.info(&format!(
+            "Resuming from checkpoint at iteration {}/{}",
+            iteration, max_iterations
+        ))?;
+        // Budget caps and the deadline count the time and usage spent
+        // before the restart.
+        let spent = resumed.spent();
+        loop_start = loop_start
+            .checked_sub(Duration::from_secs(resumed.elapsed_secs))
+            .unwrap_or(loop_start);
+        logger.info(&format!(
+            "Already spent: {}, {} tokens",
+            format_duration(resumed.elapsed_secs),
+            spent.total_tokens()
+        ))?;
+        if !resumed.finished {
+            rollback_interrupted_iteration(&project_dir, resumed, &logger)?;
+        }
+    }
+    let clear_checkpoint = || {
+        if let Some(name) = session_name {
+            let _ = checkpoint::clear(&gralph_dir, name);
+        }
+    };
 
     let initial_remaining = count_remaining_tasks(&full_task_path);
-    log_message(
-        Some(&log_file),
-        &format!("Initial remaining tasks: {}", initial_remaining),
+    logger.info(&format!("Initial remaining tasks: {}", initial_remaining))?;
+
+    let git = config
+        .map(GitSettings::from_config)
+        .filter(|settings| settings.enabled() && gitops::repo_root(&project_dir).is_some())
+        .unwrap_or_default();
+    if git.strict {
+        gitops::ensure_clean(&project_dir)?;
+    }
+    if git.branch_per_session {
+        let branch = gitops::checkout_session_branch(&project_dir, log_name)?;
+        logger.info(&format!("Git branch: {}", branch))?;
+    }
+    let mut guard = config
+        .map(GuardSettings::from_config)
+        .transpose()
+        .map_err(|err| CoreError::InvalidInput(err.to_string()))?
+        .flatten();
+    if guard.is_some() && gitops::repo_root(&project_dir).is_none() {
+        logger.warn(
+            "guard.mode is set but the project is not a git repository; file guard disabled",
+        )?;
+        guard = None;
+    }
+    if let Some(guard) = &guard {
+        logger.info(&format!("File guard: {}", guard.mode.as_str()))?;
+        if !guard.protected_paths.is_empty() {
+            logger.info(&format!(
+                "Protected paths: {}",
+                guard.protected_paths.join(", ")
+            ))?;
+        }
+    }
+    let task_files =
+        resolve_task_files(&project_dir, task_file).unwrap_or_else(|_| vec![task_file.to_string()]);
+    let review_base = reviewer.and_then(|_| gitops::head_commit(&project_dir));
+    let initial_tasks = task_states(&full_task_path);
+
+    run_lifecycle_hook(
+        config,
+        HookEvent::PreStart,
+        &project_dir,
+        &logger,
+        &HookContext {
+            session: log_name,
+            iteration,
+            remaining: initial_remaining,
+        },
     )?;
 
+    let pause_file = pause_file_path(&project_dir, session_name);
+    let session_logger = logger.clone();
+    let mut iterations_without_progress = 0;
+    let mut previous_failure: Option<String> = None;
+    let mut usage = resume_from
+        .as_ref()
+        .map(Checkpoint::spent)
+        .unwrap_or_default();
+    let mut warned_no_cost = false;
+    let mut rate_limited = 0;
+    let mut session_id: Option<String> = None;
     while iteration <= max_iterations {
+        let logger = session_logger.with("iteration", iteration);
+        if pause_file.exists() {
+            logger.info(&format!(
+                "Paused before iteration {}/{}; remove {} to continue",
+                iteration,
+                max_iterations,
+                pause_file.display()
+            ))?;
+            if let Some(callback) = state_callback.as_deref_mut() {
+                callback(
+                    session_name,
+                    iteration,
+                    LoopStatus::Paused,
+                    count_remaining_tasks(&full_task_path),
+                );
+            }
+            while pause_file.exists() && shutdown::requested().is_none() {
+                clock.sleep(PAUSE_POLL_INTERVAL);
+            }
+            if shutdown::requested().is_none() {
+                logger.info("Resumed")?;
+            }
+        }
+
+        if let Some(signal) = shutdown::requested() {
+            let remaining = count_remaining_tasks(&full_task_path);
+            if let Some(callback) = state_callback.as_deref_mut() {
+                callback(session_name, iteration, LoopStatus::Stopped, remaining);
+            }
+            return stopped_outcome(
+                &logger,
+                signal,
+                iteration - 1,
+                remaining,
+                usage,
+                loop_start,
+                clock,
+            );
+        }
+
         let remaining_before = count_remaining_tasks(&full_task_path);
 
-        log_message(Some(&log_file), "")?;
-        log_message(
-            Some(&log_file),
-            &format!(
-                "=== Iteration {}/{} (Remaining: {}) ===",
-                iteration, max_iterations, remaining_before
-            ),
-        )?;
+        logger.info("")?;
+        logger.info(&format!(
+            "=== Iteration {}/{} (Remaining: {}) ===",
+            iteration, max_iterations, remaining_before
+        ))?;
+        logger.info(&format!("Started at: {}", format_timestamp(clock.now())))?;
 
         if let Some(callback) = state_callback.as_deref_mut() {
             callback(
@@ -528,13 +1496,61 @@ pub fn run_loop_with_clock<B: Backend + ?Sized>(
         }
 
         if remaining_before == 0 {
-            log_message(
-                Some(&log_file),
-                "Zero tasks remaining before iteration, verifying completion...",
-            )?;
+            logger.info("Zero tasks remaining before iteration, verifying completion...")?;
         }
 
-        let iteration_result = run_iteration(
+        let tasks_before = task_states(&full_task_path);
+        let attempted_task = prd::prd_next_task_id(&full_task_path);
+        let head_before = gitops::head_commit(&project_dir);
+        let clean_before = head_before.is_some()
+            && gitops::dirty_paths(&project_dir).is_ok_and(|paths| paths.is_empty());
+        let guarded = guard
+            .as_ref()
+            .zip(head_before.as_deref())
+            .map(|(guard, base)| {
+                guard_scope(
+                    guard,
+                    &project_dir,
+                    base,
+                    &full_task_path,
+                    &task_files,
+                    attempted_task.as_deref(),
+                )
+            });
+        let iteration_start = clock.now();
+        let mut current = session_name.map(|session| {
+            let mut checkpoint = Checkpoint {
+                session: session.to_string(),
+                task_file: task_file.to_string(),
+                iteration,
+                prompt_hash: String::new(),
+                git_commit: head_before.clone(),
+                clean: clean_before,
+                finished: false,
+                started_at: iteration_start
+                    .duration_since(UNIX_EPOCH)
+                    .unwrap_or_default()
+                    .as_secs(),
+                elapsed_secs: 0,
+                input_tokens: 0,
+                output_tokens: 0,
+                cost_usd: None,
+            };
+            checkpoint.record_spent(
+                &usage,
+                iteration_start
+                    .duration_since(loop_start)
+                    .unwrap_or_default(),
+            );
+            checkpoint
+        });
+        let retried_hash = resume_from
+            .take()
+            .filter(|resumed| !resumed.finished && resumed.iteration == iteration)
+            .map(|resumed| resumed.prompt_hash);
+        let mut span = iteration_span(iteration, max_iterations, attempted_task.as_deref());
+        span.set_attribute("gralph.session", log_name);
+        let iteration_result = run_iteration_with_logger(
             backend,
             &project_dir,
             task_file,
@@ -543,10 +1559,136 @@ pub fn run_loop_with_clock<B: Backend + ?Sized>(
             completion_marker,
             model,
             variant,
-            Some(&log_file),
+            &logger,
             prompt_template,
             config,
+            previous_failure.as_deref(),
+            Some(&mut |prompt: &str| {
+                let Some(current) = current.as_mut() else {
+                    return;
+                };
+                current.prompt_hash = checkpoint::prompt_hash(prompt);
+                if retried_hash
+                    .as_ref()
+                    .is_some_and(|hash| *hash != current.prompt_hash)
+                {
+                    let _ = logger.info("Prompt differs from the interrupted attempt");
+                }
+                if let Err(err) = checkpoint::save(&gralph_dir, current) {
+                    let _ = logger.warn(&format!("failed to write checkpoint: {}", err));
+                }
+            }),
+            &SystemClock,
         );
+        record_span_result(&mut span, &iteration_result);
+        span.end();
+
+        let iteration_end = clock.now();
+        let task_record = TaskIterationRecord {
+            session: log_name,
+            backend: backend.name(),
+            iteration,
+            duration_secs: iteration_end
+                .duration_since(iteration_start)
+                .unwrap_or_default()
+                .as_secs(),
+            attempted: attempted_task.as_deref(),
+            failed: iteration_result.is_err(),
+            finished_at: iteration_end,
+        };
+        let mut fields: Vec<(&str, serde_json::Value)> = vec![
+            ("duration_secs", task_record.duration_secs.into()),
+            (
+                "status",
+                if task_record.failed { "failed" } else { "ok" }.into(),
+            ),
+        ];
+        if let Some(task) = task_record.attempted {
+            fields.push(("task", task.into()));
+        }
+        logger.log(Level::Info, "Iteration finished", &fields)?;
+        let mut checklist_feedback = Vec::new();
+        match reopen_open_checklists(
+            &full_task_path,
+            &tasks_before,
+            &task_states(&full_task_path),
+        ) {
+            Ok(reopened) => {
+                for (id, open) in reopened {
+                    logger.warn(&format!(
+                        "{} was checked off with {} open checklist item(s); reopened it",
+                        id,
+                        open.len()
+                    ))?;
+                    checklist_feedback.push(format!(
+                        "Task {} was unchecked again because these checklist items are still open: {}. Mark each item '* [x]' when it is done, and the task only after all of them.",
+                        id,
+                        open.join("; ")
+                    ));
+                }
+            }
+            Err(err) => {
+                logger.warn(&format!("failed to check task checklists: {}", err))?;
+            }
+        }
+        match record_task_progress(
+            &project_dir,
+            &tasks_before,
+            &task_states(&full_task_path),
+            &task_record,
+        ) {
+            Ok(closed) if !closed.is_empty() => {
+                logger.info(&format!("Tasks closed: {}", closed.join(", ")))?;
+                if let Some(tracker) = &tracker {
+                    sync_closed_issues(
+                        tracker,
+                        &project_dir,
+                        &full_task_path,
+                        &closed,
+                        log_name,
+                        iteration,
+                        &logger,
+                    )?;
+                }
+            }
+            Ok(_) => {}
+            Err(err) => {
+                logger.warn(&format!("failed to record task progress: {}", err))?;
+            }
+        }
+
+        if let (Err(error), Some(signal)) = (&iteration_result, shutdown::requested()) {
+            // The backend was cut short on purpose; this is not a failure.
+            logger.warn(&format!("Iteration interrupted: {}", error))?;
+            let remaining = count_remaining_tasks(&full_task_path);
+            if let Some(callback) = state_callback.as_deref_mut() {
+                callback(session_name, iteration, LoopStatus::Stopped, remaining);
+            }
+            return stopped_outcome(
+                &logger, signal, iteration, remaining, usage, loop_start, clock,
+            );
+        }
+
+        if let Err(CoreError::RateLimited(reason)) = &iteration_result {
+            if rate_limited < pacing.rate_limit_retries {
+                rate_limited += 1;
+                let wait = pacing.backoff(rate_limited);
+                logger.warn(&format!(
+                    "Rate limited: {}; retrying iteration {} in {}s (retry {}/{})",
+                    reason,
+                    iteration,
+                    wait.as_secs(),
+                    rate_limited,
+                    pacing.rate_limit_retries
+                ))?;
+                wait_unless_shutdown(clock, wait);
+                continue;
+            }
+            logger.warn(&format!(
+                "Still rate limited after {} retries",
+                pacing.rate_limit_retries
+            ))?;
+        }
 
         if let Err(error) = iteration_result {
             if let Some(callback) = state_callback.as_deref_mut() {
@@ -557,50 +1699,369 @@ pub fn run_loop_with_clock<B: Backend + ?Sized>(
                     remaining_before,
                 );
             }
-            log_message(Some(&log_file), &format!("Iteration failed: {}", error))?;
+            logger.error(&format!("Iteration failed: {}", error))?;
+            run_lifecycle_hook(
+                config,
+                HookEvent::PostFail,
+                &project_dir,
+                &logger,
+                &HookContext {
+                    session: log_name,
+                    iteration,
+                    remaining: remaining_before,
+                },
+            )?;
             return Err(error);
         }
 
         let iteration_result = iteration_result.unwrap();
+        let mut feedback = checklist_feedback;
+        rate_limited = 0;
+
+        if continuous_session {
+            if let Some(id) = iteration_result.session_id.as_deref() {
+                if session_id.as_deref() != Some(id) {
+                    logger.info(&format!("Continuing backend session {}", id))?;
+                    backend.resume_session(Some(id));
+                    session_id = Some(id.to_string());
+                }
+            }
+        }
+
+        if let Some(spent) = iteration_result.usage {
+            usage.add(&spent);
+            let cost = |usage: &Usage| {
+                usage
+                    .cost_usd
+                    .map(|cost| format!(", ${:.2}", cost))
+                    .unwrap_or_default()
+            };
+            logger.info(&format!(
+                "Usage: {} tokens{} (run total: {} tokens{})",
+                spent.total_tokens(),
+                cost(&spent),
+                usage.total_tokens(),
+                cost(&usage)
+            ))?;
+        }
+        match task_result::parse_task_result(&iteration_result.result) {
+            Some(Ok(result)) => {
+                let mut fields: Vec<(&str, serde_json::Value)> = vec![
+                    ("task", result.task.as_str().into()),
+                    ("status", result.status.as_str().into()),
+                ];
+                if let Some(notes) = result.notes.as_deref() {
+                    fields.push(("notes", notes.into()));
+                }
+                logger.log(Level::Info, "Task result", &fields)?;
+                let tasks_after = task_states(&full_task_path);
+                if !tasks_after.is_empty() {
+                    for mismatch in task_result::result_mismatches(
+                        &result,
+                        attempted_task.as_deref(),
+                        tasks_after.get(&result.task).copied(),
+                    ) {
+                        logger.warn(&mismatch)?;
+                    }
+                }
+            }
+            Some(Err(reason)) => {
+                logger.warn(&format!("ignoring malformed task result: {}", reason))?;
+            }
+            None => {}
+        }
+        if budget.max_cost_usd.is_some() && usage.cost_usd.is_none() && !warned_no_cost {
+            logger.warn(&format!(
+                "backend {} reports no cost; the cost cap cannot be enforced",
+                backend.name()
+            ))?;
+            warned_no_cost = true;
+        }
 
-        if check_completion(&full_task_path, &iteration_result.result, completion_marker)? {
+        let guard_failed = match (&guard, head_before.as_deref(), &guarded) {
+            (Some(guard), Some(base), Some(scope)) => run_file_guard(
+                guard,
+                &project_dir,
+                base,
+                scope,
+                iteration,
+                &logger,
+                &mut feedback,
+            )?,
+            _ => false,
+        };
+
+        if git.enabled() {
+            match gitops::finish_iteration(
+                &project_dir,
+                &git,
+                head_before.as_deref(),
+                attempted_task.as_deref(),
+                iteration,
+            ) {
+                Ok(IterationCommit::Clean) => {}
+                Ok(IterationCommit::Committed { head }) => {
+                    logger.info(&format!("Backend committed: {}", head))?;
+                }
+                Ok(IterationCommit::AutoCommitted { head, message }) => {
+                    logger.info(&format!(
+                        "Auto-committed leftover changes: {} ({})",
+                        message, head
+                    ))?;
+                }
+                Ok(IterationCommit::Uncommitted { paths }) => {
+                    logger.warn(&format!(
+                        "backend left uncommitted changes: {}",
+                        paths.join(", ")
+                    ))?;
+                    feedback.push(format!(
+                        "Uncommitted changes were left behind: {}. Commit your work before finishing.",
+                        paths.join(", ")
+                    ));
+                }
+                Err(error) => {
+                    let remaining = count_remaining_tasks(&full_task_path);
+                    if let Some(callback) = state_callback.as_deref_mut() {
+                        callback(session_name, iteration, LoopStatus::Failed, remaining);
+                    }
+                    logger.error(&format!("Iteration failed: {}", error))?;
+                    run_lifecycle_hook(
+                        config,
+                        HookEvent::PostFail,
+                        &project_dir,
+                        &logger,
+                        &HookContext {
+                            session: log_name,
+                            iteration,
+                            remaining,
+                        },
+                    )?;
+                    return Err(error.into());
+                }
+            }
+        }
+
+        let validation_failed = match run_iteration_check(
+            config,
+            &project_dir,
+            &logger,
+            &HookContext {
+                session: log_name,
+                iteration,
+                remaining: count_remaining_tasks(&full_task_path),
+            },
+        )? {
+            Some(error) => {
+                let rolled_back = git.rollback_failed
+                    && rollback_failed_iteration(
+                        &project_dir,
+                        iteration,
+                        head_before.as_deref(),
+                        clean_before,
+                        &logger,
+                    )?;
+                feedback.push(if rolled_back {
+                    format!(
+                        "{}. Your changes from that iteration were rolled back; redo the work so the check passes.",
+                        error
+                    )
+                } else {
+                    format!("{}. Fix the failure before moving on.", error)
+                });
+                true
+            }
+            None => false,
+        } || guard_failed;
+
+        if let Some(current) = current.as_mut() {
+            current.finished = true;
+            current.record_spent(
+                &usage,
+                clock.now().duration_since(loop_start).unwrap_or_default(),
+            );
+            if let Err(err) = checkpoint::save(&gralph_dir, current) {
+                logger.warn(&format!("failed to write checkpoint: {}", err))?;
+            }
+        }
+
+        let mut complete = !validation_failed
+            && check_completion(&full_task_path, &iteration_result.result, completion_marker)?;
+        if let (true, Some(reviewer)) = (complete, reviewer) {
+            logger.info(&format!(
+                "Completion claimed; asking {} to review",
+                reviewer.backend.name()
+            ))?;
+            match review_completion(
+                reviewer,
+                &project_dir,
+                &full_task_path,
+                &initial_tasks,
+                review_base.as_deref(),
+                &logger,
+                clock,
+            ) {
+                Ok(None) => logger.info("Reviewer approved the completion")?,
+                Ok(Some(objections)) => {
+                    logger.warn("Reviewer rejected the completion")?;
+                    feedback.push(format!(
+                        "A reviewer checked your work against each task's DoD and Checklist and rejected the completion:\n{}",
+                        objections
+                    ));
+                    complete = false;
+                }
+                Err(error) => {
+                    if let Some(callback) = state_callback.as_deref_mut() {
+                        callback(session_name, iteration, LoopStatus::Failed, 0);
+                    }
+                    logger.error(&format!("Completion review failed: {}", error))?;
+                    run_lifecycle_hook(
+                        config,
+                        HookEvent::PostFail,
+                        &project_dir,
+                        &logger,
+                        &HookContext {
+                            session: log_name,
+                            iteration,
+                            remaining: 0,
+                        },
+                    )?;
+                    return Err(error);
+                }
+            }
+        }
+
+        if complete {
             let duration_secs = clock
                 .now()
                 .duration_since(loop_start)
                 .unwrap_or_default()
                 .as_secs();
 
-            log_message(Some(&log_file), "")?;
-            log_message(
-                Some(&log_file),
-                &format!("Gralph complete after {} iterations.", iteration),
-            )?;
-            log_message(
-                Some(&log_file),
-                &format!("Duration: {}", format_duration(duration_secs)),
-            )?;
-            log_message(
-                Some(&log_file),
-                &format!("FINISHED: {}", format_timestamp(clock.now())),
+            logger.info("")?;
+            logger.info(&format!("Gralph complete after {} iterations.", iteration))?;
+            logger.info(&format!("Duration: {}", format_duration(duration_secs)))?;
+            logger.info(&format!("FINISHED: {}", format_timestamp(clock.now())))?;
+
+            run_lifecycle_hook(
+                config,
+                HookEvent::PostComplete,
+                &project_dir,
+                &logger,
+                &HookContext {
+                    session: log_name,
+                    iteration,
+                    remaining: 0,
+                },
             )?;
 
             if let Some(callback) = state_callback.as_deref_mut() {
                 callback(session_name, iteration, LoopStatus::Complete, 0);
             }
+            clear_checkpoint();
 
             return Ok(LoopOutcome {
                 status: LoopStatus::Complete,
                 iterations: iteration,
                 remaining_tasks: 0,
                 duration_secs,
+                usage,
             });
         }
 
         let remaining_after = count_remaining_tasks(&full_task_path);
-        log_message(
-            Some(&log_file),
-            &format!("Tasks remaining after iteration: {}", remaining_after),
-        )?;
+        logger.info(&format!(
+            "Tasks remaining after iteration: {}",
+            remaining_after
+        ))?;
+
+        let stop_context = HookContext {
+            session: log_name,
+            iteration,
+            remaining: remaining_after,
+        };
+        let elapsed = clock.now().duration_since(loop_start).unwrap_or_default();
+
+        if let Some(reason) = budget.exceeded_by(&usage) {
+            return finish_early(
+                LoopStatus::BudgetExceeded,
+                &format!("Budget exceeded: {}", reason),
+                &[],
+                &stop_context,
+                session_name,
+                state_callback.as_deref_mut(),
+                config,
+                &project_dir,
+                &logger,
+                clock,
+                elapsed.as_secs(),
+                usage,
+            );
+        }
+
+        if let Some(max) = budget.max_duration.filter(|max| elapsed >= *max) {
+            return finish_early(
+                LoopStatus::DeadlineExceeded,
+                &format!(
+                    "Deadline exceeded: time limit of {} reached",
+                    format_duration(max.as_secs())
+                ),
+                &[],
+                &stop_context,
+                session_name,
+                state_callback.as_deref_mut(),
+                config,
+                &project_dir,
+                &logger,
+                clock,
+                elapsed.as_secs(),
+                usage,
+            );
+        }
+
+        if remaining_after < remaining_before {
+            iterations_without_progress = 0;
+        } else {
+            iterations_without_progress += 1;
+        }
+        if stall_iterations > 0 && iterations_without_progress >= stall_iterations {
+            let mut details = vec!["Last backend output:".to_string()];
+            details.extend(
+                output_tail(&iteration_result.result, OUTPUT_TAIL_LINES)
+                    .into_iter()
+                    .map(|line| format!("  {}", line)),
+            );
+            return finish_early(
+                LoopStatus::Stalled,
+                &format!(
+                    "Stalled: remaining tasks have not decreased in {} iterations",
+                    iterations_without_progress
+                ),
+                &details,
+                &stop_context,
+                session_name,
+                state_callback.as_deref_mut(),
+                config,
+                &project_dir,
+                &logger,
+                clock,
+                elapsed.as_secs(),
+                usage,
+            );
+        }
+
+        if remaining_after > 0 && remaining_after >= remaining_before {
+            feedback.push(format!(
+                "Iteration {} did not check off any task in {} ({} remaining). Your output ended with:\n{}",
+                iteration,
+                task_file,
+                remaining_after,
+                output_tail(&iteration_result.result, OUTPUT_TAIL_LINES).join("\n")
+            ));
+        }
+        previous_failure = (!feedback.is_empty()).then(|| feedback.join("\n\n"));
+        if previous_failure.is_some() {
+            logger.info("Adding failure feedback to the next prompt")?;
+        }
 
         if let Some(callback) = state_callback.as_deref_mut() {
             callback(
@@ -613,7 +2074,11 @@ pub fn run_loop_with_clock<B: Backend + ?Sized>(
 
         iteration += 1;
         if iteration <= max_iterations {
-            clock.sleep(Duration::from_secs(2));
+            if pacing.iteration_delay > ITERATION_GAP {
+                wait_unless_shutdown(clock, pacing.iteration_delay);
+            } else {
+                clock.sleep(ITERATION_GAP);
+            }
         }
     }
 
@@ -624,23 +2089,11 @@ pub fn run_loop_with_clock<B: Backend + ?Sized>(
         .unwrap_or_default()
         .as_secs();
 
-    log_message(Some(&log_file), "")?;
-    log_message(
-        Some(&log_file),
-        &format!("Hit max iterations ({})", max_iterations),
-    )?;
-    log_message(
-        Some(&log_file),
-        &format!("Remaining tasks: {}", final_remaining),
-    )?;
-    log_message(
-        Some(&log_file),
-        &format!("Duration: {}", format_duration(duration_secs)),
-    )?;
-    log_message(
-        Some(&log_file),
-        &format!("FINISHED: {}", format_timestamp(clock.now())),
-    )?;
+    logger.info("")?;
+    logger.info(&format!("Hit max iterations ({})", max_iterations))?;
+    logger.info(&format!("Remaining tasks: {}", final_remaining))?;
+    logger.info(&format!("Duration: {}", format_duration(duration_secs)))?;
+    logger.info(&format!("FINISHED: {}", format_timestamp(clock.now())))?;
 
     if let Some(callback) = state_callback.as_deref_mut() {
         callback(
@@ -650,58 +2103,706 @@ pub fn run_loop_with_clock<B: Backend + ?Sized>(
             final_remaining,
         );
     }
+    clear_checkpoint();
 
     Ok(LoopOutcome {
         status: LoopStatus::MaxIterations,
         iterations: max_iterations,
         remaining_tasks: final_remaining,
         duration_secs,
+        usage,
     })
 }
 
-pub fn get_next_unchecked_task_block(task_file: &Path) -> Result<Option<String>, CoreError> {
-    if task_file.as_os_str().is_empty() || !task_file.is_file() {
-        return Ok(None);
+/// Stops the loop before its tasks are done (stalled, over budget, or past
+/// its deadline): logs `reason`, `details`, and the totals, records `status`,
+/// and runs the post-fail hook like any other failed run.
+fn finish_early(
+    status: LoopStatus,
+    reason: &str,
+    details: &[String],
+    context: &HookContext<'_>,
+    session_name: Option<&str>,
+    state_callback: Option<&mut dyn FnMut(Option<&str>, u32, LoopStatus, usize)>,
+    config: Option<&Config>,
+    project_dir: &Path,
+    logger: &Logger,
+    clock: &dyn Clock,
+    duration_secs: u64,
+    usage: Usage,
+) -> Result<LoopOutcome, CoreError> {
+    logger.info("")?;
+    logger.warn(reason)?;
+    for line in details {
+        logger.info(line)?;
     }
-    let contents = fs::read_to_string(task_file).map_err(|source| CoreError::Io {
-        path: task_file.to_path_buf(),
-        source,
-    })?;
-    let blocks = task_blocks_from_contents(&contents);
-    for block in blocks {
-        if block.lines().any(|line| is_unchecked_line(line)) {
-            return Ok(Some(block));
-        }
+    logger.info(&format!("Remaining tasks: {}", context.remaining))?;
+    logger.info(&format!("Duration: {}", format_duration(duration_secs)))?;
+    logger.info(&format!("FINISHED: {}", format_timestamp(clock.now())))?;
+
+    if let Some(callback) = state_callback {
+        callback(session_name, context.iteration, status, context.remaining);
     }
-    Ok(None)
+    run_lifecycle_hook(config, HookEvent::PostFail, project_dir, logger, context)?;
+
+    Ok(LoopOutcome {
+        status,
+        iterations: context.iteration,
+        remaining_tasks: context.remaining,
+        duration_secs,
+        usage,
+    })
 }
 
-pub fn get_task_blocks(task_file: &Path) -> Result<Vec<String>, CoreError> {
-    if task_file.as_os_str().is_empty() || !task_file.is_file() {
-        return Ok(Vec::new());
+/// Second backend that must confirm a completion promise before a loop is
+/// marked complete.
+pub struct CompletionReviewer<'a> {
+    pub backend: &'a dyn Backend,
+    pub model: Option<&'a str>,
+}
+
+/// Asks the reviewer whether the run satisfies the DoD and Checklist of the
+/// tasks it closed. Returns the reviewer's objections on rejection.
+fn review_completion(
+    reviewer: &CompletionReviewer,
+    project_dir: &Path,
+    task_path: &Path,
+    initial_tasks: &BTreeMap<String, bool>,
+    base_commit: Option<&str>,
+    logger: &Logger,
+    clock: &dyn Clock,
+) -> Result<Option<String>, CoreError> {
+    let diff = match gitops::diff_since(project_dir, base_commit) {
+        Ok(diff) if diff.trim().is_empty() => "(no changes)".to_string(),
+        Ok(diff) => match diff.char_indices().nth(REVIEW_DIFF_MAX_CHARS) {
+            Some((end, _)) => format!("{}\n[diff truncated]", &diff[..end]),
+            None => diff,
+        },
+        Err(_) => "(git diff unavailable)".to_string(),
+    };
+    let prompt = render_review_prompt(&review_task_blocks(task_path, initial_tasks), &diff);
+    let result = execute_prompt(
+        reviewer.backend,
+        None,
+        &prompt,
+        reviewer.model,
+        None,
+        project_dir,
+        logger,
+        clock,
+    )?;
+    if is_review_approved(&result.result) {
+        return Ok(None);
     }
-    let contents = fs::read_to_string(task_file).map_err(|source| CoreError::Io {
-        path: task_file.to_path_buf(),
-        source,
-    })?;
-    Ok(task_blocks_from_contents(&contents))
+    Ok(Some(
+        output_tail(&result.result, OUTPUT_TAIL_LINES).join("\n"),
+    ))
 }
 
-pub fn normalize_context_files(raw: &str) -> String {
-    if raw.trim().is_empty() {
-        return String::new();
+/// Blocks of the tasks checked off during this run, or the whole task file
+/// when it has no task blocks.
+fn review_task_blocks(task_path: &Path, initial_tasks: &BTreeMap<String, bool>) -> String {
+    let blocks: Vec<String> = task_states(task_path)
+        .into_iter()
+        .filter(|(id, done)| *done && initial_tasks.get(id) != Some(&true))
+        .filter_map(|(id, _)| find_task_block(task_path, &id).ok().flatten())
+        .collect();
+    if blocks.is_empty() {
+        return fs::read_to_string(task_path).unwrap_or_default();
+    }
+    blocks.join("\n\n")
+}
+
+fn render_review_prompt(tasks: &str, diff: &str) -> String {
+    format!(
+        "You are reviewing work another agent has just declared complete. Do not modify any files.\n\nCheck the changes below against every DoD and Checklist item of these tasks:\n\n{}\n\nChanges (git diff):\n```diff\n{}\n```\n\nList every unmet criterion with a short reason. Then end with exactly one line:\n<review>APPROVED</review> if every criterion is met, otherwise <review>REJECTED</review>",
+        tasks.trim(),
+        diff.trim_end()
+    )
+}
+
+fn is_review_approved(result: &str) -> bool {
+    last_non_empty_line(result).is_some_and(|line| line.trim() == "<review>APPROVED</review>")
+}
+
+fn output_tail(text: &str, lines: usize) -> Vec<&str> {
+    let all: Vec<&str> = text.lines().collect();
+    all[all.len().saturating_sub(lines)..].to_vec()
+}
+
+/// Sleeps for `duration` in short steps so a stop signal is not held up by a
+/// long backoff.
+fn wait_unless_shutdown(clock: &dyn Clock, duration: Duration) {
+    let mut remaining = duration;
+    while !remaining.is_zero() && shutdown::requested().is_none() {
+        let step = remaining.min(PAUSE_POLL_INTERVAL);
+        clock.sleep(step);
+        remaining -= step;
     }
-    let mut normalized = Vec::new();
-    for entry in raw.split(',') {
-        let trimmed = entry.trim();
-        if !trimmed.is_empty() {
-            normalized.push(trimmed.to_string());
-        }
+}
+
+/// Finds a rate-limit error in a failed iteration: an HTTP 429, "rate limit",
+/// or "too many requests" in the error or the tail of the backend output.
+/// Returns the line that matched.
+pub(crate) fn rate_limit_reason(error: &str, output: &str) -> Option<String> {
+    std::iter::once(error)
+        .chain(output_tail(output, OUTPUT_TAIL_LINES).into_iter().rev())
+        .find(|line| is_rate_limit_line(line))
+        .map(|line| line.trim().to_string())
+}
+
+fn is_rate_limit_line(line: &str) -> bool {
+    let lower = line.to_ascii_lowercase();
+    [
+        "rate limit",
+        "rate_limit",
+        "ratelimit",
+        "rate-limit",
+        "too many requests",
+    ]
+    .iter()
+    .any(|pattern| lower.contains(pattern))
+        || lower
+            .split(|ch: char| !ch.is_ascii_alphanumeric())
+            .any(|word| word == "429")
+}
+
+/// Pacing for a backend that shares a provider quota, from
+/// `backends.<name>.iteration_delay`, `rate_limit_backoff`, and
+/// `rate_limit_retries` (all whole seconds except the retry count).
+#[derive(Debug, Clone, Copy, PartialEq)]
+struct Pacing {
+    iteration_delay: Duration,
+    rate_limit_backoff: Duration,
+    rate_limit_retries: u32,
+}
+
+impl Pacing {
+    fn from_config(config: Option<&Config>, backend_name: &str) -> Result<Self, CoreError> {
+        let number = |name: &str, default: u64| -> Result<u64, CoreError> {
+            let key = format!("backends.{}.{}", backend_name, name);
+            let Some(value) = config.and_then(|cfg| cfg.get(&key)) else {
+                return Ok(default);
+            };
+            let value = value.trim();
+            if value.is_empty() {
+                return Ok(default);
+            }
+            value.parse().map_err(|_| {
+                CoreError::InvalidInput(format!(
+                    "{} must be a non-negative integer: {}",
+                    key, value
+                ))
+            })
+        };
+        Ok(Self {
+            iteration_delay: Duration::from_secs(number("iteration_delay", 0)?),
+            rate_limit_backoff: Duration::from_secs(number(
+                "rate_limit_backoff",
+                DEFAULT_RATE_LIMIT_BACKOFF,
+            )?),
+            rate_limit_retries: number("rate_limit_retries", DEFAULT_RATE_LIMIT_RETRIES)?
+                .min(u32::MAX as u64) as u32,
+        })
+    }
+
+    /// Wait before retry `attempt` (1-based), capped at 30 minutes.
+    fn backoff(&self, attempt: u32) -> Duration {
+        let factor = 1u32 << attempt.saturating_sub(1).min(16);
+        self.rate_limit_backoff
+            .saturating_mul(factor)
+            .min(MAX_RATE_LIMIT_BACKOFF)
     }
-    normalized.join("\n")
 }
 
-pub fn render_prompt_template(
+/// `defaults.stall_iterations`: how many iterations in a row may leave the
+/// remaining task count unchanged before the loop gives up. Zero disables it.
+fn resolve_stall_iterations(config: Option<&Config>) -> Result<u32, CoreError> {
+    let Some(value) = config.and_then(|cfg| cfg.get("defaults.stall_iterations")) else {
+        return Ok(0);
+    };
+    let value = value.trim();
+    if value.is_empty() {
+        return Ok(0);
+    }
+    value.parse().map_err(|_| {
+        CoreError::InvalidInput(format!(
+            "defaults.stall_iterations must be a non-negative integer: {}",
+            value
+        ))
+    })
+}
+
+/// `defaults.continuous_session`: resume the backend's conversation from the
+/// previous iteration instead of starting a fresh one each time.
+fn resolve_continuous_session(config: Option<&Config>) -> bool {
+    config
+        .and_then(|cfg| cfg.get("defaults.continuous_session"))
+        .is_some_and(|value| {
+            matches!(
+                value.trim().to_ascii_lowercase().as_str(),
+                "true" | "1" | "yes" | "y" | "on"
+            )
+        })
+}
+
+/// Discards what an interrupted iteration left uncommitted, so it can be run
+/// again from the same commit. Changes are kept, with a warning, when the tree
+/// already had uncommitted work before that iteration started.
+fn rollback_interrupted_iteration(
+    project_dir: &Path,
+    resumed: &Checkpoint,
+    logger: &Logger,
+) -> Result<(), CoreError> {
+    if gitops::repo_root(project_dir).is_none() {
+        return Ok(());
+    }
+    let paths = gitops::dirty_paths(project_dir)?;
+    if paths.is_empty() {
+        return Ok(());
+    }
+    if !resumed.clean {
+        logger.warn(&format!(
+            "Iteration {} was interrupted; keeping uncommitted changes because the tree was not clean before it: {}",
+            resumed.iteration,
+            paths.join(", ")
+        ))?;
+        return Ok(());
+    }
+    gitops::discard_changes(project_dir)?;
+    logger.info(&format!(
+        "Rolled back uncommitted changes from interrupted iteration {}: {}",
+        resumed.iteration,
+        paths.join(", ")
+    ))?;
+    Ok(())
+}
+
+fn stopped_outcome(
+    logger: &Logger,
+    signal: i32,
+    iterations: u32,
+    remaining_tasks: usize,
+    usage: Usage,
+    loop_start: SystemTime,
+    clock: &dyn Clock,
+) -> Result<LoopOutcome, CoreError> {
+    let duration_secs = clock
+        .now()
+        .duration_since(loop_start)
+        .unwrap_or_default()
+        .as_secs();
+
+    logger.info("")?;
+    logger.info(&format!(
+        "Stopped by {} after {} iterations.",
+        shutdown::signal_name(signal),
+        iterations
+    ))?;
+    logger.info(&format!("Remaining tasks: {}", remaining_tasks))?;
+    logger.info(&format!("Duration: {}", format_duration(duration_secs)))?;
+    logger.info(&format!("FINISHED: {}", format_timestamp(clock.now())))?;
+
+    Ok(LoopOutcome {
+        status: LoopStatus::Stopped,
+        iterations,
+        remaining_tasks,
+        duration_secs,
+        usage,
+    })
+}
+
+/// Closes the tracker issue linked to each task an iteration checked off.
+/// Tracker failures are logged and never stop the loop.
+fn sync_closed_issues(
+    settings: &TrackerSettings,
+    project_dir: &Path,
+    task_file: &Path,
+    closed: &[String],
+    session: &str,
+    iteration: u32,
+    logger: &Logger,
+) -> Result<(), CoreError> {
+    for task_id in closed {
+        let Some(issue) = tracker::task_issue(project_dir, task_file, task_id) else {
+            continue;
+        };
+        let completion = tracker::Completion {
+            task_id,
+            session,
+            iteration,
+        };
+        match tracker::close_issue(settings, project_dir, &issue, &completion) {
+            Ok(name) => logger.info(&format!("Closed issue {} for task {}", name, task_id))?,
+            Err(err) => logger.warn(&format!(
+                "failed to close issue {} for task {}: {}",
+                issue, task_id, err
+            ))?,
+        }
+    }
+    Ok(())
+}
+
+/// Per-task records kept alongside session logs.
+pub fn task_records_path(project_dir: &Path) -> PathBuf {
+    project_dir.join(".gralph").join("tasks.json")
+}
+
+/// Checked state of every task block, keyed by task ID.
+pub fn task_states(task_file: &Path) -> BTreeMap<String, bool> {
+    let contents = fs::read_to_string(task_file).unwrap_or_default();
+    prd::TaskGraph::from_contents(&contents)
+        .nodes
+        .into_iter()
+        .map(|node| (node.id, node.done))
+        .collect()
+}
+
+/// What a single iteration did to the task list.
+#[derive(Debug, Clone)]
+pub struct TaskIterationRecord<'a> {
+    pub session: &'a str,
+    pub backend: &'a str,
+    pub iteration: u32,
+    pub duration_secs: u64,
+    pub attempted: Option<&'a str>,
+    pub failed: bool,
+    pub finished_at: SystemTime,
+}
+
+/// Diffs task states around an iteration and merges the result into
+/// `.gralph/tasks.json`. Returns the IDs of tasks that were checked off.
+pub fn record_task_progress(
+    project_dir: &Path,
+    before: &BTreeMap<String, bool>,
+    after: &BTreeMap<String, bool>,
+    record: &TaskIterationRecord<'_>,
+) -> Result<Vec<String>, CoreError> {
+    let closed: Vec<String> = after
+        .iter()
+        .filter(|(id, done)| **done && !before.get(*id).copied().unwrap_or(false))
+        .map(|(id, _)| id.clone())
+        .collect();
+    let mut touched = closed.clone();
+    if let Some(attempted) = record.attempted {
+        if !touched.iter().any(|id| id == attempted) {
+            touched.push(attempted.to_string());
+        }
+    }
+    if touched.is_empty() {
+        return Ok(closed);
+    }
+
+    let path = task_records_path(project_dir);
+    let mut records = match fs::read_to_string(&path) {
+        Ok(contents) if !contents.trim().is_empty() => {
+            serde_json::from_str::<serde_json::Value>(&contents).map_err(|err| {
+                CoreError::InvalidInput(format!(
+                    "invalid task records at {}: {}",
+                    path.display(),
+                    err
+                ))
+            })?
+        }
+        _ => serde_json::json!({}),
+    };
+    let tasks = records
+        .as_object_mut()
+        .ok_or_else(|| {
+            CoreError::InvalidInput(format!("invalid task records at {}", path.display()))
+        })?
+        .entry("tasks")
+        .or_insert_with(|| serde_json::json!({}));
+    let Some(tasks) = tasks.as_object_mut() else {
+        return Err(CoreError::InvalidInput(format!(
+            "invalid task records at {}",
+            path.display()
+        )));
+    };
+
+    let finished_at = chrono::DateTime::<chrono::Local>::from(record.finished_at).to_rfc3339();
+    for id in &touched {
+        let entry = tasks
+            .entry(id.clone())
+            .or_insert_with(|| serde_json::json!({ "id": id }));
+        let attempts = entry.get("attempts").and_then(|v| v.as_u64()).unwrap_or(0);
+        let duration = entry
+            .get("duration_secs")
+            .and_then(|v| v.as_u64())
+            .unwrap_or(0);
+        let status = if closed.contains(id) {
+            "done"
+        } else if record.failed {
+            "failed"
+        } else {
+            "in_progress"
+        };
+        entry["status"] = serde_json::json!(status);
+        entry["session"] = serde_json::json!(record.session);
+        entry["backend"] = serde_json::json!(record.backend);
+        entry["attempts"] = serde_json::json!(attempts + 1);
+        entry["duration_secs"] = serde_json::json!(duration + record.duration_secs);
+        entry["last_iteration"] = serde_json::json!(record.iteration);
+        entry["updated_at"] = serde_json::json!(finished_at);
+        if status == "done" {
+            entry["completed_iteration"] = serde_json::json!(record.iteration);
+            entry["completed_at"] = serde_json::json!(finished_at);
+        }
+    }
+
+    if let Some(parent) = path.parent() {
+        fs::create_dir_all(parent).map_err(|source| CoreError::Io {
+            path: parent.to_path_buf(),
+            source,
+        })?;
+    }
+    let rendered = serde_json::to_string_pretty(&records).map_err(|err| {
+        CoreError::InvalidInput(format!("failed to encode task records: {}", err))
+    })?;
+    fs::write(&path, rendered).map_err(|source| CoreError::Io { path, source })?;
+    Ok(closed)
+}
+
+/// Unchecks the tasks an iteration checked off while tracked checklist
+/// items (`* [ ]`) were still open. Returns each reopened task with those
+/// items.
+fn reopen_open_checklists(
+    task_file: &Path,
+    before: &BTreeMap<String, bool>,
+    after: &BTreeMap<String, bool>,
+) -> Result<Vec<(String, Vec<String>)>, CoreError> {
+    let mut reopened = Vec::new();
+    for (id, done) in after {
+        if !*done || before.get(id).copied().unwrap_or(false) {
+            continue;
+        }
+        let Some(block) = find_task_block(task_file, id)? else {
+            continue;
+        };
+        let open = prd::prd_open_checklist_items(&block);
+        if !open.is_empty() && set_task_checked(task_file, id, false)? {
+            reopened.push((id.clone(), open));
+        }
+    }
+    Ok(reopened)
+}
+
+/// Control file that pauses a loop between iterations while it exists.
+pub fn pause_file_path(project_dir: &Path, session_name: Option<&str>) -> PathBuf {
+    project_dir
+        .join(".gralph")
+        .join(format!("{}.pause", session_name.unwrap_or("gralph")))
+}
+
+/// PID file a `gralph start --daemon` loop keeps while it runs.
+pub fn pid_file_path(project_dir: &Path, session_name: Option<&str>) -> PathBuf {
+    project_dir
+        .join(".gralph")
+        .join(format!("{}.pid", session_name.unwrap_or("gralph")))
+}
+
+pub fn get_next_unchecked_task_block(task_file: &Path) -> Result<Option<String>, CoreError> {
+    if task_file.as_os_str().is_empty() || !task_file.is_file() {
+        return Ok(None);
+    }
+    let contents = fs::read_to_string(task_file).map_err(|source| CoreError::Io {
+        path: task_file.to_path_buf(),
+        source,
+    })?;
+    Ok(prd::prd_next_task_block(&contents))
+}
+
+pub fn get_task_blocks(task_file: &Path) -> Result<Vec<String>, CoreError> {
+    if task_file.as_os_str().is_empty() || !task_file.is_file() {
+        return Ok(Vec::new());
+    }
+    let contents = fs::read_to_string(task_file).map_err(|source| CoreError::Io {
+        path: task_file.to_path_buf(),
+        source,
+    })?;
+    Ok(task_blocks_from_contents(&contents))
+}
+
+/// Returns the task block whose ID matches `task_id` (case-insensitive).
+pub fn find_task_block(task_file: &Path, task_id: &str) -> Result<Option<String>, CoreError> {
+    let task_id = task_id.trim();
+    Ok(get_task_blocks(task_file)?.into_iter().find(|block| {
+        prd::prd_task_id_from_block(block).is_some_and(|id| id.eq_ignore_ascii_case(task_id))
+    }))
+}
+
+/// Sets the checkbox of the task block `task_id` and rewrites the file when it
+/// changes. Returns whether the file was modified.
+pub fn set_task_checked(task_file: &Path, task_id: &str, checked: bool) -> Result<bool, CoreError> {
+    let contents = fs::read_to_string(task_file).map_err(|source| CoreError::Io {
+        path: task_file.to_path_buf(),
+        source,
+    })?;
+    let task_id = task_id.trim();
+    let mut lines: Vec<String> = contents.lines().map(str::to_string).collect();
+    let mut index = 0;
+    let mut changed = false;
+    while index < lines.len() {
+        if !is_task_header(&lines[index]) {
+            index += 1;
+            continue;
+        }
+        let start = index;
+        index += 1;
+        while index < lines.len()
+            && !is_task_header(&lines[index])
+            && !is_task_block_end(&lines[index])
+        {
+            index += 1;
+        }
+        let block = lines[start..index].join("\n");
+        let matches =
+            prd::prd_task_id_from_block(&block).is_some_and(|id| id.eq_ignore_ascii_case(task_id));
+        if !matches {
+            continue;
+        }
+        let (from, to) = if checked {
+            ("- [ ]", "- [x]")
+        } else {
+            ("- [x]", "- [ ]")
+        };
+        for line in &mut lines[start..index] {
+            let trimmed = line.trim_start();
+            let indent = line.len() - trimmed.len();
+            if trimmed
+                .get(..from.len())
+                .is_some_and(|prefix| prefix.eq_ignore_ascii_case(from))
+            {
+                *line = format!("{}{}{}", &line[..indent], to, &trimmed[from.len()..]);
+                changed = true;
+            }
+        }
+        break;
+    }
+
+    if changed {
+        let mut rendered = lines.join("\n");
+        if contents.ends_with('\n') {
+            rendered.push('\n');
+        }
+        fs::write(task_file, rendered).map_err(|source| CoreError::Io {
+            path: task_file.to_path_buf(),
+            source,
+        })?;
+    }
+    Ok(changed)
+}
+
+pub fn normalize_context_files(raw: &str) -> String {
+    if raw.trim().is_empty() {
+        return String::new();
+    }
+    let mut normalized = Vec::new();
+    for entry in raw.split(',') {
+        let trimmed = entry.trim();
+        if !trimmed.is_empty() {
+            normalized.push(trimmed.to_string());
+        }
+    }
+    normalized.join("\n")
+}
+
+/// `backends.<name>.max_context_tokens`: the token budget for the context
+/// files listed in the prompt. Unset means the files are listed unsized.
+fn resolve_max_context_tokens(
+    config: Option<&Config>,
+    backend_name: Option<&str>,
+) -> Result<Option<usize>, CoreError> {
+    let (Some(config), Some(backend_name)) = (config, backend_name) else {
+        return Ok(None);
+    };
+    let key = format!("backends.{}.max_context_tokens", backend_name);
+    let Some(value) = config.get(&key) else {
+        return Ok(None);
+    };
+    let value = value.trim();
+    if value.is_empty() {
+        return Ok(None);
+    }
+    match value.parse::<usize>() {
+        Ok(0) | Err(_) => Err(CoreError::InvalidInput(format!(
+            "{} must be a positive integer: {}",
+            key, value
+        ))),
+        Ok(tokens) => Ok(Some(tokens)),
+    }
+}
+
+/// Approximate token count for `text`.
+pub fn estimate_tokens(text: &str) -> usize {
+    text.len().div_ceil(BYTES_PER_TOKEN)
+}
+
+/// Sizes the comma-separated `context_files` (relative to `project_dir`)
+/// against `max_tokens`, in order. The file that crosses the budget is listed
+/// with the line range that fits; files after it are left out. Files that
+/// cannot be read are listed as-is and do not count against the budget.
+pub fn fit_context_files(
+    project_dir: &Path,
+    context_files: &str,
+    max_tokens: Option<usize>,
+) -> ContextBudget {
+    let mut budget = ContextBudget::default();
+    let mut seen = HashSet::new();
+    let entries = normalize_context_files(context_files);
+    let Some(max_tokens) = max_tokens else {
+        budget.entries = entries.lines().map(str::to_string).collect();
+        return budget;
+    };
+
+    let mut remaining = max_tokens;
+    for entry in entries.lines() {
+        if !seen.insert(entry) {
+            continue;
+        }
+        let Ok(contents) = fs::read_to_string(project_dir.join(entry)) else {
+            budget.entries.push(entry.to_string());
+            continue;
+        };
+        let tokens = estimate_tokens(&contents);
+        if tokens <= remaining {
+            remaining -= tokens;
+            budget.entries.push(entry.to_string());
+            continue;
+        }
+
+        let total_lines = contents.lines().count();
+        let mut bytes = 0;
+        let mut fitting_lines = 0;
+        for line in contents.lines() {
+            bytes += line.len() + 1;
+            if bytes.div_ceil(BYTES_PER_TOKEN) > remaining {
+                break;
+            }
+            fitting_lines += 1;
+        }
+        if fitting_lines == 0 {
+            budget.warnings.push(format!(
+                "Context file {} skipped (~{} tokens): max_context_tokens budget of {} is used up",
+                entry, tokens, max_tokens
+            ));
+            remaining = 0;
+            continue;
+        }
+        budget.entries.push(format!(
+            "{} (read only lines 1-{} of {})",
+            entry, fitting_lines, total_lines
+        ));
+        budget.warnings.push(format!(
+            "Context file {} truncated to lines 1-{} of {} (~{} tokens) to fit max_context_tokens {}",
+            entry, fitting_lines, total_lines, tokens, max_tokens
+        ));
+        remaining = 0;
+    }
+    budget
+}
+
+pub fn render_prompt_template(
     template: &str,
     task_file: &str,
     completion_marker: &str,
@@ -709,6 +2810,7 @@ pub fn render_prompt_template(
     max_iterations: u32,
     task_block: Option<&str>,
     context_files: Option<&str>,
+    previous_failure: Option<&str>,
 ) -> String {
     let task_block = task_block.unwrap_or("No task block available.");
     let context_files_section = if let Some(context_files) = context_files {
@@ -721,6 +2823,15 @@ pub fn render_prompt_template(
         String::new()
     };
 
+    let previous_failure = match previous_failure.map(str::trim) {
+        Some(feedback) if !feedback.is_empty() => format!(
+            "Previous Iteration Failed (fix this before moving on):\n{}\n\n",
+            feedback
+        ),
+        _ => String::new(),
+    };
+
+    // Substituted last so backend output cannot inject other placeholders.
     template
         .replace("{task_file}", task_file)
         .replace("{completion_marker}", completion_marker)
@@ -729,6 +2840,121 @@ pub fn render_prompt_template(
         .replace("{task_block}", task_block)
         .replace("{context_files}", context_files.unwrap_or(""))
         .replace("{context_files_section}", &context_files_section)
+        .replace("{previous_failure}", &previous_failure)
+}
+
+impl PromptFrontMatter {
+    /// Prepends the preamble, preferring a `backends.<name>.preamble` override.
+    pub fn template_for(&self, body: &str, backend_name: Option<&str>) -> String {
+        let preamble = backend_name
+            .and_then(|name| self.backends.get(name))
+            .and_then(|overrides| overrides.preamble.as_deref())
+            .or(self.preamble.as_deref())
+            .map(str::trim)
+            .filter(|preamble| !preamble.is_empty());
+        match preamble {
+            Some(preamble) => format!("{}\n\n{}", preamble, body),
+            None => body.to_string(),
+        }
+    }
+}
+
+/// Splits a leading `---` delimited YAML block from the template body.
+/// Templates without a closing delimiter are returned unchanged.
+pub fn split_prompt_front_matter(template: &str) -> Result<(PromptFrontMatter, &str), CoreError> {
+    let Some(rest) = template
+        .strip_prefix("---\n")
+        .or_else(|| template.strip_prefix("---\r\n"))
+    else {
+        return Ok((PromptFrontMatter::default(), template));
+    };
+    let mut offset = 0;
+    for line in rest.split_inclusive('\n') {
+        if line.trim_end() == "---" {
+            let yaml = &rest[..offset];
+            let body = &rest[offset + line.len()..];
+            let value: serde_yaml::Value = serde_yaml::from_str(yaml).map_err(|err| {
+                CoreError::InvalidInput(format!("invalid prompt template front-matter: {}", err))
+            })?;
+            return Ok((front_matter_from_value(&value), body));
+        }
+        offset += line.len();
+    }
+    Ok((PromptFrontMatter::default(), template))
+}
+
+fn front_matter_from_value(value: &serde_yaml::Value) -> PromptFrontMatter {
+    let mut front_matter = PromptFrontMatter {
+        preamble: value
+            .get("preamble")
+            .and_then(yaml_scalar_string)
+            .filter(|preamble| !preamble.trim().is_empty()),
+        vars: yaml_string_map(value.get("vars")),
+        backends: BTreeMap::new(),
+    };
+    if let Some(serde_yaml::Value::Mapping(backends)) = value.get("backends") {
+        for (name, overrides) in backends {
+            if let Some(name) = name.as_str() {
+                let mut overrides = front_matter_from_value(overrides);
+                overrides.backends.clear();
+                front_matter.backends.insert(name.to_string(), overrides);
+            }
+        }
+    }
+    front_matter
+}
+
+fn yaml_string_map(value: Option<&serde_yaml::Value>) -> BTreeMap<String, String> {
+    let mut map = BTreeMap::new();
+    if let Some(serde_yaml::Value::Mapping(entries)) = value {
+        for (key, value) in entries {
+            if let (Some(key), Some(value)) = (key.as_str(), yaml_scalar_string(value)) {
+                map.insert(key.to_string(), value);
+            }
+        }
+    }
+    map
+}
+
+fn yaml_scalar_string(value: &serde_yaml::Value) -> Option<String> {
+    match value {
+        serde_yaml::Value::String(text) => Some(text.clone()),
+        serde_yaml::Value::Bool(flag) => Some(flag.to_string()),
+        serde_yaml::Value::Number(number) => Some(number.to_string()),
+        _ => None,
+    }
+}
+
+/// Merges custom variables: config `prompt.vars`, then front-matter `vars`,
+/// then `backends.<name>.vars`. Names of built-in placeholders are dropped.
+fn resolve_prompt_vars(
+    config: Option<&Config>,
+    front_matter: &PromptFrontMatter,
+    backend_name: Option<&str>,
+) -> BTreeMap<String, String> {
+    let mut vars = BTreeMap::new();
+    if let Some(config) = config {
+        for (key, value) in config.list() {
+            if let Some(name) = key.strip_prefix("prompt.vars.") {
+                vars.insert(name.to_string(), value);
+            }
+        }
+    }
+    vars.extend(front_matter.vars.clone());
+    if let Some(overrides) = backend_name.and_then(|name| front_matter.backends.get(name)) {
+        vars.extend(overrides.vars.clone());
+    }
+    vars.retain(|name, _| !BUILTIN_PROMPT_VARS.contains(&name.as_str()));
+    vars
+}
+
+/// Substitutes `{name}` placeholders for custom variables. Runs before the
+/// built-in placeholders so variable values may reference them.
+fn apply_prompt_vars(template: &str, vars: &BTreeMap<String, String>) -> String {
+    vars.iter()
+        .fold(template.to_string(), |rendered, (name, value)| {
+            rendered.replace(&format!("{{{}}}", name), value)
+        })
 }
 
 fn first_unchecked_line(task_file: &Path) -> Result<Option<String>, CoreError> {
@@ -775,6 +3001,20 @@ fn is_negated_promise(line: &str) -> bool {
     phrases.iter().any(|phrase| prefix.contains(phrase))
 }
 
+fn resolve_iteration_template(
+    project_dir: &Path,
+    prompt_template: Option<&str>,
+    config: Option<&Config>,
+    backend_name: Option<&str>,
+) -> Result<String, CoreError> {
+    let resolved_template = resolve_prompt_template(project_dir, prompt_template)?;
+    let (front_matter, body) = split_prompt_front_matter(&resolved_template)?;
+    Ok(apply_prompt_vars(
+        &front_matter.template_for(body, backend_name),
+        &resolve_prompt_vars(config, &front_matter, backend_name),
+    ))
+}
+
 fn resolve_prompt_template(
     project_dir: &Path,
     prompt_template: Option<&str>,
@@ -803,86 +3043,287 @@ fn resolve_prompt_template(
     Ok(DEFAULT_PROMPT_TEMPLATE.to_string())
 }
 
-fn log_message(log_file: Option<&Path>, message: &str) -> Result<(), CoreError> {
-    println!("{}", message);
-    if let Some(path) = log_file {
-        if let Some(parent) = path.parent() {
-            fs::create_dir_all(parent).map_err(|source| CoreError::Io {
-                path: parent.to_path_buf(),
-                source,
-            })?;
+/// Runs `hooks.post_iteration` and logs it. Returns the failure, if the check
+/// failed, so the loop can treat the iteration as unsuccessful.
+fn run_iteration_check(
+    config: Option<&Config>,
+    project_dir: &Path,
+    logger: &Logger,
+    context: &HookContext<'_>,
+) -> Result<Option<HookError>, CoreError> {
+    let Some(config) = config else {
+        return Ok(None);
+    };
+    match hooks::run_hook(config, HookEvent::PostIteration, project_dir, context) {
+        Ok(None) => Ok(None),
+        Ok(Some(run)) => {
+            logger.info(&format!(
+                "Hook {}: {}",
+                HookEvent::PostIteration.key(),
+                run.command
+            ))?;
+            for line in run.output.lines() {
+                logger.info(&format!("  {}", line))?;
+            }
+            Ok(None)
+        }
+        Err(error) => {
+            logger.warn(&format!(
+                "Iteration {} failed its check: {}",
+                context.iteration, error
+            ))?;
+            Ok(Some(error))
         }
-        let mut file = OpenOptions::new()
-            .create(true)
-            .append(true)
-            .open(path)
-            .map_err(|source| CoreError::Io {
-                path: path.to_path_buf(),
-                source,
-            })?;
-        writeln!(file, "{}", message).map_err(|source| CoreError::Io {
-            path: path.to_path_buf(),
-            source,
-        })?;
     }
-    Ok(())
 }
 
-pub(crate) fn raw_log_path(log_file: &Path) -> PathBuf {
-    let log_str = log_file.to_string_lossy();
-    if log_str.ends_with(".log") {
-        PathBuf::from(log_str.trim_end_matches(".log")).with_extension("raw.log")
-    } else {
-        PathBuf::from(format!("{}.raw.log", log_str))
-    }
+/// What the file guard allows an iteration to change, and what had already
+/// changed when it started.
+struct GuardScope {
+    allowed: Vec<String>,
+    changed_before: Vec<String>,
+    protected_before: ProtectedSnapshot,
 }
 
-pub fn last_log_line(log_file: &Path) -> Option<String> {
-    if log_file.as_os_str().is_empty() || !log_file.is_file() {
-        return None;
-    }
-    let contents = fs::read_to_string(log_file).ok()?;
-    let mut last = None;
-    for line in contents.lines() {
-        if !line.trim().is_empty() {
-            last = Some(line.to_string());
-        }
+/// The task files and the attempted task's Context Bundle are always allowed.
+fn guard_scope(
+    guard: &GuardSettings,
+    project_dir: &Path,
+    base: &str,
+    full_task_path: &Path,
+    task_files: &[String],
+    attempted_task: Option<&str>,
+) -> GuardScope {
+    let mut allowed = task_files.to_vec();
+    if let Some(block) =
+        attempted_task.and_then(|id| find_task_block(full_task_path, id).ok().flatten())
+    {
+        allowed.extend(prd::prd_task_context_entries(&block));
+    }
+    let changed_before = gitops::changed_paths(project_dir, base).unwrap_or_default();
+    GuardScope {
+        allowed,
+        protected_before: guard
+            .snapshot_protected(project_dir, &changed_before)
+            .unwrap_or_default(),
+        changed_before,
     }
-    last
 }
 
-pub fn last_error_line(log_file: &Path) -> Option<String> {
-    if log_file.as_os_str().is_empty() || !log_file.is_file() {
-        return None;
-    }
+/// Runs the file guard after an iteration and logs what it found. Returns
+/// whether the iteration counts as failed: always when it changed protected
+/// paths, and with `guard.fail_iteration` when it changed files outside the
+/// allowed paths. A guard that cannot run only logs a warning.
+fn run_file_guard(
+    guard: &GuardSettings,
+    project_dir: &Path,
+    base: &str,
+    scope: &GuardScope,
+    iteration: u32,
+    logger: &Logger,
+    feedback: &mut Vec<String>,
+) -> Result<bool, CoreError> {
+    let report = match guard.check(
+        project_dir,
+        base,
+        &scope.allowed,
+        &scope.changed_before,
+        &scope.protected_before,
+    ) {
+        Ok(report) => report,
+        Err(error) => {
+            logger.warn(&error.to_string())?;
+            return Ok(false);
+        }
+    };
+    let mut failed = false;
+    if !report.protected.is_empty() {
+        let paths = report.protected.join(", ");
+        logger.warn(&format!(
+            "Iteration {} changed protected files: {}",
+            iteration, paths
+        ))?;
+        logger.info(&format!("Reverted {}", paths))?;
+        feedback.push(format!(
+            "Your changes to protected files were rejected and reverted: {}. These files match guard.protected_paths and must not be modified; make the task work without changing them.",
+            paths
+        ));
+        failed = true;
+    }
+    if report.violations.is_empty() {
+        return Ok(failed);
+    }
+    let paths = report.violations.join(", ");
+    logger.warn(&format!(
+        "Iteration {} changed files outside the allowed paths: {}",
+        iteration, paths
+    ))?;
+    if report.reverted {
+        logger.info(&format!("Reverted {}", paths))?;
+    }
+    feedback.push(format!(
+        "You changed files outside this task's Context Bundle and guard.allowed_paths: {}.{} Only change the files the task needs.",
+        paths,
+        if report.reverted {
+            " Those changes were reverted."
+        } else {
+            ""
+        }
+    ));
+    Ok(failed || guard.fail_iteration)
+}
+
+/// Resets the repo to the commit an iteration started from, so a failed
+/// iteration's code does not carry into the next one. Skipped, with a warning,
+/// when the tree already had uncommitted work before the iteration.
+fn rollback_failed_iteration(
+    project_dir: &Path,
+    iteration: u32,
+    head_before: Option<&str>,
+    clean_before: bool,
+    logger: &Logger,
+) -> Result<bool, CoreError> {
+    let Some(commit) = head_before else {
+        return Ok(false);
+    };
+    if !clean_before {
+        logger.warn(&format!(
+            "Not rolling back iteration {}: the tree had uncommitted changes before it",
+            iteration
+        ))?;
+        return Ok(false);
+    }
+    gitops::reset_to(project_dir, commit)?;
+    logger.info(&format!(
+        "Rolled back iteration {} to {}",
+        iteration,
+        commit.chars().take(7).collect::<String>()
+    ))?;
+    Ok(true)
+}
+
+/// Run a `hooks.*` command and log it. A failing `pre_start` hook aborts the
+/// loop; the post hooks only log a warning.
+fn run_lifecycle_hook(
+    config: Option<&Config>,
+    event: HookEvent,
+    project_dir: &Path,
+    logger: &Logger,
+    context: &HookContext<'_>,
+) -> Result<(), CoreError> {
+    let Some(config) = config else {
+        return Ok(());
+    };
+    match hooks::run_hook(config, event, project_dir, context) {
+        Ok(None) => Ok(()),
+        Ok(Some(run)) => {
+            logger.info(&format!("Hook {}: {}", event.key(), run.command))?;
+            for line in run.output.lines() {
+                logger.info(&format!("  {}", line))?;
+            }
+            Ok(())
+        }
+        Err(error) if event == HookEvent::PreStart => {
+            logger.error(&error.to_string())?;
+            Err(error.into())
+        }
+        Err(error) => {
+            logger.warn(&error.to_string())?;
+            Ok(())
+        }
+    }
+}
+
+/// Logger writing to `log_file` with the `logging` settings from `config`.
+fn config_logger(log_file: Option<&Path>, config: Option<&Config>) -> Logger {
+    Logger::new(
+        log_file,
+        config.map(LogSettings::from_config).unwrap_or_default(),
+    )
+    .redacting(config.map_or_else(|| Redactor::new(&[], &[]), Redactor::from_config))
+}
+
+pub(crate) fn raw_log_path(log_file: &Path) -> PathBuf {
+    let log_str = log_file.to_string_lossy();
+    if log_str.ends_with(".log") {
+        PathBuf::from(log_str.trim_end_matches(".log")).with_extension("raw.log")
+    } else {
+        PathBuf::from(format!("{}.raw.log", log_str))
+    }
+}
+
+pub fn last_log_line(log_file: &Path) -> Option<String> {
+    if log_file.as_os_str().is_empty() || !log_file.is_file() {
+        return None;
+    }
     let contents = fs::read_to_string(log_file).ok()?;
     let mut last = None;
     for line in contents.lines() {
-        let trimmed = line.trim_start();
-        if trimmed.starts_with("Error:") || trimmed.starts_with("Iteration failed:") {
-            last = Some(line.to_string());
+        if !line.trim().is_empty() {
+            last = Some(line);
         }
     }
-    last
+    last.map(logging::display_line)
+}
+
+pub fn last_error_line(log_file: &Path) -> Option<String> {
+    if log_file.as_os_str().is_empty() || !log_file.is_file() {
+        return None;
+    }
+    let contents = fs::read_to_string(log_file).ok()?;
+    let mut last = None;
+    for line in contents.lines() {
+        let is_error = match logging::parse_record(line) {
+            Some((level, _)) => level == Level::Error,
+            None => {
+                let trimmed = line.trim_start();
+                trimmed.starts_with("Error:") || trimmed.starts_with("Iteration failed:")
+            }
+        };
+        if is_error {
+            last = Some(line);
+        }
+    }
+    last.map(logging::display_line)
 }
 
-fn copy_if_exists(from: &Path, to: &Path) -> Result<(), CoreError> {
+/// Copies `from` to `to` with secrets redacted. Output that is not UTF-8
+/// is copied as is.
+fn copy_if_exists(from: &Path, to: &Path, redactor: &Redactor) -> Result<(), CoreError> {
     if !from.is_file() {
         return Ok(());
     }
-    fs::copy(from, to).map_err(|source| CoreError::Io {
+    let bytes = fs::read(from).map_err(|source| CoreError::Io {
+        path: from.to_path_buf(),
+        source,
+    })?;
+    let contents = match std::str::from_utf8(&bytes) {
+        Ok(text) => redactor.redact(text).into_owned().into_bytes(),
+        Err(_) => bytes,
+    };
+    fs::write(to, contents).map_err(|source| CoreError::Io {
         path: to.to_path_buf(),
         source,
     })?;
     Ok(())
 }
 
-fn format_timestamp(timestamp: SystemTime) -> String {
+/// Human-readable local time used in session logs; see [`parse_log_timestamp`].
+pub fn format_timestamp(timestamp: SystemTime) -> String {
     let datetime: chrono::DateTime<chrono::Local> = timestamp.into();
     datetime.format("%Y-%m-%d %H:%M:%S %Z").to_string()
 }
 
-fn format_duration(duration_secs: u64) -> String {
+/// Parse a timestamp written by [`format_timestamp`]. The trailing zone
+/// abbreviation is ignored and the time is read as local time.
+pub fn parse_log_timestamp(value: &str) -> Option<SystemTime> {
+    let datetime = value.trim().get(..19)?;
+    let naive = chrono::NaiveDateTime::parse_from_str(datetime, "%Y-%m-%d %H:%M:%S").ok()?;
+    let local = naive.and_local_timezone(chrono::Local).earliest()?;
+    Some(local.into())
+}
+
+pub fn format_duration(duration_secs: u64) -> String {
     let hours = duration_secs / 3600;
     let minutes = (duration_secs % 3600) / 60;
     let seconds = duration_secs % 60;
@@ -933,7 +3374,11 @@ fn cleanup_old_logs_with_clock(
             Err(_) => continue,
         };
         let path = entry.path();
-        if path.extension().and_then(|ext| ext.to_str()) != Some("log") {
+        if !path
+            .file_name()
+            .and_then(|name| name.to_str())
+            .is_some_and(logging::is_log_file_name)
+        {
             continue;
         }
         let metadata = match entry.metadata() {
@@ -1076,6 +3521,10 @@ mod tests {
     }
 
     impl Backend for TestBackend {
+        fn name(&self) -> &str {
+            "test"
+        }
+
         fn check_installed(&self) -> bool {
             true
         }
@@ -1110,6 +3559,7 @@ mod tests {
     struct LoopBackend {
         response: String,
         fail_run: bool,
+        usage: Option<Usage>,
     }
 
     impl LoopBackend {
@@ -1117,6 +3567,7 @@ mod tests {
             Self {
                 response: response.to_string(),
                 fail_run: false,
+                usage: None,
             }
         }
 
@@ -1124,11 +3575,21 @@ mod tests {
             Self {
                 response: String::new(),
                 fail_run: true,
+                usage: None,
             }
         }
+
+        fn with_usage(mut self, usage: Usage) -> Self {
+            self.usage = Some(usage);
+            self
+        }
     }
 
     impl Backend for LoopBackend {
+        fn name(&self) -> &str {
+            "test"
+        }
+
         fn check_installed(&self) -> bool {
             true
         }
@@ -1157,6 +3618,10 @@ mod tests {
             })
         }
 
+        fn parse_usage(&self, _response_file: &Path) -> Option<Usage> {
+            self.usage
+        }
+
         fn get_models(&self) -> Vec<String> {
             Vec::new()
         }
@@ -1177,6 +3642,10 @@ mod tests {
     }
 
     impl Backend for StubBackend {
+        fn name(&self) -> &str {
+            "test"
+        }
+
         fn check_installed(&self) -> bool {
             true
         }
@@ -1225,6 +3694,10 @@ mod tests {
     }
 
     impl Backend for ParseFailBackend {
+        fn name(&self) -> &str {
+            "test"
+        }
+
         fn check_installed(&self) -> bool {
             true
         }
@@ -1255,6 +3728,10 @@ mod tests {
     struct UninstalledBackend;
 
     impl Backend for UninstalledBackend {
+        fn name(&self) -> &str {
+            "test"
+        }
+
         fn check_installed(&self) -> bool {
             false
         }
@@ -1279,6 +3756,44 @@ mod tests {
         }
     }
 
+    #[test]
+    fn task_override_reads_backend_and_model_fields() {
+        let backend = TestBackend::new();
+        let resolve = |block: &str| {
+            TaskOverride::resolve(
+                Some(block),
+                &backend,
+                Some("loop-model"),
+                Some("high"),
+                None,
+            )
+        };
+
+        assert!(resolve("### Task A-1\n- [ ] A-1 Task\n").unwrap().is_none());
+
+        let model_only = resolve("### Task A-2\n- **Model** opus\n- [ ] A-2 Task\n")
+            .unwrap()
+            .unwrap();
+        assert!(model_only.backend.is_none());
+        assert_eq!(model_only.model.as_deref(), Some("opus"));
+        assert_eq!(model_only.variant.as_deref(), Some("high"));
+        assert_eq!(
+            model_only.describe(backend.name()),
+            "Task runs on backend test (model opus)"
+        );
+
+        let same_backend = resolve("### Task A-3\n- **Backend** test\n- [ ] A-3 Task\n")
+            .unwrap()
+            .unwrap();
+        assert!(same_backend.backend.is_none());
+        assert_eq!(same_backend.model.as_deref(), Some("loop-model"));
+
+        let err = resolve("### Task A-4\n- **Backend** nope\n- [ ] A-4 Task\n")
+            .err()
+            .unwrap();
+        assert!(err.to_string().contains("Unknown backend: nope"));
+    }
+
     #[test]
     fn count_remaining_tasks_ignores_outside_blocks() {
         let temp = tempfile::tempdir().unwrap();
@@ -1290,6 +3805,43 @@ mod tests {
         assert_eq!(count, 1);
     }
 
+    #[test]
+    fn resolve_task_files_expands_globs_and_orders_by_dependency() {
+        let temp = tempfile::tempdir().unwrap();
+        let dir = temp.path();
+        fs::write(
+            dir.join("PRD-frontend.md"),
+            "### Task UI-1\n- **ID** UI-1\n- **Dependencies** API-1\n- [ ] UI-1 Screen\n",
+        )
+        .unwrap();
+        fs::write(
+            dir.join("PRD-backend.md"),
+            "### Task API-1\n- **ID** API-1\n- **Dependencies** None\n- [x] API-1 Endpoint\n- [ ] API-1 Docs\n",
+        )
+        .unwrap();
+        fs::write(dir.join("PRD.md"), "- [ ] Other\n").unwrap();
+
+        assert_eq!(
+            resolve_task_files(dir, "PRD-*.md").unwrap(),
+            vec!["PRD-backend.md", "PRD-frontend.md"]
+        );
+        assert_eq!(
+            resolve_task_files(dir, "PRD-frontend.md, PRD-backend.md, PRD-frontend.md").unwrap(),
+            vec!["PRD-backend.md", "PRD-frontend.md"]
+        );
+        assert_eq!(resolve_task_files(dir, "PRD.md").unwrap(), vec!["PRD.md"]);
+        assert!(resolve_task_files(dir, "TODO-*.md").is_err());
+
+        assert_eq!(count_remaining_for(dir, "PRD-*.md"), 2);
+        assert_eq!(active_task_file(dir, "PRD-*.md"), "PRD-backend.md");
+        fs::write(
+            dir.join("PRD-backend.md"),
+            "### Task API-1\n- **ID** API-1\n- [x] API-1 Endpoint\n",
+        )
+        .unwrap();
+        assert_eq!(active_task_file(dir, "PRD-*.md"), "PRD-frontend.md");
+    }
+
     #[test]
     fn check_completion_requires_promise_line() {
         let temp = tempfile::tempdir().unwrap();
@@ -1499,6 +4051,29 @@ mod tests {
         assert_eq!(normalized, "README.md\nARCHITECTURE.md");
     }
 
+    #[test]
+    fn render_prompt_template_includes_previous_failure_section() {
+        let template = "Header\n{previous_failure}Footer";
+        let rendered = render_prompt_template(
+            template,
+            "PRD.md",
+            "COMPLETE",
+            2,
+            3,
+            Some("Block"),
+            None,
+            Some("tests failed: {task_block}\n"),
+        );
+        assert_eq!(
+            rendered,
+            "Header\nPrevious Iteration Failed (fix this before moving on):\ntests failed: {task_block}\n\nFooter"
+        );
+
+        let rendered =
+            render_prompt_template(template, "PRD.md", "COMPLETE", 1, 3, None, None, Some("  "));
+        assert_eq!(rendered, "Header\nFooter");
+    }
+
     #[test]
     fn render_prompt_template_includes_context_files_section() {
         let template = "Header\n{context_files_section}Footer";
@@ -1510,6 +4085,7 @@ mod tests {
             3,
             Some("Block"),
             Some("ARCHITECTURE.md\nPROCESS.md"),
+            None,
         );
 
         assert!(
@@ -1519,6 +4095,200 @@ mod tests {
         assert!(rendered.contains("Footer"));
     }
 
+    #[test]
+    fn fit_context_files_truncates_and_skips_over_budget() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("small.md"), "a".repeat(40)).unwrap();
+        fs::write(temp.path().join("large.md"), "line\n".repeat(100)).unwrap();
+        fs::write(temp.path().join("later.md"), "b".repeat(8)).unwrap();
+        let files = "small.md, missing.md, large.md, later.md, small.md";
+
+        let unlimited = fit_context_files(temp.path(), files, None);
+        assert_eq!(unlimited.entries.len(), 5);
+        assert!(unlimited.warnings.is_empty());
+
+        let budget = fit_context_files(temp.path(), files, Some(30));
+
+        assert_eq!(
+            budget.entries,
+            vec![
+                "small.md".to_string(),
+                "missing.md".to_string(),
+                "large.md (read only lines 1-16 of 100)".to_string(),
+            ]
+        );
+        assert_eq!(budget.warnings.len(), 2);
+        assert!(budget.warnings[0].contains("large.md truncated to lines 1-16 of 100"));
+        assert!(budget.warnings[1].contains("later.md skipped"));
+    }
+
+    #[test]
+    fn render_iteration_prompt_sizes_context_bundle_for_backend() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let config_path = temp.path().join("default.yaml");
+        fs::write(
+            &config_path,
+            "backends:\n  codex:\n    max_context_tokens: 5\n",
+        )
+        .unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env("GRALPH_GLOBAL_CONFIG", temp.path().join("missing.yaml"));
+        let config = Config::load(None).unwrap();
+        remove_env("GRALPH_DEFAULT_CONFIG");
+        remove_env("GRALPH_GLOBAL_CONFIG");
+        fs::write(temp.path().join("big.rs"), "x".repeat(400)).unwrap();
+        fs::write(
+            temp.path().join("PRD.md"),
+            "### Task A-1\n- **ID** A-1\n- **Context Bundle** `big.rs`\n- **DoD** Done.\n- [ ] A-1 Work\n",
+        )
+        .unwrap();
+
+        let render = |backend| {
+            render_iteration_prompt(
+                temp.path(),
+                "PRD.md",
+                1,
+                1,
+                "COMPLETE",
+                Some("{context_files_section}"),
+                Some(&config),
+                Some(backend),
+                None,
+            )
+            .unwrap()
+        };
+
+        let codex = render("codex");
+        assert!(codex.prompt.is_empty());
+        assert_eq!(codex.context_warnings.len(), 1);
+        assert!(codex.context_warnings[0].contains("big.rs skipped (~100 tokens)"));
+        let claude = render("claude");
+        assert!(claude.prompt.is_empty());
+        assert!(claude.context_warnings.is_empty());
+    }
+
+    #[test]
+    fn record_task_progress_diffs_checked_boxes() {
+        let temp = tempfile::tempdir().unwrap();
+        let before = BTreeMap::from([("A-1".to_string(), false), ("A-2".to_string(), false)]);
+        let after = BTreeMap::from([("A-1".to_string(), true), ("A-2".to_string(), false)]);
+        let record = TaskIterationRecord {
+            session: "demo",
+            backend: "codex",
+            iteration: 2,
+            duration_secs: 40,
+            attempted: Some("A-1"),
+            failed: false,
+            finished_at: UNIX_EPOCH + Duration::from_secs(1_700_000_000),
+        };
+
+        let closed = record_task_progress(temp.path(), &before, &after, &record).unwrap();
+        assert_eq!(closed, vec!["A-1"]);
+
+        let failed = TaskIterationRecord {
+            attempted: Some("A-2"),
+            failed: true,
+            iteration: 3,
+            ..record.clone()
+        };
+        let closed = record_task_progress(temp.path(), &after, &after, &failed).unwrap();
+        assert!(closed.is_empty());
+
+        let contents = fs::read_to_string(task_records_path(temp.path())).unwrap();
+        let value: serde_json::Value = serde_json::from_str(&contents).unwrap();
+        let done = &value["tasks"]["A-1"];
+        assert_eq!(done["status"], "done");
+        assert_eq!(done["backend"], "codex");
+        assert_eq!(done["completed_iteration"], 2);
+        assert_eq!(done["duration_secs"], 40);
+        let pending = &value["tasks"]["A-2"];
+        assert_eq!(pending["status"], "failed");
+        assert_eq!(pending["attempts"], 1);
+        assert!(pending.get("completed_at").is_none());
+    }
+
+    #[test]
+    fn split_prompt_front_matter_parses_preamble_vars_and_backends() {
+        let template = "---\npreamble: Be terse.\nvars:\n  team: payments\n  retries: 3\nbackends:\n  codex:\n    preamble: Codex rules.\n    vars:\n      team: codex-team\n---\nTeam {team}\n";
+        let (front_matter, body) = split_prompt_front_matter(template).unwrap();
+
+        assert_eq!(body, "Team {team}\n");
+        assert_eq!(front_matter.preamble.as_deref(), Some("Be terse."));
+        assert_eq!(
+            front_matter.vars.get("retries").map(String::as_str),
+            Some("3")
+        );
+        let codex = front_matter.backends.get("codex").unwrap();
+        assert_eq!(
+            codex.vars.get("team").map(String::as_str),
+            Some("codex-team")
+        );
+
+        assert_eq!(
+            front_matter.template_for(body, Some("claude")),
+            "Be terse.\n\nTeam {team}\n"
+        );
+        assert_eq!(
+            front_matter.template_for(body, Some("codex")),
+            "Codex rules.\n\nTeam {team}\n"
+        );
+    }
+
+    #[test]
+    fn split_prompt_front_matter_passes_through_plain_templates() {
+        for template in [
+            "Plain {task_file}",
+            "---\nno closing delimiter",
+            "Text\n---\nMore",
+        ] {
+            let (front_matter, body) = split_prompt_front_matter(template).unwrap();
+            assert_eq!(front_matter, PromptFrontMatter::default());
+            assert_eq!(body, template);
+        }
+
+        let err = split_prompt_front_matter("---\nvars: [\n---\nBody").unwrap_err();
+        assert!(err.to_string().contains("front-matter"));
+    }
+
+    #[test]
+    fn resolve_prompt_vars_layers_config_front_matter_and_backend() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let config_path = temp.path().join("default.yaml");
+        fs::write(
+            &config_path,
+            "prompt:\n  vars:\n    team: config-team\n    lang: rust\n    iteration: 99\n",
+        )
+        .unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env("GRALPH_GLOBAL_CONFIG", temp.path().join("missing.yaml"));
+        let config = Config::load(None).unwrap();
+        remove_env("GRALPH_DEFAULT_CONFIG");
+        remove_env("GRALPH_GLOBAL_CONFIG");
+
+        let (front_matter, body) = split_prompt_front_matter(
+            "---\nvars:\n  team: fm-team\nbackends:\n  codex:\n    vars:\n      lang: go\n---\n{team}/{lang}/{iteration}",
+        )
+        .unwrap();
+
+        let vars = resolve_prompt_vars(Some(&config), &front_matter, Some("claude"));
+        assert_eq!(apply_prompt_vars(body, &vars), "fm-team/rust/{iteration}");
+
+        let vars = resolve_prompt_vars(Some(&config), &front_matter, Some("codex"));
+        let rendered = render_prompt_template(
+            &apply_prompt_vars(body, &vars),
+            "PRD.md",
+            "COMPLETE",
+            2,
+            5,
+            None,
+            None,
+            None,
+        );
+        assert_eq!(rendered, "fm-team/go/2");
+    }
+
     #[test]
     fn resolve_prompt_template_prefers_explicit_template() {
         let _guard = env_guard();
@@ -1643,12 +4413,13 @@ mod tests {
     }
 
     #[test]
-    fn log_message_creates_parent_and_appends() {
+    fn config_logger_creates_parent_and_appends() {
         let temp = tempfile::tempdir().unwrap();
         let log_path = temp.path().join("logs").join("loop.log");
+        let logger = config_logger(Some(&log_path), None);
 
-        log_message(Some(&log_path), "first").unwrap();
-        log_message(Some(&log_path), "second").unwrap();
+        logger.info("first").unwrap();
+        logger.info("second").unwrap();
 
         let contents = fs::read_to_string(&log_path).unwrap();
         assert!(contents.contains("first"));
@@ -1656,12 +4427,14 @@ mod tests {
     }
 
     #[test]
-    fn log_message_errors_when_path_is_directory() {
+    fn config_logger_errors_when_path_is_directory() {
         let temp = tempfile::tempdir().unwrap();
         let dir_path = temp.path().join("logs");
         fs::create_dir_all(&dir_path).unwrap();
 
-        let result = log_message(Some(&dir_path), "message");
+        let result: Result<(), CoreError> = config_logger(Some(&dir_path), None)
+            .info("message")
+            .map_err(CoreError::from);
         assert!(matches!(result, Err(CoreError::Io { .. })));
     }
 
@@ -1709,21 +4482,34 @@ mod tests {
         let from = temp.path().join("missing.txt");
         let to = temp.path().join("target.txt");
 
-        copy_if_exists(&from, &to).unwrap();
+        copy_if_exists(&from, &to, &Redactor::default()).unwrap();
         assert!(!to.exists());
     }
 
     #[test]
-    fn copy_if_exists_copies_source_file() {
+    fn copy_if_exists_copies_source_file_redacted() {
         let temp = tempfile::tempdir().unwrap();
         let from = temp.path().join("source.txt");
         let to = temp.path().join("target.txt");
-        fs::write(&from, "data").unwrap();
+        fs::write(&from, "data token-123\n").unwrap();
 
-        copy_if_exists(&from, &to).unwrap();
+        copy_if_exists(
+            &from,
+            &to,
+            &Redactor::new(&["token-[0-9]+".to_string()], &[]),
+        )
+        .unwrap();
 
         let contents = fs::read_to_string(&to).unwrap();
-        assert_eq!(contents, "data");
+        assert_eq!(contents, "data [REDACTED]\n");
+    }
+
+    #[test]
+    fn parse_log_timestamp_round_trips_format_timestamp() {
+        let time = UNIX_EPOCH + Duration::from_secs(1_767_225_600);
+
+        assert_eq!(parse_log_timestamp(&format_timestamp(time)), Some(time));
+        assert_eq!(parse_log_timestamp("not a time"), None);
     }
 
     #[test]
@@ -1733,10 +4519,12 @@ mod tests {
         fs::create_dir_all(&log_dir).unwrap();
 
         let old_log = log_dir.join("old.log");
+        let old_backup = log_dir.join("old.raw.log.1");
         let recent_log = log_dir.join("recent.log");
         let keep_txt = log_dir.join("keep.txt");
 
         fs::write(&old_log, "old").unwrap();
+        fs::write(&old_backup, "old").unwrap();
         fs::write(&recent_log, "recent").unwrap();
         fs::write(&keep_txt, "keep").unwrap();
 
@@ -1744,11 +4532,13 @@ mod tests {
             .checked_sub(Duration::from_secs(9 * 86400))
             .unwrap();
         set_modified(&old_log, old_time);
+        set_modified(&old_backup, old_time);
         set_modified(&keep_txt, old_time);
 
         cleanup_old_logs(&log_dir, None).unwrap();
 
         assert!(!old_log.exists());
+        assert!(!old_backup.exists());
         assert!(recent_log.exists());
         assert!(keep_txt.exists());
     }
@@ -1897,55 +4687,154 @@ mod tests {
     }
 
     #[test]
-    fn run_iteration_includes_context_files_from_config() {
-        let _guard = env_guard();
+    fn run_task_runs_named_block_with_context_bundle_and_checks_it() {
         let temp = tempfile::tempdir().unwrap();
         let path = temp.path().join("PRD.md");
-        fs::write(&path, "### Task COV-CORE\n- [ ] Task\n").unwrap();
-
-        let config_path = temp.path().join("config.yaml");
         fs::write(
-            &config_path,
-            "defaults:\n  context_files:\n    - ARCHITECTURE.md\n    - PROCESS.md\n",
+            &path,
+            "### Task A-1\n- **ID** A-1\n- [ ] A-1 First\n\n### Task A-2\n- **ID** A-2\n- **Context Bundle** `src/lib.rs`\n- [ ] A-2 Second\n",
         )
         .unwrap();
-        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
-        let global_path = temp.path().join("missing-global.yaml");
-        set_env("GRALPH_GLOBAL_CONFIG", &global_path);
-
-        let config = Config::load(None).unwrap();
 
         let backend = TestBackend::new();
-        let _ = run_iteration(
+        run_task_with_clock(
             &backend,
             temp.path(),
             "PRD.md",
-            1,
-            2,
+            "a-2",
             "COMPLETE",
             None,
             None,
             None,
-            Some("{context_files_section}Task:\n{task_block}\n"),
-            Some(&config),
+            Some("{context_files_section}Block:\n{task_block}\n"),
+            None,
+            &SystemClock,
         )
         .unwrap();
 
         let prompt = backend.prompt.borrow().clone().unwrap();
-        assert!(
-            prompt.contains("Context Files (read these first):\nARCHITECTURE.md\nPROCESS.md\n")
-        );
-
-        remove_env("GRALPH_GLOBAL_CONFIG");
-        remove_env("GRALPH_DEFAULT_CONFIG");
+        assert!(prompt.starts_with("Work only on task a-2 below."));
+        assert!(prompt.contains("Context Files (read these first):\nsrc/lib.rs\n"));
+        assert!(prompt.contains("- [ ] A-2 Second"));
+        assert!(!prompt.contains("A-1 First"));
+        let contents = fs::read_to_string(&path).unwrap();
+        assert!(contents.contains("- [ ] A-1 First"));
+        assert!(contents.contains("- [x] A-2 Second"));
     }
 
     #[test]
-    fn run_iteration_rejects_empty_project_dir() {
-        let backend = TestBackend::new();
-        let result = run_iteration(
-            &backend,
-            Path::new(""),
+    fn run_task_leaves_box_unchecked_on_failure_and_rejects_unknown_ids() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("PRD.md");
+        fs::write(
+            &path,
+            "### Task A-1\n- [ ] A-1 First\n\n### Task A-2\n- [x] A-2 Done\n",
+        )
+        .unwrap();
+
+        let backend = ParseFailBackend::new("raw-output", "parse failed");
+        let run = |id: &str| {
+            run_task_with_clock(
+                &backend,
+                temp.path(),
+                "PRD.md",
+                id,
+                "COMPLETE",
+                None,
+                None,
+                None,
+                None,
+                None,
+                &SystemClock,
+            )
+        };
+
+        assert!(matches!(run("A-1"), Err(CoreError::Backend(_))));
+        assert!(
+            fs::read_to_string(&path)
+                .unwrap()
+                .contains("- [ ] A-1 First")
+        );
+        assert!(matches!(
+            run("A-2"),
+            Err(CoreError::InvalidInput(message)) if message.contains("already complete")
+        ));
+        assert!(matches!(
+            run("B-9"),
+            Err(CoreError::InvalidInput(message)) if message.contains("task not found")
+        ));
+    }
+
+    #[test]
+    fn set_task_checked_toggles_only_the_named_block() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("PRD.md");
+        fs::write(&path, "### Task A-1\n  - [ ] A-1 First\n---\n- [ ] Stray\n").unwrap();
+
+        assert!(set_task_checked(&path, "A-1", true).unwrap());
+        assert!(!set_task_checked(&path, "A-1", true).unwrap());
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "### Task A-1\n  - [x] A-1 First\n---\n- [ ] Stray\n"
+        );
+        assert!(set_task_checked(&path, "A-1", false).unwrap());
+        assert!(!set_task_checked(&path, "Z-1", true).unwrap());
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "### Task A-1\n  - [ ] A-1 First\n---\n- [ ] Stray\n"
+        );
+    }
+
+    #[test]
+    fn run_iteration_includes_context_files_from_config() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("PRD.md");
+        fs::write(&path, "### Task COV-CORE\n- [ ] Task\n").unwrap();
+
+        let config_path = temp.path().join("config.yaml");
+        fs::write(
+            &config_path,
+            "defaults:\n  context_files:\n    - ARCHITECTURE.md\n    - PROCESS.md\n",
+        )
+        .unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        let global_path = temp.path().join("missing-global.yaml");
+        set_env("GRALPH_GLOBAL_CONFIG", &global_path);
+
+        let config = Config::load(None).unwrap();
+
+        let backend = TestBackend::new();
+        let _ = run_iteration(
+            &backend,
+            temp.path(),
+            "PRD.md",
+            1,
+            2,
+            "COMPLETE",
+            None,
+            None,
+            None,
+            Some("{context_files_section}Task:\n{task_block}\n"),
+            Some(&config),
+        )
+        .unwrap();
+
+        let prompt = backend.prompt.borrow().clone().unwrap();
+        assert!(
+            prompt.contains("Context Files (read these first):\nARCHITECTURE.md\nPROCESS.md\n")
+        );
+
+        remove_env("GRALPH_GLOBAL_CONFIG");
+        remove_env("GRALPH_DEFAULT_CONFIG");
+    }
+
+    #[test]
+    fn run_iteration_rejects_empty_project_dir() {
+        let backend = TestBackend::new();
+        let result = run_iteration(
+            &backend,
+            Path::new(""),
             "PRD.md",
             1,
             1,
@@ -2492,6 +5381,216 @@ mod tests {
         );
     }
 
+    fn hooks_config(dir: &Path, yaml: &str) -> Config {
+        let _guard = env_guard();
+        let config_path = dir.join("hooks.yaml");
+        fs::write(&config_path, yaml).unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env("GRALPH_GLOBAL_CONFIG", dir.join("missing.yaml"));
+        let config = Config::load(None).unwrap();
+        remove_env("GRALPH_DEFAULT_CONFIG");
+        remove_env("GRALPH_GLOBAL_CONFIG");
+        config
+    }
+
+    #[test]
+    fn loop_warns_when_task_estimates_exceed_max_iterations() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(
+            temp.path().join("PRD.md"),
+            "### Task E-1\n- **ID** E-1\n- **Estimate** L\n- [ ] E-1 Task\n---\n### Task E-2\n- **ID** E-2\n- **Estimate** 2\n- [ ] E-2 Task\n",
+        )
+        .unwrap();
+
+        let backend = LoopBackend::success("Still working\n");
+        let outcome = run_loop(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(1),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            None,
+            None,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::MaxIterations);
+        let log = fs::read_to_string(temp.path().join(".gralph/session.log")).unwrap();
+        assert!(log.contains("Task estimates: 6 iterations for 2 remaining tasks"));
+        assert!(log.contains(
+            "task estimates add up to 6 iterations, more than max iterations (1); consider --max-iterations 9"
+        ));
+    }
+
+    #[test]
+    fn loop_runs_pre_start_and_post_complete_hooks() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("PRD.md"), "- [x] Done\n").unwrap();
+        let config = hooks_config(
+            temp.path(),
+            "hooks:\n  pre_start: echo \"pre $GRALPH_SESSION $GRALPH_REMAINING\" >> hooks.out\n  post_complete: echo \"post $GRALPH_ITERATION\" >> hooks.out\n",
+        );
+
+        let backend = LoopBackend::success("All done\n<promise>COMPLETE</promise>\n");
+        let outcome = run_loop(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(1),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            Some(&config),
+            None,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::Complete);
+        let hooks_out = fs::read_to_string(temp.path().join("hooks.out")).unwrap();
+        assert_eq!(hooks_out, "pre session 0\npost 1\n");
+        let log = fs::read_to_string(temp.path().join(".gralph/session.log")).unwrap();
+        assert!(log.contains("Hook post_complete: echo"));
+    }
+
+    #[test]
+    fn loop_exports_loop_iteration_and_backend_spans() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("PRD.md"), "- [x] Done\n").unwrap();
+        let (endpoint, handle) = crate::test_support::serve_http_sequence(vec![
+            ("HTTP/1.1 200 OK", String::new()),
+            ("HTTP/1.1 200 OK", String::new()),
+        ]);
+        let config = hooks_config(
+            temp.path(),
+            &format!("telemetry:\n  endpoint: {}\n", endpoint),
+        );
+
+        let backend = LoopBackend::success("All done\n<promise>COMPLETE</promise>\n");
+        let outcome = run_loop(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(1),
+            Some("COMPLETE"),
+            Some("sonnet"),
+            None,
+            Some("session"),
+            None,
+            Some(&config),
+            None,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::Complete);
+        let spans: Vec<serde_json::Value> = handle
+            .join()
+            .unwrap()
+            .iter()
+            .flat_map(|request| {
+                let (_, body) = request.split_once("\r\n\r\n").unwrap();
+                let body: serde_json::Value = serde_json::from_str(body).unwrap();
+                body["resourceSpans"][0]["scopeSpans"][0]["spans"]
+                    .as_array()
+                    .unwrap()
+                    .clone()
+            })
+            .collect();
+        let names: Vec<&str> = spans
+            .iter()
+            .map(|span| span["name"].as_str().unwrap())
+            .collect();
+        assert_eq!(
+            names,
+            vec!["gralph.backend", "gralph.iteration", "gralph.loop"]
+        );
+        let attribute = |span: &serde_json::Value, key: &str| {
+            span["attributes"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .find(|attribute| attribute["key"] == key)
+                .map(|attribute| attribute["value"].clone())
+        };
+        assert_eq!(
+            attribute(&spans[0], "gralph.model"),
+            Some(serde_json::json!({ "stringValue": "sonnet" }))
+        );
+        assert_eq!(
+            attribute(&spans[1], "gralph.iteration"),
+            Some(serde_json::json!({ "intValue": "1" }))
+        );
+        assert_eq!(
+            attribute(&spans[2], "gralph.exit_status"),
+            Some(serde_json::json!({ "stringValue": "complete" }))
+        );
+        assert_eq!(spans[1]["parentSpanId"], spans[2]["spanId"]);
+    }
+
+    #[test]
+    fn loop_aborts_when_pre_start_hook_fails() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("PRD.md"), "- [ ] Task\n").unwrap();
+        let config = hooks_config(
+            temp.path(),
+            "hooks:\n  pre_start: exit 3\n  post_fail: touch failed.out\n",
+        );
+
+        let backend = LoopBackend::success("should not run");
+        let result = run_loop(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(1),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            Some(&config),
+            None,
+        );
+
+        assert!(matches!(result, Err(CoreError::Hook(_))));
+        let log = fs::read_to_string(temp.path().join(".gralph/session.log")).unwrap();
+        assert!(!log.contains("=== Iteration"));
+        assert!(!temp.path().join("failed.out").exists());
+    }
+
+    #[test]
+    fn loop_runs_post_fail_hook_on_backend_error() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("PRD.md"), "- [ ] Task\n").unwrap();
+        let config = hooks_config(
+            temp.path(),
+            "hooks:\n  post_fail: echo \"$GRALPH_ITERATION $GRALPH_REMAINING\" > failed.out\n",
+        );
+
+        let backend = LoopBackend::fail();
+        let result = run_loop(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(1),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            Some(&config),
+            None,
+        );
+
+        assert!(matches!(result, Err(CoreError::Backend(_))));
+        let failed = fs::read_to_string(temp.path().join("failed.out")).unwrap();
+        assert_eq!(failed.trim(), "1 1");
+    }
+
     #[test]
     fn loop_hits_max_iterations_and_updates_state() {
         let temp = tempfile::tempdir().unwrap();
@@ -2532,6 +5631,1319 @@ mod tests {
         );
     }
 
+    struct UnpauseClock {
+        pause_file: PathBuf,
+    }
+
+    impl Clock for UnpauseClock {
+        fn now(&self) -> SystemTime {
+            SystemTime::now()
+        }
+
+        fn sleep(&self, _duration: Duration) {
+            let _ = fs::remove_file(&self.pause_file);
+        }
+    }
+
+    #[test]
+    fn loop_waits_while_pause_file_exists() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("PRD.md");
+        fs::write(&path, "- [ ] Task\n").unwrap();
+        let project_dir = temp.path().canonicalize().unwrap();
+        let pause_file = pause_file_path(&project_dir, Some("session"));
+        fs::create_dir_all(pause_file.parent().unwrap()).unwrap();
+        fs::write(&pause_file, "").unwrap();
+
+        let backend = LoopBackend::success("Still working\n");
+        let mut updates: Vec<(u32, LoopStatus, usize)> = Vec::new();
+        let mut callback = |_: Option<&str>, iteration, status, remaining| {
+            updates.push((iteration, status, remaining));
+        };
+        let clock = UnpauseClock {
+            pause_file: pause_file.clone(),
+        };
+
+        let outcome = run_loop_with_clock(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(1),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            None,
+            Some(&mut callback),
+            None,
+            LoopBudget::default(),
+            &clock,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::MaxIterations);
+        assert!(!pause_file.exists());
+        assert_eq!(updates[0], (1, LoopStatus::Paused, 1));
+        assert_eq!(updates[1], (1, LoopStatus::Running, 1));
+        let log = fs::read_to_string(project_dir.join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("Paused before iteration 1/1"));
+        assert!(log.contains("Resumed"));
+    }
+
+    /// Prints progress the way CLI backends do, then replies.
+    struct ChattyBackend;
+
+    impl Backend for ChattyBackend {
+        fn name(&self) -> &str {
+            "test"
+        }
+
+        fn check_installed(&self) -> bool {
+            true
+        }
+
+        fn run_iteration(
+            &self,
+            _prompt: &str,
+            _model: Option<&str>,
+            _variant: Option<&str>,
+            output_file: &Path,
+            _working_dir: &Path,
+        ) -> Result<(), BackendError> {
+            crate::backend::echo_output(&mut io::sink(), "Reading PRD.md\r\n\nRunning tests\n")?;
+            fs::write(output_file, "done").unwrap();
+            Ok(())
+        }
+
+        fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+            Ok(fs::read_to_string(response_file).unwrap())
+        }
+
+        fn get_models(&self) -> Vec<String> {
+            Vec::new()
+        }
+    }
+
+    #[test]
+    fn execute_prompt_copies_live_backend_output_into_the_log() {
+        let temp = tempfile::tempdir().unwrap();
+        let log_path = temp.path().join(".gralph").join("session.log");
+        let logger = Logger::new(Some(&log_path), LogSettings::default());
+
+        let result = execute_prompt(
+            &ChattyBackend,
+            None,
+            "prompt",
+            None,
+            None,
+            temp.path(),
+            &logger,
+            &SystemClock,
+        )
+        .unwrap();
+
+        assert_eq!(result.result, "done");
+        let log = fs::read_to_string(&log_path).unwrap();
+        let lines: Vec<&str> = log
+            .lines()
+            .map(|line| line.split_once(" | ").unwrap().1)
+            .collect();
+        assert_eq!(lines, vec!["Reading PRD.md", "Running tests"]);
+    }
+
+    struct InterruptedBackend;
+
+    impl Backend for InterruptedBackend {
+        fn name(&self) -> &str {
+            "test"
+        }
+
+        fn check_installed(&self) -> bool {
+            true
+        }
+
+        fn run_iteration(
+            &self,
+            _prompt: &str,
+            _model: Option<&str>,
+            _variant: Option<&str>,
+            _output_file: &Path,
+            _working_dir: &Path,
+        ) -> Result<(), BackendError> {
+            shutdown::request_for_test(libc::SIGINT);
+            Err(BackendError::Command(
+                "test interrupted by SIGINT".to_string(),
+            ))
+        }
+
+        fn parse_text(&self, _response_file: &Path) -> Result<String, BackendError> {
+            Ok(String::new())
+        }
+
+        fn get_models(&self) -> Vec<String> {
+            Vec::new()
+        }
+    }
+
+    #[test]
+    fn loop_stops_when_shutdown_interrupts_backend() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("PRD.md");
+        fs::write(&path, "- [ ] Task\n").unwrap();
+        let mut updates: Vec<(u32, LoopStatus, usize)> = Vec::new();
+        let mut callback = |_: Option<&str>, iteration, status, remaining| {
+            updates.push((iteration, status, remaining));
+        };
+
+        let outcome = run_loop(
+            &InterruptedBackend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(3),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            None,
+            Some(&mut callback),
+        );
+        shutdown::clear_for_test();
+
+        let outcome = outcome.unwrap();
+        assert_eq!(outcome.status, LoopStatus::Stopped);
+        assert_eq!(outcome.iterations, 1);
+        assert_eq!(outcome.remaining_tasks, 1);
+        assert_eq!(updates.last(), Some(&(1, LoopStatus::Stopped, 1)));
+        let log = fs::read_to_string(temp.path().join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("Iteration interrupted"));
+        assert!(log.contains("Stopped by SIGINT after 1 iterations."));
+        assert!(!log.contains("Iteration failed"));
+    }
+
+    #[test]
+    fn loop_does_not_start_after_shutdown_request() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("PRD.md");
+        fs::write(&path, "- [ ] Task\n").unwrap();
+        let backend = LoopBackend::fail();
+
+        shutdown::request_for_test(libc::SIGTERM);
+        let outcome = run_loop(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(3),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            None,
+            None,
+        );
+        shutdown::clear_for_test();
+
+        let outcome = outcome.unwrap();
+        assert_eq!(outcome.status, LoopStatus::Stopped);
+        assert_eq!(outcome.iterations, 0);
+        let log = fs::read_to_string(temp.path().join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("Stopped by SIGTERM after 0 iterations."));
+        assert!(!log.contains("=== Iteration"));
+    }
+
+    #[test]
+    fn loop_resumes_the_backend_session_when_continuous_session_is_set() {
+        struct SessionBackend {
+            inner: LoopBackend,
+            resumed: RefCell<Vec<Option<String>>>,
+        }
+
+        impl Backend for SessionBackend {
+            fn name(&self) -> &str {
+                self.inner.name()
+            }
+
+            fn check_installed(&self) -> bool {
+                true
+            }
+
+            fn run_iteration(
+                &self,
+                prompt: &str,
+                model: Option<&str>,
+                variant: Option<&str>,
+                output_file: &Path,
+                working_dir: &Path,
+            ) -> Result<(), BackendError> {
+                self.inner
+                    .run_iteration(prompt, model, variant, output_file, working_dir)
+            }
+
+            fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+                self.inner.parse_text(response_file)
+            }
+
+            fn parse_session_id(&self, _response_file: &Path) -> Option<String> {
+                Some("session-1".to_string())
+            }
+
+            fn resume_session(&self, id: Option<&str>) {
+                self.resumed.borrow_mut().push(id.map(str::to_string));
+            }
+
+            fn get_models(&self) -> Vec<String> {
+                Vec::new()
+            }
+        }
+
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("PRD.md"), "- [ ] Task\n").unwrap();
+        let config_path = temp.path().join("config.yaml");
+        fs::write(
+            &config_path,
+            "defaults:\n  stall_iterations: 3\n  continuous_session: true\n",
+        )
+        .unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env(
+            "GRALPH_GLOBAL_CONFIG",
+            temp.path().join("missing-global.yaml"),
+        );
+        let config = Config::load(None).unwrap();
+        remove_env("GRALPH_GLOBAL_CONFIG");
+        remove_env("GRALPH_DEFAULT_CONFIG");
+
+        let backend = SessionBackend {
+            inner: LoopBackend::success("Working\n"),
+            resumed: RefCell::new(Vec::new()),
+        };
+        let clock = UnpauseClock {
+            pause_file: temp.path().join("no-pause"),
+        };
+
+        let outcome = run_loop_with_clock(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(10),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            Some(&config),
+            None,
+            None,
+            LoopBudget::default(),
+            &clock,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.iterations, 3);
+        assert_eq!(
+            *backend.resumed.borrow(),
+            vec![Some("session-1".to_string())]
+        );
+        let log = fs::read_to_string(temp.path().join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("Continuous session"));
+        assert_eq!(
+            log.matches("Continuing backend session session-1").count(),
+            1
+        );
+    }
+
+    #[test]
+    fn loop_stops_as_stalled_when_remaining_count_does_not_drop() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("PRD.md");
+        fs::write(&path, "- [ ] Task\n").unwrap();
+        let config_path = temp.path().join("config.yaml");
+        fs::write(
+            &config_path,
+            "defaults:\n  stall_iterations: 2\nhooks:\n  post_fail: echo \"$GRALPH_ITERATION $GRALPH_REMAINING\" > failed.out\n",
+        )
+        .unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env(
+            "GRALPH_GLOBAL_CONFIG",
+            temp.path().join("missing-global.yaml"),
+        );
+        let config = Config::load(None).unwrap();
+        remove_env("GRALPH_GLOBAL_CONFIG");
+        remove_env("GRALPH_DEFAULT_CONFIG");
+
+        let backend = LoopBackend::success("Looked at the task\nStill blocked on tests\n");
+        let mut updates: Vec<(u32, LoopStatus, usize)> = Vec::new();
+        let mut callback = |_: Option<&str>, iteration, status, remaining| {
+            updates.push((iteration, status, remaining));
+        };
+        let clock = UnpauseClock {
+            pause_file: temp.path().join("no-pause"),
+        };
+
+        let outcome = run_loop_with_clock(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(10),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            Some(&config),
+            Some(&mut callback),
+            None,
+            LoopBudget::default(),
+            &clock,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::Stalled);
+        assert_eq!(outcome.iterations, 2);
+        assert_eq!(outcome.remaining_tasks, 1);
+        assert_eq!(updates.last(), Some(&(2, LoopStatus::Stalled, 1)));
+        let log = fs::read_to_string(temp.path().join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("Stall limit: 2 iterations"));
+        assert!(log.contains("remaining tasks have not decreased in 2 iterations"));
+        assert!(log.contains("Last backend output:"));
+        assert!(log.contains("  Still blocked on tests"));
+        let failed = fs::read_to_string(temp.path().join("failed.out")).unwrap();
+        assert_eq!(failed.trim(), "2 1");
+    }
+
+    #[test]
+    fn loop_stops_as_budget_exceeded_when_tokens_reach_cap() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("PRD.md"), "- [ ] Task\n").unwrap();
+
+        let backend = LoopBackend::success("Working on it\n").with_usage(Usage {
+            input_tokens: 500,
+            output_tokens: 100,
+            cost_usd: Some(0.25),
+        });
+        let mut updates: Vec<(u32, LoopStatus, usize)> = Vec::new();
+        let mut callback = |_: Option<&str>, iteration, status, remaining| {
+            updates.push((iteration, status, remaining));
+        };
+        let clock = UnpauseClock {
+            pause_file: temp.path().join("no-pause"),
+        };
+        let config = hooks_config(
+            temp.path(),
+            "hooks:\n  post_fail: echo \"$GRALPH_ITERATION $GRALPH_REMAINING\" > failed.out\n",
+        );
+        let budget = LoopBudget {
+            max_cost_usd: None,
+            max_tokens: Some(1000),
+            ..LoopBudget::default()
+        };
+
+        let outcome = run_loop_with_clock(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(10),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            Some(&config),
+            Some(&mut callback),
+            None,
+            budget,
+            &clock,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::BudgetExceeded);
+        assert_eq!(outcome.iterations, 2);
+        assert_eq!(outcome.usage.total_tokens(), 1200);
+        assert_eq!(outcome.usage.cost_usd, Some(0.5));
+        assert_eq!(updates.last(), Some(&(2, LoopStatus::BudgetExceeded, 1)));
+        let log = fs::read_to_string(temp.path().join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("Token cap: 1000"));
+        assert!(log.contains("Budget exceeded: used 1200 tokens of the 1000 token cap"));
+        let failed = fs::read_to_string(temp.path().join("failed.out")).unwrap();
+        assert_eq!(failed.trim(), "2 1");
+    }
+
+    /// Moves forward ten minutes every time the loop reads the time.
+    struct AdvancingClock {
+        now: Mutex<SystemTime>,
+    }
+
+    impl Clock for AdvancingClock {
+        fn now(&self) -> SystemTime {
+            let mut now = self.now.lock().unwrap();
+            *now += Duration::from_secs(600);
+            *now
+        }
+
+        fn sleep(&self, _duration: Duration) {}
+    }
+
+    #[test]
+    fn loop_stops_as_deadline_exceeded_after_time_limit() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("PRD.md"), "- [ ] Task\n").unwrap();
+
+        let backend = LoopBackend::success("Working on it\n");
+        let mut updates: Vec<(u32, LoopStatus, usize)> = Vec::new();
+        let mut callback = |_: Option<&str>, iteration, status, remaining| {
+            updates.push((iteration, status, remaining));
+        };
+        let clock = AdvancingClock {
+            now: Mutex::new(SystemTime::now()),
+        };
+        let config = hooks_config(
+            temp.path(),
+            "hooks:\n  post_fail: echo \"$GRALPH_ITERATION $GRALPH_REMAINING\" > failed.out\n",
+        );
+        let budget = LoopBudget {
+            max_duration: Some(Duration::from_secs(60)),
+            ..LoopBudget::default()
+        };
+
+        let outcome = run_loop_with_clock(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(10),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            Some(&config),
+            Some(&mut callback),
+            None,
+            budget,
+            &clock,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::DeadlineExceeded);
+        assert_eq!(outcome.iterations, 1);
+        assert_eq!(outcome.remaining_tasks, 1);
+        assert_eq!(updates.last(), Some(&(1, LoopStatus::DeadlineExceeded, 1)));
+        let log = fs::read_to_string(temp.path().join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("Time limit: 1m 0s (60s)"));
+        assert!(log.contains("Deadline exceeded: time limit of 1m 0s (60s) reached"));
+        let failed = fs::read_to_string(temp.path().join("failed.out")).unwrap();
+        assert_eq!(failed.trim(), "1 1");
+    }
+
+    #[test]
+    fn loop_writes_checkpoints_and_resumes_from_them() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("PRD.md"), "- [ ] Task\n").unwrap();
+        let gralph_dir = temp.path().join(".gralph");
+        let run = |backend: &LoopBackend,
+                   budget: LoopBudget,
+                   updates: &mut Vec<(u32, LoopStatus, usize)>| {
+            let mut callback = |_: Option<&str>, iteration, status, remaining| {
+                updates.push((iteration, status, remaining));
+            };
+            run_loop_with_clock(
+                backend,
+                temp.path(),
+                Some("PRD.md"),
+                Some(3),
+                Some("COMPLETE"),
+                None,
+                None,
+                Some("session"),
+                None,
+                None,
+                Some(&mut callback),
+                None,
+                budget,
+                &AdvancingClock {
+                    now: Mutex::new(SystemTime::now()),
+                },
+            )
+        };
+
+        let mut updates = Vec::new();
+        assert!(run(&LoopBackend::fail(), LoopBudget::default(), &mut updates).is_err());
+        let saved = checkpoint::load(&gralph_dir, "session").unwrap().unwrap();
+        assert_eq!(saved.iteration, 1);
+        assert_eq!(saved.task_file, "PRD.md");
+        assert!(!saved.finished);
+        assert_eq!(saved.prompt_hash.len(), 16);
+
+        checkpoint::save(
+            &gralph_dir,
+            &Checkpoint {
+                iteration: 3,
+                input_tokens: 900,
+                elapsed_secs: 7200,
+                ..saved
+            },
+        )
+        .unwrap();
+        let backend = LoopBackend::success("Working on it\n").with_usage(Usage {
+            input_tokens: 500,
+            output_tokens: 100,
+            cost_usd: Some(0.25),
+        });
+        let budget = LoopBudget {
+            max_tokens: Some(1000),
+            ..LoopBudget::default()
+        };
+        let mut updates = Vec::new();
+        let outcome = run(&backend, budget, &mut updates).unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::BudgetExceeded);
+        assert_eq!(outcome.iterations, 3);
+        assert_eq!(outcome.usage.total_tokens(), 1500);
+        assert!(outcome.duration_secs >= 7200);
+        assert_eq!(updates.first(), Some(&(3, LoopStatus::Running, 1)));
+        let log = fs::read_to_string(gralph_dir.join("session.log")).unwrap();
+        assert!(log.contains("Resuming from checkpoint at iteration 3/3"));
+        assert!(log.contains("Already spent: 2h 0m 0s (7200s), 900 tokens"));
+        assert!(log.contains("Budget exceeded: used 1500 tokens of the 1000 token cap"));
+    }
+
+    /// Leaves `broken.rs` in the project on every iteration.
+    struct BreakingBackend;
+
+    impl Backend for BreakingBackend {
+        fn name(&self) -> &str {
+            "test"
+        }
+
+        fn check_installed(&self) -> bool {
+            true
+        }
+
+        fn run_iteration(
+            &self,
+            _prompt: &str,
+            _model: Option<&str>,
+            _variant: Option<&str>,
+            output_file: &Path,
+            working_dir: &Path,
+        ) -> Result<(), BackendError> {
+            let io_error = |path: &Path| {
+                let path = path.to_path_buf();
+                move |source| BackendError::Io { path, source }
+            };
+            let broken = working_dir.join("broken.rs");
+            fs::write(&broken, "fn main() {").map_err(io_error(&broken))?;
+            fs::write(output_file, "Done\n").map_err(io_error(output_file))
+        }
+
+        fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+            fs::read_to_string(response_file).map_err(|source| BackendError::Io {
+                path: response_file.to_path_buf(),
+                source,
+            })
+        }
+
+        fn get_models(&self) -> Vec<String> {
+            Vec::new()
+        }
+    }
+
+    #[test]
+    fn loop_rolls_back_iterations_that_fail_the_check() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let project = temp.path().join("project");
+        fs::create_dir_all(&project).unwrap();
+        fs::write(project.join("PRD.md"), "- [ ] Task\n").unwrap();
+        for args in [
+            vec!["init", "-q"],
+            vec!["add", "PRD.md"],
+            vec![
+                "-c",
+                "user.email=gralph@example.com",
+                "-c",
+                "user.name=gralph",
+                "-c",
+                "commit.gpgsign=false",
+                "commit",
+                "-q",
+                "-m",
+                "init",
+            ],
+        ] {
+            let status = std::process::Command::new("git")
+                .args(&args)
+                .current_dir(&project)
+                .status()
+                .unwrap();
+            assert!(status.success());
+        }
+        let head = gitops::head_commit(&project);
+
+        let config_path = temp.path().join("config.yaml");
+        fs::write(
+            &config_path,
+            "git:\n  rollback_failed: true\nhooks:\n  post_iteration: test ! -e broken.rs\n",
+        )
+        .unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env(
+            "GRALPH_GLOBAL_CONFIG",
+            temp.path().join("missing-global.yaml"),
+        );
+        let config = Config::load(None).unwrap();
+        remove_env("GRALPH_GLOBAL_CONFIG");
+        remove_env("GRALPH_DEFAULT_CONFIG");
+
+        let outcome = run_loop_with_clock(
+            &BreakingBackend,
+            &project,
+            Some("PRD.md"),
+            Some(2),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            Some(&config),
+            None,
+            None,
+            LoopBudget::default(),
+            &AdvancingClock {
+                now: Mutex::new(SystemTime::now()),
+            },
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::MaxIterations);
+        assert!(!project.join("broken.rs").exists());
+        assert_eq!(gitops::head_commit(&project), head);
+        let log = fs::read_to_string(project.join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("Iteration 1 failed its check: hooks.post_iteration failed"));
+        assert!(log.contains("Rolled back iteration 2 to"));
+    }
+
+    /// Edits its task's Context Bundle file plus a CI config and a new file
+    /// outside it.
+    struct ScopeCreepBackend;
+
+    impl Backend for ScopeCreepBackend {
+        fn name(&self) -> &str {
+            "test"
+        }
+
+        fn check_installed(&self) -> bool {
+            true
+        }
+
+        fn run_iteration(
+            &self,
+            _prompt: &str,
+            _model: Option<&str>,
+            _variant: Option<&str>,
+            output_file: &Path,
+            working_dir: &Path,
+        ) -> Result<(), BackendError> {
+            let io_error = |path: &Path| {
+                let path = path.to_path_buf();
+                move |source| BackendError::Io { path, source }
+            };
+            for (name, contents) in [
+                ("lib.rs", "pub fn work() {}\n"),
+                ("ci.yml", "jobs: {}\n"),
+                ("notes.txt", "scratch\n"),
+            ] {
+                let path = working_dir.join(name);
+                fs::write(&path, contents).map_err(io_error(&path))?;
+            }
+            fs::write(output_file, "Done\n").map_err(io_error(output_file))
+        }
+
+        fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+            fs::read_to_string(response_file).map_err(|source| BackendError::Io {
+                path: response_file.to_path_buf(),
+                source,
+            })
+        }
+
+        fn get_models(&self) -> Vec<String> {
+            Vec::new()
+        }
+    }
+
+    /// A repository in `temp/project` with `PRD.md` naming `lib.rs` in its
+    /// Context Bundle, plus `lib.rs` and `ci.yml`, all committed.
+    fn scoped_project(temp: &Path) -> PathBuf {
+        let project = temp.join("project");
+        fs::create_dir_all(&project).unwrap();
+        fs::write(
+            project.join("PRD.md"),
+            "### Task A-1\n- **ID** A-1\n- **Context Bundle** `lib.rs`\n- [ ] A-1 Work\n",
+        )
+        .unwrap();
+        fs::write(project.join("lib.rs"), "").unwrap();
+        fs::write(project.join("ci.yml"), "jobs: {test: {}}\n").unwrap();
+        for args in [
+            vec!["init", "-q"],
+            vec!["add", "-A"],
+            vec![
+                "-c",
+                "user.email=gralph@example.com",
+                "-c",
+                "user.name=gralph",
+                "-c",
+                "commit.gpgsign=false",
+                "commit",
+                "-q",
+                "-m",
+                "init",
+            ],
+        ] {
+            let status = std::process::Command::new("git")
+                .args(&args)
+                .current_dir(&project)
+                .status()
+                .unwrap();
+            assert!(status.success());
+        }
+        project
+    }
+
+    fn run_scope_creep_loop(project: &Path, config: &Config) -> LoopOutcome {
+        run_loop_with_clock(
+            &ScopeCreepBackend,
+            project,
+            Some("PRD.md"),
+            Some(1),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            Some(config),
+            None,
+            None,
+            LoopBudget::default(),
+            &AdvancingClock {
+                now: Mutex::new(SystemTime::now()),
+            },
+        )
+        .unwrap()
+    }
+
+    #[test]
+    fn loop_reverts_changes_outside_the_allowed_paths() {
+        let temp = tempfile::tempdir().unwrap();
+        let project = scoped_project(temp.path());
+        let config = Config::from_yaml("guard:\n  mode: revert\n  fail_iteration: true\n").unwrap();
+
+        let outcome = run_scope_creep_loop(&project, &config);
+
+        assert_eq!(outcome.status, LoopStatus::MaxIterations);
+        assert_eq!(
+            fs::read_to_string(project.join("lib.rs")).unwrap(),
+            "pub fn work() {}\n"
+        );
+        assert_eq!(
+            fs::read_to_string(project.join("ci.yml")).unwrap(),
+            "jobs: {test: {}}\n"
+        );
+        assert!(!project.join("notes.txt").exists());
+        let log = fs::read_to_string(project.join(".gralph").join("session.log")).unwrap();
+        assert!(
+            log.contains("Iteration 1 changed files outside the allowed paths: ci.yml, notes.txt")
+        );
+        assert!(log.contains("Reverted ci.yml, notes.txt"));
+    }
+
+    #[test]
+    fn loop_rejects_changes_to_protected_paths_without_an_allow_list() {
+        let temp = tempfile::tempdir().unwrap();
+        let project = scoped_project(temp.path());
+        let config = Config::from_yaml("guard:\n  protected_paths: [\"*.yml\"]\n").unwrap();
+
+        run_scope_creep_loop(&project, &config);
+
+        assert_eq!(
+            fs::read_to_string(project.join("ci.yml")).unwrap(),
+            "jobs: {test: {}}\n"
+        );
+        assert!(project.join("notes.txt").exists());
+        let log = fs::read_to_string(project.join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("Protected paths: *.yml"));
+        assert!(log.contains("Iteration 1 changed protected files: ci.yml"));
+        assert!(!log.contains("outside the allowed paths"));
+    }
+
+    #[test]
+    fn loop_rejects_changes_to_protected_paths_that_were_already_dirty() {
+        let temp = tempfile::tempdir().unwrap();
+        let project = scoped_project(temp.path());
+        fs::write(project.join("ci.yml"), "jobs: {local: {}}\n").unwrap();
+        let config = Config::from_yaml("guard:\n  protected_paths: [\"*.yml\"]\n").unwrap();
+
+        run_scope_creep_loop(&project, &config);
+
+        assert_eq!(
+            fs::read_to_string(project.join("ci.yml")).unwrap(),
+            "jobs: {local: {}}\n"
+        );
+        let log = fs::read_to_string(project.join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("Iteration 1 changed protected files: ci.yml"));
+    }
+
+    /// Fails with a 429 on stderr until `failures` runs out, then succeeds.
+    struct RateLimitedBackend {
+        failures: Mutex<u32>,
+    }
+
+    impl Backend for RateLimitedBackend {
+        fn name(&self) -> &str {
+            "test"
+        }
+
+        fn check_installed(&self) -> bool {
+            true
+        }
+
+        fn run_iteration(
+            &self,
+            _prompt: &str,
+            _model: Option<&str>,
+            _variant: Option<&str>,
+            output_file: &Path,
+            _working_dir: &Path,
+        ) -> Result<(), BackendError> {
+            let mut failures = self.failures.lock().unwrap();
+            if *failures > 0 {
+                *failures -= 1;
+                fs::write(output_file, "Error: 429 Too Many Requests\n").unwrap();
+                return Err(BackendError::Command(
+                    "test exited with exit status: 1".to_string(),
+                ));
+            }
+            fs::write(output_file, "Working on it\n").unwrap();
+            Ok(())
+        }
+
+        fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+            Ok(fs::read_to_string(response_file).unwrap())
+        }
+
+        fn get_models(&self) -> Vec<String> {
+            Vec::new()
+        }
+    }
+
+    #[derive(Default)]
+    struct RecordingClock {
+        slept: Mutex<Duration>,
+    }
+
+    impl Clock for RecordingClock {
+        fn now(&self) -> SystemTime {
+            SystemTime::now()
+        }
+
+        fn sleep(&self, duration: Duration) {
+            *self.slept.lock().unwrap() += duration;
+        }
+    }
+
+    #[test]
+    fn rate_limit_reason_finds_429_and_rate_limit_text() {
+        assert_eq!(
+            rate_limit_reason(
+                "backend command error: claude exited with exit status: 1",
+                "{\"type\":\"system\"}\nAPI Error: Rate limit reached for requests\n"
+            )
+            .as_deref(),
+            Some("API Error: Rate limit reached for requests")
+        );
+        assert_eq!(
+            rate_limit_reason("openai generate returned 429 Too Many Requests: {}", "").as_deref(),
+            Some("openai generate returned 429 Too Many Requests: {}")
+        );
+        assert_eq!(
+            rate_limit_reason("codex exited with exit status: 1", "wrote 4290 lines\n"),
+            None
+        );
+    }
+
+    #[test]
+    fn loop_backs_off_and_retries_rate_limited_iteration() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("PRD.md"), "- [ ] Task\n").unwrap();
+        let config_path = temp.path().join("config.yaml");
+        fs::write(
+            &config_path,
+            "backends:\n  test:\n    rate_limit_backoff: 10\n    rate_limit_retries: 2\n",
+        )
+        .unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env(
+            "GRALPH_GLOBAL_CONFIG",
+            temp.path().join("missing-global.yaml"),
+        );
+        let config = Config::load(None).unwrap();
+        remove_env("GRALPH_GLOBAL_CONFIG");
+        remove_env("GRALPH_DEFAULT_CONFIG");
+
+        let backend = RateLimitedBackend {
+            failures: Mutex::new(2),
+        };
+        let clock = RecordingClock::default();
+        let outcome = run_loop_with_clock(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(1),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            Some(&config),
+            None,
+            None,
+            LoopBudget::default(),
+            &clock,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::MaxIterations);
+        assert_eq!(outcome.iterations, 1);
+        assert_eq!(*clock.slept.lock().unwrap(), Duration::from_secs(30));
+        let log = fs::read_to_string(temp.path().join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains(
+            "Rate limited: Error: 429 Too Many Requests; retrying iteration 1 in 10s (retry 1/2)"
+        ));
+        assert!(log.contains("retrying iteration 1 in 20s (retry 2/2)"));
+
+        let backend = RateLimitedBackend {
+            failures: Mutex::new(3),
+        };
+        let err = run_loop_with_clock(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(1),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            Some(&config),
+            None,
+            None,
+            LoopBudget::default(),
+            &RecordingClock::default(),
+        )
+        .unwrap_err();
+        assert!(matches!(err, CoreError::RateLimited(_)));
+    }
+
+    #[test]
+    fn pacing_reads_backend_settings_and_caps_backoff() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let config_path = temp.path().join("config.yaml");
+        fs::write(
+            &config_path,
+            "backends:\n  claude:\n    iteration_delay: 30\n  codex:\n    iteration_delay: soon\n",
+        )
+        .unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env(
+            "GRALPH_GLOBAL_CONFIG",
+            temp.path().join("missing-global.yaml"),
+        );
+        let config = Config::load(None).unwrap();
+        remove_env("GRALPH_GLOBAL_CONFIG");
+        remove_env("GRALPH_DEFAULT_CONFIG");
+
+        let pacing = Pacing::from_config(Some(&config), "claude").unwrap();
+        assert_eq!(pacing.iteration_delay, Duration::from_secs(30));
+        assert_eq!(pacing.rate_limit_retries, 5);
+        assert_eq!(pacing.backoff(1), Duration::from_secs(60));
+        assert_eq!(pacing.backoff(3), Duration::from_secs(240));
+        assert_eq!(pacing.backoff(40), MAX_RATE_LIMIT_BACKOFF);
+        assert!(Pacing::from_config(Some(&config), "codex").is_err());
+    }
+
+    #[test]
+    fn loop_budget_reads_caps_from_config() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let config_path = temp.path().join("config.yaml");
+        fs::write(
+            &config_path,
+            "defaults:\n  max_cost_usd: \"$2.50\"\n  max_tokens: 0\n",
+        )
+        .unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env(
+            "GRALPH_GLOBAL_CONFIG",
+            temp.path().join("missing-global.yaml"),
+        );
+        let config = Config::load(None).unwrap();
+        remove_env("GRALPH_GLOBAL_CONFIG");
+        remove_env("GRALPH_DEFAULT_CONFIG");
+
+        let budget = LoopBudget::from_config(Some(&config)).unwrap();
+        assert_eq!(budget.max_cost_usd, Some(2.5));
+        assert_eq!(budget.max_tokens, None);
+        let spent = Usage {
+            input_tokens: 10,
+            output_tokens: 10,
+            cost_usd: Some(3.0),
+        };
+        assert_eq!(
+            budget.exceeded_by(&spent).as_deref(),
+            Some("spent $3.00 of the $2.50 cap")
+        );
+        assert_eq!(budget.exceeded_by(&Usage::default()), None);
+    }
+
+    #[test]
+    fn stall_iterations_rejects_non_numeric_values() {
+        let _guard = env_guard();
+        let temp = tempfile::tempdir().unwrap();
+        let config_path = temp.path().join("config.yaml");
+        fs::write(&config_path, "defaults:\n  stall_iterations: often\n").unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &config_path);
+        set_env(
+            "GRALPH_GLOBAL_CONFIG",
+            temp.path().join("missing-global.yaml"),
+        );
+        let config = Config::load(None).unwrap();
+        remove_env("GRALPH_GLOBAL_CONFIG");
+        remove_env("GRALPH_DEFAULT_CONFIG");
+
+        let err = resolve_stall_iterations(Some(&config)).unwrap_err();
+
+        assert!(err.to_string().contains("defaults.stall_iterations"));
+        assert_eq!(resolve_stall_iterations(None).unwrap(), 0);
+    }
+
+    #[test]
+    fn loop_feeds_previous_failure_into_next_prompt() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("PRD.md");
+        fs::write(&path, "- [ ] Task\n").unwrap();
+        let backend = TestBackend::new();
+        let clock = UnpauseClock {
+            pause_file: temp.path().join("no-pause"),
+        };
+
+        let outcome = run_loop_with_clock(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(2),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            None,
+            None,
+            None,
+            LoopBudget::default(),
+            &clock,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::MaxIterations);
+        let prompt = backend.prompt.borrow().clone().unwrap();
+        assert!(prompt.contains("Iteration: 2/2"));
+        assert!(prompt.contains(
+            "Previous Iteration Failed (fix this before moving on):\nIteration 1 did not check off any task in PRD.md (1 remaining). Your output ended with:\nok\n\nTask Block:"
+        ));
+    }
+
+    /// Replies with queued responses in order and records every prompt. When
+    /// `completes` is set, each run also checks off every task in that file.
+    struct ScriptedBackend {
+        responses: RefCell<Vec<String>>,
+        prompts: RefCell<Vec<String>>,
+        completes: Option<PathBuf>,
+    }
+
+    impl ScriptedBackend {
+        fn new(responses: &[&str], completes: Option<&Path>) -> Self {
+            Self {
+                responses: RefCell::new(responses.iter().rev().map(|r| r.to_string()).collect()),
+                prompts: RefCell::new(Vec::new()),
+                completes: completes.map(Path::to_path_buf),
+            }
+        }
+    }
+
+    impl Backend for ScriptedBackend {
+        fn name(&self) -> &str {
+            "scripted"
+        }
+
+        fn check_installed(&self) -> bool {
+            true
+        }
+
+        fn run_iteration(
+            &self,
+            prompt: &str,
+            _model: Option<&str>,
+            _variant: Option<&str>,
+            output_file: &Path,
+            _working_dir: &Path,
+        ) -> Result<(), BackendError> {
+            self.prompts.borrow_mut().push(prompt.to_string());
+            if let Some(task_file) = &self.completes {
+                let contents = fs::read_to_string(task_file).unwrap();
+                fs::write(task_file, contents.replace("- [ ]", "- [x]")).unwrap();
+            }
+            let response = self.responses.borrow_mut().pop().unwrap_or_default();
+            fs::write(output_file, response).map_err(|source| BackendError::Io {
+                path: output_file.to_path_buf(),
+                source,
+            })
+        }
+
+        fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+            fs::read_to_string(response_file).map_err(|source| BackendError::Io {
+                path: response_file.to_path_buf(),
+                source,
+            })
+        }
+
+        fn get_models(&self) -> Vec<String> {
+            Vec::new()
+        }
+    }
+
+    #[test]
+    fn loop_continues_when_reviewer_rejects_completion() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("PRD.md");
+        fs::write(
+            &path,
+            "### Task R-1\n- **ID** R-1\n- **DoD** Tests cover the parser.\n- **Checklist**\n  * Parser tests added.\n- [ ] R-1 Add parser tests\n",
+        )
+        .unwrap();
+        let promise = "Done.\n<promise>COMPLETE</promise>";
+        let backend = ScriptedBackend::new(&[promise, promise], Some(&path));
+        let reviewer_backend = ScriptedBackend::new(
+            &[
+                "No parser tests were added.\n<review>REJECTED</review>",
+                "All criteria met.\n<review>APPROVED</review>",
+            ],
+            None,
+        );
+        let reviewer = CompletionReviewer {
+            backend: &reviewer_backend,
+            model: None,
+        };
+        let clock = UnpauseClock {
+            pause_file: temp.path().join("no-pause"),
+        };
+
+        let outcome = run_loop_with_clock(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(5),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            None,
+            None,
+            Some(&reviewer),
+            LoopBudget::default(),
+            &clock,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::Complete);
+        assert_eq!(outcome.iterations, 2);
+        let review_prompts = reviewer_backend.prompts.borrow();
+        assert_eq!(review_prompts.len(), 2);
+        assert!(review_prompts[0].contains("- **DoD** Tests cover the parser."));
+        assert!(review_prompts[0].contains("(git diff unavailable)"));
+        let prompts = backend.prompts.borrow();
+        assert!(prompts[1].contains("rejected the completion:\nNo parser tests were added."));
+        let log = fs::read_to_string(temp.path().join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("Completion claimed; asking scripted to review"));
+        assert!(log.contains("Reviewer rejected the completion"));
+        assert!(log.contains("Reviewer approved the completion"));
+    }
+
+    #[test]
+    fn loop_reopens_task_checked_with_open_checklist_items() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("PRD.md");
+        fs::write(
+            &path,
+            "### Task C-1\n- **ID** C-1\n- **Checklist**\n  * [x] Parser added.\n  * [ ] Parser tests added.\n- [ ] C-1 Add the parser\n",
+        )
+        .unwrap();
+        let backend = ScriptedBackend::new(&["Done.", "Done."], Some(&path));
+        let clock = UnpauseClock {
+            pause_file: temp.path().join("no-pause"),
+        };
+
+        let outcome = run_loop_with_clock(
+            &backend,
+            temp.path(),
+            Some("PRD.md"),
+            Some(2),
+            Some("COMPLETE"),
+            None,
+            None,
+            Some("session"),
+            None,
+            None,
+            None,
+            None,
+            LoopBudget::default(),
+            &clock,
+        )
+        .unwrap();
+
+        assert_eq!(outcome.status, LoopStatus::MaxIterations);
+        assert_eq!(outcome.remaining_tasks, 1);
+        assert!(
+            fs::read_to_string(&path)
+                .unwrap()
+                .contains("- [ ] C-1 Add the parser")
+        );
+        let prompts = backend.prompts.borrow();
+        assert!(prompts[1].contains(
+            "Task C-1 was unchecked again because these checklist items are still open: Parser tests added."
+        ));
+        let log = fs::read_to_string(temp.path().join(".gralph").join("session.log")).unwrap();
+        assert!(log.contains("C-1 was checked off with 1 open checklist item(s); reopened it"));
+        assert!(!log.contains("Tasks closed"));
+    }
+
+    #[test]
+    fn review_requires_explicit_approval_line() {
+        assert!(is_review_approved(
+            "Looks good.\n<review>APPROVED</review>\n"
+        ));
+        assert!(!is_review_approved(
+            "<review>APPROVED</review>\nActually, no."
+        ));
+        assert!(!is_review_approved("<review>REJECTED</review>"));
+        assert!(!is_review_approved(""));
+    }
+
     #[test]
     fn loop_updates_remaining_counts_after_iteration() {
         let temp = tempfile::tempdir().unwrap();
@@ -2676,6 +7088,7 @@ mod tests {
                 2,
                 task_block.as_deref(),
                 context_files.as_deref(),
+                None,
             );
 
             match context_files.as_deref() {
@@ -2718,6 +7131,7 @@ mod tests {
                 max_iterations,
                 Some(&task_block),
                 context_files.as_deref(),
+                None,
             );
 
             let expected_file = format!("File:{}", task_file);
@@ -1,5 +1,7 @@
-use crate::app::{Deps, exit_code_for, run};
+use crate::app::{CliError, Deps, configured_state_driver, exit_code_for, run};
+use crate::backend::cassette::Cassette;
 use crate::cli;
+use crate::offline;
 use clap::Parser;
 use std::process::ExitCode;
 
@@ -13,6 +15,17 @@ where
     T: Into<std::ffi::OsString> + Clone,
 {
     let cli = cli::Cli::parse_from(args);
-    let deps = Deps::real();
+    let driver = match configured_state_driver() {
+        Ok(driver) => driver,
+        Err(err) => return exit_code_for(Err(err)),
+    };
+    let cassette = match Cassette::from_flags(cli.record.as_deref(), cli.replay.as_deref()) {
+        Ok(cassette) => cassette,
+        Err(err) => return exit_code_for(Err(CliError::Message(err))),
+    };
+    let deps = Deps::real()
+        .with_state_driver(driver)
+        .with_offline(cli.offline || offline::from_env())
+        .with_cassette(cassette);
     exit_code_for(run(cli, &deps))
 }
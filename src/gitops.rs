@@ -0,0 +1,1102 @@
+use crate::config::Config;
+use reqwest::blocking::Client;
+use serde_json::Value;
+use std::env;
+use std::error::Error;
+use std::ffi::OsStr;
+use std::fmt;
+use std::fs;
+use std::io;
+use std::path::{Component, Path, PathBuf};
+use std::process::Command;
+use std::time::Duration;
+
+/// Directories gralph writes into the project; never committed or counted
+/// as dirty.
+const IGNORED_DIRS: [&str; 2] = [".gralph", ".worktrees"];
+const DEFAULT_REMOTE: &str = "origin";
+const DEFAULT_PR_BASE: &str = "main";
+const API_TIMEOUT: Duration = Duration::from_secs(30);
+
+#[derive(Debug)]
+pub enum GitError {
+    Io(io::Error),
+    Command { args: String, message: String },
+    Dirty { paths: Vec<String> },
+    Api(String),
+}
+
+impl fmt::Display for GitError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            GitError::Io(source) => write!(f, "failed to run git: {}", source),
+            GitError::Command { args, message } => {
+                write!(f, "git {} failed: {}", args, message)
+            }
+            GitError::Dirty { paths } => write!(
+                f,
+                "git working tree has uncommitted changes: {}",
+                paths.join(", ")
+            ),
+            GitError::Api(message) => write!(f, "pull request failed: {}", message),
+        }
+    }
+}
+
+impl Error for GitError {
+    fn source(&self) -> Option<&(dyn Error + 'static)> {
+        match self {
+            GitError::Io(source) => Some(source),
+            GitError::Command { .. } | GitError::Dirty { .. } | GitError::Api(_) => None,
+        }
+    }
+}
+
+/// Loop git behavior from the `git` config section.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct GitSettings {
+    /// Check out `gralph/<session>` before the first iteration.
+    pub branch_per_session: bool,
+    /// Commit changes the backend left uncommitted after each iteration.
+    pub auto_commit: bool,
+    /// Refuse to start, or to continue after an iteration, with a dirty tree.
+    pub strict: bool,
+    /// Reset to the commit an iteration started from when `hooks.post_iteration`
+    /// fails after it.
+    pub rollback_failed: bool,
+}
+
+impl GitSettings {
+    pub fn from_config(config: &Config) -> Self {
+        let flag = |key: &str| {
+            config.get(key).is_some_and(|value| {
+                matches!(
+                    value.trim().to_ascii_lowercase().as_str(),
+                    "true" | "1" | "yes" | "y" | "on"
+                )
+            })
+        };
+        Self {
+            branch_per_session: flag("git.branch_per_session"),
+            auto_commit: flag("git.auto_commit"),
+            strict: flag("git.strict"),
+            rollback_failed: flag("git.rollback_failed"),
+        }
+    }
+
+    pub fn enabled(&self) -> bool {
+        self.branch_per_session || self.auto_commit || self.strict || self.rollback_failed
+    }
+}
+
+/// Pull request settings from the `git` config section.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PullRequestSettings {
+    pub enabled: bool,
+    pub remote: String,
+    pub base: Option<String>,
+    /// Environment variable holding the API token; defaults per forge.
+    pub token_env: Option<String>,
+    /// API root override, e.g. for self-hosted forges on non-standard paths.
+    pub api_url: Option<String>,
+}
+
+impl PullRequestSettings {
+    pub fn from_config(config: &Config) -> Self {
+        let value = |key: &str| {
+            config
+                .get(key)
+                .map(|value| value.trim().to_string())
+                .filter(|value| !value.is_empty())
+        };
+        Self {
+            enabled: value("git.pull_request").is_some_and(|value| {
+                matches!(
+                    value.to_ascii_lowercase().as_str(),
+                    "true" | "1" | "yes" | "y" | "on"
+                )
+            }),
+            remote: value("git.remote").unwrap_or_else(|| DEFAULT_REMOTE.to_string()),
+            base: value("git.pr_base"),
+            token_env: value("git.token_env"),
+            api_url: value("git.api_url"),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Forge {
+    GitHub,
+    GitLab,
+}
+
+/// A hosted repository parsed from a remote URL.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RemoteRepo {
+    pub forge: Forge,
+    pub host: String,
+    /// `owner/name` (GitLab paths may include subgroups).
+    pub path: String,
+}
+
+impl RemoteRepo {
+    pub fn default_api_url(&self) -> String {
+        match (self.forge, self.host.as_str()) {
+            (Forge::GitHub, "github.com") => "https://api.github.com".to_string(),
+            (Forge::GitHub, host) => format!("https://{}/api/v3", host),
+            (Forge::GitLab, host) => format!("https://{}/api/v4", host),
+        }
+    }
+
+    fn token_env_names(&self) -> &'static [&'static str] {
+        match self.forge {
+            Forge::GitHub => &["GITHUB_TOKEN", "GH_TOKEN"],
+            Forge::GitLab => &["GITLAB_TOKEN"],
+        }
+    }
+}
+
+/// Parses `https://host/owner/repo(.git)`, `ssh://git@host/owner/repo.git`,
+/// and `git@host:owner/repo.git` remotes on GitHub or GitLab hosts.
+pub fn parse_remote_url(url: &str) -> Option<RemoteRepo> {
+    let url = url.trim();
+    let (host, path) = match url.split_once("://") {
+        Some((_, rest)) => rest.split_once('/')?,
+        None => url.split_once(':')?,
+    };
+    let host = host
+        .rsplit('@')
+        .next()?
+        .split(':')
+        .next()?
+        .to_ascii_lowercase();
+    let path = path.trim_matches('/').trim_end_matches(".git").to_string();
+    if host.is_empty() || !path.contains('/') {
+        return None;
+    }
+    let forge = if host.contains("gitlab") {
+        Forge::GitLab
+    } else if host.contains("github") {
+        Forge::GitHub
+    } else {
+        return None;
+    };
+    Some(RemoteRepo { forge, host, path })
+}
+
+/// A pull (or merge) request to open for the current branch.
+#[derive(Debug, Clone)]
+pub struct PullRequest<'a> {
+    pub title: &'a str,
+    pub body: &'a str,
+    pub head: &'a str,
+    pub base: &'a str,
+}
+
+/// Opens a pull request through the forge API and returns its web URL.
+pub fn open_pull_request(
+    repo: &RemoteRepo,
+    api_url: &str,
+    token: &str,
+    request: &PullRequest<'_>,
+) -> Result<String, GitError> {
+    let api_url = api_url.trim_end_matches('/');
+    let client = Client::builder()
+        .timeout(API_TIMEOUT)
+        .build()
+        .map_err(|err| GitError::Api(err.to_string()))?;
+    let (builder, url_field) = match repo.forge {
+        Forge::GitHub => (
+            client
+                .post(format!("{}/repos/{}/pulls", api_url, repo.path))
+                .header("Authorization", format!("Bearer {}", token))
+                .header("Accept", "application/vnd.github+json")
+                .body(
+                    serde_json::json!({
+                        "title": request.title,
+                        "body": request.body,
+                        "head": request.head,
+                        "base": request.base,
+                    })
+                    .to_string(),
+                ),
+            "html_url",
+        ),
+        Forge::GitLab => (
+            client
+                .post(format!(
+                    "{}/projects/{}/merge_requests",
+                    api_url,
+                    repo.path.replace('/', "%2F")
+                ))
+                .header("PRIVATE-TOKEN", token)
+                .body(
+                    serde_json::json!({
+                        "title": request.title,
+                        "description": request.body,
+                        "source_branch": request.head,
+                        "target_branch": request.base,
+                    })
+                    .to_string(),
+                ),
+            "web_url",
+        ),
+    };
+    let response = builder
+        .header("Content-Type", "application/json")
+        .header("User-Agent", "gralph")
+        .send()
+        .map_err(|err| GitError::Api(err.to_string()))?;
+    let status = response.status();
+    let body = response
+        .text()
+        .map_err(|err| GitError::Api(err.to_string()))?;
+    if !status.is_success() {
+        return Err(GitError::Api(format!(
+            "{} returned {}: {}",
+            api_url,
+            status,
+            body.trim()
+        )));
+    }
+    let value: Value = serde_json::from_str(&body).map_err(|err| GitError::Api(err.to_string()))?;
+    value
+        .get(url_field)
+        .and_then(Value::as_str)
+        .map(str::to_string)
+        .ok_or_else(|| GitError::Api(format!("response is missing {}", url_field)))
+}
+
+/// Pushes the current branch to the configured remote and opens a pull
+/// request against the base branch. Returns the pull request URL.
+pub fn publish_branch(
+    dir: &Path,
+    settings: &PullRequestSettings,
+    title: &str,
+    body: &str,
+) -> Result<String, GitError> {
+    let branch = current_branch(dir)?;
+    if branch == "HEAD" {
+        return Err(GitError::Api(
+            "cannot open a pull request from a detached HEAD".to_string(),
+        ));
+    }
+    let base = settings
+        .base
+        .clone()
+        .or_else(|| default_base_branch(dir, &settings.remote))
+        .unwrap_or_else(|| DEFAULT_PR_BASE.to_string());
+    if branch == base {
+        return Err(GitError::Api(format!(
+            "branch {} is the pull request base; enable git.branch_per_session or use a worktree",
+            branch
+        )));
+    }
+
+    let remote_url = git_output(dir, ["remote", "get-url", settings.remote.as_str()])?;
+    let repo = parse_remote_url(&remote_url).ok_or_else(|| {
+        GitError::Api(format!(
+            "unsupported remote {}: {}",
+            settings.remote,
+            remote_url.trim()
+        ))
+    })?;
+    let token_names: Vec<&str> = match settings.token_env.as_deref() {
+        Some(name) => vec![name],
+        None => repo.token_env_names().to_vec(),
+    };
+    let token = token_names
+        .iter()
+        .find_map(|name| env::var(name).ok().filter(|value| !value.trim().is_empty()))
+        .ok_or_else(|| {
+            GitError::Api(format!(
+                "set {} to open pull requests",
+                token_names.join(" or ")
+            ))
+        })?;
+
+    git_output(
+        dir,
+        ["push", "-u", settings.remote.as_str(), branch.as_str()],
+    )?;
+    let api_url = settings
+        .api_url
+        .clone()
+        .unwrap_or_else(|| repo.default_api_url());
+    open_pull_request(
+        &repo,
+        &api_url,
+        token.trim(),
+        &PullRequest {
+            title,
+            body,
+            head: &branch,
+            base: &base,
+        },
+    )
+}
+
+fn default_base_branch(dir: &Path, remote: &str) -> Option<String> {
+    let reference = format!("refs/remotes/{}/HEAD", remote);
+    let output = git_output(dir, ["symbolic-ref", "--short", reference.as_str()]).ok()?;
+    output
+        .trim()
+        .strip_prefix(&format!("{}/", remote))
+        .filter(|branch| !branch.is_empty())
+        .map(str::to_string)
+}
+
+/// What happened to the working tree after an iteration.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum IterationCommit {
+    /// Nothing changed.
+    Clean,
+    /// The backend committed its own changes.
+    Committed { head: String },
+    /// Leftover changes were committed by gralph.
+    AutoCommitted { head: String, message: String },
+    /// Changes remain uncommitted (auto-commit disabled).
+    Uncommitted { paths: Vec<String> },
+}
+
+pub fn session_branch_name(session: &str) -> String {
+    format!("gralph/{}", session.trim())
+}
+
+/// Returns the repository root containing `dir`, or `None` outside a repo.
+pub fn repo_root(dir: &Path) -> Option<PathBuf> {
+    git_output(dir, ["rev-parse", "--show-toplevel"])
+        .ok()
+        .map(|root| PathBuf::from(root.trim()))
+}
+
+/// The hooks directory of the repository containing `dir`, honouring
+/// `core.hooksPath`, or `None` outside a repo.
+pub fn hooks_dir(dir: &Path) -> Option<PathBuf> {
+    git_output(dir, ["rev-parse", "--git-path", "hooks"])
+        .ok()
+        .map(|path| dir.join(path.trim()))
+}
+
+/// The GitHub or GitLab repository behind `remote`, if it is one.
+pub fn remote_repo(dir: &Path, remote: &str) -> Option<RemoteRepo> {
+    git_output(dir, ["remote", "get-url", remote])
+        .ok()
+        .and_then(|url| parse_remote_url(&url))
+}
+
+/// Commit hash of `HEAD`, or `None` before the first commit.
+pub fn head_commit(dir: &Path) -> Option<String> {
+    git_output(dir, ["rev-parse", "--verify", "-q", "HEAD"])
+        .ok()
+        .map(|head| head.trim().to_string())
+        .filter(|head| !head.is_empty())
+}
+
+pub fn current_branch(dir: &Path) -> Result<String, GitError> {
+    git_output(dir, ["rev-parse", "--abbrev-ref", "HEAD"]).map(|branch| branch.trim().to_string())
+}
+
+/// Paths with uncommitted changes, excluding gralph's own directories.
+pub fn dirty_paths(dir: &Path) -> Result<Vec<String>, GitError> {
+    let output = git_output(dir, ["status", "--porcelain", "--untracked-files=all"])?;
+    Ok(output
+        .lines()
+        .filter_map(|line| line.get(3..))
+        .map(|path| match path.split_once(" -> ") {
+            Some((_, renamed)) => renamed,
+            None => path,
+        })
+        .map(|path| path.trim_matches('"').to_string())
+        .filter(|path| !is_ignored_path(path))
+        .collect())
+}
+
+/// Path of `dir` within its repository, with a trailing slash, or empty at
+/// the root.
+pub fn path_prefix(dir: &Path) -> Result<String, GitError> {
+    git_output(dir, ["rev-parse", "--show-prefix"]).map(|prefix| prefix.trim().to_string())
+}
+
+/// Paths changed since `base`, committed or not, including untracked files.
+/// Paths are relative to the repository root; gralph's directories are left
+/// out.
+pub fn changed_paths(dir: &Path, base: &str) -> Result<Vec<String>, GitError> {
+    let changed = git_output(
+        dir,
+        [
+            "diff",
+            "--name-only",
+            "--no-renames",
+            "-z",
+            base,
+            "--",
+            ":/",
+        ],
+    )?;
+    let untracked = git_output(
+        dir,
+        [
+            "ls-files",
+            "--others",
+            "--exclude-standard",
+            "--full-name",
+            "-z",
+            "--",
+            ":/",
+        ],
+    )?;
+    let mut paths: Vec<String> = changed
+        .split('\0')
+        .chain(untracked.split('\0'))
+        .filter(|path| !path.is_empty() && !is_ignored_path(path))
+        .map(str::to_string)
+        .collect();
+    paths.sort();
+    paths.dedup();
+    Ok(paths)
+}
+
+/// Puts `paths` (relative to the repository root) back as they were at
+/// `base`: files it had are checked out from it, and files it did not have
+/// are removed.
+pub fn restore_paths(dir: &Path, base: &str, paths: &[String]) -> Result<(), GitError> {
+    let root = repo_root(dir).unwrap_or_else(|| dir.to_path_buf());
+    for path in paths {
+        let pathspec = format!(":(literal){}", path);
+        if git_output(&root, ["cat-file", "-e", &format!("{}:{}", base, path)]).is_ok() {
+            git_output(&root, ["checkout", "-q", base, "--", pathspec.as_str()])?;
+            continue;
+        }
+        git_output(
+            &root,
+            [
+                "rm",
+                "-q",
+                "--cached",
+                "--ignore-unmatch",
+                "--",
+                pathspec.as_str(),
+            ],
+        )?;
+        match fs::remove_file(root.join(path)) {
+            Ok(()) => {}
+            Err(err) if err.kind() == io::ErrorKind::NotFound => {}
+            Err(err) => return Err(GitError::Io(err)),
+        }
+    }
+    Ok(())
+}
+
+/// Fails with [`GitError::Dirty`] when the tree has uncommitted changes.
+pub fn ensure_clean(dir: &Path) -> Result<(), GitError> {
+    let paths = dirty_paths(dir)?;
+    if paths.is_empty() {
+        Ok(())
+    } else {
+        Err(GitError::Dirty { paths })
+    }
+}
+
+/// Switches to `gralph/<session>`, creating it from `HEAD` when missing.
+pub fn checkout_session_branch(dir: &Path, session: &str) -> Result<String, GitError> {
+    let branch = session_branch_name(session);
+    if current_branch(dir)? == branch {
+        return Ok(branch);
+    }
+    let reference = format!("refs/heads/{}", branch);
+    let exists = git_output(dir, ["show-ref", "--verify", "--quiet", reference.as_str()]).is_ok();
+    if exists {
+        git_output(dir, ["checkout", branch.as_str()])?;
+    } else {
+        git_output(dir, ["checkout", "-b", branch.as_str()])?;
+    }
+    Ok(branch)
+}
+
+/// Diff of the working tree against `base` (or `HEAD`), excluding gralph's
+/// directories. Committed and uncommitted changes are both included.
+pub fn diff_since(dir: &Path, base: Option<&str>) -> Result<String, GitError> {
+    let mut args = vec![
+        "diff".to_string(),
+        base.unwrap_or("HEAD").to_string(),
+        "--".to_string(),
+        ".".to_string(),
+    ];
+    for ignored in IGNORED_DIRS {
+        args.push(format!(":(exclude){}", ignored));
+    }
+    git_output(dir, &args)
+}
+
+/// Changes from `base` to `HEAD` outside gralph's directories: a `--stat`
+/// summary followed by the patch, or only the summary with `stat_only`.
+pub fn diff_range(dir: &Path, base: &str, stat_only: bool) -> Result<String, GitError> {
+    let mut args = vec![
+        "diff".to_string(),
+        if stat_only {
+            "--stat"
+        } else {
+            "--patch-with-stat"
+        }
+        .to_string(),
+        base.to_string(),
+        "HEAD".to_string(),
+        "--".to_string(),
+        ".".to_string(),
+    ];
+    for ignored in IGNORED_DIRS {
+        args.push(format!(":(exclude){}", ignored));
+    }
+    git_output(dir, &args)
+}
+
+/// Commits after `base` up to `HEAD` whose message names `task_id`, oldest
+/// first. The ID must appear as a whole word, so `COR-2` does not match
+/// `COR-21`.
+pub fn task_commits(dir: &Path, base: &str, task_id: &str) -> Result<Vec<String>, GitError> {
+    let range = format!("{}..HEAD", base);
+    let output = git_output(
+        dir,
+        ["log", "-z", "--reverse", "--format=%H%n%B", range.as_str()],
+    )?;
+    Ok(output
+        .split('\0')
+        .filter_map(|record| record.trim_start().split_once('\n'))
+        .filter(|(_, message)| mentions_task(message, task_id))
+        .map(|(hash, _)| hash.to_string())
+        .collect())
+}
+
+/// `git show` for each commit: its header and `--stat` summary, followed by
+/// the patch unless `stat_only`.
+pub fn show_commits(dir: &Path, commits: &[String], stat_only: bool) -> Result<String, GitError> {
+    let mut args = vec![
+        "show".to_string(),
+        if stat_only {
+            "--stat"
+        } else {
+            "--patch-with-stat"
+        }
+        .to_string(),
+    ];
+    args.extend(commits.iter().cloned());
+    args.push("--".to_string());
+    args.push(".".to_string());
+    for ignored in IGNORED_DIRS {
+        args.push(format!(":(exclude){}", ignored));
+    }
+    git_output(dir, &args)
+}
+
+fn mentions_task(message: &str, task_id: &str) -> bool {
+    let task_id = task_id.trim();
+    !task_id.is_empty()
+        && message
+            .split(|c: char| !(c.is_ascii_alphanumeric() || c == '-' || c == '_'))
+            .any(|word| word.eq_ignore_ascii_case(task_id))
+}
+
+/// Stages everything outside gralph's directories and commits it. Returns the
+/// new `HEAD`, or `None` when there was nothing to commit.
+pub fn commit_all(dir: &Path, message: &str) -> Result<Option<String>, GitError> {
+    let mut args = vec![
+        "add".to_string(),
+        "-A".to_string(),
+        "--".to_string(),
+        ".".to_string(),
+    ];
+    for ignored in IGNORED_DIRS {
+        args.push(format!(":(exclude){}", ignored));
+    }
+    git_output(dir, &args)?;
+    if git_output(dir, ["diff", "--cached", "--quiet"]).is_ok() {
+        return Ok(None);
+    }
+    git_output(dir, ["commit", "-q", "-m", message])?;
+    Ok(head_commit(dir))
+}
+
+/// Throws away uncommitted changes outside gralph's directories: tracked
+/// files go back to `HEAD` and untracked files are removed.
+pub fn discard_changes(dir: &Path) -> Result<(), GitError> {
+    reset_to(dir, "HEAD")
+}
+
+/// Moves the current branch to `commit` and makes the tree match it, dropping
+/// later commits and uncommitted changes. gralph's directories are left alone.
+///
+/// The reset moves the branch for the whole repository, so the tree is
+/// restored from the repository root even when `dir` is a subdirectory.
+pub fn reset_to(dir: &Path, commit: &str) -> Result<(), GitError> {
+    let root = repo_root(dir).unwrap_or_else(|| dir.to_path_buf());
+    let mut pathspec = vec!["--".to_string(), ".".to_string()];
+    for ignored in IGNORED_DIRS {
+        // A project below the root keeps its `.gralph` next to it.
+        pathspec.push(format!(":(exclude,glob)**/{}/**", ignored));
+    }
+    git_output(&root, ["reset", "-q", commit])?;
+    git_output(
+        &root,
+        ["checkout".to_string(), "-q".to_string()]
+            .into_iter()
+            .chain(pathspec.iter().cloned()),
+    )?;
+    git_output(
+        &root,
+        ["clean".to_string(), "-fdq".to_string()]
+            .into_iter()
+            .chain(pathspec),
+    )?;
+    Ok(())
+}
+
+pub fn iteration_commit_message(task_id: Option<&str>, iteration: u32) -> String {
+    match task_id.map(str::trim).filter(|id| !id.is_empty()) {
+        Some(id) => format!("chore(gralph): {} (iteration {})", id, iteration),
+        None => format!("chore(gralph): iteration {}", iteration),
+    }
+}
+
+/// Inspects the tree after an iteration. When the backend did not commit its
+/// work, leftover changes are committed (with `auto_commit`) or reported; in
+/// `strict` mode any change still uncommitted is an error.
+pub fn finish_iteration(
+    dir: &Path,
+    settings: &GitSettings,
+    head_before: Option<&str>,
+    task_id: Option<&str>,
+    iteration: u32,
+) -> Result<IterationCommit, GitError> {
+    let head_after = head_commit(dir);
+    let backend_committed = head_after.is_some() && head_after.as_deref() != head_before;
+    let paths = dirty_paths(dir)?;
+
+    if paths.is_empty() {
+        return Ok(match head_after {
+            Some(head) if backend_committed => IterationCommit::Committed { head },
+            _ => IterationCommit::Clean,
+        });
+    }
+
+    if settings.auto_commit {
+        let message = iteration_commit_message(task_id, iteration);
+        if let Some(head) = commit_all(dir, &message)? {
+            return Ok(IterationCommit::AutoCommitted { head, message });
+        }
+    }
+
+    if settings.strict {
+        return Err(GitError::Dirty { paths });
+    }
+    Ok(IterationCommit::Uncommitted { paths })
+}
+
+fn is_ignored_path(path: &str) -> bool {
+    Path::new(path)
+        .components()
+        .any(|component| match component {
+            Component::Normal(name) => IGNORED_DIRS.iter().any(|dir| name == OsStr::new(dir)),
+            _ => false,
+        })
+}
+
+fn git_output(
+    dir: &Path,
+    args: impl IntoIterator<Item = impl AsRef<OsStr>>,
+) -> Result<String, GitError> {
+    let args: Vec<_> = args
+        .into_iter()
+        .map(|arg| arg.as_ref().to_os_string())
+        .collect();
+    let output = Command::new("git")
+        .arg("-C")
+        .arg(dir)
+        .args(&args)
+        .output()
+        .map_err(GitError::Io)?;
+    if output.status.success() {
+        return Ok(String::from_utf8_lossy(&output.stdout).to_string());
+    }
+    let stderr = String::from_utf8_lossy(&output.stderr).trim().to_string();
+    Err(GitError::Command {
+        args: args
+            .iter()
+            .map(|arg| arg.to_string_lossy())
+            .collect::<Vec<_>>()
+            .join(" "),
+        message: if stderr.is_empty() {
+            format!("exit status {}", output.status)
+        } else {
+            stderr
+        },
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::serve_http_once;
+    use std::fs;
+
+    fn init_repo() -> tempfile::TempDir {
+        let temp = tempfile::tempdir().unwrap();
+        for args in [
+            vec!["init", "-q"],
+            vec!["config", "user.email", "gralph@example.com"],
+            vec!["config", "user.name", "gralph"],
+            vec!["config", "commit.gpgsign", "false"],
+        ] {
+            git_output(temp.path(), args).unwrap();
+        }
+        fs::write(temp.path().join("README.md"), "hello\n").unwrap();
+        commit_all(temp.path(), "init").unwrap();
+        temp
+    }
+
+    #[test]
+    fn diff_range_and_task_commits_cover_changes_since_base() {
+        let repo = init_repo();
+        let dir = repo.path();
+        let base = head_commit(dir).unwrap();
+        fs::write(dir.join("a.txt"), "one\n").unwrap();
+        commit_all(dir, &iteration_commit_message(Some("COR-2"), 1)).unwrap();
+        fs::write(dir.join("b.txt"), "two\n").unwrap();
+        commit_all(dir, &iteration_commit_message(Some("COR-21"), 2)).unwrap();
+
+        let stat = diff_range(dir, &base, true).unwrap();
+        assert!(stat.contains("a.txt") && stat.contains("b.txt"));
+        assert!(!stat.contains("+one"));
+        let full = diff_range(dir, &base, false).unwrap();
+        assert!(full.contains("2 files changed") && full.contains("+two"));
+
+        let commits = task_commits(dir, &base, "cor-2").unwrap();
+        assert_eq!(commits.len(), 1);
+        let shown = show_commits(dir, &commits, false).unwrap();
+        assert!(shown.contains("COR-2 (iteration 1)") && shown.contains("+one"));
+        assert!(!shown.contains("b.txt"));
+        assert!(task_commits(dir, &base, "COR-9").unwrap().is_empty());
+    }
+
+    #[test]
+    fn changed_paths_and_restore_paths_cover_commits_and_untracked_files() {
+        let repo = init_repo();
+        let dir = repo.path();
+        let base = head_commit(dir).unwrap();
+        fs::create_dir_all(dir.join("sub")).unwrap();
+        fs::write(dir.join("sub").join("new.txt"), "new\n").unwrap();
+        commit_all(dir, "add sub").unwrap();
+        fs::write(dir.join("README.md"), "changed\n").unwrap();
+        fs::write(dir.join("untracked.txt"), "x\n").unwrap();
+        fs::create_dir_all(dir.join(".gralph")).unwrap();
+        fs::write(dir.join(".gralph").join("state.json"), "{}").unwrap();
+
+        assert_eq!(path_prefix(&dir.join("sub")).unwrap(), "sub/");
+        let changed = changed_paths(&dir.join("sub"), &base).unwrap();
+        assert_eq!(changed, vec!["README.md", "sub/new.txt", "untracked.txt"]);
+
+        restore_paths(dir, &base, &changed).unwrap();
+        assert_eq!(
+            fs::read_to_string(dir.join("README.md")).unwrap(),
+            "hello\n"
+        );
+        assert!(!dir.join("sub").join("new.txt").exists());
+        assert!(!dir.join("untracked.txt").exists());
+        assert!(changed_paths(dir, &base).unwrap().is_empty());
+    }
+
+    #[test]
+    fn iteration_commit_message_includes_task_id() {
+        assert_eq!(
+            iteration_commit_message(Some("COR-2"), 3),
+            "chore(gralph): COR-2 (iteration 3)"
+        );
+        assert_eq!(
+            iteration_commit_message(None, 1),
+            "chore(gralph): iteration 1"
+        );
+    }
+
+    #[test]
+    fn parse_remote_url_handles_https_ssh_and_scp_forms() {
+        let github = parse_remote_url("git@github.com:goosewin/gralph.git").unwrap();
+        assert_eq!(github.forge, Forge::GitHub);
+        assert_eq!(github.path, "goosewin/gralph");
+        assert_eq!(github.default_api_url(), "https://api.github.com");
+
+        let gitlab = parse_remote_url("https://gitlab.example.com/team/sub/app.git\n").unwrap();
+        assert_eq!(gitlab.forge, Forge::GitLab);
+        assert_eq!(gitlab.host, "gitlab.example.com");
+        assert_eq!(gitlab.path, "team/sub/app");
+        assert_eq!(
+            gitlab.default_api_url(),
+            "https://gitlab.example.com/api/v4"
+        );
+
+        let enterprise = parse_remote_url("ssh://git@github.corp.io:2222/org/tool").unwrap();
+        assert_eq!(enterprise.host, "github.corp.io");
+        assert_eq!(
+            enterprise.default_api_url(),
+            "https://github.corp.io/api/v3"
+        );
+
+        assert!(parse_remote_url("https://bitbucket.org/team/app.git").is_none());
+        assert!(parse_remote_url("/srv/git/app.git").is_none());
+    }
+
+    #[test]
+    fn open_pull_request_posts_to_github() {
+        let (base, handle) = serve_http_once(
+            "HTTP/1.1 201 Created",
+            "{\"html_url\":\"https://github.com/o/r/pull/7\"}".to_string(),
+        );
+        let repo = parse_remote_url("https://github.com/o/r.git").unwrap();
+        let url = open_pull_request(
+            &repo,
+            &base,
+            "tok",
+            &PullRequest {
+                title: "gralph: demo",
+                body: "report",
+                head: "gralph/demo",
+                base: "main",
+            },
+        )
+        .unwrap();
+
+        assert_eq!(url, "https://github.com/o/r/pull/7");
+        let request = handle.join().unwrap();
+        assert!(request.starts_with("POST /repos/o/r/pulls"));
+        assert!(
+            request
+                .to_ascii_lowercase()
+                .contains("authorization: bearer tok")
+        );
+        assert!(request.contains("\"head\":\"gralph/demo\""));
+        assert!(request.contains("\"body\":\"report\""));
+    }
+
+    #[test]
+    fn open_pull_request_posts_merge_request_to_gitlab() {
+        let (base, handle) = serve_http_once(
+            "HTTP/1.1 201 Created",
+            "{\"web_url\":\"https://gitlab.com/g/p/-/merge_requests/3\"}".to_string(),
+        );
+        let repo = parse_remote_url("git@gitlab.com:g/p.git").unwrap();
+        let url = open_pull_request(
+            &repo,
+            &base,
+            "tok",
+            &PullRequest {
+                title: "t",
+                body: "b",
+                head: "gralph/demo",
+                base: "main",
+            },
+        )
+        .unwrap();
+
+        assert_eq!(url, "https://gitlab.com/g/p/-/merge_requests/3");
+        let request = handle.join().unwrap();
+        assert!(request.starts_with("POST /projects/g%2Fp/merge_requests"));
+        assert!(request.to_ascii_lowercase().contains("private-token: tok"));
+        assert!(request.contains("\"target_branch\":\"main\""));
+    }
+
+    #[test]
+    fn open_pull_request_reports_api_errors() {
+        let (base, handle) = serve_http_once(
+            "HTTP/1.1 422 Unprocessable Entity",
+            "{\"message\":\"A pull request already exists\"}".to_string(),
+        );
+        let repo = parse_remote_url("https://github.com/o/r").unwrap();
+        let err = open_pull_request(
+            &repo,
+            &base,
+            "tok",
+            &PullRequest {
+                title: "t",
+                body: "b",
+                head: "h",
+                base: "main",
+            },
+        )
+        .unwrap_err();
+        let _ = handle.join();
+        assert!(err.to_string().contains("422"));
+        assert!(err.to_string().contains("already exists"));
+    }
+
+    #[test]
+    fn dirty_paths_skips_gralph_directories() {
+        let repo = init_repo();
+        fs::create_dir_all(repo.path().join(".gralph")).unwrap();
+        fs::write(repo.path().join(".gralph/loop.log"), "log").unwrap();
+        assert!(dirty_paths(repo.path()).unwrap().is_empty());
+
+        fs::write(repo.path().join("src.rs"), "fn main() {}\n").unwrap();
+        assert_eq!(dirty_paths(repo.path()).unwrap(), vec!["src.rs"]);
+        assert!(matches!(
+            ensure_clean(repo.path()),
+            Err(GitError::Dirty { paths }) if paths == vec!["src.rs"]
+        ));
+    }
+
+    #[test]
+    fn discard_changes_restores_head_and_keeps_gralph_directories() {
+        let repo = init_repo();
+        fs::write(repo.path().join("README.md"), "half done\n").unwrap();
+        fs::write(repo.path().join("new.rs"), "// new\n").unwrap();
+        fs::write(repo.path().join("staged.rs"), "// staged\n").unwrap();
+        git_output(repo.path(), ["add", "staged.rs"]).unwrap();
+        fs::create_dir_all(repo.path().join(".gralph")).unwrap();
+        fs::write(repo.path().join(".gralph/loop.log"), "log").unwrap();
+
+        discard_changes(repo.path()).unwrap();
+
+        assert!(dirty_paths(repo.path()).unwrap().is_empty());
+        assert_eq!(
+            fs::read_to_string(repo.path().join("README.md")).unwrap(),
+            "hello\n"
+        );
+        assert!(!repo.path().join("new.rs").exists());
+        assert!(!repo.path().join("staged.rs").exists());
+        assert!(repo.path().join(".gralph/loop.log").exists());
+    }
+
+    #[test]
+    fn reset_to_drops_later_commits_and_changes() {
+        let repo = init_repo();
+        let base = head_commit(repo.path()).unwrap();
+        fs::write(repo.path().join("README.md"), "broken\n").unwrap();
+        fs::write(repo.path().join("added.rs"), "// added\n").unwrap();
+        commit_all(repo.path(), "bad iteration").unwrap();
+        fs::write(repo.path().join("left.rs"), "// left\n").unwrap();
+
+        reset_to(repo.path(), &base).unwrap();
+
+        assert_eq!(head_commit(repo.path()), Some(base));
+        assert!(dirty_paths(repo.path()).unwrap().is_empty());
+        assert_eq!(
+            fs::read_to_string(repo.path().join("README.md")).unwrap(),
+            "hello\n"
+        );
+        assert!(!repo.path().join("added.rs").exists());
+    }
+
+    #[test]
+    fn reset_to_restores_the_whole_repo_from_a_nested_project() {
+        let repo = init_repo();
+        let project = repo.path().join("app");
+        fs::create_dir_all(&project).unwrap();
+        fs::write(project.join("main.rs"), "fn main() {}\n").unwrap();
+        commit_all(repo.path(), "add app").unwrap();
+        let base = head_commit(repo.path()).unwrap();
+
+        fs::write(repo.path().join("README.md"), "broken\n").unwrap();
+        fs::write(project.join("main.rs"), "broken\n").unwrap();
+        commit_all(repo.path(), "bad iteration").unwrap();
+        fs::write(repo.path().join("left.rs"), "// left\n").unwrap();
+        fs::create_dir_all(project.join(".gralph")).unwrap();
+        fs::write(project.join(".gralph/loop.log"), "log").unwrap();
+
+        reset_to(&project, &base).unwrap();
+
+        assert_eq!(head_commit(repo.path()), Some(base));
+        assert!(dirty_paths(repo.path()).unwrap().is_empty());
+        assert_eq!(
+            fs::read_to_string(repo.path().join("README.md")).unwrap(),
+            "hello\n"
+        );
+        assert_eq!(
+            fs::read_to_string(project.join("main.rs")).unwrap(),
+            "fn main() {}\n"
+        );
+        assert!(!repo.path().join("left.rs").exists());
+        assert!(project.join(".gralph/loop.log").exists());
+    }
+
+    #[test]
+    fn diff_since_covers_commits_and_worktree_changes() {
+        let repo = init_repo();
+        let base = head_commit(repo.path()).unwrap();
+        fs::write(repo.path().join("README.md"), "hello\nworld\n").unwrap();
+        commit_all(repo.path(), "update readme").unwrap();
+        fs::write(repo.path().join("README.md"), "hello\nworld\nagain\n").unwrap();
+
+        let diff = diff_since(repo.path(), Some(&base)).unwrap();
+
+        assert!(diff.contains("+world"));
+        assert!(diff.contains("+again"));
+    }
+
+    #[test]
+    fn checkout_session_branch_creates_then_reuses_branch() {
+        let repo = init_repo();
+        assert_eq!(
+            checkout_session_branch(repo.path(), "demo").unwrap(),
+            "gralph/demo"
+        );
+        assert_eq!(current_branch(repo.path()).unwrap(), "gralph/demo");
+        assert_eq!(
+            checkout_session_branch(repo.path(), "demo").unwrap(),
+            "gralph/demo"
+        );
+    }
+
+    #[test]
+    fn finish_iteration_auto_commits_leftover_changes() {
+        let repo = init_repo();
+        let before = head_commit(repo.path());
+        fs::write(repo.path().join("feature.rs"), "// work\n").unwrap();
+        fs::create_dir_all(repo.path().join(".gralph")).unwrap();
+        fs::write(repo.path().join(".gralph/loop.log"), "log").unwrap();
+
+        let settings = GitSettings {
+            auto_commit: true,
+            ..GitSettings::default()
+        };
+        let result =
+            finish_iteration(repo.path(), &settings, before.as_deref(), Some("COR-1"), 2).unwrap();
+
+        let IterationCommit::AutoCommitted { head, message } = result else {
+            panic!("expected auto commit, got {:?}", result);
+        };
+        assert_eq!(message, "chore(gralph): COR-1 (iteration 2)");
+        assert_eq!(head_commit(repo.path()).as_deref(), Some(head.as_str()));
+        let tracked = git_output(repo.path(), ["ls-files"]).unwrap();
+        assert!(tracked.contains("feature.rs"));
+        assert!(!tracked.contains(".gralph"));
+    }
+
+    #[test]
+    fn finish_iteration_reports_backend_commits_and_strict_dirty_trees() {
+        let repo = init_repo();
+        let before = head_commit(repo.path());
+        fs::write(repo.path().join("done.rs"), "// done\n").unwrap();
+        commit_all(repo.path(), "feat: done").unwrap();
+
+        let settings = GitSettings::default();
+        assert!(matches!(
+            finish_iteration(repo.path(), &settings, before.as_deref(), None, 1).unwrap(),
+            IterationCommit::Committed { .. }
+        ));
+
+        fs::write(repo.path().join("left.rs"), "// left\n").unwrap();
+        let head = head_commit(repo.path());
+        assert_eq!(
+            finish_iteration(repo.path(), &settings, head.as_deref(), None, 2).unwrap(),
+            IterationCommit::Uncommitted {
+                paths: vec!["left.rs".to_string()]
+            }
+        );
+        let strict = GitSettings {
+            strict: true,
+            ..GitSettings::default()
+        };
+        assert!(matches!(
+            finish_iteration(repo.path(), &strict, head.as_deref(), None, 2),
+            Err(GitError::Dirty { .. })
+        ));
+    }
+}
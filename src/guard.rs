@@ -0,0 +1,399 @@
+//! File-scope guardrails for loop iterations, set in the `guard` config
+//! section.
+//!
+//! After each iteration the guard lists every path changed since the
+//! iteration started and compares it with an allow-list: the task files, the
+//! attempted task's Context Bundle, and the globs in `guard.allowed_paths`.
+//! Changes outside it are logged and, with `guard.mode: revert`, put back the
+//! way they were. Paths matching `guard.protected_paths` are denied even when
+//! allowed: changing one always reverts it and fails the iteration. Protected
+//! files that were already changed when the iteration started are compared
+//! by content instead, and put back to that content.
+
+use crate::config::Config;
+use crate::gitops::{self, GitError};
+use std::error::Error;
+use std::fmt;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+
+pub const GUARD_MODES: [&str; 3] = ["off", "warn", "revert"];
+
+#[derive(Debug)]
+pub enum GuardError {
+    Config(String),
+    Git(GitError),
+    Io { path: PathBuf, source: io::Error },
+}
+
+impl fmt::Display for GuardError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            GuardError::Config(message) => write!(f, "invalid guard config: {}", message),
+            GuardError::Git(source) => write!(f, "file guard failed: {}", source),
+            GuardError::Io { path, source } => {
+                write!(f, "file guard failed on {}: {}", path.display(), source)
+            }
+        }
+    }
+}
+
+impl Error for GuardError {
+    fn source(&self) -> Option<&(dyn Error + 'static)> {
+        match self {
+            GuardError::Config(_) => None,
+            GuardError::Git(source) => Some(source),
+            GuardError::Io { source, .. } => Some(source),
+        }
+    }
+}
+
+impl From<GitError> for GuardError {
+    fn from(source: GitError) -> Self {
+        GuardError::Git(source)
+    }
+}
+
+/// What the guard does with changes outside the allow-list.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum GuardMode {
+    /// Ignore them; only `guard.protected_paths` is enforced.
+    Off,
+    /// Log them and leave them in place.
+    Warn,
+    /// Log them and restore the files as they were when the iteration started.
+    Revert,
+}
+
+impl GuardMode {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            GuardMode::Off => "off",
+            GuardMode::Warn => "warn",
+            GuardMode::Revert => "revert",
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct GuardSettings {
+    pub mode: GuardMode,
+    /// Globs, relative to the project directory, the backend may always
+    /// change. `**` matches any number of directories, and a pattern without
+    /// a `/` matches a name at any depth.
+    pub allowed_paths: Vec<String>,
+    /// Globs, like `allowed_paths`, the backend may never change.
+    pub protected_paths: Vec<String>,
+    /// Treat an iteration with violations as failed.
+    pub fail_iteration: bool,
+}
+
+/// Changes an iteration made outside the allow-list or to protected paths.
+/// Paths are relative to the repository root.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct GuardReport {
+    /// Changed paths outside the allow-list.
+    pub violations: Vec<String>,
+    /// Whether `violations` were reverted.
+    pub reverted: bool,
+    /// Changed protected paths; these are always reverted.
+    pub protected: Vec<String>,
+}
+
+/// Protected files already changed when an iteration started, with their
+/// contents then (`None` for a deleted file). Paths are relative to the
+/// repository root.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct ProtectedSnapshot {
+    files: Vec<(String, Option<Vec<u8>>)>,
+}
+
+impl GuardSettings {
+    /// `None` when `guard.mode` is unset or `off` and there are no
+    /// `guard.protected_paths`.
+    pub fn from_config(config: &Config) -> Result<Option<Self>, GuardError> {
+        let mode = match config
+            .get("guard.mode")
+            .map(|value| value.trim().to_ascii_lowercase())
+            .filter(|value| !value.is_empty())
+            .as_deref()
+        {
+            None | Some("off") | Some("false") => GuardMode::Off,
+            Some("warn") => GuardMode::Warn,
+            Some("revert") => GuardMode::Revert,
+            Some(other) => {
+                return Err(GuardError::Config(format!(
+                    "unknown guard.mode: {} (expected {})",
+                    other,
+                    GUARD_MODES.join(", ")
+                )));
+            }
+        };
+        let patterns = |key: &str| -> Vec<String> {
+            config
+                .get_list(key)
+                .unwrap_or_default()
+                .into_iter()
+                .map(|pattern| pattern.trim().to_string())
+                .filter(|pattern| !pattern.is_empty())
+                .collect()
+        };
+        let protected_paths = patterns("guard.protected_paths");
+        if mode == GuardMode::Off && protected_paths.is_empty() {
+            return Ok(None);
+        }
+        Ok(Some(Self {
+            mode,
+            allowed_paths: patterns("guard.allowed_paths"),
+            protected_paths,
+            fail_iteration: config.get("guard.fail_iteration").is_some_and(|value| {
+                matches!(
+                    value.trim().to_ascii_lowercase().as_str(),
+                    "true" | "1" | "yes" | "y" | "on"
+                )
+            }),
+        }))
+    }
+
+    /// Records the contents of the protected paths among `changed_before`,
+    /// so [`GuardSettings::check`] can tell whether the iteration changed
+    /// them again.
+    pub fn snapshot_protected(
+        &self,
+        dir: &Path,
+        changed_before: &[String],
+    ) -> Result<ProtectedSnapshot, GuardError> {
+        let protected = self.protected_patterns(&gitops::path_prefix(dir)?);
+        let root = gitops::repo_root(dir).unwrap_or_else(|| dir.to_path_buf());
+        let mut files = Vec::new();
+        for path in changed_before {
+            if protected.iter().any(|pattern| path_matches(pattern, path)) {
+                files.push((path.clone(), read_contents(&root.join(path))?));
+            }
+        }
+        Ok(ProtectedSnapshot { files })
+    }
+
+    /// Checks the changes in `dir` since `base` against `guard.allowed_paths`
+    /// plus `allowed` (task files and Context Bundle entries), and against
+    /// `guard.protected_paths`. Protected paths are reverted, and so are the
+    /// violations in `revert` mode. Other paths in `changed_before`, already
+    /// changed when the iteration started, are left alone; protected ones are
+    /// compared with `protected_before` and restored to it.
+    pub fn check(
+        &self,
+        dir: &Path,
+        base: &str,
+        allowed: &[String],
+        changed_before: &[String],
+        protected_before: &ProtectedSnapshot,
+    ) -> Result<GuardReport, GuardError> {
+        let prefix = gitops::path_prefix(dir)?;
+        let allowed: Vec<String> = self
+            .allowed_paths
+            .iter()
+            .map(|pattern| config_pattern(&prefix, pattern))
+            .chain(
+                allowed
+                    .iter()
+                    .map(|path| format!("{}{}", prefix, path.trim_start_matches("./"))),
+            )
+            .collect();
+        let protected = self.protected_patterns(&prefix);
+
+        let mut report = GuardReport::default();
+        let root = gitops::repo_root(dir).unwrap_or_else(|| dir.to_path_buf());
+        let mut put_back = Vec::new();
+        for (path, before) in &protected_before.files {
+            if read_contents(&root.join(path))? != *before {
+                report.protected.push(path.clone());
+                put_back.push((root.join(path), before));
+            }
+        }
+        for path in gitops::changed_paths(dir, base)? {
+            if changed_before.contains(&path) {
+                continue;
+            }
+            if protected.iter().any(|pattern| path_matches(pattern, &path)) {
+                report.protected.push(path);
+            } else if self.mode != GuardMode::Off
+                && !allowed.iter().any(|pattern| path_matches(pattern, &path))
+            {
+                report.violations.push(path);
+            }
+        }
+        report.reverted = self.mode == GuardMode::Revert && !report.violations.is_empty();
+        let mut restore = report.protected.clone();
+        if report.reverted {
+            restore.extend(report.violations.iter().cloned());
+        }
+        if !restore.is_empty() {
+            gitops::restore_paths(dir, base, &restore)?;
+        }
+        for (path, before) in put_back {
+            write_contents(&path, before.as_deref())?;
+        }
+        report.protected.sort();
+        Ok(report)
+    }
+
+    fn protected_patterns(&self, prefix: &str) -> Vec<String> {
+        self.protected_paths
+            .iter()
+            .map(|pattern| config_pattern(prefix, pattern))
+            .collect()
+    }
+}
+
+/// The contents of `path`, or `None` when it does not exist.
+fn read_contents(path: &Path) -> Result<Option<Vec<u8>>, GuardError> {
+    match fs::read(path) {
+        Ok(contents) => Ok(Some(contents)),
+        Err(err) if err.kind() == io::ErrorKind::NotFound => Ok(None),
+        Err(source) => Err(GuardError::Io {
+            path: path.to_path_buf(),
+            source,
+        }),
+    }
+}
+
+/// Puts `path` back to `contents`, removing it when it did not exist.
+fn write_contents(path: &Path, contents: Option<&[u8]>) -> Result<(), GuardError> {
+    let io_error = |source| GuardError::Io {
+        path: path.to_path_buf(),
+        source,
+    };
+    match contents {
+        Some(contents) => {
+            if let Some(parent) = path.parent() {
+                fs::create_dir_all(parent).map_err(io_error)?;
+            }
+            fs::write(path, contents).map_err(io_error)
+        }
+        None => match fs::remove_file(path) {
+            Ok(()) => Ok(()),
+            Err(err) if err.kind() == io::ErrorKind::NotFound => Ok(()),
+            Err(source) => Err(io_error(source)),
+        },
+    }
+}
+
+/// A configured glob rooted at the project directory (`prefix` within the
+/// repository). A pattern without a `/` matches a name at any depth.
+fn config_pattern(prefix: &str, pattern: &str) -> String {
+    let pattern = pattern.trim_start_matches("./");
+    if pattern.trim_end_matches('/').contains('/') {
+        format!("{}{}", prefix, pattern)
+    } else {
+        format!("{}**/{}", prefix, pattern)
+    }
+}
+
+/// Whether `pattern` matches `path` or one of its parent directories, so a
+/// directory entry covers everything under it.
+fn path_matches(pattern: &str, path: &str) -> bool {
+    let pattern: Vec<&str> = pattern
+        .trim_end_matches('/')
+        .split('/')
+        .filter(|segment| !segment.is_empty())
+        .collect();
+    let path: Vec<&str> = path.split('/').collect();
+    !pattern.is_empty() && (1..=path.len()).any(|len| segments_match(&pattern, &path[..len]))
+}
+
+fn segments_match(pattern: &[&str], path: &[&str]) -> bool {
+    match pattern.split_first() {
+        None => path.is_empty(),
+        Some((&"**", rest)) => (0..=path.len()).any(|skip| segments_match(rest, &path[skip..])),
+        Some((segment, rest)) => path.split_first().is_some_and(|(name, path_rest)| {
+            wildcard_match(segment, name) && segments_match(rest, path_rest)
+        }),
+    }
+}
+
+fn wildcard_match(pattern: &str, value: &str) -> bool {
+    let parts: Vec<&str> = pattern.split('*').collect();
+    let (first, last) = (parts[0], parts[parts.len() - 1]);
+    if !value.starts_with(first) || value.len() < first.len() + last.len() {
+        return false;
+    }
+    let mut rest = &value[first.len()..];
+    for part in &parts[1..parts.len() - 1] {
+        match rest.find(part) {
+            Some(index) => rest = &rest[index + part.len()..],
+            None => return false,
+        }
+    }
+    rest.ends_with(last)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn path_matches_files_directories_and_globs() {
+        assert!(path_matches("src/lib.rs", "src/lib.rs"));
+        assert!(!path_matches("src/lib.rs", "src/lib.rs.bak"));
+        assert!(path_matches("docs", "docs/guide/intro.md"));
+        assert!(path_matches("docs/", "docs/intro.md"));
+        assert!(path_matches("src/*.rs", "src/main.rs"));
+        assert!(!path_matches("src/*.rs", "src/app/main.rs"));
+        assert!(path_matches("src/**/*.rs", "src/main.rs"));
+        assert!(path_matches("src/**/*.rs", "src/app/deep/main.rs"));
+        assert!(path_matches("**/*.snap", "tests/snapshots/a.snap"));
+        assert!(!path_matches("src/**", ".github/workflows/ci.yml"));
+        assert!(!path_matches("", "README.md"));
+    }
+
+    #[test]
+    fn config_pattern_roots_globs_at_the_project_dir() {
+        assert_eq!(config_pattern("", "./tests/**"), "tests/**");
+        assert_eq!(config_pattern("app/", ".github/"), "app/**/.github/");
+        assert_eq!(config_pattern("app/", "*.pem"), "app/**/*.pem");
+        assert!(path_matches(
+            &config_pattern("", "*.pem"),
+            "certs/dev/key.pem"
+        ));
+        assert!(path_matches(&config_pattern("", "go.mod"), "go.mod"));
+    }
+
+    #[test]
+    fn from_config_reads_the_guard_section() {
+        let config = Config::from_yaml("guard:\n  mode: off\n").unwrap();
+        assert_eq!(GuardSettings::from_config(&config).unwrap(), None);
+
+        let config =
+            Config::from_yaml("guard:\n  protected_paths: \".github/** *.pem\"\n").unwrap();
+        assert_eq!(
+            GuardSettings::from_config(&config).unwrap(),
+            Some(GuardSettings {
+                mode: GuardMode::Off,
+                allowed_paths: Vec::new(),
+                protected_paths: vec![".github/**".to_string(), "*.pem".to_string()],
+                fail_iteration: false,
+            })
+        );
+
+        let config = Config::from_yaml(
+            "guard:\n  mode: warn\n  allowed_paths: [\"tests/**\", \" \"]\n  fail_iteration: true\n",
+        )
+        .unwrap();
+        assert_eq!(
+            GuardSettings::from_config(&config).unwrap(),
+            Some(GuardSettings {
+                mode: GuardMode::Warn,
+                allowed_paths: vec!["tests/**".to_string()],
+                protected_paths: Vec::new(),
+                fail_iteration: true,
+            })
+        );
+
+        let config = Config::from_yaml("guard:\n  mode: block\n").unwrap();
+        assert_eq!(
+            GuardSettings::from_config(&config).unwrap_err().to_string(),
+            "invalid guard config: unknown guard.mode: block (expected off, warn, revert)"
+        );
+    }
+}
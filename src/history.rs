@@ -0,0 +1,248 @@
+//! Append-only session history.
+//!
+//! `state.json` only holds the latest snapshot of each session. When a loop
+//! finishes, it also appends one record to `history.jsonl` in the state
+//! directory, so past runs can still be found after the session is reused or
+//! cleaned up.
+
+use fs2::FileExt;
+use serde::{Deserialize, Serialize};
+use std::error::Error;
+use std::fmt;
+use std::fs::{self, OpenOptions};
+use std::io::{self, Write};
+use std::path::{Path, PathBuf};
+use std::time::SystemTime;
+
+pub const HISTORY_FILE: &str = "history.jsonl";
+
+#[derive(Debug)]
+pub enum HistoryError {
+    Io { path: PathBuf, source: io::Error },
+    Json(serde_json::Error),
+}
+
+impl fmt::Display for HistoryError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            HistoryError::Io { path, source } => {
+                write!(f, "history io error at {}: {}", path.display(), source)
+            }
+            HistoryError::Json(source) => write!(f, "history json error: {}", source),
+        }
+    }
+}
+
+impl Error for HistoryError {
+    fn source(&self) -> Option<&(dyn Error + 'static)> {
+        match self {
+            HistoryError::Io { source, .. } => Some(source),
+            HistoryError::Json(source) => Some(source),
+        }
+    }
+}
+
+/// One finished session.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct HistoryRecord {
+    pub session: String,
+    pub dir: String,
+    /// Final status as stored in state (`complete`, `failed`, `verified`, ...).
+    pub status: String,
+    pub iterations: u32,
+    pub max_iterations: u32,
+    pub remaining_tasks: usize,
+    pub duration_secs: u64,
+    /// Spend in USD. None when the backend does not report usage.
+    #[serde(default)]
+    pub cost_usd: Option<f64>,
+    pub backend: String,
+    #[serde(default)]
+    pub model: Option<String>,
+    pub started_at: String,
+    pub finished_at: String,
+    /// HEAD when the loop started and when it finished, if `dir` is a repo.
+    #[serde(default)]
+    pub git_start: Option<String>,
+    #[serde(default)]
+    pub git_end: Option<String>,
+}
+
+impl HistoryRecord {
+    /// `start..end` with short hashes, or the single commit when HEAD did
+    /// not move.
+    pub fn git_range(&self) -> Option<String> {
+        let short = |hash: &str| hash.chars().take(7).collect::<String>();
+        match (self.git_start.as_deref(), self.git_end.as_deref()) {
+            (Some(start), Some(end)) if start != end => {
+                Some(format!("{}..{}", short(start), short(end)))
+            }
+            (Some(commit), _) | (None, Some(commit)) => Some(short(commit)),
+            (None, None) => None,
+        }
+    }
+
+    fn finished_time(&self) -> Option<SystemTime> {
+        chrono::DateTime::parse_from_rfc3339(&self.finished_at)
+            .ok()
+            .map(SystemTime::from)
+    }
+}
+
+/// Path of the history file inside `state_dir`.
+pub fn history_path(state_dir: &Path) -> PathBuf {
+    state_dir.join(HISTORY_FILE)
+}
+
+/// Append `record` as one JSON line. Existing lines are never rewritten.
+pub fn append_record(path: &Path, record: &HistoryRecord) -> Result<(), HistoryError> {
+    let io_err = |source| HistoryError::Io {
+        path: path.to_path_buf(),
+        source,
+    };
+    if let Some(parent) = path.parent().filter(|p| !p.as_os_str().is_empty()) {
+        fs::create_dir_all(parent).map_err(io_err)?;
+    }
+    let mut line = serde_json::to_string(record).map_err(HistoryError::Json)?;
+    line.push('\n');
+    let mut file = OpenOptions::new()
+        .create(true)
+        .append(true)
+        .open(path)
+        .map_err(io_err)?;
+    file.lock_exclusive().map_err(io_err)?;
+    let written = file.write_all(line.as_bytes());
+    let _ = FileExt::unlock(&file);
+    written.map_err(io_err)
+}
+
+/// All records in file order. A missing file is an empty history; lines
+/// that do not parse are skipped.
+pub fn read_records(path: &Path) -> Result<Vec<HistoryRecord>, HistoryError> {
+    let contents = match fs::read_to_string(path) {
+        Ok(contents) => contents,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(source) => {
+            return Err(HistoryError::Io {
+                path: path.to_path_buf(),
+                source,
+            });
+        }
+    };
+    Ok(contents
+        .lines()
+        .filter(|line| !line.trim().is_empty())
+        .filter_map(|line| serde_json::from_str(line).ok())
+        .collect())
+}
+
+/// Records for `session` (all sessions when None) that finished at or after
+/// `since`.
+pub fn filter_records(
+    records: Vec<HistoryRecord>,
+    session: Option<&str>,
+    since: Option<SystemTime>,
+) -> Vec<HistoryRecord> {
+    records
+        .into_iter()
+        .filter(|record| session.is_none_or(|name| record.session == name))
+        .filter(|record| {
+            since.is_none_or(|since| record.finished_time().is_some_and(|time| time >= since))
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+
+    fn record(session: &str, finished_at: &str) -> HistoryRecord {
+        HistoryRecord {
+            session: session.to_string(),
+            dir: "/tmp/project".to_string(),
+            status: "complete".to_string(),
+            iterations: 3,
+            max_iterations: 30,
+            remaining_tasks: 0,
+            duration_secs: 120,
+            cost_usd: None,
+            backend: "claude".to_string(),
+            model: None,
+            started_at: "2026-01-01T00:00:00+00:00".to_string(),
+            finished_at: finished_at.to_string(),
+            git_start: Some("aaaaaaaaaaaa".to_string()),
+            git_end: Some("bbbbbbbbbbbb".to_string()),
+        }
+    }
+
+    #[test]
+    fn append_record_adds_lines_and_read_records_round_trips() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = history_path(&temp.path().join("state"));
+        let first = record("app", "2026-01-01T00:02:00+00:00");
+        let second = record("api", "2026-01-02T00:02:00+00:00");
+
+        append_record(&path, &first).unwrap();
+        append_record(&path, &second).unwrap();
+
+        let contents = fs::read_to_string(&path).unwrap();
+        assert_eq!(contents.lines().count(), 2);
+        assert_eq!(read_records(&path).unwrap(), vec![first, second]);
+    }
+
+    #[test]
+    fn read_records_skips_bad_lines_and_missing_file() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join(HISTORY_FILE);
+        assert!(read_records(&path).unwrap().is_empty());
+
+        let good = record("app", "2026-01-01T00:02:00+00:00");
+        fs::write(
+            &path,
+            format!("not json\n\n{}\n", serde_json::to_string(&good).unwrap()),
+        )
+        .unwrap();
+        assert_eq!(read_records(&path).unwrap(), vec![good]);
+    }
+
+    #[test]
+    fn filter_records_by_session_and_since() {
+        let records = vec![
+            record("app", "2026-01-01T00:00:00+00:00"),
+            record("api", "2026-01-05T00:00:00+00:00"),
+            record("app", "2026-01-09T00:00:00+00:00"),
+        ];
+        let cutoff = SystemTime::from(
+            chrono::DateTime::parse_from_rfc3339("2026-01-04T00:00:00+00:00").unwrap(),
+        );
+
+        let app = filter_records(records.clone(), Some("app"), None);
+        assert_eq!(app.len(), 2);
+
+        let recent = filter_records(records.clone(), None, Some(cutoff));
+        assert_eq!(
+            recent
+                .iter()
+                .map(|r| r.session.as_str())
+                .collect::<Vec<_>>(),
+            vec!["api", "app"]
+        );
+
+        let recent_app =
+            filter_records(records, Some("app"), Some(cutoff + Duration::from_secs(1)));
+        assert_eq!(recent_app.len(), 1);
+        assert_eq!(recent_app[0].finished_at, "2026-01-09T00:00:00+00:00");
+    }
+
+    #[test]
+    fn git_range_shortens_hashes() {
+        let mut entry = record("app", "2026-01-01T00:00:00+00:00");
+        assert_eq!(entry.git_range().as_deref(), Some("aaaaaaa..bbbbbbb"));
+        entry.git_end = entry.git_start.clone();
+        assert_eq!(entry.git_range().as_deref(), Some("aaaaaaa"));
+        entry.git_start = None;
+        entry.git_end = None;
+        assert_eq!(entry.git_range(), None);
+    }
+}
@@ -0,0 +1,208 @@
+use crate::config::Config;
+use std::error::Error;
+use std::fmt;
+use std::io;
+use std::path::Path;
+use std::process::Command;
+
+#[derive(Debug)]
+pub enum HookError {
+    Io {
+        hook: &'static str,
+        source: io::Error,
+    },
+    Failed {
+        hook: &'static str,
+        message: String,
+    },
+}
+
+impl fmt::Display for HookError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            HookError::Io { hook, source } => {
+                write!(f, "failed to run hooks.{}: {}", hook, source)
+            }
+            HookError::Failed { hook, message } => {
+                write!(f, "hooks.{} failed: {}", hook, message)
+            }
+        }
+    }
+}
+
+impl Error for HookError {
+    fn source(&self) -> Option<&(dyn Error + 'static)> {
+        match self {
+            HookError::Io { source, .. } => Some(source),
+            HookError::Failed { .. } => None,
+        }
+    }
+}
+
+/// Loop lifecycle points that can run a `hooks.*` shell command.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum HookEvent {
+    PreStart,
+    /// Check run after every successful iteration; a non-zero exit fails it.
+    PostIteration,
+    PostComplete,
+    PostFail,
+}
+
+impl HookEvent {
+    pub fn key(self) -> &'static str {
+        match self {
+            HookEvent::PreStart => "pre_start",
+            HookEvent::PostIteration => "post_iteration",
+            HookEvent::PostComplete => "post_complete",
+            HookEvent::PostFail => "post_fail",
+        }
+    }
+}
+
+/// Session metadata exported to hook commands.
+#[derive(Debug, Clone, Copy)]
+pub struct HookContext<'a> {
+    pub session: &'a str,
+    pub iteration: u32,
+    pub remaining: usize,
+}
+
+/// Output of a hook that ran and exited successfully.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct HookRun {
+    pub command: String,
+    pub output: String,
+}
+
+pub fn hook_command(config: &Config, event: HookEvent) -> Option<String> {
+    config
+        .get(&format!("hooks.{}", event.key()))
+        .map(|value| value.trim().to_string())
+        .filter(|value| !value.is_empty())
+}
+
+/// Run the configured command for `event` with `sh -c` in `dir`.
+///
+/// Returns `Ok(None)` when no command is configured. A non-zero exit is an
+/// error carrying the last line of output.
+pub fn run_hook(
+    config: &Config,
+    event: HookEvent,
+    dir: &Path,
+    context: &HookContext<'_>,
+) -> Result<Option<HookRun>, HookError> {
+    let Some(command) = hook_command(config, event) else {
+        return Ok(None);
+    };
+    let output = Command::new("sh")
+        .arg("-c")
+        .arg(&command)
+        .current_dir(dir)
+        .env("GRALPH_SESSION", context.session)
+        .env("GRALPH_ITERATION", context.iteration.to_string())
+        .env("GRALPH_REMAINING", context.remaining.to_string())
+        .env("GRALPH_HOOK", event.key())
+        .output()
+        .map_err(|source| HookError::Io {
+            hook: event.key(),
+            source,
+        })?;
+
+    let mut text = String::from_utf8_lossy(&output.stdout).into_owned();
+    text.push_str(&String::from_utf8_lossy(&output.stderr));
+    let text = text.trim_end().to_string();
+    if !output.status.success() {
+        let status = output
+            .status
+            .code()
+            .map(|code| format!("exit code {}", code))
+            .unwrap_or_else(|| "terminated by signal".to_string());
+        let message = match text.lines().rev().find(|line| !line.trim().is_empty()) {
+            Some(line) => format!("{} ({})", status, line.trim()),
+            None => status,
+        };
+        return Err(HookError::Failed {
+            hook: event.key(),
+            message,
+        });
+    }
+
+    Ok(Some(HookRun {
+        command,
+        output: text,
+    }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+
+    fn config_with(dir: &Path, yaml: &str) -> Config {
+        let _guard = crate::test_support::env_lock();
+        let path = dir.join("hooks.yaml");
+        fs::write(&path, yaml).unwrap();
+        unsafe {
+            std::env::set_var("GRALPH_DEFAULT_CONFIG", &path);
+            std::env::set_var("GRALPH_GLOBAL_CONFIG", dir.join("missing-global.yaml"));
+        }
+        let config = Config::load(None).unwrap();
+        unsafe {
+            std::env::remove_var("GRALPH_DEFAULT_CONFIG");
+            std::env::remove_var("GRALPH_GLOBAL_CONFIG");
+        }
+        config
+    }
+
+    fn context() -> HookContext<'static> {
+        HookContext {
+            session: "demo",
+            iteration: 3,
+            remaining: 2,
+        }
+    }
+
+    #[test]
+    fn run_hook_skips_unconfigured_events() {
+        let temp = tempfile::tempdir().unwrap();
+        let config = config_with(temp.path(), "hooks:\n  pre_start: \"\"\n");
+
+        let result = run_hook(&config, HookEvent::PreStart, temp.path(), &context()).unwrap();
+
+        assert!(result.is_none());
+    }
+
+    #[test]
+    fn run_hook_exports_session_metadata() {
+        let temp = tempfile::tempdir().unwrap();
+        let config = config_with(
+            temp.path(),
+            "hooks:\n  post_complete: echo \"$GRALPH_SESSION $GRALPH_ITERATION $GRALPH_REMAINING $GRALPH_HOOK\" > hook.out\n",
+        );
+
+        let run = run_hook(&config, HookEvent::PostComplete, temp.path(), &context())
+            .unwrap()
+            .unwrap();
+
+        assert!(run.command.starts_with("echo"));
+        let written = fs::read_to_string(temp.path().join("hook.out")).unwrap();
+        assert_eq!(written.trim(), "demo 3 2 post_complete");
+    }
+
+    #[test]
+    fn run_hook_reports_exit_code_and_last_line() {
+        let temp = tempfile::tempdir().unwrap();
+        let config = config_with(
+            temp.path(),
+            "hooks:\n  post_fail: \"echo first; echo deploy broke >&2; exit 4\"\n",
+        );
+
+        let err = run_hook(&config, HookEvent::PostFail, temp.path(), &context()).unwrap_err();
+
+        assert_eq!(
+            err.to_string(),
+            "hooks.post_fail failed: exit code 4 (deploy broke)"
+        );
+    }
+}
@@ -1,16 +1,33 @@
+pub mod api;
 pub mod backend;
+pub mod checkpoint;
 pub mod cli;
 pub mod config;
 pub mod core;
 mod entrypoint;
+pub mod gitops;
+pub mod guard;
+pub mod history;
+pub mod hooks;
+pub mod logging;
+pub mod mcp;
 pub mod notify;
+pub mod offline;
 pub mod prd;
+pub mod redact;
+pub mod remote;
 pub mod server;
+pub mod shutdown;
+pub mod sources;
 pub mod state;
 pub mod task;
+pub mod task_result;
+pub mod telemetry;
+pub mod tracker;
 pub mod update;
 mod verifier;
 pub mod version;
+pub mod workspace;
 
 pub mod app;
 pub use app::{Deps, exit_code_for, run};
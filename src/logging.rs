@@ -0,0 +1,657 @@
+use crate::config::Config;
+use crate::redact::Redactor;
+use serde_json::{Map, Value};
+use std::error::Error;
+use std::fmt;
+use std::fs::{self, OpenOptions};
+use std::io::{self, Write};
+use std::path::{Path, PathBuf};
+use std::time::SystemTime;
+
+const DEFAULT_MAX_SIZE_MB: u64 = 100;
+const DEFAULT_MAX_BACKUPS: u32 = 3;
+const BYTES_PER_MB: u64 = 1024 * 1024;
+
+#[derive(Debug)]
+pub struct LogError {
+    pub path: PathBuf,
+    pub source: io::Error,
+}
+
+impl fmt::Display for LogError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(
+            f,
+            "failed to write log {}: {}",
+            self.path.display(),
+            self.source
+        )
+    }
+}
+
+impl Error for LogError {
+    fn source(&self) -> Option<&(dyn Error + 'static)> {
+        Some(&self.source)
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub enum Level {
+    Debug,
+    Info,
+    Warn,
+    Error,
+}
+
+impl Level {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Level::Debug => "debug",
+            Level::Info => "info",
+            Level::Warn => "warn",
+            Level::Error => "error",
+        }
+    }
+
+    pub fn parse(value: &str) -> Option<Self> {
+        match value.trim().to_ascii_lowercase().as_str() {
+            "debug" => Some(Level::Debug),
+            "info" => Some(Level::Info),
+            "warn" | "warning" => Some(Level::Warn),
+            "error" => Some(Level::Error),
+            _ => None,
+        }
+    }
+
+    /// Prefix used by the text format, so warnings and errors stay greppable.
+    fn text_prefix(self) -> &'static str {
+        match self {
+            Level::Debug | Level::Info => "",
+            Level::Warn => "Warning: ",
+            Level::Error => "Error: ",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum LogFormat {
+    Text,
+    Json,
+}
+
+impl LogFormat {
+    pub fn parse(value: &str) -> Option<Self> {
+        match value.trim().to_ascii_lowercase().as_str() {
+            "text" => Some(LogFormat::Text),
+            "json" => Some(LogFormat::Json),
+            _ => None,
+        }
+    }
+}
+
+/// Logger settings from the `logging` config section.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct LogSettings {
+    pub level: Level,
+    pub format: LogFormat,
+    /// Rotate a log once it reaches this size; 0 disables rotation.
+    pub max_size_bytes: u64,
+    /// Rotated copies to keep as `<file>.1` .. `<file>.N`.
+    pub max_backups: u32,
+}
+
+impl Default for LogSettings {
+    fn default() -> Self {
+        Self {
+            level: Level::Info,
+            format: LogFormat::Text,
+            max_size_bytes: DEFAULT_MAX_SIZE_MB * BYTES_PER_MB,
+            max_backups: DEFAULT_MAX_BACKUPS,
+        }
+    }
+}
+
+impl LogSettings {
+    /// Unknown values fall back to the defaults rather than failing a run.
+    pub fn from_config(config: &Config) -> Self {
+        let defaults = Self::default();
+        Self {
+            level: config
+                .get("logging.level")
+                .and_then(|value| Level::parse(&value))
+                .unwrap_or(defaults.level),
+            format: config
+                .get("logging.format")
+                .and_then(|value| LogFormat::parse(&value))
+                .unwrap_or(defaults.format),
+            max_size_bytes: config
+                .get("logging.max_size_mb")
+                .and_then(|value| value.trim().parse::<u64>().ok())
+                .map(|mb| mb.saturating_mul(BYTES_PER_MB))
+                .unwrap_or(defaults.max_size_bytes),
+            max_backups: config
+                .get("logging.max_backups")
+                .and_then(|value| value.trim().parse::<u32>().ok())
+                .unwrap_or(defaults.max_backups),
+        }
+    }
+
+    /// Rotate `path` when it has reached `max_size_bytes`.
+    ///
+    /// Backups shift up one slot (`.1` becomes `.2`, ...), the oldest beyond
+    /// `max_backups` is dropped, and the current file becomes `.1`. With no
+    /// backups the file is simply removed. Returns whether it rotated.
+    pub fn rotate_if_needed(&self, path: &Path) -> Result<bool, LogError> {
+        if self.max_size_bytes == 0 {
+            return Ok(false);
+        }
+        let size = match fs::metadata(path) {
+            Ok(metadata) => metadata.len(),
+            Err(_) => return Ok(false),
+        };
+        if size < self.max_size_bytes {
+            return Ok(false);
+        }
+        if self.max_backups == 0 {
+            remove_if_exists(path)?;
+            return Ok(true);
+        }
+        remove_if_exists(&backup_path(path, self.max_backups))?;
+        for index in (1..self.max_backups).rev() {
+            let from = backup_path(path, index);
+            if from.exists() {
+                rename(&from, &backup_path(path, index + 1))?;
+            }
+        }
+        rename(path, &backup_path(path, 1))?;
+        Ok(true)
+    }
+}
+
+/// Path of the `index`-th rotated copy of `path`, e.g. `demo.log.2`.
+pub fn backup_path(path: &Path, index: u32) -> PathBuf {
+    let mut name = path.as_os_str().to_os_string();
+    name.push(format!(".{}", index));
+    PathBuf::from(name)
+}
+
+/// Whether a file name is a log or a rotated log backup (`*.log`, `*.log.N`).
+pub fn is_log_file_name(name: &str) -> bool {
+    if name.ends_with(".log") {
+        return true;
+    }
+    match name.rsplit_once('.') {
+        Some((stem, suffix)) => {
+            stem.ends_with(".log")
+                && !suffix.is_empty()
+                && suffix.chars().all(|ch| ch.is_ascii_digit())
+        }
+        None => false,
+    }
+}
+
+/// Writes leveled records to stdout (or stderr) and an optional log file.
+///
+/// Context fields added with [`Logger::with`] are attached to every JSON
+/// record; the text format only prints the per-record fields. Secrets are
+/// removed from every line by the [`Logger::redacting`] redactor.
+#[derive(Debug, Clone)]
+pub struct Logger {
+    path: Option<PathBuf>,
+    settings: LogSettings,
+    context: Vec<(String, Value)>,
+    stderr: bool,
+    redactor: Redactor,
+}
+
+impl Logger {
+    pub fn new(path: Option<&Path>, settings: LogSettings) -> Self {
+        Self {
+            path: path.map(Path::to_path_buf),
+            settings,
+            context: Vec::new(),
+            stderr: false,
+            redactor: Redactor::default(),
+        }
+    }
+
+    /// Echo records to stderr instead of stdout.
+    pub fn to_stderr(mut self) -> Self {
+        self.stderr = true;
+        self
+    }
+
+    /// Redact secrets with `redactor` before anything is printed or written.
+    pub fn redacting(mut self, redactor: Redactor) -> Self {
+        self.redactor = redactor;
+        self
+    }
+
+    pub fn with(&self, key: &str, value: impl Into<Value>) -> Self {
+        let mut logger = self.clone();
+        let value = value.into();
+        match logger.context.iter_mut().find(|(name, _)| name == key) {
+            Some(entry) => entry.1 = value,
+            None => logger.context.push((key.to_string(), value)),
+        }
+        logger
+    }
+
+    pub fn path(&self) -> Option<&Path> {
+        self.path.as_deref()
+    }
+
+    pub fn settings(&self) -> &LogSettings {
+        &self.settings
+    }
+
+    pub fn redactor(&self) -> &Redactor {
+        &self.redactor
+    }
+
+    pub fn debug(&self, message: &str) -> Result<(), LogError> {
+        self.log(Level::Debug, message, &[])
+    }
+
+    pub fn info(&self, message: &str) -> Result<(), LogError> {
+        self.log(Level::Info, message, &[])
+    }
+
+    pub fn warn(&self, message: &str) -> Result<(), LogError> {
+        self.log(Level::Warn, message, &[])
+    }
+
+    pub fn error(&self, message: &str) -> Result<(), LogError> {
+        self.log(Level::Error, message, &[])
+    }
+
+    pub fn log(
+        &self,
+        level: Level,
+        message: &str,
+        fields: &[(&str, Value)],
+    ) -> Result<(), LogError> {
+        let Some(line) = self.render(level, message, fields, SystemTime::now()) else {
+            return Ok(());
+        };
+        let line = self.redactor.redact(&line);
+        if self.stderr {
+            eprintln!("{}", line);
+        } else {
+            println!("{}", line);
+        }
+        if let Some(path) = self.path.as_deref() {
+            self.settings.rotate_if_needed(path)?;
+            append_line(path, &line)?;
+        }
+        Ok(())
+    }
+
+    /// Appends a line of live backend output to the log file only, since
+    /// the backend already prints it. Text lines start with the local time;
+    /// JSON records are tagged `"source": "backend"`.
+    pub fn output(&self, line: &str) -> Result<(), LogError> {
+        let Some(path) = self.path.as_deref() else {
+            return Ok(());
+        };
+        let now = SystemTime::now();
+        let rendered = match self.settings.format {
+            LogFormat::Text => {
+                let time: chrono::DateTime<chrono::Local> = now.into();
+                self.render(
+                    Level::Info,
+                    &format!("{} | {}", time.format("%H:%M:%S"), line),
+                    &[],
+                    now,
+                )
+            }
+            LogFormat::Json => self.render(
+                Level::Info,
+                line,
+                &[("source", Value::String("backend".to_string()))],
+                now,
+            ),
+        };
+        let Some(rendered) = rendered else {
+            return Ok(());
+        };
+        self.settings.rotate_if_needed(path)?;
+        append_line(path, &self.redactor.redact(&rendered))
+    }
+
+    /// Format a record, or `None` when it is below the configured level.
+    /// Empty messages are blank separator lines and are dropped from JSON.
+    pub fn render(
+        &self,
+        level: Level,
+        message: &str,
+        fields: &[(&str, Value)],
+        time: SystemTime,
+    ) -> Option<String> {
+        if level < self.settings.level {
+            return None;
+        }
+        match self.settings.format {
+            LogFormat::Text => {
+                if message.is_empty() {
+                    return Some(String::new());
+                }
+                let mut line = format!("{}{}", level.text_prefix(), message);
+                for (key, value) in fields {
+                    line.push_str(&format!(" {}={}", key, text_value(value)));
+                }
+                Some(line)
+            }
+            LogFormat::Json => {
+                if message.is_empty() {
+                    return None;
+                }
+                let datetime: chrono::DateTime<chrono::Utc> = time.into();
+                let mut record = Map::new();
+                record.insert(
+                    "time".to_string(),
+                    Value::String(datetime.to_rfc3339_opts(chrono::SecondsFormat::Millis, true)),
+                );
+                record.insert("level".to_string(), Value::String(level.as_str().into()));
+                record.insert("msg".to_string(), Value::String(message.to_string()));
+                for (key, value) in &self.context {
+                    record.insert(key.clone(), value.clone());
+                }
+                for (key, value) in fields {
+                    record.insert((*key).to_string(), value.clone());
+                }
+                Some(Value::Object(record).to_string())
+            }
+        }
+    }
+}
+
+/// Render a log file line for display: JSON records become their text form,
+/// anything else is returned unchanged.
+pub fn display_line(line: &str) -> String {
+    match parse_record(line) {
+        Some((level, message)) => format!("{}{}", level.text_prefix(), message),
+        None => line.to_string(),
+    }
+}
+
+/// Level and message of a JSON log record.
+pub fn parse_record(line: &str) -> Option<(Level, String)> {
+    let trimmed = line.trim();
+    if !trimmed.starts_with('{') {
+        return None;
+    }
+    let value: Value = serde_json::from_str(trimmed).ok()?;
+    let level = value
+        .get("level")
+        .and_then(Value::as_str)
+        .and_then(Level::parse)?;
+    let message = value.get("msg").and_then(Value::as_str)?;
+    Some((level, message.to_string()))
+}
+
+fn text_value(value: &Value) -> String {
+    match value {
+        Value::String(text) if !text.is_empty() && !text.contains(char::is_whitespace) => {
+            text.clone()
+        }
+        Value::String(text) => format!("{:?}", text),
+        other => other.to_string(),
+    }
+}
+
+fn remove_if_exists(path: &Path) -> Result<(), LogError> {
+    match fs::remove_file(path) {
+        Ok(()) => Ok(()),
+        Err(err) if err.kind() == io::ErrorKind::NotFound => Ok(()),
+        Err(source) => Err(LogError {
+            path: path.to_path_buf(),
+            source,
+        }),
+    }
+}
+
+fn rename(from: &Path, to: &Path) -> Result<(), LogError> {
+    fs::rename(from, to).map_err(|source| LogError {
+        path: from.to_path_buf(),
+        source,
+    })
+}
+
+fn append_line(path: &Path, line: &str) -> Result<(), LogError> {
+    if let Some(parent) = path.parent() {
+        fs::create_dir_all(parent).map_err(|source| LogError {
+            path: parent.to_path_buf(),
+            source,
+        })?;
+    }
+    let mut file = OpenOptions::new()
+        .create(true)
+        .append(true)
+        .open(path)
+        .map_err(|source| LogError {
+            path: path.to_path_buf(),
+            source,
+        })?;
+    writeln!(file, "{}", line).map_err(|source| LogError {
+        path: path.to_path_buf(),
+        source,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+    use std::time::{Duration, UNIX_EPOCH};
+
+    fn logger(level: Level, format: LogFormat) -> Logger {
+        Logger::new(
+            None,
+            LogSettings {
+                level,
+                format,
+                ..LogSettings::default()
+            },
+        )
+    }
+
+    #[test]
+    fn text_format_prefixes_levels_and_appends_fields() {
+        let logger = logger(Level::Info, LogFormat::Text).with("session", "demo");
+        let time = UNIX_EPOCH;
+
+        assert_eq!(
+            logger.render(
+                Level::Info,
+                "Iteration finished",
+                &[("duration_secs", json!(12))],
+                time
+            ),
+            Some("Iteration finished duration_secs=12".to_string())
+        );
+        assert_eq!(
+            logger.render(
+                Level::Warn,
+                "slow hook",
+                &[("hook", json!("pre start"))],
+                time
+            ),
+            Some("Warning: slow hook hook=\"pre start\"".to_string())
+        );
+        assert_eq!(
+            logger.render(Level::Info, "", &[], time),
+            Some(String::new())
+        );
+    }
+
+    #[test]
+    fn level_filters_lower_records() {
+        let logger = logger(Level::Warn, LogFormat::Text);
+
+        assert_eq!(logger.render(Level::Info, "hidden", &[], UNIX_EPOCH), None);
+        assert_eq!(logger.render(Level::Debug, "hidden", &[], UNIX_EPOCH), None);
+        assert_eq!(
+            logger.render(Level::Error, "shown", &[], UNIX_EPOCH),
+            Some("Error: shown".to_string())
+        );
+    }
+
+    #[test]
+    fn json_format_includes_context_and_fields() {
+        let logger = logger(Level::Info, LogFormat::Json)
+            .with("session", "demo")
+            .with("iteration", 1)
+            .with("iteration", 2);
+        let time = UNIX_EPOCH + Duration::from_millis(1_500);
+
+        let line = logger
+            .render(
+                Level::Info,
+                "Iteration finished",
+                &[("duration_secs", json!(4))],
+                time,
+            )
+            .unwrap();
+        let record: Value = serde_json::from_str(&line).unwrap();
+
+        assert_eq!(record["time"], "1970-01-01T00:00:01.500Z");
+        assert_eq!(record["level"], "info");
+        assert_eq!(record["msg"], "Iteration finished");
+        assert_eq!(record["session"], "demo");
+        assert_eq!(record["iteration"], 2);
+        assert_eq!(record["duration_secs"], 4);
+        assert_eq!(logger.render(Level::Info, "", &[], time), None);
+    }
+
+    #[test]
+    fn display_line_converts_json_records() {
+        let line =
+            r#"{"time":"2026-01-01T00:00:00Z","level":"error","msg":"Iteration failed: bad"}"#;
+
+        assert_eq!(display_line(line), "Error: Iteration failed: bad");
+        assert_eq!(display_line("plain text"), "plain text");
+        assert_eq!(display_line("{not json"), "{not json");
+    }
+
+    #[test]
+    fn logger_appends_to_file() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("logs").join("demo.log");
+        let logger = Logger::new(Some(&path), LogSettings::default());
+
+        logger.info("first").unwrap();
+        logger.debug("skipped").unwrap();
+        logger.warn("second").unwrap();
+
+        let contents = fs::read_to_string(&path).unwrap();
+        assert_eq!(contents, "first\nWarning: second\n");
+    }
+
+    #[test]
+    fn output_goes_to_the_file_with_a_time() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("demo.log");
+        let logger = Logger::new(Some(&path), LogSettings::default());
+
+        logger.output("Editing src/main.rs").unwrap();
+
+        let contents = fs::read_to_string(&path).unwrap();
+        let (time, line) = contents.trim_end().split_once(" | ").unwrap();
+        assert_eq!(time.len(), "12:34:56".len());
+        assert_eq!(line, "Editing src/main.rs");
+
+        let json = Logger::new(
+            Some(&path),
+            LogSettings {
+                format: LogFormat::Json,
+                ..LogSettings::default()
+            },
+        );
+        json.output("Running tests").unwrap();
+        let record: Value =
+            serde_json::from_str(fs::read_to_string(&path).unwrap().lines().last().unwrap())
+                .unwrap();
+        assert_eq!(record["msg"], "Running tests");
+        assert_eq!(record["source"], "backend");
+    }
+
+    #[test]
+    fn redacting_logger_removes_secrets_from_records_and_output() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("demo.log");
+        let redactor = Redactor::new(&["secret-[0-9]+".to_string()], &[]);
+        let logger = Logger::new(Some(&path), LogSettings::default()).redacting(redactor);
+
+        logger.error("auth failed with secret-42").unwrap();
+        logger.output("using secret-7").unwrap();
+
+        let contents = fs::read_to_string(&path).unwrap();
+        assert!(contents.starts_with("Error: auth failed with [REDACTED]\n"));
+        assert!(contents.trim_end().ends_with("using [REDACTED]"));
+        assert!(!contents.contains("secret-"));
+    }
+
+    #[test]
+    fn rotate_shifts_backups_and_drops_oldest() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("demo.raw.log");
+        let settings = LogSettings {
+            max_size_bytes: 4,
+            max_backups: 2,
+            ..LogSettings::default()
+        };
+
+        fs::write(&path, "one!").unwrap();
+        assert!(settings.rotate_if_needed(&path).unwrap());
+        fs::write(&path, "two!").unwrap();
+        assert!(settings.rotate_if_needed(&path).unwrap());
+        fs::write(&path, "three").unwrap();
+        assert!(settings.rotate_if_needed(&path).unwrap());
+        fs::write(&path, "4").unwrap();
+        assert!(!settings.rotate_if_needed(&path).unwrap());
+
+        assert_eq!(fs::read_to_string(&path).unwrap(), "4");
+        assert_eq!(fs::read_to_string(backup_path(&path, 1)).unwrap(), "three");
+        assert_eq!(fs::read_to_string(backup_path(&path, 2)).unwrap(), "two!");
+        assert!(!backup_path(&path, 3).exists());
+    }
+
+    #[test]
+    fn rotate_without_backups_removes_file_and_zero_size_disables() {
+        let temp = tempfile::tempdir().unwrap();
+        let path = temp.path().join("demo.log");
+        fs::write(&path, "full").unwrap();
+
+        let disabled = LogSettings {
+            max_size_bytes: 0,
+            ..LogSettings::default()
+        };
+        assert!(!disabled.rotate_if_needed(&path).unwrap());
+
+        let no_backups = LogSettings {
+            max_size_bytes: 1,
+            max_backups: 0,
+            ..LogSettings::default()
+        };
+        assert!(no_backups.rotate_if_needed(&path).unwrap());
+        assert!(!path.exists());
+        assert!(!backup_path(&path, 1).exists());
+    }
+
+    #[test]
+    fn is_log_file_name_matches_backups() {
+        assert!(is_log_file_name("demo.log"));
+        assert!(is_log_file_name("demo.raw.log.3"));
+        assert!(!is_log_file_name("demo.log.bak"));
+        assert!(!is_log_file_name("tasks.json"));
+    }
+
+    #[test]
+    fn parse_accepts_known_values_only() {
+        assert_eq!(Level::parse("WARNING"), Some(Level::Warn));
+        assert_eq!(Level::parse("verbose"), None);
+        assert_eq!(LogFormat::parse(" json "), Some(LogFormat::Json));
+        assert_eq!(LogFormat::parse("yaml"), None);
+    }
+}
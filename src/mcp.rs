@@ -0,0 +1,455 @@
+//! `gralph mcp`: the Model Context Protocol over stdio.
+//!
+//! Each line on stdin is a JSON-RPC 2.0 message and each reply is one line
+//! on stdout, so MCP clients such as Claude Desktop can list, start, stop,
+//! and inspect loops as tools. The tools share their logic with the HTTP
+//! server in `server.rs`.
+
+use crate::core::active_task_file;
+use crate::prd;
+use crate::server::{self, DEFAULT_LOG_LINES, StartRequest};
+use crate::state::StateStore;
+use crate::version;
+use serde_json::{Value, json};
+use std::io::{self, BufRead, Write};
+use std::path::Path;
+
+/// Protocol revision answered when the client does not ask for one.
+pub const PROTOCOL_VERSION: &str = "2024-11-05";
+
+const PARSE_ERROR: i64 = -32700;
+const INVALID_REQUEST: i64 = -32600;
+const METHOD_NOT_FOUND: i64 = -32601;
+const INVALID_PARAMS: i64 = -32602;
+
+/// Serves requests from `input` until it is closed. Loops started with
+/// `start_loop` run offline when `offline` is set.
+pub fn serve(
+    store: &StateStore,
+    offline: bool,
+    input: impl BufRead,
+    mut output: impl Write,
+) -> Result<(), io::Error> {
+    for line in input.lines() {
+        let line = line?;
+        if line.trim().is_empty() {
+            continue;
+        }
+        let reply = match serde_json::from_str::<Value>(&line) {
+            Ok(message) => handle_message(store, offline, &message),
+            Err(error) => Some(error_reply(
+                Value::Null,
+                PARSE_ERROR,
+                format!("Parse error: {}", error),
+            )),
+        };
+        if let Some(reply) = reply {
+            writeln!(output, "{}", reply)?;
+            output.flush()?;
+        }
+    }
+    Ok(())
+}
+
+/// Answers one JSON-RPC message. Notifications get no reply.
+pub fn handle_message(store: &StateStore, offline: bool, message: &Value) -> Option<Value> {
+    let id = message.get("id").cloned();
+    let Some(method) = message.get("method").and_then(Value::as_str) else {
+        return Some(error_reply(
+            id.unwrap_or(Value::Null),
+            INVALID_REQUEST,
+            "Invalid request: missing method".to_string(),
+        ));
+    };
+    let id = id?;
+    let params = message.get("params").cloned().unwrap_or(Value::Null);
+    let result = match method {
+        "initialize" => Ok(initialize(&params)),
+        "ping" => Ok(json!({})),
+        "tools/list" => Ok(json!({"tools": tool_definitions()})),
+        "tools/call" => call_tool(store, offline, &params),
+        _ => Err((METHOD_NOT_FOUND, format!("Method not found: {}", method))),
+    };
+    Some(match result {
+        Ok(result) => json!({"jsonrpc": "2.0", "id": id, "result": result}),
+        Err((code, message)) => error_reply(id, code, message),
+    })
+}
+
+fn error_reply(id: Value, code: i64, message: String) -> Value {
+    json!({"jsonrpc": "2.0", "id": id, "error": {"code": code, "message": message}})
+}
+
+fn initialize(params: &Value) -> Value {
+    let protocol = params
+        .get("protocolVersion")
+        .and_then(Value::as_str)
+        .unwrap_or(PROTOCOL_VERSION);
+    json!({
+        "protocolVersion": protocol,
+        "capabilities": {"tools": {}},
+        "serverInfo": {"name": "gralph", "version": version::VERSION},
+    })
+}
+
+fn tool_definitions() -> Value {
+    json!([
+        {
+            "name": "list_sessions",
+            "description": "List gralph loops with their status, remaining tasks, and last log line.",
+            "inputSchema": {"type": "object", "properties": {}},
+        },
+        {
+            "name": "start_loop",
+            "description": "Start a gralph loop in the background for a project directory.",
+            "inputSchema": {
+                "type": "object",
+                "properties": {
+                    "dir": {"type": "string", "description": "Absolute project directory"},
+                    "name": {"type": "string", "description": "Session name (default: directory name)"},
+                    "task_file": {"type": "string", "description": "Task file relative to dir (default: PRD.md)"},
+                    "backend": {"type": "string"},
+                    "model": {"type": "string"},
+                    "max_iterations": {"type": "integer", "minimum": 1},
+                    "webhook": {"type": "string"},
+                },
+                "required": ["dir"],
+            },
+        },
+        {
+            "name": "stop_loop",
+            "description": "Stop a running gralph loop.",
+            "inputSchema": {
+                "type": "object",
+                "properties": {"name": {"type": "string"}},
+                "required": ["name"],
+            },
+        },
+        {
+            "name": "get_prd_tasks",
+            "description": "Parse the task blocks of a PRD, for a session or a project directory.",
+            "inputSchema": {
+                "type": "object",
+                "properties": {
+                    "name": {"type": "string", "description": "Session whose task file to read"},
+                    "dir": {"type": "string", "description": "Project directory, instead of name"},
+                    "task_file": {"type": "string", "description": "Task file relative to dir (default: PRD.md)"},
+                },
+            },
+        },
+        {
+            "name": "tail_logs",
+            "description": "Return the last lines of a session log.",
+            "inputSchema": {
+                "type": "object",
+                "properties": {
+                    "name": {"type": "string"},
+                    "lines": {"type": "integer", "minimum": 1, "description": "Default: 200"},
+                    "raw": {"type": "boolean", "description": "Read the raw backend log"},
+                },
+                "required": ["name"],
+            },
+        },
+    ])
+}
+
+/// Runs a tool. Unknown tools are a protocol error; a tool that fails
+/// returns its message as an `isError` result the model can read.
+fn call_tool(store: &StateStore, offline: bool, params: &Value) -> Result<Value, (i64, String)> {
+    let name = params.get("name").and_then(Value::as_str).ok_or_else(|| {
+        (
+            INVALID_PARAMS,
+            "tools/call requires a tool name".to_string(),
+        )
+    })?;
+    let arguments = params
+        .get("arguments")
+        .cloned()
+        .unwrap_or_else(|| json!({}));
+    let outcome = match name {
+        "list_sessions" => list_sessions(store),
+        "start_loop" => start_loop(store, offline, arguments),
+        "stop_loop" => stop_loop(store, &arguments),
+        "get_prd_tasks" => get_prd_tasks(store, &arguments),
+        "tail_logs" => tail_logs(store, &arguments),
+        _ => return Err((INVALID_PARAMS, format!("Unknown tool: {}", name))),
+    };
+    Ok(match outcome {
+        Ok(Value::String(text)) => tool_result(text, false),
+        Ok(value) => tool_result(
+            serde_json::to_string_pretty(&value).unwrap_or_default(),
+            false,
+        ),
+        Err(message) => tool_result(message, true),
+    })
+}
+
+fn tool_result(text: String, is_error: bool) -> Value {
+    json!({"content": [{"type": "text", "text": text}], "isError": is_error})
+}
+
+fn string_arg<'a>(arguments: &'a Value, key: &str) -> Option<&'a str> {
+    arguments
+        .get(key)
+        .and_then(Value::as_str)
+        .map(str::trim)
+        .filter(|value| !value.is_empty())
+}
+
+fn session(store: &StateStore, name: &str) -> Result<Value, String> {
+    store
+        .get_session(name)
+        .map_err(|error| error.to_string())?
+        .ok_or_else(|| format!("Session not found: {}", name))
+}
+
+fn list_sessions(store: &StateStore) -> Result<Value, String> {
+    let sessions = store.list_sessions().map_err(|error| error.to_string())?;
+    Ok(json!({
+        "sessions": sessions.into_iter().map(server::enrich_session).collect::<Vec<_>>(),
+    }))
+}
+
+fn start_loop(store: &StateStore, offline: bool, arguments: Value) -> Result<Value, String> {
+    let request: StartRequest = serde_json::from_value(arguments)
+        .map_err(|error| format!("Invalid arguments: {}", error))?;
+    let (name, args) = server::validate_start_request(&request)?;
+    if let Some(existing) = store
+        .get_session(&name)
+        .map_err(|error| error.to_string())?
+    {
+        if server::enrich_session(existing)
+            .get("is_alive")
+            .and_then(Value::as_bool)
+            == Some(true)
+        {
+            return Err(format!("Session already running: {}", name));
+        }
+    }
+    server::launch_start(&args, offline)
+        .map_err(|error| format!("Failed to launch gralph: {}", error))??;
+    Ok(server::enrich_session(session(store, &name).map_err(
+        |_| format!("Session was not recorded: {}", name),
+    )?))
+}
+
+fn stop_loop(store: &StateStore, arguments: &Value) -> Result<Value, String> {
+    let name = string_arg(arguments, "name").ok_or("name is required")?;
+    let record = session(store, name)?;
+    server::stop_session(name, &record);
+    store
+        .update_session(name, |mut fields| {
+            fields.insert("status".to_string(), Value::from("stopped"));
+            fields
+        })
+        .map_err(|error| error.to_string())?;
+    Ok(Value::String(format!("Stopped session {}", name)))
+}
+
+fn get_prd_tasks(store: &StateStore, arguments: &Value) -> Result<Value, String> {
+    let (dir, task_file) = match string_arg(arguments, "name") {
+        Some(name) => {
+            let record = session(store, name)?;
+            let field = |key: &str| record.get(key).and_then(Value::as_str).map(str::to_string);
+            (
+                field("dir").ok_or_else(|| format!("Session has no directory: {}", name))?,
+                field("task_file"),
+            )
+        }
+        None => (
+            string_arg(arguments, "dir")
+                .ok_or("name or dir is required")?
+                .to_string(),
+            None,
+        ),
+    };
+    let task_file = string_arg(arguments, "task_file")
+        .map(str::to_string)
+        .or(task_file)
+        .unwrap_or_else(|| "PRD.md".to_string());
+    let dir = Path::new(&dir);
+    let path = dir.join(active_task_file(dir, &task_file));
+    let document = prd::prd_parse_file(&path).map_err(|error| error.to_string())?;
+    Ok(json!({
+        "task_file": path.to_string_lossy(),
+        "title": document.title,
+        "tasks": document.tasks,
+    }))
+}
+
+fn tail_logs(store: &StateStore, arguments: &Value) -> Result<Value, String> {
+    let name = string_arg(arguments, "name").ok_or("name is required")?;
+    let lines = arguments
+        .get("lines")
+        .and_then(Value::as_u64)
+        .map_or(DEFAULT_LOG_LINES, |lines| lines.max(1) as usize);
+    let raw = arguments
+        .get("raw")
+        .and_then(Value::as_bool)
+        .unwrap_or(false);
+    let (_, tail, _) =
+        server::read_session_log(store, name, raw, lines).map_err(|(_, message)| message)?;
+    Ok(Value::String(tail))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+
+    fn store_for_test(dir: &Path) -> StateStore {
+        let state_dir = dir.join("state");
+        StateStore::with_paths(
+            state_dir.clone(),
+            state_dir.join("state.json"),
+            state_dir.join("state.lock"),
+            std::time::Duration::from_secs(1),
+        )
+    }
+
+    fn exchange(store: &StateStore, requests: &[Value]) -> Vec<Value> {
+        let input = requests
+            .iter()
+            .map(Value::to_string)
+            .collect::<Vec<_>>()
+            .join("\n");
+        let mut output = Vec::new();
+        serve(store, false, input.as_bytes(), &mut output).unwrap();
+        String::from_utf8(output)
+            .unwrap()
+            .lines()
+            .map(|line| serde_json::from_str(line).unwrap())
+            .collect()
+    }
+
+    fn call(id: u64, tool: &str, arguments: Value) -> Value {
+        json!({
+            "jsonrpc": "2.0",
+            "id": id,
+            "method": "tools/call",
+            "params": {"name": tool, "arguments": arguments},
+        })
+    }
+
+    fn text(reply: &Value) -> &str {
+        reply["result"]["content"][0]["text"].as_str().unwrap()
+    }
+
+    #[test]
+    fn initializes_and_lists_tools() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path());
+
+        let replies = exchange(
+            &store,
+            &[
+                json!({"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"protocolVersion": "2025-03-26"}}),
+                json!({"jsonrpc": "2.0", "method": "notifications/initialized"}),
+                json!({"jsonrpc": "2.0", "id": 2, "method": "tools/list"}),
+                json!({"jsonrpc": "2.0", "id": 3, "method": "resources/list"}),
+            ],
+        );
+
+        assert_eq!(replies.len(), 3);
+        assert_eq!(replies[0]["result"]["protocolVersion"], "2025-03-26");
+        assert_eq!(replies[0]["result"]["serverInfo"]["name"], "gralph");
+        let tools: Vec<&str> = replies[1]["result"]["tools"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|tool| tool["name"].as_str().unwrap())
+            .collect();
+        assert_eq!(
+            tools,
+            [
+                "list_sessions",
+                "start_loop",
+                "stop_loop",
+                "get_prd_tasks",
+                "tail_logs"
+            ]
+        );
+        assert_eq!(replies[2]["id"], 3);
+        assert_eq!(replies[2]["error"]["code"], METHOD_NOT_FOUND);
+    }
+
+    #[test]
+    fn tools_read_sessions_tasks_and_logs() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path());
+        store.init_state().unwrap();
+        let project = temp.path().join("app");
+        fs::create_dir_all(project.join(".gralph")).unwrap();
+        fs::write(
+            project.join("PRD.md"),
+            "# App\n\n### Task A-1\n- **ID** A-1\n- **Context Bundle** `README.md`\n- **DoD** Done.\n- **Checklist**\n  * Item.\n- **Dependencies** none\n- [ ] A-1 Build it\n",
+        )
+        .unwrap();
+        fs::write(project.join(".gralph").join("app.log"), "one\ntwo\nthree\n").unwrap();
+        let dir = project.to_string_lossy().to_string();
+        store
+            .set_session(
+                "app",
+                &[
+                    ("dir", dir.as_str()),
+                    ("task_file", "PRD.md"),
+                    ("status", "failed"),
+                ],
+            )
+            .unwrap();
+
+        let replies = exchange(
+            &store,
+            &[
+                call(1, "list_sessions", json!({})),
+                call(2, "get_prd_tasks", json!({"name": "app"})),
+                call(3, "tail_logs", json!({"name": "app", "lines": 2})),
+                call(4, "tail_logs", json!({"name": "missing"})),
+                call(5, "no_such_tool", json!({})),
+            ],
+        );
+
+        let sessions: Value = serde_json::from_str(text(&replies[0])).unwrap();
+        assert_eq!(sessions["sessions"][0]["name"], "app");
+        assert_eq!(sessions["sessions"][0]["current_remaining"], 1);
+        let tasks: Value = serde_json::from_str(text(&replies[1])).unwrap();
+        assert_eq!(tasks["title"], "App");
+        assert_eq!(tasks["tasks"][0]["id"], "A-1");
+        assert_eq!(tasks["tasks"][0]["status"], "ready");
+        assert_eq!(text(&replies[2]), "two\nthree\n");
+        assert_eq!(replies[3]["result"]["isError"], true);
+        assert_eq!(text(&replies[3]), "Session not found: missing");
+        assert_eq!(replies[4]["error"]["code"], INVALID_PARAMS);
+    }
+
+    #[test]
+    fn start_loop_rejects_invalid_arguments_as_tool_errors() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path());
+
+        let replies = exchange(
+            &store,
+            &[
+                call(1, "start_loop", json!({"dir": "relative/path"})),
+                call(2, "start_loop", json!({"dir": "/tmp", "colour": "red"})),
+            ],
+        );
+
+        assert_eq!(replies[0]["result"]["isError"], true);
+        assert!(text(&replies[0]).contains("dir must be an absolute path"));
+        assert!(text(&replies[1]).starts_with("Invalid arguments: unknown field `colour`"));
+    }
+
+    #[test]
+    fn replies_to_unparseable_lines_with_a_parse_error() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path());
+        let mut output = Vec::new();
+
+        serve(&store, "not json\n\n".as_bytes(), &mut output).unwrap();
+
+        let reply: Value = serde_json::from_slice(&output).unwrap();
+        assert_eq!(reply["id"], Value::Null);
+        assert_eq!(reply["error"]["code"], PARSE_ERROR);
+    }
+}
@@ -0,0 +1,98 @@
+//! Offline mode for air-gapped runners, enabled with `--offline` or
+//! `GRALPH_OFFLINE`.
+//!
+//! Offline mode does not hang on network calls. Optional ones are skipped
+//! with a note: source search, webhooks, and the update check. Required
+//! ones fail fast: remote backends, `gralph update`, and tracker sync.
+//! The mode is resolved once at startup and handed to each network call,
+//! and `start` passes `--offline` on to the loops it spawns.
+
+use std::env;
+use std::net::IpAddr;
+
+pub const OFFLINE_ENV: &str = "GRALPH_OFFLINE";
+
+/// Whether `GRALPH_OFFLINE` turns offline mode on. Read once at startup;
+/// `--offline` turns it on as well.
+pub fn from_env() -> bool {
+    env::var(OFFLINE_ENV)
+        .map(|value| {
+            matches!(
+                value.trim().to_ascii_lowercase().as_str(),
+                "1" | "true" | "yes" | "on"
+            )
+        })
+        .unwrap_or(false)
+}
+
+/// Error message for an operation that cannot run without the network.
+pub fn refusal(operation: &str) -> String {
+    format!(
+        "{} needs network access, but offline mode is on (--offline or {})",
+        operation, OFFLINE_ENV
+    )
+}
+
+/// Whether `url` points at this machine, so reaching it works offline.
+pub fn is_local_url(url: &str) -> bool {
+    let Ok(parsed) = reqwest::Url::parse(url) else {
+        return false;
+    };
+    let Some(host) = parsed.host_str() else {
+        return false;
+    };
+    if host.eq_ignore_ascii_case("localhost") {
+        return true;
+    }
+    host.trim_start_matches('[')
+        .trim_end_matches(']')
+        .parse::<IpAddr>()
+        .map(|ip| ip.is_loopback())
+        .unwrap_or(false)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn from_env_reads_truthy_env_values() {
+        let _guard = crate::test_support::env_lock();
+        let previous = env::var(OFFLINE_ENV).ok();
+        for (value, expected) in [
+            ("1", true),
+            ("true", true),
+            (" YES ", true),
+            ("on", true),
+            ("0", false),
+            ("false", false),
+            ("", false),
+        ] {
+            unsafe { env::set_var(OFFLINE_ENV, value) };
+            assert_eq!(from_env(), expected, "value {:?}", value);
+        }
+        unsafe { env::remove_var(OFFLINE_ENV) };
+        assert!(!from_env());
+        match previous {
+            Some(value) => unsafe { env::set_var(OFFLINE_ENV, value) },
+            None => unsafe { env::remove_var(OFFLINE_ENV) },
+        }
+    }
+
+    #[test]
+    fn is_local_url_accepts_only_loopback_hosts() {
+        assert!(is_local_url("http://localhost:11434"));
+        assert!(is_local_url("http://127.0.0.1:8080/v1"));
+        assert!(is_local_url("http://[::1]:11434"));
+        assert!(!is_local_url("https://api.openai.com/v1"));
+        assert!(!is_local_url("http://10.0.0.5:11434"));
+        assert!(!is_local_url("not a url"));
+    }
+
+    #[test]
+    fn refusal_names_the_operation_and_switch() {
+        let message = refusal("gralph update");
+        assert!(message.starts_with("gralph update needs network access"));
+        assert!(message.contains(OFFLINE_ENV));
+    }
+}
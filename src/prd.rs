@@ -1,8 +1,9 @@
+use crate::backend::BACKEND_NAMES;
 use crate::task::{
     is_task_block_end, is_task_header, is_unchecked_line, task_blocks_from_contents,
 };
 use serde_json::Value;
-use std::collections::HashSet;
+use std::collections::{HashMap, HashSet};
 use std::fmt;
 use std::fs;
 use std::io;
@@ -19,19 +20,116 @@ pub struct StackDetection {
     pub package_managers: Vec<String>,
     pub evidence: Vec<String>,
     pub selected_ids: Vec<String>,
+    pub packages: Vec<StackPackage>,
+}
+
+/// Stacks found in one directory of a monorepo. Paths are relative to the
+/// detection root, which is reported as `.`.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct StackPackage {
+    pub path: String,
+    pub ids: Vec<String>,
+    pub evidence: Vec<String>,
+}
+
+/// Directories that never hold workspace packages worth scanning.
+const STACK_SKIP_DIRS: &[&str] = &[
+    "node_modules",
+    "target",
+    "vendor",
+    "dist",
+    "build",
+    "Pods",
+    "DerivedData",
+    "__pycache__",
+];
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PrdSeverity {
+    Error,
+    Warning,
+}
+
+impl PrdSeverity {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            PrdSeverity::Error => "error",
+            PrdSeverity::Warning => "warning",
+        }
+    }
+}
+
+/// A single finding from PRD validation. Only errors fail validation;
+/// warnings point at tasks that will likely give the backend too little to go on.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PrdIssue {
+    /// Stable identifier such as `missing-field` or `dod-too-short`.
+    pub code: &'static str,
+    pub severity: PrdSeverity,
+    /// 1-based line in the task file, when the issue has one.
+    pub line: Option<usize>,
+    pub task: Option<String>,
+    pub message: String,
+}
+
+impl PrdIssue {
+    fn error(code: &'static str, line: Option<usize>, task: Option<&str>, message: String) -> Self {
+        PrdIssue {
+            code,
+            severity: PrdSeverity::Error,
+            line,
+            task: task.map(|task| task.to_string()),
+            message,
+        }
+    }
+
+    fn warning(
+        code: &'static str,
+        line: Option<usize>,
+        task: Option<&str>,
+        message: String,
+    ) -> Self {
+        PrdIssue {
+            code,
+            severity: PrdSeverity::Warning,
+            line,
+            task: task.map(|task| task.to_string()),
+            message,
+        }
+    }
+
+    pub fn is_error(&self) -> bool {
+        self.severity == PrdSeverity::Error
+    }
 }
 
 #[derive(Debug, Clone)]
 pub struct PrdValidationError {
-    pub messages: Vec<String>,
+    pub issues: Vec<PrdIssue>,
+}
+
+impl PrdValidationError {
+    pub fn messages(&self) -> Vec<String> {
+        self.issues
+            .iter()
+            .map(|issue| issue.message.clone())
+            .collect()
+    }
 }
 
 impl fmt::Display for PrdValidationError {
     fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
-        write!(f, "{}", self.messages.join("\n"))
+        write!(f, "{}", self.messages().join("\n"))
     }
 }
 
+/// A change made by [`prd_fix_contents`], reported against the original line.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PrdFix {
+    pub line: usize,
+    pub description: String,
+}
+
 impl std::error::Error for PrdValidationError {}
 
 #[derive(Debug)]
@@ -91,34 +189,68 @@ pub fn prd_validate_file(
     allow_missing_context: bool,
     base_dir_override: Option<&Path>,
 ) -> Result<(), PrdValidationError> {
-    let mut errors = Vec::new();
+    errors_only(prd_lint_file(
+        task_file,
+        allow_missing_context,
+        base_dir_override,
+    ))
+}
+
+pub fn prd_validate_contents(
+    contents: &str,
+    task_file: &Path,
+    allow_missing_context: bool,
+    base_dir: Option<&Path>,
+) -> Result<(), PrdValidationError> {
+    errors_only(prd_lint_contents(
+        contents,
+        task_file,
+        allow_missing_context,
+        base_dir,
+    ))
+}
 
+/// Validate a PRD and return every issue, warnings included.
+pub fn prd_lint_file(
+    task_file: &Path,
+    allow_missing_context: bool,
+    base_dir_override: Option<&Path>,
+) -> Vec<PrdIssue> {
     if task_file.as_os_str().is_empty() {
-        errors.push("Error: task_file is required".to_string());
-        return Err(PrdValidationError { messages: errors });
+        return vec![PrdIssue::error(
+            "missing-file",
+            None,
+            None,
+            "Error: task_file is required".to_string(),
+        )];
     }
     if !task_file.is_file() {
-        errors.push(format!(
-            "Error: Task file does not exist: {}",
-            task_file.display()
-        ));
-        return Err(PrdValidationError { messages: errors });
+        return vec![PrdIssue::error(
+            "missing-file",
+            None,
+            None,
+            format!("Error: Task file does not exist: {}", task_file.display()),
+        )];
     }
 
     let base_dir = resolve_base_dir(task_file, base_dir_override);
     let contents = match fs::read_to_string(task_file) {
         Ok(contents) => contents,
         Err(err) => {
-            errors.push(format!(
-                "Error: Task file could not be read: {}: {}",
-                task_file.display(),
-                err
-            ));
-            return Err(PrdValidationError { messages: errors });
+            return vec![PrdIssue::error(
+                "unreadable-file",
+                None,
+                None,
+                format!(
+                    "Error: Task file could not be read: {}: {}",
+                    task_file.display(),
+                    err
+                ),
+            )];
         }
     };
 
-    prd_validate_contents(
+    prd_lint_contents(
         &contents,
         task_file,
         allow_missing_context,
@@ -126,46 +258,77 @@ pub fn prd_validate_file(
     )
 }
 
-pub fn prd_validate_contents(
+pub fn prd_lint_contents(
     contents: &str,
     task_file: &Path,
     allow_missing_context: bool,
     base_dir: Option<&Path>,
-) -> Result<(), PrdValidationError> {
-    let mut errors = Vec::new();
+) -> Vec<PrdIssue> {
+    let mut issues = Vec::new();
 
     if contents.trim().is_empty() {
-        errors.push(format!(
-            "PRD validation error: {}: Task file is empty",
-            task_file.display()
+        issues.push(PrdIssue::error(
+            "empty-file",
+            None,
+            None,
+            format!(
+                "PRD validation error: {}: Task file is empty",
+                task_file.display()
+            ),
         ));
-        return Err(PrdValidationError { messages: errors });
+        return issues;
     }
 
-    if has_open_questions_section(contents) {
-        errors.push(format!(
-            "PRD validation error: {}: Open Questions section is not allowed",
-            task_file.display()
+    if let Some(line) = open_questions_line(contents) {
+        issues.push(PrdIssue::error(
+            "open-questions",
+            Some(line),
+            None,
+            format!(
+                "PRD validation error: {}: Open Questions section is not allowed",
+                task_file.display()
+            ),
         ));
     }
 
-    if let Some(stray_message) = validate_stray_unchecked(contents, task_file) {
-        errors.extend(stray_message);
-    }
+    issues.extend(lint_stray_unchecked(contents, task_file));
 
-    for block in task_blocks_from_contents(contents) {
-        errors.extend(validate_task_block(
-            &block,
+    let blocks = task_blocks_from_contents(contents);
+    let start_lines = task_block_start_lines(contents);
+    for (block, start_line) in blocks.iter().zip(start_lines) {
+        issues.extend(lint_task_block(
+            block,
+            start_line,
             task_file,
             allow_missing_context,
             base_dir,
         ));
     }
 
+    if !blocks.is_empty() && !has_success_criteria_section(contents) {
+        issues.push(PrdIssue::warning(
+            "missing-success-criteria",
+            None,
+            None,
+            format!(
+                "PRD validation warning: {}: No Success Criteria section",
+                task_file.display()
+            ),
+        ));
+    }
+
+    issues
+}
+
+fn errors_only(issues: Vec<PrdIssue>) -> Result<(), PrdValidationError> {
+    let errors = issues
+        .into_iter()
+        .filter(PrdIssue::is_error)
+        .collect::<Vec<_>>();
     if errors.is_empty() {
         Ok(())
     } else {
-        Err(PrdValidationError { messages: errors })
+        Err(PrdValidationError { issues: errors })
     }
 }
 
@@ -278,7 +441,157 @@ fn prd_sanitize_contents(
     output
 }
 
+/// Apply the safe subset of the generated-PRD sanitizer to a hand-written
+/// PRD. Stray and extra unchecked lines are demoted to plain bullets and
+/// Context Bundle paths that do not exist are dropped; everything else,
+/// including the preamble and any Open Questions section, is left alone.
+pub fn prd_fix_contents(contents: &str, base_dir: Option<&Path>) -> (String, Vec<PrdFix>) {
+    let newline = if contents.contains("\r\n") {
+        "\r\n"
+    } else {
+        "\n"
+    };
+    let lines = contents.lines().collect::<Vec<_>>();
+    let mut output = Vec::new();
+    let mut fixes = Vec::new();
+    let mut in_block = false;
+    let mut unchecked_seen = false;
+    let mut index = 0;
+
+    while index < lines.len() {
+        let line = lines[index];
+        let line_number = index + 1;
+        index += 1;
+
+        if is_task_header(line) {
+            in_block = true;
+            unchecked_seen = false;
+            output.push(line.to_string());
+            continue;
+        }
+        if in_block && is_task_block_end(line) {
+            in_block = false;
+        }
+
+        if let Some((indent, rest)) = unchecked_line_parts(line) {
+            if !in_block || unchecked_seen {
+                fixes.push(PrdFix {
+                    line: line_number,
+                    description: if in_block {
+                        "Demoted extra unchecked task line".to_string()
+                    } else {
+                        "Demoted unchecked line outside task block".to_string()
+                    },
+                });
+                output.push(format!("{}- {}", indent, rest));
+                continue;
+            }
+            unchecked_seen = true;
+        }
+
+        if in_block && line_has_named_field(line, "Context Bundle") {
+            let mut end = index;
+            while end < lines.len()
+                && !line_has_field(lines[end])
+                && !is_unchecked_line(lines[end])
+                && !is_task_header(lines[end])
+                && !is_task_block_end(lines[end])
+            {
+                end += 1;
+            }
+            let bundle = &lines[index - 1..end];
+            match fix_context_bundle(bundle, base_dir) {
+                Some((fixed, removed)) => {
+                    for entry in removed {
+                        fixes.push(PrdFix {
+                            line: line_number,
+                            description: format!("Removed missing Context Bundle path: {}", entry),
+                        });
+                    }
+                    output.push(fixed);
+                }
+                None => output.extend(bundle.iter().map(|line| line.to_string())),
+            }
+            index = end;
+            continue;
+        }
+
+        output.push(line.to_string());
+    }
+
+    let mut fixed = output.join(newline);
+    if contents.ends_with('\n') {
+        fixed.push_str(newline);
+    }
+    (fixed, fixes)
+}
+
+/// Apply [`prd_fix_contents`] to a file. Nothing is written when `dry_run`
+/// is set or when there is nothing to fix.
+pub fn prd_fix_file(task_file: &Path, dry_run: bool) -> Result<Vec<PrdFix>, PrdError> {
+    let contents = fs::read_to_string(task_file).map_err(|source| PrdError::Io {
+        path: task_file.to_path_buf(),
+        source,
+    })?;
+    let base_dir = resolve_base_dir(task_file, None);
+    let (fixed, fixes) = prd_fix_contents(&contents, base_dir.as_deref());
+    if !dry_run && !fixes.is_empty() {
+        fs::write(task_file, fixed).map_err(|source| PrdError::Io {
+            path: task_file.to_path_buf(),
+            source,
+        })?;
+    }
+    Ok(fixes)
+}
+
+/// Rewrite a Context Bundle (its field line plus continuation lines) when it
+/// lists paths that do not exist. Returns `None` when nothing needs to change.
+fn fix_context_bundle(bundle: &[&str], base_dir: Option<&Path>) -> Option<(String, Vec<String>)> {
+    let indent = context_bundle_indent(bundle[0])?;
+    let entries = extract_context_entries(&bundle.join("\n"));
+    let mut valid_entries = Vec::new();
+    let mut removed = Vec::new();
+    for entry in entries {
+        let trimmed = entry.trim();
+        if trimmed.is_empty() {
+            continue;
+        }
+        let display = context_display_path(trimmed, base_dir);
+        if context_entry_exists(&display, base_dir) {
+            add_unique(&mut valid_entries, &display);
+        } else {
+            removed.push(trimmed.to_string());
+        }
+    }
+    if removed.is_empty() {
+        return None;
+    }
+
+    if valid_entries.is_empty() {
+        if let Some(fallback) = pick_fallback_context(base_dir, &AllowedContext::default()) {
+            valid_entries.push(fallback);
+        }
+    }
+    let line = if valid_entries.is_empty() {
+        format!("{}- **Context Bundle**", indent)
+    } else {
+        let formatted = valid_entries
+            .iter()
+            .map(|entry| format!("`{}`", entry))
+            .collect::<Vec<_>>()
+            .join(", ");
+        format!("{}- **Context Bundle** {}", indent, formatted)
+    };
+    Some((line, removed))
+}
+
 pub fn prd_detect_stack(target_dir: &Path) -> StackDetection {
+    prd_detect_stack_with_depth(target_dir, 0)
+}
+
+/// Detect stacks in `target_dir` and in subdirectories up to `depth` levels
+/// below it. Each directory that identifies a stack is listed in `packages`.
+pub fn prd_detect_stack_with_depth(target_dir: &Path, depth: usize) -> StackDetection {
     let mut detection = StackDetection::default();
     if target_dir.as_os_str().is_empty() || !target_dir.is_dir() {
         return detection;
@@ -289,65 +602,79 @@ pub fn prd_detect_stack(target_dir: &Path) -> StackDetection {
         .unwrap_or_else(|_| target_dir.to_path_buf());
     detection.root = Some(root.clone());
 
-    let package_json = root.join("package.json");
+    for dir in stack_scan_dirs(&root, depth) {
+        let mut found = StackDetection {
+            root: Some(root.clone()),
+            ..StackDetection::default()
+        };
+        detect_stack_in_dir(&mut found, &dir);
+        merge_stack_detection(&mut detection, &root, &dir, found);
+    }
+
+    detection.selected_ids = detection.ids.clone();
+    detection
+}
+
+fn detect_stack_in_dir(detection: &mut StackDetection, dir: &Path) {
+    let package_json = dir.join("package.json");
     if package_json.is_file() {
         add_unique(&mut detection.ids, "Node.js");
         add_unique(&mut detection.runtimes, "Node.js");
         add_unique(&mut detection.languages, "JavaScript");
-        record_stack_file(&mut detection, &package_json);
+        record_stack_file(detection, &package_json);
 
-        let tsconfig = root.join("tsconfig.json");
+        let tsconfig = dir.join("tsconfig.json");
         if tsconfig.is_file() {
             add_unique(&mut detection.languages, "TypeScript");
-            record_stack_file(&mut detection, &tsconfig);
+            record_stack_file(detection, &tsconfig);
         }
 
-        let pnpm_lock = root.join("pnpm-lock.yaml");
+        let pnpm_lock = dir.join("pnpm-lock.yaml");
         if pnpm_lock.is_file() {
             add_unique(&mut detection.package_managers, "pnpm");
-            record_stack_file(&mut detection, &pnpm_lock);
+            record_stack_file(detection, &pnpm_lock);
         }
 
-        let yarn_lock = root.join("yarn.lock");
+        let yarn_lock = dir.join("yarn.lock");
         if yarn_lock.is_file() {
             add_unique(&mut detection.package_managers, "yarn");
-            record_stack_file(&mut detection, &yarn_lock);
+            record_stack_file(detection, &yarn_lock);
         }
 
-        let npm_lock = root.join("package-lock.json");
+        let npm_lock = dir.join("package-lock.json");
         if npm_lock.is_file() {
             add_unique(&mut detection.package_managers, "npm");
-            record_stack_file(&mut detection, &npm_lock);
+            record_stack_file(detection, &npm_lock);
         }
 
-        let bun_lock = root.join("bun.lockb");
+        let bun_lock = dir.join("bun.lockb");
         if bun_lock.is_file() {
             add_unique(&mut detection.runtimes, "Bun");
             add_unique(&mut detection.package_managers, "bun");
-            record_stack_file(&mut detection, &bun_lock);
+            record_stack_file(detection, &bun_lock);
         }
 
-        let bunfig = root.join("bunfig.toml");
+        let bunfig = dir.join("bunfig.toml");
         if bunfig.is_file() {
             add_unique(&mut detection.runtimes, "Bun");
             add_unique(&mut detection.package_managers, "bun");
-            record_stack_file(&mut detection, &bunfig);
+            record_stack_file(detection, &bunfig);
         }
 
-        add_framework_if_file_exists(&mut detection, &root, "next.config.js", "Next.js");
-        add_framework_if_file_exists(&mut detection, &root, "next.config.mjs", "Next.js");
-        add_framework_if_file_exists(&mut detection, &root, "next.config.cjs", "Next.js");
-        add_framework_if_file_exists(&mut detection, &root, "nuxt.config.js", "Nuxt");
-        add_framework_if_file_exists(&mut detection, &root, "nuxt.config.ts", "Nuxt");
-        add_framework_if_file_exists(&mut detection, &root, "svelte.config.js", "Svelte");
-        add_framework_if_file_exists(&mut detection, &root, "svelte.config.ts", "Svelte");
+        add_framework_if_file_exists(detection, dir, "next.config.js", "Next.js");
+        add_framework_if_file_exists(detection, dir, "next.config.mjs", "Next.js");
+        add_framework_if_file_exists(detection, dir, "next.config.cjs", "Next.js");
+        add_framework_if_file_exists(detection, dir, "nuxt.config.js", "Nuxt");
+        add_framework_if_file_exists(detection, dir, "nuxt.config.ts", "Nuxt");
+        add_framework_if_file_exists(detection, dir, "svelte.config.js", "Svelte");
+        add_framework_if_file_exists(detection, dir, "svelte.config.ts", "Svelte");
 
-        add_tool_if_file_exists(&mut detection, &root, "vite.config.js", "Vite");
-        add_tool_if_file_exists(&mut detection, &root, "vite.config.ts", "Vite");
-        add_tool_if_file_exists(&mut detection, &root, "vite.config.mjs", "Vite");
+        add_tool_if_file_exists(detection, dir, "vite.config.js", "Vite");
+        add_tool_if_file_exists(detection, dir, "vite.config.ts", "Vite");
+        add_tool_if_file_exists(detection, dir, "vite.config.mjs", "Vite");
 
-        add_framework_if_file_exists(&mut detection, &root, "angular.json", "Angular");
-        add_framework_if_file_exists(&mut detection, &root, "vue.config.js", "Vue");
+        add_framework_if_file_exists(detection, dir, "angular.json", "Angular");
+        add_framework_if_file_exists(detection, dir, "vue.config.js", "Vue");
 
         if json_has_dependency(&package_json, "react") {
             add_unique(&mut detection.frameworks, "React");
@@ -378,27 +705,27 @@ pub fn prd_detect_stack(target_dir: &Path) -> StackDetection {
         }
     }
 
-    let go_mod = root.join("go.mod");
+    let go_mod = dir.join("go.mod");
     if go_mod.is_file() {
         add_unique(&mut detection.ids, "Go");
         add_unique(&mut detection.languages, "Go");
         add_unique(&mut detection.tools, "Go modules");
-        record_stack_file(&mut detection, &go_mod);
+        record_stack_file(detection, &go_mod);
     }
 
-    let cargo = root.join("Cargo.toml");
+    let cargo = dir.join("Cargo.toml");
     if cargo.is_file() {
         add_unique(&mut detection.ids, "Rust");
         add_unique(&mut detection.languages, "Rust");
         add_unique(&mut detection.tools, "Cargo");
-        record_stack_file(&mut detection, &cargo);
+        record_stack_file(detection, &cargo);
     }
 
-    let pyproject = root.join("pyproject.toml");
-    let requirements = root.join("requirements.txt");
-    let poetry_lock = root.join("poetry.lock");
-    let pipfile = root.join("Pipfile");
-    let pipfile_lock = root.join("Pipfile.lock");
+    let pyproject = dir.join("pyproject.toml");
+    let requirements = dir.join("requirements.txt");
+    let poetry_lock = dir.join("poetry.lock");
+    let pipfile = dir.join("Pipfile");
+    let pipfile_lock = dir.join("Pipfile.lock");
     if pyproject.is_file()
         || requirements.is_file()
         || poetry_lock.is_file()
@@ -408,13 +735,13 @@ pub fn prd_detect_stack(target_dir: &Path) -> StackDetection {
         add_unique(&mut detection.ids, "Python");
         add_unique(&mut detection.languages, "Python");
         if pyproject.is_file() {
-            record_stack_file(&mut detection, &pyproject);
+            record_stack_file(detection, &pyproject);
             if contains_case_insensitive(&pyproject, "[tool.poetry]") {
                 add_unique(&mut detection.tools, "Poetry");
             }
         }
         if requirements.is_file() {
-            record_stack_file(&mut detection, &requirements);
+            record_stack_file(detection, &requirements);
             if requirements_contains(&requirements, "django") {
                 add_unique(&mut detection.frameworks, "Django");
             }
@@ -426,13 +753,13 @@ pub fn prd_detect_stack(target_dir: &Path) -> StackDetection {
             }
         }
         if poetry_lock.is_file() {
-            record_stack_file(&mut detection, &poetry_lock);
+            record_stack_file(detection, &poetry_lock);
         }
         if pipfile.is_file() {
-            record_stack_file(&mut detection, &pipfile);
+            record_stack_file(detection, &pipfile);
         }
         if pipfile_lock.is_file() {
-            record_stack_file(&mut detection, &pipfile_lock);
+            record_stack_file(detection, &pipfile_lock);
         }
 
         if pyproject.is_file()
@@ -452,11 +779,11 @@ pub fn prd_detect_stack(target_dir: &Path) -> StackDetection {
         }
     }
 
-    let gemfile = root.join("Gemfile");
+    let gemfile = dir.join("Gemfile");
     if gemfile.is_file() {
         add_unique(&mut detection.ids, "Ruby");
         add_unique(&mut detection.languages, "Ruby");
-        record_stack_file(&mut detection, &gemfile);
+        record_stack_file(detection, &gemfile);
         if contains_case_insensitive(&gemfile, "rails") {
             add_unique(&mut detection.frameworks, "Rails");
         }
@@ -465,65 +792,65 @@ pub fn prd_detect_stack(target_dir: &Path) -> StackDetection {
         }
     }
 
-    let mix = root.join("mix.exs");
+    let mix = dir.join("mix.exs");
     if mix.is_file() {
         add_unique(&mut detection.ids, "Elixir");
         add_unique(&mut detection.languages, "Elixir");
-        record_stack_file(&mut detection, &mix);
+        record_stack_file(detection, &mix);
         if contains_case_insensitive(&mix, "phoenix") {
             add_unique(&mut detection.frameworks, "Phoenix");
         }
     }
 
-    let composer = root.join("composer.json");
+    let composer = dir.join("composer.json");
     if composer.is_file() {
         add_unique(&mut detection.ids, "PHP");
         add_unique(&mut detection.languages, "PHP");
-        record_stack_file(&mut detection, &composer);
+        record_stack_file(detection, &composer);
         if contains_case_insensitive(&composer, "laravel") {
             add_unique(&mut detection.frameworks, "Laravel");
         }
     }
 
-    let pom = root.join("pom.xml");
+    let pom = dir.join("pom.xml");
     if pom.is_file() {
         add_unique(&mut detection.ids, "Java");
         add_unique(&mut detection.languages, "Java");
         add_unique(&mut detection.tools, "Maven");
-        record_stack_file(&mut detection, &pom);
+        record_stack_file(detection, &pom);
         if contains_case_insensitive(&pom, "spring-boot") {
             add_unique(&mut detection.frameworks, "Spring Boot");
         }
     }
 
-    let gradle = root.join("build.gradle");
+    let gradle = dir.join("build.gradle");
     if gradle.is_file() {
         add_unique(&mut detection.ids, "Java");
         add_unique(&mut detection.languages, "Java");
         add_unique(&mut detection.tools, "Gradle");
-        record_stack_file(&mut detection, &gradle);
+        record_stack_file(detection, &gradle);
         if contains_case_insensitive(&gradle, "spring-boot") {
             add_unique(&mut detection.frameworks, "Spring Boot");
         }
     }
-    let gradle_kts = root.join("build.gradle.kts");
+    let gradle_kts = dir.join("build.gradle.kts");
     if gradle_kts.is_file() {
         add_unique(&mut detection.ids, "Java");
         add_unique(&mut detection.languages, "Java");
         add_unique(&mut detection.tools, "Gradle");
-        record_stack_file(&mut detection, &gradle_kts);
+        record_stack_file(detection, &gradle_kts);
         if contains_case_insensitive(&gradle_kts, "spring-boot") {
             add_unique(&mut detection.frameworks, "Spring Boot");
         }
     }
 
     let mut has_dotnet = false;
-    if let Ok(entries) = fs::read_dir(&root) {
+    if let Ok(entries) = fs::read_dir(dir) {
         for entry in entries.flatten() {
             let path = entry.path();
             if let Some(ext) = path.extension().and_then(|ext| ext.to_str()) {
                 if ext.eq_ignore_ascii_case("csproj") || ext.eq_ignore_ascii_case("sln") {
-                    record_stack_file(&mut detection, &path);
+                    record_stack_file(detection, &path);
                     has_dotnet = true;
                 }
             }
@@ -534,29 +861,29 @@ pub fn prd_detect_stack(target_dir: &Path) -> StackDetection {
         add_unique(&mut detection.languages, "C#");
     }
 
-    let dockerfile = root.join("Dockerfile");
+    let dockerfile = dir.join("Dockerfile");
     if dockerfile.is_file() {
         add_unique(&mut detection.tools, "Docker");
-        record_stack_file(&mut detection, &dockerfile);
+        record_stack_file(detection, &dockerfile);
     }
-    let compose_yml = root.join("docker-compose.yml");
+    let compose_yml = dir.join("docker-compose.yml");
     if compose_yml.is_file() {
         add_unique(&mut detection.tools, "Docker Compose");
-        record_stack_file(&mut detection, &compose_yml);
+        record_stack_file(detection, &compose_yml);
     }
-    let compose_yaml = root.join("docker-compose.yaml");
+    let compose_yaml = dir.join("docker-compose.yaml");
     if compose_yaml.is_file() {
         add_unique(&mut detection.tools, "Docker Compose");
-        record_stack_file(&mut detection, &compose_yaml);
+        record_stack_file(detection, &compose_yaml);
     }
-    let makefile = root.join("Makefile");
+    let makefile = dir.join("Makefile");
     if makefile.is_file() {
         add_unique(&mut detection.tools, "Make");
-        record_stack_file(&mut detection, &makefile);
+        record_stack_file(detection, &makefile);
     }
 
     let mut has_terraform = false;
-    if let Ok(entries) = fs::read_dir(&root) {
+    if let Ok(entries) = fs::read_dir(dir) {
         for entry in entries.flatten() {
             let path = entry.path();
             if path
@@ -565,7 +892,7 @@ pub fn prd_detect_stack(target_dir: &Path) -> StackDetection {
                 .map(|ext| ext.eq_ignore_ascii_case("tf"))
                 .unwrap_or(false)
             {
-                record_stack_file(&mut detection, &path);
+                record_stack_file(detection, &path);
                 has_terraform = true;
             }
         }
@@ -574,8 +901,347 @@ pub fn prd_detect_stack(target_dir: &Path) -> StackDetection {
         add_unique(&mut detection.tools, "Terraform");
     }
 
-    detection.selected_ids = detection.ids.clone();
-    detection
+    let mut has_kotlin = false;
+    let mut has_android = false;
+    for path in [&gradle, &gradle_kts] {
+        if path.is_file() {
+            has_kotlin |= contains_case_insensitive(path, "kotlin");
+            has_android |= contains_case_insensitive(path, "com.android");
+        }
+    }
+    if has_kotlin {
+        add_unique(&mut detection.ids, "Kotlin");
+        add_unique(&mut detection.languages, "Kotlin");
+    }
+    for manifest in [
+        dir.join("AndroidManifest.xml"),
+        dir.join("src/main/AndroidManifest.xml"),
+        dir.join("app/src/main/AndroidManifest.xml"),
+    ] {
+        if manifest.is_file() {
+            record_stack_file(detection, &manifest);
+            has_android = true;
+        }
+    }
+    if has_android {
+        add_unique(&mut detection.ids, "Android");
+        add_unique(&mut detection.frameworks, "Android");
+    }
+
+    let package_swift = dir.join("Package.swift");
+    if package_swift.is_file() {
+        add_unique(&mut detection.ids, "Swift");
+        add_unique(&mut detection.languages, "Swift");
+        add_unique(&mut detection.package_managers, "Swift Package Manager");
+        record_stack_file(detection, &package_swift);
+    }
+    let podfile = dir.join("Podfile");
+    if podfile.is_file() {
+        add_unique(&mut detection.package_managers, "CocoaPods");
+        record_stack_file(detection, &podfile);
+    }
+
+    let pubspec = dir.join("pubspec.yaml");
+    if pubspec.is_file() {
+        add_unique(&mut detection.ids, "Dart");
+        add_unique(&mut detection.languages, "Dart");
+        add_unique(&mut detection.package_managers, "pub");
+        record_stack_file(detection, &pubspec);
+        if contains_case_insensitive(&pubspec, "flutter") {
+            add_unique(&mut detection.ids, "Flutter");
+            add_unique(&mut detection.frameworks, "Flutter");
+        }
+    }
+
+    for name in ["deno.json", "deno.jsonc"] {
+        let deno = dir.join(name);
+        if deno.is_file() {
+            add_unique(&mut detection.ids, "Deno");
+            add_unique(&mut detection.runtimes, "Deno");
+            add_unique(&mut detection.languages, "TypeScript");
+            record_stack_file(detection, &deno);
+        }
+    }
+
+    let build_zig = dir.join("build.zig");
+    if build_zig.is_file() {
+        add_unique(&mut detection.ids, "Zig");
+        add_unique(&mut detection.languages, "Zig");
+        add_unique(&mut detection.tools, "Zig build");
+        record_stack_file(detection, &build_zig);
+    }
+
+    let cmake = dir.join("CMakeLists.txt");
+    if cmake.is_file() {
+        add_unique(&mut detection.ids, "C/C++");
+        add_unique(&mut detection.languages, "C/C++");
+        add_unique(&mut detection.tools, "CMake");
+        record_stack_file(detection, &cmake);
+    }
+
+    let stack_yaml = dir.join("stack.yaml");
+    if stack_yaml.is_file() {
+        add_unique(&mut detection.ids, "Haskell");
+        add_unique(&mut detection.languages, "Haskell");
+        add_unique(&mut detection.tools, "Stack");
+        record_stack_file(detection, &stack_yaml);
+    }
+    let cabal_project = dir.join("cabal.project");
+    if cabal_project.is_file() {
+        add_unique(&mut detection.ids, "Haskell");
+        add_unique(&mut detection.languages, "Haskell");
+        add_unique(&mut detection.tools, "Cabal");
+        record_stack_file(detection, &cabal_project);
+    }
+
+    let build_sbt = dir.join("build.sbt");
+    if build_sbt.is_file() {
+        add_unique(&mut detection.ids, "Scala");
+        add_unique(&mut detection.languages, "Scala");
+        add_unique(&mut detection.tools, "sbt");
+        record_stack_file(detection, &build_sbt);
+    }
+
+    let mut entries: Vec<PathBuf> = fs::read_dir(dir)
+        .map(|entries| entries.flatten().map(|entry| entry.path()).collect())
+        .unwrap_or_default();
+    entries.sort();
+    for path in entries {
+        let name = path
+            .file_name()
+            .map(|name| name.to_string_lossy().to_string())
+            .unwrap_or_default();
+        let ext = path
+            .extension()
+            .and_then(|ext| ext.to_str())
+            .unwrap_or("")
+            .to_ascii_lowercase();
+        if path.is_dir() {
+            if ext == "xcodeproj" || ext == "xcworkspace" {
+                add_unique(&mut detection.ids, "Swift");
+                add_unique(&mut detection.languages, "Swift");
+                add_unique(&mut detection.tools, "Xcode");
+                record_stack_file(detection, &path);
+            }
+            continue;
+        }
+        match ext.as_str() {
+            "cabal" => {
+                add_unique(&mut detection.ids, "Haskell");
+                add_unique(&mut detection.languages, "Haskell");
+                add_unique(&mut detection.tools, "Cabal");
+                record_stack_file(detection, &path);
+            }
+            "yaml" | "yml" => {
+                if name == "Chart.yaml" {
+                    add_unique(&mut detection.tools, "Kubernetes");
+                    add_unique(&mut detection.tools, "Helm");
+                    record_stack_file(detection, &path);
+                } else if name.starts_with("kustomization.") {
+                    add_unique(&mut detection.tools, "Kubernetes");
+                    add_unique(&mut detection.tools, "Kustomize");
+                    record_stack_file(detection, &path);
+                } else if is_kubernetes_manifest(&path) {
+                    add_unique(&mut detection.tools, "Kubernetes");
+                    record_stack_file(detection, &path);
+                }
+            }
+            _ => {}
+        }
+    }
+}
+
+/// `root` followed by its subdirectories, breadth first, down to `depth`.
+/// Hidden directories and dependency or build output directories are skipped.
+fn stack_scan_dirs(root: &Path, depth: usize) -> Vec<PathBuf> {
+    let mut dirs = vec![root.to_path_buf()];
+    let mut level = vec![root.to_path_buf()];
+    for _ in 0..depth {
+        let mut next = Vec::new();
+        for dir in &level {
+            let Ok(entries) = fs::read_dir(dir) else {
+                continue;
+            };
+            let mut children: Vec<PathBuf> = entries
+                .flatten()
+                .filter(|entry| entry.file_type().map(|kind| kind.is_dir()).unwrap_or(false))
+                .map(|entry| entry.path())
+                .filter(|path| !skip_stack_dir(path))
+                .collect();
+            children.sort();
+            next.extend(children);
+        }
+        if next.is_empty() {
+            break;
+        }
+        dirs.extend(next.iter().cloned());
+        level = next;
+    }
+    dirs
+}
+
+fn skip_stack_dir(path: &Path) -> bool {
+    let Some(name) = path.file_name().and_then(|name| name.to_str()) else {
+        return true;
+    };
+    if name.starts_with('.') || STACK_SKIP_DIRS.contains(&name) {
+        return true;
+    }
+    matches!(
+        path.extension().and_then(|ext| ext.to_str()),
+        Some("xcodeproj" | "xcworkspace")
+    )
+}
+
+fn merge_stack_detection(
+    detection: &mut StackDetection,
+    root: &Path,
+    dir: &Path,
+    found: StackDetection,
+) {
+    for (target, values) in [
+        (&mut detection.ids, &found.ids),
+        (&mut detection.languages, &found.languages),
+        (&mut detection.frameworks, &found.frameworks),
+        (&mut detection.tools, &found.tools),
+        (&mut detection.runtimes, &found.runtimes),
+        (&mut detection.package_managers, &found.package_managers),
+        (&mut detection.evidence, &found.evidence),
+    ] {
+        for value in values {
+            add_unique(target, value);
+        }
+    }
+    if found.ids.is_empty() {
+        return;
+    }
+    let path = match dir.strip_prefix(root) {
+        Ok(rel) if !rel.as_os_str().is_empty() => rel.to_string_lossy().to_string(),
+        _ => ".".to_string(),
+    };
+    detection.packages.push(StackPackage {
+        path,
+        ids: found.ids,
+        evidence: found.evidence,
+    });
+}
+
+/// A YAML file with top-level `apiVersion:` and `kind:` keys.
+fn is_kubernetes_manifest(path: &Path) -> bool {
+    let Ok(contents) = fs::read_to_string(path) else {
+        return false;
+    };
+    let has_key = |key: &str| contents.lines().any(|line| line.starts_with(key));
+    has_key("apiVersion:") && has_key("kind:")
+}
+
+/// Most TODO and FIXME comments `prd create --from-todos` passes on.
+pub const TODO_SCAN_LIMIT: usize = 100;
+
+/// Files larger than this are not scanned for TODO comments.
+const TODO_MAX_FILE_BYTES: u64 = 512 * 1024;
+
+/// A TODO or FIXME comment found in the repository.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TodoComment {
+    /// Path relative to the scanned directory.
+    pub path: String,
+    /// 1-based line number.
+    pub line: usize,
+    /// `TODO` or `FIXME`.
+    pub marker: &'static str,
+    pub text: String,
+}
+
+impl fmt::Display for TodoComment {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}:{} {}", self.path, self.line, self.marker)?;
+        if !self.text.is_empty() {
+            write!(f, ": {}", self.text)?;
+        }
+        Ok(())
+    }
+}
+
+/// TODO and FIXME comments in the text files under `dir`, in path order.
+/// Hidden and build directories are skipped like in stack detection, as are
+/// files that are large or not UTF-8.
+pub fn prd_scan_todos(dir: &Path) -> Vec<TodoComment> {
+    let mut todos = Vec::new();
+    scan_todo_dir(dir, dir, &mut todos);
+    todos
+}
+
+fn scan_todo_dir(root: &Path, dir: &Path, todos: &mut Vec<TodoComment>) {
+    let Ok(entries) = fs::read_dir(dir) else {
+        return;
+    };
+    let mut paths: Vec<PathBuf> = entries.flatten().map(|entry| entry.path()).collect();
+    paths.sort();
+    for path in paths {
+        if path.is_dir() {
+            if !skip_stack_dir(&path) {
+                scan_todo_dir(root, &path, todos);
+            }
+            continue;
+        }
+        if !fs::metadata(&path).is_ok_and(|meta| meta.len() <= TODO_MAX_FILE_BYTES) {
+            continue;
+        }
+        let Ok(contents) = fs::read_to_string(&path) else {
+            continue;
+        };
+        let relative = path.strip_prefix(root).unwrap_or(&path);
+        for (index, line) in contents.lines().enumerate() {
+            if let Some((marker, text)) = todo_comment(line) {
+                todos.push(TodoComment {
+                    path: relative.to_string_lossy().to_string(),
+                    line: index + 1,
+                    marker,
+                    text,
+                });
+            }
+        }
+    }
+}
+
+/// The marker and text of a `TODO` or `FIXME` word that follows a comment
+/// opener (`//`, `#`, `/*`, `*`, `--`, `<!--`, `;`) on the line.
+fn todo_comment(line: &str) -> Option<(&'static str, String)> {
+    let (index, marker) = ["TODO", "FIXME"]
+        .into_iter()
+        .filter_map(|marker| {
+            line.match_indices(marker)
+                .find(|(index, _)| {
+                    let before = line[..*index].chars().next_back();
+                    let after = line[index + marker.len()..].chars().next();
+                    !before.is_some_and(|ch| ch.is_alphanumeric() || ch == '_')
+                        && !after.is_some_and(|ch| ch.is_alphanumeric() || ch == '_')
+                })
+                .map(|(index, _)| (index, marker))
+        })
+        .min_by_key(|(index, _)| *index)?;
+    let before = &line[..index];
+    let in_comment = ["//", "#", "/*", "--", "<!--", ";"]
+        .iter()
+        .any(|opener| before.contains(opener))
+        || before.trim_start().starts_with('*');
+    if !in_comment {
+        return None;
+    }
+    let mut rest = line[index + marker.len()..].trim_start();
+    if rest.starts_with('(') {
+        if let Some(end) = rest.find(')') {
+            rest = rest[end + 1..].trim_start();
+        }
+    }
+    let text = rest
+        .trim_start_matches(':')
+        .trim()
+        .trim_end_matches("*/")
+        .trim_end_matches("-->")
+        .trim();
+    Some((marker, text.to_string()))
 }
 
 pub fn prd_format_stack_summary(detection: &StackDetection, heading_level: u8) -> String {
@@ -611,6 +1277,18 @@ pub fn prd_format_stack_summary(detection: &StackDetection, heading_level: u8) -
         }
     }
 
+    if detection.packages.iter().any(|package| package.path != ".") {
+        output.push_str("\nPackages:\n");
+        for package in &detection.packages {
+            output.push_str(&format!(
+                "- {}: {} ({})\n",
+                package.path,
+                package.ids.join(", "),
+                package.evidence.join(", ")
+            ));
+        }
+    }
+
     output
 }
 
@@ -765,8 +1443,8 @@ fn canonicalize_for_compare(path: &Path) -> PathBuf {
     path.to_path_buf()
 }
 
-fn has_open_questions_section(contents: &str) -> bool {
-    for line in contents.lines() {
+fn open_questions_line(contents: &str) -> Option<usize> {
+    for (index, line) in contents.lines().enumerate() {
         let trimmed = line.trim_start();
         if !trimmed.starts_with('#') {
             continue;
@@ -777,14 +1455,34 @@ fn has_open_questions_section(contents: &str) -> bool {
         }
         let rest = without_hashes.trim_start();
         if rest.starts_with("Open Questions") {
-            return true;
+            return Some(index + 1);
         }
     }
-    false
+    None
+}
+
+fn has_success_criteria_section(contents: &str) -> bool {
+    contents.lines().any(|line| {
+        is_heading(line)
+            && line
+                .trim_start()
+                .trim_start_matches('#')
+                .trim()
+                .eq_ignore_ascii_case("success criteria")
+    })
+}
+
+fn task_block_start_lines(contents: &str) -> Vec<usize> {
+    contents
+        .lines()
+        .enumerate()
+        .filter(|(_, line)| is_task_header(line))
+        .map(|(index, _)| index + 1)
+        .collect()
 }
 
-fn validate_stray_unchecked(contents: &str, task_file: &Path) -> Option<Vec<String>> {
-    let mut errors = Vec::new();
+fn lint_stray_unchecked(contents: &str, task_file: &Path) -> Vec<PrdIssue> {
+    let mut issues = Vec::new();
     let mut in_block = false;
     for (index, line) in contents.lines().enumerate() {
         if is_task_header(line) {
@@ -794,72 +1492,222 @@ fn validate_stray_unchecked(contents: &str, task_file: &Path) -> Option<Vec<Stri
         }
 
         if !in_block && is_unchecked_line(line) {
-            errors.push(format!(
-                "PRD validation error: {}: line {}: Unchecked task line outside task block",
-                task_file.display(),
-                index + 1
+            issues.push(PrdIssue::error(
+                "stray-unchecked",
+                Some(index + 1),
+                None,
+                format!(
+                    "PRD validation error: {}: line {}: Unchecked task line outside task block",
+                    task_file.display(),
+                    index + 1
+                ),
             ));
         }
     }
-
-    if errors.is_empty() {
-        None
-    } else {
-        Some(errors)
-    }
+    issues
 }
 
+#[cfg(test)]
 fn validate_task_block(
     block: &str,
     task_file: &Path,
     allow_missing_context: bool,
     base_dir: Option<&Path>,
 ) -> Vec<String> {
-    let mut errors = Vec::new();
+    lint_task_block(block, 1, task_file, allow_missing_context, base_dir)
+        .into_iter()
+        .filter(PrdIssue::is_error)
+        .map(|issue| issue.message)
+        .collect()
+}
+
+/// Fewer words than this in a DoD is flagged as lacking detail.
+const DOD_MIN_WORDS: usize = 3;
+
+fn lint_task_block(
+    block: &str,
+    start_line: usize,
+    task_file: &Path,
+    allow_missing_context: bool,
+    base_dir: Option<&Path>,
+) -> Vec<PrdIssue> {
+    let mut issues = Vec::new();
     let task_label = task_label(block);
+    let task = Some(task_label.as_str());
     let fields = ["ID", "Context Bundle", "DoD", "Checklist", "Dependencies"];
+    let field_line = |field: &str| {
+        block
+            .lines()
+            .position(|line| line_has_named_field(line, field))
+            .map(|offset| start_line + offset)
+    };
 
     for field in fields {
         if !block_has_field(block, field) {
-            errors.push(format!(
-                "PRD validation error: {}: {}: Missing required field: {}",
-                task_file.display(),
-                task_label,
-                field
+            issues.push(PrdIssue::error(
+                "missing-field",
+                Some(start_line),
+                task,
+                format!(
+                    "PRD validation error: {}: {}: Missing required field: {}",
+                    task_file.display(),
+                    task_label,
+                    field
+                ),
             ));
         }
     }
 
-    let unchecked_count = block.lines().filter(|line| is_unchecked_line(line)).count();
-    if unchecked_count == 0 {
-        errors.push(format!(
-            "PRD validation error: {}: {}: Missing unchecked task line",
-            task_file.display(),
-            task_label
+    let unchecked_lines = block
+        .lines()
+        .enumerate()
+        .filter(|(_, line)| is_unchecked_line(line))
+        .map(|(offset, _)| start_line + offset)
+        .collect::<Vec<_>>();
+    if unchecked_lines.is_empty() {
+        issues.push(PrdIssue::error(
+            "missing-unchecked",
+            Some(start_line),
+            task,
+            format!(
+                "PRD validation error: {}: {}: Missing unchecked task line",
+                task_file.display(),
+                task_label
+            ),
         ));
-    } else if unchecked_count > 1 {
-        errors.push(format!(
-            "PRD validation error: {}: {}: Multiple unchecked task lines ({})",
-            task_file.display(),
-            task_label,
-            unchecked_count
+    } else if unchecked_lines.len() > 1 {
+        issues.push(PrdIssue::error(
+            "multiple-unchecked",
+            Some(unchecked_lines[1]),
+            task,
+            format!(
+                "PRD validation error: {}: {}: Multiple unchecked task lines ({})",
+                task_file.display(),
+                task_label,
+                unchecked_lines.len()
+            ),
         ));
     }
 
-    if !allow_missing_context {
-        let mut context_entries = Vec::new();
-        for entry in extract_context_entries(block) {
-            let trimmed = entry.trim();
+    if let Some(words) = field_word_count(block, "DoD") {
+        if words < DOD_MIN_WORDS {
+            issues.push(PrdIssue::warning(
+                "dod-too-short",
+                field_line("DoD"),
+                task,
+                format!(
+                    "PRD validation warning: {}: {}: DoD lacks detail ({} words)",
+                    task_file.display(),
+                    task_label,
+                    words
+                ),
+            ));
+        }
+    }
+
+    if field_word_count(block, "Checklist") == Some(0) {
+        issues.push(PrdIssue::warning(
+            "empty-checklist",
+            field_line("Checklist"),
+            task,
+            format!(
+                "PRD validation warning: {}: {}: Checklist has no items",
+                task_file.display(),
+                task_label
+            ),
+        ));
+    }
+
+    if let Some(backend) = prd_task_backend(block) {
+        if !BACKEND_NAMES.contains(&backend.as_str()) {
+            issues.push(PrdIssue::error(
+                "unknown-backend",
+                field_line("Backend"),
+                task,
+                format!(
+                    "PRD validation error: {}: {}: Unknown backend: {} (expected one of: {})",
+                    task_file.display(),
+                    task_label,
+                    backend,
+                    BACKEND_NAMES.join(", ")
+                ),
+            ));
+        }
+    }
+
+    if let Some(priority) = inline_field_value(block, "Priority") {
+        if prd_task_priority(block).is_none() {
+            issues.push(PrdIssue::warning(
+                "invalid-priority",
+                field_line("Priority"),
+                task,
+                format!(
+                    "PRD validation warning: {}: {}: Unknown priority: {} (expected one of: {}; scheduled as P2)",
+                    task_file.display(),
+                    task_label,
+                    priority,
+                    TASK_PRIORITIES.join(", ")
+                ),
+            ));
+        }
+    }
+
+    if let Some(estimate) = inline_field_value(block, "Estimate") {
+        if prd_task_estimate(block).is_none() {
+            issues.push(PrdIssue::warning(
+                "invalid-estimate",
+                field_line("Estimate"),
+                task,
+                format!(
+                    "PRD validation warning: {}: {}: Unknown estimate: {} (expected S, M, L, or a number of iterations)",
+                    task_file.display(),
+                    task_label,
+                    estimate
+                ),
+            ));
+        }
+    }
+
+    let checked = block.lines().any(|line| {
+        let line = line.trim_start();
+        line.starts_with("- [x]") || line.starts_with("- [X]")
+    });
+    let open_items = prd_open_checklist_items(block);
+    if checked && unchecked_lines.is_empty() && !open_items.is_empty() {
+        issues.push(PrdIssue::error(
+            "open-checklist",
+            field_line("Checklist"),
+            task,
+            format!(
+                "PRD validation error: {}: {}: Task is checked but {} checklist item(s) are open: {}",
+                task_file.display(),
+                task_label,
+                open_items.len(),
+                open_items.join("; ")
+            ),
+        ));
+    }
+
+    if !allow_missing_context {
+        let context_line = field_line("Context Bundle").or(Some(start_line));
+        let mut context_entries = Vec::new();
+        for entry in extract_context_entries(block) {
+            let trimmed = entry.trim();
             if !trimmed.is_empty() {
                 context_entries.push(trimmed.to_string());
             }
         }
 
         if context_entries.is_empty() {
-            errors.push(format!(
-                "PRD validation error: {}: {}: Context Bundle must include at least one file path",
-                task_file.display(),
-                task_label
+            issues.push(PrdIssue::error(
+                "context-empty",
+                context_line,
+                task,
+                format!(
+                    "PRD validation error: {}: {}: Context Bundle must include at least one file path",
+                    task_file.display(),
+                    task_label
+                ),
             ));
         } else {
             let base_compare = base_dir.map(canonicalize_for_compare);
@@ -880,11 +1728,16 @@ fn validate_task_block(
                 if Path::new(&entry).is_absolute() {
                     if let Some(base) = base_compare.as_ref() {
                         if !compare_path.starts_with(base) {
-                            errors.push(format!(
-                                "PRD validation error: {}: {}: Context Bundle path outside repo: {}",
-                                task_file.display(),
-                                task_label,
-                                entry
+                            issues.push(PrdIssue::error(
+                                "context-outside-repo",
+                                context_line,
+                                task,
+                                format!(
+                                    "PRD validation error: {}: {}: Context Bundle path outside repo: {}",
+                                    task_file.display(),
+                                    task_label,
+                                    entry
+                                ),
                             ));
                             continue;
                         }
@@ -892,158 +1745,1932 @@ fn validate_task_block(
                 }
 
                 if !resolved.exists() {
-                    errors.push(format!(
-                        "PRD validation error: {}: {}: Context Bundle path not found: {}",
-                        task_file.display(),
-                        task_label,
-                        entry
+                    issues.push(PrdIssue::error(
+                        "context-not-found",
+                        context_line,
+                        task,
+                        format!(
+                            "PRD validation error: {}: {}: Context Bundle path not found: {}",
+                            task_file.display(),
+                            task_label,
+                            entry
+                        ),
                     ));
                 }
             }
         }
     }
 
-    errors
+    issues
+}
+
+/// Words in a field's value and its continuation lines, or `None` when the
+/// field is absent.
+fn field_word_count(block: &str, field: &str) -> Option<usize> {
+    field_lines(block, field).map(|lines| {
+        lines
+            .iter()
+            .map(|line| line.split_whitespace().count())
+            .sum()
+    })
+}
+
+/// A field's inline value followed by its continuation lines with bullet
+/// markers removed. Blank lines are skipped; `None` when the field is absent.
+fn field_lines(block: &str, field: &str) -> Option<Vec<String>> {
+    let mut lines: Option<Vec<String>> = None;
+    for line in block.lines() {
+        if let Some(value) = strip_field_value(line, field) {
+            lines = Some(if value.is_empty() {
+                Vec::new()
+            } else {
+                vec![value]
+            });
+            continue;
+        }
+        let Some(collected) = lines.as_mut() else {
+            continue;
+        };
+        if line_has_field(line) || line.trim_start().starts_with("- [") {
+            break;
+        }
+        let text = line.trim().trim_start_matches(['*', '-']).trim();
+        if !text.is_empty() {
+            collected.push(text.to_string());
+        }
+    }
+    lines
+}
+
+#[cfg(test)]
+fn sanitize_task_block(
+    block: &str,
+    base_dir: Option<&Path>,
+    allowed_context_file: Option<&Path>,
+) -> String {
+    let allowed_context = load_allowed_context(allowed_context_file);
+    sanitize_task_block_with_allowed(block, base_dir, &allowed_context)
+}
+
+fn sanitize_task_block_with_allowed(
+    block: &str,
+    base_dir: Option<&Path>,
+    allowed_context: &AllowedContext,
+) -> String {
+    let mut context_entries = Vec::new();
+    for entry in extract_context_entries(block) {
+        let trimmed = entry.trim();
+        if !trimmed.is_empty() {
+            context_entries.push(trimmed.to_string());
+        }
+    }
+
+    let mut valid_entries = Vec::new();
+    for entry in context_entries {
+        let display = context_display_path(&entry, base_dir);
+        if !context_entry_exists(&display, base_dir) {
+            continue;
+        }
+        if !allowed_context.is_empty() && !allowed_context.contains(&display) {
+            continue;
+        }
+        add_unique(&mut valid_entries, &display);
+    }
+
+    if valid_entries.is_empty() {
+        if let Some(fallback) = pick_fallback_context(base_dir, allowed_context) {
+            valid_entries.push(fallback);
+        }
+    }
+
+    let context_line = if valid_entries.is_empty() {
+        "- **Context Bundle**".to_string()
+    } else {
+        let formatted = valid_entries
+            .iter()
+            .map(|entry| format!("`{}`", entry))
+            .collect::<Vec<_>>()
+            .join(", ");
+        format!("- **Context Bundle** {}", formatted)
+    };
+
+    let mut output = String::new();
+    let mut in_context_block = false;
+    let mut unchecked_seen = false;
+
+    for line in block.lines() {
+        if let Some(indent) = context_bundle_indent(line) {
+            output.push_str(&format!("{}{}\n", indent, context_line));
+            in_context_block = true;
+            continue;
+        }
+
+        if in_context_block {
+            if line_has_field(line) {
+                in_context_block = false;
+            } else {
+                continue;
+            }
+        }
+
+        if let Some((indent, rest)) = unchecked_line_parts(line) {
+            if unchecked_seen {
+                output.push_str(&format!("{}- {}\n", indent, rest));
+            } else {
+                unchecked_seen = true;
+                output.push_str(line);
+                output.push('\n');
+            }
+            continue;
+        }
+
+        output.push_str(line);
+        output.push('\n');
+    }
+
+    output
+}
+
+fn remove_unchecked_checkbox(line: &str) -> String {
+    if let Some((indent, rest)) = unchecked_line_parts(line) {
+        format!("{}- {}", indent, rest)
+    } else {
+        line.to_string()
+    }
+}
+
+fn unchecked_line_parts(line: &str) -> Option<(String, String)> {
+    let trimmed = line.trim_start();
+    if !trimmed.starts_with("- [ ]") {
+        return None;
+    }
+    let indent_len = line.len() - trimmed.len();
+    let rest = trimmed.trim_start_matches("- [ ]");
+    Some((
+        line[..indent_len].to_string(),
+        rest.trim_start().to_string(),
+    ))
+}
+
+fn task_label(block: &str) -> String {
+    if let Some(id) = extract_task_id_field(block) {
+        return id;
+    }
+    if let Some(header) = extract_task_header_id(block) {
+        return header;
+    }
+    "unknown".to_string()
+}
+
+pub fn prd_task_id_from_block(block: &str) -> Option<String> {
+    if let Some(id) = extract_task_id_field(block) {
+        return Some(id);
+    }
+    extract_task_header_id(block)
+}
+
+pub fn prd_next_task_id(task_file: &Path) -> Option<String> {
+    if task_file.as_os_str().is_empty() || !task_file.is_file() {
+        return None;
+    }
+    let contents = fs::read_to_string(task_file).ok()?;
+    let block = prd_next_task_block(&contents)?;
+    prd_task_id_from_block(&block)
+}
+
+/// A task block to append with [`prd_add_task_contents`].
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct NewTask {
+    pub id: String,
+    pub summary: String,
+    pub dod: String,
+    pub checklist: Vec<String>,
+    pub dependencies: Vec<String>,
+    pub context: Vec<String>,
+    pub issue: Option<String>,
+}
+
+pub fn prd_render_task_block(task: &NewTask) -> String {
+    let mut out = format!("### Task {}\n\n- **ID** {}\n", task.id, task.id);
+    if task.context.is_empty() {
+        out.push_str("- **Context Bundle**\n");
+    } else {
+        let context = task
+            .context
+            .iter()
+            .map(|entry| format!("`{}`", entry))
+            .collect::<Vec<_>>()
+            .join(", ");
+        out.push_str(&format!("- **Context Bundle** {}\n", context));
+    }
+    out.push_str(&format!("- **DoD** {}\n", task.dod));
+    out.push_str("- **Checklist**\n");
+    for item in &task.checklist {
+        out.push_str(&format!("  * {}\n", item));
+    }
+    let dependencies = if task.dependencies.is_empty() {
+        "None".to_string()
+    } else {
+        task.dependencies.join(", ")
+    };
+    out.push_str(&format!("- **Dependencies** {}\n", dependencies));
+    if let Some(issue) = &task.issue {
+        out.push_str(&format!("- **Issue** {}\n", issue));
+    }
+    out.push_str(&format!("- [ ] {} {}\n", task.id, task.summary));
+    out
+}
+
+/// Append a task block to a PRD file after checking the new block.
+pub fn prd_add_task_file(
+    task_file: &Path,
+    task: &NewTask,
+    allow_missing_context: bool,
+) -> Result<(), PrdError> {
+    let contents = fs::read_to_string(task_file).map_err(|source| PrdError::Io {
+        path: task_file.to_path_buf(),
+        source,
+    })?;
+    let base_dir = resolve_base_dir(task_file, None);
+    let updated = prd_add_task_contents(
+        &contents,
+        task_file,
+        task,
+        base_dir.as_deref(),
+        allow_missing_context,
+    )
+    .map_err(PrdError::Validation)?;
+    fs::write(task_file, updated).map_err(|source| PrdError::Io {
+        path: task_file.to_path_buf(),
+        source,
+    })
+}
+
+/// Insert a rendered task block after the last existing one, or at the end
+/// of the PRD when it has none. The ID must be new and the block must pass
+/// the same checks as `prd check`.
+pub fn prd_add_task_contents(
+    contents: &str,
+    task_file: &Path,
+    task: &NewTask,
+    base_dir: Option<&Path>,
+    allow_missing_context: bool,
+) -> Result<String, PrdValidationError> {
+    let mut issues = Vec::new();
+    if task.id.is_empty() || task.id.contains(char::is_whitespace) {
+        issues.push(PrdIssue::error(
+            "invalid-id",
+            None,
+            None,
+            format!(
+                "PRD validation error: {}: Task ID must be a single word: {:?}",
+                task_file.display(),
+                task.id
+            ),
+        ));
+    } else if TaskGraph::from_contents(contents).node(&task.id).is_some() {
+        issues.push(PrdIssue::error(
+            "duplicate-id",
+            None,
+            Some(&task.id),
+            format!(
+                "PRD validation error: {}: Task ID already exists: {}",
+                task_file.display(),
+                task.id
+            ),
+        ));
+    }
+
+    let block = prd_render_task_block(task);
+    let lines = contents.lines().collect::<Vec<_>>();
+    let insert_at = match lines.iter().rposition(|line| is_task_header(line)) {
+        Some(header) => {
+            let mut end = lines[header + 1..]
+                .iter()
+                .position(|line| is_task_block_end(line) || is_task_header(line))
+                .map_or(lines.len(), |offset| header + 1 + offset);
+            while end > header + 1 && lines[end - 1].trim().is_empty() {
+                end -= 1;
+            }
+            Some(end)
+        }
+        None => None,
+    };
+    let needs_blank = lines.last().is_some_and(|line| !line.trim().is_empty());
+    let start_line = insert_at.map_or(lines.len() + 1 + usize::from(needs_blank), |end| end + 2);
+    issues.extend(
+        lint_task_block(
+            block.trim_end(),
+            start_line,
+            task_file,
+            allow_missing_context,
+            base_dir,
+        )
+        .into_iter()
+        .filter(PrdIssue::is_error),
+    );
+    if !issues.is_empty() {
+        return Err(PrdValidationError { issues });
+    }
+
+    let mut output = Vec::new();
+    match insert_at {
+        Some(end) => {
+            output.extend(lines[..end].iter().map(|line| line.to_string()));
+            output.push("---".to_string());
+            output.extend(block.lines().map(str::to_string));
+            output.extend(lines[end..].iter().map(|line| line.to_string()));
+        }
+        None => {
+            output.extend(lines.iter().map(|line| line.to_string()));
+            if needs_blank {
+                output.push(String::new());
+            }
+            output.extend(block.lines().map(str::to_string));
+        }
+    }
+    let mut updated = output.join("\n");
+    updated.push('\n');
+    Ok(updated)
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TaskNode {
+    pub id: String,
+    pub dependencies: Vec<String>,
+    pub done: bool,
+    pub block: String,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct TaskGraph {
+    pub nodes: Vec<TaskNode>,
+}
+
+impl TaskGraph {
+    pub fn from_contents(contents: &str) -> Self {
+        let nodes = task_blocks_from_contents(contents)
+            .into_iter()
+            .map(|block| TaskNode {
+                id: task_label(&block),
+                dependencies: prd_task_dependencies(&block),
+                done: !block.lines().any(is_unchecked_line),
+                block,
+            })
+            .collect();
+        Self { nodes }
+    }
+
+    pub fn node(&self, id: &str) -> Option<&TaskNode> {
+        self.nodes.iter().find(|node| node.id == id)
+    }
+
+    /// Dependencies that do not match any task ID in the PRD.
+    pub fn missing_dependencies(&self, node: &TaskNode) -> Vec<String> {
+        node.dependencies
+            .iter()
+            .filter(|dep| self.node(dep).is_none())
+            .cloned()
+            .collect()
+    }
+
+    /// A task is ready when it still has unchecked items and every known
+    /// dependency is fully checked off. Unknown dependency IDs do not block.
+    pub fn is_ready(&self, node: &TaskNode) -> bool {
+        !node.done
+            && node
+                .dependencies
+                .iter()
+                .all(|dep| self.node(dep).is_none_or(|target| target.done))
+    }
+
+    /// `done`, `ready`, or `blocked`.
+    pub fn status(&self, node: &TaskNode) -> &'static str {
+        if node.done {
+            "done"
+        } else if self.is_ready(node) {
+            "ready"
+        } else {
+            "blocked"
+        }
+    }
+
+    /// The ready task with the highest `- **Priority**`; ties go to the
+    /// one that comes first in the file.
+    pub fn next_ready(&self) -> Option<&TaskNode> {
+        self.nodes
+            .iter()
+            .filter(|node| self.is_ready(node))
+            .min_by_key(|node| prd_task_priority(&node.block).unwrap_or(DEFAULT_PRIORITY))
+    }
+
+    /// Returns the first dependency cycle found as a path of task IDs whose
+    /// last entry repeats the first.
+    pub fn find_cycle(&self) -> Option<Vec<String>> {
+        #[derive(Clone, Copy, PartialEq)]
+        enum Mark {
+            Unvisited,
+            Visiting,
+            Visited,
+        }
+
+        fn visit(
+            graph: &TaskGraph,
+            index: usize,
+            marks: &mut [Mark],
+            stack: &mut Vec<usize>,
+        ) -> Option<Vec<String>> {
+            marks[index] = Mark::Visiting;
+            stack.push(index);
+            for dep in &graph.nodes[index].dependencies {
+                let Some(next) = graph.nodes.iter().position(|node| &node.id == dep) else {
+                    continue;
+                };
+                match marks[next] {
+                    Mark::Visiting => {
+                        let start = stack.iter().position(|entry| *entry == next)?;
+                        let mut cycle = stack[start..]
+                            .iter()
+                            .map(|entry| graph.nodes[*entry].id.clone())
+                            .collect::<Vec<_>>();
+                        cycle.push(graph.nodes[next].id.clone());
+                        return Some(cycle);
+                    }
+                    Mark::Unvisited => {
+                        if let Some(cycle) = visit(graph, next, marks, stack) {
+                            return Some(cycle);
+                        }
+                    }
+                    Mark::Visited => {}
+                }
+            }
+            stack.pop();
+            marks[index] = Mark::Visited;
+            None
+        }
+
+        let mut marks = vec![Mark::Unvisited; self.nodes.len()];
+        let mut stack = Vec::new();
+        for index in 0..self.nodes.len() {
+            if marks[index] == Mark::Unvisited {
+                if let Some(cycle) = visit(self, index, &mut marks, &mut stack) {
+                    return Some(cycle);
+                }
+            }
+        }
+        None
+    }
+
+    pub fn render(&self) -> String {
+        let mut out = String::new();
+        for node in &self.nodes {
+            out.push_str(&format!("{} [{}]\n", node.id, self.status(node)));
+            for dep in &node.dependencies {
+                let dep_state = match self.node(dep) {
+                    Some(target) if target.done => "done",
+                    Some(_) => "pending",
+                    None => "missing",
+                };
+                out.push_str(&format!("  <- {} ({})\n", dep, dep_state));
+            }
+        }
+        out
+    }
+}
+
+/// A PRD as structured data, for tools that consume PRDs without
+/// reimplementing the task block format.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct PrdDocument {
+    /// Text of the first `# ` heading.
+    pub title: Option<String>,
+    pub tasks: Vec<PrdTask>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct PrdTask {
+    pub id: String,
+    /// Text of the task's checkbox line, without the leading ID.
+    pub title: Option<String>,
+    /// 1-based line of the `### Task` header.
+    pub line: usize,
+    /// `done`, `ready`, or `blocked`, as in `gralph prd graph`.
+    pub status: &'static str,
+    pub dependencies: Vec<String>,
+    pub context_bundle: Vec<String>,
+    pub dod: Option<String>,
+    pub checklist: Vec<String>,
+    /// Tracker issue from the optional `- **Issue**` field.
+    pub issue: Option<String>,
+    /// Backend and model from the optional `- **Backend**` and `- **Model**`
+    /// fields, used for this task instead of the loop's.
+    pub backend: Option<String>,
+    pub model: Option<String>,
+    /// `P0` to `P3` from the optional `- **Priority**` field.
+    pub priority: Option<String>,
+    /// Iterations from the optional `- **Estimate**` field.
+    pub estimate: Option<u32>,
+    /// Checked and total `* [ ]` / `* [x]` items under `- **Checklist**`.
+    pub checklist_progress: Option<ChecklistProgress>,
+}
+
+pub fn prd_parse_file(task_file: &Path) -> Result<PrdDocument, PrdError> {
+    let contents = fs::read_to_string(task_file).map_err(|source| PrdError::Io {
+        path: task_file.to_path_buf(),
+        source,
+    })?;
+    Ok(prd_parse_contents(&contents))
+}
+
+pub fn prd_parse_contents(contents: &str) -> PrdDocument {
+    let title = contents.lines().find_map(|line| {
+        line.trim_start()
+            .strip_prefix("# ")
+            .map(|title| title.trim().to_string())
+    });
+    let graph = TaskGraph::from_contents(contents);
+    let tasks = graph
+        .nodes
+        .iter()
+        .zip(task_block_start_lines(contents))
+        .map(|(node, line)| PrdTask {
+            id: node.id.clone(),
+            title: task_title(&node.block, &node.id),
+            line,
+            status: graph.status(node),
+            dependencies: node.dependencies.clone(),
+            context_bundle: extract_context_entries(&node.block)
+                .into_iter()
+                .map(|entry| entry.trim().to_string())
+                .filter(|entry| !entry.is_empty())
+                .collect(),
+            dod: field_lines(&node.block, "DoD")
+                .map(|lines| lines.join(" "))
+                .filter(|dod| !dod.is_empty()),
+            checklist: field_lines(&node.block, "Checklist")
+                .unwrap_or_default()
+                .iter()
+                .map(|item| checklist_item(item).0.to_string())
+                .collect(),
+            issue: prd_task_issue(&node.block),
+            backend: prd_task_backend(&node.block),
+            model: prd_task_model(&node.block),
+            priority: prd_task_priority(&node.block)
+                .map(|index| TASK_PRIORITIES[index].to_string()),
+            estimate: prd_task_estimate(&node.block),
+            checklist_progress: prd_task_checklist_progress(&node.block),
+        })
+        .collect();
+    PrdDocument { title, tasks }
+}
+
+/// The optional `- **Issue**` field, e.g. `#12`, `ENG-42`, or an issue URL.
+pub fn prd_task_issue(block: &str) -> Option<String> {
+    inline_field_value(block, "Issue")
+}
+
+/// The optional `- **Backend**` field: the backend this task runs on, e.g.
+/// `claude` for a task that needs a stronger model than the rest of the loop.
+pub fn prd_task_backend(block: &str) -> Option<String> {
+    inline_field_value(block, "Backend")
+}
+
+/// The optional `- **Model**` field: the model this task runs with.
+pub fn prd_task_model(block: &str) -> Option<String> {
+    inline_field_value(block, "Model")
+}
+
+/// Values of the optional `- **Priority**` field, highest first.
+pub const TASK_PRIORITIES: [&str; 4] = ["P0", "P1", "P2", "P3"];
+
+/// How a task without a `- **Priority**` field is scheduled: as P2.
+const DEFAULT_PRIORITY: usize = 2;
+
+/// Iterations each `- **Estimate**` size stands for.
+pub const ESTIMATE_SIZES: [(&str, u32); 3] = [("S", 1), ("M", 2), ("L", 4)];
+
+/// The optional `- **Estimate**` field in iterations: `S`, `M`, or `L`
+/// (see [`ESTIMATE_SIZES`]) or a count such as `3` or `3 iterations`. None
+/// when absent or not understood.
+pub fn prd_task_estimate(block: &str) -> Option<u32> {
+    let value = inline_field_value(block, "Estimate")?;
+    if let Some((_, iterations)) = ESTIMATE_SIZES
+        .iter()
+        .find(|(size, _)| size.eq_ignore_ascii_case(&value))
+    {
+        return Some(*iterations);
+    }
+    let mut words = value.split_whitespace();
+    let count = words
+        .next()?
+        .parse::<u32>()
+        .ok()
+        .filter(|count| *count > 0)?;
+    match words.next() {
+        None => Some(count),
+        Some(unit)
+            if unit.eq_ignore_ascii_case("iteration")
+                || unit.eq_ignore_ascii_case("iterations") =>
+        {
+            words.next().is_none().then_some(count)
+        }
+        Some(_) => None,
+    }
+}
+
+/// What the `- **Estimate**` fields of the unchecked tasks add up to.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct EstimateSummary {
+    /// Sum of the estimates that were given.
+    pub iterations: u32,
+    pub estimated_tasks: usize,
+    pub unestimated_tasks: usize,
+}
+
+impl EstimateSummary {
+    pub fn add(&mut self, other: EstimateSummary) {
+        self.iterations += other.iterations;
+        self.estimated_tasks += other.estimated_tasks;
+        self.unestimated_tasks += other.unestimated_tasks;
+    }
+
+    /// Iterations the remaining tasks should take, counting one for each
+    /// task without an estimate.
+    pub fn total(&self) -> u32 {
+        self.iterations + self.unestimated_tasks as u32
+    }
+
+    /// [`total`](Self::total) with half again as much room for retries and
+    /// the completion check. None when no task has an estimate.
+    pub fn suggested_max_iterations(&self) -> Option<u32> {
+        (self.estimated_tasks > 0).then(|| (self.total() * 3).div_ceil(2).max(1))
+    }
+}
+
+pub fn prd_estimate_contents(contents: &str) -> EstimateSummary {
+    let mut summary = EstimateSummary::default();
+    for node in TaskGraph::from_contents(contents).nodes {
+        if node.done {
+            continue;
+        }
+        match prd_task_estimate(&node.block) {
+            Some(iterations) => {
+                summary.iterations += iterations;
+                summary.estimated_tasks += 1;
+            }
+            None => summary.unestimated_tasks += 1,
+        }
+    }
+    summary
+}
+
+/// How many of a task's tracked checklist items are checked. Items written
+/// `* [ ] ...` or `* [x] ...` under `- **Checklist**` are tracked; plain
+/// `* ...` items are not.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, serde::Serialize)]
+pub struct ChecklistProgress {
+    pub done: usize,
+    pub total: usize,
+}
+
+impl ChecklistProgress {
+    pub fn is_complete(&self) -> bool {
+        self.done == self.total
+    }
+}
+
+impl fmt::Display for ChecklistProgress {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}/{} checklist items", self.done, self.total)
+    }
+}
+
+/// A Checklist item's text and, for `[ ]` and `[x]` items, whether it is
+/// checked.
+fn checklist_item(item: &str) -> (&str, Option<bool>) {
+    if let Some(text) = item.strip_prefix("[ ]") {
+        return (text.trim(), Some(false));
+    }
+    match item
+        .strip_prefix("[x]")
+        .or_else(|| item.strip_prefix("[X]"))
+    {
+        Some(text) => (text.trim(), Some(true)),
+        None => (item, None),
+    }
+}
+
+/// Progress through the task's tracked checklist items; None when it has
+/// none.
+pub fn prd_task_checklist_progress(block: &str) -> Option<ChecklistProgress> {
+    let states: Vec<bool> = field_lines(block, "Checklist")
+        .unwrap_or_default()
+        .iter()
+        .filter_map(|item| checklist_item(item).1)
+        .collect();
+    (!states.is_empty()).then(|| ChecklistProgress {
+        done: states.iter().filter(|done| **done).count(),
+        total: states.len(),
+    })
+}
+
+/// Text of the task's tracked checklist items that are not checked yet.
+pub fn prd_open_checklist_items(block: &str) -> Vec<String> {
+    field_lines(block, "Checklist")
+        .unwrap_or_default()
+        .iter()
+        .filter_map(|item| match checklist_item(item) {
+            (text, Some(false)) => Some(text.to_string()),
+            _ => None,
+        })
+        .collect()
+}
+
+/// Checklist progress of every task with tracked items, keyed by task ID.
+pub fn prd_checklist_progress(contents: &str) -> Vec<(String, ChecklistProgress)> {
+    TaskGraph::from_contents(contents)
+        .nodes
+        .into_iter()
+        .filter_map(|node| {
+            prd_task_checklist_progress(&node.block).map(|progress| (node.id, progress))
+        })
+        .collect()
+}
+
+/// Estimates of every task that has one, keyed by task ID.
+pub fn prd_task_estimates(contents: &str) -> Vec<(String, u32)> {
+    TaskGraph::from_contents(contents)
+        .nodes
+        .into_iter()
+        .filter_map(|node| prd_task_estimate(&node.block).map(|estimate| (node.id, estimate)))
+        .collect()
+}
+
+/// The optional `- **Priority**` field as an index into
+/// [`TASK_PRIORITIES`], so 0 is P0. None when absent or not a known value.
+pub fn prd_task_priority(block: &str) -> Option<usize> {
+    let value = inline_field_value(block, "Priority")?;
+    TASK_PRIORITIES
+        .iter()
+        .position(|priority| priority.eq_ignore_ascii_case(&value))
+}
+
+/// A single-value field with backticks stripped; None when absent or blank.
+fn inline_field_value(block: &str, field: &str) -> Option<String> {
+    block.lines().find_map(|line| {
+        strip_field_value(line, field)
+            .map(|value| value.trim_matches('`').trim().to_string())
+            .filter(|value| !value.is_empty())
+    })
+}
+
+fn task_title(block: &str, id: &str) -> Option<String> {
+    block.lines().find_map(|line| {
+        let trimmed = line.trim_start();
+        let rest = ["- [ ]", "- [x]", "- [X]"]
+            .iter()
+            .find_map(|marker| trimmed.strip_prefix(marker))?
+            .trim();
+        let rest = match rest.strip_prefix(id) {
+            Some(after) if after.is_empty() || after.starts_with(char::is_whitespace) => {
+                after.trim_start()
+            }
+            _ => rest,
+        };
+        (!rest.is_empty()).then(|| rest.to_string())
+    })
+}
+
+/// Parses the `- **Dependencies**` field into task IDs. `None`, `N/A`, and
+/// `-` are treated as no dependencies; backticks around IDs are ignored.
+pub fn prd_task_dependencies(block: &str) -> Vec<String> {
+    let Some(raw) = block
+        .lines()
+        .find_map(|line| strip_field_value(line, "Dependencies"))
+    else {
+        return Vec::new();
+    };
+    let mut deps = Vec::new();
+    for entry in raw.split([',', ';']) {
+        let entry = strip_dependency_location(entry);
+        if entry.is_empty() || is_no_dependency(entry) {
+            continue;
+        }
+        add_unique(&mut deps, entry);
+    }
+    deps
+}
+
+/// Strips backticks and a trailing `(file)` note, as written by `prd split`
+/// for dependencies that live in another shard.
+/// Paths listed under a block's `- **Context Bundle**` field.
+pub fn prd_task_context_entries(block: &str) -> Vec<String> {
+    extract_context_entries(block)
+}
+
+fn strip_dependency_location(entry: &str) -> &str {
+    let entry = entry.trim();
+    let entry = match entry
+        .strip_suffix(')')
+        .and_then(|rest| rest.rsplit_once(" ("))
+    {
+        Some((id, _)) => id,
+        None => entry,
+    };
+    entry.trim().trim_matches('`').trim()
+}
+
+/// Done, ready, and blocked task counts for a PRD or part of one.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, serde::Serialize)]
+pub struct ProgressCounts {
+    pub tasks: usize,
+    pub done: usize,
+    pub ready: usize,
+    pub blocked: usize,
+}
+
+impl ProgressCounts {
+    fn add(&mut self, status: &str) {
+        self.tasks += 1;
+        match status {
+            "done" => self.done += 1,
+            "ready" => self.ready += 1,
+            _ => self.blocked += 1,
+        }
+    }
+
+    /// Done tasks as a whole percentage; 100 when there are no tasks.
+    pub fn percent(&self) -> usize {
+        if self.tasks == 0 {
+            100
+        } else {
+            self.done * 100 / self.tasks
+        }
+    }
+}
+
+/// A section or dependency chain and the tasks in it, in PRD order.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct ProgressGroup {
+    pub name: String,
+    pub tasks: Vec<String>,
+    #[serde(flatten)]
+    pub counts: ProgressCounts,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct ProgressTask {
+    pub id: String,
+    pub title: Option<String>,
+    /// `done`, `ready`, or `blocked`, as in `gralph prd graph`.
+    pub status: &'static str,
+    /// The `## ` heading the task sits under, if any.
+    pub section: Option<String>,
+    /// Dependencies on tasks in this PRD; unknown IDs are left out.
+    pub dependencies: Vec<String>,
+}
+
+/// Progress of a PRD by section and by dependency chain, for
+/// `gralph prd progress`.
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct PrdProgress {
+    pub total: ProgressCounts,
+    pub sections: Vec<ProgressGroup>,
+    /// Tasks linked by dependencies, named after the tasks they start from.
+    pub chains: Vec<ProgressGroup>,
+    pub tasks: Vec<ProgressTask>,
+}
+
+const PROGRESS_BAR_WIDTH: usize = 20;
+const NO_SECTION: &str = "(no section)";
+
+pub fn prd_progress(contents: &str) -> PrdProgress {
+    let graph = TaskGraph::from_contents(contents);
+    let mut sections_by_line = Vec::new();
+    let mut section = None;
+    for line in contents.lines() {
+        if is_task_header(line) {
+            sections_by_line.push(section.clone());
+        } else if let Some(heading) = line.trim_start().strip_prefix("## ") {
+            let heading = heading.trim();
+            section = (!heading.is_empty()).then(|| heading.to_string());
+        }
+    }
+    let tasks: Vec<ProgressTask> = graph
+        .nodes
+        .iter()
+        .zip(sections_by_line)
+        .map(|(node, section)| ProgressTask {
+            id: node.id.clone(),
+            title: task_title(&node.block, &node.id),
+            status: graph.status(node),
+            section,
+            dependencies: node
+                .dependencies
+                .iter()
+                .filter(|dep| graph.node(dep).is_some())
+                .cloned()
+                .collect(),
+        })
+        .collect();
+
+    let mut total = ProgressCounts::default();
+    let mut sections: Vec<ProgressGroup> = Vec::new();
+    for task in &tasks {
+        total.add(task.status);
+        let name = task.section.as_deref().unwrap_or(NO_SECTION);
+        let index = match sections.iter().position(|group| group.name == name) {
+            Some(index) => index,
+            None => {
+                sections.push(ProgressGroup {
+                    name: name.to_string(),
+                    tasks: Vec::new(),
+                    counts: ProgressCounts::default(),
+                });
+                sections.len() - 1
+            }
+        };
+        sections[index].tasks.push(task.id.clone());
+        sections[index].counts.add(task.status);
+    }
+
+    let chains = progress_chains(&tasks)
+        .into_iter()
+        .map(|members| {
+            let mut counts = ProgressCounts::default();
+            for &index in &members {
+                counts.add(tasks[index].status);
+            }
+            let roots = chain_roots(&tasks, &members);
+            ProgressGroup {
+                name: roots
+                    .iter()
+                    .map(|&index| tasks[index].id.as_str())
+                    .collect::<Vec<_>>()
+                    .join(", "),
+                tasks: members
+                    .iter()
+                    .map(|&index| tasks[index].id.clone())
+                    .collect(),
+                counts,
+            }
+        })
+        .collect();
+
+    PrdProgress {
+        total,
+        sections,
+        chains,
+        tasks,
+    }
+}
+
+/// Groups task indexes into chains: sets of tasks connected by
+/// dependencies in either direction, each in PRD order.
+fn progress_chains(tasks: &[ProgressTask]) -> Vec<Vec<usize>> {
+    let position = |id: &str| tasks.iter().position(|task| task.id == id);
+    let mut chain_of: Vec<Option<usize>> = vec![None; tasks.len()];
+    let mut chains: Vec<Vec<usize>> = Vec::new();
+    for start in 0..tasks.len() {
+        if chain_of[start].is_some() {
+            continue;
+        }
+        let chain = chains.len();
+        let mut members = Vec::new();
+        let mut pending = vec![start];
+        chain_of[start] = Some(chain);
+        while let Some(index) = pending.pop() {
+            members.push(index);
+            let linked = tasks[index]
+                .dependencies
+                .iter()
+                .filter_map(|dep| position(dep))
+                .chain((0..tasks.len()).filter(|&other| {
+                    tasks[other]
+                        .dependencies
+                        .iter()
+                        .any(|dep| *dep == tasks[index].id)
+                }))
+                .collect::<Vec<_>>();
+            for next in linked {
+                if chain_of[next].is_none() {
+                    chain_of[next] = Some(chain);
+                    pending.push(next);
+                }
+            }
+        }
+        members.sort_unstable();
+        chains.push(members);
+    }
+    chains
+}
+
+/// The tasks a chain starts from: members without dependencies, or the
+/// first member when a cycle leaves none.
+fn chain_roots(tasks: &[ProgressTask], members: &[usize]) -> Vec<usize> {
+    let roots: Vec<usize> = members
+        .iter()
+        .copied()
+        .filter(|&index| tasks[index].dependencies.is_empty())
+        .collect();
+    if roots.is_empty() {
+        members.iter().take(1).copied().collect()
+    } else {
+        roots
+    }
+}
+
+fn progress_bar(counts: &ProgressCounts) -> String {
+    let filled = if counts.tasks == 0 {
+        PROGRESS_BAR_WIDTH
+    } else {
+        counts.done * PROGRESS_BAR_WIDTH / counts.tasks
+    };
+    format!(
+        "[{}{}]",
+        "#".repeat(filled),
+        "-".repeat(PROGRESS_BAR_WIDTH - filled)
+    )
+}
+
+fn progress_summary(counts: &ProgressCounts) -> String {
+    let mut summary = format!(
+        "{} {:>3}%  {}/{} done",
+        progress_bar(counts),
+        counts.percent(),
+        counts.done,
+        counts.tasks
+    );
+    if counts.ready > 0 || counts.blocked > 0 {
+        summary.push_str(&format!(
+            ", {} ready, {} blocked",
+            counts.ready, counts.blocked
+        ));
+    }
+    summary
+}
+
+impl PrdProgress {
+    /// Progress bars for the whole PRD and each section, then each
+    /// dependency chain as a tree of the tasks that wait on each other.
+    pub fn render(&self) -> String {
+        let mut out = format!("Total     {}\n", progress_summary(&self.total));
+
+        out.push_str("\nSections\n");
+        let width = self
+            .sections
+            .iter()
+            .map(|group| group.name.chars().count())
+            .max()
+            .unwrap_or(0);
+        for group in &self.sections {
+            out.push_str(&format!(
+                "  {:<width$}  {}\n",
+                group.name,
+                progress_summary(&group.counts),
+                width = width
+            ));
+        }
+
+        out.push_str("\nDependency chains\n");
+        for group in &self.chains {
+            out.push_str(&format!(
+                "  {}  {}\n",
+                group.name,
+                progress_summary(&group.counts)
+            ));
+            let members: Vec<usize> = group
+                .tasks
+                .iter()
+                .filter_map(|id| self.tasks.iter().position(|task| task.id == *id))
+                .collect();
+            let mut shown = HashSet::new();
+            for root in chain_roots(&self.tasks, &members) {
+                self.render_chain_task(root, 2, &mut shown, &mut out);
+            }
+            // A cycle can hide tasks from every root.
+            for index in members {
+                if !shown.contains(&index) {
+                    self.render_chain_task(index, 2, &mut shown, &mut out);
+                }
+            }
+        }
+        out
+    }
+
+    fn render_chain_task(
+        &self,
+        index: usize,
+        depth: usize,
+        shown: &mut HashSet<usize>,
+        out: &mut String,
+    ) {
+        let task = &self.tasks[index];
+        let indent = "  ".repeat(depth);
+        if !shown.insert(index) {
+            out.push_str(&format!(
+                "{}{} [{}] (see above)\n",
+                indent, task.id, task.status
+            ));
+            return;
+        }
+        match task.title.as_deref() {
+            Some(title) => out.push_str(&format!(
+                "{}{} [{}] {}\n",
+                indent, task.id, task.status, title
+            )),
+            None => out.push_str(&format!("{}{} [{}]\n", indent, task.id, task.status)),
+        }
+        for (dependent, _) in self
+            .tasks
+            .iter()
+            .enumerate()
+            .filter(|(_, other)| other.dependencies.contains(&task.id))
+        {
+            self.render_chain_task(dependent, depth + 1, shown, out);
+        }
+    }
+
+    /// A Mermaid flowchart: one subgraph per section, an arrow from each
+    /// task to the tasks that depend on it, and nodes colored by status.
+    pub fn render_mermaid(&self) -> String {
+        let node_id = |index: usize| format!("t{}", index);
+        let mut out = String::from("flowchart TD\n");
+        for (section_index, group) in self.sections.iter().enumerate() {
+            out.push_str(&format!(
+                "  subgraph s{}[\"{}\"]\n",
+                section_index,
+                mermaid_label(&group.name)
+            ));
+            for (index, task) in self
+                .tasks
+                .iter()
+                .enumerate()
+                .filter(|(_, task)| task.section.as_deref().unwrap_or(NO_SECTION) == group.name)
+            {
+                let label = match task.title.as_deref() {
+                    Some(title) => format!("{}: {}", task.id, title),
+                    None => task.id.clone(),
+                };
+                out.push_str(&format!(
+                    "    {}[\"{}\"]:::{}\n",
+                    node_id(index),
+                    mermaid_label(&label),
+                    task.status
+                ));
+            }
+            out.push_str("  end\n");
+        }
+        for (index, task) in self.tasks.iter().enumerate() {
+            for dep in &task.dependencies {
+                if let Some(dep_index) = self.tasks.iter().position(|other| other.id == *dep) {
+                    out.push_str(&format!(
+                        "  {} --> {}\n",
+                        node_id(dep_index),
+                        node_id(index)
+                    ));
+                }
+            }
+        }
+        out.push_str("  classDef done fill:#2da44e,stroke:#1a7f37,color:#ffffff\n");
+        out.push_str("  classDef ready fill:#0969da,stroke:#0550ae,color:#ffffff\n");
+        out.push_str("  classDef blocked fill:#eaeef2,stroke:#8c959f,color:#24292f\n");
+        out
+    }
+}
+
+/// Text safe inside a quoted Mermaid label.
+fn mermaid_label(text: &str) -> String {
+    text.replace('"', "#quot;")
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PrdShard {
+    pub prefix: String,
+    pub file_name: String,
+    pub contents: String,
+    pub tasks: usize,
+    pub remaining: usize,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PrdSplit {
+    pub shards: Vec<PrdShard>,
+    pub index_file_name: String,
+    pub index: String,
+}
+
+/// Groups task blocks by ID prefix (`API-3` -> `API`) into one PRD per
+/// prefix named `<stem>.<prefix>.md`, plus a `<stem>.index.md` overview.
+/// Dependencies on finished tasks in other shards are dropped; pending ones
+/// are kept and annotated with the shard that owns them.
+pub fn prd_split_contents(contents: &str, stem: &str) -> PrdSplit {
+    let graph = TaskGraph::from_contents(contents);
+    let title = contents
+        .lines()
+        .find_map(|line| line.strip_prefix("# "))
+        .map(str::trim)
+        .filter(|title| !title.is_empty())
+        .unwrap_or("PRD");
+
+    let mut prefixes: Vec<String> = Vec::new();
+    for node in &graph.nodes {
+        add_unique(&mut prefixes, &prd_task_prefix(&node.id));
+    }
+    let shard_file = |prefix: &str| format!("{}.{}.md", stem, prefix.to_ascii_lowercase());
+
+    let mut shards = Vec::new();
+    for prefix in &prefixes {
+        let file_name = shard_file(prefix);
+        let nodes: Vec<&TaskNode> = graph
+            .nodes
+            .iter()
+            .filter(|node| &prd_task_prefix(&node.id) == prefix)
+            .collect();
+        let mut body = format!(
+            "# {} ({})\n\nSplit from `{}.md`. Index: `{}.index.md`.\n\n## Tasks\n",
+            title, prefix, stem, stem
+        );
+        for node in &nodes {
+            let deps = node
+                .dependencies
+                .iter()
+                .filter_map(|dep| match graph.node(dep) {
+                    Some(target) if &prd_task_prefix(&target.id) != prefix => (!target.done)
+                        .then(|| format!("{} ({})", dep, shard_file(&prd_task_prefix(dep)))),
+                    _ => Some(dep.clone()),
+                })
+                .collect::<Vec<_>>();
+            body.push('\n');
+            body.push_str(&rewrite_dependencies(&node.block, &deps));
+            body.push('\n');
+        }
+        shards.push(PrdShard {
+            prefix: prefix.clone(),
+            file_name,
+            contents: body,
+            tasks: nodes.len(),
+            remaining: nodes.iter().filter(|node| !node.done).count(),
+        });
+    }
+
+    let mut index = format!(
+        "# {} Index\n\nSplit from `{}.md` by task ID prefix.\n\n| File | Tasks | Remaining |\n|------|-------|-----------|\n",
+        title, stem
+    );
+    for shard in &shards {
+        index.push_str(&format!(
+            "| [{}]({}) | {} | {} |\n",
+            shard.file_name, shard.file_name, shard.tasks, shard.remaining
+        ));
+    }
+
+    PrdSplit {
+        shards,
+        index_file_name: format!("{}.index.md", stem),
+        index,
+    }
+}
+
+/// What [`prd_renumber_contents`] renames.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RenumberOptions {
+    /// Give every renumbered task this prefix instead of its own.
+    pub prefix: Option<String>,
+    /// Only renumber tasks whose prefix is this one (see [`prd_task_prefix`]).
+    pub from: Option<String>,
+    /// Number of the first task under each prefix.
+    pub start: u32,
+}
+
+impl Default for RenumberOptions {
+    fn default() -> Self {
+        Self {
+            prefix: None,
+            from: None,
+            start: 1,
+        }
+    }
+}
+
+/// A task ID changed by [`prd_renumber_contents`].
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct RenamedTask {
+    pub old: String,
+    pub new: String,
+}
+
+/// Numbers tasks `<prefix>-<start>`, `<prefix>-<start + 1>`, ... in file
+/// order, one sequence per prefix, and rewrites every reference to a renamed
+/// ID: the `### Task` header, the `- **ID**` field, `- **Dependencies**`,
+/// and the ID at the start of the checkbox line. With `options.prefix` the
+/// selected tasks share that prefix and one sequence. Without it, IDs that
+/// have no `-` are left alone.
+///
+/// Fails without changing anything when task IDs are not unique or a new ID
+/// would clash with a task that keeps its ID.
+pub fn prd_renumber_contents(
+    contents: &str,
+    task_file: &Path,
+    options: &RenumberOptions,
+) -> Result<(String, Vec<RenamedTask>), PrdValidationError> {
+    let error = |code: &'static str, task: Option<&str>, message: String| {
+        PrdIssue::error(
+            code,
+            None,
+            task,
+            format!("PRD validation error: {}: {}", task_file.display(), message),
+        )
+    };
+    let mut issues = Vec::new();
+    let new_prefix = options.prefix.as_deref().map(str::trim);
+    if new_prefix.is_some_and(|prefix| {
+        prefix.is_empty() || prefix.contains(char::is_whitespace) || prefix.ends_with('-')
+    }) {
+        issues.push(error(
+            "invalid-id",
+            None,
+            format!(
+                "Task ID prefix must be a single word without a trailing '-': {:?}",
+                options.prefix.as_deref().unwrap_or_default()
+            ),
+        ));
+    }
+
+    let ids: Vec<String> = TaskGraph::from_contents(contents)
+        .nodes
+        .into_iter()
+        .map(|node| node.id)
+        .collect();
+    let mut seen = HashSet::new();
+    for id in &ids {
+        if !seen.insert(id.as_str()) {
+            issues.push(error(
+                "duplicate-id",
+                Some(id),
+                format!("Duplicate task ID: {}", id),
+            ));
+        }
+    }
+    if !issues.is_empty() {
+        return Err(PrdValidationError { issues });
+    }
+
+    let from = options
+        .from
+        .as_deref()
+        .map(|from| from.trim().trim_end_matches('-').to_ascii_uppercase());
+    let mut next_numbers: HashMap<String, u32> = HashMap::new();
+    let mut renames: Vec<RenamedTask> = Vec::new();
+    for id in &ids {
+        if from
+            .as_deref()
+            .is_some_and(|from| prd_task_prefix(id) != from)
+        {
+            continue;
+        }
+        let prefix = match new_prefix {
+            Some(prefix) => prefix.to_string(),
+            None => match id.rsplit_once('-') {
+                Some((prefix, _)) if !prefix.trim().is_empty() => prefix.to_string(),
+                _ => continue,
+            },
+        };
+        let key = prefix.to_ascii_uppercase();
+        let number = next_numbers.entry(key).or_insert(options.start);
+        let new = format!("{}-{}", prefix, number);
+        *number += 1;
+        renames.push(RenamedTask {
+            old: id.clone(),
+            new,
+        });
+    }
+
+    for rename in &renames {
+        let kept = ids
+            .iter()
+            .any(|id| *id == rename.new && !renames.iter().any(|other| other.old == *id));
+        if kept {
+            issues.push(error(
+                "duplicate-id",
+                Some(&rename.old),
+                format!(
+                    "Cannot rename {} to {}: another task keeps that ID",
+                    rename.old, rename.new
+                ),
+            ));
+        }
+    }
+    if !issues.is_empty() {
+        return Err(PrdValidationError { issues });
+    }
+    renames.retain(|rename| rename.old != rename.new);
+    if renames.is_empty() {
+        return Ok((contents.to_string(), renames));
+    }
+
+    let mut output = Vec::new();
+    let mut in_block = false;
+    for line in contents.lines() {
+        if is_task_header(line) {
+            in_block = true;
+        } else if in_block && is_task_block_end(line) {
+            in_block = false;
+        }
+        output.push(if in_block {
+            rename_task_line(line, &renames)
+        } else {
+            line.to_string()
+        });
+    }
+    let mut updated = output.join("\n");
+    if contents.ends_with('\n') {
+        updated.push('\n');
+    }
+    Ok((updated, renames))
+}
+
+pub fn prd_renumber_file(
+    task_file: &Path,
+    options: &RenumberOptions,
+    dry_run: bool,
+) -> Result<Vec<RenamedTask>, PrdError> {
+    let contents = fs::read_to_string(task_file).map_err(|source| PrdError::Io {
+        path: task_file.to_path_buf(),
+        source,
+    })?;
+    let (updated, renames) =
+        prd_renumber_contents(&contents, task_file, options).map_err(PrdError::Validation)?;
+    if !dry_run && !renames.is_empty() {
+        fs::write(task_file, updated).map_err(|source| PrdError::Io {
+            path: task_file.to_path_buf(),
+            source,
+        })?;
+    }
+    Ok(renames)
+}
+
+/// Renames the IDs a task block line refers to; other lines, such as the
+/// DoD and Checklist, are left as written.
+fn rename_task_line(line: &str, renames: &[RenamedTask]) -> String {
+    let trimmed = line.trim_start();
+    let indent = &line[..line.len() - trimmed.len()];
+    if let Some(id) = trimmed.strip_prefix("### Task ") {
+        return format!("{}### Task {}", indent, rename_id_tokens(id, renames));
+    }
+    if line_has_named_field(line, "ID") || line_has_named_field(line, "Dependencies") {
+        let marker_end = trimmed.find("**").and_then(|start| {
+            trimmed[start + 2..]
+                .find("**")
+                .map(|end| start + 2 + end + 2)
+        });
+        if let Some(marker_end) = marker_end {
+            return format!(
+                "{}{}{}",
+                indent,
+                &trimmed[..marker_end],
+                rename_id_tokens(&trimmed[marker_end..], renames)
+            );
+        }
+    }
+    for marker in ["- [ ]", "- [x]", "- [X]"] {
+        if let Some(rest) = trimmed.strip_prefix(marker) {
+            let body = rest.trim_start();
+            let gap = &rest[..rest.len() - body.len()];
+            let (first, tail) = body
+                .find(char::is_whitespace)
+                .map_or((body, ""), |end| body.split_at(end));
+            if let Some(rename) = renames.iter().find(|rename| rename.old == first) {
+                return format!("{}{}{}{}{}", indent, marker, gap, rename.new, tail);
+            }
+        }
+    }
+    line.to_string()
+}
+
+/// Replaces whole ID-like words (letters, digits, `-`, `_`) that were
+/// renamed, keeping backticks, commas, and `(file)` notes around them.
+fn rename_id_tokens(text: &str, renames: &[RenamedTask]) -> String {
+    let mut out = String::with_capacity(text.len());
+    let mut word = String::new();
+    let flush = |word: &mut String, out: &mut String| {
+        match renames.iter().find(|rename| rename.old == *word) {
+            Some(rename) => out.push_str(&rename.new),
+            None => out.push_str(word),
+        }
+        word.clear();
+    };
+    for ch in text.chars() {
+        if ch.is_alphanumeric() || ch == '-' || ch == '_' {
+            word.push(ch);
+        } else {
+            flush(&mut word, &mut out);
+            out.push(ch);
+        }
+    }
+    flush(&mut word, &mut out);
+    out
+}
+
+/// What [`prd_merge_contents`] did with each task.
+#[derive(Debug, Clone, Default, PartialEq, Eq, serde::Serialize)]
+pub struct MergeReport {
+    /// Tasks kept as written in the old PRD, checkboxes included.
+    pub kept: Vec<String>,
+    /// Tasks only in the new PRD.
+    pub added: Vec<String>,
+    /// Unfinished tasks replaced by their version in the new PRD.
+    pub updated: Vec<String>,
+    /// Differences that were resolved one way and may need a second look.
+    pub conflicts: Vec<MergeConflict>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, serde::Serialize)]
+pub struct MergeConflict {
+    pub task: Option<String>,
+    pub message: String,
 }
 
-#[cfg(test)]
-fn sanitize_task_block(
-    block: &str,
-    base_dir: Option<&Path>,
-    allowed_context_file: Option<&Path>,
-) -> String {
-    let allowed_context = load_allowed_context(allowed_context_file);
-    sanitize_task_block_with_allowed(block, base_dir, &allowed_context)
+/// A merged PRD and the report of how it was put together.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PrdMerge {
+    pub contents: String,
+    pub report: MergeReport,
 }
 
-fn sanitize_task_block_with_allowed(
-    block: &str,
-    base_dir: Option<&Path>,
-    allowed_context: &AllowedContext,
-) -> String {
-    let mut context_entries = Vec::new();
-    for entry in extract_context_entries(block) {
-        let trimmed = entry.trim();
-        if !trimmed.is_empty() {
-            context_entries.push(trimmed.to_string());
+/// A run of lines outside task blocks, or one task block without its
+/// trailing blank lines.
+#[derive(Debug, Clone)]
+enum MergeSegment {
+    Text(Vec<String>),
+    Task { id: String, lines: Vec<String> },
+}
+
+/// Merges a regenerated PRD (`new`) into the one a loop has been working on
+/// (`old`). The result follows the new PRD's text and task order. Tasks
+/// unchanged apart from their checkboxes, and finished tasks, keep their old
+/// block; unfinished tasks that changed take the new block, with checklist
+/// items that were already checked staying checked. Tasks only in the old
+/// PRD are kept after the task they followed there.
+///
+/// Finished tasks that changed, tasks only in the old PRD, and text outside
+/// task blocks that differs are listed as conflicts.
+pub fn prd_merge_contents(
+    old: &str,
+    new: &str,
+    old_file: &Path,
+    new_file: &Path,
+) -> Result<PrdMerge, PrdValidationError> {
+    let old_segments = merge_segments(old);
+    let new_segments = merge_segments(new);
+    let mut issues = Vec::new();
+    for (segments, file) in [(&old_segments, old_file), (&new_segments, new_file)] {
+        let mut seen = HashSet::new();
+        for id in merge_task_ids(segments) {
+            if !seen.insert(id) {
+                issues.push(PrdIssue::error(
+                    "duplicate-id",
+                    None,
+                    Some(id),
+                    format!(
+                        "PRD validation error: {}: Duplicate task ID: {}",
+                        file.display(),
+                        id
+                    ),
+                ));
+            }
         }
     }
+    if !issues.is_empty() {
+        return Err(PrdValidationError { issues });
+    }
 
-    let mut valid_entries = Vec::new();
-    for entry in context_entries {
-        let display = context_display_path(&entry, base_dir);
-        if !context_entry_exists(&display, base_dir) {
+    let old_tasks: HashMap<&str, &[String]> = old_segments
+        .iter()
+        .filter_map(|segment| match segment {
+            MergeSegment::Task { id, lines } => Some((id.as_str(), lines.as_slice())),
+            MergeSegment::Text(_) => None,
+        })
+        .collect();
+    let mut report = MergeReport::default();
+    let mut merged = Vec::new();
+    for segment in &new_segments {
+        let MergeSegment::Task { id, lines } = segment else {
+            merged.push(segment.clone());
             continue;
-        }
-        if !allowed_context.is_empty() && !allowed_context.contains(&display) {
+        };
+        let lines = match old_tasks.get(id.as_str()) {
+            None => {
+                report.added.push(id.clone());
+                lines.clone()
+            }
+            Some(old_lines) if normalized_task_lines(old_lines) == normalized_task_lines(lines) => {
+                report.kept.push(id.clone());
+                old_lines.to_vec()
+            }
+            Some(old_lines) if !old_lines.iter().any(|line| is_unchecked_line(line)) => {
+                report.kept.push(id.clone());
+                report.conflicts.push(MergeConflict {
+                    task: Some(id.clone()),
+                    message:
+                        "finished in the old PRD but changed in the new one; kept the finished task"
+                            .to_string(),
+                });
+                old_lines.to_vec()
+            }
+            Some(old_lines) => {
+                report.updated.push(id.clone());
+                carry_checked_items(old_lines, lines)
+            }
+        };
+        merged.push(MergeSegment::Task {
+            id: id.clone(),
+            lines,
+        });
+    }
+
+    let mut previous: Option<&str> = None;
+    for segment in &old_segments {
+        let MergeSegment::Task { id, lines } = segment else {
             continue;
+        };
+        let placed = |id: &str| {
+            merged.iter().position(
+                |segment| matches!(segment, MergeSegment::Task { id: other, .. } if other == id),
+            )
+        };
+        if placed(id).is_none() {
+            let task = MergeSegment::Task {
+                id: id.clone(),
+                lines: lines.clone(),
+            };
+            let blank = MergeSegment::Text(vec![String::new()]);
+            match previous.and_then(placed) {
+                Some(index) => {
+                    merged.splice(index + 1..index + 1, [blank, task]);
+                }
+                None => match merged
+                    .iter()
+                    .position(|segment| matches!(segment, MergeSegment::Task { .. }))
+                {
+                    Some(index) => {
+                        merged.splice(index..index, [task, blank]);
+                    }
+                    None => merged.extend([blank, task]),
+                },
+            }
+            report.kept.push(id.clone());
+            report.conflicts.push(MergeConflict {
+                task: Some(id.clone()),
+                message: "only in the old PRD; kept".to_string(),
+            });
         }
-        add_unique(&mut valid_entries, &display);
+        previous = Some(id);
     }
 
-    if valid_entries.is_empty() {
-        if let Some(fallback) = pick_fallback_context(base_dir, allowed_context) {
-            valid_entries.push(fallback);
-        }
+    if merge_text_lines(&old_segments) != merge_text_lines(&new_segments) {
+        report.conflicts.insert(
+            0,
+            MergeConflict {
+                task: None,
+                message: "text outside task blocks differs; used the new PRD's".to_string(),
+            },
+        );
     }
 
-    let context_line = if valid_entries.is_empty() {
-        "- **Context Bundle**".to_string()
-    } else {
-        let formatted = valid_entries
-            .iter()
-            .map(|entry| format!("`{}`", entry))
-            .collect::<Vec<_>>()
-            .join(", ");
-        format!("- **Context Bundle** {}", formatted)
-    };
+    let mut contents = merged
+        .iter()
+        .flat_map(|segment| match segment {
+            MergeSegment::Text(lines) | MergeSegment::Task { lines, .. } => lines.iter(),
+        })
+        .map(String::as_str)
+        .collect::<Vec<_>>()
+        .join("\n");
+    if new.ends_with('\n') {
+        contents.push('\n');
+    }
+    Ok(PrdMerge { contents, report })
+}
 
-    let mut output = String::new();
-    let mut in_context_block = false;
-    let mut unchecked_seen = false;
+/// Merges `new_file` into `old_file` and writes the result to `output`
+/// unless `dry_run` is set.
+pub fn prd_merge_files(
+    old_file: &Path,
+    new_file: &Path,
+    output: &Path,
+    dry_run: bool,
+) -> Result<MergeReport, PrdError> {
+    let read = |path: &Path| {
+        fs::read_to_string(path).map_err(|source| PrdError::Io {
+            path: path.to_path_buf(),
+            source,
+        })
+    };
+    let merge = prd_merge_contents(&read(old_file)?, &read(new_file)?, old_file, new_file)
+        .map_err(PrdError::Validation)?;
+    if !dry_run {
+        fs::write(output, merge.contents).map_err(|source| PrdError::Io {
+            path: output.to_path_buf(),
+            source,
+        })?;
+    }
+    Ok(merge.report)
+}
 
-    for line in block.lines() {
-        if let Some(indent) = context_bundle_indent(line) {
-            output.push_str(&format!("{}{}\n", indent, context_line));
-            in_context_block = true;
-            continue;
+fn merge_segments(contents: &str) -> Vec<MergeSegment> {
+    fn flush_task(segments: &mut Vec<MergeSegment>, text: &mut Vec<String>, mut task: Vec<String>) {
+        let mut trailing = Vec::new();
+        while task.len() > 1 && task.last().is_some_and(|line| line.trim().is_empty()) {
+            trailing.push(task.pop().unwrap_or_default());
         }
+        let id = prd_task_id_from_block(&task.join("\n")).unwrap_or_default();
+        segments.push(MergeSegment::Task { id, lines: task });
+        text.extend(trailing);
+    }
 
-        if in_context_block {
-            if line_has_field(line) {
-                in_context_block = false;
-            } else {
-                continue;
+    let mut segments = Vec::new();
+    let mut text = Vec::new();
+    let mut task: Option<Vec<String>> = None;
+    for line in contents.lines() {
+        if is_task_header(line) {
+            if let Some(done) = task.take() {
+                flush_task(&mut segments, &mut text, done);
             }
-        }
-
-        if let Some((indent, rest)) = unchecked_line_parts(line) {
-            if unchecked_seen {
-                output.push_str(&format!("{}- {}\n", indent, rest));
-            } else {
-                unchecked_seen = true;
-                output.push_str(line);
-                output.push('\n');
+            if !text.is_empty() {
+                segments.push(MergeSegment::Text(std::mem::take(&mut text)));
             }
+            task = Some(vec![line.to_string()]);
             continue;
         }
-
-        output.push_str(line);
-        output.push('\n');
+        match task.as_mut() {
+            Some(_) if is_task_block_end(line) => {
+                flush_task(&mut segments, &mut text, task.take().unwrap_or_default());
+                text.push(line.to_string());
+            }
+            Some(lines) => lines.push(line.to_string()),
+            None => text.push(line.to_string()),
+        }
+    }
+    if let Some(done) = task.take() {
+        flush_task(&mut segments, &mut text, done);
     }
+    if !text.is_empty() {
+        segments.push(MergeSegment::Text(text));
+    }
+    segments
+}
 
-    output
+fn merge_task_ids(segments: &[MergeSegment]) -> impl Iterator<Item = &str> {
+    segments.iter().filter_map(|segment| match segment {
+        MergeSegment::Task { id, .. } => Some(id.as_str()),
+        MergeSegment::Text(_) => None,
+    })
 }
 
-fn remove_unchecked_checkbox(line: &str) -> String {
-    if let Some((indent, rest)) = unchecked_line_parts(line) {
-        format!("{}- {}", indent, rest)
-    } else {
-        line.to_string()
-    }
+/// Non-blank lines outside task blocks, for spotting edits to the preamble
+/// and other sections.
+fn merge_text_lines(segments: &[MergeSegment]) -> Vec<&str> {
+    segments
+        .iter()
+        .filter_map(|segment| match segment {
+            MergeSegment::Text(lines) => Some(lines),
+            MergeSegment::Task { .. } => None,
+        })
+        .flatten()
+        .map(|line| line.trim_end())
+        .filter(|line| !line.is_empty())
+        .collect()
 }
 
-fn unchecked_line_parts(line: &str) -> Option<(String, String)> {
+/// A task block's non-blank lines with every checkbox unchecked, so blocks
+/// that differ only in progress compare equal.
+fn normalized_task_lines(lines: &[String]) -> Vec<String> {
+    lines
+        .iter()
+        .map(|line| uncheck_line(line.trim_end()))
+        .filter(|line| !line.is_empty())
+        .collect()
+}
+
+fn uncheck_line(line: &str) -> String {
     let trimmed = line.trim_start();
-    if !trimmed.starts_with("- [ ]") {
-        return None;
+    let indent = &line[..line.len() - trimmed.len()];
+    for (checked, open) in [
+        ("- [x]", "- [ ]"),
+        ("- [X]", "- [ ]"),
+        ("* [x]", "* [ ]"),
+        ("* [X]", "* [ ]"),
+    ] {
+        if let Some(rest) = trimmed.strip_prefix(checked) {
+            return format!("{}{}{}", indent, open, rest);
+        }
     }
-    let indent_len = line.len() - trimmed.len();
-    let rest = trimmed.trim_start_matches("- [ ]");
-    Some((
-        line[..indent_len].to_string(),
-        rest.trim_start().to_string(),
-    ))
+    line.to_string()
 }
 
-fn task_label(block: &str) -> String {
-    if let Some(id) = extract_task_id_field(block) {
-        return id;
-    }
-    if let Some(header) = extract_task_header_id(block) {
-        return header;
-    }
-    "unknown".to_string()
+/// The new block with `* [ ]` items checked when the old block has the same
+/// item checked.
+fn carry_checked_items(old_lines: &[String], new_lines: &[String]) -> Vec<String> {
+    let checked: HashSet<&str> = old_lines
+        .iter()
+        .filter_map(|line| {
+            let trimmed = line.trim_start();
+            trimmed
+                .strip_prefix("* [x]")
+                .or_else(|| trimmed.strip_prefix("* [X]"))
+                .map(str::trim)
+        })
+        .collect();
+    new_lines
+        .iter()
+        .map(|line| {
+            let trimmed = line.trim_start();
+            match trimmed.strip_prefix("* [ ]") {
+                Some(rest) if checked.contains(rest.trim()) => {
+                    format!("{}* [x]{}", &line[..line.len() - trimmed.len()], rest)
+                }
+                _ => line.clone(),
+            }
+        })
+        .collect()
 }
 
-pub fn prd_task_id_from_block(block: &str) -> Option<String> {
-    if let Some(id) = extract_task_id_field(block) {
-        return Some(id);
+/// The part of a task ID before its last `-`, upper-cased; IDs without a
+/// separator fall into `TASKS`.
+pub fn prd_task_prefix(id: &str) -> String {
+    match id.rsplit_once('-') {
+        Some((prefix, _)) if !prefix.trim().is_empty() => prefix.trim().to_ascii_uppercase(),
+        _ => "TASKS".to_string(),
     }
-    extract_task_header_id(block)
 }
 
-pub fn prd_next_task_id(task_file: &Path) -> Option<String> {
-    if task_file.as_os_str().is_empty() || !task_file.is_file() {
-        return None;
-    }
-    let contents = fs::read_to_string(task_file).ok()?;
-    for block in task_blocks_from_contents(&contents) {
-        if block.lines().any(|line| is_unchecked_line(line)) {
-            return prd_task_id_from_block(&block);
-        }
+fn rewrite_dependencies(block: &str, deps: &[String]) -> String {
+    let value = if deps.is_empty() {
+        "None".to_string()
+    } else {
+        deps.join(", ")
+    };
+    block
+        .lines()
+        .map(|line| {
+            if line_has_named_field(line, "Dependencies") {
+                let indent = &line[..line.len() - line.trim_start().len()];
+                format!("{}- **Dependencies** {}", indent, value)
+            } else {
+                line.to_string()
+            }
+        })
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+/// Picks the highest-priority task block whose dependencies are satisfied,
+/// the first in the file among equals. When every
+/// unchecked task is blocked (for example by a cycle), falls back to the first
+/// unchecked block so the loop keeps making progress.
+pub fn prd_next_task_block(contents: &str) -> Option<String> {
+    let graph = TaskGraph::from_contents(contents);
+    graph
+        .next_ready()
+        .or_else(|| graph.nodes.iter().find(|node| !node.done))
+        .map(|node| node.block.clone())
+}
+
+/// Orders PRD files so that a file whose tasks depend on task IDs defined in
+/// another file comes after it. Returns indices into `contents`; files with no
+/// cross-file dependencies keep their given order, and a cycle falls back to
+/// that order for the files involved.
+pub fn prd_order_task_files(contents: &[String]) -> Vec<usize> {
+    let graphs: Vec<TaskGraph> = contents
+        .iter()
+        .map(|contents| TaskGraph::from_contents(contents))
+        .collect();
+    let depends_on = |file: usize, other: usize| {
+        file != other
+            && graphs[file].nodes.iter().any(|node| {
+                node.dependencies.iter().any(|dep| {
+                    graphs[other].node(dep).is_some() && graphs[file].node(dep).is_none()
+                })
+            })
+    };
+
+    let mut order = Vec::with_capacity(contents.len());
+    let mut pending: Vec<usize> = (0..contents.len()).collect();
+    while !pending.is_empty() {
+        let next = pending
+            .iter()
+            .position(|&file| !pending.iter().any(|&other| depends_on(file, other)))
+            .unwrap_or(0);
+        order.push(pending.remove(next));
     }
-    None
+    order
+}
+
+fn is_no_dependency(entry: &str) -> bool {
+    matches!(
+        entry.to_ascii_lowercase().as_str(),
+        "none" | "n/a" | "na" | "-"
+    )
 }
 
 fn extract_task_header_id(block: &str) -> Option<String> {
@@ -1305,40 +3932,373 @@ mod tests {
         ]
     }
 
-    fn dedupe_entries(entries: Vec<String>) -> Vec<String> {
-        let mut deduped = Vec::new();
-        for entry in entries {
-            add_unique(&mut deduped, &entry);
-        }
-        deduped
+    fn dedupe_entries(entries: Vec<String>) -> Vec<String> {
+        let mut deduped = Vec::new();
+        for entry in entries {
+            add_unique(&mut deduped, &entry);
+        }
+        deduped
+    }
+
+    fn allowed_context_from(entries: &[&str]) -> AllowedContext {
+        let mut allowed = AllowedContext::default();
+        for entry in entries {
+            allowed.insert(entry);
+        }
+        allowed
+    }
+
+    #[test]
+    fn prd_task_id_from_block_prefers_id_field() {
+        let block = "### Task UX-4\n- **ID** UX-4\n- [ ] UX-4 Task\n";
+        assert_eq!(prd_task_id_from_block(block).as_deref(), Some("UX-4"));
+    }
+
+    #[test]
+    fn prd_next_task_id_returns_first_unchecked_block_id() {
+        let temp = tempdir().unwrap();
+        let prd_path = temp.path().join("PRD.md");
+        fs::write(
+            &prd_path,
+            "# PRD\n\n### Task A-1\n- **ID** A-1\n- [x] Done\n---\n### Task B-2\n- **ID** B-2\n- [ ] Pending\n",
+        )
+        .unwrap();
+
+        let next_id = prd_next_task_id(&prd_path);
+        assert_eq!(next_id.as_deref(), Some("B-2"));
+    }
+
+    #[test]
+    fn prd_task_dependencies_parses_lists_and_none() {
+        let block =
+            "### Task C-1\n- **ID** C-1\n- **Dependencies** `A-1`, B-2; A-1\n- [ ] C-1 Task\n";
+        assert_eq!(prd_task_dependencies(block), vec!["A-1", "B-2"]);
+        let block = "### Task C-2\n- **Dependencies** None\n";
+        assert!(prd_task_dependencies(block).is_empty());
+        assert!(prd_task_dependencies("### Task C-3\n- [ ] Task\n").is_empty());
+    }
+
+    #[test]
+    fn prd_next_task_block_skips_tasks_with_pending_dependencies() {
+        let contents = "# PRD\n\n### Task A-1\n- **ID** A-1\n- **Dependencies** B-1\n- [ ] A-1 Task\n---\n### Task B-1\n- **ID** B-1\n- **Dependencies** None\n- [ ] B-1 Task\n";
+        let block = prd_next_task_block(contents).unwrap();
+        assert_eq!(prd_task_id_from_block(&block).as_deref(), Some("B-1"));
+
+        let contents = contents.replace("- [ ] B-1 Task", "- [x] B-1 Task");
+        let block = prd_next_task_block(&contents).unwrap();
+        assert_eq!(prd_task_id_from_block(&block).as_deref(), Some("A-1"));
+    }
+
+    #[test]
+    fn prd_next_task_block_prefers_higher_priority_ready_tasks() {
+        let contents = "### Task A-1\n- **ID** A-1\n- **Dependencies** None\n- [ ] A-1 Task\n---\n### Task B-1\n- **ID** B-1\n- **Priority** P3\n- **Dependencies** None\n- [ ] B-1 Task\n---\n### Task C-1\n- **ID** C-1\n- **Priority** `p1`\n- **Dependencies** None\n- [ ] C-1 Task\n---\n### Task D-1\n- **ID** D-1\n- **Priority** P0\n- **Dependencies** A-1\n- [ ] D-1 Task\n---\n### Task E-1\n- **ID** E-1\n- **Priority** P1\n- **Dependencies** None\n- [ ] E-1 Task\n";
+        let block = prd_next_task_block(contents).unwrap();
+        assert_eq!(prd_task_id_from_block(&block).as_deref(), Some("C-1"));
+
+        let contents = contents.replace("- [ ] A-1 Task", "- [x] A-1 Task");
+        let block = prd_next_task_block(&contents).unwrap();
+        assert_eq!(prd_task_id_from_block(&block).as_deref(), Some("D-1"));
+
+        let unprioritized = "### Task A-1\n- **ID** A-1\n- **Priority** P3\n- **Dependencies** None\n- [ ] A-1 Task\n---\n### Task B-1\n- **ID** B-1\n- **Dependencies** None\n- [ ] B-1 Task\n";
+        let block = prd_next_task_block(unprioritized).unwrap();
+        assert_eq!(prd_task_id_from_block(&block).as_deref(), Some("B-1"));
+        assert_eq!(
+            prd_parse_contents(unprioritized).tasks[0]
+                .priority
+                .as_deref(),
+            Some("P3")
+        );
+    }
+
+    #[test]
+    fn prd_next_task_block_falls_back_when_all_blocked() {
+        let contents = "### Task A-1\n- **ID** A-1\n- **Dependencies** B-1\n- [ ] A-1 Task\n---\n### Task B-1\n- **ID** B-1\n- **Dependencies** A-1\n- [ ] B-1 Task\n";
+        let block = prd_next_task_block(contents).unwrap();
+        assert_eq!(prd_task_id_from_block(&block).as_deref(), Some("A-1"));
+        assert!(prd_next_task_block("### Task A-1\n- [x] Done\n").is_none());
+    }
+
+    #[test]
+    fn prd_parse_contents_returns_task_fields_and_status() {
+        let contents = "# PRD: Billing\n\n### Task API-1\n- **ID** API-1\n- **Context Bundle** `src/api.rs`, `docs/api.md`\n- **DoD** Invoices are listed\n  with totals.\n- **Checklist**\n  * Endpoint added.\n  * Tests pass.\n- **Dependencies** None\n- [x] API-1 List invoices\n---\n### Task UI-1\n- **ID** UI-1\n- **Context Bundle** `src/ui.rs`\n- **Checklist**\n- **Dependencies** API-1, API-2\n- [ ] UI-10 Screen\n---\n### Task API-2\n- **ID** API-2\n- **Dependencies** UI-1\n- [ ] API-2\n";
+
+        let doc = prd_parse_contents(contents);
+
+        assert_eq!(doc.title.as_deref(), Some("PRD: Billing"));
+        assert_eq!(doc.tasks.len(), 3);
+        let api = &doc.tasks[0];
+        assert_eq!(api.id, "API-1");
+        assert_eq!(api.title.as_deref(), Some("List invoices"));
+        assert_eq!(api.line, 3);
+        assert_eq!(api.status, "done");
+        assert!(api.dependencies.is_empty());
+        assert_eq!(api.context_bundle, vec!["src/api.rs", "docs/api.md"]);
+        assert_eq!(api.dod.as_deref(), Some("Invoices are listed with totals."));
+        assert_eq!(api.checklist, vec!["Endpoint added.", "Tests pass."]);
+        assert_eq!(api.issue, None);
+
+        let ui = &doc.tasks[1];
+        assert_eq!(ui.title.as_deref(), Some("UI-10 Screen"));
+        assert_eq!(ui.status, "blocked");
+        assert_eq!(ui.dependencies, vec!["API-1", "API-2"]);
+        assert_eq!(ui.dod, None);
+        assert!(ui.checklist.is_empty());
+        assert_eq!(doc.tasks[2].title, None);
+        assert_eq!(doc.tasks[2].line, 21);
+
+        let json = serde_json::to_value(&doc).unwrap();
+        assert_eq!(json["tasks"][0]["context_bundle"][1], "docs/api.md");
+        assert_eq!(json["tasks"][1]["status"], "blocked");
+    }
+
+    #[test]
+    fn prd_task_issue_reads_optional_field() {
+        let block = "### Task T-1\n- **ID** T-1\n- **Issue** `ENG-42`\n- [ ] T-1 Task\n";
+        assert_eq!(prd_task_issue(block).as_deref(), Some("ENG-42"));
+        assert_eq!(prd_task_issue("### Task T-2\n- **Issue**\n"), None);
+    }
+
+    #[test]
+    fn prd_task_backend_and_model_read_optional_fields() {
+        let block = "### Task T-1\n- **ID** T-1\n- **Backend** `claude`\n- **Model** claude-opus-4-5\n- [ ] T-1 Task\n";
+        assert_eq!(prd_task_backend(block).as_deref(), Some("claude"));
+        assert_eq!(prd_task_model(block).as_deref(), Some("claude-opus-4-5"));
+        assert_eq!(prd_task_backend("### Task T-2\n- **Backend**\n"), None);
+        assert_eq!(prd_task_model("### Task T-2\n- **ID** T-2\n"), None);
+    }
+
+    fn new_task(id: &str) -> NewTask {
+        NewTask {
+            id: id.to_string(),
+            summary: "Add billing export".to_string(),
+            dod: "CSV export covers every invoice field.".to_string(),
+            checklist: vec!["Export command added.".to_string()],
+            dependencies: vec!["B-1".to_string()],
+            context: vec!["README.md".to_string()],
+            issue: None,
+        }
+    }
+
+    #[test]
+    fn prd_add_task_contents_inserts_after_last_block() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        fs::write(base.join("README.md"), "ok").unwrap();
+        let contents = "# PRD\n\n### Task B-1\n\n- **ID** B-1\n- [ ] B-1 First\n---\n\n## Success Criteria\n- Done\n";
+
+        let updated = prd_add_task_contents(
+            contents,
+            Path::new("PRD.md"),
+            &new_task("B-2"),
+            Some(base),
+            false,
+        )
+        .unwrap();
+
+        assert_eq!(
+            updated,
+            "# PRD\n\n### Task B-1\n\n- **ID** B-1\n- [ ] B-1 First\n---\n### Task B-2\n\n- **ID** B-2\n- **Context Bundle** `README.md`\n- **DoD** CSV export covers every invoice field.\n- **Checklist**\n  * Export command added.\n- **Dependencies** B-1\n- [ ] B-2 Add billing export\n---\n\n## Success Criteria\n- Done\n"
+        );
+        let graph = TaskGraph::from_contents(&updated);
+        assert_eq!(graph.nodes.len(), 2);
+        assert_eq!(graph.nodes[1].dependencies, vec!["B-1"]);
+    }
+
+    #[test]
+    fn prd_add_task_contents_appends_when_prd_has_no_blocks() {
+        let temp = tempdir().unwrap();
+        fs::write(temp.path().join("README.md"), "ok").unwrap();
+        let mut task = new_task("N-1");
+        task.dependencies.clear();
+        task.issue = Some("#7".to_string());
+
+        let updated = prd_add_task_contents(
+            "# PRD\n\n## Tasks",
+            Path::new("PRD.md"),
+            &task,
+            Some(temp.path()),
+            false,
+        )
+        .unwrap();
+
+        assert!(updated.starts_with("# PRD\n\n## Tasks\n\n### Task N-1\n"));
+        assert!(updated.contains("- **Dependencies** None\n- **Issue** #7\n- [ ] N-1"));
+        assert!(
+            prd_validate_contents(&updated, Path::new("PRD.md"), false, Some(temp.path())).is_ok()
+        );
+    }
+
+    #[test]
+    fn prd_add_task_contents_rejects_duplicate_id_and_missing_context() {
+        let temp = tempdir().unwrap();
+        let contents = "### Task B-1\n- **ID** B-1\n- [ ] B-1 First\n";
+        let mut task = new_task("B-1");
+        task.context = vec!["missing.md".to_string()];
+
+        let err = prd_add_task_contents(
+            contents,
+            Path::new("PRD.md"),
+            &task,
+            Some(temp.path()),
+            false,
+        )
+        .unwrap_err();
+
+        let codes = err
+            .issues
+            .iter()
+            .map(|issue| issue.code)
+            .collect::<Vec<_>>();
+        assert_eq!(codes, vec!["duplicate-id", "context-not-found"]);
+        assert_eq!(err.issues[1].line, Some(8));
+
+        task.id = "B 2".to_string();
+        let err = prd_add_task_contents(
+            contents,
+            Path::new("PRD.md"),
+            &task,
+            Some(temp.path()),
+            true,
+        )
+        .unwrap_err();
+        assert_eq!(err.issues[0].code, "invalid-id");
+    }
+
+    #[test]
+    fn prd_task_dependencies_strips_shard_annotations() {
+        let block = "### Task UI-2\n- **Dependencies** UI-1, `API-1` (PRD.api.md)\n- [ ] Task\n";
+        assert_eq!(prd_task_dependencies(block), vec!["UI-1", "API-1"]);
+    }
+
+    #[test]
+    fn prd_task_prefix_uses_text_before_last_dash() {
+        assert_eq!(prd_task_prefix("API-12"), "API");
+        assert_eq!(prd_task_prefix("web-ui-3"), "WEB-UI");
+        assert_eq!(prd_task_prefix("P1"), "TASKS");
+        assert_eq!(prd_task_prefix("-1"), "TASKS");
+    }
+
+    #[test]
+    fn prd_split_contents_groups_by_prefix_and_rewrites_dependencies() {
+        let contents = "# PRD: Billing\n\n## Tasks\n\n### Task API-1\n- **ID** API-1\n- **Dependencies** None\n- [x] API-1 Done\n\n### Task API-2\n- **ID** API-2\n- **Dependencies** API-1\n- [ ] API-2 Pending\n\n### Task UI-1\n- **ID** UI-1\n- **Dependencies** API-1, API-2\n- [ ] UI-1 Screen\n";
+        let split = prd_split_contents(contents, "PRD");
+
+        let names: Vec<_> = split.shards.iter().map(|s| s.file_name.as_str()).collect();
+        assert_eq!(names, vec!["PRD.api.md", "PRD.ui.md"]);
+        assert_eq!(split.shards[0].tasks, 2);
+        assert_eq!(split.shards[0].remaining, 1);
+        assert!(split.shards[0].contents.starts_with("# PRD: Billing (API)"));
+        assert!(
+            split.shards[0]
+                .contents
+                .contains("- **Dependencies** API-1\n- [ ] API-2")
+        );
+
+        let ui = &split.shards[1].contents;
+        assert!(ui.contains("- **Dependencies** API-2 (PRD.api.md)"));
+        assert!(!ui.contains("API-1"));
+        let graph = TaskGraph::from_contents(ui);
+        assert_eq!(graph.nodes[0].dependencies, vec!["API-2"]);
+
+        assert_eq!(split.index_file_name, "PRD.index.md");
+        assert!(split.index.contains("| [PRD.ui.md](PRD.ui.md) | 1 | 1 |"));
+        assert!(!split.index.contains("- [ ]"));
     }
 
-    fn allowed_context_from(entries: &[&str]) -> AllowedContext {
-        let mut allowed = AllowedContext::default();
-        for entry in entries {
-            allowed.insert(entry);
-        }
-        allowed
+    #[test]
+    fn prd_order_task_files_puts_dependencies_first() {
+        let frontend = "### Task UI-1\n- **ID** UI-1\n- **Dependencies** API-1 (PRD-backend.md)\n- [ ] UI-1 Screen\n".to_string();
+        let backend =
+            "### Task API-1\n- **ID** API-1\n- **Dependencies** None\n- [ ] API-1 Endpoint\n"
+                .to_string();
+        let docs = "### Task DOC-1\n- **ID** DOC-1\n- **Dependencies** None\n- [ ] DOC-1 Guide\n"
+            .to_string();
+
+        let files = vec![docs.clone(), frontend.clone(), backend.clone()];
+        assert_eq!(prd_order_task_files(&files), vec![0, 2, 1]);
+
+        let cyclic = backend.replace("- **Dependencies** None", "- **Dependencies** UI-1");
+        assert_eq!(prd_order_task_files(&[frontend, cyclic]), vec![0, 1]);
     }
 
     #[test]
-    fn prd_task_id_from_block_prefers_id_field() {
-        let block = "### Task UX-4\n- **ID** UX-4\n- [ ] UX-4 Task\n";
-        assert_eq!(prd_task_id_from_block(block).as_deref(), Some("UX-4"));
+    fn task_graph_detects_cycles_and_missing_dependencies() {
+        let contents = "### Task A-1\n- **ID** A-1\n- **Dependencies** C-1\n- [ ] A\n---\n### Task B-1\n- **ID** B-1\n- **Dependencies** A-1, Z-9\n- [ ] B\n---\n### Task C-1\n- **ID** C-1\n- **Dependencies** B-1\n- [ ] C\n";
+        let graph = TaskGraph::from_contents(contents);
+        assert_eq!(
+            graph.find_cycle(),
+            Some(vec![
+                "A-1".to_string(),
+                "C-1".to_string(),
+                "B-1".to_string(),
+                "A-1".to_string()
+            ])
+        );
+        let b = graph.node("B-1").unwrap();
+        assert_eq!(graph.missing_dependencies(b), vec!["Z-9"]);
+
+        let acyclic = TaskGraph::from_contents(
+            &contents.replace("- **Dependencies** C-1", "- **Dependencies** None"),
+        );
+        assert!(acyclic.find_cycle().is_none());
+        let rendered = acyclic.render();
+        assert!(rendered.contains("A-1 [ready]"));
+        assert!(rendered.contains("B-1 [blocked]"));
+        assert!(rendered.contains("  <- Z-9 (missing)"));
     }
 
     #[test]
-    fn prd_next_task_id_returns_first_unchecked_block_id() {
-        let temp = tempdir().unwrap();
-        let prd_path = temp.path().join("PRD.md");
-        fs::write(
-            &prd_path,
-            "# PRD\n\n### Task A-1\n- **ID** A-1\n- [x] Done\n---\n### Task B-2\n- **ID** B-2\n- [ ] Pending\n",
-        )
-        .unwrap();
+    fn prd_progress_groups_tasks_by_section_and_chain() {
+        let contents = "# PRD\n\n## Setup\n\n### Task S-1\n- **ID** S-1\n- **Dependencies** None\n- [x] S-1 Scaffold\n---\n### Task S-2\n- **ID** S-2\n- **Dependencies** None\n- [ ] S-2 Lint \"strict\"\n\n## API\n\n### Task A-1\n- **ID** A-1\n- **Dependencies** S-1\n- [ ] A-1 Routes\n---\n### Task A-2\n- **ID** A-2\n- **Dependencies** A-1, Z-9\n- [ ] A-2 Auth\n";
+        let progress = prd_progress(contents);
 
-        let next_id = prd_next_task_id(&prd_path);
-        assert_eq!(next_id.as_deref(), Some("B-2"));
+        assert_eq!(
+            progress.total,
+            ProgressCounts {
+                tasks: 4,
+                done: 1,
+                ready: 2,
+                blocked: 1,
+            }
+        );
+        let sections: Vec<(&str, &[String])> = progress
+            .sections
+            .iter()
+            .map(|group| (group.name.as_str(), group.tasks.as_slice()))
+            .collect();
+        assert_eq!(
+            sections,
+            vec![
+                ("Setup", &["S-1".to_string(), "S-2".to_string()][..]),
+                ("API", &["A-1".to_string(), "A-2".to_string()][..]),
+            ]
+        );
+        let chains: Vec<(&str, usize)> = progress
+            .chains
+            .iter()
+            .map(|group| (group.name.as_str(), group.counts.tasks))
+            .collect();
+        assert_eq!(chains, vec![("S-1", 3), ("S-2", 1)]);
+        assert_eq!(progress.tasks[3].dependencies, vec!["A-1"]);
+
+        let rendered = progress.render();
+        assert!(
+            rendered.starts_with(
+                "Total     [#####---------------]  25%  1/4 done, 2 ready, 1 blocked\n"
+            )
+        );
+        assert!(
+            rendered
+                .contains("  Setup  [##########----------]  50%  1/2 done, 1 ready, 0 blocked\n")
+        );
+        assert!(rendered.contains(
+            "  S-1  [######--------------]  33%  1/3 done, 1 ready, 1 blocked\n    S-1 [done] Scaffold\n      A-1 [ready] Routes\n        A-2 [blocked] Auth\n"
+        ));
+
+        let mermaid = progress.render_mermaid();
+        assert!(mermaid.starts_with("flowchart TD\n  subgraph s0[\"Setup\"]\n"));
+        assert!(mermaid.contains("    t1[\"S-2: Lint #quot;strict#quot;\"]:::ready\n"));
+        assert!(mermaid.contains("  t0 --> t2\n  t2 --> t3\n"));
     }
 
     #[derive(Clone, Debug)]
@@ -1388,7 +4348,7 @@ mod tests {
 
         let err = prd_validate_file(&prd, false, None).unwrap_err();
         assert!(
-            err.messages
+            err.messages()
                 .iter()
                 .any(|line| line.contains("Task file is empty"))
         );
@@ -1411,7 +4371,7 @@ mod tests {
 
         let err = prd_validate_file(&prd, false, None).unwrap_err();
         assert!(
-            err.messages
+            err.messages()
                 .iter()
                 .any(|line| line.contains("Missing required field: DoD"))
         );
@@ -1450,7 +4410,7 @@ mod tests {
 
         let err = prd_validate_file(&prd, false, None).unwrap_err();
         assert!(
-            err.messages
+            err.messages()
                 .iter()
                 .any(|line| { line.contains("Missing required field: Context Bundle") })
         );
@@ -1473,7 +4433,7 @@ mod tests {
 
         let err = prd_validate_file(&prd, false, None).unwrap_err();
         assert!(
-            err.messages
+            err.messages()
                 .iter()
                 .any(|line| line.contains("Multiple unchecked task lines"))
         );
@@ -1584,7 +4544,7 @@ mod tests {
 
         let err = prd_validate_file(&prd, false, None).unwrap_err();
         assert!(
-            err.messages
+            err.messages()
                 .iter()
                 .any(|line| line.contains("Unchecked task line outside task block"))
         );
@@ -1604,7 +4564,7 @@ mod tests {
 
         let err = prd_validate_file(&prd, false, None).unwrap_err();
         assert!(
-            err.messages
+            err.messages()
                 .iter()
                 .any(|line| line.contains("Context Bundle path not found"))
         );
@@ -1627,7 +4587,7 @@ mod tests {
 
         let err = prd_validate_file(&prd, false, None).unwrap_err();
         assert!(
-            err.messages
+            err.messages()
                 .iter()
                 .any(|line| line.contains("Open Questions section is not allowed"))
         );
@@ -1682,6 +4642,98 @@ mod tests {
         assert!(detection.evidence.contains(&"Cargo.toml".to_string()));
     }
 
+    #[test]
+    fn prd_detect_stack_identifies_new_ecosystems() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        fs::write(base.join("Package.swift"), "// swift-tools-version:5.9\n").unwrap();
+        fs::create_dir_all(base.join("App.xcodeproj")).unwrap();
+        fs::write(
+            base.join("pubspec.yaml"),
+            "dependencies:\n  flutter:\n    sdk: flutter\n",
+        )
+        .unwrap();
+        fs::write(base.join("deno.json"), "{}\n").unwrap();
+        fs::write(base.join("build.zig"), "").unwrap();
+        fs::write(base.join("CMakeLists.txt"), "project(demo)\n").unwrap();
+        fs::write(base.join("demo.cabal"), "name: demo\n").unwrap();
+        fs::write(base.join("build.sbt"), "scalaVersion := \"3.3.1\"\n").unwrap();
+        fs::write(
+            base.join("build.gradle.kts"),
+            "plugins { id(\"com.android.application\"); kotlin(\"android\") }\n",
+        )
+        .unwrap();
+        fs::write(
+            base.join("deploy.yaml"),
+            "apiVersion: apps/v1\nkind: Deployment\n",
+        )
+        .unwrap();
+        fs::write(base.join("ci.yml"), "on: push\njobs: {}\n").unwrap();
+
+        let detection = prd_detect_stack(base);
+
+        for id in [
+            "Swift", "Dart", "Flutter", "Deno", "Zig", "C/C++", "Haskell", "Scala", "Kotlin",
+            "Android",
+        ] {
+            assert!(detection.ids.contains(&id.to_string()), "missing {}", id);
+        }
+        for tool in ["Xcode", "CMake", "Cabal", "sbt", "Kubernetes"] {
+            assert!(
+                detection.tools.contains(&tool.to_string()),
+                "missing {}",
+                tool
+            );
+        }
+        assert!(detection.evidence.contains(&"deploy.yaml".to_string()));
+        assert!(!detection.evidence.contains(&"ci.yml".to_string()));
+    }
+
+    #[test]
+    fn prd_detect_stack_with_depth_reports_workspace_packages() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        fs::write(base.join("Cargo.toml"), "[workspace]\n").unwrap();
+        fs::create_dir_all(base.join("apps/web")).unwrap();
+        fs::write(base.join("apps/web/package.json"), "{}\n").unwrap();
+        fs::create_dir_all(base.join("node_modules/dep")).unwrap();
+        fs::write(base.join("node_modules/dep/go.mod"), "module dep\n").unwrap();
+
+        let shallow = prd_detect_stack(base);
+        assert_eq!(shallow.ids, vec!["Rust".to_string()]);
+
+        let detection = prd_detect_stack_with_depth(base, 2);
+
+        assert_eq!(
+            detection.ids,
+            vec!["Rust".to_string(), "Node.js".to_string()]
+        );
+        assert!(
+            detection
+                .evidence
+                .contains(&"apps/web/package.json".to_string())
+        );
+        assert_eq!(
+            detection.packages,
+            vec![
+                StackPackage {
+                    path: ".".to_string(),
+                    ids: vec!["Rust".to_string()],
+                    evidence: vec!["Cargo.toml".to_string()],
+                },
+                StackPackage {
+                    path: "apps/web".to_string(),
+                    ids: vec!["Node.js".to_string()],
+                    evidence: vec!["apps/web/package.json".to_string()],
+                },
+            ]
+        );
+        let summary = prd_format_stack_summary(&detection, 2);
+        assert!(summary.contains(
+            "Packages:\n- .: Rust (Cargo.toml)\n- apps/web: Node.js (apps/web/package.json)\n"
+        ));
+    }
+
     #[test]
     fn prd_sanitize_generated_file_filters_open_questions_and_context() {
         let temp = tempdir().unwrap();
@@ -1920,27 +4972,220 @@ mod tests {
     }
 
     #[test]
-    fn has_open_questions_section_detects_heading() {
+    fn open_questions_line_detects_heading() {
         let contents = "# PRD\n\n## Open Questions\n- Remove these\n";
-        assert!(has_open_questions_section(contents));
+        assert_eq!(open_questions_line(contents), Some(3));
     }
 
     #[test]
-    fn has_open_questions_section_ignores_non_matching_heading() {
+    fn open_questions_line_ignores_non_matching_heading() {
         let contents = "# PRD\n\n## Open questions\n- Lowercase\n";
-        assert!(!has_open_questions_section(contents));
+        assert_eq!(open_questions_line(contents), None);
     }
 
     #[test]
-    fn validate_stray_unchecked_reports_line_number() {
+    fn lint_stray_unchecked_reports_line_number() {
         let contents = "# PRD\n\n### Task D-8\n- **ID** D-8\n- **Context Bundle** `README.md`\n- **DoD** Confirm stray validation.\n- **Checklist**\n  * Done.\n- **Dependencies** None\n- [ ] D-8 Task\n## Notes\n- [ ] Stray unchecked\n";
         let task_file = Path::new("prd.md");
 
-        let errors = validate_stray_unchecked(contents, task_file).unwrap();
-        assert!(errors.iter().any(
-            |line| line.contains("Unchecked task line outside task block")
-                && line.contains("line 12")
-        ));
+        let issues = lint_stray_unchecked(contents, task_file);
+        assert!(issues.iter().any(|issue| issue.code == "stray-unchecked"
+            && issue.line == Some(12)
+            && issue.message.contains("line 12")));
+    }
+
+    #[test]
+    fn prd_lint_contents_reports_warnings_with_lines() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        fs::write(base.join("README.md"), "ok").unwrap();
+        let contents = "# PRD\n\n### Task W-1\n- **ID** W-1\n- **Context Bundle** `README.md`\n- **DoD** Works.\n- **Checklist**\n- **Dependencies** None\n- [ ] W-1 Task\n";
+
+        let issues = prd_lint_contents(contents, Path::new("prd.md"), false, Some(base));
+
+        assert!(issues.iter().all(|issue| !issue.is_error()));
+        let dod = issues
+            .iter()
+            .find(|issue| issue.code == "dod-too-short")
+            .unwrap();
+        assert_eq!(dod.line, Some(6));
+        assert_eq!(dod.task.as_deref(), Some("W-1"));
+        assert_eq!(dod.severity.as_str(), "warning");
+        let checklist = issues
+            .iter()
+            .find(|issue| issue.code == "empty-checklist")
+            .unwrap();
+        assert_eq!(checklist.line, Some(7));
+        assert!(
+            issues
+                .iter()
+                .any(|issue| issue.code == "missing-success-criteria")
+        );
+        assert!(prd_validate_contents(contents, Path::new("prd.md"), false, Some(base)).is_ok());
+    }
+
+    #[test]
+    fn prd_lint_contents_accepts_detailed_task_with_success_criteria() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        fs::write(base.join("README.md"), "ok").unwrap();
+        let contents = "# PRD\n\n### Task W-2\n- **ID** W-2\n- **Context Bundle** `README.md`\n- **DoD**\n  Sessions report their stall reason.\n- **Checklist**\n  * Reason stored.\n- **Dependencies** None\n- [ ] W-2 Task\n\n## Success Criteria\n- Done\n";
+
+        let issues = prd_lint_contents(contents, Path::new("prd.md"), false, Some(base));
+
+        assert_eq!(issues, Vec::new());
+    }
+
+    #[test]
+    fn prd_lint_contents_locates_errors_in_task_blocks() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        let contents = "# PRD\n\n### Task E-1\n- **ID** E-1\n- **Context Bundle** `missing.md`\n- **DoD** Fix the thing properly.\n- **Checklist**\n  * Done.\n- [ ] E-1 Task\n- [ ] E-1 Extra\n";
+
+        let issues = prd_lint_contents(contents, Path::new("prd.md"), false, Some(base));
+        let line_of = |code: &str| {
+            issues
+                .iter()
+                .find(|issue| issue.code == code)
+                .and_then(|issue| issue.line)
+        };
+
+        assert_eq!(line_of("missing-field"), Some(3));
+        assert_eq!(line_of("multiple-unchecked"), Some(10));
+        assert_eq!(line_of("context-not-found"), Some(5));
+    }
+
+    #[test]
+    fn prd_lint_contents_rejects_unknown_task_backend() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        fs::write(base.join("README.md"), "ok").unwrap();
+        let contents = "# PRD\n\n### Task B-1\n- **ID** B-1\n- **Context Bundle** `README.md`\n- **DoD** Pick the right backend.\n- **Checklist**\n  * Done.\n- **Dependencies** None\n- **Backend** gpt\n- [ ] B-1 Task\n";
+
+        let issues = prd_lint_contents(contents, Path::new("prd.md"), false, Some(base));
+        let issue = issues
+            .iter()
+            .find(|issue| issue.code == "unknown-backend")
+            .unwrap();
+
+        assert!(issue.is_error());
+        assert_eq!(issue.line, Some(10));
+        assert!(issue.message.contains("Unknown backend: gpt"));
+        let valid = contents.replace("**Backend** gpt", "**Backend** codex");
+        assert!(prd_validate_contents(&valid, Path::new("prd.md"), false, Some(base)).is_ok());
+    }
+
+    #[test]
+    fn prd_lint_contents_warns_on_unknown_task_priority() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        fs::write(base.join("README.md"), "ok").unwrap();
+        let contents = "# PRD\n\n### Task P-1\n- **ID** P-1\n- **Context Bundle** `README.md`\n- **DoD** Ship the urgent fix.\n- **Checklist**\n  * Done.\n- **Dependencies** None\n- **Priority** urgent\n- [ ] P-1 Task\n";
+
+        let issues = prd_lint_contents(contents, Path::new("prd.md"), false, Some(base));
+        let issue = issues
+            .iter()
+            .find(|issue| issue.code == "invalid-priority")
+            .unwrap();
+
+        assert!(!issue.is_error());
+        assert_eq!(issue.line, Some(10));
+        assert!(issue.message.contains("Unknown priority: urgent"));
+        let valid = contents.replace("**Priority** urgent", "**Priority** P0");
+        assert!(
+            prd_lint_contents(&valid, Path::new("prd.md"), false, Some(base))
+                .iter()
+                .all(|issue| issue.code != "invalid-priority")
+        );
+    }
+
+    #[test]
+    fn prd_estimate_contents_sums_unchecked_task_estimates() {
+        let contents = "### Task E-1\n- **ID** E-1\n- **Estimate** L\n- [x] E-1 Done\n---\n### Task E-2\n- **ID** E-2\n- **Estimate** `m`\n- [ ] E-2 Task\n---\n### Task E-3\n- **ID** E-3\n- **Estimate** 3 iterations\n- [ ] E-3 Task\n---\n### Task E-4\n- **ID** E-4\n- [ ] E-4 Task\n---\n### Task E-5\n- **ID** E-5\n- **Estimate** soon\n- [ ] E-5 Task\n";
+
+        let summary = prd_estimate_contents(contents);
+        assert_eq!(
+            summary,
+            EstimateSummary {
+                iterations: 5,
+                estimated_tasks: 2,
+                unestimated_tasks: 2,
+            }
+        );
+        assert_eq!(summary.total(), 7);
+        assert_eq!(summary.suggested_max_iterations(), Some(11));
+        assert_eq!(
+            prd_task_estimates(contents),
+            vec![
+                ("E-1".to_string(), 4),
+                ("E-2".to_string(), 2),
+                ("E-3".to_string(), 3)
+            ]
+        );
+        assert_eq!(EstimateSummary::default().suggested_max_iterations(), None);
+        assert_eq!(prd_task_estimate("- **Estimate** 0\n"), None);
+        assert_eq!(prd_task_estimate("- **Estimate** 2 days\n"), None);
+    }
+
+    #[test]
+    fn prd_lint_contents_warns_on_unknown_task_estimate() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        fs::write(base.join("README.md"), "ok").unwrap();
+        let contents = "# PRD\n\n### Task E-1\n- **ID** E-1\n- **Context Bundle** `README.md`\n- **DoD** Size the task properly.\n- **Checklist**\n  * Done.\n- **Dependencies** None\n- **Estimate** XL\n- [ ] E-1 Task\n";
+
+        let issues = prd_lint_contents(contents, Path::new("prd.md"), false, Some(base));
+        let issue = issues
+            .iter()
+            .find(|issue| issue.code == "invalid-estimate")
+            .unwrap();
+        assert!(!issue.is_error());
+        assert_eq!(issue.line, Some(10));
+        let valid = contents.replace("**Estimate** XL", "**Estimate** L");
+        assert!(
+            prd_lint_contents(&valid, Path::new("prd.md"), false, Some(base))
+                .iter()
+                .all(|issue| issue.code != "invalid-estimate")
+        );
+    }
+
+    #[test]
+    fn prd_fix_contents_demotes_unchecked_and_drops_missing_context() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        fs::write(base.join("README.md"), "ok").unwrap();
+        fs::create_dir_all(base.join("docs")).unwrap();
+        fs::write(base.join("docs/spec.md"), "ok").unwrap();
+        let contents = "Intro kept as written.\n- [ ] Stray\n\n### Task F-1\n- **ID** F-1\n- **Context Bundle** `docs/spec.md`,\n  `docs/gone.md`\n- **DoD** Fix it properly.\n- **Checklist**\n  * Done.\n- **Dependencies** None\n- [ ] F-1 Task\n- [ ] F-1 Extra\n---\n### Task F-2\n- **ID** F-2\n- **Context Bundle** `gone.md`\n- **DoD** Fix it properly.\n- **Checklist**\n  * Done.\n- **Dependencies** None\n- [ ] F-2 Task\n";
+
+        let (fixed, fixes) = prd_fix_contents(contents, Some(base));
+
+        assert!(fixed.starts_with("Intro kept as written.\n- Stray\n"));
+        assert!(fixed.contains("- **Context Bundle** `docs/spec.md`\n- **DoD**"));
+        assert!(fixed.contains("- [ ] F-1 Task\n- F-1 Extra\n"));
+        assert!(fixed.contains("- **Context Bundle** `README.md`\n"));
+        assert_eq!(
+            fixes.iter().map(|fix| fix.line).collect::<Vec<_>>(),
+            vec![2, 6, 13, 17]
+        );
+        assert!(prd_validate_contents(&fixed, Path::new("prd.md"), false, Some(base)).is_ok());
+
+        let (again, more) = prd_fix_contents(&fixed, Some(base));
+        assert_eq!(again, fixed);
+        assert!(more.is_empty());
+    }
+
+    #[test]
+    fn prd_fix_contents_leaves_valid_prd_untouched() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        fs::write(base.join("README.md"), "ok").unwrap();
+        let contents = "# PRD\r\n\r\n### Task F-3\r\n- **ID** F-3\r\n- **Context Bundle** `README.md`\r\n- [ ] F-3 Task\r\n";
+
+        let (fixed, fixes) = prd_fix_contents(contents, Some(base));
+
+        assert_eq!(fixed, contents);
+        assert!(fixes.is_empty());
     }
 
     #[test]
@@ -1960,7 +5205,7 @@ mod tests {
 
         let err = prd_validate_file(&prd, false, None).unwrap_err();
         assert!(
-            err.messages
+            err.messages()
                 .iter()
                 .any(|line| line.contains("Context Bundle path outside repo"))
         );
@@ -1981,12 +5226,12 @@ mod tests {
 
         let err = prd_validate_file(&prd, false, None).unwrap_err();
         assert!(
-            err.messages
+            err.messages()
                 .iter()
                 .any(|line| line.contains("Context Bundle path not found"))
         );
         assert!(
-            !err.messages
+            !err.messages()
                 .iter()
                 .any(|line| line.contains("Context Bundle path outside repo"))
         );
@@ -2012,7 +5257,7 @@ mod tests {
 
         let err = prd_validate_file(&prd, false, None).unwrap_err();
         assert!(
-            err.messages
+            err.messages()
                 .iter()
                 .any(|line| line.contains("Context Bundle path not found"))
         );
@@ -2059,7 +5304,7 @@ mod tests {
 
         let err = prd_validate_file(&prd, false, Some(&repo_root)).unwrap_err();
         assert!(
-            err.messages
+            err.messages()
                 .iter()
                 .any(|line| line.contains("Context Bundle path outside repo"))
         );
@@ -2202,7 +5447,7 @@ mod tests {
         }
 
         #[test]
-        fn prop_validate_stray_unchecked_only_outside_task_blocks(
+        fn prop_lint_stray_unchecked_only_outside_task_blocks(
             newline in newline_strategy(),
             header_leading in whitespace_strategy(),
             separator_leading in whitespace_strategy(),
@@ -2241,18 +5486,13 @@ mod tests {
             }
 
             let contents = lines.join(&newline);
-            let errors = validate_stray_unchecked(&contents, Path::new("prd.md"));
+            let issues = lint_stray_unchecked(&contents, Path::new("prd.md"));
             let expected = (prefix_unchecked as usize) + (suffix_unchecked as usize);
 
-            match errors {
-                None => prop_assert_eq!(expected, 0),
-                Some(messages) => {
-                    prop_assert_eq!(messages.len(), expected);
-                    prop_assert!(messages
-                        .iter()
-                        .all(|line| line.contains("Unchecked task line outside task block")));
-                }
-            }
+            prop_assert_eq!(issues.len(), expected);
+            prop_assert!(issues
+                .iter()
+                .all(|issue| issue.message.contains("Unchecked task line outside task block")));
         }
 
         #[test]
@@ -2671,7 +5911,7 @@ mod tests {
 
             let err = prd_validate_file(&prd, false, None).unwrap_err();
             prop_assert!(err
-                .messages
+                .messages()
                 .iter()
                 .any(|line| line.contains("Unchecked task line outside task block")));
         }
@@ -3270,4 +6510,201 @@ mod tests {
 
         assert!(summary.contains("- Stack focus: Rust"));
     }
+
+    #[test]
+    fn prd_renumber_contents_renumbers_ids_and_references() {
+        let contents = "# PRD\n\n### Task GO-3\n- **ID** GO-3\n- **Dependencies** None\n- [x] GO-3 First\n\n### Task GO-7\n- **ID** GO-7\n- **Dependencies** `GO-3`\n- [ ] GO-7 Second, after GO-3\n\n### Task UI-2\n- **ID** UI-2\n- **Dependencies** GO-3, GO-7 (PRD.api.md)\n- [ ] UI-2 Screen\n";
+        let (updated, renamed) =
+            prd_renumber_contents(contents, Path::new("PRD.md"), &RenumberOptions::default())
+                .unwrap();
+
+        assert_eq!(
+            renamed,
+            vec![
+                RenamedTask {
+                    old: "GO-3".to_string(),
+                    new: "GO-1".to_string(),
+                },
+                RenamedTask {
+                    old: "GO-7".to_string(),
+                    new: "GO-2".to_string(),
+                },
+                RenamedTask {
+                    old: "UI-2".to_string(),
+                    new: "UI-1".to_string(),
+                },
+            ]
+        );
+        assert!(updated.contains("### Task GO-2\n- **ID** GO-2\n- **Dependencies** `GO-1`\n"));
+        assert!(updated.contains("- [ ] GO-2 Second, after GO-3\n"));
+        assert!(
+            updated.contains("- **Dependencies** GO-1, GO-2 (PRD.api.md)\n- [ ] UI-1 Screen\n")
+        );
+    }
+
+    #[test]
+    fn prd_renumber_contents_reprefixes_selected_tasks() {
+        let contents = "### Task GO-1\n- **ID** GO-1\n- **Dependencies** None\n- [ ] GO-1 A\n\n### Task UI-1\n- **ID** UI-1\n- **Dependencies** GO-1\n- [ ] UI-1 B\n";
+        let options = RenumberOptions {
+            prefix: Some("CORE".to_string()),
+            from: Some("go".to_string()),
+            start: 10,
+        };
+        let (updated, renamed) =
+            prd_renumber_contents(contents, Path::new("PRD.md"), &options).unwrap();
+
+        assert_eq!(renamed.len(), 1);
+        assert!(updated.contains("### Task CORE-10\n- **ID** CORE-10\n"));
+        assert!(updated.contains("- **Dependencies** CORE-10\n- [ ] UI-1 B\n"));
+    }
+
+    #[test]
+    fn prd_renumber_contents_rejects_duplicates_and_clashes() {
+        let duplicates =
+            "### Task A-1\n- **ID** A-1\n- [ ] A-1 x\n\n### Task A-1\n- **ID** A-1\n- [ ] A-1 y\n";
+        let err =
+            prd_renumber_contents(duplicates, Path::new("PRD.md"), &RenumberOptions::default())
+                .unwrap_err();
+        assert_eq!(err.issues[0].code, "duplicate-id");
+
+        let clash =
+            "### Task A-5\n- **ID** A-5\n- [ ] A-5 x\n\n### Task B-1\n- **ID** B-1\n- [ ] B-1 y\n";
+        let options = RenumberOptions {
+            prefix: Some("B".to_string()),
+            from: Some("A".to_string()),
+            start: 1,
+        };
+        let err = prd_renumber_contents(clash, Path::new("PRD.md"), &options).unwrap_err();
+        assert_eq!(err.issues[0].code, "duplicate-id");
+
+        let options = RenumberOptions {
+            prefix: Some("NEW-".to_string()),
+            ..RenumberOptions::default()
+        };
+        let err = prd_renumber_contents(clash, Path::new("PRD.md"), &options).unwrap_err();
+        assert_eq!(err.issues[0].code, "invalid-id");
+    }
+
+    #[test]
+    fn prd_task_checklist_progress_counts_tracked_items() {
+        let block = "### Task C-1\n- **ID** C-1\n- **Checklist**\n  * [x] Parser added.\n  * [ ] Parser tests added.\n  * Docs updated.\n- [ ] C-1 Parser\n";
+        assert_eq!(
+            prd_task_checklist_progress(block),
+            Some(ChecklistProgress { done: 1, total: 2 })
+        );
+        assert_eq!(prd_open_checklist_items(block), vec!["Parser tests added."]);
+        assert_eq!(
+            prd_task_checklist_progress("### Task C-2\n- **Checklist**\n  * Plain item.\n"),
+            None
+        );
+
+        let document = prd_parse_contents(block);
+        assert_eq!(
+            document.tasks[0].checklist,
+            vec!["Parser added.", "Parser tests added.", "Docs updated."]
+        );
+        assert_eq!(
+            document.tasks[0].checklist_progress,
+            Some(ChecklistProgress { done: 1, total: 2 })
+        );
+    }
+
+    #[test]
+    fn lint_rejects_checked_task_with_open_checklist_items() {
+        let contents = "# PRD\n\n### Task C-1\n- **ID** C-1\n- **Context Bundle** `README.md`\n- **DoD** Parser handles every case.\n- **Checklist**\n  * [x] Parser added.\n  * [ ] Parser tests added.\n- **Dependencies** None\n- [x] C-1 Parser\n";
+        let issues = prd_lint_contents(contents, Path::new("PRD.md"), true, None);
+        let issue = issues
+            .iter()
+            .find(|issue| issue.code == "open-checklist")
+            .expect("open-checklist issue");
+        assert!(issue.is_error());
+        assert_eq!(issue.line, Some(7));
+        assert!(
+            issue
+                .message
+                .ends_with("1 checklist item(s) are open: Parser tests added.")
+        );
+
+        let finished = contents.replace("* [ ]", "* [x]");
+        assert!(
+            !prd_lint_contents(&finished, Path::new("PRD.md"), true, None)
+                .iter()
+                .any(|issue| issue.code == "open-checklist")
+        );
+    }
+
+    #[test]
+    fn prd_merge_contents_keeps_progress_and_adopts_new_tasks() {
+        let old = "# PRD: Parser\n\n## Tasks\n\n### Task P-1\n- **ID** P-1\n- **DoD** Parse blocks.\n- [x] P-1 Parser\n\n### Task P-2\n- **ID** P-2\n- **DoD** Old wording.\n- **Checklist**\n  * [x] Tests added.\n  * [ ] Docs updated.\n- [ ] P-2 Errors\n\n### Task P-9\n- **ID** P-9\n- **DoD** Added by hand.\n- [ ] P-9 Manual\n\n### Task P-3\n- **ID** P-3\n- **DoD** Ship it.\n- [x] P-3 Release\n";
+        let new = "# PRD: Parser\n\n## Tasks\n\n### Task P-1\n- **ID** P-1\n- **DoD** Parse blocks.\n- [ ] P-1 Parser\n\n### Task P-2\n- **ID** P-2\n- **DoD** New wording.\n- **Checklist**\n  * [ ] Tests added.\n  * [ ] Docs updated.\n- [ ] P-2 Errors\n\n### Task P-3\n- **ID** P-3\n- **DoD** Ship it twice.\n- [ ] P-3 Release\n\n### Task P-4\n- **ID** P-4\n- **DoD** New task.\n- [ ] P-4 Extra\n";
+
+        let merge =
+            prd_merge_contents(old, new, Path::new("PRD.md"), Path::new("PRD.generated.md"))
+                .unwrap();
+
+        assert_eq!(
+            merge.contents,
+            "# PRD: Parser\n\n## Tasks\n\n### Task P-1\n- **ID** P-1\n- **DoD** Parse blocks.\n- [x] P-1 Parser\n\n### Task P-2\n- **ID** P-2\n- **DoD** New wording.\n- **Checklist**\n  * [x] Tests added.\n  * [ ] Docs updated.\n- [ ] P-2 Errors\n\n### Task P-9\n- **ID** P-9\n- **DoD** Added by hand.\n- [ ] P-9 Manual\n\n### Task P-3\n- **ID** P-3\n- **DoD** Ship it.\n- [x] P-3 Release\n\n### Task P-4\n- **ID** P-4\n- **DoD** New task.\n- [ ] P-4 Extra\n"
+        );
+        assert_eq!(merge.report.kept, vec!["P-1", "P-3", "P-9"]);
+        assert_eq!(merge.report.added, vec!["P-4"]);
+        assert_eq!(merge.report.updated, vec!["P-2"]);
+        let conflicts: Vec<_> = merge
+            .report
+            .conflicts
+            .iter()
+            .map(|conflict| conflict.task.as_deref())
+            .collect();
+        assert_eq!(conflicts, vec![Some("P-3"), Some("P-9")]);
+    }
+
+    #[test]
+    fn prd_merge_contents_reports_text_changes_and_duplicate_ids() {
+        let old = "# PRD\n\nHand-written notes.\n\n### Task A-1\n- **ID** A-1\n- [ ] A-1 Task\n";
+        let new = "# PRD\n\n### Task A-1\n- **ID** A-1\n- [ ] A-1 Task\n";
+        let merge = prd_merge_contents(old, new, Path::new("old.md"), Path::new("new.md")).unwrap();
+        assert_eq!(merge.contents, new);
+        assert_eq!(merge.report.conflicts[0].task, None);
+
+        let duplicated = format!("{}\n### Task A-1\n- **ID** A-1\n- [ ] A-1 Again\n", new);
+        let err = prd_merge_contents(old, &duplicated, Path::new("old.md"), Path::new("new.md"))
+            .unwrap_err();
+        assert_eq!(err.issues[0].code, "duplicate-id");
+        assert!(err.issues[0].message.contains("new.md"));
+    }
+
+    #[test]
+    fn prd_scan_todos_finds_comment_markers() {
+        let temp = tempdir().unwrap();
+        let base = temp.path();
+        fs::create_dir_all(base.join("src")).unwrap();
+        fs::create_dir_all(base.join("node_modules/dep")).unwrap();
+        fs::write(
+            base.join("src/lib.rs"),
+            "fn main() {\n    // TODO: handle errors\n    let todo_list = \"TODO\";\n    /* FIXME(ana): leaks memory */\n}\n",
+        )
+        .unwrap();
+        fs::write(
+            base.join("app.py"),
+            "# TODO(sam) retry on timeout\nTODOS = []\n",
+        )
+        .unwrap();
+        fs::write(
+            base.join("node_modules/dep/index.js"),
+            "// TODO: vendored\n",
+        )
+        .unwrap();
+
+        let todos = prd_scan_todos(base);
+
+        let found: Vec<String> = todos.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            found,
+            vec![
+                "app.py:1 TODO: retry on timeout",
+                "src/lib.rs:2 TODO: handle errors",
+                "src/lib.rs:4 FIXME: leaks memory",
+            ]
+        );
+    }
 }
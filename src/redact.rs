@@ -0,0 +1,197 @@
+//! Redaction of secrets from session logs, raw logs, and notifications.
+//!
+//! Backend output and error messages can echo API keys and tokens from the
+//! environment. A [`Redactor`] replaces them with [`REDACTED`]: matches of
+//! built-in token formats and `redaction.patterns`, and the values of
+//! secret-looking environment variables plus `redaction.env`.
+
+use crate::config::Config;
+use regex::Regex;
+use std::borrow::Cow;
+use std::env;
+
+pub const REDACTED: &str = "[REDACTED]";
+
+/// Common API key and token formats.
+const BUILTIN_PATTERNS: &[&str] = &[
+    // OpenAI and Anthropic keys
+    r"\bsk-[A-Za-z0-9_-]{20,}",
+    // GitHub tokens
+    r"\bgh[pousr]_[A-Za-z0-9]{30,}",
+    r"\bgithub_pat_[A-Za-z0-9_]{20,}",
+    // GitLab personal access tokens
+    r"\bglpat-[A-Za-z0-9_-]{20,}",
+    // Slack tokens
+    r"\bxox[abprs]-[A-Za-z0-9-]{10,}",
+    // AWS access key IDs
+    r"\bAKIA[0-9A-Z]{16}\b",
+    // Telegram bot tokens
+    r"[0-9]{8,10}:[A-Za-z0-9_-]{35}\b",
+    // Authorization headers
+    r"(?i)\bbearer\s+[A-Za-z0-9._~+/=-]{16,}",
+];
+
+/// Environment variables named like these (`GITHUB_TOKEN`, `OPENAI_API_KEY`)
+/// hold secrets.
+const SECRET_ENV_SUFFIXES: &[&str] = &["KEY", "TOKEN", "SECRET", "PASSWORD", "CREDENTIALS"];
+
+/// Shorter values are too likely to appear in ordinary output.
+const MIN_SECRET_LEN: usize = 8;
+
+#[derive(Debug, Clone, Default)]
+pub struct Redactor {
+    patterns: Vec<Regex>,
+    /// Secret values, longest first so a value containing another is
+    /// replaced whole.
+    values: Vec<String>,
+}
+
+impl Redactor {
+    /// The built-in patterns and secret environment variables, plus
+    /// `patterns` and the values of the variables named in `env_names`.
+    /// Invalid patterns are skipped with a warning rather than failing a run.
+    pub fn new(patterns: &[String], env_names: &[String]) -> Self {
+        let mut compiled: Vec<Regex> = BUILTIN_PATTERNS
+            .iter()
+            .filter_map(|pattern| Regex::new(pattern).ok())
+            .collect();
+        for pattern in patterns.iter().filter(|pattern| !pattern.trim().is_empty()) {
+            match Regex::new(pattern) {
+                Ok(regex) => compiled.push(regex),
+                Err(err) => eprintln!("Warning: ignoring redaction pattern {:?}: {}", pattern, err),
+            }
+        }
+
+        let mut values: Vec<String> = env::vars_os()
+            .filter_map(|(name, value)| {
+                let name = name.into_string().ok()?;
+                Some((name, value.into_string().ok()?))
+            })
+            .filter(|(name, _)| {
+                is_secret_env_name(name) || env_names.iter().any(|wanted| wanted.trim() == name)
+            })
+            .map(|(_, value)| value.trim().to_string())
+            .filter(|value| value.len() >= MIN_SECRET_LEN)
+            .collect();
+        values.sort_by(|a, b| b.len().cmp(&a.len()).then_with(|| a.cmp(b)));
+        values.dedup();
+
+        Self {
+            patterns: compiled,
+            values,
+        }
+    }
+
+    /// The redactor for the `redaction` config section. With
+    /// `redaction.enabled: false` nothing is redacted.
+    pub fn from_config(config: &Config) -> Self {
+        if config
+            .get("redaction.enabled")
+            .is_some_and(|value| value.trim() == "false")
+        {
+            return Self::default();
+        }
+        Self::new(
+            &config.get_list("redaction.patterns").unwrap_or_default(),
+            &config.get_list("redaction.env").unwrap_or_default(),
+        )
+    }
+
+    /// `text` with every secret replaced by [`REDACTED`].
+    pub fn redact<'a>(&self, text: &'a str) -> Cow<'a, str> {
+        let mut redacted = Cow::Borrowed(text);
+        for value in &self.values {
+            if redacted.contains(value.as_str()) {
+                redacted = Cow::Owned(redacted.replace(value.as_str(), REDACTED));
+            }
+        }
+        for pattern in &self.patterns {
+            let replaced = match pattern.replace_all(&redacted, REDACTED) {
+                Cow::Owned(replaced) => Some(replaced),
+                Cow::Borrowed(_) => None,
+            };
+            if let Some(replaced) = replaced {
+                redacted = Cow::Owned(replaced);
+            }
+        }
+        redacted
+    }
+}
+
+pub(crate) fn is_secret_env_name(name: &str) -> bool {
+    let name = name.to_ascii_uppercase();
+    SECRET_ENV_SUFFIXES.iter().any(|suffix| {
+        name == *suffix
+            || name
+                .strip_suffix(suffix)
+                .is_some_and(|prefix| prefix.ends_with('_'))
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::env_lock;
+
+    #[test]
+    fn redact_replaces_builtin_token_formats() {
+        let redactor = Redactor {
+            patterns: Redactor::new(&[], &[]).patterns,
+            values: Vec::new(),
+        };
+
+        let text = "auth failed for sk-ant-REDACTED (Authorization: Bearer abcdef0123456789xyz)";
+        assert_eq!(
+            redactor.redact(text),
+            "auth failed for [REDACTED] (Authorization: [REDACTED])"
+        );
+        assert_eq!(
+            redactor.redact("https://api.telegram.org/bot123456789:AAbbCCddEEffGGhhIIjjKKllMMnnOOppQQr/sendMessage"),
+            "https://api.telegram.org/bot[REDACTED]/sendMessage"
+        );
+        assert!(matches!(
+            redactor.redact("nothing to hide"),
+            Cow::Borrowed(_)
+        ));
+    }
+
+    #[test]
+    fn redact_replaces_secret_env_values_and_custom_patterns() {
+        let _guard = env_lock();
+        unsafe {
+            env::set_var("GRALPH_TEST_API_KEY", "plain-secret-value");
+            env::set_var("GRALPH_TEST_DEPLOY", "hunter2hunter2");
+        }
+
+        let redactor = Redactor::new(
+            &[r"internal-[0-9]{4}".to_string(), "(".to_string()],
+            &["GRALPH_TEST_DEPLOY".to_string()],
+        );
+        assert_eq!(
+            redactor.redact("key=plain-secret-value pw=hunter2hunter2 id=internal-1234"),
+            "key=[REDACTED] pw=[REDACTED] id=[REDACTED]"
+        );
+
+        unsafe {
+            env::remove_var("GRALPH_TEST_API_KEY");
+            env::remove_var("GRALPH_TEST_DEPLOY");
+        }
+    }
+
+    #[test]
+    fn secret_env_names_match_whole_suffixes() {
+        assert!(is_secret_env_name("OPENAI_API_KEY"));
+        assert!(is_secret_env_name("GH_TOKEN"));
+        assert!(is_secret_env_name("TOKEN"));
+        assert!(!is_secret_env_name("MONKEY"));
+        assert!(!is_secret_env_name("PATH"));
+    }
+
+    #[test]
+    fn from_config_can_disable_redaction() {
+        let config = Config::from_yaml("redaction:\n  enabled: false\n").unwrap();
+        let redactor = Redactor::from_config(&config);
+        let token = "sk-abcdefghijklmnopqrstuvwxyz";
+        assert_eq!(redactor.redact(token), token);
+    }
+}
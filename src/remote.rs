@@ -0,0 +1,261 @@
+//! Remote session state from other machines.
+//!
+//! Each machine keeps its own `state.json`. When build servers run
+//! `gralph server`, those servers are the authority for their sessions.
+//! `gralph status` reads the servers listed under `remotes` and shows
+//! their sessions next to the local ones.
+
+use crate::config::Config;
+use crate::offline;
+use reqwest::blocking::Client;
+use serde_json::Value;
+use std::collections::BTreeSet;
+use std::env;
+use std::error::Error;
+use std::fmt;
+use std::time::Duration;
+
+const REMOTE_TIMEOUT: Duration = Duration::from_secs(10);
+
+#[derive(Debug)]
+pub enum RemoteError {
+    Config(String),
+    Api(String),
+}
+
+impl fmt::Display for RemoteError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            RemoteError::Config(message) => write!(f, "invalid remotes config: {}", message),
+            RemoteError::Api(message) => write!(f, "remote status failed: {}", message),
+        }
+    }
+}
+
+impl Error for RemoteError {}
+
+/// A `gralph server` listed under `remotes.<name>`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RemoteServer {
+    pub name: String,
+    pub url: String,
+    /// Bearer token read from the variable named by `token_env`.
+    pub token: Option<String>,
+}
+
+/// Servers from the `remotes` config section, sorted by name.
+pub fn remote_servers(config: &Config) -> Result<Vec<RemoteServer>, RemoteError> {
+    let names: BTreeSet<String> = config
+        .list()
+        .into_iter()
+        .filter_map(|(key, _)| {
+            let rest = key.strip_prefix("remotes.")?;
+            let (name, _) = rest.split_once('.')?;
+            Some(name.to_string())
+        })
+        .collect();
+    names
+        .into_iter()
+        .map(|name| {
+            let value = |field: &str| {
+                config
+                    .get(&format!("remotes.{}.{}", name, field))
+                    .map(|value| value.trim().to_string())
+                    .filter(|value| !value.is_empty())
+            };
+            let url = value("url")
+                .ok_or_else(|| RemoteError::Config(format!("remotes.{}.url is required", name)))?;
+            let token = match value("token_env") {
+                Some(var) => Some(
+                    env::var(&var)
+                        .ok()
+                        .filter(|token| !token.trim().is_empty())
+                        .ok_or_else(|| {
+                            RemoteError::Config(format!(
+                                "{} is not set (remotes.{}.token_env)",
+                                var, name
+                            ))
+                        })?,
+                ),
+                None => None,
+            };
+            Ok(RemoteServer {
+                url: url.trim_end_matches('/').to_string(),
+                name,
+                token,
+            })
+        })
+        .collect()
+}
+
+/// Sessions reported by `GET /status` on `server`, each tagged with a
+/// `host` field naming the remote. Offline, only remotes on this machine
+/// are asked.
+pub fn fetch_sessions(server: &RemoteServer, offline: bool) -> Result<Vec<Value>, RemoteError> {
+    if offline && !offline::is_local_url(&server.url) {
+        return Err(RemoteError::Api(offline::refusal(&format!(
+            "remote {}",
+            server.name
+        ))));
+    }
+    let client = Client::builder()
+        .timeout(REMOTE_TIMEOUT)
+        .build()
+        .map_err(|err| RemoteError::Api(err.to_string()))?;
+    let mut request = client.get(format!("{}/status", server.url));
+    if let Some(token) = &server.token {
+        request = request.bearer_auth(token);
+    }
+    let response = request
+        .send()
+        .map_err(|err| RemoteError::Api(format!("{}: {}", server.name, err)))?;
+    let status = response.status();
+    if !status.is_success() {
+        return Err(RemoteError::Api(format!(
+            "{}: HTTP {}",
+            server.name,
+            status.as_u16()
+        )));
+    }
+    let text = response
+        .text()
+        .map_err(|err| RemoteError::Api(format!("{}: {}", server.name, err)))?;
+    let body: Value = serde_json::from_str(&text)
+        .map_err(|err| RemoteError::Api(format!("{}: {}", server.name, err)))?;
+    let sessions = body
+        .get("sessions")
+        .and_then(Value::as_array)
+        .ok_or_else(|| RemoteError::Api(format!("{}: response has no sessions", server.name)))?;
+    Ok(sessions
+        .iter()
+        .filter_map(|session| {
+            let mut map = session.as_object()?.clone();
+            map.insert("host".to_string(), Value::String(server.name.clone()));
+            Some(Value::Object(map))
+        })
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::serve_http_once;
+    use std::fs;
+    use std::path::Path;
+
+    fn config_with(dir: &Path, yaml: &str) -> Config {
+        let _guard = crate::test_support::env_lock();
+        let path = dir.join("remotes.yaml");
+        fs::write(&path, yaml).unwrap();
+        unsafe {
+            env::set_var("GRALPH_DEFAULT_CONFIG", &path);
+            env::set_var("GRALPH_GLOBAL_CONFIG", dir.join("missing-global.yaml"));
+        }
+        let config = Config::load(None).unwrap();
+        unsafe {
+            env::remove_var("GRALPH_DEFAULT_CONFIG");
+            env::remove_var("GRALPH_GLOBAL_CONFIG");
+        }
+        config
+    }
+
+    #[test]
+    fn remote_servers_reads_urls_and_tokens() {
+        let temp = tempfile::tempdir().unwrap();
+        let config = config_with(
+            temp.path(),
+            "remotes:\n  build2:\n    url: http://build2:8080/\n  build1:\n    url: https://build1.example.com\n    token_env: GRALPH_TEST_REMOTE_TOKEN\n",
+        );
+
+        let _guard = crate::test_support::env_lock();
+        unsafe { env::set_var("GRALPH_TEST_REMOTE_TOKEN", "secret") };
+        let servers = remote_servers(&config).unwrap();
+        unsafe { env::remove_var("GRALPH_TEST_REMOTE_TOKEN") };
+
+        assert_eq!(
+            servers,
+            vec![
+                RemoteServer {
+                    name: "build1".to_string(),
+                    url: "https://build1.example.com".to_string(),
+                    token: Some("secret".to_string()),
+                },
+                RemoteServer {
+                    name: "build2".to_string(),
+                    url: "http://build2:8080".to_string(),
+                    token: None,
+                },
+            ]
+        );
+        let err = remote_servers(&config).unwrap_err();
+        assert!(
+            err.to_string()
+                .contains("GRALPH_TEST_REMOTE_TOKEN is not set")
+        );
+    }
+
+    #[test]
+    fn remote_servers_requires_url() {
+        let temp = tempfile::tempdir().unwrap();
+        let config = config_with(temp.path(), "remotes:\n  ci:\n    token_env: CI_TOKEN\n");
+        let err = remote_servers(&config).unwrap_err();
+        assert!(err.to_string().contains("remotes.ci.url is required"));
+    }
+
+    #[test]
+    fn fetch_sessions_tags_host_and_sends_token() {
+        let body = serde_json::json!({"sessions": [{"name": "api", "status": "running"}]});
+        let (base, handle) = serve_http_once("HTTP/1.1 200 OK", body.to_string());
+        let server = RemoteServer {
+            name: "build1".to_string(),
+            url: base,
+            token: Some("secret".to_string()),
+        };
+
+        // The test server is on this machine, so offline mode still asks it.
+        let sessions = fetch_sessions(&server, true).unwrap();
+
+        let request = handle.join().unwrap();
+        assert!(request.starts_with("GET /status"));
+        assert!(
+            request
+                .to_ascii_lowercase()
+                .contains("authorization: bearer secret")
+        );
+        assert_eq!(sessions.len(), 1);
+        assert_eq!(sessions[0]["name"], "api");
+        assert_eq!(sessions[0]["host"], "build1");
+    }
+
+    #[test]
+    fn fetch_sessions_reports_http_errors() {
+        let (base, handle) = serve_http_once(
+            "HTTP/1.1 401 Unauthorized",
+            "{\"error\":\"Invalid or missing Bearer token\"}".to_string(),
+        );
+        let server = RemoteServer {
+            name: "build1".to_string(),
+            url: base,
+            token: None,
+        };
+
+        let err = fetch_sessions(&server, false).unwrap_err();
+        let _ = handle.join();
+        assert!(err.to_string().contains("build1: HTTP 401"));
+    }
+
+    #[test]
+    fn fetch_sessions_refuses_other_hosts_offline() {
+        let server = RemoteServer {
+            name: "build1".to_string(),
+            url: "https://build1.example.com".to_string(),
+            token: None,
+        };
+
+        let err = fetch_sessions(&server, true).unwrap_err();
+        assert!(
+            err.to_string()
+                .contains("remote build1 needs network access")
+        );
+    }
+}
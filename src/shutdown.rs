@@ -0,0 +1,187 @@
+//! Process-wide shutdown requests from SIGINT/SIGTERM.
+//!
+//! Foreground loops install the handler once; the backend runner and the
+//! loop poll [`requested`] so an interrupt stops the backend, flushes logs
+//! and records the session as stopped instead of leaving it running.
+//!
+//! Programs that embed a loop cancel it with a [`CancelToken`] instead of a
+//! signal; see [`with_cancel`].
+
+use std::cell::RefCell;
+use std::sync::atomic::{AtomicBool, AtomicI32, Ordering};
+use std::sync::{Arc, Once};
+
+static PENDING: AtomicI32 = AtomicI32::new(0);
+static INSTALL: Once = Once::new();
+
+/// What [`requested`] returns once the calling thread's [`CancelToken`] is
+/// cancelled.
+pub const CANCELLED: i32 = -1;
+
+thread_local! {
+    static CANCEL: RefCell<Option<CancelToken>> = const { RefCell::new(None) };
+}
+
+/// Stops a loop from another thread, the way SIGTERM stops the CLI's loop.
+#[derive(Debug, Clone, Default)]
+pub struct CancelToken(Arc<AtomicBool>);
+
+impl CancelToken {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn cancel(&self) {
+        self.0.store(true, Ordering::SeqCst);
+    }
+
+    pub fn is_cancelled(&self) -> bool {
+        self.0.load(Ordering::SeqCst)
+    }
+}
+
+/// Runs `f` with `token` as this thread's cancellation: while it runs,
+/// [`requested`] returns [`CANCELLED`] once the token is cancelled, so the
+/// loop and the backend stop as they would on a signal.
+pub fn with_cancel<T>(token: &CancelToken, f: impl FnOnce() -> T) -> T {
+    struct Restore(Option<CancelToken>);
+
+    impl Drop for Restore {
+        fn drop(&mut self) {
+            let previous = self.0.take();
+            CANCEL.with(|cancel| *cancel.borrow_mut() = previous);
+        }
+    }
+
+    let _restore = Restore(CANCEL.with(|cancel| cancel.replace(Some(token.clone()))));
+    f()
+}
+
+fn cancelled() -> bool {
+    CANCEL.with(|cancel| {
+        cancel
+            .borrow()
+            .as_ref()
+            .is_some_and(CancelToken::is_cancelled)
+    })
+}
+
+#[cfg(test)]
+thread_local! {
+    static TEST_PENDING: std::cell::Cell<i32> = const { std::cell::Cell::new(0) };
+}
+
+/// Install SIGINT and SIGTERM handlers. A second signal exits immediately.
+pub fn install() {
+    INSTALL.call_once(|| {
+        #[cfg(unix)]
+        unsafe {
+            let handler = handle_signal as extern "C" fn(libc::c_int) as libc::sighandler_t;
+            libc::signal(libc::SIGINT, handler);
+            libc::signal(libc::SIGTERM, handler);
+        }
+    });
+}
+
+#[cfg(unix)]
+extern "C" fn handle_signal(signal: libc::c_int) {
+    if PENDING.swap(signal, Ordering::SeqCst) != 0 {
+        unsafe { libc::_exit(128 + signal) };
+    }
+}
+
+/// The signal that requested shutdown, or [`CANCELLED`], if any.
+pub fn requested() -> Option<i32> {
+    if cancelled() {
+        return Some(CANCELLED);
+    }
+    #[cfg(test)]
+    {
+        // Tests run in parallel; scope requests to the calling thread.
+        let signal = TEST_PENDING.with(|pending| pending.get());
+        (signal != 0).then_some(signal)
+    }
+    #[cfg(not(test))]
+    {
+        pending()
+    }
+}
+
+fn pending() -> Option<i32> {
+    let signal = PENDING.load(Ordering::SeqCst);
+    (signal != 0).then_some(signal)
+}
+
+pub fn signal_name(signal: i32) -> String {
+    if signal == CANCELLED {
+        return "cancellation".to_string();
+    }
+    #[cfg(unix)]
+    {
+        match signal {
+            libc::SIGINT => return "SIGINT".to_string(),
+            libc::SIGTERM => return "SIGTERM".to_string(),
+            _ => {}
+        }
+    }
+    format!("signal {}", signal)
+}
+
+#[cfg(test)]
+pub(crate) fn request_for_test(signal: i32) {
+    TEST_PENDING.with(|pending| pending.set(signal));
+}
+
+#[cfg(test)]
+pub(crate) fn clear_for_test() {
+    TEST_PENDING.with(|pending| pending.set(0));
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_requests_are_scoped_to_the_thread() {
+        request_for_test(libc::SIGINT);
+        assert_eq!(requested(), Some(libc::SIGINT));
+        std::thread::spawn(|| assert_eq!(requested(), None))
+            .join()
+            .unwrap();
+        clear_for_test();
+        assert_eq!(requested(), None);
+    }
+
+    #[test]
+    fn handler_records_the_first_signal() {
+        install();
+        unsafe { libc::raise(libc::SIGTERM) };
+        assert_eq!(pending(), Some(libc::SIGTERM));
+    }
+
+    #[test]
+    fn cancel_tokens_apply_inside_with_cancel_only() {
+        let token = CancelToken::new();
+        token.cancel();
+
+        assert_eq!(requested(), None);
+        assert_eq!(with_cancel(&token, requested), Some(CANCELLED));
+        assert_eq!(
+            with_cancel(&CancelToken::new(), || with_cancel(&token, requested)),
+            Some(CANCELLED)
+        );
+        assert_eq!(with_cancel(&CancelToken::new(), requested), None);
+        assert_eq!(requested(), None);
+    }
+
+    #[test]
+    fn signal_names_are_readable() {
+        assert_eq!(signal_name(CANCELLED), "cancellation");
+        assert_eq!(signal_name(libc::SIGINT), "SIGINT");
+        assert_eq!(signal_name(libc::SIGTERM), "SIGTERM");
+        assert_eq!(
+            signal_name(libc::SIGHUP),
+            format!("signal {}", libc::SIGHUP)
+        );
+    }
+}
@@ -0,0 +1,495 @@
+//! Source discovery for `prd create`: when no `--sources` are given, the
+//! provider named by `sources.provider` is asked for references on the goal.
+//!
+//! Providers are the Brave Search API, a SearXNG instance, and a static
+//! keyword-to-URL map from config for offline use.
+
+use crate::config::Config;
+use reqwest::StatusCode;
+use reqwest::blocking::{Client, RequestBuilder};
+use serde_json::Value;
+use std::env;
+use std::error::Error;
+use std::fmt;
+use std::thread;
+use std::time::Duration;
+
+const API_TIMEOUT: Duration = Duration::from_secs(20);
+const BRAVE_API_URL: &str = "https://api.search.brave.com/res/v1/web/search";
+const DEFAULT_MAX_RESULTS: usize = 5;
+const DEFAULT_RETRIES: u32 = 2;
+const RETRY_DELAY: Duration = Duration::from_millis(500);
+
+#[derive(Debug)]
+pub enum SourcesError {
+    Config(String),
+    Api(String),
+}
+
+impl fmt::Display for SourcesError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            SourcesError::Config(message) => write!(f, "invalid sources config: {}", message),
+            SourcesError::Api(message) => write!(f, "source search failed: {}", message),
+        }
+    }
+}
+
+impl Error for SourcesError {}
+
+/// A reference returned by a provider.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Source {
+    pub title: String,
+    pub url: String,
+}
+
+pub trait SourceProvider {
+    fn name(&self) -> &'static str;
+    fn search(&self, query: &str, limit: usize) -> Result<Vec<Source>, SourcesError>;
+}
+
+/// Settings from the `sources` config section.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SourcesSettings {
+    pub provider: String,
+    /// Search endpoint. Required for SearXNG; defaults for Brave.
+    pub api_url: Option<String>,
+    /// Environment variable holding the API key (`BRAVE_API_KEY` for Brave).
+    pub api_key_env: Option<String>,
+    pub max_results: usize,
+    /// Extra attempts after a network error, 429, or 5xx response.
+    pub retries: u32,
+    pub retry_delay: Duration,
+    /// `sources.static.<keyword>` entries, each a list of URLs.
+    pub static_map: Vec<(String, Vec<String>)>,
+}
+
+impl SourcesSettings {
+    /// `None` when `sources.provider` is unset or `none`.
+    pub fn from_config(config: &Config) -> Result<Option<Self>, SourcesError> {
+        let value = |key: &str| {
+            config
+                .get(key)
+                .map(|value| value.trim().to_string())
+                .filter(|value| !value.is_empty())
+        };
+        let provider = match value("sources.provider")
+            .map(|value| value.to_ascii_lowercase())
+            .as_deref()
+        {
+            None | Some("none") => return Ok(None),
+            Some(name @ ("brave" | "searxng" | "static")) => name.to_string(),
+            Some(other) => {
+                return Err(SourcesError::Config(format!(
+                    "unknown sources.provider: {} (expected brave, searxng, or static)",
+                    other
+                )));
+            }
+        };
+        let api_url = value("sources.api_url");
+        if provider == "searxng" && api_url.is_none() {
+            return Err(SourcesError::Config(
+                "sources.api_url is required for searxng (e.g. https://searx.example.org)"
+                    .to_string(),
+            ));
+        }
+        let parse_number = |key: &str, default: u64| -> Result<u64, SourcesError> {
+            match value(key) {
+                None => Ok(default),
+                Some(raw) => raw.parse().map_err(|_| {
+                    SourcesError::Config(format!("{} must be a non-negative integer: {}", key, raw))
+                }),
+            }
+        };
+        let static_map = config
+            .list()
+            .into_iter()
+            .filter_map(|(key, urls)| {
+                let keyword = key.strip_prefix("sources.static.")?.to_string();
+                let urls: Vec<String> = urls
+                    .split(',')
+                    .map(|url| url.trim().to_string())
+                    .filter(|url| !url.is_empty())
+                    .collect();
+                (!urls.is_empty()).then_some((keyword, urls))
+            })
+            .collect();
+        Ok(Some(Self {
+            provider,
+            api_url,
+            api_key_env: value("sources.api_key_env"),
+            max_results: parse_number("sources.max_results", DEFAULT_MAX_RESULTS as u64)? as usize,
+            retries: parse_number("sources.retries", DEFAULT_RETRIES as u64)? as u32,
+            retry_delay: RETRY_DELAY,
+            static_map,
+        }))
+    }
+
+    /// Builds the configured provider, resolving its API key.
+    /// Whether the configured provider queries a remote service.
+    pub fn requires_network(&self) -> bool {
+        self.provider != "static"
+    }
+
+    pub fn provider(&self) -> Result<Box<dyn SourceProvider>, SourcesError> {
+        match self.provider.as_str() {
+            "brave" => Ok(Box::new(BraveProvider {
+                api_url: self
+                    .api_url
+                    .clone()
+                    .unwrap_or_else(|| BRAVE_API_URL.to_string()),
+                api_key: self.api_key("BRAVE_API_KEY")?.ok_or_else(|| {
+                    SourcesError::Config(format!(
+                        "set {} to search with brave",
+                        self.api_key_env.as_deref().unwrap_or("BRAVE_API_KEY")
+                    ))
+                })?,
+                retries: self.retries,
+                retry_delay: self.retry_delay,
+            })),
+            "searxng" => Ok(Box::new(SearxngProvider {
+                api_url: self.api_url.clone().unwrap_or_default(),
+                api_key: match self.api_key_env.as_deref() {
+                    Some(name) => self.api_key(name)?,
+                    None => None,
+                },
+                retries: self.retries,
+                retry_delay: self.retry_delay,
+            })),
+            _ => Ok(Box::new(StaticProvider {
+                entries: self.static_map.clone(),
+            })),
+        }
+    }
+
+    /// The key from `api_key_env` (or `default_env`). An explicitly named
+    /// variable that is unset is an error; the default may be absent.
+    fn api_key(&self, default_env: &str) -> Result<Option<String>, SourcesError> {
+        let name = self.api_key_env.as_deref().unwrap_or(default_env);
+        match env::var(name).ok().filter(|key| !key.trim().is_empty()) {
+            Some(key) => Ok(Some(key.trim().to_string())),
+            None if self.api_key_env.is_some() => Err(SourcesError::Config(format!(
+                "{} is not set (sources.api_key_env)",
+                name
+            ))),
+            None => Ok(None),
+        }
+    }
+}
+
+/// Searches the configured provider for `query`.
+pub fn discover_sources(
+    settings: &SourcesSettings,
+    query: &str,
+) -> Result<Vec<Source>, SourcesError> {
+    let provider = settings.provider()?;
+    let mut sources = provider.search(query, settings.max_results)?;
+    let mut seen = Vec::new();
+    sources.retain(|source| {
+        let fresh = !seen.contains(&source.url);
+        seen.push(source.url.clone());
+        fresh
+    });
+    sources.truncate(settings.max_results);
+    Ok(sources)
+}
+
+struct BraveProvider {
+    api_url: String,
+    api_key: String,
+    retries: u32,
+    retry_delay: Duration,
+}
+
+impl SourceProvider for BraveProvider {
+    fn name(&self) -> &'static str {
+        "brave"
+    }
+
+    fn search(&self, query: &str, limit: usize) -> Result<Vec<Source>, SourcesError> {
+        let client = client()?;
+        let count = limit.to_string();
+        let body = send_with_retry(self.retries, self.retry_delay, &self.api_url, || {
+            client
+                .get(&self.api_url)
+                .query(&[("q", query), ("count", count.as_str())])
+                .header("X-Subscription-Token", &self.api_key)
+        })?;
+        Ok(results(&body["web"]["results"]))
+    }
+}
+
+struct SearxngProvider {
+    api_url: String,
+    api_key: Option<String>,
+    retries: u32,
+    retry_delay: Duration,
+}
+
+impl SourceProvider for SearxngProvider {
+    fn name(&self) -> &'static str {
+        "searxng"
+    }
+
+    fn search(&self, query: &str, limit: usize) -> Result<Vec<Source>, SourcesError> {
+        let client = client()?;
+        let url = format!("{}/search", self.api_url.trim_end_matches('/'));
+        let body = send_with_retry(self.retries, self.retry_delay, &url, || {
+            let builder = client.get(&url).query(&[("q", query), ("format", "json")]);
+            match self.api_key.as_deref() {
+                Some(key) => builder.header("Authorization", format!("Bearer {}", key)),
+                None => builder,
+            }
+        })?;
+        let mut sources = results(&body["results"]);
+        sources.truncate(limit);
+        Ok(sources)
+    }
+}
+
+/// Offline provider: every keyword that appears in the query contributes its
+/// URLs, in config order.
+struct StaticProvider {
+    entries: Vec<(String, Vec<String>)>,
+}
+
+impl SourceProvider for StaticProvider {
+    fn name(&self) -> &'static str {
+        "static"
+    }
+
+    fn search(&self, query: &str, limit: usize) -> Result<Vec<Source>, SourcesError> {
+        let words: Vec<String> = query
+            .split(|ch: char| !ch.is_alphanumeric() && ch != '-' && ch != '.' && ch != '+')
+            .filter(|word| !word.is_empty())
+            .map(str::to_ascii_lowercase)
+            .collect();
+        let mut sources = Vec::new();
+        for (keyword, urls) in &self.entries {
+            if !words.contains(&keyword.to_ascii_lowercase()) {
+                continue;
+            }
+            for url in urls {
+                sources.push(Source {
+                    title: keyword.clone(),
+                    url: url.clone(),
+                });
+            }
+        }
+        sources.truncate(limit);
+        Ok(sources)
+    }
+}
+
+fn client() -> Result<Client, SourcesError> {
+    Client::builder()
+        .timeout(API_TIMEOUT)
+        .build()
+        .map_err(|err| SourcesError::Api(err.to_string()))
+}
+
+/// `title`/`url` pairs from a JSON array of search results.
+fn results(items: &Value) -> Vec<Source> {
+    items
+        .as_array()
+        .map(|items| {
+            items
+                .iter()
+                .filter_map(|item| {
+                    let url = item["url"].as_str()?.trim();
+                    if url.is_empty() {
+                        return None;
+                    }
+                    let title = item["title"].as_str().unwrap_or(url).trim();
+                    Some(Source {
+                        title: title.to_string(),
+                        url: url.to_string(),
+                    })
+                })
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+/// Sends the request built by `build`, retrying network errors, 429, and 5xx
+/// responses up to `retries` more times with a linear backoff.
+fn send_with_retry(
+    retries: u32,
+    delay: Duration,
+    url: &str,
+    build: impl Fn() -> RequestBuilder,
+) -> Result<Value, SourcesError> {
+    let mut attempt = 0;
+    loop {
+        let error = match build()
+            .header("Accept", "application/json")
+            .header("User-Agent", "gralph")
+            .send()
+        {
+            Ok(response) => {
+                let status = response.status();
+                let body = response
+                    .text()
+                    .map_err(|err| SourcesError::Api(err.to_string()))?;
+                if status.is_success() {
+                    return serde_json::from_str(&body)
+                        .map_err(|err| SourcesError::Api(format!("{}: {}", url, err)));
+                }
+                let error =
+                    SourcesError::Api(format!("{} returned {}: {}", url, status, body.trim()));
+                if status != StatusCode::TOO_MANY_REQUESTS && !status.is_server_error() {
+                    return Err(error);
+                }
+                error
+            }
+            Err(err) => SourcesError::Api(err.to_string()),
+        };
+        if attempt >= retries {
+            return Err(error);
+        }
+        attempt += 1;
+        thread::sleep(delay * attempt);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::serve_http_sequence;
+    use std::fs;
+    use std::path::Path;
+
+    fn config_with(dir: &Path, yaml: &str) -> Config {
+        let _guard = crate::test_support::env_lock();
+        let path = dir.join("sources.yaml");
+        fs::write(&path, yaml).unwrap();
+        unsafe {
+            env::set_var("GRALPH_DEFAULT_CONFIG", &path);
+            env::set_var("GRALPH_GLOBAL_CONFIG", dir.join("missing-global.yaml"));
+        }
+        let config = Config::load(None).unwrap();
+        unsafe {
+            env::remove_var("GRALPH_DEFAULT_CONFIG");
+            env::remove_var("GRALPH_GLOBAL_CONFIG");
+        }
+        config
+    }
+
+    fn settings(provider: &str, api_url: &str) -> SourcesSettings {
+        SourcesSettings {
+            provider: provider.to_string(),
+            api_url: Some(api_url.to_string()),
+            api_key_env: None,
+            max_results: 2,
+            retries: 1,
+            retry_delay: Duration::ZERO,
+            static_map: Vec::new(),
+        }
+    }
+
+    #[test]
+    fn from_config_reads_provider_and_static_map() {
+        let temp = tempfile::tempdir().unwrap();
+        let config = config_with(
+            temp.path(),
+            "sources:\n  provider: Static\n  max_results: 3\n  static:\n    rust:\n      - https://doc.rust-lang.org/book/\n      - https://docs.rs/\n    react: https://react.dev/\n",
+        );
+
+        let settings = SourcesSettings::from_config(&config).unwrap().unwrap();
+
+        assert_eq!(settings.provider, "static");
+        assert_eq!(settings.max_results, 3);
+        assert_eq!(settings.retries, DEFAULT_RETRIES);
+        let sources = discover_sources(&settings, "Add a Rust CLI").unwrap();
+        assert_eq!(
+            sources.iter().map(|s| s.url.as_str()).collect::<Vec<_>>(),
+            vec!["https://doc.rust-lang.org/book/", "https://docs.rs/"]
+        );
+    }
+
+    #[test]
+    fn from_config_rejects_unknown_provider_and_missing_searxng_url() {
+        let temp = tempfile::tempdir().unwrap();
+        let unset = config_with(temp.path(), "sources:\n  provider: none\n");
+        assert!(SourcesSettings::from_config(&unset).unwrap().is_none());
+
+        let unknown = config_with(temp.path(), "sources:\n  provider: duckduckgo\n");
+        let err = SourcesSettings::from_config(&unknown).unwrap_err();
+        assert!(
+            err.to_string()
+                .contains("unknown sources.provider: duckduckgo")
+        );
+
+        let searxng = config_with(temp.path(), "sources:\n  provider: searxng\n");
+        let err = SourcesSettings::from_config(&searxng).unwrap_err();
+        assert!(err.to_string().contains("sources.api_url is required"));
+    }
+
+    #[test]
+    fn brave_sends_key_and_parses_web_results() {
+        let (base, handle) = serve_http_sequence(vec![(
+            "200 OK",
+            r#"{"web":{"results":[{"title":"Axum","url":"https://docs.rs/axum"},{"title":"Dup","url":"https://docs.rs/axum"},{"url":"https://tokio.rs"}]}}"#
+                .to_string(),
+        )]);
+        let mut settings = settings("brave", &format!("{}/search", base));
+        settings.api_key_env = Some("GRALPH_TEST_BRAVE_KEY".to_string());
+        {
+            let _guard = crate::test_support::env_lock();
+            unsafe { env::set_var("GRALPH_TEST_BRAVE_KEY", "secret") };
+        }
+
+        let sources = discover_sources(&settings, "axum server").unwrap();
+
+        assert_eq!(
+            sources,
+            vec![
+                Source {
+                    title: "Axum".to_string(),
+                    url: "https://docs.rs/axum".to_string(),
+                },
+                Source {
+                    title: "https://tokio.rs".to_string(),
+                    url: "https://tokio.rs".to_string(),
+                },
+            ]
+        );
+        let requests = handle.join().unwrap();
+        assert!(requests[0].starts_with("GET /search?q=axum+server&count=2 "));
+        assert!(
+            requests[0]
+                .to_ascii_lowercase()
+                .contains("x-subscription-token: secret")
+        );
+    }
+
+    #[test]
+    fn searxng_retries_server_errors() {
+        let (base, handle) = serve_http_sequence(vec![
+            ("503 Service Unavailable", "busy".to_string()),
+            (
+                "200 OK",
+                r#"{"results":[{"title":"Go","url":"https://go.dev/doc/"}]}"#.to_string(),
+            ),
+        ]);
+
+        let sources = discover_sources(&settings("searxng", &base), "go modules").unwrap();
+
+        assert_eq!(sources.len(), 1);
+        assert_eq!(sources[0].url, "https://go.dev/doc/");
+        let requests = handle.join().unwrap();
+        assert_eq!(requests.len(), 2);
+        assert!(requests[1].starts_with("GET /search?q=go+modules&format=json "));
+    }
+
+    #[test]
+    fn client_errors_are_not_retried() {
+        let (base, handle) = serve_http_sequence(vec![("403 Forbidden", "no".to_string())]);
+
+        let err = discover_sources(&settings("searxng", &base), "go").unwrap_err();
+
+        assert!(err.to_string().contains("403"));
+        assert_eq!(handle.join().unwrap().len(), 1);
+    }
+}
@@ -1,3 +1,4 @@
+use crate::config::Config;
 use fs2::FileExt;
 use serde_json::{Map, Value};
 use std::collections::BTreeMap;
@@ -5,10 +6,15 @@ use std::env;
 use std::error::Error;
 use std::fmt;
 use std::fs::{self, File, OpenOptions};
-use std::path::PathBuf;
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
 use std::thread;
 use std::time::{Duration, Instant};
 
+mod sqlite;
+
+use sqlite::SqliteStore;
+
 #[derive(Debug)]
 pub enum StateError {
     Io {
@@ -19,11 +25,33 @@ pub enum StateError {
         path: PathBuf,
         source: serde_json::Error,
     },
+    /// An error from the SQLite store's database.
+    Sqlite {
+        path: PathBuf,
+        source: rusqlite::Error,
+    },
     LockTimeout {
         timeout: Duration,
     },
     InvalidSessionName,
     InvalidState(String),
+    /// The name matches sessions in more than one project.
+    AmbiguousSession {
+        name: String,
+        dirs: Vec<String>,
+    },
+    /// The state was written by a newer gralph with a schema this one does
+    /// not know.
+    UnsupportedVersion {
+        path: PathBuf,
+        version: u64,
+    },
+    /// The session was written by someone else since `expected` was read.
+    Conflict {
+        name: String,
+        expected: u64,
+        actual: u64,
+    },
 }
 
 impl fmt::Display for StateError {
@@ -35,11 +63,36 @@ impl fmt::Display for StateError {
             StateError::Json { path, source } => {
                 write!(f, "state json error at {}: {}", path.display(), source)
             }
+            StateError::Sqlite { path, source } => {
+                write!(f, "state database error at {}: {}", path.display(), source)
+            }
             StateError::LockTimeout { timeout } => {
                 write!(f, "failed to acquire state lock within {:?}", timeout)
             }
             StateError::InvalidSessionName => write!(f, "session name is required"),
             StateError::InvalidState(message) => write!(f, "invalid state: {}", message),
+            StateError::AmbiguousSession { name, dirs } => write!(
+                f,
+                "session '{}' exists in several projects ({}); run the command inside one of them",
+                name,
+                dirs.join(", ")
+            ),
+            StateError::UnsupportedVersion { path, version } => write!(
+                f,
+                "{} has schema version {}, newer than this gralph supports ({}); upgrade gralph",
+                path.display(),
+                version,
+                STATE_VERSION
+            ),
+            StateError::Conflict {
+                name,
+                expected,
+                actual,
+            } => write!(
+                f,
+                "session '{}' changed while it was being updated (expected revision {}, found {})",
+                name, expected, actual
+            ),
         }
     }
 }
@@ -49,80 +102,177 @@ impl Error for StateError {
         match self {
             StateError::Io { source, .. } => Some(source),
             StateError::Json { source, .. } => Some(source),
+            StateError::Sqlite { source, .. } => Some(source),
             _ => None,
         }
     }
 }
 
+/// Schema version of the state written by this build. A `state.json`
+/// without a `version` is version 1.
+pub const STATE_VERSION: u64 = 2;
+
+/// Upgrades from version `n + 1` to `n + 2`, in order.
+const MIGRATIONS: [fn(&mut StateData); 1] = [migrate_v1_to_v2];
+
+/// The result of [`StateStore::migrate`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct StateMigration {
+    pub from: u64,
+    pub to: u64,
+    /// Copy of the state as it was before the migration.
+    pub backup: PathBuf,
+}
+
+/// Session field counting the writes to a session. Every write bumps it, so
+/// a writer can tell whether the session changed since it read it.
+pub const REVISION_FIELD: &str = "revision";
+
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 pub enum CleanupMode {
     Mark,
     Remove,
 }
 
+/// Where a [`StateStore`] keeps the state, chosen with `state.driver`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum StateDriver {
+    /// `state.json`, rewritten whole under a lock on `state.lock`.
+    #[default]
+    Json,
+    /// `state.db`, a SQLite database with one row per session.
+    Sqlite,
+}
+
+impl StateDriver {
+    /// Names accepted by `state.driver`.
+    pub const NAMES: [&str; 2] = ["json", "sqlite"];
+
+    pub fn parse(value: &str) -> Option<Self> {
+        match value.trim().to_ascii_lowercase().as_str() {
+            "json" => Some(StateDriver::Json),
+            "sqlite" => Some(StateDriver::Sqlite),
+            _ => None,
+        }
+    }
+
+    /// The driver `state.driver` names in `config`, or JSON when it is unset.
+    /// Fails on a name that is not a driver.
+    pub fn from_config(config: &Config) -> Result<Self, StateError> {
+        let Some(value) = config
+            .get("state.driver")
+            .filter(|value| !value.trim().is_empty())
+        else {
+            return Ok(StateDriver::Json);
+        };
+        Self::parse(&value).ok_or_else(|| {
+            StateError::InvalidState(format!(
+                "unknown state.driver: {} (expected {})",
+                value.trim(),
+                Self::NAMES.join(", ")
+            ))
+        })
+    }
+}
+
+/// Sessions live in one store for every project: `state.json`, or a SQLite
+/// database with `state.driver: sqlite`. Each is keyed by [`session_key`],
+/// so projects can reuse a name; commands still address sessions by name and
+/// [`StateStore::in_project`] says which project's.
 #[derive(Debug, Clone)]
 pub struct StateStore {
     state_dir: PathBuf,
-    state_file: PathBuf,
-    lock_file: PathBuf,
-    lock_timeout: Duration,
+    store: Arc<dyn Store>,
+    project: Option<PathBuf>,
+}
+
+/// Where the state is kept. Every [`StateStore`] operation is one
+/// transaction: the store loads the state, runs the operation on it, and
+/// saves the result without another gralph process writing in between.
+trait Store: fmt::Debug + Send + Sync {
+    /// Creates the state if it is missing and migrates it to
+    /// [`STATE_VERSION`].
+    fn prepare(&self) -> Result<(), StateError>;
+
+    /// Migrates the state to [`STATE_VERSION`], keeping a copy of the old
+    /// one. Returns `None` when it is already current.
+    fn migrate(&self) -> Result<Option<StateMigration>, StateError>;
+
+    /// Runs `op` on the prepared, current state and saves the state when `op`
+    /// returns true. A store may run `op` again to retry a transaction that
+    /// lost a race, so `op` must not do more than change the state it gets.
+    fn transact(
+        &self,
+        op: &mut dyn FnMut(&mut StateData) -> Result<bool, StateError>,
+    ) -> Result<(), StateError>;
 }
 
 impl StateStore {
+    /// The JSON store in `GRALPH_STATE_DIR` (default `~/.config/gralph`).
     pub fn new_from_env() -> Self {
-        let state_dir = env::var("GRALPH_STATE_DIR")
-            .map(PathBuf::from)
-            .unwrap_or_else(|_| default_state_dir());
-        let state_file = env::var("GRALPH_STATE_FILE")
-            .map(PathBuf::from)
-            .unwrap_or_else(|_| state_dir.join("state.json"));
-        let lock_file = env::var("GRALPH_LOCK_FILE")
-            .map(PathBuf::from)
-            .unwrap_or_else(|_| state_dir.join("state.lock"));
-        let lock_timeout = env::var("GRALPH_LOCK_TIMEOUT")
-            .ok()
-            .and_then(|value| value.parse::<u64>().ok())
-            .map(Duration::from_secs)
-            .unwrap_or_else(|| Duration::from_secs(10));
-
-        Self::with_paths(state_dir, state_file, lock_file, lock_timeout)
+        Self::with_driver(StateDriver::Json)
+    }
+
+    /// The store `driver` names in `GRALPH_STATE_DIR`. The SQLite store
+    /// keeps `state.db` there and takes over an existing `state.json`.
+    pub fn with_driver(driver: StateDriver) -> Self {
+        let json = JsonStore::from_env();
+        let state_dir = json.state_dir.clone();
+        let store: Arc<dyn Store> = match driver {
+            StateDriver::Json => Arc::new(json),
+            StateDriver::Sqlite => Arc::new(SqliteStore::new(
+                state_dir.join("state.db"),
+                json.state_file,
+                json.lock_timeout,
+            )),
+        };
+        Self {
+            state_dir,
+            store,
+            project: None,
+        }
     }
 
+    /// A JSON store backed by `state_file`, locked through `lock_file`.
     pub fn with_paths(
         state_dir: PathBuf,
         state_file: PathBuf,
         lock_file: PathBuf,
         lock_timeout: Duration,
     ) -> Self {
+        let json = JsonStore::with_paths(state_dir.clone(), state_file, lock_file, lock_timeout);
         Self {
             state_dir,
-            state_file,
-            lock_file,
-            lock_timeout,
+            store: Arc::new(json),
+            project: None,
         }
     }
 
-    pub fn init_state(&self) -> Result<(), StateError> {
-        if !self.state_dir.exists() {
-            fs::create_dir_all(&self.state_dir).map_err(|source| StateError::Io {
-                path: self.state_dir.clone(),
-                source,
-            })?;
-        }
+    /// Limits name lookups to sessions of the project at `dir`, and keys new
+    /// sessions by it. Without a project, a name must be unique across
+    /// projects unless the current directory is inside one of them.
+    pub fn in_project(mut self, dir: &Path) -> Self {
+        self.project = Some(dir.to_path_buf());
+        self
+    }
 
-        if !self.state_file.exists() {
-            let empty = empty_state();
-            self.write_state(&empty)?;
-        }
+    /// Directory holding the state file and the session history.
+    pub fn state_dir(&self) -> &Path {
+        &self.state_dir
+    }
 
-        match self.read_state() {
-            Ok(_) => Ok(()),
-            Err(StateError::Json { .. }) => {
-                let empty = empty_state();
-                self.write_state(&empty)
-            }
-            Err(error) => Err(error),
-        }
+    /// Creates the state if it is missing, resets `state.json` if it is not
+    /// valid JSON, and migrates the state to [`STATE_VERSION`].
+    pub fn init_state(&self) -> Result<(), StateError> {
+        self.store.prepare()
+    }
+
+    /// Upgrades the state to [`STATE_VERSION`], keeping a copy of the old
+    /// state next to it. [`StateStore::init_state`] does this on its own, so
+    /// every command migrates; this is for doing it up front. Returns `None`
+    /// when the state is already current.
+    pub fn migrate(&self) -> Result<Option<StateMigration>, StateError> {
+        self.store.migrate()
     }
 
     pub fn get_session(&self, name: &str) -> Result<Option<Value>, StateError> {
@@ -130,61 +280,144 @@ impl StateStore {
             return Err(StateError::InvalidSessionName);
         }
 
-        self.with_lock(|| {
-            self.init_state()?;
-            let state = self.read_state()?;
-            Ok(state.sessions.get(name).cloned())
+        self.read(|state| {
+            let key = resolve_key(&state.sessions, name, self.project.as_deref())?;
+            Ok(key.and_then(|key| state.sessions.get(&key).cloned()))
         })
     }
 
     pub fn set_session(&self, name: &str, fields: &[(&str, &str)]) -> Result<(), StateError> {
+        self.write_session(name, None, fields).map(|_| ())
+    }
+
+    /// Like [`StateStore::set_session`], but only when the session is still at
+    /// `revision` (0 for a session that does not exist yet); otherwise fails
+    /// with [`StateError::Conflict`]. Returns the new revision.
+    pub fn set_session_if(
+        &self,
+        name: &str,
+        revision: u64,
+        fields: &[(&str, &str)],
+    ) -> Result<u64, StateError> {
+        self.write_session(name, Some(revision), fields)
+    }
+
+    /// Replaces a session's fields with `update(fields)` in one transaction,
+    /// so no other writer can change the session between the read and the
+    /// write. Returns the session as stored, or `None` when it does not exist.
+    /// A session `update` leaves unchanged is not written. `update` may run
+    /// again when the store retries the transaction.
+    pub fn update_session(
+        &self,
+        name: &str,
+        mut update: impl FnMut(Map<String, Value>) -> Map<String, Value>,
+    ) -> Result<Option<Value>, StateError> {
         if name.trim().is_empty() {
             return Err(StateError::InvalidSessionName);
         }
 
-        self.with_lock(|| {
-            self.init_state()?;
-            let mut state = self.read_state()?;
+        self.transact(|state| {
+            let Some(key) = resolve_key(&state.sessions, name, self.project.as_deref())? else {
+                return Ok((None, false));
+            };
+            let current = state
+                .sessions
+                .get(&key)
+                .and_then(Value::as_object)
+                .cloned()
+                .unwrap_or_default();
+            let revision = session_revision(&current);
+            let mut updated = update(current.clone());
+            if updated == current {
+                return Ok((Some(Value::Object(current)), false));
+            }
+            updated.insert(REVISION_FIELD.to_string(), Value::from(revision + 1));
+            let session = Value::Object(updated);
+            state.sessions.insert(key, session.clone());
+            Ok((Some(session), true))
+        })
+    }
+
+    fn write_session(
+        &self,
+        name: &str,
+        expected: Option<u64>,
+        fields: &[(&str, &str)],
+    ) -> Result<u64, StateError> {
+        if name.trim().is_empty() {
+            return Err(StateError::InvalidSessionName);
+        }
+
+        // Writing a session's directory pins it to that project, so a new
+        // loop never takes over another project's session.
+        let project = self.project.clone().or_else(|| {
+            fields
+                .iter()
+                .find(|(key, value)| *key == "dir" && !value.is_empty())
+                .map(|(_, dir)| PathBuf::from(dir))
+        });
+        self.transact(|state| {
+            let state_key =
+                resolve_key(&state.sessions, name, project.as_deref())?.unwrap_or_else(|| {
+                    match &project {
+                        Some(dir) => session_key(dir, name),
+                        None => name.to_string(),
+                    }
+                });
             let mut session = state
                 .sessions
-                .remove(name)
+                .remove(&state_key)
                 .and_then(|value| value.as_object().cloned())
                 .unwrap_or_else(Map::new);
-            session.insert("name".to_string(), Value::String(name.to_string()));
+            let revision = session_revision(&session);
+            if let Some(expected) = expected.filter(|expected| *expected != revision) {
+                return Err(StateError::Conflict {
+                    name: name.to_string(),
+                    expected,
+                    actual: revision,
+                });
+            }
+            if !session.contains_key("name") {
+                session.insert("name".to_string(), Value::String(name.to_string()));
+            }
             for (key, raw) in fields {
-                if key.trim().is_empty() {
+                if key.trim().is_empty() || *key == REVISION_FIELD {
                     continue;
                 }
                 let value = parse_value(raw);
                 session.insert((*key).to_string(), value);
             }
-            state
-                .sessions
-                .insert(name.to_string(), Value::Object(session));
-            self.write_state(&state)
+            session.insert(REVISION_FIELD.to_string(), Value::from(revision + 1));
+            state.sessions.insert(state_key, Value::Object(session));
+            Ok((revision + 1, true))
         })
     }
 
     pub fn list_sessions(&self) -> Result<Vec<Value>, StateError> {
-        self.with_lock(|| {
-            self.init_state()?;
-            let state = self.read_state()?;
-            let mut sessions = Vec::new();
-            for (name, value) in state.sessions {
-                let session = match value {
-                    Value::Object(mut map) => {
-                        map.insert("name".to_string(), Value::String(name));
-                        Value::Object(map)
-                    }
-                    _ => {
-                        let mut map = Map::new();
-                        map.insert("name".to_string(), Value::String(name));
-                        Value::Object(map)
-                    }
-                };
-                sessions.push(session);
-            }
-            Ok(sessions)
+        self.read(|state| {
+            Ok(state
+                .sessions
+                .iter()
+                .map(|(key, value)| named_session(key, value))
+                .collect())
+        })
+    }
+
+    /// Sessions paired with their state keys, limited to the project set
+    /// with [`StateStore::in_project`] when there is one.
+    pub fn list_session_entries(&self) -> Result<Vec<(String, Value)>, StateError> {
+        let project = self.project.as_deref().map(project_id);
+        self.read(|state| {
+            Ok(state
+                .sessions
+                .iter()
+                .filter(|(_, session)| {
+                    project.as_ref().is_none_or(|id| {
+                        session_dir(session).is_some_and(|dir| project_id(&dir) == *id)
+                    })
+                })
+                .map(|(key, value)| (key.clone(), named_session(key, value)))
+                .collect())
         })
     }
 
@@ -193,23 +426,32 @@ impl StateStore {
             return Err(StateError::InvalidSessionName);
         }
 
-        self.with_lock(|| {
-            self.init_state()?;
-            let mut state = self.read_state()?;
-            if state.sessions.remove(name).is_none() {
+        self.transact(|state| {
+            let Some(key) = resolve_key(&state.sessions, name, self.project.as_deref())? else {
                 return Err(StateError::InvalidState(format!(
                     "session '{}' not found",
                     name
                 )));
-            }
-            self.write_state(&state)
+            };
+            state.sessions.remove(&key);
+            Ok(((), true))
+        })
+    }
+
+    /// Removes the session stored under state `key`, as returned by
+    /// [`StateStore::list_session_entries`], without resolving it as a name.
+    pub fn delete_session_key(&self, key: &str) -> Result<(), StateError> {
+        self.transact(|state| match state.sessions.remove(key) {
+            Some(_) => Ok(((), true)),
+            None => Err(StateError::InvalidState(format!(
+                "session '{}' not found",
+                key
+            ))),
         })
     }
 
     pub fn cleanup_stale(&self, mode: CleanupMode) -> Result<Vec<String>, StateError> {
-        self.with_lock(|| {
-            self.init_state()?;
-            let mut state = self.read_state()?;
+        self.transact(|state| {
             let mut cleaned = Vec::new();
             let mut updates: BTreeMap<String, Value> = BTreeMap::new();
 
@@ -218,7 +460,7 @@ impl StateStore {
                     continue;
                 };
                 let status = map.get("status").and_then(|v| v.as_str()).unwrap_or("");
-                if status != "running" {
+                if !matches!(status, "running" | "paused") {
                     continue;
                 }
                 let pid = map.get("pid").and_then(|v| v.as_i64()).unwrap_or(0);
@@ -229,7 +471,7 @@ impl StateStore {
                     continue;
                 }
 
-                cleaned.push(name.clone());
+                cleaned.push(display_name(name, value));
                 match mode {
                     CleanupMode::Remove => {
                         updates.insert(name.clone(), Value::Null);
@@ -237,6 +479,10 @@ impl StateStore {
                     CleanupMode::Mark => {
                         let mut session = map.clone();
                         session.insert("status".to_string(), Value::String("stale".to_string()));
+                        session.insert(
+                            REVISION_FIELD.to_string(),
+                            Value::from(session_revision(map) + 1),
+                        );
                         updates.insert(name.clone(), Value::Object(session));
                     }
                 }
@@ -250,27 +496,271 @@ impl StateStore {
                 }
             }
 
-            if !cleaned.is_empty() {
-                self.write_state(&state)?;
+            let changed = !cleaned.is_empty();
+            Ok((cleaned, changed))
+        })
+    }
+
+    pub fn purge_all(&self) -> Result<Vec<String>, StateError> {
+        self.transact(|state| {
+            let names = state
+                .sessions
+                .iter()
+                .map(|(key, value)| display_name(key, value))
+                .collect::<Vec<_>>();
+            state.sessions.clear();
+            let changed = !names.is_empty();
+            Ok((names, changed))
+        })
+    }
+
+    /// Appends `fields` to the queue with `status: queued` and the next free
+    /// id, and returns the id.
+    pub fn enqueue(&self, fields: Map<String, Value>) -> Result<u64, StateError> {
+        self.transact(|state| {
+            let id = state.queue.iter().map(queue_entry_id).max().unwrap_or(0) + 1;
+            let mut entry = fields.clone();
+            entry.insert("id".to_string(), Value::from(id));
+            entry.insert("status".to_string(), Value::String("queued".to_string()));
+            state.queue.push(Value::Object(entry));
+            Ok((id, true))
+        })
+    }
+
+    pub fn list_queue(&self) -> Result<Vec<Value>, StateError> {
+        self.read(|state| Ok(state.queue.clone()))
+    }
+
+    /// Marks the oldest queued entry as running with `fields` and returns it.
+    /// Runs as one transaction, so two runners never claim the same entry.
+    pub fn claim_queue_entry(&self, fields: &[(&str, &str)]) -> Result<Option<Value>, StateError> {
+        self.transact(|state| {
+            let Some(entry) = state
+                .queue
+                .iter_mut()
+                .find(|entry| entry.get("status").and_then(Value::as_str) == Some("queued"))
+            else {
+                return Ok((None, false));
+            };
+            entry["status"] = Value::String("running".to_string());
+            for (key, raw) in fields {
+                entry[*key] = parse_value(raw);
             }
+            Ok((Some(entry.clone()), true))
+        })
+    }
 
-            Ok(cleaned)
+    pub fn update_queue_entry(&self, id: u64, fields: &[(&str, &str)]) -> Result<(), StateError> {
+        self.transact(|state| {
+            let Some(entry) = state
+                .queue
+                .iter_mut()
+                .find(|entry| queue_entry_id(entry) == id)
+            else {
+                return Err(StateError::InvalidState(format!(
+                    "queue entry {} not found",
+                    id
+                )));
+            };
+            for (key, raw) in fields {
+                if key.trim().is_empty() {
+                    continue;
+                }
+                entry[*key] = parse_value(raw);
+            }
+            Ok(((), true))
         })
     }
 
-    pub fn purge_all(&self) -> Result<Vec<String>, StateError> {
-        self.with_lock(|| {
-            self.init_state()?;
-            let mut state = self.read_state()?;
-            let names = state.sessions.keys().cloned().collect::<Vec<_>>();
-            if !names.is_empty() {
-                state.sessions.clear();
-                self.write_state(&state)?;
+    pub fn remove_queue_entry(&self, id: u64) -> Result<(), StateError> {
+        self.transact(|state| {
+            let before = state.queue.len();
+            state.queue.retain(|entry| queue_entry_id(entry) != id);
+            if state.queue.len() == before {
+                return Err(StateError::InvalidState(format!(
+                    "queue entry {} not found",
+                    id
+                )));
             }
-            Ok(names)
+            Ok(((), true))
         })
     }
 
+    /// Appends a notification waiting for a retry with the next free id,
+    /// and returns the id.
+    pub fn add_notification(&self, fields: Map<String, Value>) -> Result<u64, StateError> {
+        self.transact(|state| {
+            let id = state
+                .notifications
+                .iter()
+                .map(queue_entry_id)
+                .max()
+                .unwrap_or(0)
+                + 1;
+            let mut entry = fields.clone();
+            entry.insert("id".to_string(), Value::from(id));
+            state.notifications.push(Value::Object(entry));
+            Ok((id, true))
+        })
+    }
+
+    pub fn list_notifications(&self) -> Result<Vec<Value>, StateError> {
+        self.read(|state| Ok(state.notifications.clone()))
+    }
+
+    /// Replaces the fields of notification `id`, keeping its id.
+    pub fn replace_notification(
+        &self,
+        id: u64,
+        fields: Map<String, Value>,
+    ) -> Result<(), StateError> {
+        self.transact(|state| {
+            let Some(entry) = state
+                .notifications
+                .iter_mut()
+                .find(|entry| queue_entry_id(entry) == id)
+            else {
+                return Err(StateError::InvalidState(format!(
+                    "notification {} not found",
+                    id
+                )));
+            };
+            let mut fields = fields.clone();
+            fields.insert("id".to_string(), Value::from(id));
+            *entry = Value::Object(fields);
+            Ok(((), true))
+        })
+    }
+
+    pub fn remove_notification(&self, id: u64) -> Result<(), StateError> {
+        self.transact(|state| {
+            let before = state.notifications.len();
+            state
+                .notifications
+                .retain(|entry| queue_entry_id(entry) != id);
+            if state.notifications.len() == before {
+                return Err(StateError::InvalidState(format!(
+                    "notification {} not found",
+                    id
+                )));
+            }
+            Ok(((), true))
+        })
+    }
+
+    /// Runs `op` as one transaction of the store. `op` returns its result
+    /// and whether it changed the state, which is saved only then.
+    fn transact<T>(
+        &self,
+        mut op: impl FnMut(&mut StateData) -> Result<(T, bool), StateError>,
+    ) -> Result<T, StateError> {
+        let mut output = None;
+        self.store.transact(&mut |state| {
+            let (value, changed) = op(state)?;
+            output = Some(value);
+            Ok(changed)
+        })?;
+        output.ok_or_else(|| StateError::InvalidState("state transaction did not run".to_string()))
+    }
+
+    fn read<T>(
+        &self,
+        mut op: impl FnMut(&StateData) -> Result<T, StateError>,
+    ) -> Result<T, StateError> {
+        self.transact(|state| op(state).map(|value| (value, false)))
+    }
+}
+
+/// The default [`Store`]: `state.json`, rewritten whole on every change
+/// under an advisory lock on `state.lock`.
+#[derive(Debug, Clone)]
+struct JsonStore {
+    state_dir: PathBuf,
+    state_file: PathBuf,
+    lock_file: PathBuf,
+    lock_timeout: Duration,
+}
+
+impl JsonStore {
+    fn from_env() -> Self {
+        let state_dir = env::var("GRALPH_STATE_DIR")
+            .map(PathBuf::from)
+            .unwrap_or_else(|_| default_state_dir());
+        let state_file = env::var("GRALPH_STATE_FILE")
+            .map(PathBuf::from)
+            .unwrap_or_else(|_| state_dir.join("state.json"));
+        let lock_file = env::var("GRALPH_LOCK_FILE")
+            .map(PathBuf::from)
+            .unwrap_or_else(|_| state_dir.join("state.lock"));
+        let lock_timeout = env::var("GRALPH_LOCK_TIMEOUT")
+            .ok()
+            .and_then(|value| value.parse::<u64>().ok())
+            .map(Duration::from_secs)
+            .unwrap_or_else(|| Duration::from_secs(10));
+
+        Self::with_paths(state_dir, state_file, lock_file, lock_timeout)
+    }
+
+    fn with_paths(
+        state_dir: PathBuf,
+        state_file: PathBuf,
+        lock_file: PathBuf,
+        lock_timeout: Duration,
+    ) -> Self {
+        Self {
+            state_dir,
+            state_file,
+            lock_file,
+            lock_timeout,
+        }
+    }
+
+    /// [`Store::prepare`] for callers already holding the lock.
+    fn prepare_state(&self) -> Result<(), StateError> {
+        if !self.state_dir.exists() {
+            fs::create_dir_all(&self.state_dir).map_err(|source| StateError::Io {
+                path: self.state_dir.clone(),
+                source,
+            })?;
+        }
+
+        if !self.state_file.exists() {
+            let empty = empty_state();
+            self.write_state(&empty)?;
+        }
+
+        match self.read_state() {
+            Ok(state) => self.migrate_state(state).map(|_| ()),
+            Err(StateError::Json { .. }) => {
+                let empty = empty_state();
+                self.write_state(&empty)
+            }
+            Err(error) => Err(error),
+        }
+    }
+
+    /// Migrates `state` and rewrites the file. The caller holds the state
+    /// lock, so no other writer sees or overwrites a half-migrated file.
+    fn migrate_state(&self, mut state: StateData) -> Result<Option<StateMigration>, StateError> {
+        let from = state.version;
+        check_version(&self.state_file, from)?;
+        if from == STATE_VERSION {
+            return Ok(None);
+        }
+        let backup = backup_path(&self.state_file, from);
+        fs::copy(&self.state_file, &backup).map_err(|source| StateError::Io {
+            path: backup.clone(),
+            source,
+        })?;
+        run_migrations(&mut state);
+        self.write_state(&state)?;
+        Ok(Some(StateMigration {
+            from,
+            to: STATE_VERSION,
+            backup,
+        }))
+    }
+
     fn with_lock<T>(&self, op: impl FnOnce() -> Result<T, StateError>) -> Result<T, StateError> {
         if !self.state_dir.exists() {
             fs::create_dir_all(&self.state_dir).map_err(|source| StateError::Io {
@@ -325,17 +815,230 @@ impl StateStore {
     }
 }
 
+impl Store for JsonStore {
+    fn prepare(&self) -> Result<(), StateError> {
+        self.with_lock(|| self.prepare_state())
+    }
+
+    fn migrate(&self) -> Result<Option<StateMigration>, StateError> {
+        self.with_lock(|| {
+            if !self.state_file.exists() {
+                self.prepare_state()?;
+                return Ok(None);
+            }
+            let state = self.read_state()?;
+            self.migrate_state(state)
+        })
+    }
+
+    fn transact(
+        &self,
+        op: &mut dyn FnMut(&mut StateData) -> Result<bool, StateError>,
+    ) -> Result<(), StateError> {
+        self.with_lock(|| {
+            self.prepare_state()?;
+            let mut state = self.read_state()?;
+            if op(&mut state)? {
+                self.write_state(&state)?;
+            }
+            Ok(())
+        })
+    }
+}
+
 #[derive(Debug, Clone, serde::Serialize, serde::Deserialize)]
 struct StateData {
+    #[serde(default = "unversioned")]
+    version: u64,
     sessions: BTreeMap<String, Value>,
+    /// Projects waiting for `gralph queue run`, in the order they were added.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    queue: Vec<Value>,
+    /// Notifications whose send failed, waiting for a retry.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    notifications: Vec<Value>,
+}
+
+/// Stable id of a project directory, used as the first half of its sessions'
+/// state keys. FNV-1a over the canonical path, so it is the same on every
+/// run and build.
+pub fn project_id(dir: &Path) -> String {
+    let dir = dir.canonicalize().unwrap_or_else(|_| dir.to_path_buf());
+    let mut hash: u64 = 0xcbf2_9ce4_8422_2325;
+    for byte in dir.to_string_lossy().bytes() {
+        hash ^= u64::from(byte);
+        hash = hash.wrapping_mul(0x0100_0000_01b3);
+    }
+    format!("{:012x}", hash >> 16)
+}
+
+/// State key of session `name` in the project at `dir`: `<project id>/<name>`.
+pub fn session_key(dir: &Path, name: &str) -> String {
+    format!("{}/{}", project_id(dir), name)
+}
+
+/// `session` as listed: an object that always has a `name`, falling back to
+/// its state `key`.
+fn named_session(key: &str, session: &Value) -> Value {
+    let mut map = session.as_object().cloned().unwrap_or_default();
+    if !map.get("name").is_some_and(Value::is_string) {
+        map.insert("name".to_string(), Value::String(key.to_string()));
+    }
+    Value::Object(map)
+}
+
+fn display_name(key: &str, session: &Value) -> String {
+    session
+        .get("name")
+        .and_then(Value::as_str)
+        .unwrap_or(key)
+        .to_string()
+}
+
+fn session_dir(session: &Value) -> Option<PathBuf> {
+    session
+        .get("dir")
+        .and_then(Value::as_str)
+        .filter(|dir| !dir.is_empty())
+        .map(PathBuf::from)
+}
+
+/// The state key for `name`, which may also be a full key. With a project,
+/// only that project's session matches. Without one, the name must be
+/// unique, or the current directory must be inside exactly one of the
+/// matching sessions' directories. Sessions stored before keys carried a
+/// project are matched by their `dir`.
+fn resolve_key(
+    sessions: &BTreeMap<String, Value>,
+    name: &str,
+    project: Option<&Path>,
+) -> Result<Option<String>, StateError> {
+    let matches = sessions
+        .iter()
+        .filter(|(key, session)| key.as_str() == name || display_name(key, session) == name)
+        .collect::<Vec<_>>();
+    if let Some(dir) = project {
+        let id = project_id(dir);
+        return Ok(matches
+            .iter()
+            .find(|(_, session)| session_dir(session).is_some_and(|dir| project_id(&dir) == id))
+            .map(|(key, _)| (*key).clone()));
+    }
+    if matches.len() <= 1 {
+        return Ok(matches.first().map(|(key, _)| (*key).clone()));
+    }
+    let cwd = env::current_dir()
+        .ok()
+        .and_then(|cwd| cwd.canonicalize().ok());
+    let inside = matches
+        .iter()
+        .filter(|(_, session)| {
+            let dir = session_dir(session).and_then(|dir| dir.canonicalize().ok());
+            matches!((&cwd, dir), (Some(cwd), Some(dir)) if cwd.starts_with(dir))
+        })
+        .collect::<Vec<_>>();
+    if let [(key, _)] = inside.as_slice() {
+        return Ok(Some((*key).clone()));
+    }
+    Err(StateError::AmbiguousSession {
+        name: name.to_string(),
+        dirs: matches
+            .iter()
+            .map(|(key, session)| {
+                session_dir(session)
+                    .map(|dir| dir.display().to_string())
+                    .unwrap_or_else(|| (*key).clone())
+            })
+            .collect(),
+    })
+}
+
+fn session_revision(session: &Map<String, Value>) -> u64 {
+    session
+        .get(REVISION_FIELD)
+        .and_then(Value::as_u64)
+        .unwrap_or(0)
+}
+
+/// Version 2 keys every session that knows its directory by
+/// [`session_key`] and stores its name in the record. Version 1 files could
+/// still hold sessions keyed by name alone.
+fn migrate_v1_to_v2(state: &mut StateData) {
+    let sessions = std::mem::take(&mut state.sessions);
+    let old_keys: Vec<String> = sessions.keys().cloned().collect();
+    for (key, value) in sessions {
+        let Value::Object(mut session) = value else {
+            state.sessions.insert(key, value);
+            continue;
+        };
+        let name = session
+            .get("name")
+            .and_then(Value::as_str)
+            .unwrap_or(&key)
+            .to_string();
+        session.insert("name".to_string(), Value::String(name.clone()));
+        let dir = session
+            .get("dir")
+            .and_then(Value::as_str)
+            .filter(|dir| !dir.is_empty())
+            .map(PathBuf::from);
+        let new_key = match dir {
+            Some(dir) if key == name => session_key(&dir, &name),
+            _ => key.clone(),
+        };
+        let taken = new_key != key
+            && (old_keys.contains(&new_key) || state.sessions.contains_key(&new_key));
+        state
+            .sessions
+            .insert(if taken { key } else { new_key }, Value::Object(session));
+    }
+}
+
+fn unversioned() -> u64 {
+    1
+}
+
+/// Fails on a state at `path` whose `version` is newer than this build.
+fn check_version(path: &Path, version: u64) -> Result<(), StateError> {
+    if version > STATE_VERSION {
+        return Err(StateError::UnsupportedVersion {
+            path: path.to_path_buf(),
+            version,
+        });
+    }
+    Ok(())
+}
+
+/// Where the copy of the state at `path` is kept before migrating it from
+/// `version`.
+fn backup_path(path: &Path, version: u64) -> PathBuf {
+    PathBuf::from(format!("{}.v{}.bak", path.display(), version))
+}
+
+/// Runs the migrations from `state.version` up to [`STATE_VERSION`].
+fn run_migrations(state: &mut StateData) {
+    for migration in MIGRATIONS
+        .iter()
+        .skip(state.version.saturating_sub(1) as usize)
+    {
+        migration(state);
+    }
+    state.version = STATE_VERSION;
 }
 
 fn empty_state() -> StateData {
     StateData {
+        version: STATE_VERSION,
         sessions: BTreeMap::new(),
+        queue: Vec::new(),
+        notifications: Vec::new(),
     }
 }
 
+fn queue_entry_id(entry: &Value) -> u64 {
+    entry.get("id").and_then(Value::as_u64).unwrap_or(0)
+}
+
 fn validate_state_content(content: &str) -> Result<(), StateError> {
     if content.trim().is_empty() {
         return Err(StateError::InvalidState(
@@ -498,12 +1201,19 @@ mod tests {
         StateStore::with_paths(state_dir, state_file, lock_file, timeout)
     }
 
+    fn json_for_test(dir: &Path, timeout: Duration) -> JsonStore {
+        let state_dir = dir.join("state");
+        let state_file = state_dir.join("state.json");
+        let lock_file = state_dir.join("state.lock");
+        JsonStore::with_paths(state_dir, state_file, lock_file, timeout)
+    }
+
     #[test]
     fn lock_times_out_when_held() {
         let temp = tempfile::tempdir().unwrap();
         // Short timeout (100ms) so test completes quickly
-        let store = Arc::new(store_for_test(temp.path(), Duration::from_millis(100)));
-        store.init_state().unwrap();
+        let store = Arc::new(json_for_test(temp.path(), Duration::from_millis(100)));
+        store.prepare().unwrap();
 
         let blocker = Arc::clone(&store);
         let handle = thread::spawn(move || {
@@ -526,8 +1236,8 @@ mod tests {
     #[test]
     fn lock_timeout_zero_expires_immediately() {
         let temp = tempfile::tempdir().unwrap();
-        let store = Arc::new(store_for_test(temp.path(), Duration::from_millis(0)));
-        store.init_state().unwrap();
+        let store = Arc::new(json_for_test(temp.path(), Duration::from_millis(0)));
+        store.prepare().unwrap();
 
         let (tx, rx) = mpsc::channel();
         let blocker = Arc::clone(&store);
@@ -576,11 +1286,12 @@ mod tests {
     fn atomic_write_persists_state() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
         store
             .set_session("alpha", &[("status", "running"), ("pid", "123")])
             .unwrap();
 
-        let contents = fs::read_to_string(store.state_file).unwrap();
+        let contents = fs::read_to_string(json.state_file).unwrap();
         assert!(!contents.trim().is_empty());
         let parsed: StateData = serde_json::from_str(&contents).unwrap();
         let session = parsed.sessions.get("alpha").unwrap();
@@ -594,7 +1305,7 @@ mod tests {
     #[test]
     fn read_state_propagates_io_error() {
         let temp = tempfile::tempdir().unwrap();
-        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let store = json_for_test(temp.path(), Duration::from_secs(1));
         fs::create_dir_all(&store.state_dir).unwrap();
         fs::create_dir_all(&store.state_file).unwrap();
 
@@ -610,7 +1321,7 @@ mod tests {
     #[test]
     fn read_state_propagates_json_error() {
         let temp = tempfile::tempdir().unwrap();
-        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let store = json_for_test(temp.path(), Duration::from_secs(1));
         fs::create_dir_all(&store.state_dir).unwrap();
         fs::write(&store.state_file, "{not json").unwrap();
 
@@ -626,7 +1337,7 @@ mod tests {
     #[test]
     fn write_state_propagates_rename_error() {
         let temp = tempfile::tempdir().unwrap();
-        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let store = json_for_test(temp.path(), Duration::from_secs(1));
         fs::create_dir_all(&store.state_dir).unwrap();
         fs::create_dir_all(&store.state_file).unwrap();
 
@@ -646,8 +1357,7 @@ mod tests {
         fs::write(&state_dir, "not a dir").unwrap();
         let state_file = state_dir.join("state.json");
         let lock_file = temp.path().join("state.lock");
-        let store =
-            StateStore::with_paths(state_dir, state_file, lock_file, Duration::from_secs(1));
+        let store = JsonStore::with_paths(state_dir, state_file, lock_file, Duration::from_secs(1));
         let tmp_file = store
             .state_file
             .with_extension(format!("tmp.{}", std::process::id()));
@@ -664,7 +1374,7 @@ mod tests {
     #[test]
     fn write_state_fails_on_tmp_file_collision() {
         let temp = tempfile::tempdir().unwrap();
-        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let store = json_for_test(temp.path(), Duration::from_secs(1));
         fs::create_dir_all(&store.state_dir).unwrap();
         let tmp_file = store
             .state_file
@@ -703,6 +1413,361 @@ mod tests {
         assert!(store.get_session("alpha").unwrap().is_none());
     }
 
+    #[test]
+    fn writes_bump_the_revision_and_stale_writers_conflict() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path(), Duration::from_secs(1));
+
+        assert_eq!(
+            store
+                .set_session_if("alpha", 0, &[("status", "running")])
+                .unwrap(),
+            1
+        );
+        store
+            .set_session("alpha", &[("iteration", "2"), ("revision", "99")])
+            .unwrap();
+        let session = store.get_session("alpha").unwrap().unwrap();
+        assert_eq!(session[REVISION_FIELD], 2);
+
+        match store.set_session_if("alpha", 1, &[("status", "stopped")]) {
+            Err(StateError::Conflict {
+                name,
+                expected,
+                actual,
+            }) => assert_eq!((name.as_str(), expected, actual), ("alpha", 1, 2)),
+            other => panic!("expected Conflict, got {other:?}"),
+        }
+        assert_eq!(
+            store.get_session("alpha").unwrap().unwrap()["status"],
+            "running"
+        );
+        assert_eq!(
+            store
+                .set_session_if("alpha", 2, &[("status", "stopped")])
+                .unwrap(),
+            3
+        );
+    }
+
+    #[test]
+    fn update_session_reads_and_writes_in_one_transaction() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        store
+            .set_session("alpha", &[("status", "running"), ("pid", "42")])
+            .unwrap();
+
+        let updated = store
+            .update_session("alpha", |mut fields| {
+                if fields.get("status").and_then(Value::as_str) == Some("running") {
+                    fields.insert("status".to_string(), Value::from("stopped"));
+                }
+                fields
+            })
+            .unwrap()
+            .unwrap();
+        assert_eq!(updated["status"], "stopped");
+        assert_eq!(updated["pid"], 42);
+        assert_eq!(updated[REVISION_FIELD], 2);
+
+        let unchanged = store.update_session("alpha", |fields| fields).unwrap();
+        assert_eq!(unchanged.unwrap()[REVISION_FIELD], 2);
+
+        let missing = store
+            .update_session("beta", |mut fields| {
+                fields.insert("status".to_string(), Value::from("stopped"));
+                fields
+            })
+            .unwrap();
+        assert!(missing.is_none());
+        assert!(store.get_session("beta").unwrap().is_none());
+    }
+
+    #[test]
+    fn sessions_with_the_same_name_are_kept_per_project() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        store.init_state().unwrap();
+        let api = temp.path().join("api");
+        let web = temp.path().join("web");
+        fs::create_dir_all(&api).unwrap();
+        fs::create_dir_all(&web).unwrap();
+        let api_dir = api.to_string_lossy().to_string();
+        let web_dir = web.to_string_lossy().to_string();
+
+        store
+            .set_session("app", &[("dir", &api_dir), ("status", "running")])
+            .unwrap();
+        store
+            .set_session("app", &[("dir", &web_dir), ("status", "running")])
+            .unwrap();
+        store
+            .clone()
+            .in_project(&web)
+            .set_session("app", &[("status", "stopped")])
+            .unwrap();
+
+        let sessions = store.list_sessions().unwrap();
+        assert_eq!(sessions.len(), 2);
+        assert!(sessions.iter().all(|session| session["name"] == "app"));
+        let status = |dir: &Path| {
+            store
+                .clone()
+                .in_project(dir)
+                .get_session("app")
+                .unwrap()
+                .unwrap()["status"]
+                .clone()
+        };
+        assert_eq!(status(&api), "running");
+        assert_eq!(status(&web), "stopped");
+        assert!(matches!(
+            store.get_session("app"),
+            Err(StateError::AmbiguousSession { .. })
+        ));
+        let key = session_key(&api, "app");
+        assert_eq!(store.get_session(&key).unwrap().unwrap()["dir"], api_dir);
+
+        store
+            .clone()
+            .in_project(&web)
+            .delete_session("app")
+            .unwrap();
+        assert_eq!(store.get_session("app").unwrap().unwrap()["dir"], api_dir);
+    }
+
+    #[test]
+    fn session_entries_are_listed_per_project_and_deleted_by_key() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let api = temp.path().join("api");
+        let web = temp.path().join("web");
+        fs::create_dir_all(&api).unwrap();
+        fs::create_dir_all(&web).unwrap();
+        for dir in [&api, &web] {
+            store
+                .set_session("app", &[("dir", &dir.to_string_lossy())])
+                .unwrap();
+        }
+
+        assert_eq!(store.list_session_entries().unwrap().len(), 2);
+        let entries = store
+            .clone()
+            .in_project(&web)
+            .list_session_entries()
+            .unwrap();
+        let [(key, session)] = entries.as_slice() else {
+            panic!("expected one session, got {:?}", entries);
+        };
+        assert_eq!(key, &session_key(&web, "app"));
+        assert_eq!(session["name"], "app");
+
+        store.delete_session_key(key).unwrap();
+        assert!(store.delete_session_key(key).is_err());
+        let remaining = store.list_session_entries().unwrap();
+        assert_eq!(remaining.len(), 1);
+        assert_eq!(remaining[0].0, session_key(&api, "app"));
+    }
+
+    #[test]
+    fn sessions_stored_by_name_alone_still_resolve() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let dir = temp.path().to_string_lossy().to_string();
+        fs::create_dir_all(temp.path().join("state")).unwrap();
+        fs::write(
+            temp.path().join("state/state.json"),
+            serde_json::json!({"sessions": {"app": {"name": "app", "dir": dir}}}).to_string(),
+        )
+        .unwrap();
+
+        store
+            .clone()
+            .in_project(temp.path())
+            .set_session("app", &[("status", "stopped")])
+            .unwrap();
+        let sessions = store.list_sessions().unwrap();
+        assert_eq!(sessions.len(), 1);
+        assert_eq!(sessions[0]["status"], "stopped");
+        assert_eq!(project_id(temp.path()), project_id(&temp.path().join(".")));
+    }
+
+    #[test]
+    fn unversioned_state_is_migrated_with_a_backup() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let dir = temp.path().to_string_lossy().to_string();
+        let state_file = temp.path().join("state/state.json");
+        fs::create_dir_all(temp.path().join("state")).unwrap();
+        let legacy = serde_json::json!({"sessions": {
+            "app": {"dir": dir, "status": "stopped"},
+            "orphan": {"status": "failed"},
+        }})
+        .to_string();
+        fs::write(&state_file, &legacy).unwrap();
+
+        let migration = store.migrate().unwrap().unwrap();
+        assert_eq!((migration.from, migration.to), (1, STATE_VERSION));
+        assert_eq!(fs::read_to_string(&migration.backup).unwrap(), legacy);
+
+        let state: Value = serde_json::from_str(&fs::read_to_string(&state_file).unwrap()).unwrap();
+        assert_eq!(state["version"], STATE_VERSION);
+        let app = &state["sessions"][session_key(temp.path(), "app")];
+        assert_eq!(app["name"], "app");
+        assert_eq!(app["status"], "stopped");
+        assert_eq!(state["sessions"]["orphan"]["name"], "orphan");
+        assert_eq!(store.migrate().unwrap(), None);
+        assert_eq!(
+            store
+                .clone()
+                .in_project(temp.path())
+                .get_session("app")
+                .unwrap()
+                .unwrap()["status"],
+            "stopped"
+        );
+    }
+
+    #[test]
+    fn concurrent_inits_migrate_once_without_losing_writes() {
+        let temp = tempfile::tempdir().unwrap();
+        let dir = temp.path().to_string_lossy().to_string();
+        let state_file = temp.path().join("state/state.json");
+        fs::create_dir_all(temp.path().join("state")).unwrap();
+        let legacy =
+            serde_json::json!({"sessions": {"app": {"dir": dir, "status": "stopped"}}}).to_string();
+        fs::write(&state_file, &legacy).unwrap();
+
+        let barrier = Arc::new(std::sync::Barrier::new(4));
+        let handles: Vec<_> = (0..4)
+            .map(|index| {
+                let store = store_for_test(temp.path(), Duration::from_secs(10));
+                let barrier = Arc::clone(&barrier);
+                thread::spawn(move || {
+                    barrier.wait();
+                    store.init_state().unwrap();
+                    store
+                        .set_session(&format!("worker-{}", index), &[("status", "running")])
+                        .unwrap();
+                })
+            })
+            .collect();
+        for handle in handles {
+            handle.join().unwrap();
+        }
+
+        let backup = PathBuf::from(format!("{}.v1.bak", state_file.display()));
+        assert_eq!(fs::read_to_string(&backup).unwrap(), legacy);
+        let state: Value = serde_json::from_str(&fs::read_to_string(&state_file).unwrap()).unwrap();
+        assert_eq!(state["version"], STATE_VERSION);
+        let sessions = state["sessions"].as_object().unwrap();
+        assert_eq!(sessions.len(), 5);
+        assert_eq!(
+            sessions[&session_key(temp.path(), "app")]["status"],
+            "stopped"
+        );
+        for index in 0..4 {
+            assert_eq!(sessions[&format!("worker-{}", index)][REVISION_FIELD], 1);
+        }
+    }
+
+    #[test]
+    fn newer_state_versions_are_refused() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let state_file = temp.path().join("state/state.json");
+        fs::create_dir_all(temp.path().join("state")).unwrap();
+        let newer = serde_json::json!({"version": STATE_VERSION + 1, "sessions": {}}).to_string();
+        fs::write(&state_file, &newer).unwrap();
+
+        match store.set_session("alpha", &[("status", "running")]) {
+            Err(StateError::UnsupportedVersion { version, .. }) => {
+                assert_eq!(version, STATE_VERSION + 1)
+            }
+            other => panic!("expected UnsupportedVersion, got {other:?}"),
+        }
+        assert_eq!(fs::read_to_string(&state_file).unwrap(), newer);
+    }
+
+    #[test]
+    fn queue_entries_are_claimed_in_order_and_updated() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let entry = |name: &str| {
+            let mut fields = Map::new();
+            fields.insert("name".to_string(), Value::String(name.to_string()));
+            fields
+        };
+
+        assert_eq!(store.enqueue(entry("alpha")).unwrap(), 1);
+        assert_eq!(store.enqueue(entry("beta")).unwrap(), 2);
+        store
+            .set_session("alpha", &[("status", "running")])
+            .unwrap();
+
+        let claimed = store
+            .claim_queue_entry(&[("runner_pid", "42")])
+            .unwrap()
+            .unwrap();
+        assert_eq!(claimed.get("name").and_then(Value::as_str), Some("alpha"));
+        assert_eq!(claimed.get("runner_pid").and_then(Value::as_i64), Some(42));
+
+        store.update_queue_entry(1, &[("status", "done")]).unwrap();
+        store.remove_queue_entry(2).unwrap();
+        assert!(store.claim_queue_entry(&[]).unwrap().is_none());
+        assert!(store.remove_queue_entry(2).is_err());
+        assert!(store.update_queue_entry(9, &[("status", "done")]).is_err());
+
+        let queue = store.list_queue().unwrap();
+        assert_eq!(queue.len(), 1);
+        assert_eq!(queue[0].get("status").and_then(Value::as_str), Some("done"));
+        assert!(store.get_session("alpha").unwrap().is_some());
+        assert_eq!(store.enqueue(entry("gamma")).unwrap(), 2);
+    }
+
+    #[test]
+    fn state_without_queue_still_loads() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
+        fs::create_dir_all(&json.state_dir).unwrap();
+        fs::write(&json.state_file, r#"{"sessions":{}}"#).unwrap();
+
+        assert!(store.list_queue().unwrap().is_empty());
+        store
+            .set_session("alpha", &[("status", "running")])
+            .unwrap();
+        let contents = fs::read_to_string(&json.state_file).unwrap();
+        assert!(!contents.contains("queue"));
+    }
+
+    #[test]
+    fn notifications_are_added_replaced_and_removed() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let entry = |session: &str| {
+            let mut fields = Map::new();
+            fields.insert("session".to_string(), Value::String(session.to_string()));
+            fields
+        };
+
+        assert_eq!(store.add_notification(entry("alpha")).unwrap(), 1);
+        assert_eq!(store.add_notification(entry("beta")).unwrap(), 2);
+        store.enqueue(entry("queued")).unwrap();
+
+        store.replace_notification(2, entry("gamma")).unwrap();
+        store.remove_notification(1).unwrap();
+        assert!(store.remove_notification(1).is_err());
+        assert!(store.replace_notification(9, entry("delta")).is_err());
+
+        let notifications = store.list_notifications().unwrap();
+        assert_eq!(notifications.len(), 1);
+        assert_eq!(notifications[0]["id"], 2);
+        assert_eq!(notifications[0]["session"], "gamma");
+        assert_eq!(store.list_queue().unwrap().len(), 1);
+    }
+
     #[test]
     fn set_session_skips_empty_field_keys() {
         let temp = tempfile::tempdir().unwrap();
@@ -726,6 +1791,7 @@ mod tests {
     fn list_sessions_handles_non_object_values() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
         store.init_state().unwrap();
 
         let mut sessions = BTreeMap::new();
@@ -738,8 +1804,13 @@ mod tests {
                 Value::String("running".to_string()),
             )])),
         );
-        let state = StateData { sessions };
-        store.write_state(&state).unwrap();
+        let state = StateData {
+            version: STATE_VERSION,
+            sessions,
+            queue: Vec::new(),
+            notifications: Vec::new(),
+        };
+        json.write_state(&state).unwrap();
 
         let listed = store.list_sessions().unwrap();
         assert_eq!(listed.len(), 3);
@@ -791,6 +1862,20 @@ mod tests {
         );
     }
 
+    #[test]
+    fn cleanup_stale_marks_dead_paused_sessions() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_for_test(temp.path(), Duration::from_secs(1));
+        store.init_state().unwrap();
+
+        store
+            .set_session("paused-session", &[("status", "paused"), ("pid", "999999")])
+            .unwrap();
+
+        let cleaned = store.cleanup_stale(CleanupMode::Mark).unwrap();
+        assert_eq!(cleaned, vec!["paused-session".to_string()]);
+    }
+
     #[test]
     #[cfg(unix)]
     fn cleanup_stale_skips_live_pid() {
@@ -890,6 +1975,7 @@ mod tests {
     fn cleanup_stale_skips_non_positive_pid_and_preserves_state() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
         store.init_state().unwrap();
 
         let mut sessions = BTreeMap::new();
@@ -914,13 +2000,18 @@ mod tests {
                 ("pid".to_string(), Value::String("nope".to_string())),
             ])),
         );
-        let state = StateData { sessions };
-        store.write_state(&state).unwrap();
+        let state = StateData {
+            version: STATE_VERSION,
+            sessions,
+            queue: Vec::new(),
+            notifications: Vec::new(),
+        };
+        json.write_state(&state).unwrap();
 
         let cleaned = store.cleanup_stale(CleanupMode::Remove).unwrap();
         assert!(cleaned.is_empty());
 
-        let reloaded = store.read_state().unwrap();
+        let reloaded = json.read_state().unwrap();
         let negative = reloaded.sessions.get("negative").unwrap();
         assert_eq!(negative.get("pid").and_then(|v| v.as_i64()), Some(-5));
         let zero = reloaded.sessions.get("zero").unwrap();
@@ -933,6 +2024,7 @@ mod tests {
     fn cleanup_stale_skips_non_object_and_missing_fields() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
         store.init_state().unwrap();
 
         let mut sessions = BTreeMap::new();
@@ -953,13 +2045,18 @@ mod tests {
                 Value::Number(12.into()),
             )])),
         );
-        let state = StateData { sessions };
-        store.write_state(&state).unwrap();
+        let state = StateData {
+            version: STATE_VERSION,
+            sessions,
+            queue: Vec::new(),
+            notifications: Vec::new(),
+        };
+        json.write_state(&state).unwrap();
 
         let cleaned = store.cleanup_stale(CleanupMode::Mark).unwrap();
         assert!(cleaned.is_empty());
 
-        let reloaded = store.read_state().unwrap();
+        let reloaded = json.read_state().unwrap();
         assert!(matches!(
             reloaded.sessions.get("stringy"),
             Some(Value::String(_))
@@ -986,6 +2083,7 @@ mod tests {
     fn cleanup_stale_skips_malformed_session_entries() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
         store.init_state().unwrap();
 
         let mut sessions = BTreeMap::new();
@@ -1011,13 +2109,18 @@ mod tests {
                 ),
             ])),
         );
-        let state = StateData { sessions };
-        store.write_state(&state).unwrap();
+        let state = StateData {
+            version: STATE_VERSION,
+            sessions,
+            queue: Vec::new(),
+            notifications: Vec::new(),
+        };
+        json.write_state(&state).unwrap();
 
         let cleaned = store.cleanup_stale(CleanupMode::Mark).unwrap();
         assert!(cleaned.is_empty());
 
-        let reloaded = store.read_state().unwrap();
+        let reloaded = json.read_state().unwrap();
         assert!(matches!(
             reloaded.sessions.get("arrayy"),
             Some(Value::Array(_))
@@ -1048,6 +2151,7 @@ mod tests {
     fn cleanup_stale_remove_keeps_malformed_entries() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
         store.init_state().unwrap();
 
         let mut sessions = BTreeMap::new();
@@ -1080,14 +2184,19 @@ mod tests {
                 ("pid".to_string(), Value::Number(999999.into())),
             ])),
         );
-        let state = StateData { sessions };
-        store.write_state(&state).unwrap();
+        let state = StateData {
+            version: STATE_VERSION,
+            sessions,
+            queue: Vec::new(),
+            notifications: Vec::new(),
+        };
+        json.write_state(&state).unwrap();
 
         let cleaned = store.cleanup_stale(CleanupMode::Remove).unwrap();
         assert_eq!(cleaned, vec!["stale".to_string()]);
         assert!(store.get_session("stale").unwrap().is_none());
 
-        let reloaded = store.read_state().unwrap();
+        let reloaded = json.read_state().unwrap();
         assert!(matches!(
             reloaded.sessions.get("stringy"),
             Some(Value::String(_))
@@ -1101,13 +2210,14 @@ mod tests {
     fn cleanup_stale_errors_when_state_file_is_directory() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_secs(1));
-        fs::create_dir_all(&store.state_dir).unwrap();
-        fs::create_dir_all(&store.state_file).unwrap();
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
+        fs::create_dir_all(&json.state_dir).unwrap();
+        fs::create_dir_all(&json.state_file).unwrap();
 
         let err = store.cleanup_stale(CleanupMode::Remove).unwrap_err();
         match err {
             StateError::Io { path, .. } => {
-                assert_eq!(path, store.state_file);
+                assert_eq!(path, json.state_file);
             }
             other => panic!("expected Io, got {other:?}"),
         }
@@ -1151,13 +2261,14 @@ mod tests {
     fn delete_session_errors_when_state_file_is_directory() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_secs(1));
-        fs::create_dir_all(&store.state_dir).unwrap();
-        fs::create_dir_all(&store.state_file).unwrap();
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
+        fs::create_dir_all(&json.state_dir).unwrap();
+        fs::create_dir_all(&json.state_file).unwrap();
 
         let err = store.delete_session("alpha").unwrap_err();
         match err {
             StateError::Io { path, .. } => {
-                assert_eq!(path, store.state_file);
+                assert_eq!(path, json.state_file);
             }
             other => panic!("expected Io, got {other:?}"),
         }
@@ -1256,7 +2367,7 @@ mod tests {
         set_env("GRALPH_LOCK_FILE", lock_file.as_os_str());
         set_env("GRALPH_LOCK_TIMEOUT", "42");
 
-        let store = StateStore::new_from_env();
+        let store = JsonStore::from_env();
         assert_eq!(store.state_dir, state_dir);
         assert_eq!(store.state_file, state_file);
         assert_eq!(store.lock_file, lock_file);
@@ -1270,7 +2381,7 @@ mod tests {
 
         set_env("GRALPH_LOCK_TIMEOUT", "not-a-number");
 
-        let store = StateStore::new_from_env();
+        let store = JsonStore::from_env();
         assert_eq!(store.lock_timeout, Duration::from_secs(10));
     }
 
@@ -1293,12 +2404,13 @@ mod tests {
     fn init_state_recovers_from_corrupted_json() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
 
-        fs::create_dir_all(&store.state_dir).unwrap();
-        fs::write(&store.state_file, "{not valid json").unwrap();
+        fs::create_dir_all(&json.state_dir).unwrap();
+        fs::write(&json.state_file, "{not valid json").unwrap();
 
         store.init_state().unwrap();
-        let state = store.read_state().unwrap();
+        let state = json.read_state().unwrap();
         assert!(state.sessions.is_empty());
     }
 
@@ -1306,14 +2418,15 @@ mod tests {
     fn init_state_recovers_from_empty_state_file() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
 
-        fs::create_dir_all(&store.state_dir).unwrap();
-        fs::write(&store.state_file, "").unwrap();
+        fs::create_dir_all(&json.state_dir).unwrap();
+        fs::write(&json.state_file, "").unwrap();
 
         store.init_state().unwrap();
-        let contents = fs::read_to_string(&store.state_file).unwrap();
+        let contents = fs::read_to_string(&json.state_file).unwrap();
         assert!(!contents.trim().is_empty());
-        let state = store.read_state().unwrap();
+        let state = json.read_state().unwrap();
         assert!(state.sessions.is_empty());
     }
 
@@ -1321,10 +2434,11 @@ mod tests {
     fn init_state_creates_missing_state_file() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_secs(1));
+        let json = json_for_test(temp.path(), Duration::from_secs(1));
 
         store.init_state().unwrap();
-        assert!(store.state_file.exists());
-        let state = store.read_state().unwrap();
+        assert!(json.state_file.exists());
+        let state = json.read_state().unwrap();
         assert!(state.sessions.is_empty());
     }
 
@@ -1332,13 +2446,14 @@ mod tests {
     fn lock_path_directory_returns_io_error() {
         let temp = tempfile::tempdir().unwrap();
         let store = store_for_test(temp.path(), Duration::from_millis(100));
-        fs::create_dir_all(&store.state_dir).unwrap();
-        fs::create_dir_all(&store.lock_file).unwrap();
+        let json = json_for_test(temp.path(), Duration::from_millis(100));
+        fs::create_dir_all(&json.state_dir).unwrap();
+        fs::create_dir_all(&json.lock_file).unwrap();
 
         let err = store.get_session("alpha").unwrap_err();
         match err {
             StateError::Io { path, .. } => {
-                assert_eq!(path, store.lock_file);
+                assert_eq!(path, json.lock_file);
             }
             other => panic!("expected Io, got {other:?}"),
         }
@@ -1420,7 +2535,7 @@ mod tests {
         fs::write(&state_dir, "not a dir").unwrap();
         let state_file = state_dir.join("state.json");
         let lock_file = state_dir.join("state.lock");
-        let store = StateStore::with_paths(
+        let store = JsonStore::with_paths(
             state_dir,
             state_file,
             lock_file.clone(),
@@ -1442,7 +2557,7 @@ mod tests {
         let state_dir = temp.path().join("state");
         let state_file = state_dir.join("state.json");
         let lock_file = temp.path().join("missing").join("state.lock");
-        let store = StateStore::with_paths(
+        let store = JsonStore::with_paths(
             state_dir,
             state_file,
             lock_file.clone(),
@@ -1466,7 +2581,7 @@ mod tests {
         let lock_parent = temp.path().join("lock-parent");
         fs::write(&lock_parent, "not a dir").unwrap();
         let lock_file = lock_parent.join("state.lock");
-        let store = StateStore::with_paths(
+        let store = JsonStore::with_paths(
             state_dir,
             state_file,
             lock_file.clone(),
@@ -1507,4 +2622,65 @@ mod tests {
         }
         assert!(validate_state_content("{\"sessions\":{}}").is_ok());
     }
+
+    #[test]
+    fn state_driver_parses_known_names() {
+        assert_eq!(StateDriver::parse("json"), Some(StateDriver::Json));
+        assert_eq!(StateDriver::parse(" SQLite "), Some(StateDriver::Sqlite));
+        assert_eq!(StateDriver::parse("postgres"), None);
+    }
+
+    #[test]
+    fn state_driver_from_config_rejects_unknown_names() {
+        let _guard = env_guard();
+        let _snapshot = EnvSnapshot::new(&["GRALPH_DEFAULT_CONFIG", "GRALPH_STATE_DRIVER"]);
+        let temp = tempfile::tempdir().unwrap();
+        let default_path = temp.path().join("default.yaml");
+        fs::write(&default_path, "state:\n  driver: json\n").unwrap();
+        set_env("GRALPH_DEFAULT_CONFIG", &default_path);
+
+        remove_env("GRALPH_STATE_DRIVER");
+        let config = Config::load(None).unwrap();
+        assert_eq!(
+            StateDriver::from_config(&config).unwrap(),
+            StateDriver::Json
+        );
+
+        set_env("GRALPH_STATE_DRIVER", "sqlite");
+        let config = Config::load(None).unwrap();
+        assert_eq!(
+            StateDriver::from_config(&config).unwrap(),
+            StateDriver::Sqlite
+        );
+
+        set_env("GRALPH_STATE_DRIVER", "postgres");
+        let config = Config::load(None).unwrap();
+        let err = StateDriver::from_config(&config).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "invalid state: unknown state.driver: postgres (expected json, sqlite)"
+        );
+    }
+
+    #[test]
+    fn with_driver_keeps_the_state_in_the_state_dir() {
+        let _guard = env_guard();
+        let _snapshot =
+            EnvSnapshot::new(&["GRALPH_STATE_DIR", "GRALPH_STATE_FILE", "GRALPH_LOCK_FILE"]);
+        let temp = tempfile::tempdir().unwrap();
+        set_env("GRALPH_STATE_DIR", temp.path().as_os_str());
+        remove_env("GRALPH_STATE_FILE");
+        remove_env("GRALPH_LOCK_FILE");
+
+        StateStore::with_driver(StateDriver::Json)
+            .set_session("alpha", &[("status", "running")])
+            .unwrap();
+        assert!(temp.path().join("state.json").exists());
+
+        let store = StateStore::with_driver(StateDriver::Sqlite);
+        assert_eq!(store.state_dir(), temp.path());
+        let session = store.get_session("alpha").unwrap().unwrap();
+        assert_eq!(session["status"], "running");
+        assert!(temp.path().join("state.db").exists());
+    }
 }
@@ -0,0 +1,598 @@
+//! The SQLite state store, chosen with `state.driver: sqlite`.
+//!
+//! The database (`state.db` in the state directory) keeps one row per
+//! session, so a write touches only the sessions it changed, and every
+//! write is one SQLite transaction instead of a rewrite of `state.json`
+//! under an advisory lock. Reads take no write lock. Writes are optimistic:
+//! the `generation` counter in `meta` goes up with each one, and a write
+//! made against an older generation is rolled back and retried on the new
+//! state. The database uses the rollback journal rather than WAL, which
+//! needs shared memory that network filesystems do not provide.
+//!
+//! SQLite is linked into gralph, so the store needs no `sqlite3` on `PATH`.
+//! On first use it takes over an existing `state.json`. The schema `version`
+//! of the rows is kept in `meta` and migrated like the JSON store's.
+
+use super::{
+    STATE_VERSION, StateData, StateError, StateMigration, Store, backup_path, check_version,
+    run_migrations,
+};
+use rusqlite::{Connection, ErrorCode, Transaction, TransactionBehavior, params};
+use serde_json::Value;
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::PathBuf;
+use std::sync::Mutex;
+use std::thread;
+use std::time::{Duration, Instant};
+
+/// Tables of the store, created when the database is older than
+/// [`SCHEMA_VERSION`]. `meta` holds the write `generation` and the schema
+/// `version` of the rows.
+const SCHEMA: &str = "\
+CREATE TABLE IF NOT EXISTS meta (key TEXT PRIMARY KEY, value INTEGER NOT NULL);
+INSERT OR IGNORE INTO meta (key, value) VALUES ('generation', 0);
+CREATE TABLE IF NOT EXISTS sessions (key TEXT PRIMARY KEY, data TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS queue (position INTEGER PRIMARY KEY, data TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS notifications (position INTEGER PRIMARY KEY, data TEXT NOT NULL);
+";
+
+/// Layout of the tables, kept in `PRAGMA user_version`. Bump it with every
+/// change to [`SCHEMA`] so existing databases pick the change up.
+const SCHEMA_VERSION: i64 = 4;
+
+/// How long to wait before retrying a write that lost the generation check.
+const RETRY_DELAY: Duration = Duration::from_millis(50);
+
+#[derive(Debug)]
+pub(super) struct SqliteStore {
+    db: PathBuf,
+    /// `state.json` from the JSON store, imported into an empty database.
+    legacy_file: PathBuf,
+    lock_timeout: Duration,
+    /// Opened on first use and kept for the life of the store.
+    conn: Mutex<Option<Connection>>,
+}
+
+/// The state as read at `generation`.
+#[derive(Debug)]
+struct Snapshot {
+    generation: i64,
+    state: StateData,
+}
+
+impl SqliteStore {
+    pub(super) fn new(db: PathBuf, legacy_file: PathBuf, lock_timeout: Duration) -> Self {
+        Self {
+            db,
+            legacy_file,
+            lock_timeout,
+            conn: Mutex::new(None),
+        }
+    }
+
+    fn with_connection<T>(
+        &self,
+        op: impl FnOnce(&mut Connection) -> Result<T, StateError>,
+    ) -> Result<T, StateError> {
+        let mut guard = self
+            .conn
+            .lock()
+            .unwrap_or_else(|poisoned| poisoned.into_inner());
+        let conn = match guard.take() {
+            Some(conn) => conn,
+            None => self.open()?,
+        };
+        op(guard.insert(conn))
+    }
+
+    /// Opens the database, creating it and its tables when missing.
+    fn open(&self) -> Result<Connection, StateError> {
+        if let Some(parent) = self.db.parent().filter(|parent| !parent.exists()) {
+            fs::create_dir_all(parent).map_err(|source| StateError::Io {
+                path: parent.to_path_buf(),
+                source,
+            })?;
+        }
+        let mut conn = Connection::open(&self.db).map_err(|err| self.error(err))?;
+        conn.busy_timeout(self.lock_timeout)
+            .map_err(|err| self.error(err))?;
+        let version: i64 = conn
+            .pragma_query_value(None, "user_version", |row| row.get(0))
+            .map_err(|err| self.error(err))?;
+        if version < SCHEMA_VERSION {
+            let tx = conn
+                .transaction_with_behavior(TransactionBehavior::Immediate)
+                .map_err(|err| self.error(err))?;
+            tx.execute_batch(SCHEMA).map_err(|err| self.error(err))?;
+            // A new database starts at the current state version. One made
+            // before the state had versions holds version 1 rows.
+            let state_version = if version == 0 { STATE_VERSION } else { 1 };
+            tx.execute(
+                "INSERT OR IGNORE INTO meta (key, value) VALUES ('version', ?1)",
+                params![state_version as i64],
+            )
+            .map_err(|err| self.error(err))?;
+            tx.pragma_update(None, "user_version", SCHEMA_VERSION)
+                .map_err(|err| self.error(err))?;
+            tx.commit().map_err(|err| self.error(err))?;
+        }
+        Ok(conn)
+    }
+
+    /// Reads the state in one read transaction.
+    fn load(&self, conn: &mut Connection) -> Result<Snapshot, StateError> {
+        let tx = conn.transaction().map_err(|err| self.error(err))?;
+        let generation = tx
+            .query_row(
+                "SELECT value FROM meta WHERE key = 'generation'",
+                [],
+                |row| row.get(0),
+            )
+            .map_err(|err| self.error(err))?;
+        let version: i64 = tx
+            .query_row("SELECT value FROM meta WHERE key = 'version'", [], |row| {
+                row.get(0)
+            })
+            .map_err(|err| self.error(err))?;
+        let mut sessions = BTreeMap::new();
+        {
+            let mut stmt = tx
+                .prepare("SELECT key, data FROM sessions")
+                .map_err(|err| self.error(err))?;
+            let rows = stmt
+                .query_map([], |row| {
+                    Ok((row.get::<_, String>(0)?, row.get::<_, String>(1)?))
+                })
+                .map_err(|err| self.error(err))?;
+            for row in rows {
+                let (key, data) = row.map_err(|err| self.error(err))?;
+                let session = serde_json::from_str(&data).map_err(|source| StateError::Json {
+                    path: self.db.clone(),
+                    source,
+                })?;
+                sessions.insert(key, session);
+            }
+        }
+        let queue = self.load_list(&tx, "queue")?;
+        let notifications = self.load_list(&tx, "notifications")?;
+        tx.commit().map_err(|err| self.error(err))?;
+        Ok(Snapshot {
+            generation,
+            state: StateData {
+                version: version as u64,
+                sessions,
+                queue,
+                notifications,
+            },
+        })
+    }
+
+    /// The rows of `table`, in order.
+    fn load_list(&self, tx: &Transaction, table: &str) -> Result<Vec<Value>, StateError> {
+        let mut stmt = tx
+            .prepare(&format!("SELECT data FROM {} ORDER BY position", table))
+            .map_err(|err| self.error(err))?;
+        let rows = stmt
+            .query_map([], |row| row.get::<_, String>(0))
+            .map_err(|err| self.error(err))?;
+        let mut items = Vec::new();
+        for row in rows {
+            let data = row.map_err(|err| self.error(err))?;
+            items.push(
+                serde_json::from_str(&data).map_err(|source| StateError::Json {
+                    path: self.db.clone(),
+                    source,
+                })?,
+            );
+        }
+        Ok(items)
+    }
+
+    /// Replaces the rows of `table` with `items`, in order.
+    fn save_list(&self, tx: &Transaction, table: &str, items: &[Value]) -> Result<(), StateError> {
+        tx.execute(&format!("DELETE FROM {}", table), [])
+            .map_err(|err| self.error(err))?;
+        for (position, item) in items.iter().enumerate() {
+            tx.execute(
+                &format!("INSERT INTO {} (position, data) VALUES (?1, ?2)", table),
+                params![position as i64, item.to_string()],
+            )
+            .map_err(|err| self.error(err))?;
+        }
+        Ok(())
+    }
+
+    /// Writes the changes from `snapshot` to `state` in one transaction.
+    /// Returns false, having written nothing, when another write came in
+    /// since `snapshot` was read.
+    fn save(
+        &self,
+        conn: &mut Connection,
+        snapshot: &Snapshot,
+        state: &StateData,
+    ) -> Result<bool, StateError> {
+        let before = &snapshot.state;
+        let tx = conn
+            .transaction_with_behavior(TransactionBehavior::Immediate)
+            .map_err(|err| self.error(err))?;
+        let claimed = tx
+            .execute(
+                "UPDATE meta SET value = value + 1 WHERE key = 'generation' AND value = ?1",
+                params![snapshot.generation],
+            )
+            .map_err(|err| self.error(err))?;
+        if claimed == 0 {
+            // Dropping the transaction rolls it back.
+            return Ok(false);
+        }
+        if state.version != before.version {
+            tx.execute(
+                "UPDATE meta SET value = ?1 WHERE key = 'version'",
+                params![state.version as i64],
+            )
+            .map_err(|err| self.error(err))?;
+        }
+        for (key, session) in &state.sessions {
+            if before.sessions.get(key) != Some(session) {
+                tx.execute(
+                    "INSERT OR REPLACE INTO sessions (key, data) VALUES (?1, ?2)",
+                    params![key, session.to_string()],
+                )
+                .map_err(|err| self.error(err))?;
+            }
+        }
+        for key in before.sessions.keys() {
+            if !state.sessions.contains_key(key) {
+                tx.execute("DELETE FROM sessions WHERE key = ?1", params![key])
+                    .map_err(|err| self.error(err))?;
+            }
+        }
+        // The queue and the notifications are short lists kept in order, so
+        // a change rewrites the list.
+        if state.queue != before.queue {
+            self.save_list(&tx, "queue", &state.queue)?;
+        }
+        if state.notifications != before.notifications {
+            self.save_list(&tx, "notifications", &state.notifications)?;
+        }
+        tx.commit().map_err(|err| self.error(err))?;
+        Ok(true)
+    }
+
+    /// Runs `op` on the state from `snapshot` and saves the result when
+    /// `op` returns true, starting over on the new state when another write
+    /// got in first.
+    fn update(
+        &self,
+        conn: &mut Connection,
+        mut snapshot: Snapshot,
+        op: &mut dyn FnMut(&mut StateData) -> Result<bool, StateError>,
+    ) -> Result<(), StateError> {
+        let start = Instant::now();
+        loop {
+            let mut state = snapshot.state.clone();
+            if !op(&mut state)? || self.save(conn, &snapshot, &state)? {
+                return Ok(());
+            }
+            if start.elapsed() >= self.lock_timeout {
+                return Err(StateError::LockTimeout {
+                    timeout: self.lock_timeout,
+                });
+            }
+            thread::sleep(RETRY_DELAY);
+            snapshot = self.load(conn)?;
+            check_version(&self.db, snapshot.state.version)?;
+        }
+    }
+
+    /// Reads the state, first importing `state.json` into a database
+    /// nothing has written to yet. A file that is not valid JSON is left
+    /// out, as the JSON store would start over.
+    fn load_imported(&self, conn: &mut Connection) -> Result<Snapshot, StateError> {
+        let snapshot = self.load(conn)?;
+        if snapshot.generation != 0 {
+            return Ok(snapshot);
+        }
+        let Ok(contents) = fs::read_to_string(&self.legacy_file) else {
+            return Ok(snapshot);
+        };
+        let Ok(legacy) = serde_json::from_str::<StateData>(&contents) else {
+            return Ok(snapshot);
+        };
+        check_version(&self.legacy_file, legacy.version)?;
+        // Losing the race means another process imported it first.
+        self.save(conn, &snapshot, &legacy)?;
+        self.load(conn)
+    }
+
+    /// Reads the state, migrating it to [`STATE_VERSION`] first when it is
+    /// older, and returns it with the migration that ran.
+    fn current(
+        &self,
+        conn: &mut Connection,
+    ) -> Result<(Snapshot, Option<StateMigration>), StateError> {
+        let snapshot = self.load_imported(conn)?;
+        let from = snapshot.state.version;
+        check_version(&self.db, from)?;
+        if from == STATE_VERSION {
+            return Ok((snapshot, None));
+        }
+        let backup = backup_path(&self.db, from);
+        if backup.exists() {
+            fs::remove_file(&backup).map_err(|source| StateError::Io {
+                path: backup.clone(),
+                source,
+            })?;
+        }
+        conn.execute("VACUUM INTO ?1", params![backup.to_string_lossy()])
+            .map_err(|err| self.error(err))?;
+        self.update(conn, snapshot, &mut |state| {
+            // Another process may have migrated it since.
+            if state.version >= STATE_VERSION {
+                return Ok(false);
+            }
+            run_migrations(state);
+            Ok(true)
+        })?;
+        let migration = StateMigration {
+            from,
+            to: STATE_VERSION,
+            backup,
+        };
+        Ok((self.load(conn)?, Some(migration)))
+    }
+
+    /// A busy database is a lock timeout, as with the JSON store's lock:
+    /// SQLite has already waited `lock_timeout` for it.
+    fn error(&self, source: rusqlite::Error) -> StateError {
+        match &source {
+            rusqlite::Error::SqliteFailure(err, _)
+                if matches!(
+                    err.code,
+                    ErrorCode::DatabaseBusy | ErrorCode::DatabaseLocked
+                ) =>
+            {
+                StateError::LockTimeout {
+                    timeout: self.lock_timeout,
+                }
+            }
+            _ => StateError::Sqlite {
+                path: self.db.clone(),
+                source,
+            },
+        }
+    }
+}
+
+impl Store for SqliteStore {
+    fn prepare(&self) -> Result<(), StateError> {
+        self.with_connection(|conn| self.current(conn).map(|_| ()))
+    }
+
+    fn migrate(&self) -> Result<Option<StateMigration>, StateError> {
+        self.with_connection(|conn| self.current(conn).map(|(_, migration)| migration))
+    }
+
+    fn transact(
+        &self,
+        op: &mut dyn FnMut(&mut StateData) -> Result<bool, StateError>,
+    ) -> Result<(), StateError> {
+        self.with_connection(|conn| {
+            let (snapshot, _) = self.current(conn)?;
+            self.update(conn, snapshot, op)
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+    use std::path::Path;
+
+    fn store_in(dir: &Path) -> SqliteStore {
+        SqliteStore::new(
+            dir.join("state.db"),
+            dir.join("state.json"),
+            Duration::from_secs(10),
+        )
+    }
+
+    fn read(store: &SqliteStore) -> StateData {
+        let mut read = None;
+        store
+            .transact(&mut |state| {
+                read = Some(state.clone());
+                Ok(false)
+            })
+            .unwrap();
+        read.unwrap()
+    }
+
+    fn generation(store: &SqliteStore) -> i64 {
+        store
+            .with_connection(|conn| store.load(conn))
+            .unwrap()
+            .generation
+    }
+
+    #[test]
+    fn writes_only_when_the_operation_changes_the_state() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_in(temp.path());
+
+        store
+            .transact(&mut |state| {
+                state
+                    .sessions
+                    .insert("it's".to_string(), json!({"name": "it's", "pid": 1}));
+                state
+                    .sessions
+                    .insert("beta".to_string(), json!({"name": "beta"}));
+                state.queue.push(json!({"id": 1, "status": "queued"}));
+                state.queue.push(json!({"id": 2, "status": "queued"}));
+                state
+                    .notifications
+                    .push(json!({"id": 1, "session": "beta"}));
+                Ok(true)
+            })
+            .unwrap();
+        store
+            .transact(&mut |state| {
+                state.sessions.clear();
+                state.queue.clear();
+                Ok(false)
+            })
+            .unwrap();
+
+        let state = read(&store);
+        assert_eq!(state.sessions.len(), 2);
+        assert_eq!(state.sessions["it's"]["pid"], json!(1));
+        assert_eq!(state.queue.len(), 2);
+        assert_eq!(state.queue[1]["id"], json!(2));
+        assert_eq!(state.notifications.len(), 1);
+        assert_eq!(generation(&store), 1);
+
+        store
+            .transact(&mut |state| {
+                state.sessions.remove("beta");
+                state.queue.remove(0);
+                state.notifications.clear();
+                Ok(true)
+            })
+            .unwrap();
+        let state = read(&store);
+        assert_eq!(state.sessions.keys().collect::<Vec<_>>(), vec!["it's"]);
+        assert_eq!(state.queue, vec![json!({"id": 2, "status": "queued"})]);
+        assert!(state.notifications.is_empty());
+        assert_eq!(generation(&store), 2);
+    }
+
+    #[test]
+    fn concurrent_writers_do_not_lose_updates() {
+        let temp = tempfile::tempdir().unwrap();
+        store_in(temp.path()).prepare().unwrap();
+
+        // A store per writer, each with its own connection, as separate
+        // gralph processes would have.
+        let handles = (0..4)
+            .map(|writer| {
+                let store = store_in(temp.path());
+                thread::spawn(move || {
+                    for write in 0..5 {
+                        store
+                            .transact(&mut |state| {
+                                let key = format!("writer-{}-{}", writer, write);
+                                let count = state.sessions.len();
+                                state.sessions.insert(key, json!({"seen": count}));
+                                Ok(true)
+                            })
+                            .unwrap();
+                    }
+                })
+            })
+            .collect::<Vec<_>>();
+        for handle in handles {
+            handle.join().unwrap();
+        }
+
+        let store = store_in(temp.path());
+        assert_eq!(read(&store).sessions.len(), 20);
+        assert_eq!(generation(&store), 20);
+    }
+
+    #[test]
+    fn imports_and_migrates_an_existing_state_json_once() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(
+            temp.path().join("state.json"),
+            r#"{"sessions":{"alpha":{"name":"alpha","dir":"/tmp/alpha"}}}"#,
+        )
+        .unwrap();
+        let store = store_in(temp.path());
+
+        let migration = store.migrate().unwrap().unwrap();
+        assert_eq!(migration.from, 1);
+        assert_eq!(migration.to, STATE_VERSION);
+        assert_eq!(migration.backup, temp.path().join("state.db.v1.bak"));
+        assert!(migration.backup.exists());
+        assert!(store.migrate().unwrap().is_none());
+
+        let state = read(&store);
+        assert_eq!(state.version, STATE_VERSION);
+        let key = super::super::session_key(Path::new("/tmp/alpha"), "alpha");
+        assert_eq!(state.sessions[&key]["dir"], json!("/tmp/alpha"));
+
+        // Once imported, state.json is left alone.
+        fs::write(
+            temp.path().join("state.json"),
+            r#"{"sessions":{"beta":{"name":"beta"}}}"#,
+        )
+        .unwrap();
+        assert_eq!(
+            read(&store_in(temp.path()))
+                .sessions
+                .keys()
+                .collect::<Vec<_>>(),
+            vec![&key]
+        );
+    }
+
+    #[test]
+    fn skips_a_state_json_that_is_not_valid() {
+        let temp = tempfile::tempdir().unwrap();
+        fs::write(temp.path().join("state.json"), "{not json").unwrap();
+        let store = store_in(temp.path());
+
+        assert!(read(&store).sessions.is_empty());
+        assert_eq!(generation(&store), 0);
+    }
+
+    #[test]
+    fn refuses_a_database_from_a_newer_gralph() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = store_in(temp.path());
+        store.prepare().unwrap();
+        Connection::open(temp.path().join("state.db"))
+            .unwrap()
+            .execute(
+                "UPDATE meta SET value = ?1 WHERE key = 'version'",
+                params![(STATE_VERSION + 1) as i64],
+            )
+            .unwrap();
+
+        match store.prepare() {
+            Err(StateError::UnsupportedVersion { path, version }) => {
+                assert_eq!(path, temp.path().join("state.db"));
+                assert_eq!(version, STATE_VERSION + 1);
+            }
+            other => panic!("expected UnsupportedVersion, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn busy_database_is_a_lock_timeout() {
+        let temp = tempfile::tempdir().unwrap();
+        let store = SqliteStore::new(
+            temp.path().join("state.db"),
+            temp.path().join("state.json"),
+            Duration::from_millis(100),
+        );
+        store.prepare().unwrap();
+
+        let mut blocker = Connection::open(temp.path().join("state.db")).unwrap();
+        let tx = blocker
+            .transaction_with_behavior(TransactionBehavior::Immediate)
+            .unwrap();
+        let err = store
+            .transact(&mut |state| {
+                state.sessions.insert("alpha".to_string(), json!({}));
+                Ok(true)
+            })
+            .unwrap_err();
+        drop(tx);
+
+        assert!(matches!(err, StateError::LockTimeout { .. }));
+    }
+}
@@ -0,0 +1,203 @@
+//! Structured task results reported by the backend.
+//!
+//! The iteration prompt asks the backend to end its reply with a trailer such
+//! as `<gralph-result>{"task":"GO-12","status":"done","notes":"..."}</gralph-result>`.
+//! The loop parses it to confirm which task was worked on and how far it got,
+//! instead of relying only on the checkbox diff, and logs any mismatch between
+//! the two. Replies without a trailer are handled as before.
+
+use serde::Deserialize;
+use std::fmt;
+
+pub const RESULT_OPEN_TAG: &str = "<gralph-result>";
+pub const RESULT_CLOSE_TAG: &str = "</gralph-result>";
+
+/// How far the backend says it got with the task.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum TaskResultStatus {
+    Done,
+    Partial,
+    Blocked,
+}
+
+impl TaskResultStatus {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            TaskResultStatus::Done => "done",
+            TaskResultStatus::Partial => "partial",
+            TaskResultStatus::Blocked => "blocked",
+        }
+    }
+
+    fn parse(value: &str) -> Option<Self> {
+        match value.trim().to_ascii_lowercase().as_str() {
+            "done" | "complete" | "completed" => Some(TaskResultStatus::Done),
+            "partial" | "in_progress" | "in-progress" => Some(TaskResultStatus::Partial),
+            "blocked" | "failed" => Some(TaskResultStatus::Blocked),
+            _ => None,
+        }
+    }
+}
+
+impl fmt::Display for TaskResultStatus {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TaskResult {
+    pub task: String,
+    pub status: TaskResultStatus,
+    pub notes: Option<String>,
+}
+
+#[derive(Deserialize)]
+struct RawTaskResult {
+    #[serde(default)]
+    task: Option<String>,
+    #[serde(default)]
+    status: Option<String>,
+    #[serde(default)]
+    notes: Option<String>,
+}
+
+/// Finds the last `<gralph-result>` trailer in `text`. Returns None when
+/// there is none, and Err with the reason when it cannot be used: invalid
+/// JSON, a missing task ID, or an unknown status.
+pub fn parse_task_result(text: &str) -> Option<Result<TaskResult, String>> {
+    let start = text.rfind(RESULT_OPEN_TAG)? + RESULT_OPEN_TAG.len();
+    let Some(len) = text[start..].find(RESULT_CLOSE_TAG) else {
+        return Some(Err(format!("{} is never closed", RESULT_OPEN_TAG)));
+    };
+    Some(validate(&text[start..start + len]))
+}
+
+fn validate(payload: &str) -> Result<TaskResult, String> {
+    let raw: RawTaskResult =
+        serde_json::from_str(payload.trim()).map_err(|err| format!("invalid JSON: {}", err))?;
+    let task = raw
+        .task
+        .map(|task| task.trim().trim_matches('`').to_string())
+        .filter(|task| !task.is_empty())
+        .ok_or_else(|| "missing \"task\"".to_string())?;
+    let status = raw
+        .status
+        .as_deref()
+        .ok_or_else(|| "missing \"status\"".to_string())
+        .and_then(|status| {
+            TaskResultStatus::parse(status).ok_or_else(|| {
+                format!(
+                    "unknown status {:?} (expected done, partial, or blocked)",
+                    status
+                )
+            })
+        })?;
+    Ok(TaskResult {
+        task,
+        status,
+        notes: raw
+            .notes
+            .map(|notes| notes.trim().to_string())
+            .filter(|notes| !notes.is_empty()),
+    })
+}
+
+/// Ways a reported result disagrees with the task file after the iteration.
+/// `attempted` is the task the loop picked; `checked` is whether the
+/// reported task is checked off now, or None when the task file has no
+/// such task.
+pub fn result_mismatches(
+    result: &TaskResult,
+    attempted: Option<&str>,
+    checked: Option<bool>,
+) -> Vec<String> {
+    let mut mismatches = Vec::new();
+    if let Some(attempted) = attempted {
+        if attempted != result.task {
+            mismatches.push(format!(
+                "backend reported task {} but the loop picked {}",
+                result.task, attempted
+            ));
+        }
+    }
+    match (result.status, checked) {
+        (_, None) => mismatches.push(format!(
+            "backend reported task {}, which is not in the task file",
+            result.task
+        )),
+        (TaskResultStatus::Done, Some(false)) => mismatches.push(format!(
+            "backend reported task {} as done but it is still unchecked",
+            result.task
+        )),
+        (status, Some(true)) if status != TaskResultStatus::Done => mismatches.push(format!(
+            "backend reported task {} as {} but it is checked off",
+            result.task, status
+        )),
+        _ => {}
+    }
+    mismatches
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parse_task_result_reads_the_last_trailer() {
+        let text = "Working on it.\n<gralph-result>{\"task\":\"A-1\",\"status\":\"partial\"}</gralph-result>\nDone.\n<gralph-result>\n{\"task\": \"GO-12\", \"status\": \"Done\", \"notes\": \" added tests \"}\n</gralph-result>\n";
+
+        assert_eq!(
+            parse_task_result(text),
+            Some(Ok(TaskResult {
+                task: "GO-12".to_string(),
+                status: TaskResultStatus::Done,
+                notes: Some("added tests".to_string()),
+            }))
+        );
+        assert_eq!(parse_task_result("no trailer here"), None);
+    }
+
+    #[test]
+    fn parse_task_result_rejects_bad_trailers() {
+        let error = |text: &str| parse_task_result(text).unwrap().unwrap_err();
+
+        assert!(error("<gralph-result>{not json}</gralph-result>").starts_with("invalid JSON"));
+        assert_eq!(
+            error("<gralph-result>{\"status\":\"done\"}</gralph-result>"),
+            "missing \"task\""
+        );
+        assert!(
+            error("<gralph-result>{\"task\":\"A-1\",\"status\":\"maybe\"}</gralph-result>")
+                .contains("unknown status \"maybe\"")
+        );
+        assert!(error("<gralph-result>{\"task\":\"A-1\"}").contains("never closed"));
+    }
+
+    #[test]
+    fn result_mismatches_compare_against_the_task_file() {
+        let result = |task: &str, status| TaskResult {
+            task: task.to_string(),
+            status,
+            notes: None,
+        };
+        let done = result("A-1", TaskResultStatus::Done);
+
+        assert!(result_mismatches(&done, Some("A-1"), Some(true)).is_empty());
+        assert_eq!(
+            result_mismatches(&done, Some("B-2"), Some(false)),
+            vec![
+                "backend reported task A-1 but the loop picked B-2",
+                "backend reported task A-1 as done but it is still unchecked",
+            ]
+        );
+        assert_eq!(
+            result_mismatches(&result("A-1", TaskResultStatus::Blocked), None, Some(true)),
+            vec!["backend reported task A-1 as blocked but it is checked off"]
+        );
+        assert_eq!(
+            result_mismatches(&result("Z-9", TaskResultStatus::Partial), None, None),
+            vec!["backend reported task Z-9, which is not in the task file"]
+        );
+    }
+}
@@ -0,0 +1,561 @@
+//! OpenTelemetry traces for loops, iterations, and backend calls.
+//!
+//! When `telemetry.endpoint` is set, [`with_tracing`] collects the spans
+//! the loop opens with [`start_span`] and sends them to that OTLP/HTTP
+//! collector as JSON (`<endpoint>/v1/traces`). Spans are exported as each
+//! iteration ends, so a long loop shows up while it is still running.
+//! Without an endpoint every span is a no-op.
+//!
+//! Tracing never fails a loop: a collector that is down costs one warning.
+
+use crate::config::Config;
+use crate::offline;
+use reqwest::blocking::Client;
+use serde_json::{Value, json};
+use std::cell::RefCell;
+use std::collections::hash_map::RandomState;
+use std::hash::{BuildHasher, Hasher};
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+const DEFAULT_SERVICE_NAME: &str = "gralph";
+const EXPORT_TIMEOUT: Duration = Duration::from_secs(5);
+const TRACES_PATH: &str = "/v1/traces";
+
+thread_local! {
+    static TRACER: RefCell<Option<Tracer>> = const { RefCell::new(None) };
+}
+
+/// Where spans go, from the `telemetry.*` config keys.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ExporterConfig {
+    /// The collector's traces URL, `<telemetry.endpoint>/v1/traces`.
+    pub traces_url: String,
+    /// Extra request headers from `telemetry.headers` (`key=value,...`).
+    pub headers: Vec<(String, String)>,
+    pub service_name: String,
+}
+
+impl ExporterConfig {
+    /// None when `telemetry.endpoint` is unset, or when offline mode is on
+    /// and the collector is not on this machine.
+    pub fn from_config(config: &Config) -> Option<Self> {
+        let endpoint = config
+            .get("telemetry.endpoint")
+            .map(|value| value.trim().trim_end_matches('/').to_string())
+            .filter(|value| !value.is_empty())?;
+        if config.offline() && !offline::is_local_url(&endpoint) {
+            return None;
+        }
+        let traces_url = if endpoint.ends_with(TRACES_PATH) {
+            endpoint
+        } else {
+            format!("{}{}", endpoint, TRACES_PATH)
+        };
+        let headers = config
+            .get("telemetry.headers")
+            .map(|value| parse_headers(&value))
+            .unwrap_or_default();
+        let service_name = config
+            .get("telemetry.service_name")
+            .map(|value| value.trim().to_string())
+            .filter(|value| !value.is_empty())
+            .unwrap_or_else(|| DEFAULT_SERVICE_NAME.to_string());
+        Some(Self {
+            traces_url,
+            headers,
+            service_name,
+        })
+    }
+
+    /// POSTs `spans` to the collector.
+    pub fn export(&self, spans: &[SpanData]) -> Result<(), String> {
+        if spans.is_empty() {
+            return Ok(());
+        }
+        let client = Client::builder()
+            .timeout(EXPORT_TIMEOUT)
+            .build()
+            .map_err(|err| err.to_string())?;
+        let mut request = client
+            .post(&self.traces_url)
+            .header("Content-Type", "application/json");
+        for (key, value) in &self.headers {
+            request = request.header(key.as_str(), value.as_str());
+        }
+        let response = request
+            .body(encode_traces(&self.service_name, spans).to_string())
+            .send()
+            .map_err(|err| err.to_string())?;
+        if response.status().is_success() {
+            Ok(())
+        } else {
+            Err(format!(
+                "collector returned HTTP {}",
+                response.status().as_u16()
+            ))
+        }
+    }
+}
+
+/// `key=value` pairs separated by commas, as in `OTEL_EXPORTER_OTLP_HEADERS`.
+fn parse_headers(value: &str) -> Vec<(String, String)> {
+    value
+        .split(',')
+        .filter_map(|pair| pair.split_once('='))
+        .map(|(key, value)| (key.trim().to_string(), value.trim().to_string()))
+        .filter(|(key, _)| !key.is_empty())
+        .collect()
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub enum AttributeValue {
+    String(String),
+    Int(i64),
+    Bool(bool),
+}
+
+impl From<&str> for AttributeValue {
+    fn from(value: &str) -> Self {
+        AttributeValue::String(value.to_string())
+    }
+}
+
+impl From<String> for AttributeValue {
+    fn from(value: String) -> Self {
+        AttributeValue::String(value)
+    }
+}
+
+impl From<u32> for AttributeValue {
+    fn from(value: u32) -> Self {
+        AttributeValue::Int(i64::from(value))
+    }
+}
+
+impl From<u64> for AttributeValue {
+    fn from(value: u64) -> Self {
+        AttributeValue::Int(i64::try_from(value).unwrap_or(i64::MAX))
+    }
+}
+
+impl From<usize> for AttributeValue {
+    fn from(value: usize) -> Self {
+        AttributeValue::Int(i64::try_from(value).unwrap_or(i64::MAX))
+    }
+}
+
+impl From<bool> for AttributeValue {
+    fn from(value: bool) -> Self {
+        AttributeValue::Bool(value)
+    }
+}
+
+impl AttributeValue {
+    fn to_json(&self) -> Value {
+        match self {
+            AttributeValue::String(value) => json!({ "stringValue": value }),
+            // OTLP/JSON encodes 64-bit integers as strings.
+            AttributeValue::Int(value) => json!({ "intValue": value.to_string() }),
+            AttributeValue::Bool(value) => json!({ "boolValue": value }),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum SpanKind {
+    /// Work inside gralph: the loop and its iterations.
+    Internal,
+    /// A call out to something else: the backend.
+    Client,
+}
+
+impl SpanKind {
+    fn code(self) -> u8 {
+        match self {
+            SpanKind::Internal => 1,
+            SpanKind::Client => 3,
+        }
+    }
+}
+
+/// A finished span, as it is exported.
+#[derive(Debug, Clone, PartialEq)]
+pub struct SpanData {
+    pub name: String,
+    pub kind: SpanKind,
+    pub trace_id: [u8; 16],
+    pub span_id: [u8; 8],
+    pub parent_span_id: Option<[u8; 8]>,
+    pub start: SystemTime,
+    pub end: SystemTime,
+    pub attributes: Vec<(String, AttributeValue)>,
+    /// Set when the span failed; exported as an error status.
+    pub error: Option<String>,
+}
+
+impl SpanData {
+    fn to_json(&self) -> Value {
+        let mut span = json!({
+            "traceId": hex(&self.trace_id),
+            "spanId": hex(&self.span_id),
+            "name": self.name,
+            "kind": self.kind.code(),
+            "startTimeUnixNano": unix_nanos(self.start).to_string(),
+            "endTimeUnixNano": unix_nanos(self.end).to_string(),
+            "attributes": encode_attributes(&self.attributes),
+        });
+        if let Some(parent) = self.parent_span_id {
+            span["parentSpanId"] = json!(hex(&parent));
+        }
+        if let Some(message) = &self.error {
+            span["status"] = json!({ "code": 2, "message": message });
+        }
+        span
+    }
+}
+
+/// The OTLP/JSON `ExportTraceServiceRequest` body for `spans`.
+pub fn encode_traces(service_name: &str, spans: &[SpanData]) -> Value {
+    let resource = vec![
+        (
+            "service.name".to_string(),
+            AttributeValue::from(service_name),
+        ),
+        (
+            "service.version".to_string(),
+            AttributeValue::from(crate::version::VERSION),
+        ),
+    ];
+    json!({
+        "resourceSpans": [{
+            "resource": { "attributes": encode_attributes(&resource) },
+            "scopeSpans": [{
+                "scope": { "name": "gralph", "version": crate::version::VERSION },
+                "spans": spans.iter().map(SpanData::to_json).collect::<Vec<_>>(),
+            }],
+        }],
+    })
+}
+
+fn encode_attributes(attributes: &[(String, AttributeValue)]) -> Value {
+    Value::Array(
+        attributes
+            .iter()
+            .map(|(key, value)| json!({ "key": key, "value": value.to_json() }))
+            .collect(),
+    )
+}
+
+fn hex(bytes: &[u8]) -> String {
+    bytes.iter().map(|byte| format!("{:02x}", byte)).collect()
+}
+
+fn unix_nanos(time: SystemTime) -> u128 {
+    time.duration_since(UNIX_EPOCH)
+        .unwrap_or_default()
+        .as_nanos()
+}
+
+/// A random non-zero id; OTLP treats all-zero ids as invalid.
+fn random_u64() -> u64 {
+    static COUNTER: AtomicU64 = AtomicU64::new(0);
+    let mut hasher = RandomState::new().build_hasher();
+    hasher.write_u64(COUNTER.fetch_add(1, Ordering::Relaxed));
+    hasher.write_u128(unix_nanos(SystemTime::now()));
+    hasher.finish().max(1)
+}
+
+struct Tracer {
+    exporter: ExporterConfig,
+    trace_id: [u8; 16],
+    /// Ids of the spans that are open, innermost last.
+    open: Vec<[u8; 8]>,
+    finished: Vec<SpanData>,
+    warned: bool,
+}
+
+impl Tracer {
+    fn new(exporter: ExporterConfig) -> Self {
+        let mut trace_id = [0; 16];
+        trace_id[..8].copy_from_slice(&random_u64().to_be_bytes());
+        trace_id[8..].copy_from_slice(&random_u64().to_be_bytes());
+        Self {
+            exporter,
+            trace_id,
+            open: Vec::new(),
+            finished: Vec::new(),
+            warned: false,
+        }
+    }
+
+    fn flush(&mut self) {
+        let spans = std::mem::take(&mut self.finished);
+        if let Err(err) = self.exporter.export(&spans) {
+            if !self.warned {
+                eprintln!("Warning: telemetry export failed: {}", err);
+                self.warned = true;
+            }
+        }
+    }
+}
+
+/// Runs `f` with tracing on when `telemetry.endpoint` is set: spans opened
+/// inside `f` share one trace and are exported by the time it returns.
+/// Inside another `with_tracing`, spans join the outer trace.
+pub fn with_tracing<T>(config: Option<&Config>, f: impl FnOnce() -> T) -> T {
+    struct Restore;
+
+    impl Drop for Restore {
+        fn drop(&mut self) {
+            if let Some(mut tracer) = TRACER.with(|tracer| tracer.borrow_mut().take()) {
+                tracer.flush();
+            }
+        }
+    }
+
+    if TRACER.with(|tracer| tracer.borrow().is_some()) {
+        return f();
+    }
+    let Some(exporter) = config.and_then(ExporterConfig::from_config) else {
+        return f();
+    };
+    TRACER.with(|tracer| *tracer.borrow_mut() = Some(Tracer::new(exporter)));
+    let _restore = Restore;
+    f()
+}
+
+/// Opens a span as a child of the innermost open one. It ends when it is
+/// dropped; outside [`with_tracing`] it records nothing.
+pub fn start_span(name: &str, kind: SpanKind) -> Span {
+    let data = TRACER.with(|tracer| {
+        let mut tracer = tracer.borrow_mut();
+        let tracer = tracer.as_mut()?;
+        let span_id = random_u64().to_be_bytes();
+        let parent_span_id = tracer.open.last().copied();
+        tracer.open.push(span_id);
+        Some(SpanData {
+            name: name.to_string(),
+            kind,
+            trace_id: tracer.trace_id,
+            span_id,
+            parent_span_id,
+            start: SystemTime::now(),
+            end: SystemTime::now(),
+            attributes: Vec::new(),
+            error: None,
+        })
+    });
+    Span { data }
+}
+
+/// An open span; see [`start_span`].
+#[derive(Debug)]
+pub struct Span {
+    data: Option<SpanData>,
+}
+
+impl Span {
+    pub fn with_attribute(mut self, key: &str, value: impl Into<AttributeValue>) -> Self {
+        self.set_attribute(key, value);
+        self
+    }
+
+    pub fn set_attribute(&mut self, key: &str, value: impl Into<AttributeValue>) {
+        if let Some(data) = self.data.as_mut() {
+            data.attributes.push((key.to_string(), value.into()));
+        }
+    }
+
+    /// Marks the span as failed with `message`.
+    pub fn set_error(&mut self, message: impl Into<String>) {
+        if let Some(data) = self.data.as_mut() {
+            data.error = Some(message.into());
+        }
+    }
+
+    pub fn end(self) {}
+}
+
+impl Drop for Span {
+    fn drop(&mut self) {
+        let Some(mut data) = self.data.take() else {
+            return;
+        };
+        data.end = SystemTime::now();
+        TRACER.with(|tracer| {
+            let mut tracer = tracer.borrow_mut();
+            let Some(tracer) = tracer.as_mut() else {
+                return;
+            };
+            tracer.open.retain(|span_id| *span_id != data.span_id);
+            tracer.finished.push(data);
+            // Export once an iteration (a child of the loop span) or a
+            // top-level span ends, rather than once per backend call.
+            if tracer.open.len() <= 1 {
+                tracer.flush();
+            }
+        });
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::serve_http_sequence;
+
+    fn config_with(yaml: &str) -> Config {
+        Config::from_yaml(yaml).unwrap()
+    }
+
+    /// The JSON body of a raw HTTP request.
+    fn request_body(request: &str) -> Value {
+        let (_, body) = request.split_once("\r\n\r\n").unwrap();
+        serde_json::from_str(body).unwrap()
+    }
+
+    fn exported_spans(body: &Value) -> Vec<Value> {
+        body["resourceSpans"][0]["scopeSpans"][0]["spans"]
+            .as_array()
+            .unwrap()
+            .clone()
+    }
+
+    fn attribute<'a>(span: &'a Value, key: &str) -> Option<&'a Value> {
+        span["attributes"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|attribute| attribute["key"] == key)
+            .map(|attribute| &attribute["value"])
+    }
+
+    #[test]
+    fn exporter_config_reads_telemetry_keys() {
+        let _guard = crate::test_support::env_lock();
+        assert_eq!(
+            ExporterConfig::from_config(&config_with("defaults:\n  backend: claude\n")),
+            None
+        );
+
+        let config = config_with(
+            "telemetry:\n  endpoint: http://localhost:4318/\n  headers: \"authorization=Bearer abc, x-team = ops\"\n  service_name: ci-gralph\n",
+        );
+        assert_eq!(
+            ExporterConfig::from_config(&config),
+            Some(ExporterConfig {
+                traces_url: "http://localhost:4318/v1/traces".to_string(),
+                headers: vec![
+                    ("authorization".to_string(), "Bearer abc".to_string()),
+                    ("x-team".to_string(), "ops".to_string()),
+                ],
+                service_name: "ci-gralph".to_string(),
+            })
+        );
+
+        let config = config_with("telemetry:\n  endpoint: https://otel.example.com/v1/traces\n");
+        let exporter = ExporterConfig::from_config(&config).unwrap();
+        assert_eq!(exporter.traces_url, "https://otel.example.com/v1/traces");
+        assert_eq!(exporter.service_name, "gralph");
+        assert_eq!(
+            ExporterConfig::from_config(&config.with_offline(true)),
+            None
+        );
+
+        let config = config_with("telemetry:\n  endpoint: http://127.0.0.1:4318\n");
+        assert!(ExporterConfig::from_config(&config.with_offline(true)).is_some());
+    }
+
+    #[test]
+    fn encode_traces_writes_otlp_json() {
+        let start = UNIX_EPOCH + Duration::from_secs(10);
+        let span = SpanData {
+            name: "gralph.backend".to_string(),
+            kind: SpanKind::Client,
+            trace_id: [0xab; 16],
+            span_id: [0x01; 8],
+            parent_span_id: Some([0x02; 8]),
+            start,
+            end: start + Duration::from_millis(1500),
+            attributes: vec![
+                ("gralph.backend".to_string(), AttributeValue::from("claude")),
+                ("gralph.iteration".to_string(), AttributeValue::from(3u32)),
+            ],
+            error: Some("backend exited with 1".to_string()),
+        };
+
+        let body = encode_traces("gralph", &[span]);
+
+        assert_eq!(
+            body["resourceSpans"][0]["resource"]["attributes"][0],
+            json!({ "key": "service.name", "value": { "stringValue": "gralph" } })
+        );
+        let spans = exported_spans(&body);
+        assert_eq!(spans[0]["traceId"], "ab".repeat(16));
+        assert_eq!(spans[0]["spanId"], "01".repeat(8));
+        assert_eq!(spans[0]["parentSpanId"], "02".repeat(8));
+        assert_eq!(spans[0]["kind"], 3);
+        assert_eq!(spans[0]["startTimeUnixNano"], "10000000000");
+        assert_eq!(spans[0]["endTimeUnixNano"], "11500000000");
+        assert_eq!(
+            attribute(&spans[0], "gralph.iteration"),
+            Some(&json!({ "intValue": "3" }))
+        );
+        assert_eq!(
+            spans[0]["status"],
+            json!({ "code": 2, "message": "backend exited with 1" })
+        );
+    }
+
+    #[test]
+    fn spans_are_no_ops_without_an_endpoint() {
+        let mut span = start_span("gralph.loop", SpanKind::Internal).with_attribute("a", 1u32);
+        span.set_error("ignored");
+        assert!(span.data.is_none());
+        with_tracing(None, || {
+            assert!(start_span("gralph.loop", SpanKind::Internal).data.is_none());
+        });
+    }
+
+    #[test]
+    fn with_tracing_exports_nested_spans_per_iteration() {
+        let _guard = crate::test_support::env_lock();
+        let (endpoint, handle) = serve_http_sequence(vec![
+            ("HTTP/1.1 200 OK", String::new()),
+            ("HTTP/1.1 200 OK", String::new()),
+        ]);
+        let config = config_with(&format!("telemetry:\n  endpoint: {}\n", endpoint));
+
+        with_tracing(Some(&config), || {
+            let root = start_span("gralph.loop", SpanKind::Internal)
+                .with_attribute("gralph.session", "demo");
+            {
+                let _iteration = start_span("gralph.iteration", SpanKind::Internal)
+                    .with_attribute("gralph.iteration", 1u32);
+                let mut backend = start_span("gralph.backend", SpanKind::Client);
+                backend.set_error("rate limited");
+            }
+            root.end();
+        });
+
+        let requests = handle.join().unwrap();
+        assert!(requests[0].starts_with("POST /v1/traces "));
+        let spans = exported_spans(&request_body(&requests[0]));
+        assert_eq!(spans.len(), 2);
+        assert_eq!(spans[0]["name"], "gralph.backend");
+        assert_eq!(spans[1]["name"], "gralph.iteration");
+        assert_eq!(spans[0]["parentSpanId"], spans[1]["spanId"]);
+        assert_eq!(spans[0]["status"]["code"], 2);
+
+        let spans_after = exported_spans(&request_body(&requests[1]));
+        assert_eq!(spans_after.len(), 1);
+        assert_eq!(spans_after[0]["name"], "gralph.loop");
+        assert!(spans_after[0].get("parentSpanId").is_none());
+        assert_eq!(spans[1]["parentSpanId"], spans_after[0]["spanId"]);
+        assert_eq!(spans[1]["traceId"], spans_after[0]["traceId"]);
+        assert_eq!(
+            attribute(&spans_after[0], "gralph.session"),
+            Some(&json!({ "stringValue": "demo" }))
+        );
+    }
+}
@@ -1,4 +1,7 @@
+use std::io::{Read, Write};
+use std::net::TcpListener;
 use std::sync::Mutex;
+use std::thread;
 
 static ENV_LOCK: Mutex<()> = Mutex::new(());
 
@@ -6,6 +9,70 @@ pub fn env_lock() -> std::sync::MutexGuard<'static, ()> {
     ENV_LOCK.lock().unwrap_or_else(|poison| poison.into_inner())
 }
 
+/// Serves a single HTTP response on a random local port and returns the raw
+/// request text from the join handle.
+pub fn serve_http_once(
+    status_line: &'static str,
+    body: String,
+) -> (String, thread::JoinHandle<String>) {
+    let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+    let addr = listener.local_addr().unwrap();
+    let handle = thread::spawn(move || respond_once(&listener, status_line, &body));
+    (format!("http://{}", addr), handle)
+}
+
+/// Serves one response per connection, in order, and returns every raw
+/// request once all responses have been sent.
+pub fn serve_http_sequence(
+    responses: Vec<(&'static str, String)>,
+) -> (String, thread::JoinHandle<Vec<String>>) {
+    let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+    let addr = listener.local_addr().unwrap();
+    let handle = thread::spawn(move || {
+        responses
+            .iter()
+            .map(|(status_line, body)| respond_once(&listener, status_line, body))
+            .collect()
+    });
+    (format!("http://{}", addr), handle)
+}
+
+fn respond_once(listener: &TcpListener, status_line: &str, body: &str) -> String {
+    let (mut stream, _) = listener.accept().unwrap();
+    let mut request = Vec::new();
+    let mut buffer = [0u8; 4096];
+    loop {
+        let read = stream.read(&mut buffer).unwrap();
+        request.extend_from_slice(&buffer[..read]);
+        let text = String::from_utf8_lossy(&request);
+        if let Some(end) = text.find("\r\n\r\n") {
+            let length = text[..end]
+                .lines()
+                .find_map(|line| {
+                    let lower = line.to_ascii_lowercase();
+                    lower
+                        .strip_prefix("content-length:")
+                        .map(|v| v.trim().parse::<usize>().unwrap_or(0))
+                })
+                .unwrap_or(0);
+            if request.len() >= end + 4 + length || read == 0 {
+                break;
+            }
+        }
+        if read == 0 {
+            break;
+        }
+    }
+    let response = format!(
+        "{}\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{}",
+        status_line,
+        body.len(),
+        body
+    );
+    stream.write_all(response.as_bytes()).unwrap();
+    String::from_utf8_lossy(&request).to_string()
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -0,0 +1,755 @@
+//! Closes issues in an external tracker (GitHub, Linear, or Jira) when the
+//! loop checks off the task they belong to.
+//!
+//! A task is linked to an issue by an `- **Issue**` field in its PRD block or
+//! by an `issue` key on its entry in `.gralph/tasks.json`.
+
+use crate::config::Config;
+use crate::gitops::{self, Forge};
+use crate::offline;
+use crate::prd;
+use reqwest::blocking::{Client, RequestBuilder};
+use serde_json::Value;
+use std::env;
+use std::error::Error;
+use std::fmt;
+use std::fs;
+use std::path::Path;
+use std::time::Duration;
+
+const API_TIMEOUT: Duration = Duration::from_secs(30);
+const DEFAULT_DONE_STATE: &str = "Done";
+const GITHUB_API_URL: &str = "https://api.github.com";
+const LINEAR_API_URL: &str = "https://api.linear.app/graphql";
+
+#[derive(Debug)]
+pub enum TrackerError {
+    Config(String),
+    Api(String),
+}
+
+impl fmt::Display for TrackerError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            TrackerError::Config(message) => write!(f, "invalid tracker config: {}", message),
+            TrackerError::Api(message) => write!(f, "tracker request failed: {}", message),
+        }
+    }
+}
+
+impl Error for TrackerError {}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Provider {
+    GitHub,
+    Linear,
+    Jira,
+}
+
+impl Provider {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Provider::GitHub => "github",
+            Provider::Linear => "linear",
+            Provider::Jira => "jira",
+        }
+    }
+
+    fn token_env_names(&self) -> &'static [&'static str] {
+        match self {
+            Provider::GitHub => &["GITHUB_TOKEN", "GH_TOKEN"],
+            Provider::Linear => &["LINEAR_API_KEY"],
+            Provider::Jira => &["JIRA_API_TOKEN"],
+        }
+    }
+}
+
+/// Tracker settings from the `tracker` config section.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TrackerSettings {
+    pub provider: Provider,
+    /// Environment variable holding the API token; defaults per provider.
+    pub token_env: Option<String>,
+    /// API root. Required for Jira (the site URL); defaults for the others.
+    pub api_url: Option<String>,
+    /// `owner/name` for GitHub issues without a repository of their own;
+    /// defaults to the `git.remote` repository.
+    pub repo: Option<String>,
+    /// Remote read for the default GitHub repository (`git.remote`).
+    pub remote: String,
+    /// Linear workflow state or Jira transition that marks an issue done.
+    pub done_state: String,
+    /// Environment variable holding the Jira account email. Without it the
+    /// token is sent as a bearer token (Jira Data Center).
+    pub user_env: String,
+    /// Leave a comment naming the session before closing.
+    pub comment: bool,
+    /// The run is offline, so issues are not closed.
+    pub offline: bool,
+}
+
+impl TrackerSettings {
+    /// `None` when `tracker.provider` is unset.
+    pub fn from_config(config: &Config) -> Result<Option<Self>, TrackerError> {
+        let value = |key: &str| {
+            config
+                .get(key)
+                .map(|value| value.trim().to_string())
+                .filter(|value| !value.is_empty())
+        };
+        let provider = match value("tracker.provider")
+            .map(|value| value.to_ascii_lowercase())
+            .as_deref()
+        {
+            None | Some("none") => return Ok(None),
+            Some("github") => Provider::GitHub,
+            Some("linear") => Provider::Linear,
+            Some("jira") => Provider::Jira,
+            Some(other) => {
+                return Err(TrackerError::Config(format!(
+                    "unknown tracker.provider: {} (expected github, linear, or jira)",
+                    other
+                )));
+            }
+        };
+        let api_url = value("tracker.api_url");
+        if provider == Provider::Jira && api_url.is_none() {
+            return Err(TrackerError::Config(
+                "tracker.api_url is required for jira (e.g. https://example.atlassian.net)"
+                    .to_string(),
+            ));
+        }
+        Ok(Some(Self {
+            provider,
+            token_env: value("tracker.token_env"),
+            api_url,
+            repo: value("tracker.repo"),
+            remote: value("git.remote").unwrap_or_else(|| "origin".to_string()),
+            done_state: value("tracker.done_state")
+                .unwrap_or_else(|| DEFAULT_DONE_STATE.to_string()),
+            user_env: value("tracker.user_env").unwrap_or_else(|| "JIRA_EMAIL".to_string()),
+            comment: value("tracker.comment").is_none_or(|value| {
+                matches!(
+                    value.to_ascii_lowercase().as_str(),
+                    "true" | "1" | "yes" | "y" | "on"
+                )
+            }),
+            offline: config.offline(),
+        }))
+    }
+
+    fn token(&self) -> Result<String, TrackerError> {
+        let names: Vec<&str> = match self.token_env.as_deref() {
+            Some(name) => vec![name],
+            None => self.provider.token_env_names().to_vec(),
+        };
+        names
+            .iter()
+            .find_map(|name| env::var(name).ok().filter(|value| !value.trim().is_empty()))
+            .map(|token| token.trim().to_string())
+            .ok_or_else(|| {
+                TrackerError::Config(format!("set {} to sync issues", names.join(" or ")))
+            })
+    }
+}
+
+/// An issue reference parsed from a task's `Issue` field or record.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct IssueRef {
+    /// `owner/name` when a GitHub reference names its repository.
+    pub repo: Option<String>,
+    /// Issue number for GitHub, identifier (`ENG-42`) for Linear and Jira.
+    pub key: String,
+}
+
+/// Accepts `#12`, `12`, `owner/name#12`, `ENG-42`, and issue URLs.
+pub fn parse_issue_ref(provider: Provider, raw: &str) -> Option<IssueRef> {
+    let raw = raw.trim().trim_matches('`').trim();
+    let segments: Vec<&str> = raw
+        .split_once("://")
+        .map(|(_, rest)| rest.split('/').filter(|part| !part.is_empty()).collect())
+        .unwrap_or_default();
+    match provider {
+        Provider::GitHub => {
+            let (repo, number) = if segments.is_empty() {
+                match raw.rsplit_once('#') {
+                    Some((repo, number)) => (
+                        Some(repo.to_string()).filter(|repo| repo.contains('/')),
+                        number,
+                    ),
+                    None => (None, raw),
+                }
+            } else {
+                let index = segments.iter().position(|part| *part == "issues")?;
+                let repo = (index >= 3).then(|| segments[index - 2..index].join("/"));
+                (repo, *segments.get(index + 1)?)
+            };
+            number.parse::<u64>().ok()?;
+            Some(IssueRef {
+                repo,
+                key: number.to_string(),
+            })
+        }
+        Provider::Linear | Provider::Jira => {
+            let key = if segments.is_empty() {
+                raw
+            } else {
+                let marker = if provider == Provider::Linear {
+                    "issue"
+                } else {
+                    "browse"
+                };
+                let index = segments.iter().position(|part| *part == marker)?;
+                *segments.get(index + 1)?
+            };
+            let (project, number) = key.split_once('-')?;
+            let valid = project
+                .chars()
+                .next()
+                .is_some_and(|ch| ch.is_ascii_alphabetic())
+                && project.chars().all(|ch| ch.is_ascii_alphanumeric())
+                && !number.is_empty()
+                && number.chars().all(|ch| ch.is_ascii_digit());
+            valid.then(|| IssueRef {
+                repo: None,
+                key: key.to_ascii_uppercase(),
+            })
+        }
+    }
+}
+
+/// The issue linked to `task_id`, from the PRD block first and then
+/// `.gralph/tasks.json`.
+pub fn task_issue(project_dir: &Path, task_file: &Path, task_id: &str) -> Option<String> {
+    let contents = fs::read_to_string(task_file).unwrap_or_default();
+    let graph = prd::TaskGraph::from_contents(&contents);
+    if let Some(issue) = graph
+        .node(task_id)
+        .and_then(|node| prd::prd_task_issue(&node.block))
+    {
+        return Some(issue);
+    }
+    let records = fs::read_to_string(crate::core::task_records_path(project_dir)).ok()?;
+    let records: Value = serde_json::from_str(&records).ok()?;
+    records["tasks"][task_id]["issue"]
+        .as_str()
+        .map(|issue| issue.trim().to_string())
+        .filter(|issue| !issue.is_empty())
+}
+
+/// Who finished the task, for the completion comment.
+#[derive(Debug, Clone, Copy)]
+pub struct Completion<'a> {
+    pub task_id: &'a str,
+    pub session: &'a str,
+    pub iteration: u32,
+}
+
+impl Completion<'_> {
+    fn comment(&self) -> String {
+        format!(
+            "Task {} was completed by gralph session {} (iteration {}).",
+            self.task_id, self.session, self.iteration
+        )
+    }
+}
+
+/// Marks the issue done and returns a display name for it (`o/r#12`, `ENG-42`).
+pub fn close_issue(
+    settings: &TrackerSettings,
+    project_dir: &Path,
+    issue: &str,
+    completion: &Completion<'_>,
+) -> Result<String, TrackerError> {
+    if settings.offline {
+        return Err(TrackerError::Api(offline::refusal(&format!(
+            "closing issue {}",
+            issue
+        ))));
+    }
+    let issue_ref = parse_issue_ref(settings.provider, issue)
+        .ok_or_else(|| TrackerError::Config(format!("unrecognized issue reference: {}", issue)))?;
+    let token = settings.token()?;
+    let client = Client::builder()
+        .timeout(API_TIMEOUT)
+        .build()
+        .map_err(|err| TrackerError::Api(err.to_string()))?;
+    match settings.provider {
+        Provider::GitHub => {
+            let remote = (issue_ref.repo.is_none() && settings.repo.is_none())
+                .then(|| {
+                    gitops::remote_repo(project_dir, &settings.remote)
+                        .filter(|repo| repo.forge == Forge::GitHub)
+                })
+                .flatten();
+            let repo = issue_ref
+                .repo
+                .clone()
+                .or_else(|| settings.repo.clone())
+                .or_else(|| remote.as_ref().map(|repo| repo.path.clone()))
+                .ok_or_else(|| {
+                    TrackerError::Config(format!(
+                        "set tracker.repo or use owner/name#N to close {}",
+                        issue
+                    ))
+                })?;
+            let api_url = settings
+                .api_url
+                .clone()
+                .or_else(|| remote.as_ref().map(|repo| repo.default_api_url()))
+                .unwrap_or_else(|| GITHUB_API_URL.to_string());
+            close_github_issue(
+                &client,
+                &api_url,
+                &token,
+                &repo,
+                &issue_ref.key,
+                settings.comment.then(|| completion.comment()).as_deref(),
+            )?;
+            Ok(format!("{}#{}", repo, issue_ref.key))
+        }
+        Provider::Linear => {
+            let api_url = settings.api_url.as_deref().unwrap_or(LINEAR_API_URL);
+            close_linear_issue(
+                &client,
+                api_url,
+                &token,
+                &issue_ref.key,
+                &settings.done_state,
+                settings.comment.then(|| completion.comment()).as_deref(),
+            )?;
+            Ok(issue_ref.key)
+        }
+        Provider::Jira => {
+            let api_url = settings.api_url.as_deref().unwrap_or_default();
+            let auth = match env::var(&settings.user_env)
+                .ok()
+                .filter(|user| !user.trim().is_empty())
+            {
+                Some(user) => JiraAuth::Basic(user.trim().to_string(), token),
+                None => JiraAuth::Bearer(token),
+            };
+            close_jira_issue(
+                &client,
+                api_url,
+                &auth,
+                &issue_ref.key,
+                &settings.done_state,
+                settings.comment.then(|| completion.comment()).as_deref(),
+            )?;
+            Ok(issue_ref.key)
+        }
+    }
+}
+
+fn close_github_issue(
+    client: &Client,
+    api_url: &str,
+    token: &str,
+    repo: &str,
+    number: &str,
+    comment: Option<&str>,
+) -> Result<(), TrackerError> {
+    let base = format!(
+        "{}/repos/{}/issues/{}",
+        api_url.trim_end_matches('/'),
+        repo,
+        number
+    );
+    let authorize = |builder: RequestBuilder| {
+        builder
+            .header("Authorization", format!("Bearer {}", token))
+            .header("Accept", "application/vnd.github+json")
+    };
+    if let Some(comment) = comment {
+        let url = format!("{}/comments", base);
+        send(
+            authorize(client.post(&url)).body(serde_json::json!({ "body": comment }).to_string()),
+            &url,
+        )?;
+    }
+    send(
+        authorize(client.patch(&base)).body(
+            serde_json::json!({ "state": "closed", "state_reason": "completed" }).to_string(),
+        ),
+        &base,
+    )?;
+    Ok(())
+}
+
+fn close_linear_issue(
+    client: &Client,
+    api_url: &str,
+    token: &str,
+    identifier: &str,
+    done_state: &str,
+    comment: Option<&str>,
+) -> Result<(), TrackerError> {
+    let query = |body: Value| -> Result<Value, TrackerError> {
+        let response = send(
+            client
+                .post(api_url)
+                .header("Authorization", token)
+                .body(body.to_string()),
+            api_url,
+        )?;
+        if let Some(errors) = response.get("errors").filter(|errors| !errors.is_null()) {
+            return Err(TrackerError::Api(format!(
+                "{} returned errors: {}",
+                api_url, errors
+            )));
+        }
+        Ok(response)
+    };
+
+    let lookup = query(serde_json::json!({
+        "query": "query($id: String!, $state: String!) { issue(id: $id) { id team { states(filter: { name: { eqIgnoreCase: $state } }) { nodes { id } } } } }",
+        "variables": { "id": identifier, "state": done_state },
+    }))?;
+    let issue = &lookup["data"]["issue"];
+    let issue_id = issue["id"]
+        .as_str()
+        .ok_or_else(|| TrackerError::Api(format!("Linear issue not found: {}", identifier)))?;
+    let state_id = issue["team"]["states"]["nodes"][0]["id"]
+        .as_str()
+        .ok_or_else(|| {
+            TrackerError::Api(format!(
+                "Linear workflow state not found for {}: {}",
+                identifier, done_state
+            ))
+        })?;
+
+    let mutation = match comment {
+        Some(_) => {
+            "mutation($id: String!, $stateId: String!, $body: String!) { commentCreate(input: { issueId: $id, body: $body }) { success } issueUpdate(id: $id, input: { stateId: $stateId }) { success } }"
+        }
+        None => {
+            "mutation($id: String!, $stateId: String!) { issueUpdate(id: $id, input: { stateId: $stateId }) { success } }"
+        }
+    };
+    let mut variables = serde_json::json!({ "id": issue_id, "stateId": state_id });
+    if let Some(comment) = comment {
+        variables["body"] = serde_json::json!(comment);
+    }
+    let updated = query(serde_json::json!({ "query": mutation, "variables": variables }))?;
+    if updated["data"]["issueUpdate"]["success"].as_bool() != Some(true) {
+        return Err(TrackerError::Api(format!(
+            "Linear did not update {}",
+            identifier
+        )));
+    }
+    Ok(())
+}
+
+enum JiraAuth {
+    Basic(String, String),
+    Bearer(String),
+}
+
+fn close_jira_issue(
+    client: &Client,
+    api_url: &str,
+    auth: &JiraAuth,
+    key: &str,
+    done_state: &str,
+    comment: Option<&str>,
+) -> Result<(), TrackerError> {
+    let base = format!("{}/rest/api/2/issue/{}", api_url.trim_end_matches('/'), key);
+    let authorize = |builder: RequestBuilder| match auth {
+        JiraAuth::Basic(user, token) => builder.basic_auth(user, Some(token)),
+        JiraAuth::Bearer(token) => builder.bearer_auth(token),
+    };
+
+    let transitions_url = format!("{}/transitions", base);
+    let transitions = send(authorize(client.get(&transitions_url)), &transitions_url)?;
+    let transition_id = transitions["transitions"]
+        .as_array()
+        .into_iter()
+        .flatten()
+        .find(|transition| {
+            [&transition["name"], &transition["to"]["name"]]
+                .iter()
+                .any(|name| {
+                    name.as_str()
+                        .is_some_and(|name| name.eq_ignore_ascii_case(done_state))
+                })
+        })
+        .and_then(|transition| transition["id"].as_str())
+        .ok_or_else(|| {
+            TrackerError::Api(format!(
+                "Jira transition not available for {}: {}",
+                key, done_state
+            ))
+        })?;
+
+    if let Some(comment) = comment {
+        let url = format!("{}/comment", base);
+        send(
+            authorize(client.post(&url)).body(serde_json::json!({ "body": comment }).to_string()),
+            &url,
+        )?;
+    }
+    send(
+        authorize(client.post(&transitions_url))
+            .body(serde_json::json!({ "transition": { "id": transition_id } }).to_string()),
+        &transitions_url,
+    )?;
+    Ok(())
+}
+
+fn send(builder: RequestBuilder, url: &str) -> Result<Value, TrackerError> {
+    let response = builder
+        .header("Content-Type", "application/json")
+        .header("User-Agent", "gralph")
+        .send()
+        .map_err(|err| TrackerError::Api(err.to_string()))?;
+    let status = response.status();
+    let body = response
+        .text()
+        .map_err(|err| TrackerError::Api(err.to_string()))?;
+    if !status.is_success() {
+        return Err(TrackerError::Api(format!(
+            "{} returned {}: {}",
+            url,
+            status,
+            body.trim()
+        )));
+    }
+    if body.trim().is_empty() {
+        return Ok(Value::Null);
+    }
+    serde_json::from_str(&body).map_err(|err| TrackerError::Api(err.to_string()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::serve_http_sequence;
+
+    fn config_with(dir: &Path, yaml: &str) -> Config {
+        let _guard = crate::test_support::env_lock();
+        let path = dir.join("tracker.yaml");
+        fs::write(&path, yaml).unwrap();
+        unsafe {
+            env::set_var("GRALPH_DEFAULT_CONFIG", &path);
+            env::set_var("GRALPH_GLOBAL_CONFIG", dir.join("missing-global.yaml"));
+        }
+        let config = Config::load(None).unwrap();
+        unsafe {
+            env::remove_var("GRALPH_DEFAULT_CONFIG");
+            env::remove_var("GRALPH_GLOBAL_CONFIG");
+        }
+        config
+    }
+
+    fn settings(provider: Provider, api_url: &str, token_env: &str) -> TrackerSettings {
+        let _guard = crate::test_support::env_lock();
+        unsafe {
+            env::set_var(token_env, "tok");
+        }
+        TrackerSettings {
+            provider,
+            token_env: Some(token_env.to_string()),
+            api_url: Some(api_url.to_string()),
+            repo: Some("o/r".to_string()),
+            remote: "origin".to_string(),
+            done_state: "Done".to_string(),
+            user_env: "GRALPH_TEST_TRACKER_MISSING_USER".to_string(),
+            comment: true,
+            offline: false,
+        }
+    }
+
+    fn completion() -> Completion<'static> {
+        Completion {
+            task_id: "A-1",
+            session: "demo",
+            iteration: 3,
+        }
+    }
+
+    #[test]
+    fn parse_issue_ref_accepts_numbers_identifiers_and_urls() {
+        assert_eq!(
+            parse_issue_ref(Provider::GitHub, "#12"),
+            Some(IssueRef {
+                repo: None,
+                key: "12".to_string()
+            })
+        );
+        assert_eq!(
+            parse_issue_ref(Provider::GitHub, "`acme/api#7`")
+                .unwrap()
+                .repo,
+            Some("acme/api".to_string())
+        );
+        let url =
+            parse_issue_ref(Provider::GitHub, "https://github.com/acme/api/issues/9").unwrap();
+        assert_eq!(
+            (url.repo.as_deref(), url.key.as_str()),
+            (Some("acme/api"), "9")
+        );
+        assert_eq!(parse_issue_ref(Provider::GitHub, "ENG-1"), None);
+
+        assert_eq!(
+            parse_issue_ref(Provider::Linear, "eng-42").unwrap().key,
+            "ENG-42"
+        );
+        assert_eq!(
+            parse_issue_ref(
+                Provider::Linear,
+                "https://linear.app/acme/issue/ENG-42/add-billing"
+            )
+            .unwrap()
+            .key,
+            "ENG-42"
+        );
+        assert_eq!(
+            parse_issue_ref(Provider::Jira, "https://acme.atlassian.net/browse/PROJ-7")
+                .unwrap()
+                .key,
+            "PROJ-7"
+        );
+        assert_eq!(parse_issue_ref(Provider::Jira, "#12"), None);
+        assert_eq!(parse_issue_ref(Provider::Jira, "PROJ-"), None);
+    }
+
+    #[test]
+    fn settings_from_config_validates_provider() {
+        let temp = tempfile::tempdir().unwrap();
+
+        let config = config_with(temp.path(), "tracker:\n  provider: \"\"\n");
+        assert_eq!(TrackerSettings::from_config(&config).unwrap(), None);
+
+        let config = config_with(temp.path(), "tracker:\n  provider: trello\n");
+        let err = TrackerSettings::from_config(&config).unwrap_err();
+        assert!(err.to_string().contains("unknown tracker.provider: trello"));
+
+        let config = config_with(temp.path(), "tracker:\n  provider: jira\n");
+        let err = TrackerSettings::from_config(&config).unwrap_err();
+        assert!(err.to_string().contains("tracker.api_url is required"));
+
+        let config = config_with(
+            temp.path(),
+            "tracker:\n  provider: Linear\n  comment: false\n",
+        );
+        let settings = TrackerSettings::from_config(&config).unwrap().unwrap();
+        assert_eq!(settings.provider, Provider::Linear);
+        assert_eq!(settings.done_state, "Done");
+        assert_eq!(settings.remote, "origin");
+        assert!(!settings.comment);
+    }
+
+    #[test]
+    fn task_issue_prefers_prd_field_over_task_records() {
+        let temp = tempfile::tempdir().unwrap();
+        let prd = temp.path().join("PRD.md");
+        fs::write(
+            &prd,
+            "### Task A-1\n- **ID** A-1\n- **Issue** #4\n- [x] A-1 Done\n---\n### Task A-2\n- **ID** A-2\n- [x] A-2 Done\n",
+        )
+        .unwrap();
+        fs::create_dir_all(temp.path().join(".gralph")).unwrap();
+        fs::write(
+            temp.path().join(".gralph/tasks.json"),
+            "{\"tasks\":{\"A-1\":{\"issue\":\"#9\"},\"A-2\":{\"issue\":\"#5\"}}}",
+        )
+        .unwrap();
+
+        assert_eq!(task_issue(temp.path(), &prd, "A-1").as_deref(), Some("#4"));
+        assert_eq!(task_issue(temp.path(), &prd, "A-2").as_deref(), Some("#5"));
+        assert_eq!(task_issue(temp.path(), &prd, "A-3"), None);
+    }
+
+    #[test]
+    fn close_issue_comments_and_closes_github_issue() {
+        let (base, handle) = serve_http_sequence(vec![
+            ("HTTP/1.1 201 Created", "{\"id\":1}".to_string()),
+            ("HTTP/1.1 200 OK", "{\"state\":\"closed\"}".to_string()),
+        ]);
+        let settings = settings(Provider::GitHub, &base, "GRALPH_TEST_TRACKER_GITHUB");
+
+        let name = close_issue(&settings, Path::new("/nonexistent"), "#12", &completion()).unwrap();
+
+        assert_eq!(name, "o/r#12");
+        let requests = handle.join().unwrap();
+        assert!(requests[0].starts_with("POST /repos/o/r/issues/12/comments"));
+        assert!(requests[0].contains("gralph session demo (iteration 3)"));
+        assert!(
+            requests[0]
+                .to_ascii_lowercase()
+                .contains("authorization: bearer tok")
+        );
+        assert!(requests[1].starts_with("PATCH /repos/o/r/issues/12"));
+        assert!(requests[1].contains("\"state\":\"closed\""));
+    }
+
+    #[test]
+    fn close_issue_moves_linear_issue_to_done_state() {
+        let (base, handle) = serve_http_sequence(vec![
+            (
+                "HTTP/1.1 200 OK",
+                "{\"data\":{\"issue\":{\"id\":\"uuid-1\",\"team\":{\"states\":{\"nodes\":[{\"id\":\"state-done\"}]}}}}}"
+                    .to_string(),
+            ),
+            (
+                "HTTP/1.1 200 OK",
+                "{\"data\":{\"commentCreate\":{\"success\":true},\"issueUpdate\":{\"success\":true}}}"
+                    .to_string(),
+            ),
+        ]);
+        let settings = settings(Provider::Linear, &base, "GRALPH_TEST_TRACKER_LINEAR");
+
+        let name = close_issue(&settings, Path::new("."), "ENG-42", &completion()).unwrap();
+
+        assert_eq!(name, "ENG-42");
+        let requests = handle.join().unwrap();
+        assert!(requests[0].contains("\"id\":\"ENG-42\""));
+        assert!(requests[0].contains("\"state\":\"Done\""));
+        assert!(requests[1].contains("\"stateId\":\"state-done\""));
+        assert!(requests[1].contains("commentCreate"));
+    }
+
+    #[test]
+    fn close_issue_reports_missing_linear_state() {
+        let (base, handle) = serve_http_sequence(vec![(
+            "HTTP/1.1 200 OK",
+            "{\"data\":{\"issue\":{\"id\":\"uuid-1\",\"team\":{\"states\":{\"nodes\":[]}}}}}"
+                .to_string(),
+        )]);
+        let settings = settings(Provider::Linear, &base, "GRALPH_TEST_TRACKER_LINEAR_STATE");
+
+        let err = close_issue(&settings, Path::new("."), "ENG-42", &completion()).unwrap_err();
+
+        let _ = handle.join();
+        assert!(err.to_string().contains("workflow state not found"));
+    }
+
+    #[test]
+    fn close_issue_applies_jira_transition() {
+        let (base, handle) = serve_http_sequence(vec![
+            (
+                "HTTP/1.1 200 OK",
+                "{\"transitions\":[{\"id\":\"11\",\"name\":\"Start\",\"to\":{\"name\":\"In Progress\"}},{\"id\":\"31\",\"name\":\"Resolve\",\"to\":{\"name\":\"Done\"}}]}"
+                    .to_string(),
+            ),
+            ("HTTP/1.1 201 Created", "{\"id\":\"100\"}".to_string()),
+            ("HTTP/1.1 204 No Content", String::new()),
+        ]);
+        let settings = settings(Provider::Jira, &base, "GRALPH_TEST_TRACKER_JIRA");
+
+        let name = close_issue(&settings, Path::new("."), "proj-7", &completion()).unwrap();
+
+        assert_eq!(name, "PROJ-7");
+        let requests = handle.join().unwrap();
+        assert!(requests[0].starts_with("GET /rest/api/2/issue/PROJ-7/transitions"));
+        assert!(
+            requests[0]
+                .to_ascii_lowercase()
+                .contains("authorization: bearer tok")
+        );
+        assert!(requests[1].starts_with("POST /rest/api/2/issue/PROJ-7/comment"));
+        assert!(requests[2].starts_with("POST /rest/api/2/issue/PROJ-7/transitions"));
+        assert!(requests[2].contains("\"id\":\"31\""));
+    }
+}
@@ -0,0 +1,585 @@
+//! Monorepo workspace packages declared by pnpm, npm/yarn `workspaces`, Nx,
+//! Turborepo, Go workspaces (`go.work`), and Cargo workspaces.
+//!
+//! `--workspace <name>` on `start` and `prd create` resolves a package by its
+//! manifest name, its path relative to the repo root, or its directory name.
+
+use serde_json::Value;
+use std::error::Error;
+use std::fmt;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// How deep a `**` glob descends below its prefix.
+const MAX_GLOB_DEPTH: usize = 5;
+/// Package roots Nx and Turborepo use when no `workspaces` globs are declared.
+const DEFAULT_JS_PATTERNS: [&str; 3] = ["apps/*", "packages/*", "libs/*"];
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct WorkspacePackage {
+    pub name: String,
+    /// Relative to the workspace root, using `/` separators.
+    pub path: String,
+    /// The tool that declared the package: pnpm, npm, nx, turbo, go, or cargo.
+    pub source: &'static str,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum WorkspaceError {
+    NoWorkspaces(PathBuf),
+    NotFound {
+        name: String,
+        available: Vec<String>,
+    },
+    Ambiguous {
+        name: String,
+        matches: Vec<String>,
+    },
+}
+
+impl fmt::Display for WorkspaceError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            WorkspaceError::NoWorkspaces(root) => {
+                write!(f, "No workspace packages found in {}", root.display())
+            }
+            WorkspaceError::NotFound { name, available } => write!(
+                f,
+                "Unknown workspace: {} (available: {})",
+                name,
+                available.join(", ")
+            ),
+            WorkspaceError::Ambiguous { name, matches } => {
+                write!(f, "Workspace {} is ambiguous: {}", name, matches.join(", "))
+            }
+        }
+    }
+}
+
+impl Error for WorkspaceError {}
+
+/// All packages declared under `root`, in declaration order. A directory
+/// claimed by more than one tool is listed once, under the first.
+pub fn detect_workspaces(root: &Path) -> Vec<WorkspacePackage> {
+    let mut packages = Vec::new();
+
+    let mut js_patterns: Vec<(String, &'static str)> = Vec::new();
+    let pnpm = root.join("pnpm-workspace.yaml");
+    if let Ok(contents) = fs::read_to_string(&pnpm) {
+        for pattern in pnpm_patterns(&contents) {
+            js_patterns.push((pattern, "pnpm"));
+        }
+    }
+    if let Some(patterns) = package_json_workspaces(&root.join("package.json")) {
+        for pattern in patterns {
+            js_patterns.push((pattern, "npm"));
+        }
+    }
+    if js_patterns.is_empty() {
+        let source = if root.join("nx.json").is_file() {
+            Some("nx")
+        } else if root.join("turbo.json").is_file() {
+            Some("turbo")
+        } else {
+            None
+        };
+        if let Some(source) = source {
+            for pattern in DEFAULT_JS_PATTERNS {
+                js_patterns.push((pattern.to_string(), source));
+            }
+        }
+    }
+    let excluded: Vec<PathBuf> = js_patterns
+        .iter()
+        .filter_map(|(pattern, _)| pattern.strip_prefix('!'))
+        .flat_map(|pattern| expand_pattern(root, pattern))
+        .collect();
+    for (pattern, source) in &js_patterns {
+        if pattern.starts_with('!') {
+            continue;
+        }
+        for dir in expand_pattern(root, pattern) {
+            if excluded.contains(&dir) {
+                continue;
+            }
+            if let Some(name) = js_package_name(&dir) {
+                push_package(&mut packages, root, &dir, name, source);
+            }
+        }
+    }
+
+    if let Ok(contents) = fs::read_to_string(root.join("go.work")) {
+        for entry in go_work_uses(&contents) {
+            let dir = root.join(&entry);
+            if let Some(name) = go_module_name(&dir) {
+                push_package(&mut packages, root, &dir, name, "go");
+            }
+        }
+    }
+
+    if let Ok(contents) = fs::read_to_string(root.join("Cargo.toml")) {
+        let members = toml_string_array(&contents, "workspace", "members");
+        let excluded: Vec<PathBuf> = toml_string_array(&contents, "workspace", "exclude")
+            .iter()
+            .flat_map(|pattern| expand_pattern(root, pattern))
+            .collect();
+        for pattern in members {
+            for dir in expand_pattern(root, &pattern) {
+                if excluded.contains(&dir) {
+                    continue;
+                }
+                if let Some(name) = cargo_package_name(&dir) {
+                    push_package(&mut packages, root, &dir, name, "cargo");
+                }
+            }
+        }
+    }
+
+    packages
+}
+
+/// The directory of the package `name` under `root`. Manifest names win over
+/// relative paths, which win over bare directory names.
+pub fn resolve_workspace(root: &Path, name: &str) -> Result<PathBuf, WorkspaceError> {
+    let packages = detect_workspaces(root);
+    if packages.is_empty() {
+        return Err(WorkspaceError::NoWorkspaces(root.to_path_buf()));
+    }
+    let query = name.trim().trim_start_matches("./").trim_end_matches('/');
+    let by_name: Vec<&WorkspacePackage> = packages.iter().filter(|p| p.name == query).collect();
+    let by_path: Vec<&WorkspacePackage> = packages.iter().filter(|p| p.path == query).collect();
+    let by_dir: Vec<&WorkspacePackage> = packages
+        .iter()
+        .filter(|p| p.path.rsplit('/').next() == Some(query))
+        .collect();
+    let matches = [by_name, by_path, by_dir]
+        .into_iter()
+        .find(|matches| !matches.is_empty())
+        .unwrap_or_default();
+    match matches.as_slice() {
+        [package] => Ok(root.join(&package.path)),
+        [] => Err(WorkspaceError::NotFound {
+            name: name.to_string(),
+            available: packages.iter().map(|p| p.name.clone()).collect(),
+        }),
+        _ => Err(WorkspaceError::Ambiguous {
+            name: name.to_string(),
+            matches: matches.iter().map(|p| p.path.clone()).collect(),
+        }),
+    }
+}
+
+fn push_package(
+    packages: &mut Vec<WorkspacePackage>,
+    root: &Path,
+    dir: &Path,
+    name: String,
+    source: &'static str,
+) {
+    let Ok(relative) = dir.strip_prefix(root) else {
+        return;
+    };
+    let path = relative
+        .components()
+        .map(|part| part.as_os_str().to_string_lossy().to_string())
+        .collect::<Vec<_>>()
+        .join("/");
+    if path.is_empty() || packages.iter().any(|package| package.path == path) {
+        return;
+    }
+    packages.push(WorkspacePackage { name, path, source });
+}
+
+fn pnpm_patterns(contents: &str) -> Vec<String> {
+    let Ok(value) = serde_yaml::from_str::<serde_yaml::Value>(contents) else {
+        return Vec::new();
+    };
+    value
+        .get("packages")
+        .and_then(|packages| packages.as_sequence())
+        .map(|items| {
+            items
+                .iter()
+                .filter_map(|item| item.as_str().map(str::to_string))
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+/// `workspaces` as an array, or as `{ "packages": [...] }` (yarn classic).
+fn package_json_workspaces(path: &Path) -> Option<Vec<String>> {
+    let value: Value = serde_json::from_str(&fs::read_to_string(path).ok()?).ok()?;
+    let workspaces = value.get("workspaces")?;
+    let items = workspaces
+        .as_array()
+        .or_else(|| workspaces.get("packages").and_then(Value::as_array))?;
+    Some(
+        items
+            .iter()
+            .filter_map(|item| item.as_str().map(str::to_string))
+            .collect(),
+    )
+}
+
+/// `package.json` name, then Nx `project.json` name, then the directory name.
+fn js_package_name(dir: &Path) -> Option<String> {
+    for manifest in ["package.json", "project.json"] {
+        let path = dir.join(manifest);
+        if !path.is_file() {
+            continue;
+        }
+        let name = fs::read_to_string(&path)
+            .ok()
+            .and_then(|contents| serde_json::from_str::<Value>(&contents).ok())
+            .and_then(|value| {
+                value
+                    .get("name")
+                    .and_then(Value::as_str)
+                    .map(str::to_string)
+            })
+            .filter(|name| !name.is_empty());
+        return name.or_else(|| dir_name(dir));
+    }
+    None
+}
+
+fn go_work_uses(contents: &str) -> Vec<String> {
+    let mut uses = Vec::new();
+    let mut in_block = false;
+    for line in contents.lines() {
+        let line = line.split("//").next().unwrap_or("").trim();
+        if in_block {
+            if line == ")" {
+                in_block = false;
+            } else if !line.is_empty() {
+                uses.push(line.trim_matches('"').to_string());
+            }
+            continue;
+        }
+        let Some(rest) = line.strip_prefix("use") else {
+            continue;
+        };
+        if !rest.starts_with(|ch: char| ch.is_whitespace() || ch == '(') {
+            continue;
+        }
+        let rest = rest.trim();
+        if rest == "(" {
+            in_block = true;
+        } else if !rest.is_empty() {
+            uses.push(rest.trim_matches('"').to_string());
+        }
+    }
+    uses
+}
+
+fn go_module_name(dir: &Path) -> Option<String> {
+    let contents = fs::read_to_string(dir.join("go.mod")).ok()?;
+    contents
+        .lines()
+        .find_map(|line| line.trim().strip_prefix("module "))
+        .map(|module| module.trim().trim_matches('"').to_string())
+        .or_else(|| dir_name(dir))
+}
+
+fn cargo_package_name(dir: &Path) -> Option<String> {
+    let contents = fs::read_to_string(dir.join("Cargo.toml")).ok()?;
+    let mut in_package = false;
+    for line in contents.lines() {
+        let line = line.trim();
+        if line.starts_with('[') {
+            in_package = line == "[package]";
+            continue;
+        }
+        if !in_package {
+            continue;
+        }
+        if let Some((key, value)) = line.split_once('=') {
+            if key.trim() == "name" {
+                return Some(value.trim().trim_matches('"').to_string());
+            }
+        }
+    }
+    dir_name(dir)
+}
+
+/// The quoted strings in `key = [...]` under `[section]`. Arrays may span
+/// lines; anything fancier than strings and comments is ignored.
+fn toml_string_array(contents: &str, section: &str, key: &str) -> Vec<String> {
+    let header = format!("[{}]", section);
+    let mut in_section = false;
+    let mut collecting = false;
+    let mut buffer = String::new();
+    for line in contents.lines() {
+        let line = line.split('#').next().unwrap_or("").trim();
+        if collecting {
+            buffer.push_str(line);
+            if line.contains(']') {
+                break;
+            }
+            continue;
+        }
+        if line.starts_with('[') && !line.contains('=') {
+            in_section = line == header;
+            continue;
+        }
+        if !in_section {
+            continue;
+        }
+        if let Some((name, value)) = line.split_once('=') {
+            if name.trim() == key {
+                buffer.push_str(value);
+                if value.contains(']') {
+                    break;
+                }
+                collecting = true;
+            }
+        }
+    }
+    buffer
+        .split('"')
+        .skip(1)
+        .step_by(2)
+        .map(str::to_string)
+        .collect()
+}
+
+/// Directories under `root` matching a workspace glob. `*` matches within a
+/// path segment and `**` matches any number of segments.
+fn expand_pattern(root: &Path, pattern: &str) -> Vec<PathBuf> {
+    let pattern = pattern
+        .trim()
+        .trim_start_matches("./")
+        .trim_end_matches('/');
+    if pattern.is_empty() {
+        return Vec::new();
+    }
+    let segments: Vec<&str> = pattern.split('/').collect();
+    let mut matches = Vec::new();
+    expand_segments(root, &segments, 0, &mut matches);
+    matches.sort();
+    matches.dedup();
+    matches
+}
+
+fn expand_segments(dir: &Path, segments: &[&str], depth: usize, out: &mut Vec<PathBuf>) {
+    let Some((segment, rest)) = segments.split_first() else {
+        if dir.is_dir() {
+            out.push(dir.to_path_buf());
+        }
+        return;
+    };
+    if *segment == "**" {
+        expand_segments(dir, rest, depth, out);
+        if depth < MAX_GLOB_DEPTH {
+            for child in child_dirs(dir) {
+                expand_segments(&child, segments, depth + 1, out);
+            }
+        }
+        return;
+    }
+    if !segment.contains('*') {
+        expand_segments(&dir.join(segment), rest, depth, out);
+        return;
+    }
+    for child in child_dirs(dir) {
+        let matched = child
+            .file_name()
+            .and_then(|name| name.to_str())
+            .map(|name| wildcard_match(segment, name))
+            .unwrap_or(false);
+        if matched {
+            expand_segments(&child, rest, depth, out);
+        }
+    }
+}
+
+fn child_dirs(dir: &Path) -> Vec<PathBuf> {
+    let Ok(entries) = fs::read_dir(dir) else {
+        return Vec::new();
+    };
+    let mut dirs: Vec<PathBuf> = entries
+        .flatten()
+        .filter(|entry| entry.file_type().map(|kind| kind.is_dir()).unwrap_or(false))
+        .map(|entry| entry.path())
+        .filter(|path| {
+            path.file_name()
+                .and_then(|name| name.to_str())
+                .map(|name| !name.starts_with('.') && name != "node_modules")
+                .unwrap_or(false)
+        })
+        .collect();
+    dirs.sort();
+    dirs
+}
+
+fn wildcard_match(pattern: &str, value: &str) -> bool {
+    let parts: Vec<&str> = pattern.split('*').collect();
+    let (first, last) = (parts[0], parts[parts.len() - 1]);
+    if !value.starts_with(first) || value.len() < first.len() + last.len() {
+        return false;
+    }
+    let mut rest = &value[first.len()..];
+    for part in &parts[1..parts.len() - 1] {
+        match rest.find(part) {
+            Some(index) => rest = &rest[index + part.len()..],
+            None => return false,
+        }
+    }
+    rest.ends_with(last)
+}
+
+fn dir_name(dir: &Path) -> Option<String> {
+    dir.file_name()
+        .and_then(|name| name.to_str())
+        .map(str::to_string)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    fn write(root: &Path, path: &str, contents: &str) {
+        let path = root.join(path);
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(path, contents).unwrap();
+    }
+
+    #[test]
+    fn detects_pnpm_go_and_cargo_packages() {
+        let temp = tempdir().unwrap();
+        let root = temp.path();
+        write(
+            root,
+            "pnpm-workspace.yaml",
+            "packages:\n  - 'apps/*'\n  - '!apps/legacy'\n",
+        );
+        write(root, "apps/web/package.json", "{\"name\": \"@acme/web\"}");
+        write(root, "apps/legacy/package.json", "{\"name\": \"legacy\"}");
+        write(root, "apps/notes/README.md", "not a package");
+        write(
+            root,
+            "go.work",
+            "go 1.22\n\nuse (\n\t./services/api // api\n)\n",
+        );
+        write(root, "services/api/go.mod", "module example.com/acme/api\n");
+        write(
+            root,
+            "Cargo.toml",
+            "[workspace]\nmembers = [\n  \"crates/*\", # all crates\n]\nexclude = [\"crates/scratch\"]\n",
+        );
+        write(
+            root,
+            "crates/core/Cargo.toml",
+            "[package]\nname = \"acme-core\"\nversion = \"0.1.0\"\n",
+        );
+        write(
+            root,
+            "crates/scratch/Cargo.toml",
+            "[package]\nname = \"scratch\"\n",
+        );
+
+        let packages = detect_workspaces(root);
+
+        assert_eq!(
+            packages,
+            vec![
+                WorkspacePackage {
+                    name: "@acme/web".to_string(),
+                    path: "apps/web".to_string(),
+                    source: "pnpm",
+                },
+                WorkspacePackage {
+                    name: "example.com/acme/api".to_string(),
+                    path: "services/api".to_string(),
+                    source: "go",
+                },
+                WorkspacePackage {
+                    name: "acme-core".to_string(),
+                    path: "crates/core".to_string(),
+                    source: "cargo",
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn nx_and_turbo_fall_back_to_conventional_roots() {
+        let temp = tempdir().unwrap();
+        let root = temp.path();
+        write(root, "nx.json", "{}");
+        write(root, "libs/ui/project.json", "{\"name\": \"ui\"}");
+        write(root, "packages/cli/package.json", "{}");
+
+        let packages = detect_workspaces(root);
+
+        let names: Vec<(&str, &str)> = packages
+            .iter()
+            .map(|package| (package.name.as_str(), package.source))
+            .collect();
+        assert_eq!(names, vec![("cli", "nx"), ("ui", "nx")]);
+    }
+
+    #[test]
+    fn npm_workspaces_support_nested_globs() {
+        let temp = tempdir().unwrap();
+        let root = temp.path();
+        write(
+            root,
+            "package.json",
+            "{\"workspaces\": {\"packages\": [\"modules/**\"]}}",
+        );
+        write(root, "modules/a/b/package.json", "{\"name\": \"deep\"}");
+
+        let packages = detect_workspaces(root);
+
+        assert_eq!(packages.len(), 1);
+        assert_eq!(packages[0].path, "modules/a/b");
+        assert_eq!(packages[0].source, "npm");
+    }
+
+    #[test]
+    fn resolve_workspace_matches_name_path_or_directory() {
+        let temp = tempdir().unwrap();
+        let root = temp.path();
+        write(
+            root,
+            "pnpm-workspace.yaml",
+            "packages:\n  - apps/*\n  - tools/*\n",
+        );
+        write(root, "apps/web/package.json", "{\"name\": \"@acme/web\"}");
+        write(root, "apps/api/package.json", "{\"name\": \"@acme/api\"}");
+        write(root, "tools/api/package.json", "{\"name\": \"api-tools\"}");
+
+        assert_eq!(
+            resolve_workspace(root, "@acme/web").unwrap(),
+            root.join("apps/web")
+        );
+        assert_eq!(
+            resolve_workspace(root, "./tools/api/").unwrap(),
+            root.join("tools/api")
+        );
+        assert_eq!(
+            resolve_workspace(root, "web").unwrap(),
+            root.join("apps/web")
+        );
+        assert!(matches!(
+            resolve_workspace(root, "api"),
+            Err(WorkspaceError::Ambiguous { .. })
+        ));
+        let err = resolve_workspace(root, "mobile").unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "Unknown workspace: mobile (available: @acme/api, @acme/web, api-tools)"
+        );
+    }
+
+    #[test]
+    fn resolve_workspace_requires_declared_packages() {
+        let temp = tempdir().unwrap();
+
+        let err = resolve_workspace(temp.path(), "web").unwrap_err();
+
+        assert_eq!(err, WorkspaceError::NoWorkspaces(temp.path().to_path_buf()));
+    }
+}
@@ -0,0 +1,131 @@
+use gralph_rs::api::{
+    Backend, BackendError, CancelToken, Config, LoopOptions, LoopStatus, PrdSeverity, check_prd,
+    parse_prd, run_loop,
+};
+use std::fs;
+use std::path::Path;
+
+/// Checks off the first open task and says COMPLETE once none are left.
+struct CheckOffBackend;
+
+impl Backend for CheckOffBackend {
+    fn name(&self) -> &str {
+        "test"
+    }
+
+    fn check_installed(&self) -> bool {
+        true
+    }
+
+    fn run_iteration(
+        &self,
+        _prompt: &str,
+        _model: Option<&str>,
+        _variant: Option<&str>,
+        output_file: &Path,
+        working_dir: &Path,
+    ) -> Result<(), BackendError> {
+        let prd = working_dir.join("PRD.md");
+        let contents = fs::read_to_string(&prd).unwrap();
+        let contents = contents.replacen("- [ ]", "- [x]", 1);
+        fs::write(&prd, &contents).unwrap();
+        let reply = if contents.contains("- [ ]") {
+            "Checked off a task."
+        } else {
+            "<promise>COMPLETE</promise>"
+        };
+        fs::write(output_file, reply).unwrap();
+        Ok(())
+    }
+
+    fn parse_text(&self, response_file: &Path) -> Result<String, BackendError> {
+        Ok(fs::read_to_string(response_file).unwrap())
+    }
+
+    fn get_models(&self) -> Vec<String> {
+        Vec::new()
+    }
+}
+
+fn options() -> LoopOptions {
+    LoopOptions {
+        task_file: Some("PRD.md".to_string()),
+        max_iterations: Some(5),
+        session_name: Some("embedded".to_string()),
+        ..LoopOptions::default()
+    }
+}
+
+#[test]
+fn run_loop_completes_with_an_explicit_config() {
+    let temp = tempfile::tempdir().unwrap();
+    fs::write(temp.path().join("PRD.md"), "- [ ] First\n- [ ] Second\n").unwrap();
+    let config = Config::from_yaml("defaults:\n  max_iterations: 1\n").unwrap();
+    let mut updates = Vec::new();
+    let mut on_status = |iteration: u32, status: LoopStatus, remaining: usize| {
+        updates.push((iteration, status, remaining));
+    };
+
+    let outcome = run_loop(
+        &CheckOffBackend,
+        temp.path(),
+        Some(&config),
+        &options(),
+        &CancelToken::new(),
+        Some(&mut on_status),
+    )
+    .unwrap();
+
+    assert_eq!(outcome.status, LoopStatus::Complete);
+    assert_eq!(outcome.iterations, 2);
+    assert_eq!(outcome.remaining_tasks, 0);
+    assert_eq!(updates.last(), Some(&(2, LoopStatus::Complete, 0)));
+}
+
+#[test]
+fn run_loop_stops_when_cancelled() {
+    let temp = tempfile::tempdir().unwrap();
+    fs::write(temp.path().join("PRD.md"), "- [ ] First\n").unwrap();
+    let config = Config::from_yaml("").unwrap();
+    let cancel = CancelToken::new();
+    cancel.cancel();
+
+    let outcome = run_loop(
+        &CheckOffBackend,
+        temp.path(),
+        Some(&config),
+        &options(),
+        &cancel,
+        None,
+    )
+    .unwrap();
+
+    assert_eq!(outcome.status, LoopStatus::Stopped);
+    assert_eq!(outcome.iterations, 0);
+    assert_eq!(outcome.remaining_tasks, 1);
+    let log = fs::read_to_string(temp.path().join(".gralph/embedded.log")).unwrap();
+    assert!(log.contains("Stopped by cancellation after 0 iterations."));
+}
+
+#[test]
+fn parse_and_check_prd_read_the_task_file() {
+    let temp = tempfile::tempdir().unwrap();
+    let path = temp.path().join("PRD.md");
+    fs::write(
+        &path,
+        "# PRD\n\n## Tasks\n\n### Task A-1\n- **ID** A-1\n- **Context Bundle** `missing.rs`\n- **DoD** Done\n- **Checklist**\n  * Do it\n- **Dependencies** None\n- [ ] A-1 Do it\n",
+    )
+    .unwrap();
+
+    let document = parse_prd(&path).unwrap();
+    assert_eq!(document.tasks.len(), 1);
+    assert_eq!(document.tasks[0].id, "A-1");
+
+    let issues = check_prd(&path);
+    assert!(
+        issues
+            .iter()
+            .any(|issue| issue.severity == PrdSeverity::Error
+                && issue.message.contains("missing.rs"))
+    );
+}
@@ -59,6 +59,30 @@ fn claude_run_iteration_orders_args_and_places_model_flag() {
     );
 }
 
+#[cfg(unix)]
+#[test]
+fn claude_run_iteration_runs_in_the_working_dir() {
+    let temp = tempfile::tempdir().unwrap();
+    let project = temp.path().join("project");
+    fs::create_dir(&project).unwrap();
+    let output_path = temp.path().join("claude.pwd");
+    let script = r#"#!/bin/sh
+echo "{\"type\":\"result\",\"result\":\"$(pwd -P)\"}"
+"#;
+    let fake = support::FakeCli::new_script("claude", script).unwrap();
+    let _guard = fake.prepend_to_path().unwrap();
+    let cwd = std::env::current_dir().unwrap();
+
+    let backend = ClaudeBackend::with_command(fake.command());
+    backend
+        .run_iteration("prompt", None, None, &output_path, &project)
+        .unwrap();
+
+    let parsed = backend.parse_text(&output_path).unwrap();
+    assert_eq!(parsed, project.canonicalize().unwrap().to_string_lossy());
+    assert_eq!(std::env::current_dir().unwrap(), cwd);
+}
+
 #[test]
 fn claude_parse_text_falls_back_to_raw_when_no_result_entries() {
     let temp = tempfile::tempdir().unwrap();
@@ -86,6 +110,21 @@ fn claude_run_iteration_reports_failure_exit() {
     );
 }
 
+#[test]
+fn claude_check_auth_reports_login_errors() {
+    let fake = support::FakeCli::new("claude", "OK", "", 0).unwrap();
+    let _guard = fake.prepend_to_path().unwrap();
+    let backend = ClaudeBackend::with_command(fake.command());
+    assert!(backend.check_auth(None).is_ok());
+
+    let fake = support::FakeCli::new("claude", "", "Invalid API key", 1).unwrap();
+    let _guard = fake.prepend_to_path().unwrap();
+    let backend = ClaudeBackend::with_command(fake.command());
+    let err = backend.check_auth(Some("test-model")).unwrap_err();
+    assert!(err.contains("claude exited with"), "{}", err);
+    assert!(err.contains("Invalid API key"), "{}", err);
+}
+
 fn render_claude_script() -> String {
     if cfg!(windows) {
         "@echo off\r\necho {\"type\":\"assistant\",\"message\":{\"content\":[{\"type\":\"text\",\"text\":\"args:%* env:%IS_SANDBOX%\"}]}}\r\necho {\"type\":\"result\",\"result\":\"done\"}\r\nexit /b 0\r\n".to_string()
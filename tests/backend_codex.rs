@@ -32,7 +32,7 @@ fn codex_run_iteration_writes_output_and_args() {
         .unwrap();
 
     let output = fs::read_to_string(&output_path).unwrap();
-    assert!(output.contains("args:--quiet --auto-approve --model test-model prompt"));
+    assert!(output.contains("args:--quiet --auto-approve --json --model test-model prompt"));
     assert!(output.contains("env:ok"));
 
     let parsed = backend.parse_text(&output_path).unwrap();